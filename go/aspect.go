@@ -0,0 +1,94 @@
+package viewer
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Aspect ratio. A node with an aspectRatio prop (width over height; a
+// number, or a string like "16/9" or "16:9") keeps that shape. If it sets
+// its width or height the other is derived from it; if it sets neither it
+// takes the largest size of its ratio that fits the space it is given, as
+// an image is contained. A derived side is kept within the node's min
+// and max props for it, and the other side follows when that changes it.
+
+// aspectRatio returns a node's width to height ratio, if it has one.
+func aspectRatio(node *RenderNode) (float64, bool) {
+	p := node.Props
+	if p.AspectRatio == nil || *p.AspectRatio <= 0 {
+		return 0, false
+	}
+	return *p.AspectRatio, true
+}
+
+// parseAspectRatio reads an aspectRatio prop.
+func parseAspectRatio(v interface{}) (float64, bool) {
+	if f, ok := toFloat(v); ok {
+		return f, true
+	}
+	s, ok := v.(string)
+	if !ok {
+		return 0, false
+	}
+	w, h, found := strings.Cut(s, "/")
+	if !found {
+		w, h, found = strings.Cut(s, ":")
+	}
+	num, err := strconv.ParseFloat(strings.TrimSpace(w), 64)
+	if err != nil {
+		return 0, false
+	}
+	if !found {
+		return num, true
+	}
+	den, err := strconv.ParseFloat(strings.TrimSpace(h), 64)
+	if err != nil || den == 0 {
+		return 0, false
+	}
+	return num / den, true
+}
+
+// clampSize limits a size to a min and max prop.
+func clampSize(v float64, lo, hi *int) float64 {
+	if hi != nil {
+		v = min(v, float64(*hi))
+	}
+	if lo != nil {
+		v = max(v, float64(*lo))
+	}
+	return v
+}
+
+// fitAspect returns the size a node with ratio takes in w × h: hasW and
+// hasH tell which sides it sets itself.
+func fitAspect(p NodeProps, ratio, w, h float64, hasW, hasH bool) (float64, float64) {
+	switch {
+	case hasW && hasH:
+		return w, h
+	case hasW:
+		h = w / ratio
+	case hasH:
+		w = h * ratio
+	case w/ratio <= h:
+		h = w / ratio
+	default:
+		w = h * ratio
+	}
+	if !hasH {
+		if c := clampSize(h, p.MinHeight, p.MaxHeight); c != h {
+			h = c
+			if !hasW {
+				w = h * ratio
+			}
+		}
+	}
+	if !hasW {
+		if c := clampSize(w, p.MinWidth, p.MaxWidth); c != w {
+			w = c
+			if !hasH {
+				h = w / ratio
+			}
+		}
+	}
+	return w, h
+}
@@ -0,0 +1,79 @@
+package viewer
+
+import "math"
+
+// Baseline alignment. A row box with align "baseline" lines its children
+// up on the baseline of their first line of text, so text of different
+// sizes reads as one line, as in CSS. A text node's baseline is its first
+// line's, scaled with its font size; a one-line control's is that of its
+// label; a box's is its first flow child's, or in a baseline row, the
+// lowest of its children's. A child without text sits with the bottom of
+// its margin box on the baseline. Children take their own height rather
+// than stretching. In a column, baseline is start.
+
+// AlignBaseline is the align of a row that lines its children up on
+// their text baselines.
+const AlignBaseline = "baseline"
+
+// textBaseline returns the distance from the top of a line of a text
+// node's text to its baseline.
+func (l *layoutEngine) textBaseline(p NodeProps) float64 {
+	if l.opts.Round || p.Size == nil || *p.Size <= 0 {
+		return l.opts.Baseline
+	}
+	return math.Round(l.opts.Baseline * float64(*p.Size) / defaultFontSize)
+}
+
+// baseline returns the distance from the top of a node's box to the
+// baseline of its first line of text, if it has one.
+func (l *layoutEngine) baseline(node *RenderNode) (float64, bool) {
+	switch node.Type {
+	case NodeText:
+		return l.textBaseline(node.Props), true
+	case NodeCheckbox, NodeRadio, NodeSelect, NodeButton, NodeSpinner, NodeProgress:
+		return l.opts.Baseline, true
+	case NodeInput:
+		return l.opts.Baseline, !isMultiline(node)
+	case NodeBox, NodeScroll:
+		if node.Props.Direction == DirectionGrid {
+			return 0, false
+		}
+		children := flowChildren(node)
+		if node.Props.Direction != "row" || node.Props.Align != AlignBaseline {
+			children = children[:min(len(children), 1)]
+		}
+		lowest, found := 0.0, false
+		for _, child := range children {
+			if b, ok := l.baseline(child); ok {
+				lowest, found = math.Max(lowest, l.resolveSpacing(child.Props.Margin).top+b), true
+			}
+		}
+		top := l.resolveSpacing(node.Props.Padding).top + l.borderWidth(node)
+		return top + lowest, found
+	}
+	return 0, false
+}
+
+// alignBaselines sizes a baseline row's children to their own heights
+// and returns how far below the top of the row's content box each child's
+// margin box starts, so that their baselines line up.
+func (l *layoutEngine) alignBaselines(infos []*childInfo, contentH float64) []float64 {
+	below := make([]float64, len(infos))
+	lowest := 0.0
+	for i, info := range infos {
+		if !info.hasFixedCross {
+			h := l.measure(info.child, false, info.allocatedMain+info.mainMargin, contentH)
+			info.allocatedCross = constrain(info.child, false, h)
+		}
+		b, ok := l.baseline(info.child)
+		if !ok {
+			b = info.allocatedCross + info.margin.bottom
+		}
+		below[i] = info.margin.top + b
+		lowest = math.Max(lowest, below[i])
+	}
+	for i := range below {
+		below[i] = lowest - below[i]
+	}
+	return below
+}
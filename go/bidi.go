@@ -0,0 +1,374 @@
+package viewer
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Bidirectional text — visual reordering of mixed left-to-right and
+// right-to-left text for targets that place characters themselves (the
+// cell grid and the rasterizer). HTML leaves this to the browser and the
+// text projection keeps logical order.
+//
+// This implements the implicit part of the Unicode Bidirectional
+// Algorithm (UAX #9) for a single line: character types, the weak rules
+// for numbers and their separators, bracket pairs and other neutrals,
+// implicit levels, reordering, and mirroring of paired brackets. Explicit
+// embedding and isolate controls are treated as neutral. Reordering works
+// on grapheme clusters, so combining marks stay on their base character.
+
+// bidiClass is a simplified bidi character type.
+type bidiClass uint8
+
+const (
+	bidiL  bidiClass = iota // strong left-to-right
+	bidiR                   // strong right-to-left (Hebrew, Arabic, ...)
+	bidiEN                  // European number
+	bidiAN                  // Arabic number
+	bidiES                  // number separator: + -
+	bidiCS                  // common number separator: , . : /
+	bidiET                  // number terminator: % $ # ...
+	bidiWS                  // whitespace
+	bidiON                  // other neutral
+)
+
+// TextDirection values for the textDirection prop.
+const (
+	DirLTR  = "ltr"
+	DirRTL  = "rtl"
+	DirAuto = "auto"
+)
+
+// BaseDirection returns DirRTL or DirLTR for the first strongly
+// directional character of s, or "" if it has none.
+func BaseDirection(s string) string {
+	for _, r := range s {
+		switch classify(r) {
+		case bidiL:
+			return DirLTR
+		case bidiR:
+			return DirRTL
+		}
+	}
+	return ""
+}
+
+// resolveRTL reports whether text in a node with the resolved text
+// direction dir is a right-to-left paragraph.
+func resolveRTL(dir, text string) bool {
+	switch dir {
+	case DirRTL:
+		return true
+	case DirAuto:
+		return BaseDirection(text) == DirRTL
+	}
+	return false
+}
+
+// inheritDir returns a node's text direction given its prop and the
+// direction inherited from its parent.
+func inheritDir(prop, inherited string) string {
+	if prop == DirLTR || prop == DirRTL || prop == DirAuto {
+		return prop
+	}
+	return inherited
+}
+
+// alignOffset returns the column at which a line of lineWidth cells
+// starts within a box of width cells, for a textAlign value in a
+// paragraph of the given direction. "start" and "end", and the default,
+// follow the direction.
+func alignOffset(align string, rtl bool, width, lineWidth int) int {
+	switch align {
+	case "start", "":
+		if rtl {
+			align = "right"
+		}
+	case "end":
+		if !rtl {
+			align = "right"
+		}
+	}
+	switch align {
+	case "right":
+		return max(0, width-lineWidth)
+	case "center":
+		return max(0, (width-lineWidth)/2)
+	}
+	return 0
+}
+
+// BidiReorder returns one line of text in visual (left-to-right display)
+// order. rtl selects the paragraph direction; use BaseDirection to detect
+// it. Brackets in right-to-left runs are mirrored.
+func BidiReorder(line string, rtl bool) string {
+	clusters := Graphemes(line)
+	if !hasRTL(clusters) && !rtl {
+		return line
+	}
+	levels := bidiLevels(clusters, rtl)
+
+	// L2: from the highest level down to the lowest odd level, reverse
+	// every run at that level or above.
+	high, lowOdd := 0, 1<<7
+	for _, l := range levels {
+		high = max(high, l)
+		if l%2 == 1 {
+			lowOdd = min(lowOdd, l)
+		}
+	}
+	order := make([]int, len(clusters))
+	for i := range order {
+		order[i] = i
+	}
+	for lvl := high; lvl >= lowOdd; lvl-- {
+		for i := 0; i < len(order); {
+			if levels[order[i]] < lvl {
+				i++
+				continue
+			}
+			j := i
+			for j < len(order) && levels[order[j]] >= lvl {
+				j++
+			}
+			for a, b := i, j-1; a < b; a, b = a+1, b-1 {
+				order[a], order[b] = order[b], order[a]
+			}
+			i = j
+		}
+	}
+
+	var sb strings.Builder
+	for _, i := range order {
+		c := clusters[i]
+		if levels[i]%2 == 1 {
+			if m, ok := mirrored[c]; ok {
+				c = m
+			}
+		}
+		sb.WriteString(c)
+	}
+	return sb.String()
+}
+
+// hasRTL reports whether any cluster is right-to-left or an Arabic number.
+func hasRTL(clusters []string) bool {
+	for _, c := range clusters {
+		if t := clusterClass(c); t == bidiR || t == bidiAN {
+			return true
+		}
+	}
+	return false
+}
+
+// bidiLevels resolves the embedding level of each cluster.
+func bidiLevels(clusters []string, rtl bool) []int {
+	n := len(clusters)
+	types := make([]bidiClass, n)
+	for i, c := range clusters {
+		types[i] = clusterClass(c)
+	}
+	sos := bidiL
+	para := 0
+	if rtl {
+		sos, para = bidiR, 1
+	}
+
+	// W4: a single separator between two numbers of the same kind joins
+	// them.
+	for i := 1; i < n-1; i++ {
+		switch {
+		case types[i] == bidiES && types[i-1] == bidiEN && types[i+1] == bidiEN:
+			types[i] = bidiEN
+		case types[i] == bidiCS && types[i-1] == types[i+1] && (types[i-1] == bidiEN || types[i-1] == bidiAN):
+			types[i] = types[i-1]
+		}
+	}
+	// W5: terminators next to European numbers become numbers.
+	for i := 0; i < n; i++ {
+		if types[i] != bidiET {
+			continue
+		}
+		j := i
+		for j < n && types[j] == bidiET {
+			j++
+		}
+		if (i > 0 && types[i-1] == bidiEN) || (j < n && types[j] == bidiEN) {
+			for k := i; k < j; k++ {
+				types[k] = bidiEN
+			}
+		}
+		i = j
+	}
+	// W6: remaining separators and terminators are neutral. W7: European
+	// numbers in a left-to-right context are left-to-right.
+	prevStrong := sos
+	for i, t := range types {
+		switch t {
+		case bidiES, bidiCS, bidiET:
+			types[i] = bidiON
+		case bidiL, bidiR:
+			prevStrong = t
+		case bidiEN:
+			if prevStrong == bidiL {
+				types[i] = bidiL
+			}
+		}
+	}
+	strong := func(t bidiClass) bidiClass {
+		if t == bidiEN || t == bidiAN {
+			return bidiR
+		}
+		return t
+	}
+	// N0: a bracket pair takes the paragraph direction if its content has
+	// text in that direction, else the opposite direction if its content
+	// and the text before it have that.
+	for _, pair := range bracketPairs(clusters) {
+		inside := bidiON
+		for k := pair[0] + 1; k < pair[1]; k++ {
+			if t := strong(types[k]); t == sos {
+				inside = sos
+				break
+			} else if t == bidiL || t == bidiR {
+				inside = t
+			}
+		}
+		if inside == bidiON {
+			continue
+		}
+		if inside != sos {
+			context := sos
+			for k := pair[0] - 1; k >= 0; k-- {
+				if t := strong(types[k]); t == bidiL || t == bidiR {
+					context = t
+					break
+				}
+			}
+			if context != inside {
+				inside = sos
+			}
+		}
+		types[pair[0]], types[pair[1]] = inside, inside
+	}
+	// N1/N2: a run of neutrals takes the direction of the text around it
+	// if both sides agree (numbers count as right-to-left), otherwise the
+	// paragraph direction.
+	for i := 0; i < n; i++ {
+		if types[i] != bidiWS && types[i] != bidiON {
+			continue
+		}
+		j := i
+		for j < n && (types[j] == bidiWS || types[j] == bidiON) {
+			j++
+		}
+		before, after := sos, sos
+		if i > 0 {
+			before = strong(types[i-1])
+		}
+		if j < n {
+			after = strong(types[j])
+		}
+		dir := sos
+		if before == after {
+			dir = before
+		}
+		for k := i; k < j; k++ {
+			types[k] = dir
+		}
+		i = j
+	}
+
+	// I1/I2: implicit levels.
+	levels := make([]int, n)
+	for i, t := range types {
+		switch {
+		case para == 0 && t == bidiR:
+			levels[i] = 1
+		case para == 0 && (t == bidiEN || t == bidiAN):
+			levels[i] = 2
+		case para == 1 && t != bidiR:
+			levels[i] = 2
+		default:
+			levels[i] = para
+		}
+	}
+	// L1: trailing whitespace goes back to the paragraph level.
+	for i := n - 1; i >= 0 && clusterClass(clusters[i]) == bidiWS; i-- {
+		levels[i] = para
+	}
+	return levels
+}
+
+// bracketPairs returns the indices of matching opening and closing
+// brackets, in order of the opening bracket.
+func bracketPairs(clusters []string) [][2]int {
+	type open struct {
+		i     int
+		close string
+	}
+	var stack []open
+	var pairs [][2]int
+	for i, c := range clusters {
+		switch c {
+		case "(", "[", "{":
+			stack = append(stack, open{i, mirrored[c]})
+			continue
+		case ")", "]", "}":
+		default:
+			continue
+		}
+		for k := len(stack) - 1; k >= 0; k-- {
+			if stack[k].close == c {
+				pairs = append(pairs, [2]int{stack[k].i, i})
+				stack = stack[:k]
+				break
+			}
+		}
+	}
+	sort.Slice(pairs, func(a, b int) bool { return pairs[a][0] < pairs[b][0] })
+	return pairs
+}
+
+// clusterClass is the bidi type of a cluster's base character.
+func clusterClass(c string) bidiClass {
+	for _, r := range c {
+		return classify(r)
+	}
+	return bidiON
+}
+
+// classify returns the simplified bidi type of r.
+func classify(r rune) bidiClass {
+	switch {
+	case r >= '0' && r <= '9', r >= 0x6f0 && r <= 0x6f9:
+		return bidiEN
+	case r >= 0x660 && r <= 0x669, r == 0x66b, r == 0x66c:
+		return bidiAN
+	case r == '+' || r == '-':
+		return bidiES
+	case r == ',' || r == '.' || r == ':' || r == '/' || r == 0xa0:
+		return bidiCS
+	case strings.ContainsRune("#$%°¢£¤¥€‰", r):
+		return bidiET
+	case r == ' ' || r == '\t' || unicode.Is(unicode.Zs, r):
+		return bidiWS
+	case r >= 0x590 && r <= 0x8ff, r >= 0xfb1d && r <= 0xfdff, r >= 0xfe70 && r <= 0xfeff,
+		r >= 0x10800 && r <= 0x10fff, r >= 0x1e800 && r <= 0x1efff:
+		if unicode.IsLetter(r) {
+			return bidiR
+		}
+		return bidiON
+	case unicode.IsLetter(r) || unicode.IsDigit(r):
+		return bidiL
+	}
+	return bidiON
+}
+
+// mirrored maps paired punctuation to its mirror image, for display in
+// right-to-left runs.
+var mirrored = map[string]string{
+	"(": ")", ")": "(", "[": "]", "]": "[", "{": "}", "}": "{",
+	"<": ">", ">": "<", "«": "»", "»": "«", "‹": "›", "›": "‹",
+}
@@ -0,0 +1,161 @@
+package viewer
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// Box borders. Layout insets a bordered box's content by the border
+// width, and the cell grid draws the border with box-drawing characters
+// in the cells it reserved — always one cell thick. The characters follow
+// the border style: light lines for solid, dashed, and dotted borders,
+// with rounded corners if borderRadius is set; heavy lines for borders
+// two or more pixels wide; and double lines for the double style. Only
+// boxes and scroll containers draw borders.
+//
+// The rasterizer rounds corners by borderRadius on any node: the node and
+// its children are clipped to an anti-aliased rounded rectangle and a
+// border becomes a ring following it.
+
+// BorderStyle values.
+const (
+	BorderSolid  = "solid"
+	BorderDashed = "dashed"
+	BorderDotted = "dotted"
+	BorderDouble = "double"
+	BorderNone   = "none"
+)
+
+// borderChars are the characters for the edges and corners of a border.
+type borderChars struct {
+	h, v           rune
+	tl, tr, bl, br rune
+}
+
+var (
+	borderLight   = borderChars{'─', '│', '┌', '┐', '└', '┘'}
+	borderRounded = borderChars{'─', '│', '╭', '╮', '╰', '╯'}
+	borderHeavy   = borderChars{'━', '┃', '┏', '┓', '┗', '┛'}
+	borderDoubled = borderChars{'═', '║', '╔', '╗', '╚', '╝'}
+)
+
+// hasBorder reports whether a border prop draws anything.
+func hasBorder(b *BorderStyle) bool {
+	return b != nil && b.Width > 0 && b.Style != BorderNone
+}
+
+// borderWidth returns the space a node's border takes on each side, in
+// layout units: the scaled border width, rounded up to a whole cell on
+// cell targets.
+func (l *layoutEngine) borderWidth(node *RenderNode) float64 {
+	b := node.Props.Border
+	if !hasBorder(b) || (node.Type != NodeBox && node.Type != NodeScroll) {
+		return 0
+	}
+	k := l.opts.SpacingScale
+	if k == 0 {
+		k = 1
+	}
+	w := float64(b.Width) * k
+	if l.opts.Round {
+		w = math.Ceil(w)
+	}
+	return w
+}
+
+// glyphs returns the characters that draw border b.
+func (b *BorderStyle) glyphs(radius *int) borderChars {
+	if b.Style == BorderDouble {
+		return borderDoubled
+	}
+	c := borderLight
+	if b.Width >= 2 {
+		c = borderHeavy
+	} else if radius != nil && *radius > 0 {
+		c = borderRounded
+	}
+	switch b.Style {
+	case BorderDashed:
+		c.h, c.v = '┄', '┆'
+		if b.Width >= 2 {
+			c.h, c.v = '┅', '┇'
+		}
+	case BorderDotted:
+		c.h, c.v = '┈', '┊'
+		if b.Width >= 2 {
+			c.h, c.v = '┉', '┋'
+		}
+	}
+	return c
+}
+
+// border draws a node's border around the edge of r, colored with the
+// resolved border color over the node's background, and returns the clip
+// for the node's children, which stay inside it.
+func (d *gridDrawer) border(node *RenderNode, r rect, style CellStyle, clip rect) rect {
+	b := node.Props.Border
+	if !hasBorder(b) {
+		return clip
+	}
+	inner := clip.intersect(rect{r.x + 1, r.y + 1, r.w - 2, r.h - 2})
+	if r.w < 2 || r.h < 2 {
+		return inner
+	}
+	style = CellStyle{FG: style.FG, BG: style.BG}
+	if c := d.color(b.Color); c != "" {
+		style.FG = c
+	}
+	c := b.glyphs(node.Props.BorderRadius)
+	x1, y1 := r.x+r.w-1, r.y+r.h-1
+	for x := r.x + 1; x < x1; x++ {
+		d.set(x, r.y, c.h, style, clip)
+		d.set(x, y1, c.h, style, clip)
+	}
+	for y := r.y + 1; y < y1; y++ {
+		d.set(r.x, y, c.v, style, clip)
+		d.set(x1, y, c.v, style, clip)
+	}
+	d.set(r.x, r.y, c.tl, style, clip)
+	d.set(x1, r.y, c.tr, style, clip)
+	d.set(r.x, y1, c.bl, style, clip)
+	d.set(x1, y1, c.br, style, clip)
+	return inner
+}
+
+// roundedMask returns the anti-aliased coverage over area of the
+// rectangle r with corners of radius rad, or of a ring width pixels wide
+// along its edge if width > 0.
+func roundedMask(r image.Rectangle, rad, width int, area image.Rectangle) *image.Alpha {
+	mask := image.NewAlpha(area)
+	inner := r.Inset(width)
+	for y := area.Min.Y; y < area.Max.Y; y++ {
+		for x := area.Min.X; x < area.Max.X; x++ {
+			a := roundedCoverage(x, y, r, rad)
+			if width > 0 {
+				a *= 1 - roundedCoverage(x, y, inner, max(rad-width, 0))
+			}
+			mask.SetAlpha(x, y, color.Alpha{uint8(a*255 + 0.5)})
+		}
+	}
+	return mask
+}
+
+// roundedCoverage returns how much of pixel (x, y) lies inside r with
+// corners of radius rad.
+func roundedCoverage(x, y int, r image.Rectangle, rad int) float64 {
+	if !image.Pt(x, y).In(r) {
+		return 0
+	}
+	if rad <= 0 {
+		return 1
+	}
+	// Distance from the pixel center to the nearest point of the
+	// rectangle inset by the radius, which is zero along the straight
+	// edges.
+	k := float64(rad)
+	px, py := float64(x)+0.5, float64(y)+0.5
+	cx := min(max(px, float64(r.Min.X)+k), float64(r.Max.X)-k)
+	cy := min(max(py, float64(r.Min.Y)+k), float64(r.Max.Y)-k)
+	return min(max(k-math.Hypot(px-cx, py-cy)+0.5, 0), 1)
+}
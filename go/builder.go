@@ -0,0 +1,838 @@
+package viewer
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Declarative VNode construction:
+//
+//	root, err := Box(Dir("row"), Gap(2),
+//		Text("Hello").Weight("bold"),
+//		Input().Placeholder("name"),
+//	).Build()
+//
+// Container constructors take a mix of Options and child Elements. Leaf
+// properties are set with chained methods. Build validates the tree and
+// assigns IDs to every node without an explicit one.
+
+// Item is an argument to a container constructor: an Option or a child
+// Element.
+type Item interface {
+	applyTo(e *Element)
+}
+
+// Option sets properties on the element it is passed to.
+type Option func(e *Element)
+
+func (o Option) applyTo(e *Element) { o(e) }
+
+// Element is a node under construction.
+type Element struct {
+	node     VNode
+	children []*Element
+	idSet    bool
+	key      string
+	errs     []error
+}
+
+func (e *Element) applyTo(parent *Element) {
+	parent.children = append(parent.children, e)
+}
+
+// ErrInvalidTree wraps every error returned by Build.
+var ErrInvalidTree = errors.New("invalid tree")
+
+func newElement(t NodeType, items []Item) *Element {
+	e := &Element{node: VNode{Type: t}}
+	for _, item := range items {
+		if item != nil {
+			item.applyTo(e)
+		}
+	}
+	return e
+}
+
+// Box returns a flex container.
+func Box(items ...Item) *Element { return newElement(NodeBox, items) }
+
+// Row returns a box laid out horizontally.
+func Row(items ...Item) *Element { return Box(append([]Item{Dir("row")}, items...)...) }
+
+// Column returns a box laid out vertically.
+func Column(items ...Item) *Element { return Box(append([]Item{Dir("column")}, items...)...) }
+
+// GridBox returns a box laid out in a grid with the given columns
+// template, such as "20 1fr 2fr"; see Element.Rows and Element.Span.
+func GridBox(columns string, items ...Item) *Element {
+	e := Box(append([]Item{Dir(DirectionGrid)}, items...)...)
+	e.node.Props.Columns = columns
+	return e
+}
+
+// Show returns child, hidden unless cond holds. The child stays in the
+// tree either way, so a component whose content comes and goes renders
+// it with Show rather than leaving it out: the diff against the last
+// render is then a one-prop visibility patch instead of a removal and
+// reinsertion of the whole subtree, and the viewer keeps its state.
+func Show(cond bool, child *Element) *Element {
+	if !cond {
+		child.Hidden()
+	}
+	return child
+}
+
+// Scroll returns a scrollable container.
+func Scroll(items ...Item) *Element { return newElement(NodeScroll, items) }
+
+// Text returns a text node.
+func Text(content string) *Element {
+	e := newElement(NodeText, nil)
+	e.node.Props.Content = &content
+	return e
+}
+
+// Input returns a text input.
+func Input() *Element { return newElement(NodeInput, nil) }
+
+// Checkbox returns a checkbox with a label.
+func Checkbox(label string) *Element {
+	e := newElement(NodeCheckbox, nil)
+	e.node.Props.Label = &label
+	return e
+}
+
+// Radio returns a radio button with a label and the value it stands for.
+// Radios sharing a parent form a group.
+func Radio(label, value string) *Element {
+	e := newElement(NodeRadio, nil)
+	e.node.Props.Label = &label
+	e.node.Props.Value = &value
+	return e
+}
+
+// Select returns a select offering options.
+func Select(options ...string) *Element {
+	e := newElement(NodeSelect, nil)
+	e.node.Props.Options = options
+	return e
+}
+
+// Button returns a button with a label.
+func Button(label string) *Element {
+	e := newElement(NodeButton, nil)
+	e.node.Props.Label = &label
+	return e
+}
+
+// Progress returns a progress bar value of max of the way full.
+func Progress(value, max float64) *Element {
+	e := newElement(NodeProgress, nil)
+	e.node.Props.Progress = &value
+	e.node.Props.Max = &max
+	return e
+}
+
+// IndeterminateProgress returns a progress bar for a task of unknown
+// length.
+func IndeterminateProgress() *Element { return newElement(NodeProgress, nil) }
+
+// Spinner returns a spinner with a label.
+func Spinner(label string) *Element {
+	e := newElement(NodeSpinner, nil)
+	e.node.Props.Label = &label
+	return e
+}
+
+// Table returns a table of the data rows of a schema slot.
+func Table(schema int) *Element {
+	e := newElement(NodeTable, nil)
+	e.node.Props.Schema = &schema
+	return e
+}
+
+// Separator returns a horizontal rule.
+func Separator() *Element { return newElement(NodeSeparator, nil) }
+
+// Image returns an image node with encoded data ("png", "jpeg", ...).
+func Image(data []byte, format string) *Element {
+	e := newElement(NodeImage, nil)
+	e.node.Props.Data = data
+	e.node.Props.Format = format
+	return e
+}
+
+// Canvas returns a canvas node in the given mode.
+func Canvas(mode string) *Element {
+	e := newElement(NodeCanvas, nil)
+	e.node.Props.Mode = mode
+	return e
+}
+
+// ── Options ──────────────────────────────────────────────────────────
+
+// ID sets an explicit node ID.
+func ID(id int) Option { return func(e *Element) { e.ID(id) } }
+
+// Dir sets a container's direction: "row", "column", or "grid".
+func Dir(direction string) Option {
+	return func(e *Element) {
+		e.node.Props.Direction = e.check("direction", direction, "row", "column", DirectionGrid)
+	}
+}
+
+// Gap sets the spacing between a container's children.
+func Gap(gap int) Option {
+	return func(e *Element) {
+		if gap < 0 {
+			e.fail("gap must not be negative, got %d", gap)
+		}
+		e.node.Props.Gap = &gap
+	}
+}
+
+// Justify sets main-axis distribution.
+func Justify(justify string) Option {
+	return func(e *Element) {
+		e.node.Props.Justify = e.check("justify", justify, "start", "end", "center", "between", "around", "evenly")
+	}
+}
+
+// Align sets cross-axis alignment.
+func Align(align string) Option {
+	return func(e *Element) {
+		e.node.Props.Align = e.check("align", align, "start", "end", "center", "stretch", "baseline")
+	}
+}
+
+// Padding sets padding: one value, or [vertical, horizontal], or
+// [top, right, bottom, left].
+func Padding(values ...int) Option { return func(e *Element) { e.Padding(values...) } }
+
+// Margin sets margin, with the same forms as Padding.
+func Margin(values ...int) Option { return func(e *Element) { e.Margin(values...) } }
+
+// Width sets a fixed width.
+func Width(w int) Option { return func(e *Element) { e.Width(w) } }
+
+// Height sets a fixed height.
+func Height(h int) Option { return func(e *Element) { e.Height(h) } }
+
+// Flex sets the flex grow factor.
+func Flex(grow float64) Option { return func(e *Element) { e.Flex(grow) } }
+
+// Background sets the background color (string or slot reference).
+func Background(color interface{}) Option { return func(e *Element) { e.Background(color) } }
+
+// Border sets a container's border; see Element.Border.
+func Border(width int, style, color string) Option {
+	return func(e *Element) { e.Border(width, style, color) }
+}
+
+// Clickable marks the element as clickable.
+func Clickable() Option { return func(e *Element) { e.Clickable() } }
+
+// ── Chained setters ──────────────────────────────────────────────────
+
+// ID sets an explicit node ID.
+func (e *Element) ID(id int) *Element {
+	if id <= 0 {
+		e.fail("id must be positive, got %d", id)
+	}
+	e.node.ID = id
+	e.idSet = true
+	return e
+}
+
+// Key names the element among its siblings for BuildWith, which derives
+// a stable ID from the keys (or positions) on the path from the root.
+// Build ignores keys.
+func (e *Element) Key(key string) *Element {
+	if key == "" {
+		e.fail("key must not be empty")
+	}
+	e.key = key
+	return e
+}
+
+// Weight sets the font weight: "normal", "bold", or "light".
+func (e *Element) Weight(weight string) *Element {
+	e.node.Props.Weight = e.check("weight", weight, "normal", "bold", "light")
+	return e
+}
+
+// Italic sets italic text.
+func (e *Element) Italic() *Element {
+	italic := true
+	e.node.Props.Italic = &italic
+	return e
+}
+
+// Decoration sets "none", "underline", or "strikethrough".
+func (e *Element) Decoration(decoration string) *Element {
+	e.node.Props.Decoration = e.check("decoration", decoration, "none", "underline", "strikethrough")
+	return e
+}
+
+// TextAlign sets "left", "center", "right", or "start" or "end", which
+// follow the text direction.
+func (e *Element) TextAlign(align string) *Element {
+	e.node.Props.TextAlign = e.check("textAlign", align, "left", "center", "right", "start", "end")
+	return e
+}
+
+// TextOverflow sets "clip", "wrap", or "ellipsis".
+func (e *Element) TextOverflow(mode string) *Element {
+	e.node.Props.TextOverflow = e.check("textOverflow", mode, OverflowClip, OverflowWrap, OverflowEllipsis)
+	return e
+}
+
+// TextDirection sets "ltr", "rtl", or "auto". Descendants inherit it.
+func (e *Element) TextDirection(dir string) *Element {
+	e.node.Props.TextDirection = e.check("textDirection", dir, DirLTR, DirRTL, DirAuto)
+	return e
+}
+
+// Size sets the font size.
+func (e *Element) Size(size int) *Element {
+	e.node.Props.Size = &size
+	return e
+}
+
+// Color sets the text color (string or slot reference).
+func (e *Element) Color(color interface{}) *Element {
+	e.node.Props.Color = e.checkColor("color", color)
+	return e
+}
+
+// Background sets the background color (string or slot reference).
+func (e *Element) Background(color interface{}) *Element {
+	e.node.Props.Background = e.checkColor("background", color)
+	return e
+}
+
+// Border sets a border of the given width in pixels, style ("solid",
+// "dashed", "dotted", "double", or "none"), and color, which may be "".
+func (e *Element) Border(width int, style, color string) *Element {
+	if width < 0 {
+		e.fail("border width must not be negative, got %d", width)
+	}
+	style = e.check("border", style, BorderSolid, BorderDashed, BorderDotted, BorderDouble, BorderNone)
+	e.node.Props.Border = &BorderStyle{Width: width, Style: style, Color: color}
+	return e
+}
+
+// BorderRadius sets the corner radius in pixels.
+func (e *Element) BorderRadius(radius int) *Element {
+	e.node.Props.BorderRadius = &radius
+	return e
+}
+
+// Shadow sets a drop shadow offset by (x, y) pixels with the given blur
+// radius and color, which may be "".
+func (e *Element) Shadow(x, y, blur int, color string) *Element {
+	if blur < 0 {
+		e.fail("shadow blur must not be negative, got %d", blur)
+	}
+	e.node.Props.Shadow = &ShadowStyle{X: x, Y: y, Blur: blur, Color: color}
+	return e
+}
+
+// Opacity sets the opacity of the element and its subtree, from 0 to 1.
+func (e *Element) Opacity(opacity float64) *Element {
+	if opacity < 0 || opacity > 1 {
+		e.fail("opacity must be between 0 and 1, got %g", opacity)
+	}
+	e.node.Props.Opacity = &opacity
+	return e
+}
+
+// Translate moves the element and its subtree as drawn by (x, y) pixels.
+func (e *Element) Translate(x, y float64) *Element {
+	t := e.transform()
+	t.TranslateX, t.TranslateY = x, y
+	return e
+}
+
+// Scale scales the element and its subtree as drawn about its center, by
+// sx across and sy down.
+func (e *Element) Scale(sx, sy float64) *Element {
+	if sx == 0 || sy == 0 {
+		e.fail("scale must not be 0, got %g×%g", sx, sy)
+	}
+	t := e.transform()
+	t.ScaleX, t.ScaleY = sx, sy
+	return e
+}
+
+// Rotate rotates the element and its subtree as drawn about its center,
+// by degrees clockwise.
+func (e *Element) Rotate(degrees float64) *Element {
+	e.transform().Rotate = degrees
+	return e
+}
+
+// transform returns the element's transform, adding one if it has none.
+func (e *Element) transform() *Transform {
+	if e.node.Props.Transform == nil {
+		e.node.Props.Transform = &Transform{}
+	}
+	return e.node.Props.Transform
+}
+
+// Style references a style slot.
+func (e *Element) Style(slot int) *Element {
+	e.node.Props.Style = &slot
+	return e
+}
+
+// Placeholder sets an input's placeholder.
+func (e *Element) Placeholder(text string) *Element {
+	e.node.Props.Placeholder = &text
+	return e
+}
+
+// Tooltip sets text shown near the element while it is hovered or
+// focused.
+func (e *Element) Tooltip(text string) *Element {
+	e.node.Props.Tooltip = &text
+	return e
+}
+
+// Variant sets how a button is drawn: ButtonPrimary or ButtonDanger.
+func (e *Element) Variant(variant string) *Element {
+	e.node.Props.Variant = variant
+	return e
+}
+
+// ColumnWidths sets the widths of a table's columns in characters; 0
+// sizes a column to its contents.
+func (e *Element) ColumnWidths(widths ...int) *Element {
+	e.node.Props.ColumnWidths = widths
+	return e
+}
+
+// Striped shades every other row of a table.
+func (e *Element) Striped() *Element {
+	striped := true
+	e.node.Props.Striped = &striped
+	return e
+}
+
+// Checked checks a checkbox or radio.
+func (e *Element) Checked() *Element {
+	checked := true
+	e.node.Props.Checked = &checked
+	return e
+}
+
+// Value sets an input's value.
+func (e *Element) Value(text string) *Element {
+	e.node.Props.Value = &text
+	return e
+}
+
+// Multiline makes an input multi-line.
+func (e *Element) Multiline() *Element {
+	multiline := true
+	e.node.Props.Multiline = &multiline
+	return e
+}
+
+// MaxLength limits an input's value to n grapheme clusters.
+func (e *Element) MaxLength(n int) *Element {
+	e.node.Props.MaxLength = &n
+	return e
+}
+
+// InputType sets what an input's value must be: InputText, InputNumber,
+// or InputEmail.
+func (e *Element) InputType(t string) *Element {
+	e.node.Props.InputType = t
+	return e
+}
+
+// Pattern sets a regular expression an input's whole value must match.
+func (e *Element) Pattern(re string) *Element {
+	e.node.Props.Pattern = re
+	return e
+}
+
+// Secret makes an input hide its value, as for a password.
+func (e *Element) Secret() *Element {
+	secret := true
+	e.node.Props.Secret = &secret
+	return e
+}
+
+// Draggable lets the element be dragged with the pointer onto Droppable
+// ones.
+func (e *Element) Draggable() *Element {
+	draggable := true
+	e.node.Props.Draggable = &draggable
+	return e
+}
+
+// Droppable lets Draggable elements be dropped on the element.
+func (e *Element) Droppable() *Element {
+	droppable := true
+	e.node.Props.Droppable = &droppable
+	return e
+}
+
+// CapturePointer sends the element every pointer move and the release
+// after a press on it, wherever the pointer goes.
+func (e *Element) CapturePointer() *Element {
+	capture := true
+	e.node.Props.CapturePointer = &capture
+	return e
+}
+
+// Sticky pins a child of a scroll container to the top of its viewport
+// while the content scrolls under it, as for table headers.
+func (e *Element) Sticky() *Element {
+	sticky := true
+	e.node.Props.Sticky = &sticky
+	return e
+}
+
+// Overlay makes the element an overlay drawn over the screen at level z.
+func (e *Element) Overlay(z int) *Element {
+	e.node.Props.ZIndex = &z
+	return e
+}
+
+// Modal makes the element a modal overlay, dimming and blocking the
+// screen under it while it is open.
+func (e *Element) Modal() *Element {
+	modal := true
+	if e.node.Props.ZIndex == nil {
+		e.Overlay(0)
+	}
+	e.node.Props.Modal = &modal
+	return e
+}
+
+// Disabled disables an input or control.
+func (e *Element) Disabled() *Element {
+	disabled := true
+	e.node.Props.Disabled = &disabled
+	return e
+}
+
+// Hidden takes the element and its children off the screen, keeping
+// their state until the element is shown again.
+func (e *Element) Hidden() *Element {
+	hidden := true
+	e.node.Props.Hidden = &hidden
+	return e
+}
+
+// AltText sets the text shown in place of an image or canvas.
+func (e *Element) AltText(text string) *Element {
+	e.node.Props.AltText = &text
+	return e
+}
+
+// TextAlt overrides the node's text projection.
+func (e *Element) TextAlt(text string) *Element {
+	e.node.Props.TextAlt = &text
+	return e
+}
+
+// Clickable marks the element as clickable.
+func (e *Element) Clickable() *Element {
+	e.node.Props.Interactive = "clickable"
+	return e
+}
+
+// Focusable marks the element as focusable.
+func (e *Element) Focusable() *Element {
+	e.node.Props.Interactive = "focusable"
+	return e
+}
+
+// Width sets a fixed width.
+func (e *Element) Width(w int) *Element {
+	e.node.Props.Width = w
+	return e
+}
+
+// Height sets a fixed height.
+func (e *Element) Height(h int) *Element {
+	e.node.Props.Height = h
+	return e
+}
+
+// WidthOf sets a width in other units: a percentage of the parent's
+// content width ("50%") or of the viewport's width or height ("100vw").
+func (e *Element) WidthOf(size string) *Element {
+	e.node.Props.Width = e.checkLength("width", size)
+	return e
+}
+
+// HeightOf sets a height in other units, as WidthOf does.
+func (e *Element) HeightOf(size string) *Element {
+	e.node.Props.Height = e.checkLength("height", size)
+	return e
+}
+
+// Overflow sets what a box does with children reaching outside it:
+// OverflowHidden clips them, OverflowVisible shows them, and OverflowAuto
+// scrolls.
+func (e *Element) Overflow(mode string) *Element {
+	e.node.Props.Overflow = e.check("overflow", mode, OverflowVisible, OverflowHidden, OverflowAuto)
+	return e
+}
+
+// AspectRatio keeps the element's width over height at ratio, deriving
+// whichever side it does not set.
+func (e *Element) AspectRatio(ratio float64) *Element {
+	if ratio <= 0 {
+		e.fail("aspectRatio must be positive, got %g", ratio)
+	}
+	e.node.Props.AspectRatio = &ratio
+	return e
+}
+
+// Rows sets a grid's rows template, such as "auto 1fr".
+func (e *Element) Rows(template string) *Element {
+	e.node.Props.Rows = template
+	return e
+}
+
+// Span makes the element take cols columns and rows rows of its grid.
+func (e *Element) Span(cols, rows int) *Element {
+	if cols < 1 || rows < 1 {
+		e.fail("span must be at least 1 × 1, got %d × %d", cols, rows)
+	}
+	e.node.Props.ColSpan = &cols
+	e.node.Props.RowSpan = &rows
+	return e
+}
+
+// Absolute positions the element within its parent by its Top, Right,
+// Bottom, and Left offsets, out of the parent's flow.
+func (e *Element) Absolute() *Element {
+	e.node.Props.Position = PositionAbsolute
+	return e
+}
+
+// Fixed positions the element on the screen by its Top, Right, Bottom,
+// and Left offsets, unmoved by scrolling.
+func (e *Element) Fixed() *Element {
+	e.node.Props.Position = PositionFixed
+	return e
+}
+
+// Top sets how far a positioned element's top edge is from the top.
+func (e *Element) Top(n int) *Element {
+	e.node.Props.Top = n
+	return e
+}
+
+// Right sets how far a positioned element's right edge is from the right.
+func (e *Element) Right(n int) *Element {
+	e.node.Props.Right = n
+	return e
+}
+
+// Bottom sets how far a positioned element's bottom edge is from the
+// bottom.
+func (e *Element) Bottom(n int) *Element {
+	e.node.Props.Bottom = n
+	return e
+}
+
+// Left sets how far a positioned element's left edge is from the left.
+func (e *Element) Left(n int) *Element {
+	e.node.Props.Left = n
+	return e
+}
+
+// Flex sets the flex grow factor.
+func (e *Element) Flex(grow float64) *Element {
+	if grow < 0 {
+		e.fail("flex must not be negative, got %g", grow)
+	}
+	e.node.Props.Flex = &grow
+	return e
+}
+
+// Padding sets padding; see the Padding option.
+func (e *Element) Padding(values ...int) *Element {
+	e.node.Props.Padding = e.spacing("padding", values)
+	return e
+}
+
+// Margin sets margin; see the Padding option.
+func (e *Element) Margin(values ...int) *Element {
+	e.node.Props.Margin = e.spacing("margin", values)
+	return e
+}
+
+// Children appends children to a container.
+func (e *Element) Children(children ...*Element) *Element {
+	e.children = append(e.children, children...)
+	return e
+}
+
+// ── Building ─────────────────────────────────────────────────────────
+
+// Build validates the tree and returns it as a VNode, assigning IDs to
+// nodes without an explicit ID. Automatic IDs count up from 1, skipping
+// explicit ones. Errors wrap ErrInvalidTree.
+func (e *Element) Build() (*VNode, error) {
+	used, err := e.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	next := 1
+	return e.build("", func(*Element, string) int {
+		for used[next] {
+			next++
+		}
+		used[next] = true
+		return next
+	}), nil
+}
+
+// BuildWith is Build with IDs taken from ids. A node without an explicit
+// ID gets the ID bound to its path: the keys of it and its ancestors, or
+// their type and position where no key is set. Building the same shape
+// again therefore yields the same IDs, which is what lets a Component's
+// renders be reconciled. Explicit IDs are reserved in ids.
+func (e *Element) BuildWith(ids *IDAllocator) (*VNode, error) {
+	used, err := e.validate()
+	if err != nil {
+		return nil, err
+	}
+	for id := range used {
+		ids.Reserve(id)
+	}
+	root := e.key
+	if root == "" {
+		root = string(e.node.Type)
+	}
+	return e.build(root, func(_ *Element, path string) int {
+		return ids.Key(path)
+	}), nil
+}
+
+// MustBuild is Build that panics on an invalid tree, for trees fixed at
+// compile time.
+func (e *Element) MustBuild() *VNode {
+	root, err := e.Build()
+	if err != nil {
+		panic(err)
+	}
+	return root
+}
+
+// validate checks the whole tree and returns the set of explicit IDs.
+func (e *Element) validate() (map[int]bool, error) {
+	var errs []error
+	used := make(map[int]bool)
+	e.walk(func(el *Element) {
+		for _, err := range el.errs {
+			errs = append(errs, fmt.Errorf("%s: %w", el.node.Type, err))
+		}
+		if len(el.children) > 0 && el.node.Type != NodeBox && el.node.Type != NodeScroll {
+			errs = append(errs, fmt.Errorf("%s cannot have children", el.node.Type))
+		}
+		if el.idSet {
+			if used[el.node.ID] {
+				errs = append(errs, fmt.Errorf("duplicate id %d", el.node.ID))
+			}
+			used[el.node.ID] = true
+		}
+		keys := make(map[string]bool)
+		for _, c := range el.children {
+			if c.key != "" && keys[c.key] {
+				errs = append(errs, fmt.Errorf("duplicate key %q", c.key))
+			}
+			keys[c.key] = true
+		}
+	})
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidTree, errors.Join(errs...))
+	}
+	return used, nil
+}
+
+// build copies the element tree into VNodes in depth-first order, asking
+// assign for the ID of each node without an explicit one. path identifies
+// the element; children extend it with their key or position.
+func (e *Element) build(path string, assign func(el *Element, path string) int) *VNode {
+	node := e.node
+	if !e.idSet {
+		node.ID = assign(e, path)
+	}
+	node.Children = nil
+	for i, child := range e.children {
+		seg := child.key
+		if seg == "" {
+			seg = fmt.Sprintf("%d:%s", i, child.node.Type)
+		}
+		node.Children = append(node.Children, child.build(path+"/"+seg, assign))
+	}
+	return &node
+}
+
+// walk visits the element and its descendants depth-first.
+func (e *Element) walk(fn func(*Element)) {
+	fn(e)
+	for _, child := range e.children {
+		child.walk(fn)
+	}
+}
+
+// check records an error if value is not one of allowed, and returns it.
+func (e *Element) check(prop, value string, allowed ...string) string {
+	if !containsString(allowed, value) {
+		sort.Strings(allowed)
+		e.fail("invalid %s %q (want one of %v)", prop, value, allowed)
+	}
+	return value
+}
+
+// checkLength accepts size strings.
+func (e *Element) checkLength(prop, size string) string {
+	if _, ok := parseSize(size); !ok {
+		e.fail("invalid %s %q", prop, size)
+	}
+	return size
+}
+
+// checkColor accepts color strings and integer slot references.
+func (e *Element) checkColor(prop string, color interface{}) interface{} {
+	switch color.(type) {
+	case string, int:
+		return color
+	}
+	e.fail("%s must be a color string or slot reference, got %T", prop, color)
+	return nil
+}
+
+// spacing converts 1, 2, or 4 values to a padding/margin prop.
+func (e *Element) spacing(prop string, values []int) interface{} {
+	switch len(values) {
+	case 1:
+		return values[0]
+	case 2, 4:
+		out := make([]interface{}, len(values))
+		for i, v := range values {
+			out[i] = v
+		}
+		return out
+	}
+	e.fail("%s takes 1, 2, or 4 values, got %d", prop, len(values))
+	return nil
+}
+
+// fail records a validation error.
+func (e *Element) fail(format string, args ...interface{}) {
+	e.errs = append(e.errs, fmt.Errorf(format, args...))
+}
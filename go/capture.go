@@ -0,0 +1,107 @@
+package viewer
+
+// Pointer capture. A press on a node that sets the capturePointer prop
+// captures the pointer: until the button is released, the source gets a
+// "pointer_down", then a "pointer_move" for every move and a
+// "pointer_up" on the release, all aimed at that node wherever the
+// pointer is — outside the node, outside the tree, over other nodes. A
+// slider or a custom scrollbar keeps tracking a drag that strays off it,
+// as the viewer's own scrollbars and text selection do. Pointer events
+// carry the position in input units (X, Y) and relative to the captured
+// node's top-left corner (OffsetX, OffsetY), which is negative or past
+// its size when the pointer is outside it.
+//
+// Hosts can also capture for a node of their own choosing while a press
+// is held, with SetPointerCapture. Capture ends on the release, on the
+// next press, or when the node leaves the tree. A second finger, which
+// turns the touch into a gesture, ends it with a "pointer_cancel"; a
+// finger held still on a capturing node is not a long press.
+
+// SetPointerCapture sends the rest of the current press — its moves and
+// its release — to the source aimed at nodeID, as if the node set the
+// capturePointer prop. Returns false if the node is not in the tree.
+func (v *Viewer) SetPointerCapture(nodeID int) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.tree.NodeIndex[nodeID] == nil {
+		return false
+	}
+	v.capture = &nodeID
+	return true
+}
+
+// ReleasePointerCapture ends any pointer capture; later moves are handled
+// as usual.
+func (v *Viewer) ReleasePointerCapture() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.capture = nil
+}
+
+// PointerCapture returns the node that has captured the pointer, if any.
+func (v *Viewer) PointerCapture() (nodeID int, ok bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.capture == nil {
+		return 0, false
+	}
+	return *v.capture, true
+}
+
+// pressCapture captures the pointer for the topmost node in path that
+// sets the capturePointer prop, if any, and sends it a pointer_down.
+// Returns whether there was one.
+// Must be called with the mutex held.
+func (v *Viewer) pressCapture(in *inputLayout, path []hitNode, ev PointerEvent) bool {
+	for i := len(path) - 1; i >= 0; i-- {
+		if node := path[i].node; capturesPointer(node) {
+			id := node.ID
+			v.capture = &id
+			v.emitCapture(in, "pointer_down", ev)
+			return true
+		}
+	}
+	return false
+}
+
+// capturing reports whether a node has captured the pointer, forgetting
+// the capture if the node has left the tree.
+// Must be called with the mutex held.
+func (v *Viewer) capturing() bool {
+	if v.capture != nil && v.tree.NodeIndex[*v.capture] == nil {
+		v.capture = nil
+	}
+	return v.capture != nil
+}
+
+// cancelCapture ends any capture, telling the node with a pointer_cancel.
+// Must be called with the mutex held.
+func (v *Viewer) cancelCapture() {
+	if !v.capturing() {
+		return
+	}
+	event := InputEvent{Target: v.capture, Kind: "pointer_cancel"}
+	v.capture = nil
+	v.emit(ProtocolMessage{Type: MsgInput, Event: &event})
+}
+
+// emitCapture sends the captured node a pointer event.
+// Must be called with the mutex held.
+func (v *Viewer) emitCapture(in *inputLayout, kind string, ev PointerEvent) {
+	id := *v.capture
+	x, y, button := ev.X, ev.Y, ev.Button
+	event := InputEvent{Target: &id, Kind: kind, X: &x, Y: &y, Button: &button}
+	if l := in.layouts[id]; l != nil {
+		ox := x - int(l.X)
+		oy := y - int(l.Y-scrollOffset(v.tree.Root, id))
+		event.OffsetX, event.OffsetY = &ox, &oy
+	}
+	v.emit(ProtocolMessage{Type: MsgInput, Event: &event})
+}
+
+// capturesPointer reports whether a node sets the capturePointer prop.
+func capturesPointer(node *RenderNode) bool {
+	return node.Props.CapturePointer != nil && *node.Props.CapturePointer
+}
@@ -0,0 +1,185 @@
+package viewer
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// Clipboard. Ctrl+c and ctrl+x copy and cut the selection in the focused
+// input, and ctrl+v pastes over it; with nothing selected, ctrl+c and
+// ctrl+x go to the source as keys, so a source can still treat ctrl+c as
+// an interrupt. A paste also sends the source a paste event with the
+// pasted text, aimed at the focused node if there is one, so sources can
+// take pastes outside inputs too. Inputs that are not multiline get
+// pasted line breaks as spaces. Secret inputs take pastes but cannot be
+// copied or cut from. Clipboard failures go to the error handlers.
+//
+// A headless viewer keeps its own in-memory clipboard, and others use the
+// system clipboard, unless the host sets one with SetClipboard.
+
+// ErrNoClipboard is returned by SystemClipboard when no clipboard tool is
+// installed.
+var ErrNoClipboard = errors.New("no clipboard tool found")
+
+// Clipboard holds copied text.
+type Clipboard interface {
+	ReadText() (string, error)
+	WriteText(text string) error
+}
+
+// MemoryClipboard is a Clipboard that holds text in memory.
+type MemoryClipboard struct {
+	mu   sync.Mutex
+	text string
+}
+
+func (c *MemoryClipboard) ReadText() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.text, nil
+}
+
+func (c *MemoryClipboard) WriteText(text string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.text = text
+	return nil
+}
+
+// SystemClipboard is the operating system's clipboard, reached through
+// its command-line tools: pbcopy and pbpaste on macOS, wl-clipboard under
+// Wayland, xclip or xsel under X11, and clip.exe with PowerShell on
+// Windows.
+type SystemClipboard struct{}
+
+// clipboardTools are the commands that copy to and paste from the system
+// clipboard, in order of preference.
+var clipboardTools = []struct {
+	copy, paste []string
+	env         string // set only in sessions where the tool works
+}{
+	{copy: []string{"pbcopy"}, paste: []string{"pbpaste"}},
+	{copy: []string{"wl-copy"}, paste: []string{"wl-paste", "--no-newline"}, env: "WAYLAND_DISPLAY"},
+	{copy: []string{"xclip", "-selection", "clipboard"}, paste: []string{"xclip", "-selection", "clipboard", "-o"}, env: "DISPLAY"},
+	{copy: []string{"xsel", "--clipboard", "--input"}, paste: []string{"xsel", "--clipboard", "--output"}, env: "DISPLAY"},
+	{copy: []string{"clip.exe"}, paste: []string{"powershell.exe", "-NoProfile", "-Command", "Get-Clipboard -Raw"}},
+}
+
+// clipboardTool returns the copy and paste commands of the first
+// clipboard tool available.
+func clipboardTool() (copyCmd, pasteCmd []string, err error) {
+	for _, t := range clipboardTools {
+		if t.env != "" && os.Getenv(t.env) == "" {
+			continue
+		}
+		if _, err := exec.LookPath(t.copy[0]); err == nil {
+			return t.copy, t.paste, nil
+		}
+	}
+	return nil, nil, ErrNoClipboard
+}
+
+func (SystemClipboard) ReadText() (string, error) {
+	_, pasteCmd, err := clipboardTool()
+	if err != nil {
+		return "", err
+	}
+	out, err := exec.Command(pasteCmd[0], pasteCmd[1:]...).Output()
+	return string(out), err
+}
+
+func (SystemClipboard) WriteText(text string) error {
+	copyCmd, _, err := clipboardTool()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(copyCmd[0], copyCmd[1:]...)
+	cmd.Stdin = strings.NewReader(text)
+	return cmd.Run()
+}
+
+// SetClipboard replaces the viewer's clipboard. Passing nil restores the
+// default.
+func (v *Viewer) SetClipboard(c Clipboard) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.clip = c
+}
+
+// clipboard returns the clipboard in effect.
+// Must be called with the mutex held.
+func (v *Viewer) clipboard() Clipboard {
+	if v.clip != nil {
+		return v.clip
+	}
+	if v.renderTarget.TargetType() == "headless" {
+		v.clip = &MemoryClipboard{}
+		return v.clip
+	}
+	return SystemClipboard{}
+}
+
+// clipboardKey applies a copy, cut, or paste key. target is the focused
+// node, if any. Returns false if the key is not one, or there is nothing
+// selected to copy or cut.
+// Must be called with the mutex held.
+func (v *Viewer) clipboardKey(key string, target *int) bool {
+	var node *RenderNode
+	if c := v.tree.Cursor; c != nil {
+		node = v.tree.NodeIndex[c.Node]
+	}
+
+	switch strings.ToLower(key) {
+	case "ctrl+c", "ctrl+x":
+		if node == nil {
+			return false
+		}
+		value := inputValue(node)
+		start, end := v.tree.Cursor.selection(len(value))
+		if start == end {
+			return false
+		}
+		if isSecret(node) {
+			return true
+		}
+		if err := v.clipboard().WriteText(value[start:end]); err != nil {
+			v.reportError(err)
+			return true
+		}
+		if strings.EqualFold(key, "ctrl+x") {
+			v.replaceSelection(node, "")
+		}
+		return true
+
+	case "ctrl+v":
+		text, err := v.clipboard().ReadText()
+		if err != nil {
+			v.reportError(err)
+			return true
+		}
+		event := InputEvent{Target: target, Kind: "paste", Value: text}
+		v.emit(ProtocolMessage{Type: MsgInput, Event: &event})
+		if node != nil {
+			if node.Props.Multiline == nil || !*node.Props.Multiline {
+				text = strings.NewReplacer("\r\n", " ", "\n", " ", "\r", " ").Replace(text)
+			}
+			v.replaceSelection(node, text)
+		}
+		return true
+	}
+	return false
+}
+
+// replaceSelection replaces the selection in the input being edited with
+// text, or inserts it at the cursor.
+// Must be called with the mutex held.
+func (v *Viewer) replaceSelection(node *RenderNode, text string) {
+	value := inputValue(node)
+	start, end := v.tree.Cursor.selection(len(value))
+	v.insertText(node, value, start, end, text)
+	v.selected(node, start, end)
+	v.showCursor()
+}
@@ -0,0 +1,100 @@
+package viewer
+
+import "fmt"
+
+// Clipboard transfer. A source can put text on the clipboard of the
+// machine the viewer runs on, so its "copy" actions reach the user, and
+// read text from it, with MsgClipboard. Either direction hands the
+// source access to the user's clipboard, so both are off until the host
+// allows them with SetClipboardPolicy, and text over the policy's size
+// limit is refused. Refusals are reported upstream; clipboard failures
+// go to the error handlers, and leave a read unanswered.
+
+// DefaultClipboardMaxBytes is the transfer size limit when
+// ClipboardPolicy.MaxBytes is zero.
+const DefaultClipboardMaxBytes = 1 << 20
+
+// Clipboard transfer ops, in ClipboardTransfer.Op.
+const (
+	ClipboardWrite = "write" // source → viewer: put Text on the clipboard
+	ClipboardRead  = "read"  // source → viewer: answer with the clipboard's text
+	ClipboardText  = "text"  // viewer → source: the answer to a read
+)
+
+// ClipboardTransfer is the payload of a MsgClipboard. A read and its
+// answer carry the same ProtocolMessage.Nonce.
+type ClipboardTransfer struct {
+	Op   string `json:"op" cbor:"op"`
+	Text string `json:"text,omitempty" cbor:"text,omitempty"`
+}
+
+// ClipboardPolicy is what a source may do with the viewer's clipboard.
+// The zero value allows nothing.
+type ClipboardPolicy struct {
+	AllowWrite bool
+	AllowRead  bool
+	MaxBytes   int // largest text transferred either way; 0 means DefaultClipboardMaxBytes
+}
+
+// SetClipboardPolicy sets what the source may do with the clipboard (see
+// SetClipboard) through MsgClipboard.
+func (v *Viewer) SetClipboardPolicy(p ClipboardPolicy) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.clipPolicy = p
+}
+
+// handleClipboard applies a MsgClipboard from the source.
+// Must be called with the mutex held.
+func (v *Viewer) handleClipboard(msg ProtocolMessage) {
+	p := v.clipPolicy
+	limit := p.MaxBytes
+	if limit <= 0 {
+		limit = DefaultClipboardMaxBytes
+	}
+	deny := func(format string, args ...interface{}) {
+		v.reportUpstream(ErrorReport{Code: ErrorCodeDenied, Message: fmt.Sprintf(format, args...), MsgType: MsgClipboard})
+	}
+	tooLarge := func(n int) {
+		v.reportUpstream(ErrorReport{
+			Code:    ErrorCodeQuota,
+			Message: fmt.Sprintf("clipboard text of %d bytes exceeds limit %d", n, limit),
+			MsgType: MsgClipboard,
+		})
+	}
+
+	switch t := msg.Clipboard; t.Op {
+	case ClipboardWrite:
+		if !p.AllowWrite {
+			deny("clipboard writes are not allowed")
+			return
+		}
+		if len(t.Text) > limit {
+			tooLarge(len(t.Text))
+			return
+		}
+		if err := v.clipboard().WriteText(t.Text); err != nil {
+			v.reportError(err)
+		}
+
+	case ClipboardRead:
+		if !p.AllowRead {
+			deny("clipboard reads are not allowed")
+			return
+		}
+		text, err := v.clipboard().ReadText()
+		if err != nil {
+			v.reportError(err)
+			return
+		}
+		if len(text) > limit {
+			tooLarge(len(text))
+			return
+		}
+		v.emit(ProtocolMessage{
+			Type:      MsgClipboard,
+			Nonce:     msg.Nonce,
+			Clipboard: &ClipboardTransfer{Op: ClipboardText, Text: text},
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package viewer
+
+import "time"
+
+// Clock is the viewer's source of wall-clock time. Substitute a fixed or
+// manually advanced clock for deterministic tests and replays.
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock reads the real wall clock.
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time { return time.Now() }
+
+// ManualClock is a Clock that only moves when told to.
+type ManualClock struct {
+	T time.Time
+}
+
+func (c *ManualClock) Now() time.Time { return c.T }
+
+// Advance moves the clock forward by d.
+func (c *ManualClock) Advance(d time.Duration) { c.T = c.T.Add(d) }
+
+// SetClock replaces the viewer's clock. Passing nil restores SystemClock.
+// The tree reads it too, so relative_time columns render the same way
+// every time under a ManualClock.
+func (v *Viewer) SetClock(c Clock) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if c == nil {
+		c = SystemClock{}
+	}
+	v.clock = c
+	v.tree.Clock = c
+}
+
+// now returns the current time from the viewer's clock.
+// Must be called with the mutex held.
+func (v *Viewer) now() time.Time {
+	if v.clock == nil {
+		return time.Now()
+	}
+	return v.clock.Now()
+}
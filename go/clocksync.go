@@ -0,0 +1,90 @@
+package viewer
+
+import "time"
+
+// Clock synchronization. Times the source sends — relative_time columns,
+// message timestamps — are by its clock, which need not agree with the
+// viewer's. The viewer estimates the difference NTP-style over ping and
+// pong: SourceState stamps each pong with its clock when sending it, and
+// assuming the pong left halfway through the round trip, the stamp less
+// the viewer's time at that midpoint is the offset. Of the last few
+// samples, the one with the shortest round trip is used, since it leaves
+// the least room for asymmetric delay. Every heartbeat ping takes a
+// sample; SyncClock takes one on demand.
+
+// clockSamples is how many recent offset samples are kept.
+const clockSamples = 8
+
+// maxPendingPings bounds how many unanswered pings are remembered.
+const maxPendingPings = 16
+
+// clockSample is one offset estimate and the round trip it came from.
+type clockSample struct {
+	rtt    time.Duration
+	offset time.Duration
+}
+
+// SyncClock pings the source to refine the clock offset estimate.
+func (v *Viewer) SyncClock() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.sendPing()
+}
+
+// ClockOffset returns how far the source's clock is estimated to run
+// ahead of the viewer's, or 0 before the first sample.
+func (v *Viewer) ClockOffset() time.Duration {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.tree.ClockOffset
+}
+
+// sendPing emits a MsgPing, remembering when it was sent, and returns its
+// nonce. Must be called with the mutex held.
+func (v *Viewer) sendPing() uint64 {
+	v.pingNext++
+	nonce := v.pingNext
+	if v.pings == nil {
+		v.pings = make(map[uint64]time.Time)
+	}
+	v.pings[nonce] = v.now()
+	delete(v.pings, nonce-maxPendingPings)
+	v.emit(ProtocolMessage{Type: MsgPing, Nonce: &nonce})
+	return nonce
+}
+
+// addClockSample records the offset implied by a pong the source stamped
+// at source (Unix microseconds by its clock), answering a ping sent at
+// sent that took rtt to come back.
+// Must be called with the mutex held.
+func (v *Viewer) addClockSample(sent time.Time, rtt time.Duration, source int64) {
+	offset := time.UnixMicro(source).Sub(sent.Add(rtt / 2))
+	v.clockSamples = append(v.clockSamples, clockSample{rtt: rtt, offset: offset})
+	if len(v.clockSamples) > clockSamples {
+		v.clockSamples = v.clockSamples[1:]
+	}
+	best := v.clockSamples[0]
+	for _, s := range v.clockSamples[1:] {
+		if s.rtt < best.rtt {
+			best = s
+		}
+	}
+	v.tree.ClockOffset = best.offset
+}
+
+// sourceNow returns the current time by the source's clock, as far as
+// tree's clock and clock offset know it.
+func sourceNow(tree *RenderTree) time.Time {
+	if tree == nil {
+		return time.Now()
+	}
+	return clockNow(tree.Clock).Add(tree.ClockOffset)
+}
+
+// clockNow reads c, or the system clock if c is nil.
+func clockNow(c Clock) time.Time {
+	if c == nil {
+		return time.Now()
+	}
+	return c.Now()
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"sync"
+
+	viewer "github.com/anthropics/viewport/viewer"
+)
+
+// instance is the Go-side state behind a C handle.
+type instance struct {
+	v *viewer.Viewer
+
+	// in serializes inbound bytes, so frames split across calls are
+	// reassembled, and processed, in the order the host passed them.
+	in     sync.Mutex
+	reader *viewer.FrameReader
+
+	mu     sync.Mutex
+	outbox [][]byte // encoded outbound frames awaiting viewer_next_output
+}
+
+// newInstance creates a headless viewer of the given display size whose
+// outbound messages are queued for nextOutput.
+func newInstance(width, height int) *instance {
+	inst := &instance{
+		v:      viewer.NewViewer(viewer.HeadlessTarget{}),
+		reader: viewer.NewFrameReader(),
+	}
+	inst.v.Init(viewer.EnvInfo{
+		ViewportVersion: viewer.ProtocolVersion,
+		DisplayWidth:    width,
+		DisplayHeight:   height,
+		PixelDensity:    1.0,
+		ColorDepth:      24,
+	})
+	inst.v.OnMessage(func(msg viewer.ProtocolMessage) {
+		frame, err := viewer.EncodeFrame(&msg)
+		if err != nil {
+			return
+		}
+		inst.mu.Lock()
+		inst.outbox = append(inst.outbox, frame)
+		inst.mu.Unlock()
+	})
+	return inst
+}
+
+// processBytes feeds raw protocol bytes to the viewer and returns the
+// number of messages processed. Undecodable payloads are skipped and
+// handled as Serve handles them (see Viewer.ReportDecodeError).
+func (inst *instance) processBytes(data []byte) (int, error) {
+	inst.in.Lock()
+	defer inst.in.Unlock()
+
+	inst.v.TrackBytes(len(data))
+	frames, err := inst.reader.Feed(data)
+	if err != nil {
+		return 0, err
+	}
+
+	processed := 0
+	for _, f := range frames {
+		msg, err := viewer.DecodeMessage(f.Header, f.Payload)
+		if err != nil {
+			inst.v.ReportDecodeError(f.Header.Type, err)
+			continue
+		}
+		inst.v.ProcessMessage(msg)
+		processed++
+	}
+	return processed, nil
+}
+
+// nextOutput pops the next encoded outbound frame, or returns nil.
+func (inst *instance) nextOutput() []byte {
+	inst.mu.Lock()
+	defer inst.mu.Unlock()
+	if len(inst.outbox) == 0 {
+		return nil
+	}
+	frame := inst.outbox[0]
+	inst.outbox = inst.outbox[1:]
+	return frame
+}
+
+// handleTable maps the opaque handles given to the host to instances.
+// Handles are never reused, so a stale one finds nothing.
+type handleTable struct {
+	mu        sync.Mutex
+	instances map[uintptr]*instance
+	next      uintptr
+}
+
+func newHandleTable() *handleTable {
+	return &handleTable{instances: make(map[uintptr]*instance), next: 1}
+}
+
+// add registers inst and returns its handle.
+func (t *handleTable) add(inst *instance) uintptr {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	h := t.next
+	t.next++
+	t.instances[h] = inst
+	return h
+}
+
+// lookup returns the instance for a handle, or nil.
+func (t *handleTable) lookup(h uintptr) *instance {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.instances[h]
+}
+
+// remove forgets a handle and returns its instance, or nil.
+func (t *handleTable) remove(h uintptr) *instance {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	inst := t.instances[h]
+	delete(t.instances, h)
+	return inst
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	viewer "github.com/anthropics/viewport/viewer"
+)
+
+func TestHandleTable(t *testing.T) {
+	table := newHandleTable()
+	a, b := newInstance(80, 24), newInstance(80, 24)
+	ha, hb := table.add(a), table.add(b)
+	if ha == 0 || ha == hb {
+		t.Fatalf("handles %d, %d", ha, hb)
+	}
+	if table.lookup(ha) != a || table.lookup(hb) != b {
+		t.Error("lookup did not find the instances")
+	}
+	if table.remove(ha) != a || table.lookup(ha) != nil || table.remove(ha) != nil {
+		t.Error("removed handle still found")
+	}
+	if hc := table.add(newInstance(80, 24)); hc == ha || hc == hb {
+		t.Errorf("handle %d reused", hc)
+	}
+}
+
+// encode returns msgs as wire frames.
+func encode(t *testing.T, msgs []viewer.ProtocolMessage) []byte {
+	t.Helper()
+	var out []byte
+	for i := range msgs {
+		frame, err := viewer.EncodeFrame(&msgs[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, frame...)
+	}
+	return out
+}
+
+func TestProcessBytes(t *testing.T) {
+	inst := newInstance(80, 24)
+	for inst.nextOutput() != nil {
+		// Drop the handshake.
+	}
+	s := viewer.NewSourceState()
+	s.SetTree(viewer.Column(viewer.Text("Hello").ID(2)).ID(1).MustBuild())
+	stream := encode(t, s.Flush())
+
+	// A frame split across calls is processed once it is whole.
+	if n, err := inst.processBytes(stream[:5]); n != 0 || err != nil {
+		t.Fatalf("partial frame = %d, %v", n, err)
+	}
+	if n, err := inst.processBytes(stream[5:]); n != 1 || err != nil {
+		t.Fatalf("rest of frame = %d, %v", n, err)
+	}
+	if got := inst.v.GetTextProjection(); got != "Hello" {
+		t.Errorf("projection %q", got)
+	}
+	if m := inst.v.GetMetrics(); m.BytesReceived != len(stream) {
+		t.Errorf("bytesReceived = %d, want %d", m.BytesReceived, len(stream))
+	}
+	if frame := inst.nextOutput(); frame == nil {
+		t.Error("no ack queued")
+	}
+
+	// An undecodable payload is skipped, counted, and reported upstream
+	// ahead of a refresh request, as Serve does.
+	bad := []byte{0x56, 0x50, 1, byte(viewer.MsgPatch), 1, 0, 0, 0, 0xff}
+	if n, err := inst.processBytes(bad); n != 0 || err != nil {
+		t.Errorf("bad payload = %d, %v", n, err)
+	}
+	if m := inst.v.GetMetrics(); m.DecodeErrors != 1 {
+		t.Errorf("decodeErrors = %d, want 1", m.DecodeErrors)
+	}
+	var types []viewer.MessageType
+	for frame := inst.nextOutput(); frame != nil; frame = inst.nextOutput() {
+		header, err := viewer.DecodeHeader(frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		types = append(types, header.Type)
+	}
+	if len(types) != 2 || types[0] != viewer.MsgError || types[1] != viewer.MsgRefresh {
+		t.Errorf("outbound %v, want ERROR then REFRESH", types)
+	}
+}
+
+func TestProcessBytesConcurrent(t *testing.T) {
+	inst := newInstance(80, 24)
+	s := viewer.NewSourceState()
+	s.SetTree(viewer.Column(viewer.Text("0").ID(2)).ID(1).MustBuild())
+	frames := [][]byte{encode(t, s.Flush())}
+	for i := 1; i <= 50; i++ {
+		s.Patch([]viewer.PatchOp{{Target: 2, Set: map[string]interface{}{"content": strings.Repeat("x", i)}}})
+		frames = append(frames, encode(t, s.Flush()))
+	}
+	if _, err := inst.processBytes(frames[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	// Feeds racing on the frame reader would mangle its buffer.
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	start := make(chan struct{})
+	processed := 0
+	for _, frame := range frames[1:] {
+		wg.Add(1)
+		go func(frame []byte) {
+			defer wg.Done()
+			<-start
+			n, err := inst.processBytes(frame)
+			if err != nil {
+				t.Error(err)
+			}
+			mu.Lock()
+			processed += n
+			mu.Unlock()
+		}(frame)
+	}
+	close(start)
+	wg.Wait()
+	if processed != 50 {
+		t.Errorf("processed %d messages, want 50", processed)
+	}
+	if m := inst.v.GetMetrics(); m.DecodeErrors != 0 {
+		t.Errorf("%d decode errors", m.DecodeErrors)
+	}
+}
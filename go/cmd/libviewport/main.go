@@ -0,0 +1,392 @@
+// Command libviewport exports the viewer through a C ABI so C, C++, and
+// Rust hosts can embed it as a shared library.
+//
+// Build with:
+//
+//	go build -buildmode=c-shared -o libviewport.so ./cmd/libviewport
+//
+// which also writes libviewport.h. Viewers are referred to by opaque
+// handles. Strings and buffers returned to the host are allocated with
+// malloc and must be released with viewer_free.
+package main
+
+/*
+#include <stdint.h>
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"encoding/json"
+	"math"
+	"unsafe"
+
+	viewer "github.com/anthropics/viewport/viewer"
+)
+
+// handles holds every live viewer.
+var handles = newHandleTable()
+
+// lookup returns the instance for a handle, or nil.
+func lookup(h C.uintptr_t) *instance {
+	return handles.lookup(uintptr(h))
+}
+
+// goBytes returns the n bytes at data without copying them, or false if
+// n does not fit in an int. The slice must not outlive the call.
+func goBytes(data *C.uchar, n C.size_t) ([]byte, bool) {
+	if uint64(n) > math.MaxInt {
+		return nil, false
+	}
+	if n == 0 {
+		return nil, true
+	}
+	return unsafe.Slice((*byte)(unsafe.Pointer(data)), int(n)), true
+}
+
+//export viewer_create
+func viewer_create(width, height C.int) C.uintptr_t {
+	return C.uintptr_t(handles.add(newInstance(int(width), int(height))))
+}
+
+//export viewer_destroy
+func viewer_destroy(h C.uintptr_t) {
+	if inst := handles.remove(uintptr(h)); inst != nil {
+		inst.v.Destroy()
+	}
+}
+
+// viewer_process_bytes feeds raw protocol bytes (any chunking) to the
+// viewer. Returns the number of messages processed, or -1 on a bad
+// handle, a length too large to address, or a framing error. Undecodable
+// payloads are skipped, counted, and reported to the source, which is
+// asked to resend its full state.
+// Calls on one handle may come from several threads; each is handled in
+// turn.
+//
+//export viewer_process_bytes
+func viewer_process_bytes(h C.uintptr_t, data *C.uchar, n C.size_t) C.int {
+	inst := lookup(h)
+	if inst == nil {
+		return -1
+	}
+	b, ok := goBytes(data, n)
+	if !ok {
+		return -1
+	}
+	processed, err := inst.processBytes(b)
+	if err != nil {
+		return -1
+	}
+	return C.int(processed)
+}
+
+// viewer_text_projection returns the current text projection as a
+// NUL-terminated UTF-8 string, or NULL on a bad handle.
+//
+//export viewer_text_projection
+func viewer_text_projection(h C.uintptr_t) *C.char {
+	inst := lookup(h)
+	if inst == nil {
+		return nil
+	}
+	return C.CString(inst.v.GetTextProjection())
+}
+
+// viewer_send_input injects an input event given as JSON (matching
+// InputEvent, e.g. {"target":3,"kind":"click"}). Returns 0 on success.
+//
+//export viewer_send_input
+func viewer_send_input(h C.uintptr_t, eventJSON *C.char) C.int {
+	inst := lookup(h)
+	if inst == nil {
+		return -1
+	}
+	var ev viewer.InputEvent
+	if err := json.Unmarshal([]byte(C.GoString(eventJSON)), &ev); err != nil {
+		return -1
+	}
+	inst.v.SendInput(ev)
+	return 0
+}
+
+// viewer_screenshot returns the screenshot result as JSON (format, data,
+// width, height), or NULL on a bad handle.
+//
+//export viewer_screenshot
+func viewer_screenshot(h C.uintptr_t) *C.char {
+	inst := lookup(h)
+	if inst == nil {
+		return nil
+	}
+	out, err := json.Marshal(inst.v.Screenshot())
+	if err != nil {
+		return nil
+	}
+	return C.CString(string(out))
+}
+
+// viewer_resize changes the display size and notifies the source.
+//
+//export viewer_resize
+func viewer_resize(h C.uintptr_t, width, height C.int) {
+	if inst := lookup(h); inst != nil {
+		inst.v.Resize(int(width), int(height))
+	}
+}
+
+// viewer_request_refresh asks the source to resend its full state, as
+// after reconnecting the host's transport.
+//
+//export viewer_request_refresh
+func viewer_request_refresh(h C.uintptr_t) {
+	if inst := lookup(h); inst != nil {
+		inst.v.RequestRefresh()
+	}
+}
+
+// viewer_attach_framebuffer adds a framebuffer target that renders into
+// host memory: height rows of stride bytes at pix, in format 0 (RGBA),
+// 1 (BGRA), or 2 (RGB565). The memory must stay valid until the viewer
+// is destroyed. Returns 0 on success, -1 on a bad handle or layout.
+//
+//export viewer_attach_framebuffer
+func viewer_attach_framebuffer(h C.uintptr_t, pix *C.uchar, width, height, stride, format C.int) C.int {
+	inst := lookup(h)
+	if inst == nil || pix == nil || height <= 0 || stride <= 0 {
+		return -1
+	}
+	fb := &viewer.Framebuffer{
+		Pix:    unsafe.Slice((*byte)(unsafe.Pointer(pix)), int(stride)*int(height)),
+		Width:  int(width),
+		Height: int(height),
+		Stride: int(stride),
+		Format: viewer.PixelFormat(format),
+	}
+	if fb.Validate() != nil {
+		return -1
+	}
+	inst.v.AddTarget(viewer.FramebufferTarget{Ptr: uintptr(unsafe.Pointer(pix)), Buffer: fb})
+	return 0
+}
+
+// viewer_render renders every out-of-date target, writing changed pixels
+// into attached framebuffers. Returns 1 if anything was redrawn, 0 if not,
+// or -1 on a bad handle.
+//
+//export viewer_render
+func viewer_render(h C.uintptr_t) C.int {
+	inst := lookup(h)
+	if inst == nil {
+		return -1
+	}
+	if inst.v.Render() {
+		return 1
+	}
+	return 0
+}
+
+// viewer_scroll scrolls scroll node `node` by dy pixels from a wheel or
+// touch gesture. Returns 0 on success, -1 on a bad handle or node.
+//
+//export viewer_scroll
+func viewer_scroll(h C.uintptr_t, node C.int, dy C.double) C.int {
+	inst := lookup(h)
+	if inst == nil || !inst.v.Scroll(int(node), float64(dy)) {
+		return -1
+	}
+	return 0
+}
+
+// viewer_tick advances animations; call it every frame. Returns 1
+// while an animation is running, 0 when idle, or -1 on a bad handle.
+//
+//export viewer_tick
+func viewer_tick(h C.uintptr_t) C.int {
+	inst := lookup(h)
+	if inst == nil {
+		return -1
+	}
+	if inst.v.Tick() {
+		return 1
+	}
+	return 0
+}
+
+// viewer_pointer reports a pointer event at (x, y) in input units;
+// action is 0 for down, 1 for move, 2 for up. Returns 1 if the viewer
+// consumed it (a scrollbar drag or a press on a clickable node), 0 if
+// not, or -1 on a bad handle.
+//
+//export viewer_pointer
+func viewer_pointer(h C.uintptr_t, x, y, action, button C.int) C.int {
+	inst := lookup(h)
+	if inst == nil {
+		return -1
+	}
+	if inst.v.Pointer(viewer.PointerEvent{X: int(x), Y: int(y), Action: viewer.PointerAction(action), Button: int(button)}) {
+		return 1
+	}
+	return 0
+}
+
+// viewer_touch reports finger id of a touch screen at (x, y) in input
+// units; action is 0 for down, 1 for move, 2 for up. The viewer treats
+// the first finger down like a mouse and recognizes gestures (tap,
+// long press, pinch, two-finger scroll) from all of them, sending them
+// as input events. Returns 1 if the viewer consumed it, 0 if not, or -1
+// on a bad handle.
+//
+//export viewer_touch
+func viewer_touch(h C.uintptr_t, id, x, y, action C.int) C.int {
+	inst := lookup(h)
+	if inst == nil {
+		return -1
+	}
+	if inst.v.Pointer(viewer.PointerEvent{X: int(x), Y: int(y), Action: viewer.PointerAction(action), Touch: true, ID: int(id)}) {
+		return 1
+	}
+	return 0
+}
+
+// viewer_wheel scrolls the scroll containers under (x, y) by dy input
+// units, innermost first. Returns 1 if anything scrolled, 0 if not, or -1
+// on a bad handle.
+//
+//export viewer_wheel
+func viewer_wheel(h C.uintptr_t, x, y C.int, dy C.double) C.int {
+	inst := lookup(h)
+	if inst == nil {
+		return -1
+	}
+	if inst.v.Pointer(viewer.PointerEvent{X: int(x), Y: int(y), Action: viewer.PointerWheel, DeltaY: float64(dy)}) {
+		return 1
+	}
+	return 0
+}
+
+// viewer_gamepad reports the full state of gamepad pad: buttons is a
+// GamepadButtons bitmask, the sticks run from -1 to 1 and the triggers
+// from 0 to 1. Call it whenever the state changes, and periodically while
+// a direction is held so navigation repeats. Returns 1 if the viewer
+// consumed it, 0 if not, or -1 on a bad handle.
+//
+//export viewer_gamepad
+func viewer_gamepad(h C.uintptr_t, pad, buttons C.int, lx, ly, rx, ry, lt, rt C.double) C.int {
+	inst := lookup(h)
+	if inst == nil {
+		return -1
+	}
+	state := viewer.GamepadState{
+		Buttons:      viewer.GamepadButtons(buttons),
+		LeftX:        float64(lx),
+		LeftY:        float64(ly),
+		RightX:       float64(rx),
+		RightY:       float64(ry),
+		LeftTrigger:  float64(lt),
+		RightTrigger: float64(rt),
+	}
+	if inst.v.Gamepad(int(pad), state) {
+		return 1
+	}
+	return 0
+}
+
+// viewer_key handles a key press named as in keybind slots ("tab",
+// "ctrl+s"): tab and shift+tab move focus, other keys go to the source.
+// Returns 1 if the viewer consumed it, 0 if not, or -1 on a bad handle.
+//
+//export viewer_key
+func viewer_key(h C.uintptr_t, key *C.char) C.int {
+	inst := lookup(h)
+	if inst == nil {
+		return -1
+	}
+	if inst.v.Key(C.GoString(key)) {
+		return 1
+	}
+	return 0
+}
+
+// viewer_compose shows text, which an input method is composing, in the
+// focused input with the IME's cursor at byte offset cursor; empty text
+// cancels the composition. viewer_commit ends it, entering text. Both
+// return 1 if an input is focused, 0 if not, or -1 on a bad handle.
+//
+//export viewer_compose
+func viewer_compose(h C.uintptr_t, text *C.char, cursor C.int) C.int {
+	inst := lookup(h)
+	if inst == nil {
+		return -1
+	}
+	if inst.v.Compose(C.GoString(text), int(cursor)) {
+		return 1
+	}
+	return 0
+}
+
+//export viewer_commit
+func viewer_commit(h C.uintptr_t, text *C.char) C.int {
+	inst := lookup(h)
+	if inst == nil {
+		return -1
+	}
+	if inst.v.CommitComposition(C.GoString(text)) {
+		return 1
+	}
+	return 0
+}
+
+// viewer_terminal_keys handles the keys in n bytes read from a terminal
+// in raw mode, escape sequences included. Returns how many bytes were
+// used, or -1 on a bad handle or a length over 2 GiB; the rest begin a
+// sequence cut short, to be passed again with the bytes that follow.
+//
+//export viewer_terminal_keys
+func viewer_terminal_keys(h C.uintptr_t, data *C.uchar, n C.size_t) C.int {
+	inst := lookup(h)
+	if inst == nil {
+		return -1
+	}
+	if n > math.MaxInt32 {
+		return -1
+	}
+	keys, used := viewer.ParseTerminalKeys(C.GoBytes(unsafe.Pointer(data), C.int(n)))
+	for _, k := range keys {
+		inst.v.KeyInput(k)
+	}
+	return C.int(used)
+}
+
+// viewer_next_output pops the next encoded outbound frame (input events,
+// acks, env) for the host to send to the source, and sets *n to its
+// length. Returns NULL, with *n set to 0, when none are queued or the
+// handle is invalid, and without popping anything if n is NULL.
+//
+//export viewer_next_output
+func viewer_next_output(h C.uintptr_t, n *C.size_t) *C.uchar {
+	if n == nil {
+		return nil
+	}
+	*n = 0
+	inst := lookup(h)
+	if inst == nil {
+		return nil
+	}
+
+	frame := inst.nextOutput()
+	if frame == nil {
+		return nil
+	}
+	*n = C.size_t(len(frame))
+	return (*C.uchar)(C.CBytes(frame))
+}
+
+// viewer_free releases a string or buffer returned by this library.
+//
+//export viewer_free
+func viewer_free(p unsafe.Pointer) {
+	C.free(p)
+}
+
+func main() {}
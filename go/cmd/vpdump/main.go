@@ -0,0 +1,251 @@
+// Command vpdump inspects a recorded protocol stream frame by frame.
+//
+// Usage:
+//
+//	vpdump [-q] [-outbound] [file]
+//
+// The input (a file, or stdin) is either a recording written by
+// viewer.Recorder or a raw capture of frame bytes. For each frame vpdump
+// prints the header, the CBOR payload as indented JSON, and running tree
+// statistics from a headless viewer fed the same frames. Bytes that do not
+// parse — garbage between frames, unknown versions or message types,
+// undecodable payloads, a truncated final frame — are flagged with "!!".
+// The exit status is 1 if anything was malformed.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+
+	viewer "github.com/anthropics/viewport/viewer"
+)
+
+func main() {
+	quiet := flag.Bool("q", false, "print headers and statistics only, not payloads")
+	outbound := flag.Bool("outbound", false, "also dump viewer → source chunks of a recording")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: vpdump [-q] [-outbound] [file]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	in := io.Reader(os.Stdin)
+	if flag.NArg() > 0 {
+		f, err := os.Open(flag.Arg(0))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "vpdump:", err)
+			os.Exit(2)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	data, err := io.ReadAll(in)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "vpdump:", err)
+		os.Exit(2)
+	}
+
+	d := &dumper{
+		out:   os.Stdout,
+		quiet: *quiet,
+		state: viewer.NewViewer(viewer.HeadlessTarget{}),
+	}
+	d.dump(data, *outbound)
+	fmt.Fprintf(d.out, "\n%d frames, %d bytes, %d malformed\n", d.frames, len(data), d.malformed)
+	if d.malformed > 0 {
+		os.Exit(1)
+	}
+}
+
+// dumper holds running state across chunks.
+type dumper struct {
+	out       io.Writer
+	quiet     bool
+	frames    int
+	malformed int
+
+	// Each direction is parsed as its own stream.
+	pending map[viewer.Direction][]byte
+	offsets map[viewer.Direction]int
+
+	// Fed inbound frames for running tree statistics.
+	state *viewer.Viewer
+}
+
+// dump parses data as a recording if it has the recording magic, or as
+// raw frames otherwise.
+func (d *dumper) dump(data []byte, outbound bool) {
+	d.pending = make(map[viewer.Direction][]byte)
+	d.offsets = make(map[viewer.Direction]int)
+
+	if !viewer.IsRecording(data) {
+		d.feed(viewer.RecordedChunk{Dir: viewer.DirInbound, Data: data})
+		d.finish()
+		return
+	}
+
+	rr, _ := viewer.NewRecordingReader(bytes.NewReader(data))
+	for {
+		chunk, err := rr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			d.flag("recording: %v", err)
+			break
+		}
+		if chunk.Dir == viewer.DirOutbound && !outbound {
+			continue
+		}
+		d.feed(chunk)
+	}
+	d.finish()
+}
+
+// feed appends a chunk to its direction's stream and dumps every complete
+// frame.
+func (d *dumper) feed(chunk viewer.RecordedChunk) {
+	buf := append(d.pending[chunk.Dir], chunk.Data...)
+	for len(buf) >= viewer.HeaderSize {
+		header, err := viewer.DecodeHeader(buf)
+		if errors.Is(err, viewer.ErrBadMagic) {
+			skip := resync(buf)
+			d.flag("%s offset %d: skipped %d bytes without frame magic", chunk.Dir, d.offsets[chunk.Dir], skip)
+			d.offsets[chunk.Dir] += skip
+			buf = buf[skip:]
+			continue
+		}
+		if errors.Is(err, viewer.ErrUnsupportedVersion) {
+			skip := resync(buf)
+			d.flag("%s offset %d: skipped %d bytes of a frame with %v", chunk.Dir, d.offsets[chunk.Dir], skip, err)
+			d.offsets[chunk.Dir] += skip
+			buf = buf[skip:]
+			continue
+		}
+		if err != nil {
+			break // partial v2 header
+		}
+		size := header.Size() + int(header.Length)
+		if len(buf) < size {
+			break
+		}
+		d.frame(chunk, header, buf[header.Size():size])
+		d.offsets[chunk.Dir] += size
+		buf = buf[size:]
+	}
+	d.pending[chunk.Dir] = buf
+}
+
+// finish flags bytes left over at the end of each stream.
+func (d *dumper) finish() {
+	for _, dir := range []viewer.Direction{viewer.DirInbound, viewer.DirOutbound} {
+		if buf := d.pending[dir]; len(buf) > 0 {
+			d.flag("%s offset %d: truncated frame, %d trailing bytes", dir, d.offsets[dir], len(buf))
+		}
+	}
+}
+
+// frame prints one frame and applies it to the direction's viewer.
+func (d *dumper) frame(chunk viewer.RecordedChunk, header *viewer.FrameHeader, payload []byte) {
+	d.frames++
+	n := d.frames
+
+	fmt.Fprintf(d.out, "#%d %s %s v%d %s (0x%02x) len=%d",
+		n, formatAt(chunk.At), chunk.Dir, header.Version, header.Type, uint8(header.Type), header.Length)
+	if header.Version >= viewer.SessionProtocolVersion {
+		fmt.Fprintf(d.out, " session=%d seq=%d", header.Session, header.Seq)
+	}
+	fmt.Fprintln(d.out)
+
+	if header.Version != viewer.ProtocolVersion && header.Version != viewer.SessionProtocolVersion {
+		d.flag("#%d: unknown protocol version %d", n, header.Version)
+	}
+	if !header.Type.Known() {
+		d.flag("#%d: unknown message type 0x%02x", n, uint8(header.Type))
+	}
+
+	var generic interface{}
+	if err := cbor.Unmarshal(payload, &generic); err != nil {
+		d.flag("#%d: payload: %v", n, err)
+		return
+	}
+	if !d.quiet {
+		pretty, err := json.MarshalIndent(jsonValue(generic), "  ", "  ")
+		if err != nil {
+			d.flag("#%d: payload: %v", n, err)
+		} else {
+			fmt.Fprintf(d.out, "  %s\n", pretty)
+		}
+	}
+
+	msg, err := viewer.DecodeMessage(header, payload)
+	if err != nil {
+		d.flag("#%d: %v", n, err)
+		return
+	}
+	if chunk.Dir == viewer.DirOutbound {
+		return
+	}
+	d.state.ProcessMessage(msg)
+	m := d.state.GetMetrics()
+	fmt.Fprintf(d.out, "  tree: nodes=%d depth=%d slots=%d rows=%d\n",
+		m.TreeNodeCount, m.TreeDepth, m.SlotCount, m.DataRowCount)
+}
+
+// flag reports a malformed frame or region.
+func (d *dumper) flag(format string, args ...interface{}) {
+	d.malformed++
+	fmt.Fprintf(d.out, "!! "+format+"\n", args...)
+}
+
+// resync returns how many bytes to skip to reach the next frame magic.
+func resync(buf []byte) int {
+	for i := 1; i+1 < len(buf); i++ {
+		if uint16(buf[i])<<8|uint16(buf[i+1]) == viewer.Magic {
+			return i
+		}
+	}
+	return len(buf)
+}
+
+// formatAt formats a recording offset; raw captures have none.
+func formatAt(at time.Duration) string {
+	return fmt.Sprintf("+%.3fs", at.Seconds())
+}
+
+// jsonValue converts decoded CBOR into values encoding/json accepts:
+// non-string map keys are stringified and byte strings summarized.
+func jsonValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(x))
+		for k, val := range x {
+			m[fmt.Sprint(k)] = jsonValue(val)
+		}
+		return m
+	case []interface{}:
+		for i := range x {
+			x[i] = jsonValue(x[i])
+		}
+		return x
+	case []byte:
+		return fmt.Sprintf("<%d bytes>", len(x))
+	case cbor.Tag:
+		return map[string]interface{}{"tag": x.Number, "content": jsonValue(x.Content)}
+	case float64:
+		if math.IsNaN(x) || math.IsInf(x, 0) {
+			return fmt.Sprint(x) // not representable in JSON
+		}
+	}
+	return v
+}
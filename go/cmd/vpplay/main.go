@@ -0,0 +1,283 @@
+// Command vpplay replays a recorded session into a live ANSI viewer.
+//
+// Usage:
+//
+//	vpplay [-speed N] [-paused] file
+//
+// Frames the source sent are applied at their recorded times, scaled by
+// -speed. Raw frame captures without timing play back one frame per step.
+// Keys while playing:
+//
+//	space   pause / resume
+//	n .     step one frame (pauses)
+//	+ -     double / halve speed
+//	q       quit
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	viewer "github.com/anthropics/viewport/viewer"
+)
+
+// timedFrame is a decoded frame with its offset in the recording.
+type timedFrame struct {
+	at  time.Duration
+	msg viewer.ProtocolMessage
+}
+
+func main() {
+	speed := flag.Float64("speed", 1, "playback speed multiplier")
+	paused := flag.Bool("paused", false, "start paused")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: vpplay [-speed N] [-paused] file")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 || *speed <= 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	data, err := os.ReadFile(flag.Arg(0))
+	if err != nil {
+		fail(err)
+	}
+	frames, timed, loadErr := loadFrames(data)
+	if loadErr != nil && len(frames) == 0 {
+		fail(loadErr)
+	}
+
+	width, height, err := viewer.TerminalSize(int(os.Stdout.Fd()))
+	if err != nil || width == 0 || height < 2 {
+		width, height = 80, 24
+	}
+	restore, err := viewer.MakeRaw(int(os.Stdin.Fd()))
+	if err == nil {
+		defer restore()
+	}
+
+	p := &player{
+		frames: frames,
+		speed:  *speed,
+		paused: *paused || !timed,
+		height: height,
+	}
+	if loadErr != nil {
+		// Play what could be read, but say it is not everything.
+		p.warning = loadErr.Error()
+	}
+	p.start(os.Stdout, width, height-1) // last row is the status line
+	defer p.term.Stop()
+	p.run(readKeys(os.Stdin))
+}
+
+// errTruncated reports a recording or capture that ends mid-frame.
+var errTruncated = errors.New("recording ends mid-frame")
+
+// loadFrames decodes the source → viewer frames of a recording, or of a
+// raw capture (reported as untimed). If the data is truncated or corrupt,
+// the frames before the damage are returned with the error.
+func loadFrames(data []byte) (frames []timedFrame, timed bool, err error) {
+	fr := viewer.NewFrameReader()
+	add := func(at time.Duration, chunk []byte) error {
+		batch, err := fr.Feed(chunk)
+		for _, f := range batch {
+			msg, err := viewer.DecodeMessage(f.Header, f.Payload)
+			if err != nil {
+				continue // vpdump reports these
+			}
+			frames = append(frames, timedFrame{at: at, msg: msg})
+		}
+		return err
+	}
+
+	if !viewer.IsRecording(data) {
+		if err := add(0, data); err != nil {
+			return frames, false, err
+		}
+		if fr.PendingBytes() > 0 {
+			return frames, false, errTruncated
+		}
+		return frames, false, nil
+	}
+	rr, err := viewer.NewRecordingReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, false, err
+	}
+	for {
+		chunk, err := rr.Next()
+		if err == io.EOF {
+			if fr.PendingBytes() > 0 {
+				return frames, true, errTruncated
+			}
+			return frames, true, nil
+		}
+		if err != nil {
+			return frames, true, fmt.Errorf("reading recording: %w", err)
+		}
+		if chunk.Dir != viewer.DirInbound {
+			continue
+		}
+		if err := add(chunk.At, chunk.Data); err != nil {
+			return frames, true, err
+		}
+	}
+}
+
+// player drives playback.
+type player struct {
+	frames []timedFrame
+	next   int
+	pos    time.Duration // playback position in recording time
+	speed  float64
+	paused bool
+
+	// warning is shown on the status line, for a recording that could
+	// not be read to the end.
+	warning string
+
+	v      *viewer.Viewer
+	term   *viewer.Terminal
+	width  int // terminal columns, for the status line
+	height int
+	out    io.Writer
+}
+
+// start creates the viewer and takes over the terminal tty.
+func (p *player) start(tty *os.File, width, height int) {
+	p.out, p.width = tty, width
+	target := viewer.AnsiTarget{FD: int(tty.Fd())}
+	p.v = viewer.NewViewer(target)
+	p.v.Init(viewer.EnvInfo{
+		ViewportVersion: viewer.ProtocolVersion,
+		DisplayWidth:    width * 8,
+		DisplayHeight:   height * 16,
+		ColorDepth:      24,
+	})
+	p.term = viewer.NewTerminal(tty, width, height)
+	p.v.AttachTerminal(target, p.term)
+	p.term.Start()
+	p.v.Render()
+	p.status()
+}
+
+// run plays frames until the user quits.
+func (p *player) run(keys <-chan byte) {
+	for {
+		var timer <-chan time.Time
+		waitStart := time.Now()
+		if wait, ok := p.untilNext(); ok {
+			timer = time.After(wait)
+		}
+
+		select {
+		case <-timer:
+			p.step()
+
+		case key, ok := <-keys:
+			if !ok {
+				return
+			}
+			if timer != nil {
+				p.advance(time.Since(waitStart))
+			}
+			if !p.key(key) {
+				return
+			}
+			p.status()
+		}
+	}
+}
+
+// untilNext returns the real time until the next frame is due, or false
+// if playback is paused or over.
+func (p *player) untilNext() (time.Duration, bool) {
+	if p.paused || p.next >= len(p.frames) {
+		return 0, false
+	}
+	return time.Duration(float64(p.frames[p.next].at-p.pos) / p.speed), true
+}
+
+// advance moves the playback position on by elapsed real time.
+func (p *player) advance(elapsed time.Duration) {
+	p.pos += time.Duration(float64(elapsed) * p.speed)
+}
+
+// key handles a key press. Returns false to quit.
+func (p *player) key(key byte) bool {
+	switch key {
+	case 'q', 3: // q, Ctrl-C
+		return false
+	case ' ':
+		p.paused = !p.paused
+	case 'n', '.':
+		p.paused = true
+		p.step()
+	case '+':
+		p.speed *= 2
+	case '-':
+		p.speed /= 2
+	}
+	return true
+}
+
+// step applies the next frame and redraws.
+func (p *player) step() {
+	if p.next >= len(p.frames) {
+		return
+	}
+	f := p.frames[p.next]
+	p.next++
+	if f.at > p.pos {
+		p.pos = f.at
+	}
+	p.v.ProcessMessage(f.msg)
+	p.v.Render()
+	p.status()
+}
+
+// status draws the status line on the terminal's last row.
+func (p *player) status() {
+	state := "playing"
+	switch {
+	case p.next >= len(p.frames) && p.warning != "":
+		state = "end (" + p.warning + ")"
+	case p.next >= len(p.frames):
+		state = "end"
+	case p.paused:
+		state = "paused"
+	}
+	line := fmt.Sprintf(" %s  frame %d/%d  %.3fs  %gx  [space] pause  [n] step  [+/-] speed  [q] quit",
+		state, p.next, len(p.frames), p.pos.Seconds(), p.speed)
+	// A line wider than the terminal would wrap and scroll the view.
+	line = viewer.TruncateWidth(line, p.width, "…")
+	fmt.Fprintf(p.out, "\x1b[%d;1H\x1b[7m%s\x1b[0m\x1b[K", p.height, line)
+}
+
+// readKeys delivers bytes typed on in until it closes.
+func readKeys(in io.Reader) <-chan byte {
+	keys := make(chan byte)
+	go func() {
+		defer close(keys)
+		buf := make([]byte, 1)
+		for {
+			if _, err := in.Read(buf); err != nil {
+				return
+			}
+			keys <- buf[0]
+		}
+	}()
+	return keys
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "vpplay:", err)
+	os.Exit(1)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	viewer "github.com/anthropics/viewport/viewer"
+)
+
+// sourceFrames returns a source's first flush and a patch as wire frames.
+func sourceFrames(t *testing.T) [][]byte {
+	t.Helper()
+	s := viewer.NewSourceState()
+	s.SetTree(viewer.Column(viewer.Text("one").ID(2)).ID(1).MustBuild())
+	first := s.Flush()
+	s.Patch([]viewer.PatchOp{{Target: 2, Set: map[string]interface{}{"content": "two"}}})
+	var out [][]byte
+	for _, msg := range append(first, s.Flush()...) {
+		frame, err := viewer.EncodeFrame(&msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, frame)
+	}
+	return out
+}
+
+// record writes chunks at the given offsets as a recording.
+func record(t *testing.T, at []time.Duration, dirs []viewer.Direction, chunks [][]byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	clock := &viewer.ManualClock{T: time.Unix(0, 0)}
+	rec, err := viewer.NewRecorder(&buf, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, chunk := range chunks {
+		clock.T = time.Unix(0, 0).Add(at[i])
+		if err := rec.Record(dirs[i], chunk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestLoadFrames(t *testing.T) {
+	src := sourceFrames(t)
+	raw := bytes.Join(src, nil)
+
+	frames, timed, err := loadFrames(raw)
+	if err != nil || timed || len(frames) != 2 {
+		t.Fatalf("raw capture = %d frames, timed %v, err %v", len(frames), timed, err)
+	}
+	frames, _, err = loadFrames(raw[:len(raw)-1])
+	if !errors.Is(err, errTruncated) || len(frames) != 1 {
+		t.Errorf("cut raw capture = %d frames, err %v", len(frames), err)
+	}
+
+	ack := []byte("ack")
+	data := record(t,
+		[]time.Duration{0, 10 * time.Millisecond, 250 * time.Millisecond},
+		[]viewer.Direction{viewer.DirInbound, viewer.DirOutbound, viewer.DirInbound},
+		[][]byte{src[0], ack, src[1]})
+	frames, timed, err = loadFrames(data)
+	if err != nil || !timed || len(frames) != 2 || frames[1].at != 250*time.Millisecond {
+		t.Fatalf("recording = %+v, timed %v, err %v", frames, timed, err)
+	}
+
+	// A recording cut off mid-chunk plays what came before, and says so.
+	frames, _, err = loadFrames(data[:len(data)-1])
+	if !errors.Is(err, io.ErrUnexpectedEOF) || len(frames) != 1 {
+		t.Errorf("cut recording = %d frames, err %v", len(frames), err)
+	}
+	// So does one whose last chunk holds only part of a frame.
+	cut := record(t, []time.Duration{0, time.Second},
+		[]viewer.Direction{viewer.DirInbound, viewer.DirInbound},
+		[][]byte{src[0], src[1][:len(src[1])-1]})
+	if frames, _, err = loadFrames(cut); !errors.Is(err, errTruncated) || len(frames) != 1 {
+		t.Errorf("mid-frame recording = %d frames, err %v", len(frames), err)
+	}
+
+	// Framing that cannot be read stops loading there.
+	bad := append([]byte{}, src[1]...)
+	bad[2] = 9 // header version
+	if frames, _, err = loadFrames(append(append([]byte{}, src[0]...), bad...)); !errors.Is(err, viewer.ErrUnsupportedVersion) || len(frames) != 1 {
+		t.Errorf("bad framing = %d frames, err %v", len(frames), err)
+	}
+}
+
+func TestPlayerTiming(t *testing.T) {
+	data := record(t,
+		[]time.Duration{0, time.Second},
+		[]viewer.Direction{viewer.DirInbound, viewer.DirInbound},
+		sourceFrames(t))
+	frames, _, err := loadFrames(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	p := &player{frames: frames, speed: 2, width: 80, height: 24, out: &out, v: viewer.NewViewer(viewer.HeadlessTarget{})}
+
+	if wait, ok := p.untilNext(); !ok || wait != 0 {
+		t.Errorf("first wait = %v, %v", wait, ok)
+	}
+	p.step()
+	if wait, ok := p.untilNext(); !ok || wait != 500*time.Millisecond {
+		t.Errorf("wait at 2x = %v, %v; want 500ms", wait, ok)
+	}
+
+	// Time spent waiting counts toward the next frame, at speed.
+	p.advance(200 * time.Millisecond)
+	p.key('-')
+	if wait, _ := p.untilNext(); wait != 600*time.Millisecond {
+		t.Errorf("wait after 200ms then 1x = %v, want 600ms", wait)
+	}
+
+	// Pausing stops the clock; stepping applies the next frame at once.
+	p.key(' ')
+	if _, ok := p.untilNext(); ok {
+		t.Error("paused player still waiting for a frame")
+	}
+	p.key(' ')
+	p.key('n')
+	if !p.paused || p.next != 2 || p.pos != time.Second {
+		t.Errorf("after step: paused %v, next %d, pos %v", p.paused, p.next, p.pos)
+	}
+	if got := p.v.GetTextProjection(); got != "two" {
+		t.Errorf("projection %q", got)
+	}
+	if _, ok := p.untilNext(); ok {
+		t.Error("waiting past the last frame")
+	}
+	if p.key('q') {
+		t.Error("q did not quit")
+	}
+
+	p.warning = "recording ends mid-frame"
+	p.status()
+	if !strings.Contains(out.String(), "end (recording ends mid-frame)") {
+		t.Errorf("status line does not mention the truncation: %q", out.String())
+	}
+
+	// The status line fits the terminal rather than wrap.
+	out.Reset()
+	p.width = 30
+	p.status()
+	line := strings.TrimSuffix(strings.TrimPrefix(out.String(), "\x1b[24;1H\x1b[7m"), "\x1b[0m\x1b[K")
+	if viewer.StringWidth(line) != 30 || !strings.HasSuffix(line, "…") {
+		t.Errorf("status line %q is %d wide, want 30", line, viewer.StringWidth(line))
+	}
+}
@@ -0,0 +1,77 @@
+// Command vpscript runs a viewer automation script against a live source.
+//
+// Usage:
+//
+//	vpscript [-timeout 5s] [-width 800 -height 600] [-v] script.vps
+//
+// See viewer.ScriptRunner for the script language. Relative paths in the
+// script resolve against the script's directory. The exit status is 1 if a
+// step fails.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
+
+	viewer "github.com/anthropics/viewport/viewer"
+)
+
+func main() {
+	timeout := flag.Duration("timeout", 5*time.Second, "default wait-text timeout")
+	width := flag.Int("width", 800, "display width reported to the source")
+	height := flag.Int("height", 600, "display height reported to the source")
+	verbose := flag.Bool("v", false, "log each completed step")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: vpscript [flags] script.vps")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	f, err := os.Open(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "vpscript:", err)
+		os.Exit(2)
+	}
+	script, err := viewer.ParseScript(f)
+	f.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "vpscript: %s: %v\n", flag.Arg(0), err)
+		os.Exit(2)
+	}
+
+	v := viewer.NewViewer(viewer.HeadlessTarget{})
+	v.Init(viewer.EnvInfo{
+		ViewportVersion: viewer.ProtocolVersion,
+		DisplayWidth:    *width,
+		DisplayHeight:   *height,
+		ColorDepth:      24,
+	})
+
+	runner := &viewer.ScriptRunner{
+		Viewer:  v,
+		Timeout: *timeout,
+		Dir:     filepath.Dir(flag.Arg(0)),
+		Log:     io.Discard,
+	}
+	if *verbose {
+		runner.Log = os.Stderr
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := runner.Run(ctx, script); err != nil {
+		fmt.Fprintf(os.Stderr, "FAIL %s: %v\n", flag.Arg(0), err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "PASS %s (%d steps)\n", flag.Arg(0), len(script.Steps))
+}
@@ -0,0 +1,72 @@
+//go:build js && wasm
+
+// Command vpwasm runs the viewer client-side in a web page.
+//
+// Build with:
+//
+//	GOOS=js GOARCH=wasm go build -o viewport.wasm ./cmd/vpwasm
+//
+// and load it with wasm_exec.js. The page sets window.viewportContainer to
+// the ID of the element to render into (default "viewport"), then feeds
+// protocol bytes with viewportFeed(uint8Array). Outbound messages (input
+// events, acks) are delivered as encoded frames to window.viewportSend if
+// it is defined.
+package main
+
+import (
+	"syscall/js"
+
+	viewer "github.com/anthropics/viewport/viewer"
+)
+
+func main() {
+	container := "viewport"
+	if c := js.Global().Get("viewportContainer"); c.Type() == js.TypeString {
+		container = c.String()
+	}
+
+	v := viewer.NewViewer(viewer.HtmlTarget{Container: container})
+	v.OnMessage(func(msg viewer.ProtocolMessage) {
+		send := js.Global().Get("viewportSend")
+		if send.Type() != js.TypeFunction {
+			return
+		}
+		frame, err := viewer.EncodeFrame(&msg)
+		if err != nil {
+			return
+		}
+		buf := js.Global().Get("Uint8Array").New(len(frame))
+		js.CopyBytesToJS(buf, frame)
+		send.Invoke(buf)
+	})
+
+	// Chunks are processed in order by a single goroutine so JS callbacks
+	// never block on the viewer.
+	chunks := make(chan []byte, 64)
+	go func() {
+		fr := viewer.NewFrameReader()
+		for data := range chunks {
+			frames, err := fr.Feed(data)
+			if err != nil {
+				continue
+			}
+			v.TrackBytes(len(data))
+			for _, f := range frames {
+				if msg, err := viewer.DecodeMessage(f.Header, f.Payload); err == nil {
+					v.ProcessMessage(msg)
+				}
+			}
+			v.Render()
+		}
+	}()
+
+	feed := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		data := make([]byte, args[0].Get("length").Int())
+		js.CopyBytesToGo(data, args[0])
+		chunks <- data
+		return nil
+	})
+	js.Global().Set("viewportFeed", feed)
+
+	select {}
+}
@@ -0,0 +1,467 @@
+package viewer
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Retained components on top of SourceState:
+//
+//	type counter struct{ n int }
+//
+//	func (c *counter) Render() *VNode {
+//		return Column(Text(fmt.Sprint(c.n)).ID(2)).ID(1).MustBuild()
+//	}
+//
+//	root := Mount(state, &counter{})
+//	root.Update(func() { c.n++ })
+//	send(root.Flush())
+//
+// The first render is sent as a full tree. After that each render is
+// diffed against the previous one by node ID and only the differences are
+// queued as patches, so components should give their nodes stable IDs,
+// most simply by building with BuildWith(state.IDs()). IDs that drop out
+// of a render are released back to the state's allocator.
+
+// Component is a piece of source-side UI that renders itself to a VNode
+// tree. Render must not modify state and may be called at any time; it
+// should return a fresh tree each call rather than mutating the last one.
+type Component interface {
+	Render() *VNode
+}
+
+// ComponentFunc adapts a function to the Component interface.
+type ComponentFunc func() *VNode
+
+// Render calls f.
+func (f ComponentFunc) Render() *VNode { return f() }
+
+// Root mounts a component on a SourceState and keeps the component's
+// last rendered output. It is safe for concurrent use; the SourceState
+// must only be used through the Root while mounted.
+type Root struct {
+	mu        sync.Mutex
+	state     *SourceState
+	component Component
+	prev      *VNode
+	dirty     bool
+	notify    func()
+}
+
+// Mount attaches component to state. Nothing is rendered until the first
+// Flush or Render. component may be nil for apps that only drive the
+// state directly through State.
+func Mount(state *SourceState, component Component) *Root {
+	return &Root{state: state, component: component, dirty: true}
+}
+
+// Invalidate marks the component as needing a re-render.
+func (r *Root) Invalidate() {
+	r.mu.Lock()
+	r.dirty = true
+	r.changed()
+	r.mu.Unlock()
+}
+
+// Update runs fn, which may modify the component's state, and marks the
+// component as needing a re-render. fn runs with the Root locked, so
+// concurrent Updates and Flushes do not interleave.
+func (r *Root) Update(fn func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if fn != nil {
+		fn()
+	}
+	r.dirty = true
+	r.changed()
+}
+
+// Render re-renders the component if it is invalidated and queues the
+// difference from the previous render on the SourceState.
+func (r *Root) Render() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.render()
+}
+
+// Flush renders if needed and flushes the SourceState, returning the
+// messages to send.
+func (r *Root) Flush() []ProtocolMessage {
+	return r.FlushWithin(FlushBudget{})
+}
+
+// FlushWithin is Flush limited by a budget; see SourceState.FlushWithin.
+func (r *Root) FlushWithin(b FlushBudget) []ProtocolMessage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.render()
+	return r.state.FlushWithin(b)
+}
+
+// State calls fn with the underlying SourceState, for control messages
+// and slot definitions.
+func (r *Root) State(fn func(s *SourceState)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fn(r.state)
+	r.changed()
+}
+
+// pending reports whether a render or flush has anything to send, and
+// whether flow control allows sending it.
+func (r *Root) pending() (pending, canSend bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.dirty || r.state.HasPending(), r.state.CanSend()
+}
+
+// changed tells a scheduler driving the Root that there may be work.
+// Must be called with the mutex held.
+func (r *Root) changed() {
+	if r.notify != nil {
+		r.notify()
+	}
+}
+
+// render is Render with the mutex held.
+func (r *Root) render() {
+	if !r.dirty || r.component == nil {
+		r.dirty = false
+		return
+	}
+	r.dirty = false
+	next := r.component.Render()
+	if next == nil {
+		return
+	}
+	if r.state.interner != nil {
+		next = r.state.interner.Intern(next)
+	}
+	if r.state.published.Root == nil && r.state.pending.tree == nil {
+		// Nothing sent yet, or the state was Reset for a new viewer.
+		r.prev = nil
+	}
+	if ops, ok := DiffTree(r.prev, next); ok {
+		r.state.Patch(ops)
+	} else {
+		r.state.SetTree(next)
+	}
+	if r.state.ids != nil && r.prev != nil {
+		releaseRemoved(r.state.ids, r.prev, next)
+	}
+	r.prev = next
+}
+
+// releaseRemoved releases the IDs of nodes in prev that are not in next.
+func releaseRemoved(ids *IDAllocator, prev, next *VNode) {
+	present := make(map[int]bool)
+	var mark func(*VNode)
+	mark = func(n *VNode) {
+		present[n.ID] = true
+		for _, c := range n.Children {
+			mark(c)
+		}
+	}
+	mark(next)
+	var release func(*VNode)
+	release = func(n *VNode) {
+		if !present[n.ID] {
+			ids.Release(n.ID)
+		}
+		for _, c := range n.Children {
+			release(c)
+		}
+	}
+	release(prev)
+}
+
+// ── Reconciliation ───────────────────────────────────────────────────
+
+// DiffTree computes patch operations that turn prev into next, matching
+// nodes by ID. It returns ok=false when the trees cannot be reconciled
+// with patches (no previous tree, or the root's ID or type changed), in
+// which case next should be sent as a full tree.
+//
+// A child whose ID moves to a different parent, or whose type changes,
+// is removed and reinserted. Removals are emitted before anything else
+// so an ID is never present twice in the viewer's index. A node none of
+// whose children survive has them all replaced by one ChildrenSet.
+func DiffTree(prev, next *VNode) (ops []PatchOp, ok bool) {
+	if prev == nil || next == nil || prev.ID != next.ID || prev.Type != next.Type {
+		return nil, false
+	}
+	d := &differ{next: make(map[int]bool)}
+	var mark func(*VNode)
+	mark = func(n *VNode) {
+		d.next[n.ID] = true
+		for _, c := range n.Children {
+			mark(c)
+		}
+	}
+	mark(next)
+	d.removals(prev, next)
+	d.update(prev, next)
+	return d.ops, true
+}
+
+type differ struct {
+	ops  []PatchOp
+	next map[int]bool // IDs in the next tree
+}
+
+// replacesChildren reports whether next's children replace all of prev's
+// with a ChildrenSet: none survive, it saves ops, and no node under prev's
+// children is still in the next tree, so none is reinserted elsewhere
+// before the set removes it.
+func (d *differ) replacesChildren(prev, next *VNode) bool {
+	if len(prev.Children)+len(next.Children) < 2 || len(survivors(prev, next)) > 0 {
+		return false
+	}
+	var reused func(*VNode) bool
+	reused = func(n *VNode) bool {
+		if d.next[n.ID] {
+			return true
+		}
+		for _, c := range n.Children {
+			if reused(c) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, c := range prev.Children {
+		if reused(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// removals emits ChildrenRemove ops for children of prev that do not
+// survive into next, one ChildrenRemoveRange for each run of them, then
+// recurses into the survivors.
+func (d *differ) removals(prev, next *VNode) {
+	if d.replacesChildren(prev, next) {
+		return
+	}
+	keep := survivors(prev, next)
+	for i := len(prev.Children) - 1; i >= 0; i-- {
+		if keep[prev.Children[i].ID] != nil {
+			continue
+		}
+		start := i
+		for start > 0 && keep[prev.Children[start-1].ID] == nil {
+			start--
+		}
+		if start == i {
+			d.ops = append(d.ops, PatchOp{Target: prev.ID, ChildrenRemove: &ChildrenRemove{Index: i}})
+		} else {
+			d.ops = append(d.ops, PatchOp{Target: prev.ID, ChildrenRemoveRange: &ChildrenRemoveRange{Index: start, Count: i - start + 1}})
+		}
+		i = start
+	}
+	for _, c := range prev.Children {
+		if n := keep[c.ID]; n != nil {
+			d.removals(c, n)
+		}
+	}
+}
+
+// update emits Set ops for prev's changed properties, then moves and
+// inserts children into next's order and recurses into the survivors.
+// Children that stay together move together with a ChildrenMoveRange.
+// prev's removed children must already be gone.
+func (d *differ) update(prev, next *VNode) {
+	set, unset, replace := diffProps(prev, next)
+	if replace {
+		d.ops = append(d.ops, PatchOp{Target: next.ID, Replace: next})
+		return
+	}
+	if len(set) > 0 || len(unset) > 0 {
+		d.ops = append(d.ops, PatchOp{Target: next.ID, Set: set, Unset: unset})
+	}
+	if d.replacesChildren(prev, next) {
+		d.ops = append(d.ops, PatchOp{Target: next.ID, ChildrenSet: &ChildrenSet{Nodes: next.Children}})
+		return
+	}
+
+	keep := survivors(prev, next)
+	old := make(map[int]*VNode)
+	var order []int
+	for _, c := range prev.Children {
+		if keep[c.ID] != nil {
+			old[c.ID] = c
+			order = append(order, c.ID)
+		}
+	}
+	for i := 0; i < len(next.Children); i++ {
+		c := next.Children[i]
+		p := old[c.ID]
+		if p == nil {
+			d.ops = append(d.ops, PatchOp{Target: next.ID, ChildrenInsert: &ChildrenInsert{Index: i, Node: c}})
+			order = insertAt(order, i, c.ID)
+			continue
+		}
+		from := indexOf(order, c.ID)
+		if from == i {
+			d.update(p, c)
+			continue
+		}
+		// The children after it in both orders move with it.
+		n := 1
+		for i+n < len(next.Children) && from+n < len(order) && order[from+n] == next.Children[i+n].ID {
+			n++
+		}
+		if n == 1 {
+			d.ops = append(d.ops, PatchOp{Target: next.ID, ChildrenMove: &ChildrenMove{From: from, To: i}})
+		} else {
+			d.ops = append(d.ops, PatchOp{Target: next.ID, ChildrenMoveRange: &ChildrenMoveRange{From: from, Count: n, To: i}})
+		}
+		run := append([]int{}, order[from:from+n]...)
+		order = append(order[:from], order[from+n:]...)
+		order = append(order[:i], append(run, order[i:]...)...)
+		for _, c := range next.Children[i : i+n] {
+			d.update(old[c.ID], c)
+		}
+		i += n - 1
+	}
+}
+
+// survivors maps the IDs of prev's children that remain children of next,
+// with the same type, to their new version.
+func survivors(prev, next *VNode) map[int]*VNode {
+	byID := make(map[int]*VNode, len(next.Children))
+	for _, c := range next.Children {
+		byID[c.ID] = c
+	}
+	keep := make(map[int]*VNode, len(prev.Children))
+	for _, c := range prev.Children {
+		if n := byID[c.ID]; n != nil && n.Type == c.Type {
+			keep[c.ID] = n
+		}
+	}
+	return keep
+}
+
+// unsettableProps are the keys applyPropsSet does not handle; a change
+// to one of them forces the node to be replaced.
+var unsettableProps = map[string]bool{"border": true, "shadow": true}
+
+// diffProps returns the Set map and Unset keys that turn prev's props
+// into next's, or replace=true if a change cannot be expressed with them.
+// Removed props a Set can clear are cleared by it; the rest are unset.
+func diffProps(prev, next *VNode) (set map[string]interface{}, unset []string, replace bool) {
+	before, after := propsMap(prev), propsMap(next)
+	put := func(k string, v interface{}) {
+		if set == nil {
+			set = make(map[string]interface{})
+		}
+		set[k] = v
+	}
+	for k, v := range after {
+		if old, ok := before[k]; !ok || !reflect.DeepEqual(old, v) {
+			if unsettableProps[k] {
+				return nil, nil, true
+			}
+			put(k, v)
+		}
+	}
+	for k := range before {
+		if _, ok := after[k]; ok {
+			continue
+		}
+		if zero, ok := clearValue(k); ok {
+			put(k, zero)
+		} else {
+			unset = append(unset, k)
+		}
+	}
+	sort.Strings(unset)
+	return set, unset, false
+}
+
+// pointerDefaults are the pointer props a Set clears by setting their
+// default, so that showing a hidden node or ending a transform is a Set
+// rather than a Replace.
+var pointerDefaults = map[string]interface{}{"hidden": false, "transform": Transform{}}
+
+// clearValue returns the Set value that removes property key: "" for
+// string props, nil for untyped ones and Extra keys, and the default of a
+// pointerDefaults prop. Other pointer props must be unset instead.
+func clearValue(key string) (interface{}, bool) {
+	if v, ok := pointerDefaults[key]; ok {
+		return v, true
+	}
+	f, ok := propFields[key]
+	if !ok {
+		return nil, true
+	}
+	switch f.Type.Kind() {
+	case reflect.String:
+		return "", true
+	case reflect.Interface:
+		return nil, true
+	}
+	return nil, false
+}
+
+// propFields maps wire keys to NodeProps fields.
+var propFields = func() map[string]reflect.StructField {
+	m := make(map[string]reflect.StructField)
+	rt := reflect.TypeOf(NodeProps{})
+	for i := 0; i < rt.NumField(); i++ {
+		f := rt.Field(i)
+		if key, _, _ := strings.Cut(f.Tag.Get("json"), ","); key != "" && key != "-" {
+			m[key] = f
+		}
+	}
+	return m
+}()
+
+// propsMap flattens a node's set properties into wire keys, dereferencing
+// pointers, in the form applyPropsSet accepts.
+func propsMap(node *VNode) map[string]interface{} {
+	m := propValues(node.Props)
+	if node.TextAlt != nil {
+		m["textAlt"] = *node.TextAlt
+	}
+	return m
+}
+
+// propValues is propsMap for bare props.
+func propValues(props NodeProps) map[string]interface{} {
+	m := make(map[string]interface{})
+	rv := reflect.ValueOf(props)
+	for key, sf := range propFields {
+		f := rv.FieldByIndex(sf.Index)
+		if f.IsZero() {
+			continue
+		}
+		if f.Kind() == reflect.Pointer {
+			f = f.Elem()
+		}
+		m[key] = f.Interface()
+	}
+	for k, v := range props.Extra {
+		m[k] = v
+	}
+	return m
+}
+
+func indexOf(ids []int, id int) int {
+	for i, x := range ids {
+		if x == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func insertAt(ids []int, i, id int) []int {
+	ids = append(ids, 0)
+	copy(ids[i+1:], ids[i:])
+	ids[i] = id
+	return ids
+}
@@ -0,0 +1,124 @@
+package viewer
+
+// IME composition. Input methods for CJK and other scripts build text
+// over several keystrokes before the user picks what to enter, and the
+// keys along the way must not reach the input as text. The host passes
+// the text being composed to Compose as it changes, and the final choice
+// to CommitComposition. The composition is kept on the text cursor
+// (TextCursor.Composition) and drawn inside the focused input at the
+// cursor, underlined, with the cursor where the IME has it, but is not
+// part of the value until it is committed; committing inserts it as
+// typing would and sends a value_change.
+//
+// The source sees the composition too: a "composition_start" when it
+// begins, a "composition_update" with the text each time it changes,
+// and a "composition_end" with the committed text, or none if it was
+// cancelled. While it is under way, keys are left to the IME. Moving
+// focus or pressing elsewhere in the input commits the composition as
+// it stands, as browsers do.
+
+// Compose shows text as the composition in the focused input, with the
+// IME's cursor at byte offset cursor into it, starting a composition if
+// none is under way. Composing empty text cancels it. Returns false if
+// no input is focused.
+func (v *Viewer) Compose(text string, cursor int) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	c, node := v.editing()
+	if c == nil {
+		return false
+	}
+	id := node.ID
+	if text == "" {
+		if c.Composition != "" {
+			c.Composition, c.CompositionCursor = "", 0
+			v.emitComposition(id, "composition_end", "")
+			v.showCursor()
+		}
+		return true
+	}
+	if c.Composition == "" {
+		// The composition replaces the selection.
+		value := inputValue(node)
+		if start, end := c.selection(len(value)); start != end {
+			v.insertText(node, value, start, end, "")
+			v.selected(node, start, end)
+		}
+		v.emitComposition(id, "composition_start", "")
+	}
+	c.Composition = text
+	c.CompositionCursor = min(max(cursor, 0), len(text))
+	v.emitComposition(id, "composition_update", text)
+	v.showCursor()
+	return true
+}
+
+// CommitComposition ends the composition in the focused input, inserting
+// text, the IME's final choice, at the cursor. It may be called without
+// Compose for text the IME commits straight away. Returns false if no
+// input is focused.
+func (v *Viewer) CommitComposition(text string) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	c, node := v.editing()
+	if c == nil {
+		return false
+	}
+	v.commitComposition(c, node, text)
+	v.showCursor()
+	return true
+}
+
+// finishComposition commits any composition under way as it stands.
+// Must be called with the mutex held.
+func (v *Viewer) finishComposition() {
+	if c, node := v.editing(); c != nil && c.Composition != "" {
+		v.commitComposition(c, node, c.Composition)
+	}
+}
+
+// commitComposition inserts text at the cursor of an input, ending its
+// composition.
+// Must be called with the mutex held.
+func (v *Viewer) commitComposition(c *TextCursor, node *RenderNode, text string) {
+	composing := c.Composition != ""
+	c.Composition, c.CompositionCursor = "", 0
+	value := inputValue(node)
+	start, end := c.selection(len(value))
+	if text != "" {
+		v.insertText(node, value, start, end, text)
+		v.selected(node, start, end)
+	}
+	if composing || text != "" {
+		v.emitComposition(node.ID, "composition_end", text)
+	}
+}
+
+// editing returns the text cursor and the input it is in, or nil if no
+// input is being edited.
+// Must be called with the mutex held.
+func (v *Viewer) editing() (*TextCursor, *RenderNode) {
+	c := v.tree.Cursor
+	if c == nil {
+		return nil, nil
+	}
+	node := v.tree.NodeIndex[c.Node]
+	if node == nil || node.Type != NodeInput {
+		return nil, nil
+	}
+	return c, node
+}
+
+// emitComposition sends the source a composition event.
+// Must be called with the mutex held.
+func (v *Viewer) emitComposition(id int, kind, text string) {
+	event := InputEvent{Target: &id, Kind: kind, Value: text}
+	v.emit(ProtocolMessage{Type: MsgInput, Event: &event})
+}
+
+// composing reports whether an IME composition is under way.
+func (c *TextCursor) composing() bool {
+	return c != nil && c.Composition != ""
+}
@@ -0,0 +1,68 @@
+package viewer
+
+import "math"
+
+// Size constraints. A node's minWidth, maxWidth, minHeight, and maxHeight
+// props bound its box, whatever sized it: its own width and height, the
+// space its parent gives it, its content, or a flex share. A min wins
+// over a max it exceeds.
+//
+// A flex container applies its children's limits as it shares out space,
+// as CSS does: a flex child whose share would break one of its limits is
+// held at that limit, and the space it frees or takes is shared among the
+// other flex children, repeating until every share keeps to its limits.
+
+// limits returns a node's min and max props along one axis: width when
+// horizontal is true, height otherwise.
+func limits(node *RenderNode, horizontal bool) (lo, hi *int) {
+	if horizontal {
+		return node.Props.MinWidth, node.Props.MaxWidth
+	}
+	return node.Props.MinHeight, node.Props.MaxHeight
+}
+
+// constrain limits a size of a node along one axis to its min and max
+// props.
+func constrain(node *RenderNode, horizontal bool, v float64) float64 {
+	lo, hi := limits(node, horizontal)
+	return clampSize(v, lo, hi)
+}
+
+// shareFlex shares free space along the main axis among flex children by
+// their flex factors, within their limits.
+func shareFlex(infos []*childInfo, free float64, isRow bool) {
+	var active []*childInfo
+	for _, info := range infos {
+		if !info.hasFixedMain && info.flexGrow > 0 {
+			active = append(active, info)
+		}
+	}
+	for len(active) > 0 {
+		total := 0.0
+		for _, info := range active {
+			total += info.flexGrow
+		}
+		// Share the space out, then see which way the limits push it.
+		violation := 0.0
+		for _, info := range active {
+			info.allocatedMain = info.flexGrow / total * math.Max(free, 0)
+			violation += constrain(info.child, isRow, info.allocatedMain) - info.allocatedMain
+		}
+		if violation == 0 {
+			return
+		}
+		// Hold the children pushed the same way as the total at their
+		// limits, and share again among the rest.
+		rest := active[:0]
+		for _, info := range active {
+			c := constrain(info.child, isRow, info.allocatedMain)
+			if violation > 0 && c > info.allocatedMain || violation < 0 && c < info.allocatedMain {
+				info.allocatedMain = c
+				free -= c
+			} else {
+				rest = append(rest, info)
+			}
+		}
+		active = rest
+	}
+}
@@ -0,0 +1,227 @@
+package viewer
+
+import "strings"
+
+// Form controls. Checkbox, radio, and select nodes are driven by the
+// viewer itself, like inputs, so they answer at once. Each takes focus
+// and is pressed like a clickable node; releasing over it, or pressing
+// space while it has focus, activates it: a checkbox toggles, a radio is
+// checked and the other radios in its group — the radios sharing its
+// parent — are unchecked, and a select moves to its next option,
+// wrapping around. The arrow keys move a select through its options and
+// move the check and focus through a radio group, and home and end go to
+// the first and last, as browsers do.
+//
+// Each change updates the node's props at once and sends the source a
+// change event with the checked state (and a radio's value) or the
+// select's new value. Checkboxes show as "[x] label", radios as
+// "(*) label", and selects as "[value ▾]", on screen and in text
+// projections; renderers underline the one with focus.
+//
+// Buttons are pressed and clicked like clickable nodes without needing
+// the interactive prop, taking focus when pressed, and enter or space
+// clicks the focused one. They
+// show as "[ label ]", bold if primary and in dangerColor if danger.
+
+// Button variants.
+const (
+	ButtonPrimary = "primary"
+	ButtonDanger  = "danger"
+)
+
+// dangerColor is the text color of danger buttons.
+const dangerColor = "#cc3333"
+
+// isControl reports whether a node is a checkbox, radio, or select.
+func isControl(node *RenderNode) bool {
+	switch node.Type {
+	case NodeCheckbox, NodeRadio, NodeSelect:
+		return true
+	}
+	return false
+}
+
+// isWidget reports whether a node is a control or a button, which take
+// focus when pressed and keys when focused.
+func isWidget(node *RenderNode) bool {
+	return isControl(node) || node.Type == NodeButton
+}
+
+// isChecked reports whether a checkbox or radio is checked.
+func isChecked(node *RenderNode) bool {
+	return node.Props.Checked != nil && *node.Props.Checked
+}
+
+// optionIndex returns the index of a select's value among its options,
+// or 0 if it has none of them.
+func optionIndex(node *RenderNode) int {
+	for i, o := range node.Props.Options {
+		if o == inputValue(node) {
+			return i
+		}
+	}
+	return 0
+}
+
+// controlText returns how a control or button is shown.
+func controlText(node *RenderNode) string {
+	label := ""
+	if node.Props.Label != nil {
+		label = " " + *node.Props.Label
+	}
+	switch node.Type {
+	case NodeCheckbox:
+		if isChecked(node) {
+			return "[x]" + label
+		}
+		return "[ ]" + label
+	case NodeRadio:
+		if isChecked(node) {
+			return "(*)" + label
+		}
+		return "( )" + label
+	case NodeButton:
+		return "[" + label + " ]"
+	case NodeSelect:
+		value := ""
+		if opts := node.Props.Options; len(opts) > 0 {
+			value = opts[optionIndex(node)]
+		}
+		return "[" + value + " ▾]"
+	}
+	return ""
+}
+
+// controlKey applies a key to the focused control. Returns false if the
+// key does nothing to it, leaving it for the source.
+// Must be called with the mutex held.
+func (v *Viewer) controlKey(key string) bool {
+	if v.tree.Focused == nil {
+		return false
+	}
+	node := v.tree.NodeIndex[*v.tree.Focused]
+	if node == nil || !isWidget(node) {
+		return false
+	}
+	key = strings.ToLower(key)
+	if node.Type == NodeButton {
+		if key != " " && key != "space" && key != "enter" {
+			return false
+		}
+		id := node.ID
+		event := InputEvent{Target: &id, Kind: "click"}
+		v.emit(ProtocolMessage{Type: MsgInput, Event: &event})
+		return true
+	}
+	if key == " " || key == "space" {
+		v.activate(node)
+		return true
+	}
+
+	// move returns where the key takes the i'th of n choices.
+	var move func(i, n int) int
+	switch key {
+	case "arrowup", "up", "arrowleft", "left":
+		move = func(i, n int) int { return max(i-1, 0) }
+	case "arrowdown", "down", "arrowright", "right":
+		move = func(i, n int) int { return min(i+1, n-1) }
+	case "home":
+		move = func(i, n int) int { return 0 }
+	case "end":
+		move = func(i, n int) int { return n - 1 }
+	default:
+		return false
+	}
+	switch node.Type {
+	case NodeSelect:
+		if n := len(node.Props.Options); n > 0 {
+			v.setOption(node, move(optionIndex(node), n))
+		}
+	case NodeRadio:
+		group := v.radioGroup(node)
+		for i, r := range group {
+			if r == node {
+				next := group[move(i, len(group))]
+				v.setFocus(&next.ID)
+				v.checkRadio(next)
+				break
+			}
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// activate toggles a checkbox, checks a radio, or moves a select to its
+// next option.
+// Must be called with the mutex held.
+func (v *Viewer) activate(node *RenderNode) {
+	switch node.Type {
+	case NodeCheckbox:
+		checked := !isChecked(node)
+		node.Props.Checked = &checked
+		v.controlChanged(node, InputEvent{Checked: &checked})
+	case NodeRadio:
+		v.checkRadio(node)
+	case NodeSelect:
+		if n := len(node.Props.Options); n > 0 {
+			v.setOption(node, (optionIndex(node)+1)%n)
+		}
+	}
+}
+
+// radioGroup returns the radios sharing a radio's parent, in tree order.
+// Must be called with the mutex held.
+func (v *Viewer) radioGroup(node *RenderNode) []*RenderNode {
+	if node.Type != NodeRadio {
+		return nil
+	}
+	parent := findParent(v.tree.Root, node.ID)
+	if parent == nil {
+		return []*RenderNode{node}
+	}
+	var group []*RenderNode
+	for _, c := range parent.Children {
+		if c.Type == NodeRadio {
+			group = append(group, c)
+		}
+	}
+	return group
+}
+
+// checkRadio checks a radio and unchecks the rest of its group. Checking
+// a radio that is already checked does nothing.
+// Must be called with the mutex held.
+func (v *Viewer) checkRadio(node *RenderNode) {
+	if isChecked(node) {
+		return
+	}
+	for _, r := range v.radioGroup(node) {
+		checked := r == node
+		r.Props.Checked = &checked
+	}
+	checked := true
+	v.controlChanged(node, InputEvent{Checked: &checked, Value: inputValue(node)})
+}
+
+// setOption moves a select to its i'th option.
+// Must be called with the mutex held.
+func (v *Viewer) setOption(node *RenderNode, i int) {
+	if i == optionIndex(node) {
+		return
+	}
+	value := node.Props.Options[i]
+	node.Props.Value = &value
+	v.controlChanged(node, InputEvent{Value: value})
+}
+
+// controlChanged sends the source a change event for a control and redraws.
+// Must be called with the mutex held.
+func (v *Viewer) controlChanged(node *RenderNode, event InputEvent) {
+	id := node.ID
+	event.Target, event.Kind = &id, "change"
+	v.emit(ProtocolMessage{Type: MsgInput, Event: &event})
+	v.markDirty()
+	v.signalChanged()
+}
@@ -0,0 +1,90 @@
+//go:build js && wasm
+
+package viewer
+
+import (
+	"strconv"
+	"syscall/js"
+)
+
+// domState holds the event listeners attached to an HtmlTarget container.
+type domState struct {
+	container js.Value
+	listeners []js.Func
+}
+
+// renderDOM writes the tree into the HtmlTarget's container element and,
+// on first use, attaches listeners that translate DOM events into
+// InputEvents. Must be called with the mutex held.
+func (v *Viewer) renderDOM(target HtmlTarget, markup string) {
+	doc := js.Global().Get("document")
+	container := doc.Call("getElementById", target.Container)
+	if container.IsNull() || container.IsUndefined() {
+		return
+	}
+	container.Set("innerHTML", markup)
+
+	if v.dom == nil {
+		v.dom = make(map[string]*domState)
+	}
+	if _, ok := v.dom[target.Container]; ok {
+		return
+	}
+	ds := &domState{container: container}
+	for kind, domEvent := range map[string]string{
+		"click":        "click",
+		"value_change": "input",
+		"key":          "keydown",
+		"focus":        "focusin",
+		"blur":         "focusout",
+	} {
+		kind := kind
+		fn := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+			if ev, ok := domInputEvent(kind, args[0]); ok {
+				// Blocking on the viewer lock inside a JS callback would
+				// stall the event loop, so deliver from a goroutine.
+				go v.SendInput(ev)
+			}
+			return nil
+		})
+		container.Call("addEventListener", domEvent, fn)
+		ds.listeners = append(ds.listeners, fn)
+	}
+	v.dom[target.Container] = ds
+}
+
+// releaseDOM detaches all DOM listeners. Must be called with the mutex held.
+func (v *Viewer) releaseDOM() {
+	for _, ds := range v.dom {
+		for _, fn := range ds.listeners {
+			fn.Release()
+		}
+	}
+	v.dom = nil
+}
+
+// domInputEvent converts a DOM event into an InputEvent targeted at the
+// nearest element carrying data-vp-id.
+func domInputEvent(kind string, ev js.Value) (InputEvent, bool) {
+	el := ev.Get("target").Call("closest", "[data-vp-id]")
+	if el.IsNull() {
+		return InputEvent{}, false
+	}
+	id, err := strconv.Atoi(el.Call("getAttribute", "data-vp-id").String())
+	if err != nil {
+		return InputEvent{}, false
+	}
+
+	out := InputEvent{Target: &id, Kind: kind}
+	switch kind {
+	case "click":
+		x, y := ev.Get("offsetX").Int(), ev.Get("offsetY").Int()
+		button := ev.Get("button").Int()
+		out.X, out.Y, out.Button = &x, &y, &button
+	case "value_change":
+		out.Value = el.Get("value").String()
+	case "key":
+		out.Key = ev.Get("key").String()
+	}
+	return out, true
+}
@@ -0,0 +1,13 @@
+//go:build !(js && wasm)
+
+package viewer
+
+// domState is unused outside js/wasm builds.
+type domState struct{}
+
+// renderDOM is a no-op outside js/wasm builds; HtmlTarget output is only
+// available as markup via TargetOutput.
+func (v *Viewer) renderDOM(target HtmlTarget, markup string) {}
+
+// releaseDOM is a no-op outside js/wasm builds.
+func (v *Viewer) releaseDOM() {}
@@ -0,0 +1,142 @@
+package viewer
+
+// Drag and drop. Pressing on a draggable node and moving the pointer half
+// a character's width or more starts dragging it: the viewer sends the
+// source a "drag_start" aimed at the dragged node, and the press no longer
+// counts as a click. While dragging, the droppable node under the pointer
+// — the topmost one outside the dragged subtree and any disabled one — is
+// the drop target; each time it changes the source gets a "drag_over"
+// aimed at the new one, or at no node when the pointer leaves them all.
+// Releasing over a drop target sends it a "drop", and releasing anywhere
+// else sends the dragged node a "drag_cancel". drag_over and drop carry
+// the dragged node in InputEvent.Source.
+//
+// Renderers show the drag: RenderTree.Dragging is drawn faint, and
+// RenderTree.DropTarget inverted, until the drag ends.
+
+// dragDrop is a press on a draggable node, and the drag it starts once
+// the pointer moves far enough.
+type dragDrop struct {
+	node    int
+	x, y    int // where the press was
+	started bool
+}
+
+// pressDraggable notes a press on the topmost draggable node in path, if
+// any. Returns whether there was one.
+// Must be called with the mutex held.
+func (v *Viewer) pressDraggable(path []hitNode, ev PointerEvent) bool {
+	for i := len(path) - 1; i >= 0; i-- {
+		if node := path[i].node; isDraggable(node) {
+			v.dragDrop = &dragDrop{node: node.ID, x: ev.X, y: ev.Y}
+			return true
+		}
+	}
+	return false
+}
+
+// moveDrag starts the drag once the pointer has moved far enough, and
+// then tracks the drop target. Returns whether a drag is under way.
+// Must be called with the mutex held.
+func (v *Viewer) moveDrag(in *inputLayout, ev PointerEvent) bool {
+	d := v.dragDrop
+	if v.tree.NodeIndex[d.node] == nil {
+		v.endDrag()
+		return false
+	}
+	if !d.started {
+		dx, dy := ev.X-d.x, ev.Y-d.y
+		if float64(max(dx, -dx, dy, -dy)) < in.opts.CharWidth/2 {
+			return false
+		}
+		d.started = true
+		if v.tree.Active != nil {
+			v.setActive(nil)
+		}
+		node := d.node
+		v.tree.Dragging = &node
+		v.markDirty()
+		v.emitDrag("drag_start", &d.node, nil, ev)
+	}
+	if over := v.dropTarget(in, ev); !sameNode(over, v.tree.DropTarget) {
+		v.tree.DropTarget = over
+		v.markDirty()
+		v.emitDrag("drag_over", over, &d.node, ev)
+	}
+	return true
+}
+
+// releaseDrag ends a drag, dropping the dragged node on the target under
+// the pointer or cancelling. Returns false, and forgets the press, if the
+// drag never started, so the release can still click.
+// Must be called with the mutex held.
+func (v *Viewer) releaseDrag(in *inputLayout, ev PointerEvent) bool {
+	d := v.dragDrop
+	if !d.started {
+		v.dragDrop = nil
+		return false
+	}
+	if over := v.dropTarget(in, ev); over != nil {
+		v.emitDrag("drop", over, &d.node, ev)
+	} else {
+		v.emitDrag("drag_cancel", &d.node, nil, ev)
+	}
+	v.endDrag()
+	return true
+}
+
+// endDrag forgets any drag and clears its feedback.
+// Must be called with the mutex held.
+func (v *Viewer) endDrag() {
+	v.dragDrop = nil
+	if v.tree.Dragging != nil || v.tree.DropTarget != nil {
+		v.tree.Dragging = nil
+		v.tree.DropTarget = nil
+		v.markDirty()
+	}
+}
+
+// dropTarget returns the droppable node the dragged one would drop on at
+// the pointer, or nil.
+// Must be called with the mutex held.
+func (v *Viewer) dropTarget(in *inputLayout, ev PointerEvent) *int {
+	path := hitLayers(v.tree.Root, in, float64(ev.X), float64(ev.Y))
+	for i, h := range path {
+		// Nothing is dropped on the dragged node or into it, nor inside
+		// a disabled node.
+		if h.node.ID == v.dragDrop.node || isDisabled(h.node) {
+			path = path[:i]
+			break
+		}
+	}
+	for i := len(path) - 1; i >= 0; i-- {
+		if node := path[i].node; isDroppable(node) {
+			id := node.ID
+			return &id
+		}
+	}
+	return nil
+}
+
+// emitDrag sends a drag event to the source.
+// Must be called with the mutex held.
+func (v *Viewer) emitDrag(kind string, target, source *int, ev PointerEvent) {
+	x, y := ev.X, ev.Y
+	event := InputEvent{Target: target, Kind: kind, Source: source, X: &x, Y: &y}
+	v.emit(ProtocolMessage{Type: MsgInput, Event: &event})
+}
+
+// isDraggable reports whether a node sets the draggable prop.
+func isDraggable(node *RenderNode) bool {
+	return node.Props.Draggable != nil && *node.Props.Draggable
+}
+
+// isDroppable reports whether a node sets the droppable prop.
+func isDroppable(node *RenderNode) bool {
+	return node.Props.Droppable != nil && *node.Props.Droppable
+}
+
+// sameNode reports whether two optional node IDs are equal.
+func sameNode(a, b *int) bool {
+	return (a == nil) == (b == nil) && (a == nil || *a == *b)
+}
@@ -0,0 +1,636 @@
+package viewer
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"strings"
+	"time"
+
+	"golang.org/x/image/draw"
+)
+
+// Text editing. The viewer edits the focused input itself, as the source
+// would only see keystrokes a round trip late: printable keys insert at
+// the cursor, backspace and delete remove the grapheme cluster before or
+// after it, and the arrow keys, home, and end move it. Each edit updates
+// the input's value at once and sends the source a value_change; keys the
+// input does not use go to the source as usual.
+//
+// Multiline inputs take enter as a line break, move between lines with
+// up and down, keeping the column, and take home and end to the ends of
+// the line. Their height grows with their lines, from multilineMinRows up
+// to multilineMaxRows, unless they set one; past that they scroll to keep
+// the cursor in view.
+//
+// The cursor is a byte offset into the value, always on a cluster
+// boundary, and is kept on the tree for renderers. The cell grid draws a
+// block cursor by inverting its cell and the other shapes as an
+// underline, having nothing thinner than a cell; the rasterizer draws
+// each shape as named. A blinking cursor shows for blinkPeriod after each
+// edit or move, then hides and shows again on alternate periods as Tick
+// advances.
+//
+// Shift with the movement keys extends a selection from where the cursor
+// was, as does dragging the pointer across an input, and ctrl+a selects
+// everything. Typing replaces the selection and backspace or delete
+// removes it. The grid inverts selected cells and the rasterizer
+// highlights them; the cursor is hidden while there is a selection. Each
+// change of selection sends the source a select event with the selected
+// text and its byte range, for copy and cut.
+
+// Cursor shapes.
+const (
+	CursorBlock     = "block"
+	CursorBar       = "bar"
+	CursorUnderline = "underline"
+)
+
+// blinkPeriod is how long a blinking cursor stays on or off.
+const blinkPeriod = 530 * time.Millisecond
+
+// The lines a multiline input shows when it sets no height.
+const (
+	multilineMinRows = 3
+	multilineMaxRows = 10
+)
+
+// CursorConfig controls how the text cursor is drawn.
+type CursorConfig struct {
+	// Shape is CursorBlock, CursorBar, or CursorUnderline.
+	Shape string
+
+	// Blink makes the cursor blink while Tick is being called.
+	Blink bool
+}
+
+// DefaultCursorConfig returns a steady block cursor.
+func DefaultCursorConfig() CursorConfig {
+	return CursorConfig{Shape: CursorBlock}
+}
+
+// SetCursorConfig changes how the text cursor is drawn.
+func (v *Viewer) SetCursorConfig(cfg CursorConfig) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.cursorCfg = &cfg
+	if c := v.tree.Cursor; c != nil {
+		c.Shape = cfg.Shape
+		v.showCursor()
+	}
+}
+
+// cursorConfig returns the configuration in effect.
+// Must be called with the mutex held.
+func (v *Viewer) cursorConfig() CursorConfig {
+	if v.cursorCfg == nil {
+		return DefaultCursorConfig()
+	}
+	return *v.cursorCfg
+}
+
+// startEditing puts the cursor at the end of a newly focused input, or
+// removes it if the focused node is not an input.
+// Must be called with the mutex held.
+func (v *Viewer) startEditing() {
+	v.finishComposition()
+	v.tree.Cursor = nil
+	if v.tree.Focused == nil {
+		return
+	}
+	node := v.tree.NodeIndex[*v.tree.Focused]
+	if node == nil || node.Type != NodeInput {
+		return
+	}
+	end := len(inputValue(node))
+	v.tree.Cursor = &TextCursor{Node: node.ID, Offset: end, Anchor: end, Shape: v.cursorConfig().Shape}
+	v.showCursor()
+}
+
+// editKey applies a key to the input being edited. Returns false if the
+// key does not edit, leaving it for the source.
+// Must be called with the mutex held.
+func (v *Viewer) editKey(key string) bool {
+	c := v.tree.Cursor
+	if c == nil {
+		return false
+	}
+	node := v.tree.NodeIndex[c.Node]
+	if node == nil || node.Type != NodeInput {
+		v.tree.Cursor = nil
+		return false
+	}
+	value := inputValue(node)
+	at := min(c.Offset, len(value))
+	start, end := c.selection(len(value))
+
+	lower := strings.ToLower(key)
+	extend := strings.HasPrefix(lower, "shift+")
+	multiline := isMultiline(node)
+	switch strings.TrimPrefix(lower, "shift+") {
+	case "arrowleft", "left":
+		to := prevCluster(value, at)
+		if start != end && !extend {
+			to = start
+		}
+		c.moveTo(to, extend)
+	case "arrowright", "right":
+		to := nextCluster(value, at)
+		if start != end && !extend {
+			to = end
+		}
+		c.moveTo(to, extend)
+	case "arrowup", "up", "arrowdown", "down":
+		if !multiline {
+			return false
+		}
+		shown, shownAt, _, _ := c.shown(node)
+		c.moveTo(valueOffset(node, verticalMove(shown, shownAt, strings.HasSuffix(lower, "up"))), extend)
+	case "home":
+		if multiline {
+			c.moveTo(lineStart(value, at), extend)
+		} else {
+			c.moveTo(0, extend)
+		}
+	case "end":
+		if multiline {
+			c.moveTo(lineEnd(value, at), extend)
+		} else {
+			c.moveTo(len(value), extend)
+		}
+	case "enter":
+		if !multiline || extend {
+			v.commitInput(node)
+			return false
+		}
+		v.insertText(node, value, start, end, "\n")
+	case "ctrl+a":
+		c.moveTo(0, false)
+		c.moveTo(len(value), true)
+	case "backspace":
+		if start == end {
+			start = prevCluster(value, at)
+		}
+		v.setValue(node, value[:start]+value[end:], start)
+	case "delete":
+		if start == end {
+			end = nextCluster(value, at)
+		}
+		v.setValue(node, value[:start]+value[end:], start)
+	default:
+		if !printable(key) {
+			return false
+		}
+		v.insertText(node, value, start, end, key)
+	}
+	v.selected(node, start, end)
+	v.showCursor()
+	return true
+}
+
+// selected sends the source a select event if the selection in an input
+// is no longer start to end. Collapsing an empty selection sends nothing.
+// Must be called with the mutex held.
+func (v *Viewer) selected(node *RenderNode, start, end int) {
+	value := inputValue(node)
+	from, to := v.tree.Cursor.selection(len(value))
+	if from == start && to == end || from == to && start == end {
+		return
+	}
+	id := node.ID
+	event := InputEvent{Target: &id, Kind: "select", Value: value[from:to], SelectionStart: &from, SelectionEnd: &to}
+	v.emit(ProtocolMessage{Type: MsgInput, Event: &event})
+}
+
+// selection returns the selected byte range of a value n bytes long,
+// start first.
+func (c *TextCursor) selection(n int) (start, end int) {
+	a, b := min(c.Anchor, n), min(c.Offset, n)
+	return min(a, b), max(a, b)
+}
+
+// moveTo moves the cursor, extending the selection to it or leaving
+// nothing selected.
+func (c *TextCursor) moveTo(offset int, extend bool) {
+	c.Offset = offset
+	if !extend {
+		c.Anchor = offset
+	}
+}
+
+// pressInput focuses an input pressed at (x, y) and puts the cursor
+// there, starting a selection drag. y is in the input's layout
+// coordinates, before scrolling.
+// Must be called with the mutex held.
+func (v *Viewer) pressInput(in *inputLayout, node *RenderNode, x, y float64) {
+	v.finishComposition()
+	if v.tree.Focused == nil || *v.tree.Focused != node.ID {
+		v.setFocus(&node.ID)
+	}
+	c := v.tree.Cursor
+	if c == nil {
+		return
+	}
+	start, end := c.selection(len(inputValue(node)))
+	c.moveTo(inputOffset(in, node, c.Scroll, x, y), false)
+	v.selected(node, start, end)
+	v.showCursor()
+	v.selecting = true
+}
+
+// dragSelection extends the selection in the focused input to the
+// pointer at (x, y).
+// Must be called with the mutex held.
+func (v *Viewer) dragSelection(in *inputLayout, x, y float64) {
+	c := v.tree.Cursor
+	if c == nil || v.tree.NodeIndex[c.Node] == nil {
+		v.selecting = false
+		return
+	}
+	node := v.tree.NodeIndex[c.Node]
+	start, end := c.selection(len(inputValue(node)))
+	y += scrollOffset(v.tree.Root, node.ID)
+	c.moveTo(inputOffset(in, node, c.Scroll, x, y), true)
+	v.selected(node, start, end)
+	v.showCursor()
+}
+
+// inputOffset returns the cluster boundary in an input's value nearest
+// to (x, y), with the input scrolled down by scroll lines.
+func inputOffset(in *inputLayout, node *RenderNode, scroll int, x, y float64) int {
+	l := in.layouts[node.ID]
+	if l == nil {
+		return 0
+	}
+	value := shownValue(node)
+	start := 0
+	if isMultiline(node) {
+		line := max(int(math.Floor((y-l.Y)/in.opts.LineHeight)), 0) + scroll
+		for ; line > 0; line-- {
+			i := strings.IndexByte(value[start:], '\n')
+			if i < 0 {
+				break
+			}
+			start += i + 1
+		}
+	}
+	// The value is drawn after a two-column prompt.
+	return valueOffset(node, columnOffset(value, start, (x-l.X)/in.opts.CharWidth-2))
+}
+
+// columnOffset returns the cluster boundary nearest to column col of the
+// line of value starting at start.
+func columnOffset(value string, start int, col float64) int {
+	at, left := start, 0
+	for at < len(value) && value[at] != '\n' {
+		n, width := nextGrapheme(value[at:])
+		if n == 0 || col < float64(left)+float64(width)/2 {
+			break
+		}
+		at += n
+		left += width
+	}
+	return at
+}
+
+// verticalMove returns where the cursor at at goes on moving a line up
+// or down, keeping its column: the same column on that line, or the
+// start or end of the value from the first or last line.
+func verticalMove(value string, at int, up bool) int {
+	start := lineStart(value, at)
+	col := float64(StringWidth(value[start:at]))
+	if up {
+		if start == 0 {
+			return 0
+		}
+		return columnOffset(value, lineStart(value, start-1), col)
+	}
+	end := lineEnd(value, at)
+	if end == len(value) {
+		return end
+	}
+	return columnOffset(value, end+1, col)
+}
+
+// lineStart returns the offset of the start of the line holding at.
+func lineStart(value string, at int) int {
+	return strings.LastIndexByte(value[:at], '\n') + 1
+}
+
+// lineEnd returns the offset of the end of the line holding at, before
+// its line break.
+func lineEnd(value string, at int) int {
+	if i := strings.IndexByte(value[at:], '\n'); i >= 0 {
+		return at + i
+	}
+	return len(value)
+}
+
+// cursorPos returns the line of value holding at and the column of at
+// within it.
+func cursorPos(value string, at int) (line, col int) {
+	start := lineStart(value, at)
+	return strings.Count(value[:start], "\n"), StringWidth(value[start:at])
+}
+
+// lineSpan is the selected columns of one line.
+type lineSpan struct {
+	line, from, to int
+}
+
+// selectionSpans returns the columns selected between start and end on
+// each line of value. A selected line break takes a column after its
+// line.
+func selectionSpans(value string, start, end int) []lineSpan {
+	sl, sc := cursorPos(value, start)
+	el, ec := cursorPos(value, end)
+	lines := strings.Split(value, "\n")
+	var spans []lineSpan
+	for line := sl; line <= el; line++ {
+		from, to := 0, StringWidth(lines[line])+1
+		if line == sl {
+			from = sc
+		}
+		if line == el {
+			to = ec
+		}
+		spans = append(spans, lineSpan{line, from, to})
+	}
+	return spans
+}
+
+// insertText replaces value[start:end] of an input with text, as much
+// of it as the input's constraints allow. Text it does not allow at all
+// leaves the value as it is.
+// Must be called with the mutex held.
+func (v *Viewer) insertText(node *RenderNode, value string, start, end int, text string) {
+	rest := value[:start] + value[end:]
+	if allowed := allowedText(node, rest, start, text); allowed != "" || text == "" {
+		v.setValue(node, rest[:start]+allowed+rest[start:], start+len(allowed))
+	}
+}
+
+// setValue replaces an input's value, moves the cursor to offset, and
+// sends the source a value_change.
+// Must be called with the mutex held.
+func (v *Viewer) setValue(node *RenderNode, value string, offset int) {
+	node.Props.Value = &value
+	v.tree.Cursor.moveTo(offset, false)
+	id := node.ID
+	event := InputEvent{Target: &id, Kind: "value_change", Value: value}
+	v.emit(ProtocolMessage{Type: MsgInput, Event: &event})
+}
+
+// showCursor restarts the blink with the cursor visible and redraws.
+// Must be called with the mutex held.
+func (v *Viewer) showCursor() {
+	c := v.tree.Cursor
+	c.Hidden = false
+	v.cursorMoved = v.now()
+	v.markDirty()
+	if node := v.tree.NodeIndex[c.Node]; node != nil && isMultiline(node) {
+		v.followCursor(node)
+	}
+	v.signalChanged()
+}
+
+// followCursor scrolls a multiline input so the cursor's line is in view.
+// Must be called with the mutex held.
+func (v *Viewer) followCursor(node *RenderNode) {
+	c := v.tree.Cursor
+	in := v.inputLayout()
+	rows := 1
+	if l := in.layouts[node.ID]; l != nil {
+		rows = max(int(l.Height/in.opts.LineHeight), 1)
+	}
+	value := inputValue(node)
+	line, _ := cursorPos(value, min(c.Offset, len(value)))
+	lines := strings.Count(value, "\n") + 1
+	c.Scroll = min(max(c.Scroll, line-rows+1), line)
+	c.Scroll = max(min(c.Scroll, lines-rows), 0)
+}
+
+// blinkCursor hides or shows a blinking cursor for the current time.
+// Returns whether a cursor is blinking.
+// Must be called with the mutex held.
+func (v *Viewer) blinkCursor() bool {
+	c := v.tree.Cursor
+	if c == nil || !v.cursorConfig().Blink {
+		return false
+	}
+	hidden := v.now().Sub(v.cursorMoved)/blinkPeriod%2 == 1
+	if hidden != c.Hidden {
+		c.Hidden = hidden
+		v.markDirty()
+		v.signalChanged()
+	}
+	return true
+}
+
+// inputValue returns an input's value, or "" if it has none.
+func inputValue(node *RenderNode) string {
+	if node.Props.Value == nil {
+		return ""
+	}
+	return *node.Props.Value
+}
+
+// isMultiline reports whether an input takes more than one line.
+func isMultiline(node *RenderNode) bool {
+	return node.Props.Multiline != nil && *node.Props.Multiline
+}
+
+// inputScroll returns how many lines an input is scrolled down.
+func (d *gridDrawer) inputScroll(node *RenderNode) int {
+	if c := d.cursor; c != nil && c.Node == node.ID {
+		return c.Scroll
+	}
+	return 0
+}
+
+// printable reports whether a key name is text to insert: a single
+// grapheme cluster that takes up space, rather than a named key.
+func printable(key string) bool {
+	if key == "" {
+		return false
+	}
+	n, width := nextGrapheme(key)
+	return n == len(key) && width > 0
+}
+
+// prevCluster returns the offset of the grapheme cluster before at.
+func prevCluster(s string, at int) int {
+	prev := 0
+	for i := 0; i < at; {
+		n, _ := nextGrapheme(s[i:])
+		if n == 0 {
+			break
+		}
+		prev = i
+		i += n
+	}
+	return prev
+}
+
+// nextCluster returns the offset just past the grapheme cluster at at.
+func nextCluster(s string, at int) int {
+	if at >= len(s) {
+		return len(s)
+	}
+	n, _ := nextGrapheme(s[at:])
+	return at + max(n, 1)
+}
+
+// drawCursor marks the selection or, if there is none, the text cursor
+// in an input whose value is drawn from (x, y).
+func (d *gridDrawer) drawCursor(node *RenderNode, x, y int, clip rect) {
+	c := d.cursor
+	if c == nil || c.Node != node.ID {
+		return
+	}
+	clip = clip.intersect(rect{0, 0, d.grid.Width, d.grid.Height})
+	value, at, start, end := c.shown(node)
+	if from, to := c.composition(node); from != to {
+		for _, s := range selectionSpans(value, from, to) {
+			row := y + s.line - c.Scroll
+			for i := x + s.from; i < x+s.to; i++ {
+				if clip.contains(i, row) {
+					d.grid.Cells[row][i].Style.Underline = true
+				}
+			}
+		}
+	}
+	if start != end {
+		for _, s := range selectionSpans(value, start, end) {
+			row := y + s.line - c.Scroll
+			for i := x + s.from; i < x+s.to; i++ {
+				if clip.contains(i, row) {
+					cell := &d.grid.Cells[row][i]
+					cell.Style.Inverse = !cell.Style.Inverse
+				}
+			}
+		}
+		return
+	}
+	if c.Hidden {
+		return
+	}
+	line, col := cursorPos(value, at)
+	x, y = x+col, y+line-c.Scroll
+	if !clip.contains(x, y) {
+		return
+	}
+	row := d.grid.Cells[y]
+	if c.Shape == CursorBar || c.Shape == CursorUnderline {
+		row[x].Style.Underline = true
+		return
+	}
+	row[x].Style.Inverse = !row[x].Style.Inverse
+	if x+1 < len(row) && row[x+1].Ch == 0 {
+		// The other half of a wide character.
+		row[x+1].Style.Inverse = row[x].Style.Inverse
+	}
+}
+
+// cursorRect returns where the rasterizer draws the text cursor of an
+// input at r, and its shape, or an empty rectangle if it shows none.
+func (d *rasterDrawer) cursorRect(node *RenderNode, r image.Rectangle, m textMetrics) (image.Rectangle, string) {
+	c := d.cursor
+	if c == nil || c.Node != node.ID || c.Hidden {
+		return image.Rectangle{}, ""
+	}
+	value, at, start, end := c.shown(node)
+	if start != end {
+		return image.Rectangle{}, ""
+	}
+	line, col := cursorPos(value, at)
+	x, y := r.Min.X+(2+col)*m.cellW, r.Min.Y+(line-c.Scroll)*m.lineH
+	width := 1
+	if at < len(value) && value[at] != '\n' {
+		_, width = nextGrapheme(value[at:])
+	}
+	cell := image.Rect(x, y, x+max(width, 1)*m.cellW, y+m.lineH)
+	switch c.Shape {
+	case CursorBar:
+		cell.Max.X = cell.Min.X + max(m.cellW/8, 1)
+	case CursorUnderline:
+		cell.Min.Y = cell.Max.Y - max(m.lineH/8, 1)
+	}
+	return cell.Intersect(r), c.Shape
+}
+
+// selectionRects returns the selected text of each line of an input at
+// r, or nil if nothing is selected.
+func (d *rasterDrawer) selectionRects(node *RenderNode, r image.Rectangle, m textMetrics) []image.Rectangle {
+	c := d.cursor
+	if c == nil || c.Node != node.ID {
+		return nil
+	}
+	value, _, start, end := c.shown(node)
+	if start == end {
+		return nil
+	}
+	var rects []image.Rectangle
+	x := r.Min.X + 2*m.cellW
+	for _, s := range selectionSpans(value, start, end) {
+		y := r.Min.Y + (s.line-c.Scroll)*m.lineH
+		rects = append(rects, image.Rect(x+s.from*m.cellW, y, x+s.to*m.cellW, y+m.lineH).Intersect(r))
+	}
+	return rects
+}
+
+// selectionRect returns the area of an input's selection, or an empty
+// rectangle if nothing is selected.
+func (d *rasterDrawer) selectionRect(node *RenderNode, r image.Rectangle, m textMetrics) image.Rectangle {
+	var area image.Rectangle
+	for _, rc := range d.selectionRects(node, r, m) {
+		area = area.Union(rc)
+	}
+	return area
+}
+
+// drawCursor paints an input's text cursor in its text color. A block
+// cursor shows the character under it in the background color.
+func (d *rasterDrawer) drawCursor(node *RenderNode, n rasterNode, clip image.Rectangle) {
+	area := n.cursor.Intersect(clip)
+	draw.Draw(d.img, area, image.NewUniform(rgba(n.style.FG, rasterForeground)), image.Point{}, draw.Over)
+	if n.caret == CursorBar || n.caret == CursorUnderline {
+		return
+	}
+	value, at, _, _ := d.cursor.shown(node)
+	if at < len(value) && value[at] != '\n' {
+		under := n.style
+		under.FG = hexColor(rgba(n.style.BG, rasterBackground))
+		d.text(n.cursor.Min.X, n.cursor.Min.Y, value[at:nextCluster(value, at)], under, n.metrics, area)
+	}
+}
+
+// drawComposition underlines the composition in an input.
+func (d *rasterDrawer) drawComposition(node *RenderNode, n rasterNode, clip image.Rectangle) {
+	c := d.cursor
+	if c == nil || c.Node != node.ID {
+		return
+	}
+	from, to := c.composition(node)
+	if from == to {
+		return
+	}
+	value, _, _, _ := c.shown(node)
+	src := image.NewUniform(rgba(n.style.FG, rasterForeground))
+	x := n.rect.Min.X + 2*n.metrics.cellW
+	thick := max(n.metrics.lineH/16, 1)
+	for _, s := range selectionSpans(value, from, to) {
+		y := n.rect.Min.Y + (s.line-c.Scroll+1)*n.metrics.lineH
+		line := image.Rect(x+s.from*n.metrics.cellW, y-thick, x+s.to*n.metrics.cellW, y)
+		draw.Draw(d.img, line.Intersect(n.rect).Intersect(clip), src, image.Point{}, draw.Over)
+	}
+}
+
+// drawSelection highlights an input's selected text with its text color.
+func (d *rasterDrawer) drawSelection(node *RenderNode, n rasterNode, clip image.Rectangle) {
+	src := image.NewUniform(rgba(n.style.FG, rasterForeground))
+	for _, rc := range d.selectionRects(node, n.rect, n.metrics) {
+		draw.DrawMask(d.img, rc.Intersect(clip), src, image.Point{}, image.NewUniform(color.Alpha{0x50}), image.Point{}, draw.Over)
+	}
+}
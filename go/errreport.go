@@ -0,0 +1,69 @@
+package viewer
+
+import "fmt"
+
+// Error reports. Whenever the viewer drops or fails something the source
+// sent, it says so upstream with a MsgError, so the source can log it or
+// adapt — resend, slow down, send less — rather than drift out of sync
+// without knowing. Reports are sent for frames that fail to decode, patch
+// ops that fail to apply, messages rejected by a quota, requirements the
+// viewer cannot meet, triggers naming a slot that is not a sound, and
+// requests its policy refuses.
+
+// Error codes reported in ErrorReport.Code.
+const (
+	ErrorCodeDecode       = "decode"       // a frame's payload failed to decode
+	ErrorCodePatch        = "patch"        // a patch op failed to apply
+	ErrorCodeQuota        = "quota"        // a message exceeded a quota (see Quotas)
+	ErrorCodeIncompatible = "incompatible" // the source's requirements cannot be met
+	ErrorCodeSlot         = "slot"         // a message named an undefined slot, or one of the wrong kind
+	ErrorCodeDenied       = "denied"       // a request the viewer's policy does not allow
+)
+
+// ErrorReport describes a message the viewer rejected or failed to apply.
+type ErrorReport struct {
+	Code    string      `json:"code" cbor:"code"`
+	Message string      `json:"message,omitempty" cbor:"message,omitempty"`
+	MsgType MessageType `json:"msgType,omitempty" cbor:"msgType,omitempty"` // type of the offending message
+
+	Target *int `json:"target,omitempty" cbor:"target,omitempty"` // node the failure concerns
+	Slot   *int `json:"slot,omitempty" cbor:"slot,omitempty"`     // slot or schema the failure concerns
+	Op     *int `json:"op,omitempty" cbor:"op,omitempty"`         // index of the failed op in its PATCH
+}
+
+// reportUpstream sends an ErrorReport to the source.
+// Must be called with the mutex held.
+func (v *Viewer) reportUpstream(r ErrorReport) {
+	v.emit(ProtocolMessage{Type: MsgError, Error: &r})
+}
+
+// reportPatchFailure reports the failed op at index i of a PATCH.
+// Must be called with the mutex held.
+func (v *Viewer) reportPatchFailure(i int, op PatchOp) {
+	target := op.Target
+	v.reportUpstream(ErrorReport{
+		Code:    ErrorCodePatch,
+		Message: fmt.Sprintf("op %d (target %d) failed to apply", i, op.Target),
+		MsgType: MsgPatch,
+		Target:  &target,
+		Op:      &i,
+	})
+}
+
+// reportQuota reports a message rejected by a quota.
+// Must be called with the mutex held.
+func (v *Viewer) reportQuota(q QuotaViolation) {
+	r := ErrorReport{
+		Code:    ErrorCodeQuota,
+		Message: fmt.Sprintf("%s: %d exceeds limit %d", q.Kind, q.Value, q.Limit),
+		MsgType: q.MsgType,
+	}
+	target := q.Target
+	switch q.Kind {
+	case QuotaSlots, QuotaDataRows:
+		r.Slot = &target
+	case QuotaImageBytes:
+		r.Target = &target
+	}
+	v.reportUpstream(r)
+}
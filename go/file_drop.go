@@ -0,0 +1,184 @@
+package viewer
+
+import (
+	"errors"
+	"fmt"
+)
+
+// File drops. When the user drops a file onto the UI, the host passes it
+// to DropFile, and the viewer sends the source a "drop" input event aimed
+// at the node it landed on, describing the file (InputEvent.File), then
+// the content in MsgFile chunks, the last one marked Final. Chunks keep
+// any one frame small, so a large file does not hold up the messages
+// around it for long. The viewer refuses files over its FileLimits and
+// drops on disabled nodes.
+//
+// A FileAssembler on the receiving side puts the chunks back together,
+// enforcing its own limits, since a source cannot trust a viewer's.
+
+// Errors returned by DropFile and FileAssembler.
+var (
+	ErrFileTooLarge = errors.New("file exceeds size limit")
+	ErrDropDisabled = errors.New("drop target is disabled")
+	ErrFileChunk    = errors.New("file chunk out of sequence")
+	ErrTooManyFiles = errors.New("too many files in transfer")
+)
+
+// FileInfo describes a dropped file, in its "drop" InputEvent.
+type FileInfo struct {
+	ID   uint64 `json:"id" cbor:"id"` // names the file in its chunks
+	Name string `json:"name" cbor:"name"`
+	Mime string `json:"mime,omitempty" cbor:"mime,omitempty"`
+	Size int    `json:"size" cbor:"size"` // bytes
+}
+
+// FileChunk is a piece of a dropped file's content, in a MsgFile.
+type FileChunk struct {
+	ID     uint64 `json:"id" cbor:"id"`
+	Offset int    `json:"offset" cbor:"offset"`
+	Data   []byte `json:"data,omitempty" cbor:"data,omitempty"`
+	Final  bool   `json:"final,omitempty" cbor:"final,omitempty"` // the last chunk of the file
+}
+
+// FileLimits bounds the files a viewer sends. Zero fields take the
+// DefaultFileLimits value.
+type FileLimits struct {
+	MaxBytes   int // largest file accepted
+	ChunkBytes int // content per MsgFile
+}
+
+// DefaultFileLimits are the limits of a viewer that has not set any.
+var DefaultFileLimits = FileLimits{MaxBytes: 16 << 20, ChunkBytes: 64 << 10}
+
+// withDefaults fills zero fields from DefaultFileLimits.
+func (l FileLimits) withDefaults() FileLimits {
+	if l.MaxBytes <= 0 {
+		l.MaxBytes = DefaultFileLimits.MaxBytes
+	}
+	if l.ChunkBytes <= 0 {
+		l.ChunkBytes = DefaultFileLimits.ChunkBytes
+	}
+	return l
+}
+
+// SetFileLimits sets the limits DropFile enforces.
+func (v *Viewer) SetFileLimits(l FileLimits) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.fileLimits = l
+}
+
+// DropFile sends the source a file dropped onto target (nil if it landed
+// on no node): a "drop" input event, then its content in chunks. Returns
+// ErrFileTooLarge if data exceeds the viewer's FileLimits, and
+// ErrDropDisabled if target is disabled; nothing is sent then.
+func (v *Viewer) DropFile(target *int, name, mime string, data []byte) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	l := v.fileLimits.withDefaults()
+	if len(data) > l.MaxBytes {
+		return fmt.Errorf("%s: %w (%d > %d bytes)", name, ErrFileTooLarge, len(data), l.MaxBytes)
+	}
+	if target != nil && v.disabled(*target) {
+		return ErrDropDisabled
+	}
+
+	v.fileNext++
+	id := v.fileNext
+	info := FileInfo{ID: id, Name: name, Mime: mime, Size: len(data)}
+	v.emit(ProtocolMessage{Type: MsgInput, Event: &InputEvent{Target: target, Kind: "drop", File: &info}})
+	for offset := 0; ; offset += l.ChunkBytes {
+		end := min(offset+l.ChunkBytes, len(data))
+		chunk := FileChunk{ID: id, Offset: offset, Data: data[offset:end], Final: end == len(data)}
+		v.emit(ProtocolMessage{Type: MsgFile, Chunk: &chunk})
+		if chunk.Final {
+			return nil
+		}
+	}
+}
+
+// DroppedFile is a file put back together by a FileAssembler.
+type DroppedFile struct {
+	FileInfo
+	Target *int // node the file was dropped on
+	Data   []byte
+}
+
+// FileAssembler reassembles dropped files from their "drop" events and
+// MsgFile chunks. It is not safe for concurrent use.
+type FileAssembler struct {
+	// MaxBytes caps the size of each file, and MaxFiles how many may be
+	// in transfer at once. Zero means DefaultFileLimits.MaxBytes and 4.
+	MaxBytes int
+	MaxFiles int
+
+	files map[uint64]*DroppedFile
+}
+
+// Add takes a message from the viewer. It returns the file a final chunk
+// completes, and nil for anything else. A file that breaks the limits,
+// or whose chunks arrive out of order, is abandoned with an error; later
+// chunks of it are ignored.
+func (a *FileAssembler) Add(msg ProtocolMessage) (*DroppedFile, error) {
+	switch {
+	case msg.Type == MsgInput && msg.Event != nil && msg.Event.Kind == "drop" && msg.Event.File != nil:
+		return nil, a.start(msg.Event)
+	case msg.Type == MsgFile && msg.Chunk != nil:
+		return a.chunk(msg.Chunk)
+	}
+	return nil, nil
+}
+
+// start begins a file announced by a drop event.
+func (a *FileAssembler) start(event *InputEvent) error {
+	info := *event.File
+	if info.Size > a.maxBytes() {
+		return fmt.Errorf("%s: %w (%d > %d bytes)", info.Name, ErrFileTooLarge, info.Size, a.maxBytes())
+	}
+	maxFiles := a.MaxFiles
+	if maxFiles <= 0 {
+		maxFiles = 4
+	}
+	if len(a.files) >= maxFiles {
+		return fmt.Errorf("%s: %w", info.Name, ErrTooManyFiles)
+	}
+	if a.files == nil {
+		a.files = make(map[uint64]*DroppedFile)
+	}
+	a.files[info.ID] = &DroppedFile{FileInfo: info, Target: event.Target, Data: make([]byte, 0, info.Size)}
+	return nil
+}
+
+// chunk appends a chunk to its file, returning the file if it is done.
+func (a *FileAssembler) chunk(c *FileChunk) (*DroppedFile, error) {
+	f, ok := a.files[c.ID]
+	if !ok {
+		return nil, nil
+	}
+	switch {
+	case c.Offset != len(f.Data):
+		delete(a.files, c.ID)
+		return nil, fmt.Errorf("%s: %w (offset %d, have %d bytes)", f.Name, ErrFileChunk, c.Offset, len(f.Data))
+	case len(f.Data)+len(c.Data) > f.Size:
+		delete(a.files, c.ID)
+		return nil, fmt.Errorf("%s: %w (more than the %d bytes announced)", f.Name, ErrFileTooLarge, f.Size)
+	}
+	f.Data = append(f.Data, c.Data...)
+	if !c.Final {
+		return nil, nil
+	}
+	delete(a.files, c.ID)
+	if len(f.Data) != f.Size {
+		return nil, fmt.Errorf("%s: %w (%d of %d bytes)", f.Name, ErrFileChunk, len(f.Data), f.Size)
+	}
+	return f, nil
+}
+
+// maxBytes returns the per-file size limit in effect.
+func (a *FileAssembler) maxBytes() int {
+	if a.MaxBytes > 0 {
+		return a.MaxBytes
+	}
+	return DefaultFileLimits.MaxBytes
+}
@@ -0,0 +1,123 @@
+package viewer
+
+// Flow control: when a source produces messages faster than the viewer can
+// render them, inbound messages are held in a bounded queue instead of
+// piling up without limit. The viewer grants the source credit (MsgCredit)
+// for each message it consumes, so a well-behaved source never has more
+// than the window outstanding.
+
+// FlowConfig configures inbound flow control.
+type FlowConfig struct {
+	// QueueSize is the maximum number of queued messages. Must be > 0.
+	QueueSize int
+
+	// Window is the initial credit granted to the source. Defaults to
+	// QueueSize when zero.
+	Window int
+
+	// DropOldest evicts the oldest queued message when the queue is full.
+	// By default the incoming message is dropped instead. Either way a
+	// dropped sequenced frame shows up as a gap and triggers a resync.
+	DropOldest bool
+}
+
+// flowState is the viewer's inbound queue.
+type flowState struct {
+	cfg   FlowConfig
+	queue []ProtocolMessage
+}
+
+// EnableFlowControl turns on the bounded inbound queue and sends the
+// initial credit window upstream. Messages must then be submitted with
+// Enqueue and processed with Pump; ProcessMessage still bypasses the queue.
+// Serve and ServeCtx enqueue what they read, leaving the host to Pump.
+func (v *Viewer) EnableFlowControl(cfg FlowConfig) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 1
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = cfg.QueueSize
+	}
+	v.flow = &flowState{
+		cfg:   cfg,
+		queue: make([]ProtocolMessage, 0, cfg.QueueSize),
+	}
+	v.grantCredit(cfg.Window)
+}
+
+// Enqueue adds an inbound message to the flow-control queue. Returns false
+// if a message had to be dropped because the queue was full. If flow
+// control is disabled the message is processed immediately.
+func (v *Viewer) Enqueue(msg ProtocolMessage) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.enqueue(msg)
+}
+
+// enqueue is Enqueue without locking.
+// Must be called with the mutex held.
+func (v *Viewer) enqueue(msg ProtocolMessage) bool {
+	if v.flow == nil {
+		v.processMessage(msg)
+		return true
+	}
+
+	f := v.flow
+	if len(f.queue) < f.cfg.QueueSize {
+		f.queue = append(f.queue, msg)
+		v.framesDeferred++
+		return true
+	}
+
+	v.framesDropped++
+	if f.cfg.DropOldest {
+		f.queue = append(f.queue[1:], msg)
+		v.framesDeferred++
+	}
+	return false
+}
+
+// Pump processes up to max queued messages (all of them if max <= 0) and
+// grants the source one credit per message consumed. Returns the number
+// processed.
+func (v *Viewer) Pump(max int) int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.flow == nil {
+		return 0
+	}
+
+	f := v.flow
+	n := len(f.queue)
+	if max > 0 && max < n {
+		n = max
+	}
+	for _, msg := range f.queue[:n] {
+		v.processMessage(msg)
+	}
+	f.queue = append(f.queue[:0], f.queue[n:]...)
+
+	if n > 0 {
+		v.grantCredit(n)
+	}
+	return n
+}
+
+// grantCredit sends a MsgCredit upstream.
+// Must be called with the mutex held.
+func (v *Viewer) grantCredit(n int) {
+	v.emit(ProtocolMessage{Type: MsgCredit, Credit: &n})
+}
+
+// queuedFrames returns the current inbound queue depth.
+// Must be called with the mutex held.
+func (v *Viewer) queuedFrames() int {
+	if v.flow == nil {
+		return 0
+	}
+	return len(v.flow.queue)
+}
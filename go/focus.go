@@ -0,0 +1,235 @@
+package viewer
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+// Focus and keyboard input. The viewer keeps the focused node itself, so
+// tab traversal needs no round trip to the source. Inputs and nodes that
+// are focusable or clickable take focus unless their tabIndex is
+// negative. Tab order puts nodes with a positive tabIndex first, lowest
+// first, then the rest in tree order, as on the web; tab and shift+tab
+// move through it and wrap around. Moving focus sends the source a blur
+// for the node that had it and a focus for the node that gets it.
+//
+// A node with the disabled prop disables its whole subtree: the nodes in
+// it are skipped in tab order, pressing them does nothing, renderers draw
+// them faint, and click, key, and value_change events aimed at them are
+// dropped rather than sent to the source.
+
+// disabledKinds are the input event kinds dropped for disabled targets.
+var disabledKinds = map[string]bool{"click": true, "key": true, "value_change": true, "change": true, "drop": true}
+
+// isDisabled reports whether a node sets the disabled prop.
+func isDisabled(node *RenderNode) bool {
+	return node.Props.Disabled != nil && *node.Props.Disabled
+}
+
+// disabled reports whether a node or one of its ancestors is disabled.
+// Must be called with the mutex held.
+func (v *Viewer) disabled(nodeID int) bool {
+	node := v.tree.NodeIndex[nodeID]
+	if node == nil {
+		return false
+	}
+	if isDisabled(node) {
+		return true
+	}
+	for _, a := range ancestors(v.tree.Root, nodeID) {
+		if isDisabled(a) {
+			return true
+		}
+	}
+	return false
+}
+
+// focusable reports whether a node can take focus, ignoring whether it
+// is disabled.
+func focusable(node *RenderNode) bool {
+	if node.Props.TabIndex != nil && *node.Props.TabIndex < 0 {
+		return false
+	}
+	return node.Type == NodeInput || node.Type == NodeTable || pressable(node) || node.Props.Interactive == "focusable"
+}
+
+// tabOrder returns the nodes that can take focus, in tab order.
+func tabOrder(root *RenderNode) []*RenderNode {
+	var nodes []*RenderNode
+	var walk func(node *RenderNode)
+	walk = func(node *RenderNode) {
+		if isDisabled(node) || isHidden(node) {
+			return
+		}
+		if focusable(node) {
+			nodes = append(nodes, node)
+		}
+		for _, child := range node.Children {
+			walk(child)
+		}
+	}
+	if root != nil {
+		walk(root)
+	}
+	rank := func(n *RenderNode) int {
+		if n.Props.TabIndex != nil && *n.Props.TabIndex > 0 {
+			return *n.Props.TabIndex
+		}
+		return math.MaxInt
+	}
+	sort.SliceStable(nodes, func(i, j int) bool { return rank(nodes[i]) < rank(nodes[j]) })
+	return nodes
+}
+
+// Focus moves focus to a node. Returns false if the node cannot take
+// focus, is disabled or hidden, or is outside an open modal overlay.
+func (v *Viewer) Focus(nodeID int) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	node := v.tree.NodeIndex[nodeID]
+	if node == nil || !focusable(node) || v.disabled(nodeID) || v.hidden(nodeID) || v.captured(nodeID) {
+		return false
+	}
+	v.setFocus(&nodeID)
+	return true
+}
+
+// Focused returns the focused node, if any.
+func (v *Viewer) Focused() (nodeID int, ok bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.tree.Focused == nil {
+		return 0, false
+	}
+	return *v.tree.Focused, true
+}
+
+// Key handles a key press, named as in keybind slots ("tab",
+// "shift+tab", "ctrl+s"). Tab and shift+tab move focus, clipboard keys
+// copy, cut, and paste, and keys that edit the focused input or work
+// the focused control, table or scroll node are applied to it; other
+// keys are sent to the source aimed at the focused node, or at the
+// topmost modal overlay if focus is outside it.
+// Returns whether the viewer consumed the key.
+func (v *Viewer) Key(key string) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.heldKey = nil
+	return v.key(key, nil)
+}
+
+// key is the body of Key and KeyInput, handling key by name; e is the
+// key event it came from, if any.
+// Must be called with the mutex held.
+func (v *Viewer) key(key string, e *KeyEvent) bool {
+	if v.tree.Cursor.composing() {
+		// The IME has the keys.
+		return false
+	}
+	up := e != nil && e.Action == KeyUp
+	switch strings.ToLower(key) {
+	case "tab":
+		if !up {
+			return v.focusStep(1)
+		}
+	case "shift+tab":
+		if !up {
+			return v.focusStep(-1)
+		}
+	case "escape":
+		if !up && v.tree.Tooltip != nil {
+			v.hoverTooltip(nil)
+			return true
+		}
+	}
+	var target *int
+	if v.tree.Focused != nil {
+		id := *v.tree.Focused
+		if v.disabled(id) {
+			return false
+		}
+		target = &id
+	}
+	if m := topModal(v.tree.Root); m != nil && (target == nil || v.captured(*target)) {
+		id := m.ID
+		event := keyEvent(&id, key, e)
+		v.emit(ProtocolMessage{Type: MsgInput, Event: &event})
+		return false
+	}
+	if !up && (v.clipboardKey(key, target) || target != nil && (v.editKey(key) || v.controlKey(key) || v.tableKey(key) || v.scrollKey(key))) {
+		return true
+	}
+	event := keyEvent(target, key, e)
+	v.emit(ProtocolMessage{Type: MsgInput, Event: &event})
+	return false
+}
+
+// focusStep moves focus dir places along the tab order, wrapping around,
+// within the topmost modal overlay if one is open. With nothing
+// focused, it goes to the first node, or the last if stepping back.
+// Returns false if nothing can take focus.
+// Must be called with the mutex held.
+func (v *Viewer) focusStep(dir int) bool {
+	scope := v.tree.Root
+	if m := topModal(scope); m != nil {
+		scope = m
+	}
+	order := tabOrder(scope)
+	if len(order) == 0 {
+		return false
+	}
+	next := 0
+	if dir < 0 {
+		next = len(order) - 1
+	}
+	if v.tree.Focused != nil {
+		for i, n := range order {
+			if n.ID == *v.tree.Focused {
+				next = (i + dir + len(order)) % len(order)
+				break
+			}
+		}
+	}
+	v.setFocus(&order[next].ID)
+	return true
+}
+
+// setFocus moves focus to a node, or clears it if id is nil, and tells
+// the source.
+// Must be called with the mutex held.
+func (v *Viewer) setFocus(id *int) {
+	old := v.tree.Focused
+	if old != nil && id != nil && *old == *id {
+		return
+	}
+	if id != nil {
+		n := *id
+		id = &n
+	}
+	if c := v.tree.Cursor; c != nil {
+		if node := v.tree.NodeIndex[c.Node]; node != nil {
+			v.commitInput(node)
+		}
+	}
+	v.tree.Focused = id
+	v.startEditing()
+	var focused *RenderNode
+	if id != nil {
+		focused = v.tree.NodeIndex[*id]
+	}
+	v.hoverTooltip(focused)
+	if old != nil {
+		event := InputEvent{Target: old, Kind: "blur"}
+		v.emit(ProtocolMessage{Type: MsgInput, Event: &event})
+	}
+	if id != nil {
+		n := *id
+		event := InputEvent{Target: &n, Kind: "focus"}
+		v.emit(ProtocolMessage{Type: MsgInput, Event: &event})
+	}
+	v.markDirty()
+	v.signalChanged()
+}
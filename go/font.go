@@ -0,0 +1,229 @@
+package viewer
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sync"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/gofont/gomonobold"
+	"golang.org/x/image/font/gofont/gomonobolditalic"
+	"golang.org/x/image/font/gofont/gomonoitalic"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// Raster text. The rasterizer draws text on the same monospace grid the
+// pixel layout measures it with: every cluster takes one or two cells of
+// a width and line height set by the font size (8 × 20 pixels at the
+// default 14px), so alignment, wrapping, and bidi reordering agree
+// between layout and drawing.
+//
+// Glyphs come from the embedded 7×13 bitmap font unless the rasterizer
+// has a FontFamily of TrueType/OpenType fonts. Bold and italic use the
+// family's variants where it has them; otherwise, and always for the
+// bitmap font, bold is synthesized by overstriking and italic by
+// shearing. The bitmap font is magnified by whole multiples for larger
+// sizes.
+
+// defaultFontSize is the font size, in pixels, of text without a size
+// prop: the size PixelLayoutOptions' text metrics are for.
+const defaultFontSize = 14
+
+// FontFamily is a set of OpenType fonts for raster text. Any variant but
+// Regular may be nil.
+type FontFamily struct {
+	Regular    *opentype.Font
+	Bold       *opentype.Font
+	Italic     *opentype.Font
+	BoldItalic *opentype.Font
+}
+
+// ParseFontFamily parses TrueType or OpenType font files. regular is
+// required; the other variants may be nil.
+func ParseFontFamily(regular, bold, italic, boldItalic []byte) (*FontFamily, error) {
+	var f FontFamily
+	for _, v := range []struct {
+		data []byte
+		dst  **opentype.Font
+		name string
+	}{
+		{regular, &f.Regular, "regular"},
+		{bold, &f.Bold, "bold"},
+		{italic, &f.Italic, "italic"},
+		{boldItalic, &f.BoldItalic, "bold italic"},
+	} {
+		if v.data == nil {
+			continue
+		}
+		fnt, err := opentype.Parse(v.data)
+		if err != nil {
+			return nil, fmt.Errorf("font: parse %s: %w", v.name, err)
+		}
+		*v.dst = fnt
+	}
+	if f.Regular == nil {
+		return nil, fmt.Errorf("font: no regular font")
+	}
+	return &f, nil
+}
+
+var (
+	goMonoOnce sync.Once
+	goMono     *FontFamily
+)
+
+// GoMonoFonts returns the embedded Go Mono family, a monospace TrueType
+// font that matches the layout's text metrics.
+func GoMonoFonts() *FontFamily {
+	goMonoOnce.Do(func() {
+		f, err := ParseFontFamily(gomono.TTF, gomonobold.TTF, gomonoitalic.TTF, gomonobolditalic.TTF)
+		if err != nil {
+			panic(err)
+		}
+		goMono = f
+	})
+	return goMono
+}
+
+// textMetrics are the text grid dimensions for one font size, in pixels.
+type textMetrics struct {
+	size     float64
+	cellW    int
+	lineH    int
+	baseline int
+}
+
+// rasterMetrics returns the text metrics for a font size prop, or the
+// defaults for nil.
+func rasterMetrics(size *int) textMetrics {
+	if size == nil || *size <= 0 || *size == defaultFontSize {
+		return textMetrics{defaultFontSize, rasterCharWidth, rasterLineHeight, rasterBaseline}
+	}
+	k := float64(*size) / defaultFontSize
+	return textMetrics{
+		size:     float64(*size),
+		cellW:    max(1, int(math.Round(rasterCharWidth*k))),
+		lineH:    max(1, int(math.Round(rasterLineHeight*k))),
+		baseline: int(math.Round(rasterBaseline * k)),
+	}
+}
+
+// rasterFace is a face chosen for one text style and size.
+type rasterFace struct {
+	face   font.Face
+	base   textMetrics // metrics the face draws at before magnification
+	scale  int         // whole-number magnification of bitmap faces
+	bold   bool        // synthesize bold
+	italic bool        // synthesize italic
+}
+
+// faceKey identifies a cached face.
+type faceKey struct {
+	bold, italic bool
+	size         float64
+}
+
+// fontCache opens faces from a family on demand. Faces are not safe for
+// concurrent use, so each Rasterizer keeps its own.
+type fontCache struct {
+	family *FontFamily
+	faces  map[faceKey]rasterFace
+}
+
+// face returns the face for a style at metrics m.
+func (c *fontCache) face(style CellStyle, m textMetrics) rasterFace {
+	if c.family == nil {
+		scale := max(1, int(m.size/defaultFontSize))
+		return rasterFace{face: basicfont.Face7x13, base: rasterMetrics(nil), scale: scale, bold: style.Bold, italic: style.Italic}
+	}
+	key := faceKey{style.Bold, style.Italic, m.size}
+	if f, ok := c.faces[key]; ok {
+		return f
+	}
+
+	fnt, bold, italic := c.family.Regular, style.Bold, style.Italic
+	switch {
+	case style.Bold && style.Italic && c.family.BoldItalic != nil:
+		fnt, bold, italic = c.family.BoldItalic, false, false
+	case style.Bold && c.family.Bold != nil:
+		fnt, bold = c.family.Bold, false
+	case style.Italic && c.family.Italic != nil:
+		fnt, italic = c.family.Italic, false
+	}
+	// Size the face so a monospace advance fills a cell, as the bitmap
+	// font's 7px advance does at 13px.
+	face, err := opentype.NewFace(fnt, &opentype.FaceOptions{Size: m.size * 13 / defaultFontSize, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return rasterFace{face: basicfont.Face7x13, base: rasterMetrics(nil), scale: 1, bold: style.Bold, italic: style.Italic}
+	}
+	f := rasterFace{face: face, base: m, scale: 1, bold: bold, italic: italic}
+	if c.faces == nil {
+		c.faces = make(map[faceKey]rasterFace)
+	}
+	c.faces[key] = f
+	return f
+}
+
+// glyph draws one cluster spanning cells cells with its cell's top-left
+// corner at (x, y), through a mask when the face is synthesized italic
+// or magnified.
+func (f rasterFace) glyph(dst draw.Image, src image.Image, cluster string, x, y, cells int, m textMetrics) {
+	if f.scale == 1 && !f.italic {
+		d := font.Drawer{Dst: dst, Src: src, Face: f.face}
+		for dx := 0; dx <= boolInt(f.bold); dx++ {
+			d.Dot = fixed.P(x+dx, y+m.baseline)
+			d.DrawString(cluster)
+		}
+		return
+	}
+
+	// Draw into a mask with room on the left for the shear, slant it
+	// about the baseline, then magnify it into place.
+	const pad = 4
+	b := f.base
+	mask := image.NewAlpha(image.Rect(0, 0, cells*b.cellW+2*pad, b.lineH))
+	d := font.Drawer{Dst: mask, Src: image.Opaque, Face: f.face}
+	for dx := 0; dx <= boolInt(f.bold); dx++ {
+		d.Dot = fixed.P(pad+dx, b.baseline)
+		d.DrawString(cluster)
+	}
+	if f.italic {
+		mask = shear(mask, b.baseline)
+	}
+	top := y + m.baseline - b.baseline*f.scale
+	r := image.Rect(x-pad*f.scale, top, x+(cells*b.cellW+pad)*f.scale, top+b.lineH*f.scale)
+	if f.scale > 1 {
+		big := image.NewAlpha(image.Rect(0, 0, r.Dx(), r.Dy()))
+		draw.NearestNeighbor.Scale(big, big.Bounds(), mask, mask.Bounds(), draw.Src, nil)
+		mask = big
+	}
+	draw.DrawMask(dst, r, src, image.Point{}, mask, image.Point{}, draw.Over)
+}
+
+// shear slants a glyph mask right by one pixel per four rows above the
+// baseline, and left below it.
+func shear(mask *image.Alpha, baseline int) *image.Alpha {
+	out := image.NewAlpha(mask.Bounds())
+	w := mask.Bounds().Dx()
+	for y := 0; y < mask.Bounds().Dy(); y++ {
+		shift := (baseline - y) / 4
+		for x := 0; x < w; x++ {
+			if sx := x - shift; sx >= 0 && sx < w {
+				out.Pix[y*out.Stride+x] = mask.Pix[y*mask.Stride+sx]
+			}
+		}
+	}
+	return out
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
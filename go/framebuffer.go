@@ -0,0 +1,103 @@
+package viewer
+
+import (
+	"fmt"
+	"image"
+)
+
+// Software framebuffer output. A FramebufferTarget with a Buffer renders
+// each frame with the Rasterizer and copies the damaged regions into the
+// caller's pixel memory, converting to its pixel format. Game engines,
+// kiosks, and compositors can hand the viewer a texture upload buffer or
+// a mapped display buffer and present only the rectangles reported by
+// TakeRenderResult.
+
+// PixelFormat is the memory layout of one framebuffer pixel.
+type PixelFormat int
+
+const (
+	PixelRGBA   PixelFormat = iota // bytes R, G, B, A
+	PixelBGRA                      // bytes B, G, R, A (most compositors, Windows DIBs)
+	PixelRGB565                    // 16-bit little-endian, 5 bits red, 6 green, 5 blue
+)
+
+// BytesPerPixel returns the size of one pixel in bytes.
+func (f PixelFormat) BytesPerPixel() int {
+	if f == PixelRGB565 {
+		return 2
+	}
+	return 4
+}
+
+func (f PixelFormat) String() string {
+	switch f {
+	case PixelRGBA:
+		return "rgba"
+	case PixelBGRA:
+		return "bgra"
+	case PixelRGB565:
+		return "rgb565"
+	}
+	return fmt.Sprintf("PixelFormat(%d)", int(f))
+}
+
+// Framebuffer is caller-owned pixel memory the viewer renders into. Row y
+// starts at Pix[y*Stride]; Stride may exceed Width times the pixel size
+// for padded rows.
+type Framebuffer struct {
+	Pix    []byte
+	Width  int
+	Height int
+	Stride int
+	Format PixelFormat
+}
+
+// NewFramebuffer allocates a tightly packed width × height buffer.
+func NewFramebuffer(width, height int, format PixelFormat) *Framebuffer {
+	width, height = max(width, 0), max(height, 0)
+	stride := width * format.BytesPerPixel()
+	return &Framebuffer{Pix: make([]byte, stride*height), Width: width, Height: height, Stride: stride, Format: format}
+}
+
+// Validate reports whether the buffer's dimensions and memory agree.
+func (f *Framebuffer) Validate() error {
+	bpp := f.Format.BytesPerPixel()
+	switch {
+	case f.Format < PixelRGBA || f.Format > PixelRGB565:
+		return fmt.Errorf("framebuffer: unknown pixel format %v", f.Format)
+	case f.Width < 0 || f.Height < 0:
+		return fmt.Errorf("framebuffer: negative size %dx%d", f.Width, f.Height)
+	case f.Stride < f.Width*bpp:
+		return fmt.Errorf("framebuffer: stride %d shorter than a %d-pixel %v row", f.Stride, f.Width, f.Format)
+	case f.Height > 0 && len(f.Pix) < (f.Height-1)*f.Stride+f.Width*bpp:
+		return fmt.Errorf("framebuffer: %d bytes too small for %dx%d with stride %d", len(f.Pix), f.Width, f.Height, f.Stride)
+	}
+	return nil
+}
+
+// Copy converts the given regions of img into the buffer. Regions are
+// clipped to both the image and the buffer.
+func (f *Framebuffer) Copy(img *image.RGBA, rects []image.Rectangle) {
+	bounds := img.Bounds().Intersect(image.Rect(0, 0, f.Width, f.Height))
+	bpp := f.Format.BytesPerPixel()
+	for _, rc := range rects {
+		rc = rc.Intersect(bounds)
+		for y := rc.Min.Y; y < rc.Max.Y; y++ {
+			src := img.Pix[img.PixOffset(rc.Min.X, y):img.PixOffset(rc.Max.X, y)]
+			dst := f.Pix[y*f.Stride+rc.Min.X*bpp : y*f.Stride+rc.Max.X*bpp]
+			switch f.Format {
+			case PixelRGBA:
+				copy(dst, src)
+			case PixelBGRA:
+				for i := 0; i < len(src); i += 4 {
+					dst[i], dst[i+1], dst[i+2], dst[i+3] = src[i+2], src[i+1], src[i], src[i+3]
+				}
+			case PixelRGB565:
+				for i, j := 0, 0; i < len(src); i, j = i+4, j+2 {
+					p := uint16(src[i]>>3)<<11 | uint16(src[i+1]>>2)<<5 | uint16(src[i+2]>>3)
+					dst[j], dst[j+1] = byte(p), byte(p>>8)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package viewer
+
+import (
+	"math"
+	"time"
+)
+
+// Gamepads. For kiosk and couch UIs on the framebuffer and texture
+// targets, hosts poll their controllers and pass each one's state to
+// Gamepad every frame. The viewer works out what changed:
+//
+//   - The d-pad and left stick move focus to the nearest focusable node
+//     in that direction on screen, repeating while held.
+//   - A activates the focused node as a click, or as space on a form
+//     control; B acts as the escape key.
+//   - Other buttons go to the source as "gamepad" events, with the
+//     button in Key and "press" or "release" in Action.
+//   - The right stick and triggers go to the source as "gamepad_axis"
+//     events, with the axis in Key and its position in Axis, when they
+//     move.
+//
+// Events are aimed at the focused node and carry the controller's index
+// in Pad.
+
+// GamepadButtons is a set of gamepad buttons.
+type GamepadButtons uint32
+
+// Gamepad buttons, in the standard layout.
+const (
+	ButtonA GamepadButtons = 1 << iota
+	ButtonB
+	ButtonX
+	ButtonY
+	ButtonLB
+	ButtonRB
+	ButtonBack
+	ButtonStart
+	ButtonLeftStick
+	ButtonRightStick
+	ButtonUp
+	ButtonDown
+	ButtonLeft
+	ButtonRight
+)
+
+// gamepadButtonNames names the buttons sent to the source.
+var gamepadButtonNames = []struct {
+	button GamepadButtons
+	name   string
+}{
+	{ButtonX, "x"}, {ButtonY, "y"}, {ButtonLB, "lb"}, {ButtonRB, "rb"},
+	{ButtonBack, "back"}, {ButtonStart, "start"},
+	{ButtonLeftStick, "left_stick"}, {ButtonRightStick, "right_stick"},
+}
+
+// GamepadState is a snapshot of a controller. Stick axes run from -1 to
+// 1, positive right and down; triggers from 0 to 1.
+type GamepadState struct {
+	Buttons        GamepadButtons
+	LeftX, LeftY   float64
+	RightX, RightY float64
+	LeftTrigger    float64
+	RightTrigger   float64
+}
+
+const (
+	// gamepadDeadZone is how far an axis moves from rest before it counts.
+	gamepadDeadZone = 0.15
+	// gamepadAxisStep is the least change of an axis that is reported.
+	gamepadAxisStep = 0.05
+	// gamepadNavThreshold is how far the left stick leans to navigate.
+	gamepadNavThreshold = 0.5
+
+	// Held directions repeat after gamepadRepeatDelay, then every
+	// gamepadRepeatInterval.
+	gamepadRepeatDelay    = 400 * time.Millisecond
+	gamepadRepeatInterval = 100 * time.Millisecond
+)
+
+// gamepadState is what the viewer remembers of a controller.
+type gamepadState struct {
+	last   GamepadState
+	dx, dy int       // direction held, if any
+	repeat time.Time // when the held direction next repeats
+}
+
+// Gamepad takes the current state of controller pad. Returns whether
+// the viewer consumed any of the changes since the last state: moved
+// focus or activated the focused node.
+func (v *Viewer) Gamepad(pad int, state GamepadState) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.gamepads == nil {
+		v.gamepads = make(map[int]*gamepadState)
+	}
+	g := v.gamepads[pad]
+	if g == nil {
+		g = &gamepadState{}
+		v.gamepads[pad] = g
+	}
+	last := g.last
+	g.last = state
+	pressed := state.Buttons &^ last.Buttons
+	released := last.Buttons &^ state.Buttons
+	consumed := false
+
+	// Navigation.
+	dx, dy := gamepadDirection(state)
+	now := v.now()
+	switch {
+	case dx == 0 && dy == 0:
+		g.dx, g.dy = 0, 0
+	case dx != g.dx || dy != g.dy:
+		g.dx, g.dy = dx, dy
+		g.repeat = now.Add(gamepadRepeatDelay)
+		consumed = v.focusToward(dx, dy) || consumed
+	case !now.Before(g.repeat):
+		g.repeat = now.Add(gamepadRepeatInterval)
+		consumed = v.focusToward(dx, dy) || consumed
+	}
+
+	if pressed&ButtonA != 0 {
+		consumed = v.gamepadActivate() || consumed
+	}
+	if pressed&ButtonB != 0 {
+		consumed = v.key("escape", nil) || consumed
+	}
+	for _, b := range gamepadButtonNames {
+		switch {
+		case pressed&b.button != 0:
+			v.emitGamepad(pad, InputEvent{Kind: "gamepad", Key: b.name, Action: "press"})
+		case released&b.button != 0:
+			v.emitGamepad(pad, InputEvent{Kind: "gamepad", Key: b.name, Action: "release"})
+		}
+	}
+
+	axes := []struct {
+		name      string
+		now, last float64
+	}{
+		{"right_x", state.RightX, last.RightX},
+		{"right_y", state.RightY, last.RightY},
+		{"left_trigger", state.LeftTrigger, last.LeftTrigger},
+		{"right_trigger", state.RightTrigger, last.RightTrigger},
+	}
+	for _, a := range axes {
+		pos, was := deadZone(a.now), deadZone(a.last)
+		if math.Abs(pos-was) >= gamepadAxisStep || (pos == 0) != (was == 0) {
+			v.emitGamepad(pad, InputEvent{Kind: "gamepad_axis", Key: a.name, Axis: &pos})
+		} else {
+			// Too small a change to report; keep measuring from the
+			// position last reported.
+			g.last.setAxis(a.name, a.last)
+		}
+	}
+	return consumed
+}
+
+// gamepadDirection returns the direction the d-pad or left stick points,
+// as -1, 0 or 1 on each axis, preferring the d-pad.
+func gamepadDirection(s GamepadState) (dx, dy int) {
+	switch {
+	case s.Buttons&ButtonLeft != 0:
+		return -1, 0
+	case s.Buttons&ButtonRight != 0:
+		return 1, 0
+	case s.Buttons&ButtonUp != 0:
+		return 0, -1
+	case s.Buttons&ButtonDown != 0:
+		return 0, 1
+	}
+	if math.Max(math.Abs(s.LeftX), math.Abs(s.LeftY)) < gamepadNavThreshold {
+		return 0, 0
+	}
+	if math.Abs(s.LeftX) >= math.Abs(s.LeftY) {
+		return int(math.Copysign(1, s.LeftX)), 0
+	}
+	return 0, int(math.Copysign(1, s.LeftY))
+}
+
+// deadZone returns an axis position with the dead zone around rest
+// taken as rest.
+func deadZone(pos float64) float64 {
+	if math.Abs(pos) < gamepadDeadZone {
+		return 0
+	}
+	return pos
+}
+
+// setAxis sets a reported axis by name.
+func (s *GamepadState) setAxis(name string, pos float64) {
+	switch name {
+	case "right_x":
+		s.RightX = pos
+	case "right_y":
+		s.RightY = pos
+	case "left_trigger":
+		s.LeftTrigger = pos
+	case "right_trigger":
+		s.RightTrigger = pos
+	}
+}
+
+// gamepadActivate clicks the focused node, or works it as space if it
+// is a form control. Returns whether anything was focused to activate.
+// Must be called with the mutex held.
+func (v *Viewer) gamepadActivate() bool {
+	if v.tree.Focused == nil || v.disabled(*v.tree.Focused) {
+		return false
+	}
+	node := v.tree.NodeIndex[*v.tree.Focused]
+	if node == nil {
+		return false
+	}
+	if isWidget(node) {
+		return v.controlKey("space")
+	}
+	id := node.ID
+	event := InputEvent{Target: &id, Kind: "click"}
+	v.emit(ProtocolMessage{Type: MsgInput, Event: &event})
+	return true
+}
+
+// emitGamepad sends a gamepad event aimed at the focused node.
+// Must be called with the mutex held.
+func (v *Viewer) emitGamepad(pad int, event InputEvent) {
+	if v.tree.Focused != nil {
+		id := *v.tree.Focused
+		event.Target = &id
+	}
+	event.Pad = pad
+	v.emit(ProtocolMessage{Type: MsgInput, Event: &event})
+}
+
+// focusToward moves focus to the nearest focusable node in direction
+// (dx, dy) from the focused one, as laid out on screen, within the
+// topmost modal overlay if one is open. With nothing focused, it focuses
+// the first node in tab order. Returns whether focus moved.
+// Must be called with the mutex held.
+func (v *Viewer) focusToward(dx, dy int) bool {
+	scope := v.tree.Root
+	if m := topModal(scope); m != nil {
+		scope = m
+	}
+	order := tabOrder(scope)
+	if len(order) == 0 {
+		return false
+	}
+	if v.tree.Focused == nil {
+		v.setFocus(&order[0].ID)
+		return true
+	}
+	in := v.inputLayout()
+	center := func(node *RenderNode) (x, y float64, ok bool) {
+		r := in.layouts[node.ID]
+		if r == nil {
+			return 0, 0, false
+		}
+		return r.X + r.Width/2, r.Y + r.Height/2 - scrollOffset(v.tree.Root, node.ID), true
+	}
+	from := v.tree.NodeIndex[*v.tree.Focused]
+	if from == nil {
+		return false
+	}
+	fx, fy, ok := center(from)
+	if !ok {
+		return false
+	}
+
+	var best *RenderNode
+	bestScore := math.Inf(1)
+	for _, node := range order {
+		if node == from {
+			continue
+		}
+		x, y, ok := center(node)
+		if !ok {
+			continue
+		}
+		// along is the distance in the direction of travel, across the
+		// distance off to the side, which counts for more.
+		along := (x-fx)*float64(dx) + (y-fy)*float64(dy)
+		across := math.Abs((x-fx)*float64(dy)) + math.Abs((y-fy)*float64(dx))
+		if along <= 0 {
+			continue
+		}
+		if score := along + 2*across; score < bestScore {
+			best, bestScore = node, score
+		}
+	}
+	if best == nil {
+		return false
+	}
+	v.setFocus(&best.ID)
+	return true
+}
@@ -0,0 +1,215 @@
+package viewer
+
+import (
+	"math"
+	"time"
+)
+
+// Touch gestures. Hosts report each finger as a PointerEvent with Touch
+// set and its own ID. The first finger down works like the mouse — it
+// presses, drags and clicks — and the viewer also recognizes gestures
+// from the touches, sending them to the source as input events:
+//
+//   - "tap": the finger lifted soon after touching, without moving
+//     farther than a character's width.
+//   - "long_press": the finger stayed still for longPressDelay. Tick
+//     sends it, and the press no longer clicks on release.
+//   - "pinch": two fingers moved apart or together. Scale is the
+//     distance between them over the distance when the second touched.
+//   - "two_finger_scroll": two fingers moved vertically together. The
+//     scroll nodes under them scroll as for a wheel, and DeltaY is the
+//     distance they had no room for.
+//
+// A second finger cancels the first finger's press. Gesture events are
+// aimed at the topmost node under the touch, or under the point midway
+// between two, and pinch and two_finger_scroll carry the touches in
+// Pointers.
+
+// longPressDelay is how long a touch stays still to be a long press.
+const longPressDelay = 500 * time.Millisecond
+
+// pinchStep is the least change of scale that sends another pinch.
+const pinchStep = 0.01
+
+// TouchPoint is one touch in a gesture event.
+type TouchPoint struct {
+	ID int `json:"id" cbor:"id"`
+	X  int `json:"x" cbor:"x"`
+	Y  int `json:"y" cbor:"y"`
+}
+
+// gestureState tracks the touches of a gesture, from the first finger
+// down until the last one lifts.
+type gestureState struct {
+	touches []TouchPoint // in the order they went down
+	startX  int          // where the first touch went down, and when
+	startY  int
+	start   time.Time
+	moved   bool // the first touch left its slop
+	pressed bool // a long press was sent
+	multi   bool // a second touch joined
+
+	// Two-finger gestures: the distance between the touches when the
+	// second went down, the last scale sent, and the last midpoint.
+	span0, scale, midY float64
+}
+
+// touch runs a touch PointerEvent through the gesture recognizer.
+// Returns whether the event is used up by a two-finger gesture, so it
+// should not go on to the pointer handling.
+// Must be called with the mutex held.
+func (v *Viewer) touch(in *inputLayout, ev PointerEvent) bool {
+	g := v.gesture
+	switch ev.Action {
+	case PointerDown:
+		if g == nil {
+			v.gesture = &gestureState{
+				touches: []TouchPoint{{ID: ev.ID, X: ev.X, Y: ev.Y}},
+				startX:  ev.X,
+				startY:  ev.Y,
+				start:   v.now(),
+			}
+			return false
+		}
+		g.touches = append(g.touches, TouchPoint{ID: ev.ID, X: ev.X, Y: ev.Y})
+		if !g.multi {
+			g.multi = true
+			v.cancelPress()
+			g.span0, g.scale = touchSpan(g.touches[0], g.touches[1]), 1
+			g.midY = float64(g.touches[0].Y+g.touches[1].Y) / 2
+		}
+		return true
+
+	case PointerMove:
+		if g == nil {
+			return false
+		}
+		i := g.find(ev.ID)
+		if i < 0 {
+			return g.multi
+		}
+		g.touches[i].X, g.touches[i].Y = ev.X, ev.Y
+		if !g.multi {
+			dx, dy := ev.X-g.startX, ev.Y-g.startY
+			if float64(max(dx, -dx, dy, -dy)) >= in.opts.CharWidth {
+				g.moved = true
+			}
+			return false
+		}
+		if i < 2 {
+			v.twoFingers(in, g)
+		}
+		return true
+
+	case PointerUp:
+		if g == nil {
+			return false
+		}
+		i := g.find(ev.ID)
+		if i >= 0 {
+			g.touches = append(g.touches[:i], g.touches[i+1:]...)
+		}
+		multi := g.multi
+		if len(g.touches) == 0 {
+			v.gesture = nil
+		}
+		if multi {
+			return true
+		}
+		if !g.moved && !g.pressed && v.now().Sub(g.start) < longPressDelay {
+			event := v.gestureEvent(in, "tap", ev.X, ev.Y)
+			v.emit(ProtocolMessage{Type: MsgInput, Event: &event})
+		}
+		return false
+	}
+	return false
+}
+
+// twoFingers sends pinches and scrolls as the first two touches move.
+// Must be called with the mutex held.
+func (v *Viewer) twoFingers(in *inputLayout, g *gestureState) {
+	a, b := g.touches[0], g.touches[1]
+	midX, midY := (a.X+b.X)/2, float64(a.Y+b.Y)/2
+	pointers := append([]TouchPoint(nil), g.touches...)
+
+	if g.span0 > 0 {
+		scale := touchSpan(a, b) / g.span0
+		if math.Abs(scale-g.scale) >= pinchStep {
+			g.scale = scale
+			event := v.gestureEvent(in, "pinch", midX, int(midY))
+			event.Scale, event.Pointers = &scale, pointers
+			v.emit(ProtocolMessage{Type: MsgInput, Event: &event})
+		}
+	}
+
+	// Content follows the fingers: moving them up scrolls down.
+	dy := g.midY - midY
+	g.midY = midY
+	if dy == 0 {
+		return
+	}
+	if rest, _ := v.wheel(in, float64(midX), midY, dy); rest != 0 {
+		event := v.gestureEvent(in, "two_finger_scroll", midX, int(midY))
+		event.DeltaY, event.Pointers = &rest, pointers
+		v.emit(ProtocolMessage{Type: MsgInput, Event: &event})
+	}
+}
+
+// checkLongPress sends a long_press once a still touch has been held
+// long enough. Returns whether one is still waiting.
+// Must be called with the mutex held.
+func (v *Viewer) checkLongPress() bool {
+	g := v.gesture
+	// A finger holding a captured node is dragging it, however slowly.
+	if g == nil || g.multi || g.moved || g.pressed || v.capture != nil {
+		return false
+	}
+	if v.now().Sub(g.start) < longPressDelay {
+		return true
+	}
+	g.pressed = true
+	v.cancelPress()
+	t := g.touches[0]
+	event := v.gestureEvent(v.inputLayout(), "long_press", t.X, t.Y)
+	v.emit(ProtocolMessage{Type: MsgInput, Event: &event})
+	return false
+}
+
+// cancelPress forgets a press in progress, so its release does nothing.
+// Must be called with the mutex held.
+func (v *Viewer) cancelPress() {
+	if v.tree.Active != nil {
+		v.setActive(nil)
+	}
+	v.drag = nil
+	v.endDrag()
+	v.cancelCapture()
+	v.selecting = false
+}
+
+// gestureEvent returns a gesture event at (x, y), aimed at the topmost
+// node there.
+// Must be called with the mutex held.
+func (v *Viewer) gestureEvent(in *inputLayout, kind string, x, y int) InputEvent {
+	event := InputEvent{Kind: kind, X: &x, Y: &y}
+	if path := hitLayers(v.tree.Root, in, float64(x), float64(y)); len(path) > 0 {
+		id := path[len(path)-1].node.ID
+		event.Target = &id
+	}
+	return event
+}
+
+// find returns the index of the touch with the given ID, or -1.
+func (g *gestureState) find(id int) int {
+	for i, t := range g.touches {
+		if t.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// touchSpan returns the distance between two touches.
+func touchSpan(a, b TouchPoint) float64 {
+	return math.Hypot(float64(a.X-b.X), float64(a.Y-b.Y))
+}
@@ -2,6 +2,12 @@ module github.com/anthropics/viewport/viewer
 
 go 1.21
 
-require github.com/fxamacker/cbor/v2 v2.9.0
+require (
+	github.com/fxamacker/cbor/v2 v2.9.0
+	golang.org/x/image v0.18.0
+)
 
-require github.com/x448/float16 v0.8.4 // indirect
+require (
+	github.com/x448/float16 v0.8.4 // indirect
+	golang.org/x/text v0.16.0 // indirect
+)
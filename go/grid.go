@@ -0,0 +1,624 @@
+package viewer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Cell grid — the character-cell rendering of a tree, ported from the
+// TerminalBuffer in src/viewer/ansi/viewer.ts. A Grid is what the ANSI
+// target draws to the terminal.
+
+// CellStyle is the visual attributes of a single cell. Colors are
+// normalized "#rrggbb" strings, or empty for the terminal default.
+type CellStyle struct {
+	FG        string
+	BG        string
+	Bold      bool
+	Faint     bool
+	Italic    bool
+	Underline bool
+	Strike    bool
+	Inverse   bool // swap foreground and background
+}
+
+// Cell is one character position on the grid. A double-width character
+// occupies its cell and a continuation cell after it, whose Ch is 0 and
+// which prints nothing.
+type Cell struct {
+	Ch    rune
+	Style CellStyle
+
+	// Cluster holds the whole grapheme cluster when it is more than one
+	// rune (a base with combining marks, an emoji sequence); Ch is then
+	// its first rune.
+	Cluster string
+}
+
+// text returns what the cell prints.
+func (c Cell) text() string {
+	switch {
+	case c.Cluster != "":
+		return c.Cluster
+	case c.Ch == 0:
+		return ""
+	}
+	return string(c.Ch)
+}
+
+// Grid is a width × height buffer of cells, indexed [row][col].
+type Grid struct {
+	Width  int
+	Height int
+	Cells  [][]Cell
+
+	// Images lists decoded images drawn on the grid, for terminals that
+	// can display them at full resolution.
+	Images []GridImage
+}
+
+// NewGrid returns a grid filled with blank cells.
+func NewGrid(width, height int) *Grid {
+	if width < 0 {
+		width = 0
+	}
+	if height < 0 {
+		height = 0
+	}
+	g := &Grid{Width: width, Height: height, Cells: make([][]Cell, height)}
+	for y := range g.Cells {
+		row := make([]Cell, width)
+		for x := range row {
+			row[x] = Cell{Ch: ' '}
+		}
+		g.Cells[y] = row
+	}
+	return g
+}
+
+// Set writes a single-width cell, ignoring positions outside the grid.
+func (g *Grid) Set(x, y int, ch rune, style CellStyle) {
+	if x < 0 || y < 0 || x >= g.Width || y >= g.Height {
+		return
+	}
+	g.clearWide(x, y)
+	g.Cells[y][x] = Cell{Ch: ch, Style: style}
+}
+
+// SetCluster writes one grapheme cluster at (x, y) and returns the cells
+// it advances by: its display width, or 1 for a wide cluster that would
+// not fit and is shown as a space. Zero-width clusters are skipped.
+func (g *Grid) SetCluster(x, y int, cluster string, style CellStyle) int {
+	width := StringWidth(cluster)
+	if width == 0 || cluster == "" {
+		return 0
+	}
+	if x < 0 || y < 0 || x >= g.Width || y >= g.Height {
+		return width
+	}
+	if width == 2 && x+1 >= g.Width {
+		g.Set(x, y, ' ', style)
+		return 1
+	}
+	ch, size := utf8.DecodeRuneInString(cluster)
+	cell := Cell{Ch: ch, Style: style}
+	if size < len(cluster) {
+		cell.Cluster = cluster
+	}
+	g.clearWide(x, y)
+	g.Cells[y][x] = cell
+	if width == 2 {
+		g.clearWide(x+1, y)
+		g.Cells[y][x+1] = Cell{Style: style}
+	}
+	return width
+}
+
+// clearWide blanks the other half of a double-width character that a
+// write to (x, y) would split.
+func (g *Grid) clearWide(x, y int) {
+	row := g.Cells[y]
+	if row[x].Ch == 0 && x > 0 {
+		row[x-1] = Cell{Ch: ' ', Style: row[x-1].Style}
+	}
+	if x+1 < len(row) && row[x+1].Ch == 0 {
+		row[x+1] = Cell{Ch: ' ', Style: row[x+1].Style}
+	}
+}
+
+// String returns the grid as plain text, with trailing spaces and
+// trailing blank lines removed.
+func (g *Grid) String() string {
+	lines := make([]string, g.Height)
+	for y, row := range g.Cells {
+		var sb strings.Builder
+		for _, c := range row {
+			sb.WriteString(c.text())
+		}
+		lines[y] = strings.TrimRight(sb.String(), " ")
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ANSI returns the grid as text with SGR escape sequences, one line per
+// row, ending with a reset.
+func (g *Grid) ANSI() string {
+	var sb strings.Builder
+	for y := range g.Cells {
+		if y > 0 {
+			sb.WriteString("\n")
+		}
+		g.writeRow(&sb, y, 0, g.Width)
+	}
+	return sb.String()
+}
+
+// writeRow writes cells [from, to) of row y with SGR sequences, ending
+// with a reset if any style was emitted. The range must not split a
+// double-width character.
+func (g *Grid) writeRow(sb *strings.Builder, y, from, to int) {
+	current := CellStyle{}
+	for x := from; x < to; x++ {
+		c := g.Cells[y][x]
+		if c.Style != current {
+			sb.WriteString(sgr(c.Style))
+			current = c.Style
+		}
+		sb.WriteString(c.text())
+	}
+	if current != (CellStyle{}) {
+		sb.WriteString("\x1b[0m")
+	}
+}
+
+// sgr returns the escape sequence that selects style from a reset state.
+func sgr(s CellStyle) string {
+	codes := []string{"0"}
+	if s.Bold {
+		codes = append(codes, "1")
+	}
+	if s.Faint {
+		codes = append(codes, "2")
+	}
+	if s.Italic {
+		codes = append(codes, "3")
+	}
+	if s.Underline {
+		codes = append(codes, "4")
+	}
+	if s.Inverse {
+		codes = append(codes, "7")
+	}
+	if s.Strike {
+		codes = append(codes, "9")
+	}
+	if r, g, b, ok := parseColor(s.FG); ok {
+		codes = append(codes, fmt.Sprintf("38;2;%d;%d;%d", r, g, b))
+	}
+	if r, g, b, ok := parseColor(s.BG); ok {
+		codes = append(codes, fmt.Sprintf("48;2;%d;%d;%d", r, g, b))
+	}
+	return "\x1b[" + strings.Join(codes, ";") + "m"
+}
+
+// namedColors is the common subset of CSS color names.
+var namedColors = map[string][3]int{
+	"red":     {255, 0, 0},
+	"green":   {0, 128, 0},
+	"blue":    {0, 0, 255},
+	"white":   {255, 255, 255},
+	"black":   {0, 0, 0},
+	"yellow":  {255, 255, 0},
+	"cyan":    {0, 255, 255},
+	"magenta": {255, 0, 255},
+	"gray":    {128, 128, 128},
+	"grey":    {128, 128, 128},
+	"orange":  {255, 165, 0},
+}
+
+// parseColor parses "#rgb", "#rrggbb", or a named color.
+func parseColor(color string) (r, g, b int, ok bool) {
+	if strings.HasPrefix(color, "#") {
+		hex := color[1:]
+		if len(hex) == 3 {
+			hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+		}
+		if len(hex) != 6 {
+			return 0, 0, 0, false
+		}
+		n, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil {
+			return 0, 0, 0, false
+		}
+		return int(n >> 16 & 0xff), int(n >> 8 & 0xff), int(n & 0xff), true
+	}
+	if rgb, found := namedColors[strings.ToLower(color)]; found {
+		return rgb[0], rgb[1], rgb[2], true
+	}
+	return 0, 0, 0, false
+}
+
+// rect is an integer cell rectangle.
+type rect struct {
+	x, y, w, h int
+}
+
+// intersect returns the overlap of two rectangles.
+func (r rect) intersect(o rect) rect {
+	x0, y0 := max(r.x, o.x), max(r.y, o.y)
+	x1, y1 := min(r.x+r.w, o.x+o.w), min(r.y+r.h, o.y+o.h)
+	return rect{x0, y0, max(0, x1-x0), max(0, y1-y0)}
+}
+
+// contains reports whether a cell lies inside the rectangle.
+func (r rect) contains(x, y int) bool {
+	return x >= r.x && y >= r.y && x < r.x+r.w && y < r.y+r.h
+}
+
+// RenderGrid lays the tree out in character cells and draws it onto a new
+// width × height grid. slots resolves style and color slot references; it
+// may be nil. Animated images show their first frame.
+func RenderGrid(tree *RenderTree, width, height int, slots func(int) SlotValue) *Grid {
+	return renderGrid(tree, width, height, slots, &imageCache{}, time.Time{})
+}
+
+// renderGrid is RenderGrid with a persistent image cache; animations
+// advance with now.
+func renderGrid(tree *RenderTree, width, height int, slots func(int) SlotValue, images *imageCache, now time.Time) *Grid {
+	g := NewGrid(width, height)
+	if tree.Root == nil {
+		return g
+	}
+
+	l := &layoutEngine{opts: CellLayoutOptions(), layouts: make(map[int]*ComputedLayout), tree: tree}
+	l.layoutTree(tree.Root, ComputedLayout{Width: float64(width), Height: float64(height)})
+
+	d := &gridDrawer{grid: g, layouts: l.layouts, slots: slots, images: images, now: now, active: tree.Active, focused: tree.Focused, cursor: tree.Cursor, invalid: tree.Invalid, progress: tree.Progress, tree: tree, alpha: 1}
+	d.draw(tree.Root, CellStyle{}, DirLTR, rect{0, 0, width, height}, 0)
+	d.drawOverlays(tree.Root)
+	d.drawTooltip(tree)
+	return g
+}
+
+// gridDrawer paints laid-out nodes onto a grid.
+type gridDrawer struct {
+	grid     *Grid
+	layouts  map[int]*ComputedLayout
+	slots    func(int) SlotValue
+	images   *imageCache
+	now      time.Time
+	active   *int                        // RenderTree.Active
+	focused  *int                        // RenderTree.Focused
+	progress map[int]*ProgressTransition // RenderTree.Progress
+	tree     *RenderTree                 // for table data
+	cursor   *TextCursor                 // RenderTree.Cursor
+	invalid  map[int]bool                // RenderTree.Invalid
+
+	// alpha is the combined opacity of the nodes being drawn, and
+	// backdrop the background color painted behind them.
+	alpha    float64
+	backdrop string
+}
+
+// draw paints a node and its children. inherited carries text attributes
+// and dir the text direction from ancestors, clip bounds drawing, and dy
+// shifts content scrolled by enclosing scroll containers.
+func (d *gridDrawer) draw(node *RenderNode, inherited CellStyle, dir string, clip rect, dy int) {
+	layout, ok := d.layouts[node.ID]
+	if !ok {
+		return
+	}
+	r := rect{int(layout.X), int(layout.Y) - dy, int(layout.Width), int(layout.Height)}
+	d.shadow(node, r, clip)
+	visible := r.intersect(clip)
+	if (visible.w <= 0 || visible.h <= 0) && clipsChildren(node) {
+		return
+	}
+
+	p := node.Props
+	alpha, backdrop := d.alpha, d.backdrop
+	defer func() { d.alpha, d.backdrop = alpha, backdrop }()
+	if p.Opacity != nil {
+		d.alpha *= min(max(*p.Opacity, 0), 1)
+	}
+	if d.alpha <= 0 {
+		return
+	}
+
+	// Children inherit the node's own colors; paint is what it draws
+	// with, faded by opacity.
+	style := d.nodeStyle(node, inherited)
+	paint := d.fade(style, style.BG != inherited.BG)
+	d.backdrop = paint.BG
+	if style.BG != "" && style.BG != inherited.BG || style.Inverse && !inherited.Inverse {
+		d.fill(visible, paint)
+	}
+
+	dir = inheritDir(p.TextDirection, dir)
+	switch node.Type {
+	case NodeText:
+		content := ""
+		if p.Content != nil {
+			content = *p.Content
+		}
+		rtl := resolveRTL(dir, content)
+		for i, line := range fitLines(content, p.TextOverflow, r.w) {
+			line = BidiReorder(line, rtl)
+			x := r.x + alignOffset(p.TextAlign, rtl, r.w, StringWidth(line))
+			d.text(x, r.y+i, line, paint, visible)
+		}
+
+	case NodeSeparator:
+		for x := visible.x; x < visible.x+visible.w; x++ {
+			d.set(x, r.y, '─', paint, visible)
+		}
+
+	case NodeInput:
+		faint := paint
+		faint.Faint = true
+		d.text(r.x, r.y, "> ", faint, visible)
+		shown := d.inputShown(node)
+		switch {
+		case shown != "" && isMultiline(node):
+			visible = visible.intersect(r)
+			lines := strings.Split(shown, "\n")
+			for i, line := range lines[min(d.inputScroll(node), len(lines)):] {
+				d.text(r.x+2, r.y+i, BidiReorder(line, resolveRTL(dir, line)), paint, visible)
+			}
+		case shown != "":
+			d.text(r.x+2, r.y, BidiReorder(shown, resolveRTL(dir, shown)), paint, visible)
+		case p.Placeholder != nil:
+			d.text(r.x+2, r.y, BidiReorder(*p.Placeholder, resolveRTL(dir, *p.Placeholder)), faint, visible)
+		}
+		d.drawCursor(node, r.x+2, r.y, visible)
+
+	case NodeCheckbox, NodeRadio, NodeSelect, NodeButton:
+		d.text(r.x, r.y, controlText(node), paint, visible)
+
+	case NodeProgress:
+		d.drawBar(node, r, paint, visible)
+
+	case NodeTable:
+		d.drawTable(node, r, paint, visible)
+
+	case NodeSpinner:
+		d.text(r.x, r.y, d.spinnerText(node), paint, visible)
+
+	case NodeImage, NodeCanvas:
+		if node.Type == NodeImage && len(p.Data) > 0 && d.drawImage(node, r, visible) {
+			break
+		}
+		alt := MediaPlaceholder(node)
+		if p.AltText != nil {
+			alt = *p.AltText
+		}
+		faint := paint
+		faint.Faint = true
+		d.text(r.x, r.y, BidiReorder(alt, resolveRTL(dir, alt)), faint, visible)
+
+	case NodeBox, NodeScroll:
+		inner := d.border(node, r, paint, visible)
+		if !clipsChildren(node) {
+			inner = clip
+		}
+		scrollTop := 0
+		if isScroller(node) && p.ScrollTop != nil {
+			scrollTop = *p.ScrollTop
+		}
+		for _, child := range paintOrder(node) {
+			d.draw(child, style, dir, inner, dy+scrollTop)
+		}
+		if !isScroller(node) {
+			break
+		}
+		if track, thumb, ok := scrollbar(node, d.layouts, CellLayoutOptions()); ok {
+			d.scrollbar(track, thumb, dy, paint, inner)
+		}
+	}
+}
+
+// text writes a single line of text starting at (x, y). A wide
+// character cut by the clip is shown as a space.
+func (d *gridDrawer) text(x, y int, s string, style CellStyle, clip rect) {
+	for len(s) > 0 {
+		n, width := nextGrapheme(s)
+		switch {
+		case width == 2 && clip.contains(x, y) && !clip.contains(x+1, y):
+			d.grid.Set(x, y, ' ', style)
+		case width > 0 && clip.contains(x, y):
+			d.grid.SetCluster(x, y, s[:n], style)
+		case width == 2 && clip.contains(x+1, y):
+			d.grid.Set(x+1, y, ' ', style)
+		}
+		x += width
+		s = s[n:]
+	}
+}
+
+// set writes one cell if it lies inside clip.
+func (d *gridDrawer) set(x, y int, ch rune, style CellStyle, clip rect) {
+	if clip.contains(x, y) {
+		d.grid.Set(x, y, ch, style)
+	}
+}
+
+// fade returns style as drawn at the current opacity: colors are blended
+// toward the background behind them, or made faint if they are unknown.
+// ownBG says the node sets its own background rather than showing the
+// backdrop.
+func (d *gridDrawer) fade(style CellStyle, ownBG bool) CellStyle {
+	if !ownBG {
+		style.BG = d.backdrop
+	}
+	if d.alpha >= 1 {
+		return style
+	}
+	if ownBG && d.backdrop != "" {
+		style.BG = blendColor(style.BG, d.backdrop, d.alpha)
+	}
+	if style.FG != "" && style.BG != "" {
+		style.FG = blendColor(style.FG, style.BG, d.alpha)
+	} else if d.alpha <= 0.5 {
+		style.Faint = true
+	}
+	return style
+}
+
+// blendColor mixes normalized colors: a of fg over bg.
+func blendColor(fg, bg string, a float64) string {
+	fr, fgg, fb, ok1 := parseColor(fg)
+	br, bgg, bb, ok2 := parseColor(bg)
+	if !ok1 || !ok2 {
+		return fg
+	}
+	mix := func(f, b int) int { return int(float64(f)*a + float64(b)*(1-a) + 0.5) }
+	return fmt.Sprintf("#%02x%02x%02x", mix(fr, br), mix(fgg, bgg), mix(fb, bb))
+}
+
+// fill paints a background over a rectangle.
+func (d *gridDrawer) fill(r rect, style CellStyle) {
+	for y := r.y; y < r.y+r.h; y++ {
+		for x := r.x; x < r.x+r.w; x++ {
+			d.grid.Set(x, y, ' ', style)
+		}
+	}
+}
+
+// nodeStyle merges a node's own text attributes, including those from its
+// style slot, over the inherited style.
+func (d *gridDrawer) nodeStyle(node *RenderNode, inherited CellStyle) CellStyle {
+	s := inherited
+	p := node.Props
+
+	if node.Type == NodeButton {
+		switch p.Variant {
+		case ButtonPrimary:
+			s.Bold = true
+		case ButtonDanger:
+			s.FG = dangerColor
+		}
+	}
+	if p.Style != nil {
+		if slot, ok := d.slot(*p.Style).(StyleSlot); ok {
+			d.applyStyleProps(&s, slot.Props)
+		}
+	}
+
+	switch p.Weight {
+	case "bold":
+		s.Bold = true
+	case "light":
+		s.Faint = true
+	}
+	if p.Italic != nil {
+		s.Italic = *p.Italic
+	}
+	switch p.Decoration {
+	case "underline":
+		s.Underline = true
+	case "strikethrough":
+		s.Strike = true
+	}
+	if c := d.color(p.Color); c != "" {
+		s.FG = c
+	}
+	if c := d.color(p.Background); c != "" {
+		s.BG = c
+	}
+	if isDisabled(node) {
+		s.Faint = true
+	}
+	if d.active != nil && *d.active == node.ID {
+		var active map[string]interface{}
+		if p.Style != nil {
+			if slot, ok := d.slot(*p.Style).(StyleSlot); ok {
+				active = slot.Active
+			}
+		}
+		if active != nil {
+			d.applyStyleProps(&s, active)
+		} else {
+			s.Inverse = !s.Inverse
+		}
+	}
+	if d.focused != nil && *d.focused == node.ID && isWidget(node) {
+		s.Underline = true
+	}
+	if t := d.tree; t != nil {
+		if t.Dragging != nil && *t.Dragging == node.ID {
+			s.Faint = true
+		}
+		if t.DropTarget != nil && *t.DropTarget == node.ID {
+			s.Inverse = !s.Inverse
+		}
+	}
+	if d.invalid[node.ID] {
+		var invalid map[string]interface{}
+		if p.Style != nil {
+			if slot, ok := d.slot(*p.Style).(StyleSlot); ok {
+				invalid = slot.Invalid
+			}
+		}
+		if invalid != nil {
+			d.applyStyleProps(&s, invalid)
+		} else {
+			s.FG = invalidColor
+		}
+	}
+	return s
+}
+
+// applyStyleProps applies the props of a style slot.
+func (d *gridDrawer) applyStyleProps(s *CellStyle, props map[string]interface{}) {
+	if w, ok := props["weight"].(string); ok {
+		s.Bold = w == "bold"
+		s.Faint = w == "light"
+	}
+	if it, ok := props["italic"].(bool); ok {
+		s.Italic = it
+	}
+	if dec, ok := props["decoration"].(string); ok {
+		s.Underline = dec == "underline"
+		s.Strike = dec == "strikethrough"
+	}
+	if c := d.color(props["color"]); c != "" {
+		s.FG = c
+	}
+	if c := d.color(props["background"]); c != "" {
+		s.BG = c
+	}
+}
+
+// color resolves a color prop — a color string or a color slot
+// reference — to a normalized "#rrggbb" string, or "" if unknown.
+func (d *gridDrawer) color(v interface{}) string {
+	var name string
+	if s, ok := v.(string); ok {
+		name = s
+	} else if n, ok := toFloat(v); ok {
+		slot, ok := d.slot(int(n)).(ColorSlot)
+		if !ok {
+			return ""
+		}
+		name = slot.Value
+	}
+	r, g, b, ok := parseColor(name)
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("#%02x%02x%02x", r, g, b)
+}
+
+// slot looks up a slot value, or returns nil.
+func (d *gridDrawer) slot(id int) SlotValue {
+	if d.slots == nil {
+		return nil
+	}
+	return d.slots(id)
+}
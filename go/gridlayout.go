@@ -0,0 +1,301 @@
+package viewer
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Grid layout. A box or scroll node with direction "grid" places its
+// children in the cells of a grid rather than along a line. Its columns
+// and rows props are track templates: a string of space-separated
+// tracks — a number is a fixed size, "Nfr" a share of the space left
+// over, and "auto" as big as the largest child in the track — a list of
+// the same, or a number n for n equal columns or rows. Without columns
+// there is one column; rows past the template are auto.
+//
+// Children fill the grid in order, row by row, each taking the first
+// free cell it fits, spanning colSpan columns and rowSpan rows (one of
+// each by default). A child stretches over its cells, and the gap prop
+// spaces columns and rows alike. Text projections give a grid row by row.
+
+// DirectionGrid is the direction of a container that lays its children
+// out in a grid.
+const DirectionGrid = "grid"
+
+// track is one column or row of a grid template.
+type track struct {
+	size float64 // fixed size
+	fr   float64 // share of the leftover space, if nonzero
+	auto bool    // sized to its content
+}
+
+// parseTracks reads a columns or rows template.
+func parseTracks(v interface{}) []track {
+	if n, ok := toInt(v); ok {
+		tracks := make([]track, max(n, 0))
+		for i := range tracks {
+			tracks[i] = track{fr: 1}
+		}
+		return tracks
+	}
+	var tokens []interface{}
+	switch t := v.(type) {
+	case string:
+		for _, f := range strings.Fields(t) {
+			tokens = append(tokens, f)
+		}
+	case []interface{}:
+		tokens = t
+	case []string:
+		for _, s := range t {
+			tokens = append(tokens, s)
+		}
+	}
+	tracks := make([]track, 0, len(tokens))
+	for _, tok := range tokens {
+		tracks = append(tracks, parseTrack(tok))
+	}
+	return tracks
+}
+
+// parseTrack reads one track of a template; anything unreadable is auto.
+func parseTrack(tok interface{}) track {
+	if n, ok := toFloat(tok); ok {
+		return track{size: n}
+	}
+	s, _ := tok.(string)
+	if f, ok := strings.CutSuffix(s, "fr"); ok {
+		if n, err := strconv.ParseFloat(f, 64); err == nil && n > 0 {
+			return track{fr: n}
+		}
+	}
+	if n, err := strconv.ParseFloat(s, 64); err == nil {
+		return track{size: n}
+	}
+	return track{auto: true}
+}
+
+// gridItem is a child placed in a grid.
+type gridItem struct {
+	node       *RenderNode
+	row, col   int
+	rows, cols int // spans
+}
+
+// gridColumns returns how many columns a grid has.
+func gridColumns(node *RenderNode) int {
+	return max(len(parseTracks(node.Props.Columns)), 1)
+}
+
+// gridPlace places a grid's children in its cells. Returns them and how
+// many rows they fill.
+func gridPlace(node *RenderNode) ([]gridItem, int) {
+	ncols := gridColumns(node)
+	taken := make(map[[2]int]bool)
+	free := func(row, col, rows, cols int) bool {
+		for r := row; r < row+rows; r++ {
+			for c := col; c < col+cols; c++ {
+				if taken[[2]int{r, c}] {
+					return false
+				}
+			}
+		}
+		return true
+	}
+
+	var items []gridItem
+	nrows, row, col := 0, 0, 0
+	for _, child := range flowChildren(node) {
+		it := gridItem{node: child, rows: 1, cols: 1}
+		if s := child.Props.ColSpan; s != nil {
+			it.cols = min(max(*s, 1), ncols)
+		}
+		if s := child.Props.RowSpan; s != nil {
+			it.rows = max(*s, 1)
+		}
+		for col+it.cols > ncols || !free(row, col, it.rows, it.cols) {
+			if col++; col+it.cols > ncols {
+				row, col = row+1, 0
+			}
+		}
+		it.row, it.col = row, col
+		for r := row; r < row+it.rows; r++ {
+			for c := col; c < col+it.cols; c++ {
+				taken[[2]int{r, c}] = true
+			}
+		}
+		items = append(items, it)
+		nrows = max(nrows, row+it.rows)
+		col += it.cols
+	}
+	return items, nrows
+}
+
+// sizeTracks returns the sizes of n tracks sharing avail, less gaps.
+// content gives an auto track's size. When measuring, fr tracks are
+// sized to their content too, as there is no leftover space to share.
+func sizeTracks(tracks []track, n int, avail, gap float64, measuring bool, content func(i int) float64) []float64 {
+	sizes := make([]float64, n)
+	used, fr := gap*float64(max(n-1, 0)), 0.0
+	for i := range sizes {
+		t := track{auto: true}
+		if i < len(tracks) {
+			t = tracks[i]
+		}
+		switch {
+		case t.fr > 0 && !measuring:
+			fr += t.fr
+			continue
+		case t.fr > 0, t.auto:
+			sizes[i] = content(i)
+		default:
+			sizes[i] = t.size
+		}
+		used += sizes[i]
+	}
+	if fr > 0 {
+		left := math.Max(avail-used, 0)
+		for i := range sizes {
+			if i < len(tracks) && tracks[i].fr > 0 {
+				sizes[i] = tracks[i].fr / fr * left
+			}
+		}
+	}
+	return sizes
+}
+
+// span returns the size of count tracks from first, with the gaps
+// between them.
+func span(sizes []float64, first, count int, gap float64) float64 {
+	total := gap * float64(max(count-1, 0))
+	for _, s := range sizes[first : first+count] {
+		total += s
+	}
+	return total
+}
+
+// trackStart returns where track i starts, past the tracks and gaps
+// before it.
+func trackStart(sizes []float64, i int, gap float64) float64 {
+	start := gap * float64(i)
+	for _, s := range sizes[:i] {
+		start += s
+	}
+	return start
+}
+
+// gridTracks places a grid's children and sizes its columns and rows to
+// fit a content box of w × h.
+func (l *layoutEngine) gridTracks(node *RenderNode, w, h float64, measuring bool) (items []gridItem, cols, rows []float64) {
+	items, nrows := gridPlace(node)
+	gap := 0.0
+	if node.Props.Gap != nil {
+		gap = float64(*node.Props.Gap)
+	}
+	// content returns the largest size along an axis of the children
+	// alone in track i.
+	content := func(horizontal bool, i int) float64 {
+		size := 0.0
+		for _, it := range items {
+			m := l.resolveSpacing(it.node.Props.Margin)
+			switch {
+			case horizontal && it.col == i && it.cols == 1:
+				size = math.Max(size, l.measure(it.node, true, w, h)+m.left+m.right)
+			case !horizontal && it.row == i && it.rows == 1:
+				width := span(cols, it.col, it.cols, gap) - m.left - m.right
+				size = math.Max(size, l.measure(it.node, false, width, h)+m.top+m.bottom)
+			}
+		}
+		return size
+	}
+	cols = sizeTracks(parseTracks(node.Props.Columns), gridColumns(node), w, gap, measuring, func(i int) float64 { return content(true, i) })
+	rows = sizeTracks(parseTracks(node.Props.Rows), nrows, h, gap, measuring, func(i int) float64 { return content(false, i) })
+	return items, cols, rows
+}
+
+// gridContent returns a container's content box inside its padding and
+// border.
+func (l *layoutEngine) gridContent(node *RenderNode, box ComputedLayout) ComputedLayout {
+	pad := l.resolveSpacing(node.Props.Padding)
+	bw := l.borderWidth(node)
+	return ComputedLayout{
+		X:      box.X + pad.left + bw,
+		Y:      box.Y + pad.top + bw,
+		Width:  math.Max(0, box.Width-pad.left-pad.right-2*bw),
+		Height: math.Max(0, box.Height-pad.top-pad.bottom-2*bw),
+	}
+}
+
+// layoutGrid lays out the children of a grid container, each stretched
+// over its cells.
+func (l *layoutEngine) layoutGrid(parent *RenderNode, parentLayout ComputedLayout) {
+	c := l.gridContent(parent, parentLayout)
+	items, cols, rows := l.gridTracks(parent, c.Width, c.Height, false)
+	gap := 0.0
+	if parent.Props.Gap != nil {
+		gap = float64(*parent.Props.Gap)
+	}
+	for _, it := range items {
+		l.layoutNode(it.node, l.within(it.node, ComputedLayout{
+			X:      c.X + trackStart(cols, it.col, gap),
+			Y:      c.Y + trackStart(rows, it.row, gap),
+			Width:  span(cols, it.col, it.cols, gap),
+			Height: span(rows, it.row, it.rows, gap),
+		}))
+	}
+}
+
+// measureGrid returns the size of a grid's tracks along one axis, with
+// the gaps between them.
+func (l *layoutEngine) measureGrid(node *RenderNode, horizontal bool, availW, availH float64) float64 {
+	_, cols, rows := l.gridTracks(node, availW, availH, true)
+	tracks := rows
+	if horizontal {
+		tracks = cols
+	}
+	if len(tracks) == 0 {
+		return 0
+	}
+	gap := 0.0
+	if node.Props.Gap != nil {
+		gap = float64(*node.Props.Gap)
+	}
+	return span(tracks, 0, len(tracks), gap)
+}
+
+// projectGrid projects a grid's children row by row: a row's children
+// are joined like a row box's, and the rows like a column box's.
+func projectGrid(node *RenderNode, tree *RenderTree, opts TextProjectionOptions, depth int) string {
+	items, nrows := gridPlace(node)
+	lines := make([][]string, nrows)
+	for _, it := range items {
+		if t := projectNode(it.node, tree, opts, depth+1); t != "" {
+			lines[it.row] = append(lines[it.row], t)
+		}
+	}
+	var rows []string
+	for _, line := range lines {
+		if len(line) > 0 {
+			rows = append(rows, strings.Join(line, opts.BoxSeparatorRow))
+		}
+	}
+	return strings.Join(rows, opts.BoxSeparatorColumn)
+}
+
+// htmlTracks converts a track template to CSS.
+func htmlTracks(v interface{}) string {
+	var css []string
+	for _, t := range parseTracks(v) {
+		switch {
+		case t.fr > 0:
+			css = append(css, fmt.Sprintf("%gfr", t.fr))
+		case t.auto:
+			css = append(css, "auto")
+		default:
+			css = append(css, fmt.Sprintf("%gpx", t.size))
+		}
+	}
+	return strings.Join(css, " ")
+}
@@ -0,0 +1,119 @@
+package viewer
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrNoEnv is returned by Handshake when the viewer has no EnvInfo yet.
+var ErrNoEnv = errors.New("viewer environment not initialized")
+
+// IncompatibleError lists the source requirements the viewer cannot meet.
+type IncompatibleError struct {
+	Unmet []string
+}
+
+func (e *IncompatibleError) Error() string {
+	return "incompatible viewer: " + strings.Join(e.Unmet, "; ")
+}
+
+// CheckRequirements reports which of req the environment env fails to
+// satisfy. Returns nil if env is compatible.
+func CheckRequirements(env EnvInfo, req Requirements) error {
+	var unmet []string
+
+	if req.MinViewportVersion > 0 && env.ViewportVersion < req.MinViewportVersion {
+		unmet = append(unmet, fmt.Sprintf("viewport version %d < %d", env.ViewportVersion, req.MinViewportVersion))
+	}
+	if req.MinDisplayWidth > 0 && env.DisplayWidth < req.MinDisplayWidth {
+		unmet = append(unmet, fmt.Sprintf("display width %d < %d", env.DisplayWidth, req.MinDisplayWidth))
+	}
+	if req.MinDisplayHeight > 0 && env.DisplayHeight < req.MinDisplayHeight {
+		unmet = append(unmet, fmt.Sprintf("display height %d < %d", env.DisplayHeight, req.MinDisplayHeight))
+	}
+	if req.MinColorDepth > 0 && env.ColorDepth < req.MinColorDepth {
+		unmet = append(unmet, fmt.Sprintf("color depth %d < %d", env.ColorDepth, req.MinColorDepth))
+	}
+	if req.GPU && !env.GPU {
+		unmet = append(unmet, "gpu unavailable")
+	}
+	for _, codec := range req.VideoDecode {
+		if !containsString(env.VideoDecode, codec) {
+			unmet = append(unmet, fmt.Sprintf("video codec %s unsupported", codec))
+		}
+	}
+	if req.AllowRemote != nil && !*req.AllowRemote && env.Remote {
+		unmet = append(unmet, "remote viewer not allowed")
+	}
+	if req.MaxLatencyMs > 0 && env.LatencyMs > req.MaxLatencyMs {
+		unmet = append(unmet, fmt.Sprintf("latency %.0fms > %.0fms", env.LatencyMs, req.MaxLatencyMs))
+	}
+
+	if len(unmet) == 0 {
+		return nil
+	}
+	return &IncompatibleError{Unmet: unmet}
+}
+
+// Handshake sends the viewer's EnvInfo upstream as MsgEnv. It must be the
+// first outbound message on a new connection; ServeCtx calls it
+// automatically when the environment is known.
+func (v *Viewer) Handshake() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.env == nil {
+		return ErrNoEnv
+	}
+	env := *v.env
+	v.emit(ProtocolMessage{Type: MsgEnv, Env: &env})
+	return nil
+}
+
+// SourceRequirements returns the requirements declared by the source via
+// MsgRequire, or nil if none were received.
+func (v *Viewer) SourceRequirements() *Requirements {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.requires == nil {
+		return nil
+	}
+	req := *v.requires
+	return &req
+}
+
+// Compatible returns the *IncompatibleError recorded when the source's
+// requirements could not be met, or nil.
+func (v *Viewer) Compatible() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.incompatible
+}
+
+// handleRequire records the source's requirements and checks them against
+// the current environment. Must be called with the mutex held.
+func (v *Viewer) handleRequire(req Requirements) {
+	v.requires = &req
+	v.incompatible = nil
+
+	env := EnvInfo{}
+	if v.env != nil {
+		env = *v.env
+	}
+	if err := CheckRequirements(env, req); err != nil {
+		v.incompatible = err
+		v.reportUpstream(ErrorReport{Code: ErrorCodeIncompatible, Message: err.Error(), MsgType: MsgRequire})
+	}
+}
+
+// containsString reports whether list contains s.
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
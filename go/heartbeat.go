@@ -0,0 +1,118 @@
+package viewer
+
+import (
+	"errors"
+	"time"
+)
+
+// Heartbeat: a remote connection can go half-open, with the source gone
+// but no error on the viewer's side, which then waits on a read that
+// never returns. With a heartbeat enabled, ServeCtx pings the source
+// (MsgPing) every interval and gives up with ErrHeartbeatTimeout when a
+// ping goes unanswered (MsgPong) for the timeout. Each answer measures the
+// round trip, which keeps EnvInfo.LatencyMs current unless the source
+// timestamps its messages (see receiveTimestamp). Either side may
+// ping: the viewer answers the source's pings itself, and SourceState
+// answers the viewer's in its next flush.
+
+// ErrHeartbeatTimeout is returned by ServeCtx when the source stops
+// answering pings.
+var ErrHeartbeatTimeout = errors.New("heartbeat timeout")
+
+// HeartbeatConfig configures connection keepalive.
+type HeartbeatConfig struct {
+	// Interval is the time between pings. Must be > 0.
+	Interval time.Duration
+
+	// Timeout is how long a ping may go unanswered before the connection
+	// is considered dead. Defaults to three intervals when zero.
+	Timeout time.Duration
+}
+
+// latencySmoothing is the weight of each new round trip in the latency
+// estimate, as in TCP's smoothed RTT.
+const latencySmoothing = 0.125
+
+// heartbeatState tracks pings sent and the latency estimate.
+type heartbeatState struct {
+	cfg   HeartbeatConfig
+	nonce uint64    // of the last ping sent (see sendPing)
+	sent  time.Time // when the outstanding ping was sent; zero if none
+	next  time.Time // when the next ping is due
+}
+
+// EnableHeartbeat turns on keepalive pings for the connections ServeCtx
+// serves. An Interval of zero disables them.
+func (v *Viewer) EnableHeartbeat(cfg HeartbeatConfig) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if cfg.Interval <= 0 {
+		v.heartbeat = nil
+		return
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 3 * cfg.Interval
+	}
+	v.heartbeat = &heartbeatState{cfg: cfg}
+}
+
+// checkHeartbeat sends a ping if one is due and none is outstanding, and
+// returns ErrHeartbeatTimeout if the outstanding one is overdue.
+func (v *Viewer) checkHeartbeat() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	hb := v.heartbeat
+	if hb == nil {
+		return nil
+	}
+	now := v.now()
+	if !hb.sent.IsZero() {
+		if now.Sub(hb.sent) >= hb.cfg.Timeout {
+			v.heartbeatTimeouts++
+			hb.sent = time.Time{}
+			return ErrHeartbeatTimeout
+		}
+		return nil
+	}
+	if now.Before(hb.next) {
+		return nil
+	}
+	hb.nonce = v.sendPing()
+	hb.sent = now
+	hb.next = now.Add(hb.cfg.Interval)
+	return nil
+}
+
+// receivePong records the round trip of the ping msg answers in the
+// latency estimate and, without timestamps, the environment, and the
+// source's clock, if the pong carries it, in the clock offset.
+// Must be called with the mutex held.
+func (v *Viewer) receivePong(msg ProtocolMessage) {
+	if msg.Nonce == nil {
+		return
+	}
+	sent, ok := v.pings[*msg.Nonce]
+	if !ok {
+		return
+	}
+	delete(v.pings, *msg.Nonce)
+	elapsed := v.now().Sub(sent)
+	if msg.Sent != nil {
+		v.addClockSample(sent, elapsed, *msg.Sent)
+	}
+	if hb := v.heartbeat; hb != nil && *msg.Nonce == hb.nonce {
+		hb.sent = time.Time{}
+	}
+
+	rtt := float64(elapsed) / float64(time.Millisecond)
+	if v.latencyMs == 0 {
+		v.latencyMs = rtt
+	} else {
+		v.latencyMs += latencySmoothing * (rtt - v.latencyMs)
+	}
+	if v.delaySamples == 0 {
+		v.setLatency(v.latencyMs)
+	}
+}
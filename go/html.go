@@ -0,0 +1,331 @@
+package viewer
+
+import (
+	"encoding/base64"
+	"fmt"
+	"html"
+	"strings"
+)
+
+// RenderHTML renders the tree as HTML markup for an HtmlTarget container.
+// Every element carries a data-vp-id attribute so DOM events can be mapped
+// back to node IDs.
+func RenderHTML(tree *RenderTree) string {
+	if tree.Root == nil {
+		return ""
+	}
+	var b strings.Builder
+	writeHTMLNode(&b, tree, tree.Root)
+	return b.String()
+}
+
+// writeHTMLNode writes one node and its subtree.
+func writeHTMLNode(b *strings.Builder, tree *RenderTree, node *RenderNode) {
+	p := node.Props
+	attrs := fmt.Sprintf(` data-vp-id="%d"`, node.ID)
+	if p.Interactive != "" {
+		attrs += fmt.Sprintf(` data-vp-interactive="%s"`, html.EscapeString(p.Interactive))
+	}
+	if p.TabIndex != nil {
+		attrs += fmt.Sprintf(` tabindex="%d"`, *p.TabIndex)
+	}
+	if isDisabled(node) && node.Type != NodeInput && node.Type != NodeButton && !isControl(node) {
+		attrs += ` aria-disabled="true"`
+	}
+	if p.Tooltip != nil {
+		attrs += fmt.Sprintf(` title="%s"`, html.EscapeString(*p.Tooltip))
+	}
+	if isDraggable(node) {
+		attrs += ` draggable="true"`
+	}
+	if isDroppable(node) {
+		attrs += ` data-vp-droppable="true"`
+	}
+	if tree.Dragging != nil && *tree.Dragging == node.ID {
+		attrs += ` data-vp-dragging="true"`
+	}
+	if tree.DropTarget != nil && *tree.DropTarget == node.ID {
+		attrs += ` data-vp-drop-target="true"`
+	}
+	if isModal(node) {
+		attrs += ` role="dialog" aria-modal="true"`
+	}
+	if p.TextDirection != "" {
+		attrs += fmt.Sprintf(` dir="%s"`, html.EscapeString(p.TextDirection))
+	}
+	if style := htmlStyle(node); style != "" {
+		attrs += fmt.Sprintf(` style="%s"`, html.EscapeString(style))
+	}
+
+	switch node.Type {
+	case NodeText:
+		content := ""
+		if p.Content != nil {
+			content = *p.Content
+		}
+		fmt.Fprintf(b, "<span%s>%s</span>", attrs, html.EscapeString(content))
+
+	case NodeBox, NodeScroll:
+		fmt.Fprintf(b, "<div%s>", attrs)
+		for _, child := range node.Children {
+			writeHTMLNode(b, tree, child)
+		}
+		b.WriteString("</div>")
+
+	case NodeInput:
+		if p.Value != nil {
+			attrs += fmt.Sprintf(` value="%s"`, html.EscapeString(shownValue(node)))
+		}
+		if p.Placeholder != nil {
+			attrs += fmt.Sprintf(` placeholder="%s"`, html.EscapeString(*p.Placeholder))
+		}
+		if p.Disabled != nil && *p.Disabled {
+			attrs += " disabled"
+		}
+		if p.MaxLength != nil {
+			attrs += fmt.Sprintf(` maxlength="%d"`, *p.MaxLength)
+		}
+		if isSecret(node) {
+			attrs += ` type="password"`
+		} else if p.InputType == InputNumber || p.InputType == InputEmail {
+			attrs += fmt.Sprintf(` type="%s"`, p.InputType)
+		}
+		if p.Pattern != "" {
+			attrs += fmt.Sprintf(` pattern="%s"`, html.EscapeString(p.Pattern))
+		}
+		if p.Multiline != nil && *p.Multiline {
+			fmt.Fprintf(b, "<textarea%s></textarea>", attrs)
+		} else {
+			fmt.Fprintf(b, "<input%s>", attrs)
+		}
+
+	case NodeImage:
+		alt := ""
+		if p.AltText != nil {
+			alt = *p.AltText
+		}
+		src := ""
+		if len(p.Data) > 0 && p.Format != "" {
+			mime := "image/" + p.Format
+			if p.Format == "svg" {
+				mime = "image/svg+xml"
+			}
+			src = "data:" + mime + ";base64," + base64.StdEncoding.EncodeToString(p.Data)
+		}
+		fmt.Fprintf(b, `<img%s src="%s" alt="%s">`, attrs, src, html.EscapeString(alt))
+
+	case NodeCanvas:
+		fmt.Fprintf(b, "<canvas%s></canvas>", attrs)
+
+	case NodeCheckbox, NodeRadio:
+		attrs += fmt.Sprintf(` type="%s"`, node.Type)
+		if p.Value != nil {
+			attrs += fmt.Sprintf(` value="%s"`, html.EscapeString(*p.Value))
+		}
+		if isChecked(node) {
+			attrs += " checked"
+		}
+		if isDisabled(node) {
+			attrs += " disabled"
+		}
+		label := ""
+		if p.Label != nil {
+			label = " " + html.EscapeString(*p.Label)
+		}
+		fmt.Fprintf(b, "<label><input%s>%s</label>", attrs, label)
+
+	case NodeButton:
+		if p.Variant != "" {
+			attrs += fmt.Sprintf(` data-vp-variant="%s"`, html.EscapeString(p.Variant))
+		}
+		if isDisabled(node) {
+			attrs += " disabled"
+		}
+		label := ""
+		if p.Label != nil {
+			label = html.EscapeString(*p.Label)
+		}
+		fmt.Fprintf(b, `<button%s type="button">%s</button>`, attrs, label)
+
+	case NodeSelect:
+		if isDisabled(node) {
+			attrs += " disabled"
+		}
+		fmt.Fprintf(b, "<select%s>", attrs)
+		for i, o := range p.Options {
+			selected := ""
+			if i == optionIndex(node) {
+				selected = " selected"
+			}
+			fmt.Fprintf(b, "<option%s>%s</option>", selected, html.EscapeString(o))
+		}
+		b.WriteString("</select>")
+
+	case NodeTable:
+		fmt.Fprintf(b, "<table%s><thead><tr>", attrs)
+		header, body := tableCells(tree, node)
+		for _, h := range header {
+			fmt.Fprintf(b, "<th>%s</th>", html.EscapeString(h))
+		}
+		b.WriteString("</tr></thead><tbody>")
+		for i, cells := range body {
+			if i == selectedRow(node) {
+				b.WriteString(`<tr aria-selected="true">`)
+			} else {
+				b.WriteString("<tr>")
+			}
+			for _, c := range cells {
+				fmt.Fprintf(b, "<td>%s</td>", html.EscapeString(c))
+			}
+			b.WriteString("</tr>")
+		}
+		b.WriteString("</tbody></table>")
+
+	case NodeProgress:
+		if frac, ok := progressFraction(node); ok {
+			fmt.Fprintf(b, `<progress%s value="%g" max="1"></progress>`, attrs, frac)
+		} else {
+			fmt.Fprintf(b, "<progress%s></progress>", attrs)
+		}
+
+	case NodeSpinner:
+		fmt.Fprintf(b, `<span%s role="status" aria-busy="true">%s</span>`, attrs, html.EscapeString(progressText(node)))
+
+	case NodeSeparator:
+		fmt.Fprintf(b, "<hr%s>", attrs)
+	}
+}
+
+// htmlStyle maps layout and visual props to inline CSS.
+func htmlStyle(node *RenderNode) string {
+	p := node.Props
+	if isHidden(node) {
+		return "display:none"
+	}
+	var css []string
+
+	switch node.Type {
+	case NodeBox, NodeScroll:
+		dir := "column"
+		if p.Direction == "row" {
+			dir = "row"
+		}
+		if p.Direction == DirectionGrid {
+			css = append(css, "display:grid")
+			if cols := htmlTracks(p.Columns); cols != "" {
+				css = append(css, "grid-template-columns:"+cols)
+			}
+			if rows := htmlTracks(p.Rows); rows != "" {
+				css = append(css, "grid-template-rows:"+rows)
+			}
+		} else {
+			css = append(css, "display:flex", "flex-direction:"+dir)
+		}
+		if isScroller(node) {
+			css = append(css, "overflow:auto")
+		} else if p.Overflow != "" {
+			css = append(css, "overflow:"+p.Overflow)
+		}
+		for _, child := range node.Children {
+			if isAbsolute(child) {
+				css = append(css, "position:relative")
+				break
+			}
+		}
+	}
+	if isSticky(node) {
+		css = append(css, "position:sticky", "top:0", "z-index:1")
+	}
+	switch {
+	case isFixed(node), isAbsolute(node):
+		css = append(css, "position:"+p.Position)
+		for _, side := range []struct {
+			name  string
+			value interface{}
+		}{{"top", p.Top}, {"right", p.Right}, {"bottom", p.Bottom}, {"left", p.Left}} {
+			if n, ok := htmlLength(side.value); ok {
+				css = append(css, side.name+":"+n)
+			}
+		}
+		if p.ZIndex != nil {
+			css = append(css, fmt.Sprintf("z-index:%d", *p.ZIndex))
+		}
+	case isOverlay(node):
+		css = append(css, "position:fixed", "inset:0", fmt.Sprintf("z-index:%d", *p.ZIndex))
+	}
+	if isModal(node) {
+		css = append(css, "background:rgba(0,0,0,0.5)")
+	}
+	if isDisabled(node) {
+		css = append(css, "opacity:0.5", "pointer-events:none")
+	}
+	if p.Gap != nil {
+		css = append(css, fmt.Sprintf("gap:%dpx", *p.Gap))
+	}
+	if p.ColSpan != nil {
+		css = append(css, fmt.Sprintf("grid-column:span %d", *p.ColSpan))
+	}
+	if p.RowSpan != nil {
+		css = append(css, fmt.Sprintf("grid-row:span %d", *p.RowSpan))
+	}
+	if p.Flex != nil {
+		css = append(css, fmt.Sprintf("flex:%g", *p.Flex))
+	}
+	if p.AspectRatio != nil {
+		css = append(css, fmt.Sprintf("aspect-ratio:%g", *p.AspectRatio))
+	}
+	if c, ok := p.Color.(string); ok {
+		css = append(css, "color:"+c)
+	}
+	if c, ok := p.Background.(string); ok {
+		css = append(css, "background:"+c)
+	}
+	if b := p.Border; b != nil {
+		style := b.Style
+		if style == "" {
+			style = BorderSolid
+		}
+		border := fmt.Sprintf("border:%dpx %s", b.Width, style)
+		if b.Color != "" {
+			border += " " + b.Color
+		}
+		css = append(css, border)
+	}
+	if p.BorderRadius != nil {
+		css = append(css, fmt.Sprintf("border-radius:%dpx", *p.BorderRadius))
+	}
+	if sh := p.Shadow; sh != nil {
+		shadow := fmt.Sprintf("box-shadow:%dpx %dpx %dpx", sh.X, sh.Y, sh.Blur)
+		if sh.Color != "" {
+			shadow += " " + sh.Color
+		}
+		css = append(css, shadow)
+	}
+	if p.Size != nil {
+		css = append(css, fmt.Sprintf("font-size:%dpx", *p.Size))
+	}
+	if p.Weight != "" {
+		css = append(css, "font-weight:"+p.Weight)
+	}
+	if p.Italic != nil && *p.Italic {
+		css = append(css, "font-style:italic")
+	}
+	if p.TextAlign != "" {
+		css = append(css, "text-align:"+p.TextAlign)
+	}
+	switch p.TextOverflow {
+	case OverflowWrap:
+		css = append(css, "white-space:pre-wrap")
+	case OverflowClip:
+		css = append(css, "white-space:pre", "overflow:hidden")
+	case OverflowEllipsis:
+		css = append(css, "white-space:nowrap", "overflow:hidden", "text-overflow:ellipsis")
+	}
+	if p.Opacity != nil {
+		css = append(css, fmt.Sprintf("opacity:%g", *p.Opacity))
+	}
+	if t := transformOf(node); t != nil {
+		css = append(css, "transform:"+htmlTransform(t))
+	}
+	return strings.Join(css, ";")
+}
@@ -0,0 +1,115 @@
+package viewer
+
+// IDAllocator hands out node IDs for source-authored trees so apps never
+// manage the integer ID space themselves.
+//
+// IDs are either anonymous (Alloc) or bound to a string key (Key), which
+// returns the same ID for the same key until it is released; keys are how
+// a re-rendered node keeps its identity. Released IDs are not reused
+// straight away: they are quarantined until Recycle, so a removal and a
+// reuse of the same ID never land in one flush where a coalesced patch
+// could confuse the two nodes. SourceState.Flush calls Recycle for the
+// allocator returned by SourceState.IDs.
+//
+// An IDAllocator is not safe for concurrent use.
+type IDAllocator struct {
+	next        int
+	live        map[int]string // ID → key ("" for anonymous IDs)
+	keys        map[string]int
+	quarantined []int
+	free        []int
+}
+
+// NewIDAllocator creates an allocator whose first ID is 1.
+func NewIDAllocator() *IDAllocator {
+	return &IDAllocator{
+		next: 1,
+		live: make(map[int]string),
+		keys: make(map[string]int),
+	}
+}
+
+// Alloc returns an unused ID, preferring recycled ones.
+func (a *IDAllocator) Alloc() int {
+	for len(a.free) > 0 {
+		id := a.free[len(a.free)-1]
+		a.free = a.free[:len(a.free)-1]
+		if _, ok := a.live[id]; !ok {
+			a.live[id] = ""
+			return id
+		}
+	}
+	for {
+		id := a.next
+		a.next++
+		if _, ok := a.live[id]; !ok {
+			a.live[id] = ""
+			return id
+		}
+	}
+}
+
+// Key returns the ID bound to key, allocating one on first use.
+func (a *IDAllocator) Key(key string) int {
+	if id, ok := a.keys[key]; ok {
+		return id
+	}
+	id := a.Alloc()
+	a.live[id] = key
+	a.keys[key] = id
+	return id
+}
+
+// Reserve marks an explicitly chosen ID as in use so Alloc never returns
+// it. Returns false if the ID is already live.
+func (a *IDAllocator) Reserve(id int) bool {
+	if _, ok := a.live[id]; ok {
+		return false
+	}
+	a.live[id] = ""
+	return true
+}
+
+// Release frees an ID, and its key binding if any. The ID becomes
+// available again after the next Recycle. Releasing an ID that is not
+// live does nothing.
+func (a *IDAllocator) Release(id int) {
+	key, ok := a.live[id]
+	if !ok {
+		return
+	}
+	delete(a.live, id)
+	if key != "" {
+		delete(a.keys, key)
+	}
+	a.quarantined = append(a.quarantined, id)
+}
+
+// ReleaseTree releases the IDs of node and all its descendants.
+func (a *IDAllocator) ReleaseTree(node *VNode) {
+	if node == nil {
+		return
+	}
+	a.Release(node.ID)
+	for _, c := range node.Children {
+		a.ReleaseTree(c)
+	}
+}
+
+// Recycle makes IDs released since the last Recycle available to Alloc.
+// Call it once their removal has been sent to the viewer.
+func (a *IDAllocator) Recycle() {
+	a.free = append(a.free, a.quarantined...)
+	a.quarantined = a.quarantined[:0]
+}
+
+// Live reports whether id is currently allocated or reserved.
+func (a *IDAllocator) Live(id int) bool {
+	_, ok := a.live[id]
+	return ok
+}
+
+// Len returns the number of live IDs.
+func (a *IDAllocator) Len() int {
+	return len(a.live)
+}
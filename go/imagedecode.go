@@ -0,0 +1,275 @@
+package viewer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	_ "image/jpeg" // register decoder
+	_ "image/png"  // register decoder
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "golang.org/x/image/webp" // register decoder
+)
+
+// Image decode pipeline for NodeImage data: format sniffing, size probing
+// for layout, and frame extraction for animated GIFs.
+
+// ErrUnsupportedImage is returned for image data that cannot be decoded.
+var ErrUnsupportedImage = errors.New("unsupported image format")
+
+// ImageError reports image data on a node that failed to decode.
+type ImageError struct {
+	NodeID int
+	Format string
+	Err    error
+}
+
+func (e *ImageError) Error() string {
+	return fmt.Sprintf("image #%d (%s): %v", e.NodeID, e.Format, e.Err)
+}
+
+func (e *ImageError) Unwrap() error { return e.Err }
+
+// ImageInfo is the result of probing image data without decoding pixels.
+type ImageInfo struct {
+	Format string
+	Width  int
+	Height int
+}
+
+// DecodedImage holds the frames of a decoded image. Still images have a
+// single frame.
+type DecodedImage struct {
+	Format string
+	Width  int
+	Height int
+	Frames []image.Image
+	Delays []time.Duration // per frame; empty for still images
+}
+
+// defaultFrameDelay is used for animation frames that declare no delay.
+const defaultFrameDelay = 100 * time.Millisecond
+
+// ProbeImage returns the format and pixel size of image data. format is
+// the node's declared format and may be empty, in which case it is
+// sniffed from the data.
+func ProbeImage(data []byte, format string) (ImageInfo, error) {
+	if normalizeImageFormat(format) == "svg" || (format == "" && looksLikeSVG(data)) {
+		w, h, ok := probeSVG(data)
+		if !ok {
+			return ImageInfo{Format: "svg"}, ErrUnsupportedImage
+		}
+		return ImageInfo{Format: "svg", Width: w, Height: h}, nil
+	}
+
+	cfg, sniffed, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return ImageInfo{Format: normalizeImageFormat(format)}, imageDecodeError(err)
+	}
+	return ImageInfo{Format: sniffed, Width: cfg.Width, Height: cfg.Height}, nil
+}
+
+// DecodeImage decodes png, jpeg, gif (including animation), and webp
+// data. SVG can be probed but not rasterized.
+func DecodeImage(data []byte, format string) (*DecodedImage, error) {
+	info, err := ProbeImage(data, format)
+	if err != nil {
+		return nil, err
+	}
+	if info.Format == "svg" {
+		return nil, ErrUnsupportedImage
+	}
+
+	if info.Format == "gif" {
+		g, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		return decodeGIFFrames(g), nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return &DecodedImage{
+		Format: info.Format,
+		Width:  info.Width,
+		Height: info.Height,
+		Frames: []image.Image{img},
+	}, nil
+}
+
+// FrameAt returns the frame showing after elapsed time, looping the
+// animation.
+func (d *DecodedImage) FrameAt(elapsed time.Duration) image.Image {
+	if len(d.Frames) <= 1 || len(d.Delays) != len(d.Frames) {
+		return d.Frames[0]
+	}
+	var total time.Duration
+	for _, delay := range d.Delays {
+		total += delay
+	}
+	t := elapsed % total
+	for i, delay := range d.Delays {
+		if t < delay {
+			return d.Frames[i]
+		}
+		t -= delay
+	}
+	return d.Frames[len(d.Frames)-1]
+}
+
+// decodeGIFFrames composites GIF frames onto the logical screen, honoring
+// each frame's disposal method, so every frame is a complete picture.
+func decodeGIFFrames(g *gif.GIF) *DecodedImage {
+	bounds := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	canvas := image.NewRGBA(bounds)
+	out := &DecodedImage{Format: "gif", Width: bounds.Dx(), Height: bounds.Dy()}
+
+	for i, frame := range g.Image {
+		var previous *image.RGBA
+		disposal := byte(0)
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		if disposal == gif.DisposalPrevious {
+			previous = image.NewRGBA(bounds)
+			draw.Draw(previous, bounds, canvas, image.Point{}, draw.Src)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		snapshot := image.NewRGBA(bounds)
+		draw.Draw(snapshot, bounds, canvas, image.Point{}, draw.Src)
+		out.Frames = append(out.Frames, snapshot)
+
+		delay := defaultFrameDelay
+		if i < len(g.Delay) && g.Delay[i] > 0 {
+			delay = time.Duration(g.Delay[i]) * 10 * time.Millisecond
+		}
+		out.Delays = append(out.Delays, delay)
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+	return out
+}
+
+// normalizeImageFormat lower-cases a format name and maps aliases.
+func normalizeImageFormat(format string) string {
+	format = strings.ToLower(format)
+	if format == "jpg" {
+		return "jpeg"
+	}
+	return format
+}
+
+// imageDecodeError maps the standard library's unknown-format error to
+// ErrUnsupportedImage.
+func imageDecodeError(err error) error {
+	if errors.Is(err, image.ErrFormat) {
+		return ErrUnsupportedImage
+	}
+	return err
+}
+
+// looksLikeSVG reports whether data starts like an SVG document.
+func looksLikeSVG(data []byte) bool {
+	head := bytes.TrimSpace(data)
+	if len(head) > 256 {
+		head = head[:256]
+	}
+	return bytes.HasPrefix(head, []byte("<svg")) ||
+		(bytes.HasPrefix(head, []byte("<?xml")) && bytes.Contains(head, []byte("<svg")))
+}
+
+var (
+	svgWidthRe   = regexp.MustCompile(`<svg[^>]*\swidth="([\d.]+)(?:px)?"`)
+	svgHeightRe  = regexp.MustCompile(`<svg[^>]*\sheight="([\d.]+)(?:px)?"`)
+	svgViewBoxRe = regexp.MustCompile(`<svg[^>]*\sviewBox="[\d.-]+[\s,]+[\d.-]+[\s,]+([\d.]+)[\s,]+([\d.]+)"`)
+)
+
+// probeSVG reads the intrinsic size of an SVG from its width and height
+// attributes, falling back to the viewBox.
+func probeSVG(data []byte) (width, height int, ok bool) {
+	if w, h := svgWidthRe.FindSubmatch(data), svgHeightRe.FindSubmatch(data); w != nil && h != nil {
+		return atoiFloat(w[1]), atoiFloat(h[1]), true
+	}
+	if vb := svgViewBoxRe.FindSubmatch(data); vb != nil {
+		return atoiFloat(vb[1]), atoiFloat(vb[2]), true
+	}
+	return 0, 0, false
+}
+
+// atoiFloat parses a decimal number, truncating to an int.
+func atoiFloat(b []byte) int {
+	f, _ := strconv.ParseFloat(string(b), 64)
+	return int(f)
+}
+
+// imageCache keeps decoded images per node so frames are not re-decoded
+// on every render, and so each decode failure is reported once.
+type imageCache struct {
+	entries map[int]*cachedImage
+	errors  []error // failures not yet reported
+}
+
+// cachedImage is one node's decoded image.
+type cachedImage struct {
+	data    []byte
+	format  string
+	decoded *DecodedImage
+	err     error
+	start   time.Time // when the image first appeared, for animation
+}
+
+// lookup returns the decoded image for a node, decoding it if the node's
+// data changed. The frame to show is chosen from the time since the
+// image first appeared.
+func (c *imageCache) lookup(node *RenderNode, now time.Time) (image.Image, bool) {
+	data, format := node.Props.Data, node.Props.Format
+	entry, ok := c.entries[node.ID]
+	if !ok || !sameBytes(entry.data, data) || entry.format != format {
+		entry = &cachedImage{data: data, format: format, start: now}
+		entry.decoded, entry.err = DecodeImage(data, format)
+		if entry.err != nil {
+			c.errors = append(c.errors, &ImageError{NodeID: node.ID, Format: format, Err: entry.err})
+		}
+		if c.entries == nil {
+			c.entries = make(map[int]*cachedImage)
+		}
+		c.entries[node.ID] = entry
+	}
+	if entry.err != nil {
+		return nil, false
+	}
+	return entry.decoded.FrameAt(now.Sub(entry.start)), true
+}
+
+// prune drops entries for nodes no longer in the tree.
+func (c *imageCache) prune(tree *RenderTree) {
+	for id := range c.entries {
+		if _, ok := tree.NodeIndex[id]; !ok {
+			delete(c.entries, id)
+		}
+	}
+}
+
+// sameBytes reports whether two slices are the same backing data, which
+// holds for image props the tree has not replaced.
+func sameBytes(a, b []byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	return len(a) == 0 || &a[0] == &b[0]
+}
@@ -0,0 +1,46 @@
+package viewer
+
+import "time"
+
+// Input latency. Every input event the viewer sends is stamped with the
+// viewer's clock (InputEvent.Time) and an echo token (InputEvent.Echo).
+// A source that calls SourceState.Echo with the token of the event it is
+// responding to gets the token carried back on the frame that responds
+// (ProtocolMessage.Echo), and the viewer measures from the event to the
+// moment that frame is applied: the interaction latency a user feels,
+// transport and source included. The last, average and peak are reported
+// in ViewerMetrics.
+
+// maxPendingEchoes bounds how many unanswered input events are
+// remembered; events older than that are never measured.
+const maxPendingEchoes = 256
+
+// stampInput gives an outbound event its time and echo token.
+// Must be called with the mutex held.
+func (v *Viewer) stampInput(event *InputEvent) {
+	now := v.now()
+	v.echoNext++
+	event.Time = now.UnixMilli()
+	event.Echo = v.echoNext
+	if v.echoSent == nil {
+		v.echoSent = make(map[uint64]time.Time)
+	}
+	v.echoSent[v.echoNext] = now
+	delete(v.echoSent, v.echoNext-maxPendingEchoes)
+}
+
+// receiveEcho records the latency of the input event an inbound frame
+// responds to. Tokens not sent, or already answered, are ignored.
+// Must be called with the mutex held.
+func (v *Viewer) receiveEcho(token uint64) {
+	sent, ok := v.echoSent[token]
+	if !ok {
+		return
+	}
+	delete(v.echoSent, token)
+	ms := float64(v.now().Sub(sent)) / float64(time.Millisecond)
+	v.inputLatencyMs = ms
+	v.inputLatencySum += ms
+	v.inputLatencyCount++
+	v.peakInputLatencyMs = max(v.peakInputLatencyMs, ms)
+}
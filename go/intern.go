@@ -0,0 +1,168 @@
+package viewer
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SlotInterner hoists inline colors and text styles that repeat across a
+// tree into DEFINE slots, rewriting props to slot references so each
+// value is sent once instead of on every node.
+//
+// A value is hoisted once it appears MinUses times in one tree; after that
+// it stays interned, so later trees reference the same slot and diff
+// cleanly against earlier ones. Styles are only hoisted from nodes without
+// a style slot of their own and when they combine at least two props,
+// since a lone prop is no larger than the reference. Slot numbers are
+// taken upward from the first slot given to NewSlotInterner; keep the
+// app's own slots out of that range.
+type SlotInterner struct {
+	// MinUses is how often a value must repeat in a tree before it is
+	// hoisted. Zero means 2.
+	MinUses int
+
+	state  *SourceState
+	first  int
+	next   int
+	colors map[string]int
+	styles map[string]int
+}
+
+// styleKeys are the inline props folded into an interned style slot.
+var styleKeys = []string{"decoration", "fontFamily", "italic", "size", "weight"}
+
+// NewSlotInterner creates an interner that defines its slots on state,
+// numbering them from firstSlot.
+func NewSlotInterner(state *SourceState, firstSlot uint32) *SlotInterner {
+	return &SlotInterner{
+		state:  state,
+		first:  int(firstSlot),
+		next:   int(firstSlot),
+		colors: make(map[string]int),
+		styles: make(map[string]int),
+	}
+}
+
+// Intern rewrites root in place and returns it. New slots are queued on
+// the SourceState with DefineSlot, so they are flushed ahead of the tree
+// or patch that references them.
+func (in *SlotInterner) Intern(root *VNode) *VNode {
+	if root == nil {
+		return nil
+	}
+	minUses := in.MinUses
+	if minUses <= 0 {
+		minUses = 2
+	}
+
+	colorUses := make(map[string]int)
+	styleUses := make(map[string]int)
+	walkVNode(root, func(n *VNode) {
+		for _, c := range []interface{}{n.Props.Color, n.Props.Background} {
+			if s, ok := c.(string); ok && s != "" {
+				colorUses[s]++
+			}
+		}
+		if key, _ := inlineStyle(n); key != "" {
+			styleUses[key]++
+		}
+	})
+
+	walkVNode(root, func(n *VNode) {
+		n.Props.Color = in.color(n.Props.Color, colorUses, minUses)
+		n.Props.Background = in.color(n.Props.Background, colorUses, minUses)
+
+		key, props := inlineStyle(n)
+		if key == "" {
+			return
+		}
+		slot, ok := in.styles[key]
+		if !ok {
+			if styleUses[key] < minUses {
+				return
+			}
+			slot = in.define(StyleSlot{Kind: "style", Props: props})
+			in.styles[key] = slot
+		}
+		n.Props.Style = &slot
+		n.Props.Weight, n.Props.Italic, n.Props.Decoration = "", nil, ""
+		n.Props.FontFamily, n.Props.Size = "", nil
+	})
+	return root
+}
+
+// color returns the slot reference for an inline color string that is
+// or should be interned, or v unchanged.
+func (in *SlotInterner) color(v interface{}, uses map[string]int, minUses int) interface{} {
+	s, ok := v.(string)
+	if !ok || s == "" {
+		return v
+	}
+	slot, ok := in.colors[s]
+	if !ok {
+		if uses[s] < minUses {
+			return v
+		}
+		slot = in.define(ColorSlot{Kind: "color", Value: s})
+		in.colors[s] = slot
+	}
+	return slot
+}
+
+// reset forgets every interned value, for a viewer that has not seen
+// the slots.
+func (in *SlotInterner) reset() {
+	in.next = in.first
+	in.colors = make(map[string]int)
+	in.styles = make(map[string]int)
+}
+
+func (in *SlotInterner) define(value SlotValue) int {
+	slot := in.next
+	in.next++
+	in.state.DefineSlot(uint32(slot), value)
+	return slot
+}
+
+// inlineStyle returns a node's inline text style props and a canonical
+// key for them, or "" if the node has a style slot or fewer than two
+// style props.
+func inlineStyle(n *VNode) (string, map[string]interface{}) {
+	if n.Props.Style != nil {
+		return "", nil
+	}
+	props := make(map[string]interface{})
+	if n.Props.Decoration != "" {
+		props["decoration"] = n.Props.Decoration
+	}
+	if n.Props.FontFamily != "" {
+		props["fontFamily"] = n.Props.FontFamily
+	}
+	if n.Props.Italic != nil {
+		props["italic"] = *n.Props.Italic
+	}
+	if n.Props.Size != nil {
+		props["size"] = *n.Props.Size
+	}
+	if n.Props.Weight != "" {
+		props["weight"] = n.Props.Weight
+	}
+	if len(props) < 2 {
+		return "", nil
+	}
+	var sb strings.Builder
+	for _, k := range styleKeys {
+		if v, ok := props[k]; ok {
+			fmt.Fprintf(&sb, "%s=%v;", k, v)
+		}
+	}
+	return sb.String(), props
+}
+
+// walkVNode visits node and its descendants depth-first.
+func walkVNode(node *VNode, fn func(*VNode)) {
+	fn(node)
+	for _, c := range node.Children {
+		walkVNode(c, fn)
+	}
+}
@@ -0,0 +1,135 @@
+package viewer
+
+import "time"
+
+// Key repeat. Hosts that report keys coming up as well as going down, but
+// not the repeats of a held key — window systems that leave repeat to
+// the application, terminals in the kitty protocol without repeat
+// reporting — would move a cursor or scroll a node one step per press.
+// For the keys that navigate, held in the focused input or scroll node,
+// the viewer repeats them itself: after KeyRepeatConfig.Delay, then
+// every Interval, until the key comes up, another goes down, or focus
+// moves. Tick drives the repeats. Once the host sends a repeat of its
+// own, the viewer leaves repeating to it. Keys given to Key, which never
+// come up, are not repeated, and headless viewers never repeat, so tests
+// and replays see only the keys given to them.
+
+// KeyRepeatConfig configures viewer-side key repeat.
+type KeyRepeatConfig struct {
+	// Enabled repeats held navigation keys.
+	Enabled bool
+
+	// Delay is how long a key is held before it first repeats, and
+	// Interval the time between repeats after that. They default to
+	// half a second and 1/30 of a second.
+	Delay    time.Duration
+	Interval time.Duration
+}
+
+// DefaultKeyRepeatConfig returns the configuration new viewers use.
+func DefaultKeyRepeatConfig() KeyRepeatConfig {
+	return KeyRepeatConfig{Enabled: true, Delay: 500 * time.Millisecond, Interval: time.Second / 30}
+}
+
+// keyRepeat is a held key being repeated.
+type keyRepeat struct {
+	key    KeyEvent
+	target int // the node focused when it went down
+	next   time.Time
+}
+
+// SetKeyRepeat replaces the key repeat configuration. Turning repeat off
+// stops a key repeating.
+func (v *Viewer) SetKeyRepeat(cfg KeyRepeatConfig) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	def := DefaultKeyRepeatConfig()
+	if cfg.Delay <= 0 {
+		cfg.Delay = def.Delay
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = def.Interval
+	}
+	v.keyRepeatCfg = &cfg
+	if !cfg.Enabled {
+		v.heldKey = nil
+	}
+}
+
+// keyRepeatConfig returns the configuration in effect.
+// Must be called with the mutex held.
+func (v *Viewer) keyRepeatConfig() KeyRepeatConfig {
+	if v.keyRepeatCfg == nil {
+		return DefaultKeyRepeatConfig()
+	}
+	return *v.keyRepeatCfg
+}
+
+// holdKey notes a key event KeyInput handled, starting or stopping the
+// repeat of a held key; consumed is whether the viewer consumed it.
+// Must be called with the mutex held.
+func (v *Viewer) holdKey(e KeyEvent, consumed bool) {
+	switch e.Action {
+	case KeyRepeat:
+		v.nativeRepeat = true
+		v.heldKey = nil
+		return
+	case KeyUp:
+		if h := v.heldKey; h != nil && h.key.Key == e.Key {
+			v.heldKey = nil
+		}
+		return
+	}
+	v.heldKey = nil
+	cfg := v.keyRepeatConfig()
+	if !consumed || !cfg.Enabled || v.nativeRepeat || v.renderTarget.TargetType() == "headless" || !repeats(e.Key) {
+		return
+	}
+	if v.tree.Focused == nil {
+		return
+	}
+	node := v.tree.NodeIndex[*v.tree.Focused]
+	if node == nil || node.Type != NodeInput && !isScroller(node) {
+		return
+	}
+	e.Action = KeyRepeat
+	v.heldKey = &keyRepeat{key: e, target: node.ID, next: v.now().Add(cfg.Delay)}
+}
+
+// checkKeyRepeat repeats the held key if it is due. Returns whether a
+// key is held.
+// Must be called with the mutex held.
+func (v *Viewer) checkKeyRepeat() bool {
+	h := v.heldKey
+	if h == nil {
+		return false
+	}
+	if v.tree.Focused == nil || *v.tree.Focused != h.target {
+		v.heldKey = nil
+		return false
+	}
+	now := v.now()
+	if now.Before(h.next) {
+		return true
+	}
+	interval := v.keyRepeatConfig().Interval
+	h.next = h.next.Add(interval)
+	if h.next.Before(now) {
+		// Ticks stopped for a while; carry on from now rather than
+		// catching up all at once.
+		h.next = now.Add(interval)
+	}
+	key := h.key
+	v.key(key.name(), &key)
+	return true
+}
+
+// repeats reports whether a held key is repeated.
+func repeats(key string) bool {
+	switch key {
+	case "arrowup", "arrowdown", "arrowleft", "arrowright", "pageup", "pagedown", "backspace", "delete":
+		return true
+	}
+	return false
+}
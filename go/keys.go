@@ -0,0 +1,436 @@
+package viewer
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Keys. Key takes a key as a name in keybind notation ("ctrl+s"), which
+// is all most hosts have. A KeyEvent says more: the modifiers held, the
+// physical key (Code) apart from the character it typed (Text), and
+// whether the key went down, repeated, or came back up. KeyInput handles
+// one, and the events it sends the source carry the extra detail.
+//
+// Key names follow keybind slots. Named keys are lowercase — "enter",
+// "tab", "escape", "backspace", "delete", "insert", "home", "end",
+// "pageup", "pagedown", "arrowup" and the other arrows, "f1" to "f12" —
+// and any other key is the character it types, with shift folded in
+// ("A", not "shift+a"). Modifiers prefix the name in the order ctrl,
+// alt, shift, meta, and the space bar is " ", written "space" in
+// keybinds. ParseKey reads the notation, aliases included ("esc",
+// "up", "cmd+q"), and KeyEvent.String writes it back.
+//
+// ParseTerminalKeys decodes what a terminal in raw mode sends: control
+// bytes, alt as a leading escape, the xterm cursor, function and
+// modifier sequences, modifyOtherKeys, and the kitty keyboard protocol,
+// which alone reports repeats and releases.
+
+// ErrUnknownKey is returned by ParseKey for a name it cannot read.
+var ErrUnknownKey = errors.New("unknown key")
+
+// KeyModifiers is a set of modifier keys.
+type KeyModifiers uint8
+
+const (
+	ModShift KeyModifiers = 1 << iota
+	ModAlt
+	ModCtrl
+	ModMeta // command, super, windows
+)
+
+// KeyAction is what a key did.
+type KeyAction int
+
+const (
+	KeyDown   KeyAction = iota // pressed
+	KeyRepeat                  // auto-repeated while held
+	KeyUp                      // released
+)
+
+// String returns the action as sent in InputEvent.Action.
+func (a KeyAction) String() string {
+	switch a {
+	case KeyRepeat:
+		return "repeat"
+	case KeyUp:
+		return "up"
+	}
+	return "down"
+}
+
+// KeyEvent is a key going down, repeating, or coming up.
+type KeyEvent struct {
+	Key    string // name, as in keybind slots, without modifiers
+	Code   string // physical key ("KeyA", "ArrowUp"), if known
+	Text   string // the text the key types, if any
+	Mods   KeyModifiers
+	Action KeyAction
+}
+
+// String returns the key in keybind notation, modifiers first.
+func (e KeyEvent) String() string {
+	var sb strings.Builder
+	for _, m := range []struct {
+		mod  KeyModifiers
+		name string
+	}{{ModCtrl, "ctrl+"}, {ModAlt, "alt+"}, {ModShift, "shift+"}, {ModMeta, "meta+"}} {
+		if e.Mods&m.mod != 0 {
+			sb.WriteString(m.name)
+		}
+	}
+	if e.Key == " " {
+		sb.WriteString("space")
+	} else {
+		sb.WriteString(e.Key)
+	}
+	return sb.String()
+}
+
+// Matches reports whether the key is the one bind names in keybind
+// notation, with exactly the modifiers it names. Letters held with
+// ctrl, alt or meta match in either case.
+func (e KeyEvent) Matches(bind string) bool {
+	b, err := ParseKey(bind)
+	if err != nil || b.Mods != e.Mods {
+		return false
+	}
+	if e.Mods&(ModCtrl|ModAlt|ModMeta) != 0 {
+		return strings.EqualFold(b.Key, e.Key)
+	}
+	return b.Key == e.Key
+}
+
+// name returns what the viewer handles the key as: the text it types,
+// or its keybind notation if it types none.
+func (e KeyEvent) name() string {
+	if e.Text != "" {
+		return e.Text
+	}
+	return e.String()
+}
+
+// modifierNames maps modifier names in keybind notation to modifiers.
+var modifierNames = map[string]KeyModifiers{
+	"ctrl": ModCtrl, "control": ModCtrl,
+	"alt": ModAlt, "option": ModAlt, "shift": ModShift,
+	"meta": ModMeta, "cmd": ModMeta, "command": ModMeta, "super": ModMeta, "win": ModMeta,
+}
+
+// keyAliases maps other names for keys to theirs.
+var keyAliases = map[string]string{
+	"esc": "escape", "return": "enter", "del": "delete", "ins": "insert",
+	"up": "arrowup", "down": "arrowdown", "left": "arrowleft", "right": "arrowright",
+	"pgup": "pageup", "pgdn": "pagedown", "space": " ", "spacebar": " ",
+}
+
+// keyCodes maps named keys to their physical key codes.
+var keyCodes = map[string]string{
+	"enter": "Enter", "tab": "Tab", "escape": "Escape", "backspace": "Backspace",
+	"delete": "Delete", "insert": "Insert", "home": "Home", "end": "End",
+	"pageup": "PageUp", "pagedown": "PageDown",
+	"arrowup": "ArrowUp", "arrowdown": "ArrowDown", "arrowleft": "ArrowLeft", "arrowright": "ArrowRight",
+	"f1": "F1", "f2": "F2", "f3": "F3", "f4": "F4", "f5": "F5", "f6": "F6",
+	"f7": "F7", "f8": "F8", "f9": "F9", "f10": "F10", "f11": "F11", "f12": "F12",
+}
+
+// ParseKey reads a key in keybind notation ("ctrl+shift+tab", "A",
+// "cmd+q"). Modifier and key names are case-insensitive, except that a
+// character is itself.
+func ParseKey(s string) (KeyEvent, error) {
+	key, mods := s, KeyModifiers(0)
+	for {
+		// A "+" that ends the name, or is all of it, is the key.
+		i := strings.IndexByte(key, '+')
+		if i <= 0 || i == len(key)-1 {
+			break
+		}
+		m, ok := modifierNames[strings.ToLower(key[:i])]
+		if !ok {
+			return KeyEvent{}, fmt.Errorf("%q: %w", s, ErrUnknownKey)
+		}
+		mods |= m
+		key = key[i+1:]
+	}
+	lower := strings.ToLower(key)
+	if alias, ok := keyAliases[lower]; ok {
+		lower, key = alias, alias
+	}
+	if _, ok := keyCodes[lower]; ok {
+		return namedKey(lower, mods), nil
+	}
+	if r, n := utf8.DecodeRuneInString(key); n > 0 && n == len(key) && r != utf8.RuneError {
+		return charKey(r, mods), nil
+	}
+	return KeyEvent{}, fmt.Errorf("%q: %w", s, ErrUnknownKey)
+}
+
+// namedKey returns a named key.
+func namedKey(name string, mods KeyModifiers) KeyEvent {
+	return KeyEvent{Key: name, Code: keyCodes[name], Mods: mods}
+}
+
+// charKey returns the key that types r, folding shift into letters. It
+// types r unless ctrl, alt or meta is held.
+func charKey(r rune, mods KeyModifiers) KeyEvent {
+	if mods == ModShift && unicode.IsLetter(r) {
+		r, mods = unicode.ToUpper(r), 0
+	}
+	e := KeyEvent{Key: string(r), Mods: mods}
+	switch u := unicode.ToUpper(r); {
+	case u >= 'A' && u <= 'Z':
+		e.Code = "Key" + string(u)
+	case r >= '0' && r <= '9':
+		e.Code = "Digit" + string(r)
+	case r == ' ':
+		e.Code = "Space"
+	}
+	if mods&(ModCtrl|ModAlt|ModMeta) == 0 {
+		e.Text = string(r)
+	}
+	return e
+}
+
+// ParseTerminalKeys decodes the keys in input read from a terminal in raw
+// mode. It returns them and how many bytes they took; bytes left over
+// start a sequence cut off at the end, to be decoded with what follows.
+// Other reports the terminal sends, such as mouse sequences, are skipped.
+func ParseTerminalKeys(b []byte) (keys []KeyEvent, n int) {
+	for n < len(b) {
+		e, size := terminalKey(b[n:])
+		if size == 0 {
+			break
+		}
+		n += size
+		if e.Key != "" {
+			keys = append(keys, e)
+		}
+	}
+	return keys, n
+}
+
+// terminalKey decodes the key at the start of b and returns it and its
+// length in bytes: 0 if b ends before the key does, and a key with no
+// name for a sequence that is not a key.
+func terminalKey(b []byte) (KeyEvent, int) {
+	switch c := b[0]; {
+	case c == 0x1b:
+		// A lone escape is the escape key: a terminal sends a sequence
+		// all at once.
+		if len(b) == 1 {
+			return namedKey("escape", 0), 1
+		}
+		switch b[1] {
+		case '[':
+			return csiKey(b)
+		case 'O':
+			if len(b) < 3 {
+				return KeyEvent{}, 0
+			}
+			if name, ok := csiKeys[b[2]]; ok {
+				return namedKey(name, 0), 3
+			}
+			return KeyEvent{}, 3
+		}
+		// Alt sends the key's own bytes after an escape.
+		e, size := terminalKey(b[1:])
+		if size == 0 {
+			return KeyEvent{}, 0
+		}
+		if e.Key != "" {
+			e = withMods(e, e.Mods|ModAlt)
+		}
+		return e, size + 1
+	case c == '\r':
+		return namedKey("enter", 0), 1
+	case c == '\t':
+		return namedKey("tab", 0), 1
+	case c == 0x7f || c == 0x08:
+		return namedKey("backspace", 0), 1
+	case c == 0:
+		return charKey(' ', ModCtrl), 1
+	case c < 0x1b:
+		return charKey(rune('a'+c-1), ModCtrl), 1
+	case c < 0x20:
+		return charKey(rune(c+0x40), ModCtrl), 1
+	}
+	if !utf8.FullRune(b) {
+		return KeyEvent{}, 0
+	}
+	r, size := utf8.DecodeRune(b)
+	return charKey(r, 0), size
+}
+
+// withMods returns the key with other modifiers held, which may change
+// the text it types.
+func withMods(e KeyEvent, mods KeyModifiers) KeyEvent {
+	if _, named := keyCodes[e.Key]; named {
+		e.Mods = mods
+		return e
+	}
+	r, _ := utf8.DecodeRuneInString(e.Key)
+	return charKey(r, mods)
+}
+
+// csiKeys maps the final bytes of cursor and function key sequences to
+// key names.
+var csiKeys = map[byte]string{
+	'A': "arrowup", 'B': "arrowdown", 'C': "arrowright", 'D': "arrowleft",
+	'H': "home", 'F': "end", 'P': "f1", 'Q': "f2", 'R': "f3", 'S': "f4",
+}
+
+// tildeKeys maps the numbers of "CSI n ~" sequences to key names.
+var tildeKeys = map[int]string{
+	1: "home", 2: "insert", 3: "delete", 4: "end", 5: "pageup", 6: "pagedown",
+	7: "home", 8: "end", 11: "f1", 12: "f2", 13: "f3", 14: "f4", 15: "f5",
+	17: "f6", 18: "f7", 19: "f8", 20: "f9", 21: "f10", 23: "f11", 24: "f12",
+}
+
+// maxSequence bounds how long an unfinished escape sequence is waited on
+// before its start is taken for keys.
+const maxSequence = 64
+
+// csiKey decodes a control sequence ("ESC [ params final") at the start
+// of b.
+func csiKey(b []byte) (KeyEvent, int) {
+	end := 2
+	for end < len(b) && (b[end] < 0x40 || b[end] > 0x7e) {
+		end++
+	}
+	if end == len(b) {
+		if len(b) < maxSequence {
+			return KeyEvent{}, 0
+		}
+		return charKey('[', ModAlt), 2
+	}
+	size := end + 1
+	params, final := string(b[2:end]), b[end]
+	if params != "" && strings.IndexByte("<=>?", params[0]) >= 0 {
+		// A mouse or other report, not a key.
+		return KeyEvent{}, size
+	}
+
+	// Parameters are "key;modifiers:action" with ";"-separated fields
+	// and ":"-separated subfields.
+	var fields [][]int
+	for _, f := range strings.Split(params, ";") {
+		var sub []int
+		for _, s := range strings.Split(f, ":") {
+			n, _ := strconv.Atoi(s)
+			sub = append(sub, n)
+		}
+		fields = append(fields, sub)
+	}
+	field := func(i, j int) int {
+		if i < len(fields) && j < len(fields[i]) {
+			return fields[i][j]
+		}
+		return 0
+	}
+	mods, action := terminalMods(field(1, 0)), KeyDown
+	switch field(1, 1) {
+	case 2:
+		action = KeyRepeat
+	case 3:
+		action = KeyUp
+	}
+
+	var e KeyEvent
+	switch {
+	case final == 'Z':
+		e = namedKey("tab", ModShift)
+	case final == '~' && field(0, 0) == 27:
+		// modifyOtherKeys: "27;modifiers;code~".
+		e = codeKey(field(2, 0), terminalMods(field(1, 0)))
+	case final == '~':
+		name, ok := tildeKeys[field(0, 0)]
+		if !ok {
+			return KeyEvent{}, size
+		}
+		e = namedKey(name, mods)
+	case final == 'u':
+		e = codeKey(field(0, 0), mods)
+	default:
+		name, ok := csiKeys[final]
+		if !ok {
+			return KeyEvent{}, size
+		}
+		e = namedKey(name, mods)
+	}
+	e.Action = action
+	return e, size
+}
+
+// codeKey returns the key a kitty or modifyOtherKeys sequence reports by
+// its Unicode code point.
+func codeKey(code int, mods KeyModifiers) KeyEvent {
+	switch code {
+	case 13:
+		return namedKey("enter", mods)
+	case 9:
+		return namedKey("tab", mods)
+	case 27:
+		return namedKey("escape", mods)
+	case 127, 8:
+		return namedKey("backspace", mods)
+	}
+	if code <= 0 || code > unicode.MaxRune {
+		return KeyEvent{}
+	}
+	return charKey(rune(code), mods)
+}
+
+// terminalMods decodes a terminal's modifier parameter: one more than a
+// bitmask of shift 1, alt 2, ctrl 4, super 8 and, in the kitty protocol,
+// meta 32.
+func terminalMods(p int) KeyModifiers {
+	if p <= 1 {
+		return 0
+	}
+	bits := p - 1
+	var mods KeyModifiers
+	if bits&1 != 0 {
+		mods |= ModShift
+	}
+	if bits&2 != 0 {
+		mods |= ModAlt
+	}
+	if bits&4 != 0 {
+		mods |= ModCtrl
+	}
+	if bits&(8|32) != 0 {
+		mods |= ModMeta
+	}
+	return mods
+}
+
+// KeyInput handles a key event. Keys going down or repeating are handled
+// as Key handles them, by the text they type or else their keybind
+// notation; keys coming up are only sent to the source. Events sent to
+// the source carry the key's code, text, modifiers and action.
+// Returns whether the viewer consumed the key.
+func (v *Viewer) KeyInput(e KeyEvent) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	consumed := v.key(e.name(), &e)
+	v.holdKey(e, consumed)
+	return consumed
+}
+
+// keyEvent returns the input event that sends key to the source, with
+// the detail of e if there is one.
+func keyEvent(target *int, key string, e *KeyEvent) InputEvent {
+	event := InputEvent{Target: target, Kind: "key", Key: key}
+	if e != nil {
+		event.Key = e.String()
+		event.Code = e.Code
+		event.Text = e.Text
+		event.Modifiers = e.Mods
+		event.Action = e.Action.String()
+	}
+	return event
+}
@@ -0,0 +1,547 @@
+package viewer
+
+import (
+	"math"
+	"strings"
+)
+
+// Layout engine — flexbox subset, ported from src/core/layout.ts.
+//
+// Computes a ComputedLayout rectangle for every node. The same algorithm
+// serves pixel targets and character-cell targets; LayoutOptions supplies
+// the unit conversions.
+//
+// Supported:
+//   - direction: row | column
+//   - justify: start | end | center | between | around | evenly
+//   - align: start | end | center | stretch | baseline (rows)
+//   - gap, padding, margin (uniform, 2-value, 4-value)
+//   - border width, which insets a box's content like padding
+//   - width, height (numbers)
+//   - flex grow
+//   - text overflow: wrapped text is as tall as its wrapped lines
+//   - font size: text metrics scale with size on pixel targets
+//   - sticky children of scroll nodes, pinned to the scrolled viewport
+//   - multiline inputs as tall as their lines, within limits
+
+// LayoutOptions controls unit conversion for layout.
+type LayoutOptions struct {
+	// CharWidth and LineHeight size text content.
+	CharWidth  float64
+	LineHeight float64
+
+	// SpacingScale multiplies padding and margin values. Sources author
+	// spacing in pixels; cell targets scale it down (8px ≈ one cell).
+	SpacingScale float64
+
+	// PixelWidth and PixelHeight are the size of one unit in image
+	// pixels, used to size images from their intrinsic dimensions.
+	PixelWidth  float64
+	PixelHeight float64
+
+	// Baseline is the distance from the top of a line of text to its
+	// baseline, for baseline alignment.
+	Baseline float64
+
+	// Round snaps every rectangle to whole units (character cells).
+	Round bool
+}
+
+// PixelLayoutOptions returns options for pixel-based targets.
+func PixelLayoutOptions() LayoutOptions {
+	return LayoutOptions{CharWidth: 8, LineHeight: 20, Baseline: 14, SpacingScale: 1, PixelWidth: 1, PixelHeight: 1}
+}
+
+// CellLayoutOptions returns options for character-cell targets (ANSI).
+func CellLayoutOptions() LayoutOptions {
+	return LayoutOptions{CharWidth: 1, LineHeight: 1, Baseline: 1, SpacingScale: 1.0 / 8, PixelWidth: 8, PixelHeight: 16, Round: true}
+}
+
+// ComputeLayout lays out the whole tree within a width × height viewport,
+// setting ComputedLayout on every node in place. Returns the layouts by
+// node ID.
+func ComputeLayout(tree *RenderTree, width, height float64, opts LayoutOptions) map[int]*ComputedLayout {
+	layouts := make(map[int]*ComputedLayout, len(tree.NodeIndex))
+	if tree.Root == nil {
+		return layouts
+	}
+
+	l := &layoutEngine{opts: opts, layouts: layouts, tree: tree}
+	l.layoutTree(tree.Root, ComputedLayout{Width: width, Height: height})
+	return layouts
+}
+
+// layoutEngine carries options and results through a layout pass.
+type layoutEngine struct {
+	opts     LayoutOptions
+	layouts  map[int]*ComputedLayout
+	tree     *RenderTree    // for the data rows tables show; may be nil
+	viewport ComputedLayout // the screen, for viewport-relative sizes
+}
+
+// spacing is a resolved padding or margin.
+type spacing struct {
+	top, right, bottom, left float64
+}
+
+// layoutNode lays out a node within bounds, then its children.
+func (l *layoutEngine) layoutNode(node *RenderNode, bounds ComputedLayout) {
+	p := node.Props
+
+	width := bounds.Width
+	if w, ok := l.ownSize(p.Width); ok {
+		width = w
+	}
+	height := bounds.Height
+	if h, ok := l.ownSize(p.Height); ok {
+		height = h
+	}
+
+	margin := l.resolveSpacing(p.Margin)
+	layout := ComputedLayout{
+		X:      bounds.X + margin.left,
+		Y:      bounds.Y + margin.top,
+		Width:  math.Max(0, width-margin.left-margin.right),
+		Height: math.Max(0, height-margin.top-margin.bottom),
+	}
+	layout.Width = constrain(node, true, layout.Width)
+	layout.Height = constrain(node, false, layout.Height)
+	if ratio, ok := aspectRatio(node); ok {
+		layout.Width, layout.Height = fitAspect(p, ratio, layout.Width, layout.Height, hasSize(p.Width), hasSize(p.Height))
+	}
+	if l.opts.Round {
+		layout = roundLayout(layout)
+	}
+
+	node.ComputedLayout = &layout
+	l.layouts[node.ID] = &layout
+
+	if len(node.Children) > 0 && (node.Type == NodeBox || node.Type == NodeScroll) {
+		if p.Direction == DirectionGrid {
+			l.layoutGrid(node, layout)
+		} else {
+			l.layoutChildren(node, layout)
+		}
+		l.layoutAbsolute(node, layout)
+	}
+}
+
+// childInfo holds per-child sizing state during a flex pass.
+type childInfo struct {
+	child          *RenderNode
+	fixedMain      float64
+	hasFixedMain   bool
+	fixedCross     float64
+	hasFixedCross  bool
+	flexGrow       float64
+	margin         spacing
+	mainMargin     float64
+	allocatedMain  float64
+	allocatedCross float64
+}
+
+// layoutChildren lays out the children of a flex container.
+func (l *layoutEngine) layoutChildren(parent *RenderNode, parentLayout ComputedLayout) {
+	p := parent.Props
+	padding := l.resolveSpacing(p.Padding)
+	bw := l.borderWidth(parent)
+	padding = spacing{padding.top + bw, padding.right + bw, padding.bottom + bw, padding.left + bw}
+	gap := 0.0
+	if p.Gap != nil {
+		gap = float64(*p.Gap)
+	}
+	justify := p.Justify
+	align := p.Align
+	if align == "" {
+		align = "stretch"
+	}
+
+	contentX := parentLayout.X + padding.left
+	contentY := parentLayout.Y + padding.top
+	contentW := math.Max(0, parentLayout.Width-padding.left-padding.right)
+	contentH := math.Max(0, parentLayout.Height-padding.top-padding.bottom)
+
+	isRow := p.Direction == "row"
+	mainSize, crossSize := contentH, contentW
+	if isRow {
+		mainSize, crossSize = contentW, contentH
+	}
+
+	// Measure children: fixed sizes, flex factors, margins.
+	children := flowChildren(parent)
+	infos := make([]*childInfo, len(children))
+	for i, child := range children {
+		cp := child.Props
+		info := &childInfo{child: child, margin: l.resolveSpacing(cp.Margin)}
+		if isRow {
+			info.fixedMain, info.hasFixedMain = l.size(cp.Width, contentW)
+			info.fixedCross, info.hasFixedCross = l.size(cp.Height, contentH)
+			info.mainMargin = info.margin.left + info.margin.right
+		} else {
+			info.fixedMain, info.hasFixedMain = l.size(cp.Height, contentH)
+			info.fixedCross, info.hasFixedCross = l.size(cp.Width, contentW)
+			info.mainMargin = info.margin.top + info.margin.bottom
+		}
+		if cp.Flex != nil {
+			info.flexGrow = *cp.Flex
+		}
+		infos[i] = info
+	}
+
+	// First pass: allocate fixed and content sizes.
+	totalGap := gap * float64(len(infos)-1)
+	fixedTotal := totalGap
+	totalFlex := 0.0
+	for _, info := range infos {
+		switch {
+		case info.hasFixedMain:
+			info.allocatedMain = constrain(info.child, isRow, info.fixedMain)
+			fixedTotal += info.allocatedMain + info.mainMargin
+		case info.flexGrow > 0:
+			totalFlex += info.flexGrow
+			fixedTotal += info.mainMargin
+		default:
+			info.allocatedMain = constrain(info.child, isRow, l.measure(info.child, isRow, contentW, contentH))
+			fixedTotal += info.allocatedMain + info.mainMargin
+		}
+	}
+
+	// Second pass: distribute remaining space to flex items, within
+	// their limits.
+	if totalFlex > 0 {
+		shareFlex(infos, math.Max(0, mainSize-fixedTotal), isRow)
+	}
+
+	// Cross-axis allocation.
+	for _, info := range infos {
+		crossMargin := info.margin.left + info.margin.right
+		if isRow {
+			crossMargin = info.margin.top + info.margin.bottom
+		}
+		if info.hasFixedCross {
+			info.allocatedCross = info.fixedCross
+		} else {
+			info.allocatedCross = math.Max(0, crossSize-crossMargin)
+		}
+		info.allocatedCross = constrain(info.child, !isRow, info.allocatedCross)
+	}
+	var below []float64
+	if isRow && align == AlignBaseline {
+		below = l.alignBaselines(infos, contentH)
+	}
+
+	// Justify along the main axis.
+	totalUsed := totalGap
+	for _, info := range infos {
+		totalUsed += info.allocatedMain + info.mainMargin
+	}
+	freeSpace := math.Max(0, mainSize-totalUsed)
+
+	mainPos := contentY
+	if isRow {
+		mainPos = contentX
+	}
+	itemGap := gap
+	n := float64(len(infos))
+	switch justify {
+	case "end":
+		mainPos += freeSpace
+	case "center":
+		mainPos += freeSpace / 2
+	case "between":
+		if len(infos) > 1 {
+			itemGap = gap + freeSpace/(n-1)
+		}
+	case "around":
+		space := freeSpace / n
+		mainPos += space / 2
+		itemGap = gap + space
+	case "evenly":
+		space := freeSpace / (n + 1)
+		mainPos += space
+		itemGap = gap + space
+	}
+
+	// Position each child.
+	for i, info := range infos {
+		mainOffset, crossOffset := info.margin.top, info.margin.left
+		crossPos := contentX
+		if isRow {
+			mainOffset, crossOffset = info.margin.left, info.margin.top
+			crossPos = contentY
+		}
+
+		switch align {
+		case "end":
+			crossPos += crossSize - info.allocatedCross - crossOffset
+		case "center":
+			crossPos += (crossSize - info.allocatedCross) / 2
+		case AlignBaseline:
+			if isRow {
+				crossPos += below[i]
+			}
+			crossPos += crossOffset
+		default: // start, stretch
+			crossPos += crossOffset
+		}
+
+		var bounds ComputedLayout
+		if isRow {
+			bounds = ComputedLayout{X: mainPos + mainOffset, Y: crossPos, Width: info.allocatedMain, Height: info.allocatedCross}
+		} else {
+			bounds = ComputedLayout{X: crossPos, Y: mainPos + mainOffset, Width: info.allocatedCross, Height: info.allocatedMain}
+		}
+		// Margins are applied by the child itself.
+		bounds.X -= info.margin.left
+		bounds.Y -= info.margin.top
+		bounds.Width += info.margin.left + info.margin.right
+		bounds.Height += info.margin.top + info.margin.bottom
+		if isScroller(parent) && isSticky(info.child) && p.ScrollTop != nil {
+			// Pinned below the top of the scrolled viewport.
+			bounds.Y = math.Max(bounds.Y, contentY+float64(*p.ScrollTop)-info.margin.top)
+		}
+		l.layoutNode(info.child, bounds)
+
+		mainPos += info.allocatedMain + info.mainMargin
+		if i < len(infos)-1 {
+			mainPos += itemGap
+		}
+	}
+}
+
+// measure returns a node's intrinsic size along one axis: width when
+// horizontal is true, height otherwise. availW and availH bound the result.
+func (l *layoutEngine) measure(node *RenderNode, horizontal bool, availW, availH float64) float64 {
+	p := node.Props
+	if horizontal {
+		if w, ok := l.size(p.Width, availW); ok {
+			return constrain(node, true, w)
+		}
+	} else if h, ok := l.size(p.Height, availH); ok {
+		return constrain(node, false, h)
+	}
+	if ratio, ok := aspectRatio(node); ok {
+		// The side it is given is the space available less its margin.
+		m := l.resolveSpacing(p.Margin)
+		w, hasW := l.size(p.Width, availW)
+		if !hasW {
+			w = availW - m.left - m.right
+		}
+		h, hasH := l.size(p.Height, availH)
+		if !hasH {
+			h = availH - m.top - m.bottom
+		}
+		w, h = fitAspect(p, ratio, math.Max(w, 0), math.Max(h, 0), hasW, hasH)
+		if horizontal {
+			return math.Min(w, availW)
+		}
+		return math.Min(h, availH)
+	}
+
+	var size float64
+	switch node.Type {
+	case NodeText:
+		content := ""
+		if p.Content != nil {
+			content = *p.Content
+		}
+		// Wrapped text is as tall as its lines at the width it will get:
+		// its own, or what is available less its margin.
+		charW, lineH := l.textUnits(p)
+		cols := 0
+		if p.TextOverflow == OverflowWrap && !horizontal {
+			width, ok := l.size(p.Width, availW)
+			if !ok {
+				m := l.resolveSpacing(p.Margin)
+				width = availW - m.left - m.right
+			}
+			cols = max(1, int(width/charW))
+		}
+		lines := fitLines(content, p.TextOverflow, cols)
+		if horizontal {
+			widest := 1
+			for _, line := range lines {
+				if w := StringWidth(line); w > widest {
+					widest = w
+				}
+			}
+			size = float64(widest) * charW
+		} else {
+			size = float64(len(lines)) * lineH
+		}
+
+	case NodeSeparator:
+		if horizontal {
+			size = availW
+		} else {
+			size = l.opts.LineHeight
+		}
+
+	case NodeCheckbox, NodeRadio, NodeSelect, NodeButton:
+		if horizontal {
+			size = float64(StringWidth(controlText(node))) * l.opts.CharWidth
+		} else {
+			size = l.opts.LineHeight
+		}
+
+	case NodeProgress:
+		if horizontal {
+			size = 20 * l.opts.CharWidth
+		} else {
+			size = l.opts.LineHeight
+		}
+
+	case NodeTable:
+		if horizontal {
+			size = float64(tableWidth(l.tree, node)) * l.opts.CharWidth
+		} else {
+			_, rows := tableData(l.tree, node)
+			size = float64(1+len(rows)) * l.opts.LineHeight
+		}
+
+	case NodeSpinner:
+		if horizontal {
+			text := spinnerFrames[0]
+			if node.Props.Label != nil {
+				text += " " + *node.Props.Label
+			}
+			size = float64(StringWidth(text)) * l.opts.CharWidth
+		} else {
+			size = l.opts.LineHeight
+		}
+
+	case NodeInput:
+		if horizontal {
+			size = 25 * l.opts.CharWidth
+		} else if isMultiline(node) {
+			// Grows with its lines, within limits.
+			rows := strings.Count(inputValue(node), "\n") + 1
+			size = float64(min(max(rows, multilineMinRows), multilineMaxRows)) * l.opts.LineHeight
+		} else {
+			size = l.opts.LineHeight
+		}
+
+	case NodeImage, NodeCanvas:
+		if node.Type == NodeImage && len(p.Data) > 0 {
+			if info, err := ProbeImage(p.Data, p.Format); err == nil {
+				if horizontal {
+					size = float64(info.Width) / l.opts.PixelWidth
+				} else {
+					size = float64(info.Height) / l.opts.PixelHeight
+				}
+				break
+			}
+		}
+		if horizontal {
+			alt := MediaPlaceholder(node)
+			if p.AltText != nil {
+				alt = *p.AltText
+			}
+			size = float64(StringWidth(alt)) * l.opts.CharWidth
+		} else {
+			size = l.opts.LineHeight
+		}
+
+	case NodeBox, NodeScroll:
+		padding := l.resolveSpacing(p.Padding)
+		gap := 0.0
+		if p.Gap != nil {
+			gap = float64(*p.Gap)
+		}
+		if p.Direction == DirectionGrid {
+			bw := 2 * l.borderWidth(node)
+			size = l.measureGrid(node, horizontal, availW-padding.left-padding.right-bw, availH-padding.top-padding.bottom-bw)
+		} else {
+			// Along the container's own direction children add up; across
+			// it the largest child wins.
+			stacked := (p.Direction == "row") == horizontal
+			for i, child := range flowChildren(node) {
+				m := l.resolveSpacing(child.Props.Margin)
+				childSize := l.measure(child, horizontal, availW, availH)
+				if horizontal {
+					childSize += m.left + m.right
+				} else {
+					childSize += m.top + m.bottom
+				}
+				if stacked {
+					size += childSize
+					if i > 0 {
+						size += gap
+					}
+				} else if childSize > size {
+					size = childSize
+				}
+			}
+		}
+		size += 2 * l.borderWidth(node)
+		if horizontal {
+			size += padding.left + padding.right
+		} else {
+			size += padding.top + padding.bottom
+		}
+	}
+
+	size = constrain(node, horizontal, size)
+	if horizontal {
+		return math.Min(size, availW)
+	}
+	return math.Min(size, availH)
+}
+
+// textUnits returns the character width and line height of a text node:
+// the layout's, scaled by a size prop on pixel targets.
+func (l *layoutEngine) textUnits(p NodeProps) (charW, lineH float64) {
+	if l.opts.Round || p.Size == nil || *p.Size <= 0 {
+		return l.opts.CharWidth, l.opts.LineHeight
+	}
+	k := float64(*p.Size) / defaultFontSize
+	return math.Max(1, math.Round(l.opts.CharWidth*k)), math.Max(1, math.Round(l.opts.LineHeight*k))
+}
+
+// resolveSpacing converts a padding or margin prop (number, [v, h], or
+// [t, r, b, l]) into scaled edge values.
+func (l *layoutEngine) resolveSpacing(v interface{}) spacing {
+	s := resolveSpacingValue(v)
+	k := l.opts.SpacingScale
+	if k == 0 {
+		k = 1
+	}
+	s = spacing{s.top * k, s.right * k, s.bottom * k, s.left * k}
+	if l.opts.Round {
+		s = spacing{math.Round(s.top), math.Round(s.right), math.Round(s.bottom), math.Round(s.left)}
+	}
+	return s
+}
+
+// resolveSpacingValue parses an unscaled spacing prop.
+func resolveSpacingValue(v interface{}) spacing {
+	if n, ok := toFloat(v); ok {
+		return spacing{n, n, n, n}
+	}
+	arr, ok := v.([]interface{})
+	if !ok {
+		return spacing{}
+	}
+	vals := make([]float64, len(arr))
+	for i, a := range arr {
+		vals[i], _ = toFloat(a)
+	}
+	switch len(vals) {
+	case 2:
+		return spacing{vals[0], vals[1], vals[0], vals[1]}
+	case 4:
+		return spacing{vals[0], vals[1], vals[2], vals[3]}
+	}
+	return spacing{}
+}
+
+// roundLayout snaps a rectangle to whole units.
+func roundLayout(r ComputedLayout) ComputedLayout {
+	x, y := math.Floor(r.X), math.Floor(r.Y)
+	return ComputedLayout{
+		X:      x,
+		Y:      y,
+		Width:  math.Max(0, math.Floor(r.X+r.Width)-x),
+		Height: math.Max(0, math.Floor(r.Y+r.Height)-y),
+	}
+}
@@ -0,0 +1,47 @@
+package viewer
+
+import "time"
+
+// Metrics reports. A source cannot see how hard the viewer is working:
+// a slow display, a deep tree or frames dropped by flow control all look
+// the same from upstream. With reporting enabled, ServeCtx sends the
+// viewer's metrics to the source (MsgMetrics) at a fixed interval, so the
+// source can adapt its fidelity — update less often, send simpler trees —
+// to what the viewer keeps up with. SourceState keeps the latest report.
+
+// metricsReportState schedules metrics reports.
+type metricsReportState struct {
+	interval time.Duration
+	next     time.Time // when the next report is due
+}
+
+// ReportMetrics makes ServeCtx send the viewer's metrics upstream every
+// interval. An interval of zero stops the reports.
+func (v *Viewer) ReportMetrics(interval time.Duration) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if interval <= 0 {
+		v.metricsReport = nil
+		return
+	}
+	v.metricsReport = &metricsReportState{interval: interval}
+}
+
+// checkMetricsReport sends a MsgMetrics if one is due.
+func (v *Viewer) checkMetricsReport() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	r := v.metricsReport
+	if r == nil {
+		return
+	}
+	now := v.now()
+	if now.Before(r.next) {
+		return
+	}
+	r.next = now.Add(r.interval)
+	m := v.metrics()
+	v.emit(ProtocolMessage{Type: MsgMetrics, Metrics: &m})
+}
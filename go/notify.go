@@ -0,0 +1,136 @@
+package viewer
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// Notifications. A source that needs the user's attention outside its UI
+// — a long build finished, a message arrived while the window is hidden —
+// sends a MsgNotify, and the viewer passes it to a Notifier. The host
+// picks the notifier with SetNotifier: a Terminal rings the bell and
+// raises an OSC 777 notification, DesktopNotifier uses the desktop's
+// notification service, and NotifierFunc hands notifications to a
+// callback. By default a viewer with an attached terminal notifies
+// through it, and others drop notifications. Notifier failures go to the
+// error handlers.
+
+// ErrNoNotifier is returned by DesktopNotifier when no notification tool
+// is installed.
+var ErrNoNotifier = errors.New("no notification tool found")
+
+// Urgencies for Notification.Urgency.
+const (
+	UrgencyLow      = "low"
+	UrgencyNormal   = "normal"
+	UrgencyCritical = "critical"
+)
+
+// Notification is an out-of-band alert from the source.
+type Notification struct {
+	Title   string `json:"title" cbor:"title"`
+	Body    string `json:"body,omitempty" cbor:"body,omitempty"`
+	Urgency string `json:"urgency,omitempty" cbor:"urgency,omitempty"` // empty means UrgencyNormal
+}
+
+// Notifier surfaces notifications to the user.
+type Notifier interface {
+	// Notify shows n and returns without waiting for the user. It is
+	// called with the viewer's lock held.
+	Notify(n Notification) error
+}
+
+// NotifierFunc adapts a function to a Notifier.
+type NotifierFunc func(n Notification) error
+
+func (f NotifierFunc) Notify(n Notification) error { return f(n) }
+
+// Notify rings the bell, unless n is of low urgency, and shows n with
+// OSC 777, which terminals without it ignore. Control characters in the
+// title and body are dropped, so a source cannot smuggle escape sequences
+// through them.
+func (t *Terminal) Notify(n Notification) error {
+	var buf strings.Builder
+	if n.Urgency != UrgencyLow {
+		buf.WriteString("\a")
+	}
+	title := strings.ReplaceAll(stripControls(n.Title), ";", ",")
+	fmt.Fprintf(&buf, "\x1b]777;notify;%s;%s\x1b\\", title, stripControls(n.Body))
+	return t.write([]byte(buf.String()))
+}
+
+// stripControls returns s without C0 and C1 control characters.
+func stripControls(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || (r >= 0x7f && r < 0xa0) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// DesktopNotifier shows notifications with the desktop's notification
+// service, through its command-line tools: notify-send on Linux and other
+// freedesktop systems, and osascript on macOS.
+type DesktopNotifier struct{}
+
+func (DesktopNotifier) Notify(n Notification) error {
+	var cmd *exec.Cmd
+	switch {
+	case runtime.GOOS == "darwin":
+		script := fmt.Sprintf("display notification %s with title %s", appleScriptString(n.Body), appleScriptString(n.Title))
+		cmd = exec.Command("osascript", "-e", script)
+	default:
+		if _, err := exec.LookPath("notify-send"); err != nil {
+			return ErrNoNotifier
+		}
+		urgency := n.Urgency
+		if urgency == "" {
+			urgency = UrgencyNormal
+		}
+		cmd = exec.Command("notify-send", "-u", urgency, "--", n.Title, n.Body)
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go cmd.Wait()
+	return nil
+}
+
+// appleScriptString quotes s as an AppleScript string literal.
+func appleScriptString(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
+
+// SetNotifier replaces the viewer's notifier. Passing nil restores the
+// default.
+func (v *Viewer) SetNotifier(n Notifier) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.notifier = n
+}
+
+// notify passes n to the notifier in effect: the one set with
+// SetNotifier, or else the first attached terminal.
+// Must be called with the mutex held.
+func (v *Viewer) notify(n Notification) {
+	notifier := v.notifier
+	if notifier == nil {
+		for _, ts := range v.targets {
+			if ts.term != nil {
+				notifier = ts.term
+				break
+			}
+		}
+	}
+	if notifier == nil {
+		return
+	}
+	if err := notifier.Notify(n); err != nil {
+		v.reportError(fmt.Errorf("notification %q: %w", n.Title, err))
+	}
+}
@@ -0,0 +1,168 @@
+package viewer
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Number columns. A column with the number format groups a value's
+// integer digits in thousands — 1,234,567.5 — and one with the
+// compact_number format abbreviates it — 1.2M. The separators follow a
+// locale: the column's own (SchemaColumn.Locale) or else the viewer's
+// (SetLocale), English unless set. Locales are BCP 47 tags; those not
+// known here fall back to their language, then to English.
+// SchemaColumn.Precision fixes the digits after the decimal point: by
+// default a number shows as many as its value has, and a compact number
+// one, dropped when it is zero.
+
+// numberLocale is how a locale writes numbers.
+type numberLocale struct {
+	group   string // between groups of three integer digits
+	decimal string // before the fraction
+}
+
+// numberLocales maps lower-case locale tags to their separators.
+var numberLocales = map[string]numberLocale{
+	"en": {",", "."}, "ja": {",", "."}, "zh": {",", "."}, "ko": {",", "."}, "he": {",", "."},
+	"de": {".", ","}, "es": {".", ","}, "it": {".", ","}, "nl": {".", ","}, "pt": {".", ","},
+	"tr": {".", ","}, "id": {".", ","}, "da": {".", ","},
+	"fr": {"\u202f", ","}, "ru": {"\u00a0", ","}, "pl": {"\u00a0", ","}, "uk": {"\u00a0", ","},
+	"cs": {"\u00a0", ","}, "sv": {"\u00a0", ","}, "fi": {"\u00a0", ","}, "nb": {"\u00a0", ","},
+	"de-ch": {"’", "."}, "pt-br": {".", ","}, "en-za": {"\u00a0", ","},
+}
+
+// compactUnits are the abbreviations of compact numbers, largest first.
+var compactUnits = []struct {
+	size   float64
+	suffix string
+}{{1e12, "T"}, {1e9, "B"}, {1e6, "M"}, {1e3, "K"}}
+
+// SetLocale sets the locale number columns are written in, unless they
+// name their own: a BCP 47 tag such as "de" or "pt-BR". Empty means
+// English.
+func (v *Viewer) SetLocale(tag string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.locale = tag
+	v.tree.Locale = tag
+	v.markDirty()
+	v.signalChanged()
+}
+
+// localeFor returns the separators of the column's locale, or else of
+// tag.
+func localeFor(column SchemaColumn, tag string) numberLocale {
+	if column.Locale != "" {
+		tag = column.Locale
+	}
+	tag = strings.ToLower(strings.ReplaceAll(tag, "_", "-"))
+	for tag != "" {
+		if loc, ok := numberLocales[tag]; ok {
+			return loc
+		}
+		i := strings.LastIndexByte(tag, '-')
+		if i < 0 {
+			break
+		}
+		tag = tag[:i]
+	}
+	return numberLocales["en"]
+}
+
+// formatNumber writes a number with its integer digits grouped, and
+// precision digits after the decimal point, or as many as it has if
+// precision is nil.
+func formatNumber(value interface{}, precision *int, loc numberLocale) (string, bool) {
+	var digits string
+	switch n := value.(type) {
+	case int:
+		digits = strconv.Itoa(n)
+	case int64:
+		digits = strconv.FormatInt(n, 10)
+	case uint64:
+		digits = strconv.FormatUint(n, 10)
+	case float64:
+		if math.IsInf(n, 0) || math.IsNaN(n) {
+			return strconv.FormatFloat(n, 'f', -1, 64), true
+		}
+		prec := -1
+		if precision != nil {
+			prec = *precision
+		}
+		digits = strconv.FormatFloat(n, 'f', prec, 64)
+	default:
+		return "", false
+	}
+	if precision != nil && *precision > 0 && !strings.Contains(digits, ".") {
+		digits += "." + strings.Repeat("0", *precision)
+	}
+	return localizeDigits(digits, loc), true
+}
+
+// compactNumber writes a number abbreviated to thousands, millions,
+// billions or trillions, with precision digits after the decimal point,
+// or one unless it is zero if precision is nil. Numbers under a thousand
+// or too large to abbreviate, and NaN and infinities, are written as
+// formatNumber writes them.
+func compactNumber(value interface{}, precision *int, loc numberLocale) (string, bool) {
+	n, ok := toFloat(value)
+	if !ok {
+		return "", false
+	}
+	if math.IsInf(n, 0) || math.IsNaN(n) {
+		return formatNumber(n, nil, loc)
+	}
+	prec, trim := 1, true
+	if precision != nil {
+		prec, trim = *precision, false
+	}
+	abs := math.Abs(n)
+	for i, u := range compactUnits {
+		if abs < u.size {
+			continue
+		}
+		scaled := strconv.FormatFloat(abs/u.size, 'f', prec, 64)
+		if f, _ := strconv.ParseFloat(scaled, 64); f >= 1000 {
+			if i == 0 {
+				// A thousand trillion or more has no unit.
+				break
+			}
+			// Rounding carried into the next unit up.
+			u = compactUnits[i-1]
+			scaled = strconv.FormatFloat(abs/u.size, 'f', prec, 64)
+		}
+		if trim && strings.Contains(scaled, ".") {
+			scaled = strings.TrimRight(strings.TrimRight(scaled, "0"), ".")
+		}
+		if n < 0 {
+			scaled = "-" + scaled
+		}
+		return localizeDigits(scaled, loc) + u.suffix, true
+	}
+	return formatNumber(n, precision, loc)
+}
+
+// localizeDigits groups the integer digits of a plain decimal number
+// ("-1234.5") and writes its separators as loc does.
+func localizeDigits(s string, loc numberLocale) string {
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+	whole, frac, hasFrac := strings.Cut(s, ".")
+	var sb strings.Builder
+	sb.WriteString(sign)
+	for i, d := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			sb.WriteString(loc.group)
+		}
+		sb.WriteRune(d)
+	}
+	if hasFrac {
+		sb.WriteString(loc.decimal)
+		sb.WriteString(frac)
+	}
+	return sb.String()
+}
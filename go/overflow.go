@@ -0,0 +1,66 @@
+package viewer
+
+// Overflow. A box's overflow prop says what happens to children that
+// reach outside it. "hidden", like leaving it unset, clips them to the
+// box inside its border; "visible" draws them wherever they are, and the
+// pointer reaches them there too; "auto" makes the box scroll like a
+// scroll node, with scrollTop, a scrollbar, and the wheel. Scroll nodes
+// always clip.
+//
+// Text projections follow: a box with overflow hidden leaves out the
+// children clipped out of it, and one with overflow auto the children
+// scrolled out of view, as scroll nodes do, unless the projection asks
+// for full scroll content.
+
+// Overflow modes.
+const (
+	OverflowVisible = "visible"
+	OverflowHidden  = "hidden"
+	OverflowAuto    = "auto"
+)
+
+// isScroller reports whether a node scrolls its children: a scroll node,
+// or a box with overflow auto.
+func isScroller(node *RenderNode) bool {
+	return node.Type == NodeScroll || node.Type == NodeBox && node.Props.Overflow == OverflowAuto
+}
+
+// clipsChildren reports whether a node clips its children to its box.
+func clipsChildren(node *RenderNode) bool {
+	return node.Props.Overflow != OverflowVisible || node.Type == NodeScroll
+}
+
+// reaches reports whether (x, y), in the coordinates of the node's
+// parent, is on a node drawn scrolled up by dy, or on a descendant
+// showing outside it.
+func reaches(node *RenderNode, in *inputLayout, x, y, dy float64) bool {
+	r := in.layouts[node.ID]
+	if r == nil {
+		return false
+	}
+	x, y = in.local(node, x, y, dy)
+	if x >= r.X && x < r.X+r.Width && y >= r.Y-dy && y < r.Y-dy+r.Height {
+		return true
+	}
+	if clipsChildren(node) {
+		return false
+	}
+	for _, child := range paintOrder(node) {
+		if reaches(child, in, x, y, dy) {
+			return true
+		}
+	}
+	return false
+}
+
+// projectedChild reports whether a container's child appears in a text
+// projection, or is clipped or scrolled out of it.
+func projectedChild(node, child *RenderNode, opts TextProjectionOptions) bool {
+	switch {
+	case isScroller(node):
+		return opts.FullScrollContent || scrolledIntoView(node, child)
+	case node.Props.Overflow == OverflowHidden:
+		return scrolledIntoView(node, child)
+	}
+	return true
+}
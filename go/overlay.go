@@ -0,0 +1,171 @@
+package viewer
+
+import (
+	"image"
+	"image/color"
+	"sort"
+
+	"golang.org/x/image/draw"
+)
+
+// Overlays. A node with a zIndex is an overlay — a modal, a dropdown
+// menu, a toast — taken out of its parent's flow and laid out over the
+// whole screen, like the root, so it places its children with its own
+// justify, align, and padding. Fixed position nodes are overlays too,
+// at zIndex 0 unless they set one, placed by their offsets instead.
+// Renderers draw overlays after the rest of the tree, lowest zIndex
+// first and in tree order among equals, starting each at the top of the
+// screen whatever scrolls around it. An overlay only takes the pointer
+// where one of its descendants is, so the rest of the screen stays
+// usable under a toast; a fixed node takes it anywhere on itself.
+//
+// A modal overlay takes everything while it is open: the screen under it
+// is dimmed, the pointer reaches nothing beneath it, tab cycles only
+// through its nodes, and keys with focus left outside it go to the
+// source aimed at the modal itself.
+
+// dimAlpha is how strongly the rasterizer darkens the screen under a
+// modal overlay.
+const dimAlpha = 0x80
+
+// isOverlay reports whether a node is drawn as an overlay: it has a
+// zIndex or a fixed position.
+func isOverlay(node *RenderNode) bool {
+	return node.Props.ZIndex != nil || isFixed(node)
+}
+
+// zIndex returns the level an overlay is drawn at.
+func zIndex(node *RenderNode) int {
+	if node.Props.ZIndex == nil {
+		return 0
+	}
+	return *node.Props.ZIndex
+}
+
+// isModal reports whether a node is a modal overlay.
+func isModal(node *RenderNode) bool {
+	return isOverlay(node) && node.Props.Modal != nil && *node.Props.Modal
+}
+
+// flowChildren returns a container's children that are laid out in its
+// flow, leaving out hidden children, overlays, and absolutely positioned
+// children.
+func flowChildren(node *RenderNode) []*RenderNode {
+	for i, child := range node.Children {
+		if !outOfFlow(child) {
+			continue
+		}
+		flow := append([]*RenderNode{}, node.Children[:i]...)
+		for _, c := range node.Children[i+1:] {
+			if !outOfFlow(c) {
+				flow = append(flow, c)
+			}
+		}
+		return flow
+	}
+	return node.Children
+}
+
+// overlays returns the tree's overlays in drawing order, bottom to top,
+// leaving out those in hidden subtrees.
+func overlays(root *RenderNode) []*RenderNode {
+	var layers []*RenderNode
+	var walk func(node *RenderNode)
+	walk = func(node *RenderNode) {
+		if isHidden(node) {
+			return
+		}
+		if node != root && isOverlay(node) {
+			layers = append(layers, node)
+		}
+		for _, child := range node.Children {
+			walk(child)
+		}
+	}
+	if root != nil {
+		walk(root)
+	}
+	sort.SliceStable(layers, func(i, j int) bool { return zIndex(layers[i]) < zIndex(layers[j]) })
+	return layers
+}
+
+// topModal returns the topmost modal overlay, or nil if none is open.
+func topModal(root *RenderNode) *RenderNode {
+	layers := overlays(root)
+	for i := len(layers) - 1; i >= 0; i-- {
+		if isModal(layers[i]) {
+			return layers[i]
+		}
+	}
+	return nil
+}
+
+// inside reports whether a node is within the subtree at root.
+func inside(root *RenderNode, nodeID int) bool {
+	return FindByID(root, nodeID) != nil
+}
+
+// layoutTree lays out the tree and then its overlays within bounds.
+// A hidden root leaves nothing laid out.
+func (l *layoutEngine) layoutTree(root *RenderNode, bounds ComputedLayout) {
+	if isHidden(root) {
+		return
+	}
+	l.viewport = bounds
+	l.layoutNode(root, l.within(root, bounds))
+	for _, o := range overlays(root) {
+		if isFixed(o) {
+			l.layoutPositioned(o, bounds)
+		} else {
+			l.layoutNode(o, l.within(o, bounds))
+		}
+	}
+}
+
+// hitLayers returns the nodes under (x, y) in the topmost layer there,
+// from the layer's root down: the highest overlay with a descendant under
+// the point, a modal or fixed overlay anywhere on it, or else the tree.
+func hitLayers(root *RenderNode, in *inputLayout, x, y float64) []hitNode {
+	layers := overlays(root)
+	for i := len(layers) - 1; i >= 0; i-- {
+		path := hitPath(layers[i], in, x, y)
+		if len(path) > 1 || len(path) > 0 && (isModal(layers[i]) || isFixed(layers[i])) {
+			return path
+		}
+	}
+	return hitPath(root, in, x, y)
+}
+
+// captured reports whether an open modal overlay keeps input from a node.
+// Must be called with the mutex held.
+func (v *Viewer) captured(nodeID int) bool {
+	m := topModal(v.tree.Root)
+	return m != nil && !inside(m, nodeID)
+}
+
+// drawOverlays draws the tree's overlays over the grid, dimming the
+// cells under each modal.
+func (d *gridDrawer) drawOverlays(root *RenderNode) {
+	clip := rect{0, 0, d.grid.Width, d.grid.Height}
+	for _, o := range overlays(root) {
+		if isModal(o) {
+			for y := range d.grid.Cells {
+				for x := range d.grid.Cells[y] {
+					d.grid.Cells[y][x].Style.Faint = true
+				}
+			}
+		}
+		d.draw(o, CellStyle{}, DirLTR, clip, 0)
+	}
+}
+
+// drawOverlays paints overlays over the image within clip, darkening it
+// under each modal.
+func (d *rasterDrawer) drawOverlays(layers []*RenderNode, clip image.Rectangle) {
+	for _, o := range layers {
+		if isModal(o) {
+			draw.DrawMask(d.img, clip, image.NewUniform(color.Black), image.Point{}, image.NewUniform(color.Alpha{dimAlpha}), image.Point{}, draw.Over)
+		}
+		d.draw(o, clip)
+	}
+}
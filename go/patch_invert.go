@@ -0,0 +1,132 @@
+package viewer
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Patch inversion. InvertPatch computes, before an op is applied, the op
+// that undoes it: the primitive under undo, time travel, and rolling back
+// optimistic updates. Props an op sets or unsets are set back to their
+// old values or unset again; an inserted child is removed, a removed one
+// reinserted whole, and moves are moved back. Changes to a node's props
+// and to its children commute, so one op holds both inverses; an op that
+// changes the children more than one way is undone by setting the old
+// children back, and a removed node is undone by inserting it into its
+// parent again.
+
+// InvertPatch returns the op that undoes op on tree, which must be in
+// the state op will be applied to. It fails if op's target does not
+// exist, or if op removes the root, which no op can restore.
+func InvertPatch(tree *RenderTree, op PatchOp) (PatchOp, error) {
+	node := tree.NodeIndex[op.Target]
+	if node == nil {
+		return PatchOp{}, fmt.Errorf("%w: no node %d", ErrInvalidPatch, op.Target)
+	}
+	switch {
+	case op.Remove:
+		parent := findParent(tree.Root, op.Target)
+		if parent == nil {
+			return PatchOp{}, fmt.Errorf("%w: cannot invert removing the root", ErrInvalidPatch)
+		}
+		i := 0
+		for parent.Children[i] != node {
+			i++
+		}
+		return PatchOp{Target: parent.ID, ChildrenInsert: &ChildrenInsert{Index: i, Node: snapshot(node)}}, nil
+	case op.Replace != nil:
+		return PatchOp{Target: op.Replace.ID, Replace: snapshot(node)}, nil
+	}
+
+	inv := PatchOp{Target: op.Target}
+	if !invertProps(&inv, node, op) {
+		return PatchOp{Target: op.Target, Replace: snapshot(node)}, nil
+	}
+	parts := 0
+	for _, set := range []bool{
+		op.ChildrenSet != nil, op.ChildrenInsert != nil, op.ChildrenRemove != nil,
+		op.ChildrenMove != nil, op.ChildrenRemoveRange != nil, op.ChildrenMoveRange != nil,
+	} {
+		if set {
+			parts++
+		}
+	}
+	n := len(node.Children)
+	switch {
+	case parts > 1, op.ChildrenSet != nil, op.ChildrenRemoveRange != nil:
+		inv.ChildrenSet = &ChildrenSet{Nodes: snapshotChildren(node)}
+	case op.ChildrenInsert != nil:
+		i, ok := insertIndex(node, op.ChildrenInsert)
+		if !ok {
+			return PatchOp{}, fmt.Errorf("%w: insert next to a node that is not a child", ErrInvalidPatch)
+		}
+		inv.ChildrenRemove = &ChildrenRemove{Index: i}
+	case op.ChildrenRemove != nil:
+		if i := op.ChildrenRemove.Index; i >= 0 && i < n {
+			inv.ChildrenInsert = &ChildrenInsert{Index: i, Node: snapshot(node.Children[i])}
+		}
+	case op.ChildrenMove != nil:
+		if m := op.ChildrenMove; m.From >= 0 && m.From < n && m.To >= 0 && m.To < n {
+			inv.ChildrenMove = &ChildrenMove{From: m.To, To: m.From}
+		}
+	case op.ChildrenMoveRange != nil:
+		if r := op.ChildrenMoveRange; r.Count > 0 && r.From >= 0 && r.From+r.Count <= n && r.To >= 0 && r.To+r.Count <= n {
+			inv.ChildrenMoveRange = &ChildrenMoveRange{From: r.To, Count: r.Count, To: r.From}
+		}
+	}
+	return inv, nil
+}
+
+// invertProps adds to inv the Set and Unset that restore the props op
+// sets or unsets on node. Returns false if one of them cannot be set back
+// by a patch.
+func invertProps(inv *PatchOp, node *RenderNode, op PatchOp) bool {
+	keys := append([]string{}, op.Unset...)
+	for k := range op.Set {
+		keys = append(keys, k)
+	}
+	current := propValues(node.Props)
+	seen := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		old, ok := current[k]
+		switch {
+		case !ok:
+			inv.Unset = append(inv.Unset, k)
+		case unsettableProps[k]:
+			return false
+		default:
+			if inv.Set == nil {
+				inv.Set = make(map[string]interface{})
+			}
+			inv.Set[k] = old
+		}
+	}
+	sort.Strings(inv.Unset)
+	return true
+}
+
+// snapshot copies a node's subtree as it is now, so later patches to the
+// node do not change the copy.
+func snapshot(node *RenderNode) *VNode {
+	v := &VNode{ID: node.ID, Type: node.Type, Props: node.Props, Children: snapshotChildren(node)}
+	if node.Props.Extra != nil {
+		v.Props.Extra = make(map[string]interface{}, len(node.Props.Extra))
+		for k, x := range node.Props.Extra {
+			v.Props.Extra[k] = x
+		}
+	}
+	return v
+}
+
+// snapshotChildren snapshots each of a node's children.
+func snapshotChildren(node *RenderNode) []*VNode {
+	children := make([]*VNode, 0, len(node.Children))
+	for _, c := range node.Children {
+		children = append(children, snapshot(c))
+	}
+	return children
+}
@@ -0,0 +1,164 @@
+package viewer
+
+import "reflect"
+
+// Patch batch optimization. OptimizePatches shortens a batch without
+// changing what it does, for sources that patch naively at high rates:
+//
+//   - A node inserted and then removed in the same batch is never
+//     inserted, and ops on it in between are dropped, as long as nothing
+//     in between changes the children of the node it was inserted into,
+//     whose indexes the insert shifts.
+//   - Ops that only set or unset props on a node are merged into the
+//     first of them, unless something between changes the node's
+//     children, replaces or removes it, or inserts a node with its ID.
+//   - A child moved and then moved on again is moved once, and not at all
+//     if it ends where it started, unless a node is removed or replaced
+//     in between.
+//
+// Ops are otherwise kept in order, so a batch that applies cleanly still
+// does, and leaves the same tree.
+
+// OptimizePatches returns ops with redundant operations merged or
+// dropped. ops is not modified.
+func OptimizePatches(ops []PatchOp) []PatchOp {
+	keep := cancelInserts(ops)
+	out := make([]PatchOp, 0, len(ops))
+	props := make(map[int]int) // target -> index in out of an op later props merge into
+	moves := make(map[int]int) // target -> index in out of a move later moves chain onto
+	for i, op := range ops {
+		if !keep[i] {
+			continue
+		}
+		for _, id := range insertedIDs(op) {
+			delete(props, id)
+		}
+		if op.Remove || op.Replace != nil {
+			// Without a tree there is no telling whose child this
+			// was, and so whose indexes it shifts.
+			clear(moves)
+		}
+		switch {
+		case isPropsOnly(op) && (op.Set != nil || op.Unset != nil):
+			delete(moves, op.Target)
+			if j, ok := props[op.Target]; ok {
+				out[j] = mergeProps(out[j], op)
+				continue
+			}
+			props[op.Target] = len(out)
+			if op.Set != nil {
+				op.Set = copySet(op.Set)
+			}
+			op.Unset = append([]string(nil), op.Unset...)
+		case isMoveOnly(op):
+			delete(props, op.Target)
+			if j, ok := moves[op.Target]; ok && out[j].ChildrenMove != nil && out[j].ChildrenMove.To == op.ChildrenMove.From {
+				out[j].ChildrenMove = &ChildrenMove{From: out[j].ChildrenMove.From, To: op.ChildrenMove.To}
+				continue
+			}
+			moves[op.Target] = len(out)
+		default:
+			delete(props, op.Target)
+			delete(moves, op.Target)
+		}
+		out = append(out, op)
+	}
+
+	// Drop chains that moved children back where they were.
+	n := 0
+	for _, op := range out {
+		if isMoveOnly(op) && op.ChildrenMove.From == op.ChildrenMove.To {
+			continue
+		}
+		out[n] = op
+		n++
+	}
+	return out[:n]
+}
+
+// cancelInserts reports which ops to keep after dropping nodes inserted
+// and then removed, along with the ops on them in between.
+func cancelInserts(ops []PatchOp) []bool {
+	keep := make([]bool, len(ops))
+	for i := range keep {
+		keep[i] = true
+	}
+	for i, op := range ops {
+		ins := op.ChildrenInsert
+		if !keep[i] || ins == nil || ins.Node == nil || !reflect.DeepEqual(op, PatchOp{Target: op.Target, ChildrenInsert: ins}) {
+			continue
+		}
+		subtree := make(map[int]bool)
+		walkVNode(ins.Node, func(n *VNode) { subtree[n.ID] = true })
+		var within []int
+		for j := i + 1; j < len(ops); j++ {
+			next := ops[j]
+			if next.Target == op.Target {
+				break
+			}
+			if next.Remove && next.Target == ins.Node.ID {
+				keep[i], keep[j] = false, false
+				for _, k := range within {
+					keep[k] = false
+				}
+				break
+			}
+			if next.Remove || next.Replace != nil {
+				// It may remove a sibling, shifting indexes.
+				break
+			}
+			if subtree[next.Target] {
+				within = append(within, j)
+				for _, id := range insertedIDs(next) {
+					subtree[id] = true
+				}
+			}
+		}
+	}
+	return keep
+}
+
+// insertedIDs returns the IDs of the nodes an op inserts.
+func insertedIDs(op PatchOp) []int {
+	var roots []*VNode
+	if op.Replace != nil {
+		roots = append(roots, op.Replace)
+	}
+	if op.ChildrenInsert != nil {
+		roots = append(roots, op.ChildrenInsert.Node)
+	}
+	if op.ChildrenSet != nil {
+		roots = append(roots, op.ChildrenSet.Nodes...)
+	}
+	var ids []int
+	for _, r := range roots {
+		if r != nil {
+			walkVNode(r, func(n *VNode) { ids = append(ids, n.ID) })
+		}
+	}
+	return ids
+}
+
+// isMoveOnly reports whether op only moves one child.
+func isMoveOnly(op PatchOp) bool {
+	return op.ChildrenMove != nil && reflect.DeepEqual(op, PatchOp{Target: op.Target, ChildrenMove: op.ChildrenMove})
+}
+
+// mergeProps returns the props-only op doing first and then next. An op
+// unsets before it sets, so next's Unset removes keys from first's Set,
+// and next's Set wins over both Unsets.
+func mergeProps(first, next PatchOp) PatchOp {
+	for _, k := range next.Unset {
+		delete(first.Set, k)
+		if !containsString(first.Unset, k) {
+			first.Unset = append(first.Unset, k)
+		}
+	}
+	for k, v := range next.Set {
+		if first.Set == nil {
+			first.Set = make(map[string]interface{})
+		}
+		first.Set[k] = v
+	}
+	return first
+}
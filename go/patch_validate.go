@@ -0,0 +1,189 @@
+package viewer
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// Patch validation. ValidatePatches checks a batch against a tree without
+// changing it, so a source can verify a batch before committing to it and
+// a proxy before forwarding it. Each op is checked against the tree as the
+// ops before it leave it, on a shadow copy, and each part of an op in the
+// order ApplyPatch applies them: the target must exist, indexes must be
+// in range, siblings named by an insert must be children of the target,
+// inserted nodes must not reuse IDs already in the tree, and every Set
+// value must be of a type its prop takes.
+
+// ErrInvalidPatch is returned by ValidatePatches for an operation that
+// would fail, or do nothing it asks, if applied.
+var ErrInvalidPatch = errors.New("invalid patch operation")
+
+// ValidatePatches reports the first op in ops that would not apply
+// cleanly to tree, or nil if they all would. tree is not modified.
+func ValidatePatches(tree *RenderTree, ops []PatchOp) error {
+	shadow := shadowTree(tree)
+	for i, op := range ops {
+		for _, part := range opParts(op) {
+			if err := validatePatch(shadow, part); err != nil {
+				return fmt.Errorf("op %d (target %d): %w", i, op.Target, err)
+			}
+			ApplyPatch(shadow, part)
+		}
+	}
+	return nil
+}
+
+// opParts splits an op into ops of one change each, in the order
+// ApplyPatch applies them.
+func opParts(op PatchOp) []PatchOp {
+	if op.Remove || op.Replace != nil {
+		return []PatchOp{op}
+	}
+	parts := []PatchOp{{Target: op.Target, Unset: op.Unset, Set: op.Set, Transition: op.Transition}}
+	for _, p := range []PatchOp{
+		{ChildrenSet: op.ChildrenSet},
+		{ChildrenInsert: op.ChildrenInsert},
+		{ChildrenRemove: op.ChildrenRemove},
+		{ChildrenMove: op.ChildrenMove},
+		{ChildrenRemoveRange: op.ChildrenRemoveRange},
+		{ChildrenMoveRange: op.ChildrenMoveRange},
+	} {
+		if !reflect.ValueOf(p).IsZero() {
+			p.Target = op.Target
+			parts = append(parts, p)
+		}
+	}
+	return parts
+}
+
+// validatePatch checks one part of an op against tree.
+func validatePatch(tree *RenderTree, op PatchOp) error {
+	node := tree.NodeIndex[op.Target]
+	if node == nil {
+		return fmt.Errorf("%w: no node %d", ErrInvalidPatch, op.Target)
+	}
+	n := len(node.Children)
+	switch {
+	case op.Remove:
+		return nil
+	case op.Replace != nil:
+		return unusedIDs(tree, op.Replace, node)
+	case op.ChildrenSet != nil:
+		for _, c := range op.ChildrenSet.Nodes {
+			if err := unusedIDs(tree, c, node.Children...); err != nil {
+				return err
+			}
+		}
+		return nil
+	case op.ChildrenInsert != nil:
+		ins := op.ChildrenInsert
+		if _, ok := insertIndex(node, ins); !ok {
+			return fmt.Errorf("%w: insert next to a node that is not a child", ErrInvalidPatch)
+		}
+		if ins.Before == nil && ins.After == nil {
+			if err := checkIndex("insert index", ins.Index, n+1); err != nil {
+				return err
+			}
+		}
+		return unusedIDs(tree, ins.Node)
+	case op.ChildrenRemove != nil:
+		return checkIndex("remove index", op.ChildrenRemove.Index, n)
+	case op.ChildrenMove != nil:
+		if err := checkIndex("move from", op.ChildrenMove.From, n); err != nil {
+			return err
+		}
+		return checkIndex("move to", op.ChildrenMove.To, n)
+	case op.ChildrenRemoveRange != nil:
+		r := op.ChildrenRemoveRange
+		return checkRange("remove", r.Index, r.Count, n)
+	case op.ChildrenMoveRange != nil:
+		r := op.ChildrenMoveRange
+		if err := checkRange("move from", r.From, r.Count, n); err != nil {
+			return err
+		}
+		return checkRange("move to", r.To, r.Count, n)
+	}
+	for k, v := range op.Set {
+		if err := checkPropValue(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkIndex checks that an index is in [0, n).
+func checkIndex(what string, i, n int) error {
+	if i < 0 || i >= n {
+		return fmt.Errorf("%w: %s %d out of range [0, %d)", ErrInvalidPatch, what, i, n)
+	}
+	return nil
+}
+
+// checkRange checks that count children from i are within n.
+func checkRange(what string, i, count, n int) error {
+	if count <= 0 || i < 0 || i+count > n {
+		return fmt.Errorf("%w: %s %d children from %d out of %d", ErrInvalidPatch, what, count, i, n)
+	}
+	return nil
+}
+
+// unusedIDs checks that a new subtree is present and that none of its IDs
+// is in the tree, outside the subtrees at replaced, which it replaces.
+func unusedIDs(tree *RenderTree, node *VNode, replaced ...*RenderNode) error {
+	if node == nil {
+		return fmt.Errorf("%w: missing node", ErrInvalidPatch)
+	}
+	var err error
+	walkVNode(node, func(n *VNode) {
+		if err != nil {
+			return
+		}
+		if tree.NodeIndex[n.ID] == nil {
+			return
+		}
+		for _, r := range replaced {
+			if inside(r, n.ID) {
+				return
+			}
+		}
+		err = fmt.Errorf("%w: node %d is already in the tree", ErrInvalidPatch, n.ID)
+	})
+	return err
+}
+
+// checkPropValue checks that a Set value is one its prop takes, by
+// setting it on a blank node: a value applyPropsSet ignores leaves the
+// prop unset. Clearing values — "" for strings, nil for untyped props —
+// and props without fields are always accepted.
+func checkPropValue(key string, v interface{}) error {
+	f, ok := propFields[key]
+	if !ok {
+		return nil
+	}
+	var probe RenderNode
+	applyPropsSet(&probe, map[string]interface{}{key: v})
+	field := reflect.ValueOf(probe.Props).FieldByIndex(f.Index)
+	switch {
+	case field.Kind() == reflect.String && v == "", field.Kind() == reflect.Interface && v == nil:
+		return nil
+	case field.Kind() == reflect.Slice && isEmptySlice(v):
+		// An empty list clears the prop.
+		return nil
+	case field.IsZero():
+		return fmt.Errorf("%w: %s cannot be set to %T %v", ErrInvalidPatch, key, v, v)
+	}
+	if sizeProps[key] && !hasSize(v) {
+		return fmt.Errorf("%w: invalid %s %v", ErrInvalidPatch, key, v)
+	}
+	return nil
+}
+
+// isEmptySlice reports whether v is a slice of length zero.
+func isEmptySlice(v interface{}) bool {
+	rv := reflect.ValueOf(v)
+	return rv.Kind() == reflect.Slice && rv.Len() == 0
+}
+
+// sizeProps are the props that take sizes.
+var sizeProps = map[string]bool{"width": true, "height": true, "top": true, "right": true, "bottom": true, "left": true}
@@ -0,0 +1,363 @@
+package viewer
+
+import "math"
+
+// Pointer input. Hosts report mouse and touch positions in input units:
+// character cells when the render target draws to a terminal, pixels
+// otherwise. Hit testing follows what the render target draws — scrolled
+// content is offset by every enclosing scroll container and clipped to
+// its ancestors — so the node found is the one under the pointer.
+//
+// The viewer handles the scrollbars it draws itself: pressing on a thumb
+// drags it, and pressing elsewhere on a track jumps the thumb there and
+// drags from the middle. A wheel scrolls the scroll containers under the
+// pointer from the innermost out: each takes as much of the distance as
+// it has room for, so a nested container scrolls until it reaches its
+// end and only then does the one around it move.
+//
+// Pressing on a clickable node or form control makes it the tree's
+// active node, which renderers show at once, and releasing over it sends
+// the source a click — so the press is visible before the source has
+// answered. Controls and buttons also take focus on the press, and
+// controls activate on the release, unless the press became a drag (see
+// drag.go). Presses in
+// a disabled subtree do nothing. Pressing on an input focuses it and puts
+// the cursor under the pointer, and dragging selects text; pressing on a
+// table selects the row there. A press on a node that captures the
+// pointer sends it the moves and release that follow, wherever they are
+// (see capture.go). Other pointer input is left to the host. Touches
+// also make gestures (see gesture.go).
+
+// PointerAction is what a pointer did.
+type PointerAction int
+
+const (
+	PointerDown  PointerAction = iota // button pressed or touch started
+	PointerMove                       // moved, pressed or not
+	PointerUp                         // button released or touch ended
+	PointerWheel                      // wheel turned by DeltaY
+)
+
+// PointerEvent is mouse or touch input at a position in input units.
+type PointerEvent struct {
+	X, Y   int
+	Action PointerAction
+	Button int
+	DeltaY float64 // wheel distance in input units; positive scrolls down
+
+	// Touch marks a finger on a touch screen, and ID tells fingers that
+	// are down at once apart (see gesture.go).
+	Touch bool
+	ID    int
+}
+
+// inputLayout is the tree laid out in input units, cached until the tree
+// or the output size changes.
+type inputLayout struct {
+	gen           uint64
+	width, height int
+	opts          LayoutOptions
+	layouts       map[int]*ComputedLayout
+	transforms    bool // the target draws transform props
+}
+
+// scrollDrag is a scrollbar thumb being dragged.
+type scrollDrag struct {
+	node int
+	grab float64 // pointer offset from the top of the thumb
+}
+
+// inputLayout returns the layout input coordinates and scroll offsets
+// refer to: the terminal's cells when the render target draws to one,
+// the display's pixels otherwise.
+// Must be called with the mutex held.
+func (v *Viewer) inputLayout() *inputLayout {
+	opts := PixelLayoutOptions()
+	width, height := v.displaySize()
+	transforms := true
+	if ts := v.targets[0]; ts.term != nil {
+		opts = CellLayoutOptions()
+		width, height = ts.term.Size()
+		transforms = false
+	}
+	if c := v.input; c != nil && c.gen == v.generation && c.width == width && c.height == height && c.opts == opts {
+		return c
+	}
+	l := &layoutEngine{opts: opts, layouts: make(map[int]*ComputedLayout), tree: v.tree}
+	if v.tree.Root != nil {
+		l.layoutTree(v.tree.Root, ComputedLayout{Width: float64(width), Height: float64(height)})
+	}
+	v.input = &inputLayout{gen: v.generation, width: width, height: height, opts: opts, layouts: l.layouts, transforms: transforms}
+	return v.input
+}
+
+// hitNode is a node under the pointer, the scroll offset applied to its
+// layout, and the pointer in its coordinates, undoing transforms.
+type hitNode struct {
+	node *RenderNode
+	dy   float64
+	x, y float64
+}
+
+// hitPath returns the nodes under (x, y) from the root down to the
+// topmost one, or nil if the point is outside the root.
+func hitPath(root *RenderNode, in *inputLayout, x, y float64) []hitNode {
+	var path []hitNode
+	node, dy := root, 0.0
+	for node != nil {
+		if !reaches(node, in, x, y, dy) {
+			break
+		}
+		x, y = in.local(node, x, y, dy)
+		path = append(path, hitNode{node, dy, x, y})
+		if isScroller(node) && node.Props.ScrollTop != nil {
+			dy += float64(*node.Props.ScrollTop)
+		}
+		// Later children draw over earlier ones.
+		children := paintOrder(node)
+		node = nil
+		for i := len(children) - 1; i >= 0; i-- {
+			if reaches(children[i], in, x, y, dy) {
+				node = children[i]
+				break
+			}
+		}
+	}
+	return path
+}
+
+// HitTest returns the topmost node at (x, y) in input units.
+func (v *Viewer) HitTest(x, y int) (nodeID int, ok bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	in := v.inputLayout()
+	path := hitLayers(v.tree.Root, in, float64(x), float64(y))
+	if len(path) == 0 {
+		return 0, false
+	}
+	return path[len(path)-1].node.ID, true
+}
+
+// Pointer handles pointer input. Returns whether the viewer consumed the
+// event — it pressed, dragged, or released a scrollbar, a clickable node,
+// or an input, or the wheel scrolled something — so the host should not
+// act on it too.
+func (v *Viewer) Pointer(ev PointerEvent) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	in := v.inputLayout()
+	if ev.Touch && v.touch(in, ev) {
+		return true
+	}
+	x, y := float64(ev.X), float64(ev.Y)
+	switch ev.Action {
+	case PointerDown:
+		v.drag = nil
+		v.endDrag()
+		v.capture = nil
+		v.selecting = false
+		v.hoverTooltip(nil)
+		if v.tree.Active != nil {
+			v.setActive(nil)
+		}
+		path := hitLayers(v.tree.Root, in, x, y)
+		for i := len(path) - 1; i >= 0; i-- {
+			h := path[i]
+			if !isScroller(h.node) {
+				continue
+			}
+			track, thumb, ok := scrollbar(h.node, in.layouts, in.opts)
+			if !ok || h.x < track.X || h.x >= track.X+track.Width || h.y < track.Y-h.dy || h.y >= track.Y-h.dy+track.Height {
+				continue
+			}
+			delete(v.scrolls, h.node.ID)
+			grab := h.y - (thumb.Y - h.dy)
+			if grab < 0 || grab >= thumb.Height {
+				grab = thumb.Height / 2
+			}
+			v.drag = &scrollDrag{node: h.node.ID, grab: grab}
+			v.dragThumb(in, y)
+			return true
+		}
+		// Nothing inside a disabled node can be pressed.
+		for i, h := range path {
+			if isDisabled(h.node) {
+				path = path[:i]
+				break
+			}
+		}
+		if len(path) > 0 && path[len(path)-1].node.Type == NodeInput {
+			h := path[len(path)-1]
+			v.pressInput(in, h.node, h.x, h.y+h.dy)
+			return true
+		}
+		if len(path) > 0 && path[len(path)-1].node.Type == NodeTable {
+			h := path[len(path)-1]
+			v.pressTable(in, h.node, h.y+h.dy)
+			return true
+		}
+		held := v.pressCapture(in, path, ev)
+		if !held {
+			held = v.pressDraggable(path, ev)
+		}
+		for i := len(path) - 1; i >= 0; i-- {
+			if node := path[i].node; pressable(node) {
+				v.setActive(&node.ID)
+				if isWidget(node) {
+					v.setFocus(&node.ID)
+				}
+				return true
+			}
+		}
+		return held
+
+	case PointerMove:
+		if v.capturing() {
+			v.emitCapture(in, "pointer_move", ev)
+			return true
+		}
+		v.hoverTooltip(tooltipNode(hitLayers(v.tree.Root, in, x, y)))
+		if v.selecting {
+			v.dragSelection(in, x, y)
+			return true
+		}
+		if v.dragDrop != nil {
+			return v.moveDrag(in, ev)
+		}
+		if v.drag == nil {
+			return false
+		}
+		v.dragThumb(in, y)
+		return true
+
+	case PointerUp:
+		if v.selecting {
+			v.dragSelection(in, x, y)
+			v.selecting = false
+			return true
+		}
+		if v.drag != nil {
+			v.dragThumb(in, y)
+			v.drag = nil
+			return true
+		}
+		if v.dragDrop != nil && v.releaseDrag(in, ev) {
+			return true
+		}
+		captured := v.capturing()
+		if captured {
+			v.emitCapture(in, "pointer_up", ev)
+			v.capture = nil
+		}
+		if v.tree.Active == nil {
+			return captured
+		}
+		id := *v.tree.Active
+		v.setActive(nil)
+		for _, h := range hitLayers(v.tree.Root, in, x, y) {
+			if h.node.ID == id {
+				event := InputEvent{Target: &id, Kind: "click", X: &ev.X, Y: &ev.Y, Button: &ev.Button}
+				v.emit(ProtocolMessage{Type: MsgInput, Event: &event})
+				if isControl(h.node) {
+					v.activate(h.node)
+				}
+				break
+			}
+		}
+		return true
+
+	case PointerWheel:
+		_, moved := v.wheel(in, x, y, ev.DeltaY)
+		return moved
+	}
+	return false
+}
+
+// wheel scrolls the scroll nodes under (x, y) by dy, innermost first.
+// Returns the distance none had room for, and whether any moved.
+// Must be called with the mutex held.
+func (v *Viewer) wheel(in *inputLayout, x, y, dy float64) (rest float64, moved bool) {
+	path := hitLayers(v.tree.Root, in, x, y)
+	rest = dy
+	for i := len(path) - 1; i >= 0 && rest != 0; i-- {
+		node := path[i].node
+		if !isScroller(node) {
+			continue
+		}
+		_, maxTop, _ := scrollExtent(node, in.layouts, in.opts)
+		pos := v.scrollTarget(node)
+		if take := math.Min(math.Max(pos+rest, 0), maxTop) - pos; take != 0 {
+			v.scrollBy(node, take)
+			rest -= take
+			moved = true
+		}
+	}
+	return rest, moved
+}
+
+// pressable reports whether a node is pressed and clicked by the pointer.
+func pressable(node *RenderNode) bool {
+	return node.Props.Interactive == "clickable" || isWidget(node)
+}
+
+// setActive changes the pressed node, or clears it if id is nil.
+// Must be called with the mutex held.
+func (v *Viewer) setActive(id *int) {
+	if id != nil {
+		n := *id
+		id = &n
+	}
+	v.tree.Active = id
+	v.markDirty()
+	v.signalChanged()
+}
+
+// dragThumb scrolls the dragged scroll node so its thumb's top is at the
+// pointer less the grab offset.
+// Must be called with the mutex held.
+func (v *Viewer) dragThumb(in *inputLayout, y float64) {
+	node := v.tree.NodeIndex[v.drag.node]
+	if node == nil {
+		v.drag = nil
+		return
+	}
+	track, thumb, ok := scrollbar(node, in.layouts, in.opts)
+	if !ok || track.Height <= thumb.Height {
+		return
+	}
+	// The track moves with any enclosing scroll containers, so find
+	// where it is drawn.
+	dy := scrollOffset(v.tree.Root, node.ID)
+	frac := (y - v.drag.grab - (track.Y - dy)) / (track.Height - thumb.Height)
+	_, maxTop, _ := scrollExtent(node, in.layouts, in.opts)
+	v.scrollTo(node.ID, math.Min(math.Max(frac, 0), 1)*maxTop)
+}
+
+// scrollOffset returns how far a node is scrolled up by the scroll
+// containers around it, up to the overlay it is in.
+func scrollOffset(root *RenderNode, nodeID int) float64 {
+	if node := FindByID(root, nodeID); node != nil && isOverlay(node) {
+		return 0
+	}
+	dy := 0.0
+	for _, a := range ancestors(root, nodeID) {
+		if isOverlay(a) {
+			dy = 0
+		}
+		if isScroller(a) && a.Props.ScrollTop != nil {
+			dy += float64(*a.Props.ScrollTop)
+		}
+	}
+	return dy
+}
+
+// ancestors returns the ancestors of a node, root first.
+func ancestors(root *RenderNode, nodeID int) []*RenderNode {
+	var path []*RenderNode
+	for p := findParent(root, nodeID); p != nil; p = findParent(root, p.ID) {
+		path = append([]*RenderNode{p}, path...)
+	}
+	return path
+}
@@ -0,0 +1,91 @@
+package viewer
+
+import "math"
+
+// Positioning. A node with position absolute is taken out of its parent's
+// flow and placed within the parent's box inside its border: its top,
+// right, bottom, and left props offset its edges inward from the box's
+// edges. With neither offset on an axis it sits at the start; with both
+// and no size on that axis it stretches between them; otherwise it takes
+// its width or height, or else its content's size, from the offset it
+// has. Absolute children scroll with their parent and are drawn over its
+// other children, and take no space in it.
+//
+// Position fixed places a node the same way against the screen. A fixed
+// node is an overlay (see overlay.go), so it is drawn over the tree and
+// stays put however its ancestors scroll, as for pinned status bars and
+// floating buttons.
+
+// Positions.
+const (
+	PositionAbsolute = "absolute"
+	PositionFixed    = "fixed"
+)
+
+// isAbsolute reports whether a node is positioned within its parent. One
+// with a zIndex is an overlay instead.
+func isAbsolute(node *RenderNode) bool {
+	return node.Props.Position == PositionAbsolute && node.Props.ZIndex == nil
+}
+
+// isFixed reports whether a node is positioned on the screen.
+func isFixed(node *RenderNode) bool {
+	return node.Props.Position == PositionFixed
+}
+
+// outOfFlow reports whether a node takes no space in its parent's flow:
+// it is hidden, an overlay, or absolutely positioned.
+func outOfFlow(node *RenderNode) bool {
+	return isHidden(node) || isOverlay(node) || isAbsolute(node)
+}
+
+// layoutAbsolute lays out a container's absolutely positioned children
+// within its border.
+func (l *layoutEngine) layoutAbsolute(parent *RenderNode, parentLayout ComputedLayout) {
+	bw := l.borderWidth(parent)
+	box := ComputedLayout{
+		X:      parentLayout.X + bw,
+		Y:      parentLayout.Y + bw,
+		Width:  math.Max(0, parentLayout.Width-2*bw),
+		Height: math.Max(0, parentLayout.Height-2*bw),
+	}
+	for _, child := range parent.Children {
+		if isAbsolute(child) && !isHidden(child) {
+			l.layoutPositioned(child, box)
+		}
+	}
+}
+
+// layoutPositioned lays out an absolutely or fixed positioned node within
+// box by its offsets.
+func (l *layoutEngine) layoutPositioned(node *RenderNode, box ComputedLayout) {
+	p := node.Props
+	m := l.resolveSpacing(p.Margin)
+	x, w := l.place(node, p.Left, p.Right, p.Width, box.X, box.Width, m.left+m.right, true, box)
+	y, h := l.place(node, p.Top, p.Bottom, p.Height, box.Y, box.Height, m.top+m.bottom, false, box)
+	l.layoutNode(node, ComputedLayout{X: x, Y: y, Width: w, Height: h})
+}
+
+// place returns where a positioned node starts along one axis of box,
+// which runs from origin for extent, and its size there with margins.
+func (l *layoutEngine) place(node *RenderNode, start, end, size interface{}, origin, extent, margins float64, horizontal bool, box ComputedLayout) (float64, float64) {
+	s, hasStart := l.size(start, extent)
+	e, hasEnd := l.size(end, extent)
+	n, hasSize := l.size(size, extent)
+	switch {
+	case hasSize:
+		n += margins
+	case hasStart && hasEnd:
+		n = extent - s - e
+	default:
+		n = l.measure(node, horizontal, box.Width, box.Height) + margins
+	}
+	n = max(n, 0)
+	switch {
+	case hasStart:
+		return origin + s, n
+	case hasEnd:
+		return origin + extent - e - n, n
+	}
+	return origin, n
+}
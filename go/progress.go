@@ -0,0 +1,224 @@
+package viewer
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"time"
+
+	"golang.org/x/image/draw"
+)
+
+// Progress indicators. A progress node shows how far along a task is —
+// its progress prop out of max, which defaults to 1 — as a bar across its
+// width: full blocks with an eighth block at the edge on the cell grid, a
+// filled rectangle in the rasterizer. Text projections show it as "42%".
+// Without progress it is indeterminate, and a segment sweeps back and
+// forth across the bar instead; projections show its label, or
+// "working…". A spinner node is a one-cell indeterminate indicator
+// cycling through spinnerFrames, followed by its label.
+//
+// When a progress bar's value changes and the node references a
+// transition slot, the bar moves to the new value over the slot's
+// duration with its easing rather than jumping. Renderers draw the
+// transitions, sweeps, and spinners for the viewer clock's current time,
+// and Tick reports an animation running while any of them is in the tree.
+
+// sweepPeriod is how long an indeterminate bar's segment takes to cross
+// the bar and come back.
+const sweepPeriod = 2 * time.Second
+
+// spinnerInterval is how long a spinner shows each frame.
+const spinnerInterval = 80 * time.Millisecond
+
+// spinnerFrames are the frames of a spinner.
+var spinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// eighthBlocks are the cells for a bar's partly filled edge, by eighths.
+var eighthBlocks = []rune{' ', '▏', '▎', '▍', '▌', '▋', '▊', '▉'}
+
+// progressFraction returns how full a progress bar is, from 0 to 1, or
+// false if it is indeterminate.
+func progressFraction(node *RenderNode) (float64, bool) {
+	p := node.Props
+	if p.Progress == nil {
+		return 0, false
+	}
+	limit := 1.0
+	if p.Max != nil {
+		limit = *p.Max
+	}
+	if limit <= 0 {
+		return 0, true
+	}
+	return min(max(*p.Progress/limit, 0), 1), true
+}
+
+// progressText returns how a progress or spinner node is projected.
+func progressText(node *RenderNode) string {
+	label := ""
+	if node.Props.Label != nil {
+		label = *node.Props.Label
+	}
+	if frac, ok := progressFraction(node); ok && node.Type == NodeProgress {
+		pct := fmt.Sprintf("%d%%", int(math.Round(frac*100)))
+		if label != "" {
+			return label + " " + pct
+		}
+		return pct
+	}
+	if label != "" {
+		return label
+	}
+	return "working…"
+}
+
+// at returns the bar's fraction at now.
+func (b *ProgressTransition) at(now time.Time) float64 {
+	t := 1.0
+	if b.Duration > 0 {
+		t = min(max(float64(now.Sub(b.Start))/float64(b.Duration), 0), 1)
+	}
+	return b.From + (b.To-b.From)*ease(b.Easing, t)
+}
+
+// ease maps linear progress t through a transition slot's easing.
+func ease(easing string, t float64) float64 {
+	switch easing {
+	case "ease-in":
+		return t * t
+	case "ease-out":
+		return 1 - (1-t)*(1-t)
+	case "ease", "ease-in-out":
+		return t * t * (3 - 2*t)
+	}
+	return t
+}
+
+// trackProgress records each progress bar's value, starting a transition
+// for bars whose value changed. Returns whether a transition, sweep, or
+// spinner is running.
+// Must be called with the mutex held.
+func (v *Viewer) trackProgress() bool {
+	now := v.now()
+	running := false
+	for id := range v.tree.Progress {
+		if node := v.tree.NodeIndex[id]; node == nil || node.Type != NodeProgress || node.Props.Progress == nil {
+			delete(v.tree.Progress, id)
+		}
+	}
+	for id, node := range v.tree.NodeIndex {
+		if node.Type == NodeSpinner {
+			running = true
+			continue
+		}
+		if node.Type != NodeProgress {
+			continue
+		}
+		frac, ok := progressFraction(node)
+		if !ok {
+			running = true
+			continue
+		}
+		b := v.tree.Progress[id]
+		if b == nil {
+			// A new bar starts where it is.
+			if v.tree.Progress == nil {
+				v.tree.Progress = make(map[int]*ProgressTransition)
+			}
+			v.tree.Progress[id] = &ProgressTransition{From: frac, To: frac, Start: now}
+			continue
+		}
+		if b.To != frac {
+			next := ProgressTransition{From: b.at(now), To: frac, Start: now}
+			if node.Props.Transition != nil {
+				if slot, ok := v.slotValue(*node.Props.Transition).(TransitionSlot); ok {
+					next.Duration = time.Duration(slot.DurationMs) * time.Millisecond
+					next.Easing = slot.Easing
+				}
+			}
+			*b = next
+		}
+		if now.Sub(b.Start) < b.Duration {
+			running = true
+		}
+	}
+	return running
+}
+
+// barFraction returns how full a progress bar is drawn now.
+func (d *gridDrawer) barFraction(node *RenderNode) (float64, bool) {
+	frac, ok := progressFraction(node)
+	if b := d.progress[node.ID]; ok && b != nil {
+		frac = b.at(d.now)
+	}
+	return frac, ok
+}
+
+// sweep returns the start of an indeterminate bar's segment, from 0 to
+// 1-width, for the time now.
+func sweep(now time.Time, width float64) float64 {
+	phase := float64(now.UnixNano()%int64(sweepPeriod)) / float64(sweepPeriod)
+	return (1 - math.Abs(2*phase-1)) * (1 - width)
+}
+
+// spinnerFrame returns the spinner frame for the time now.
+func spinnerFrame(now time.Time) string {
+	return spinnerFrames[now.UnixNano()/int64(spinnerInterval)%int64(len(spinnerFrames))]
+}
+
+// spinnerText returns how a spinner is drawn now.
+func (d *gridDrawer) spinnerText(node *RenderNode) string {
+	if node.Props.Label != nil {
+		return spinnerFrame(d.now) + " " + *node.Props.Label
+	}
+	return spinnerFrame(d.now)
+}
+
+// drawBar draws a progress bar across a row of cells, visiting only those
+// in clip.
+func (d *gridDrawer) drawBar(node *RenderNode, r rect, paint CellStyle, clip rect) {
+	visible := r.intersect(clip)
+	end := visible.x + visible.w
+	track := paint
+	track.Faint = true
+	for x := visible.x; x < end; x++ {
+		d.set(x, r.y, '░', track, clip)
+	}
+	frac, ok := d.barFraction(node)
+	if !ok {
+		width := max(r.w/4, 1)
+		from := r.x + int(math.Round(sweep(d.now, float64(width)/float64(max(r.w, 1)))*float64(r.w)))
+		for x := max(from, visible.x); x < min(from+width, end); x++ {
+			d.set(x, r.y, '█', paint, clip)
+		}
+		return
+	}
+	eighths := int(math.Round(frac * float64(r.w) * 8))
+	for x := visible.x; x < min(r.x+eighths/8, end); x++ {
+		d.set(x, r.y, '█', paint, clip)
+	}
+	if rest := eighths % 8; rest > 0 {
+		d.set(r.x+eighths/8, r.y, eighthBlocks[rest], paint, clip)
+	}
+}
+
+// progressRect returns the filled part of a progress bar at r.
+func (d *rasterDrawer) progressRect(node *RenderNode, r image.Rectangle) image.Rectangle {
+	frac, ok := d.barFraction(node)
+	if !ok {
+		width := max(r.Dx()/4, 1)
+		from := r.Min.X + int(math.Round(sweep(d.now, float64(width)/float64(max(r.Dx(), 1)))*float64(r.Dx())))
+		return image.Rect(from, r.Min.Y, min(from+width, r.Max.X), r.Max.Y)
+	}
+	return image.Rect(r.Min.X, r.Min.Y, r.Min.X+int(math.Round(frac*float64(r.Dx()))), r.Max.Y)
+}
+
+// drawBar paints a progress bar: its track faintly in the text color and
+// its filled part solid.
+func (d *rasterDrawer) drawBar(n rasterNode, clip image.Rectangle) {
+	src := image.NewUniform(rgba(n.style.FG, rasterForeground))
+	draw.DrawMask(d.img, n.rect.Intersect(clip), src, image.Point{}, image.NewUniform(color.Alpha{0x30}), image.Point{}, draw.Over)
+	draw.Draw(d.img, n.bar.Intersect(clip), src, image.Point{}, draw.Over)
+}
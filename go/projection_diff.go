@@ -0,0 +1,280 @@
+package viewer
+
+import (
+	"fmt"
+	"strings"
+)
+
+// LineChangeKind says how a projection line changed.
+type LineChangeKind int
+
+const (
+	LineAdded LineChangeKind = iota
+	LineRemoved
+	LineChanged
+)
+
+func (k LineChangeKind) String() string {
+	switch k {
+	case LineAdded:
+		return "added"
+	case LineRemoved:
+		return "removed"
+	case LineChanged:
+		return "changed"
+	}
+	return fmt.Sprintf("LineChangeKind(%d)", int(k))
+}
+
+// LineChange is one line-level difference between two text projections.
+// Line numbers are 1-based; BeforeLine is 0 for an added line and
+// AfterLine is 0 for a removed one.
+type LineChange struct {
+	Kind       LineChangeKind
+	BeforeLine int
+	AfterLine  int
+	Before     string
+	After      string
+
+	// NodeID is the node the line belongs to: the node whose projection
+	// produced it, or the nearest container when a row joins several
+	// nodes on one line. It is the after node except for removed lines,
+	// and 0 when diffing plain strings.
+	NodeID int
+}
+
+// String formats the change like a diff line: "+3 text", "-2 text", or
+// "~4 old → new".
+func (c LineChange) String() string {
+	switch c.Kind {
+	case LineAdded:
+		return fmt.Sprintf("+%d %s", c.AfterLine, c.After)
+	case LineRemoved:
+		return fmt.Sprintf("-%d %s", c.BeforeLine, c.Before)
+	}
+	return fmt.Sprintf("~%d %s → %s", c.AfterLine, c.Before, c.After)
+}
+
+// ProjectionLines is a text projection split into lines, each attributed
+// to the node that produced it.
+type ProjectionLines struct {
+	Lines  []string
+	Owners []int
+}
+
+// ProjectLines computes the text projection of tree with line ownership.
+// strings.Join(Lines, "\n") equals TextProjection(tree).
+func ProjectLines(tree *RenderTree) ProjectionLines {
+	return projectLines(tree, DefaultTextProjectionOptions())
+}
+
+// projectLines is ProjectLines with custom options.
+func projectLines(tree *RenderTree, opts TextProjectionOptions) ProjectionLines {
+	if tree == nil || tree.Root == nil {
+		return ProjectionLines{}
+	}
+	text, owners := projectOwners(tree.Root, tree, opts, 0)
+	return ProjectionLines{Lines: strings.Split(text, "\n"), Owners: owners}
+}
+
+// ProjectionLines returns the viewer's current projection with line
+// ownership, for diffing against a later one with DiffProjectionLines.
+func (v *Viewer) ProjectionLines() ProjectionLines {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return projectLines(v.tree, v.projectionOptions())
+}
+
+// DiffProjections returns the line changes that turn the projection
+// before into after. Lines removed and added at the same place are
+// reported as changed.
+func DiffProjections(before, after string) []LineChange {
+	return DiffProjectionLines(
+		ProjectionLines{Lines: strings.Split(before, "\n")},
+		ProjectionLines{Lines: strings.Split(after, "\n")},
+	)
+}
+
+// DiffTreeProjections is DiffProjections for two trees, with each change
+// attributed to a node.
+func DiffTreeProjections(before, after *RenderTree) []LineChange {
+	return DiffProjectionLines(ProjectLines(before), ProjectLines(after))
+}
+
+// DiffProjectionLines diffs two attributed projections.
+func DiffProjectionLines(before, after ProjectionLines) []LineChange {
+	owner := func(p ProjectionLines, i int) int {
+		if i < len(p.Owners) {
+			return p.Owners[i]
+		}
+		return 0
+	}
+
+	var changes []LineChange
+	var removed, added []int
+	flush := func() {
+		n := min(len(removed), len(added))
+		for k := 0; k < n; k++ {
+			i, j := removed[k], added[k]
+			changes = append(changes, LineChange{Kind: LineChanged, BeforeLine: i + 1, AfterLine: j + 1,
+				Before: before.Lines[i], After: after.Lines[j], NodeID: owner(after, j)})
+		}
+		for _, i := range removed[n:] {
+			changes = append(changes, LineChange{Kind: LineRemoved, BeforeLine: i + 1,
+				Before: before.Lines[i], NodeID: owner(before, i)})
+		}
+		for _, j := range added[n:] {
+			changes = append(changes, LineChange{Kind: LineAdded, AfterLine: j + 1,
+				After: after.Lines[j], NodeID: owner(after, j)})
+		}
+		removed, added = removed[:0], added[:0]
+	}
+
+	for _, e := range diffLines(before.Lines, after.Lines) {
+		switch {
+		case e.i >= 0 && e.j >= 0:
+			flush()
+		case e.i >= 0:
+			removed = append(removed, e.i)
+		default:
+			added = append(added, e.j)
+		}
+	}
+	flush()
+	return changes
+}
+
+// lineEdit is one step of an edit script: a kept line (both indices), a
+// removed line (j = -1), or an added line (i = -1).
+type lineEdit struct{ i, j int }
+
+// diffLines returns a shortest edit script from a to b using the longest
+// common subsequence of lines, after trimming a common prefix and suffix.
+func diffLines(a, b []string) []lineEdit {
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	ma, mb := a[pre:len(a)-suf], b[pre:len(b)-suf]
+
+	// lcs[i][j] is the LCS length of ma[i:] and mb[j:].
+	lcs := make([][]int, len(ma)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(mb)+1)
+	}
+	for i := len(ma) - 1; i >= 0; i-- {
+		for j := len(mb) - 1; j >= 0; j-- {
+			if ma[i] == mb[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	edits := make([]lineEdit, 0, len(a)+len(b))
+	for k := 0; k < pre; k++ {
+		edits = append(edits, lineEdit{k, k})
+	}
+	i, j := 0, 0
+	for i < len(ma) || j < len(mb) {
+		switch {
+		case i < len(ma) && j < len(mb) && ma[i] == mb[j]:
+			edits = append(edits, lineEdit{pre + i, pre + j})
+			i, j = i+1, j+1
+		case j == len(mb) || (i < len(ma) && lcs[i+1][j] >= lcs[i][j+1]):
+			edits = append(edits, lineEdit{pre + i, -1})
+			i++
+		default:
+			edits = append(edits, lineEdit{-1, pre + j})
+			j++
+		}
+	}
+	for k := 0; k < suf; k++ {
+		edits = append(edits, lineEdit{len(a) - suf + k, len(b) - suf + k})
+	}
+	return edits
+}
+
+// projectOwners returns projectNode's text for node along with the ID of
+// the node owning each of its lines.
+func projectOwners(node *RenderNode, tree *RenderTree, opts TextProjectionOptions, depth int) (string, []int) {
+	text := projectNode(node, tree, opts, depth)
+	if isHidden(node) {
+		return "", nil
+	}
+	if node.Props.TextAlt != nil || (node.Type != NodeBox && node.Type != NodeScroll) {
+		return text, fillOwners(node.ID, text)
+	}
+
+	sep := "\n"
+	if node.Type == NodeBox {
+		sep = opts.BoxSeparatorColumn
+		if node.Props.Direction == "row" {
+			sep = opts.BoxSeparatorRow
+		}
+	}
+	var joined string
+	var owners []int
+	first := true
+	for _, child := range node.Children {
+		t, o := projectOwners(child, tree, opts, depth+1)
+		if len(t) == 0 {
+			continue
+		}
+		if first {
+			joined, owners, first = t, o, false
+			continue
+		}
+		joined += sep + t
+		owners = joinOwners(owners, sep, o, node.ID)
+	}
+
+	// Whatever follows the children (a scroll's data rows) is the node's own.
+	if rest, ok := strings.CutPrefix(text, joined); ok && rest != "" {
+		if first {
+			return text, fillOwners(node.ID, text)
+		}
+		head, tail, more := strings.Cut(rest, "\n")
+		if head != "" {
+			owners[len(owners)-1] = node.ID
+		}
+		if more {
+			owners = append(owners, fillOwners(node.ID, tail)...)
+		}
+	}
+	if len(owners) == 0 {
+		owners = fillOwners(node.ID, text)
+	}
+	return text, owners
+}
+
+// joinOwners returns the line owners of a+sep+b given those of a and b.
+// A line shared by different owners belongs to parent, as do separator
+// lines of their own.
+func joinOwners(a []int, sep string, b []int, parent int) []int {
+	n := strings.Count(sep, "\n")
+	out := append([]int(nil), a...)
+	if n == 0 {
+		if out[len(out)-1] != b[0] {
+			out[len(out)-1] = parent
+		}
+		return append(out, b[1:]...)
+	}
+	for k := 1; k < n; k++ {
+		out = append(out, parent)
+	}
+	return append(out, b...)
+}
+
+func fillOwners(id int, text string) []int {
+	owners := make([]int, strings.Count(text, "\n")+1)
+	for i := range owners {
+		owners[i] = id
+	}
+	return owners
+}
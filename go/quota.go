@@ -0,0 +1,219 @@
+package viewer
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Quotas limits what an untrusted source may do through ProcessMessage.
+// A zero field means unlimited. Direct-call methods (SetTree, ApplyPatches,
+// DefineSlot) are trusted and bypass quotas.
+//
+// Two limits apply to the connection itself, so Serve enforces them by
+// failing it: MaxFrameBytes caps the size of a frame, header included
+// (see FrameReader.MaxFrameBytes), and a frame still arriving when
+// MaxBytesPerSec is passed is not buffered further (ErrByteRateExceeded).
+type Quotas struct {
+	MaxMessagesPerSec    int
+	MaxBytesPerSec       int
+	MaxSlots             int
+	MaxDataRowsPerSchema int
+	MaxImageBytes        int
+	MaxFrameBytes        int
+}
+
+// ErrByteRateExceeded is returned by Serve when the byte rate quota is
+// passed while a frame is still being buffered.
+var ErrByteRateExceeded = errors.New("byte rate quota exceeded while buffering a frame")
+
+// Quota kinds reported in QuotaViolation.Kind.
+const (
+	QuotaMessageRate = "message_rate"
+	QuotaByteRate    = "byte_rate"
+	QuotaSlots       = "slots"
+	QuotaDataRows    = "data_rows"
+	QuotaImageBytes  = "image_bytes"
+	QuotaSessions    = "sessions" // sessions opened by a Mux (see Mux.SetMaxSessions)
+)
+
+// QuotaViolation describes a message rejected for exceeding a quota.
+type QuotaViolation struct {
+	Kind    string      `json:"kind"`
+	Limit   int         `json:"limit"`
+	Value   int         `json:"value"`
+	MsgType MessageType `json:"msgType"`
+	Target  int         `json:"target,omitempty"` // node ID or slot, when applicable
+}
+
+// quotaState tracks the configured limits and the current rate window.
+type quotaState struct {
+	limits      Quotas
+	onViolation func(QuotaViolation)
+
+	windowStart time.Time
+	windowMsgs  int
+	windowBytes int
+}
+
+// SetQuotas installs per-connection quotas enforced by ProcessMessage.
+// onViolation, if non-nil, is called (with the viewer lock held) for each
+// rejected message. Each rejection is also reported to the source with a
+// MsgError.
+func (v *Viewer) SetQuotas(q Quotas, onViolation func(QuotaViolation)) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.quota = &quotaState{
+		limits:      q,
+		onViolation: onViolation,
+		windowStart: v.now(),
+	}
+}
+
+// checkQuota returns false if msg exceeds a quota, reporting the violation.
+// Must be called with the mutex held.
+func (v *Viewer) checkQuota(msg ProtocolMessage) bool {
+	q := v.quota
+	if q == nil {
+		return true
+	}
+
+	q.roll(v.now())
+	q.windowMsgs++
+
+	violation := q.violation(msg, v.tree)
+	if violation == nil {
+		return true
+	}
+
+	violation.MsgType = msg.Type
+	v.rejectQuota(*violation)
+	return false
+}
+
+// rejectQuota counts a quota violation and reports it to the violation
+// handler and the source.
+// Must be called with the mutex held.
+func (v *Viewer) rejectQuota(violation QuotaViolation) {
+	v.quotaViolations++
+	if v.quota.onViolation != nil {
+		v.quota.onViolation(violation)
+	}
+	v.reportQuota(violation)
+}
+
+// maxFrameBytes returns the frame size quota, or 0 for none.
+func (v *Viewer) maxFrameBytes() int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.quota == nil {
+		return 0
+	}
+	return v.quota.limits.MaxFrameBytes
+}
+
+// trackRead counts n bytes read by Serve, with buffered bytes of an
+// incomplete frame already waiting, and fails once the byte rate quota
+// is passed while a frame is still arriving: its message would only be
+// rejected once whole.
+func (v *Viewer) trackRead(n, buffered int) error {
+	v.TrackBytes(n)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	q := v.quota
+	if q == nil || buffered == 0 || q.limits.MaxBytesPerSec <= 0 || q.windowBytes <= q.limits.MaxBytesPerSec {
+		return nil
+	}
+	v.rejectQuota(QuotaViolation{Kind: QuotaByteRate, Limit: q.limits.MaxBytesPerSec, Value: q.windowBytes})
+	return fmt.Errorf("%w: %d bytes, limit %d", ErrByteRateExceeded, q.windowBytes, q.limits.MaxBytesPerSec)
+}
+
+// roll starts a new rate window if the current one is over by now.
+func (q *quotaState) roll(now time.Time) {
+	if now.Sub(q.windowStart) >= time.Second {
+		q.windowStart = now
+		q.windowMsgs = 0
+		q.windowBytes = 0
+	}
+}
+
+// violation returns the first quota msg exceeds, or nil.
+func (q *quotaState) violation(msg ProtocolMessage, tree *RenderTree) *QuotaViolation {
+	l := q.limits
+
+	if l.MaxMessagesPerSec > 0 && q.windowMsgs > l.MaxMessagesPerSec {
+		return &QuotaViolation{Kind: QuotaMessageRate, Limit: l.MaxMessagesPerSec, Value: q.windowMsgs}
+	}
+	if l.MaxBytesPerSec > 0 && q.windowBytes > l.MaxBytesPerSec {
+		return &QuotaViolation{Kind: QuotaByteRate, Limit: l.MaxBytesPerSec, Value: q.windowBytes}
+	}
+
+	switch msg.Type {
+	case MsgDefine:
+		if l.MaxSlots > 0 && msg.Slot != nil {
+			if _, exists := tree.Slots[*msg.Slot]; !exists && len(tree.Slots) >= l.MaxSlots {
+				return &QuotaViolation{Kind: QuotaSlots, Limit: l.MaxSlots, Value: len(tree.Slots) + 1, Target: *msg.Slot}
+			}
+		}
+
+	case MsgData:
+		if l.MaxDataRowsPerSchema > 0 {
+			schema := 0
+			if msg.Schema != nil {
+				schema = *msg.Schema
+			}
+			if n := len(tree.DataRows[schema]); n >= l.MaxDataRowsPerSchema {
+				return &QuotaViolation{Kind: QuotaDataRows, Limit: l.MaxDataRowsPerSchema, Value: n + 1, Target: schema}
+			}
+		}
+
+	case MsgTree:
+		if l.MaxImageBytes > 0 {
+			return imageQuotaViolation(msg.Root, l.MaxImageBytes)
+		}
+
+	case MsgPatch:
+		if l.MaxImageBytes > 0 {
+			for _, op := range msg.Ops {
+				if v := imageQuotaViolation(op.Replace, l.MaxImageBytes); v != nil {
+					return v
+				}
+				if op.ChildrenInsert != nil {
+					if v := imageQuotaViolation(op.ChildrenInsert.Node, l.MaxImageBytes); v != nil {
+						return v
+					}
+				}
+				if op.ChildrenSet != nil {
+					for _, c := range op.ChildrenSet.Nodes {
+						if v := imageQuotaViolation(c, l.MaxImageBytes); v != nil {
+							return v
+						}
+					}
+				}
+				if data, ok := op.Set["data"].([]byte); ok && len(data) > l.MaxImageBytes {
+					return &QuotaViolation{Kind: QuotaImageBytes, Limit: l.MaxImageBytes, Value: len(data), Target: op.Target}
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// imageQuotaViolation reports the first node in a VNode subtree whose
+// image data exceeds limit bytes.
+func imageQuotaViolation(node *VNode, limit int) *QuotaViolation {
+	if node == nil {
+		return nil
+	}
+	if len(node.Props.Data) > limit {
+		return &QuotaViolation{Kind: QuotaImageBytes, Limit: limit, Value: len(node.Props.Data), Target: node.ID}
+	}
+	for _, c := range node.Children {
+		if v := imageQuotaViolation(c, limit); v != nil {
+			return v
+		}
+	}
+	return nil
+}
@@ -0,0 +1,582 @@
+package viewer
+
+import (
+	"image"
+	"image/color"
+	"strings"
+	"time"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/math/f64"
+)
+
+// Pixel rasterizer — draws a tree into an RGBA image for framebuffer
+// targets and PNG output, using the pixel layout.
+//
+// A Rasterizer keeps its image between renders and redraws only what
+// changed. Each render snapshots every node's on-screen rectangle and
+// resolved appearance; nodes whose snapshot differs from the previous
+// render, whether through a patch, a slot change, or a reflow, damage
+// both their old and new rectangles. Only the damaged regions are
+// cleared and redrawn, and they are reported in the RenderResult so an
+// embedder compositing the image can copy just those pixels.
+
+// maxDamageRects is how many separate damage rectangles a render reports
+// before they are merged into their bounding box.
+const maxDamageRects = 8
+
+// Raster text metrics at the default font size, matching
+// PixelLayoutOptions.
+const (
+	rasterCharWidth  = 8
+	rasterLineHeight = 20
+	rasterBaseline   = 14 // basicfont's 13px face centered in the line
+)
+
+var (
+	rasterForeground = color.RGBA{0, 0, 0, 255}
+	rasterBackground = color.RGBA{255, 255, 255, 255}
+)
+
+// RenderResult is the output of a raster render.
+type RenderResult struct {
+	// Image is the rendered frame. It is reused by later renders.
+	Image *image.RGBA
+
+	// Damage lists the regions of Image that were redrawn, merged and
+	// clipped to the image bounds. It is empty when nothing changed.
+	Damage []image.Rectangle
+
+	// Full reports that the whole image was redrawn, as on the first
+	// render or after a resize.
+	Full bool
+}
+
+// RenderImage lays the tree out in pixels and draws it onto a new
+// width × height image. slots resolves style and color slot references;
+// it may be nil. Animated images show their first frame.
+func RenderImage(tree *RenderTree, width, height int, slots func(int) SlotValue) *image.RGBA {
+	return NewRasterizer().Render(tree, width, height, slots).Image
+}
+
+// Rasterizer renders successive states of a tree into one image,
+// redrawing only damaged regions. It is not safe for concurrent use.
+type Rasterizer struct {
+	// Fonts draws text with OpenType fonts instead of the embedded bitmap
+	// font when set. Changing it redraws the whole frame.
+	Fonts *FontFamily
+
+	img    *image.RGBA
+	nodes  map[int]rasterNode
+	images imageCache
+	fonts  fontCache
+}
+
+// rasterNode is a node's snapshot from one render: everything that
+// affects the pixels it draws.
+type rasterNode struct {
+	rect     image.Rectangle
+	style    CellStyle
+	fill     bool        // paints its own background
+	text     string      // content, value, placeholder, or alt text
+	rtl      bool        // right-to-left paragraph
+	align    string      // textAlign
+	metrics  textMetrics // text grid for the font size
+	overflow string      // textOverflow
+	img      image.Image // decoded frame, for image nodes
+	border   int         // border width in pixels
+	stroke   string      // resolved border color
+	shadow   ShadowStyle // with the color resolved
+	shadowed bool
+	opacity  float64         // the node's own opacity; 1 if opaque
+	radius   int             // corner radius, limited to half the shorter side
+	track    image.Rectangle // scrollbar, if a scroll node overflows
+	thumb    image.Rectangle
+	cursor   image.Rectangle // text cursor, if a focused input shows one
+	caret    string          // its shape
+	selected image.Rectangle // selected text in a focused input
+	scroll   int             // lines a multiline input is scrolled down
+	bar      image.Rectangle // filled part of a progress bar
+	striped  bool            // a table shades every other row
+	space    f64.Aff3        // maps rect to the screen through transforms; zero if none apply
+}
+
+// bounds is the area the node paints, including its shadow, before any
+// transforms.
+func (n rasterNode) bounds() image.Rectangle {
+	if !n.shadowed {
+		return n.rect
+	}
+	return n.rect.Union(shadowRect(n.shadow, n.rect))
+}
+
+// area is the area of the screen the node paints.
+func (n rasterNode) area() image.Rectangle {
+	if n.space == (f64.Aff3{}) {
+		return n.bounds()
+	}
+	return transformRect(n.space, n.bounds())
+}
+
+// NewRasterizer creates a rasterizer with no previous frame; its first
+// render is a full one.
+func NewRasterizer() *Rasterizer {
+	return &Rasterizer{}
+}
+
+// Render draws tree at width × height and returns the frame with the
+// regions that changed since the previous Render.
+func (r *Rasterizer) Render(tree *RenderTree, width, height int, slots func(int) SlotValue) RenderResult {
+	r.images.prune(tree)
+	return r.render(tree, width, height, slots, &r.images, time.Time{})
+}
+
+// render is Render with a caller-owned image cache; animations advance
+// with now.
+func (r *Rasterizer) render(tree *RenderTree, width, height int, slots func(int) SlotValue, images *imageCache, now time.Time) RenderResult {
+	width, height = max(width, 0), max(height, 0)
+	bounds := image.Rect(0, 0, width, height)
+
+	fontsChanged := r.fonts.family != r.Fonts
+	if fontsChanged {
+		r.fonts = fontCache{family: r.Fonts}
+	}
+	d := &rasterDrawer{
+		gridDrawer: gridDrawer{slots: slots, images: images, now: now, active: tree.Active, focused: tree.Focused, cursor: tree.Cursor, invalid: tree.Invalid, progress: tree.Progress, tree: tree},
+		nodes:      make(map[int]rasterNode),
+		fonts:      &r.fonts,
+	}
+	layers := overlays(tree.Root)
+	if tree.Root != nil {
+		l := &layoutEngine{opts: PixelLayoutOptions(), layouts: make(map[int]*ComputedLayout), tree: tree}
+		l.layoutTree(tree.Root, ComputedLayout{Width: float64(width), Height: float64(height)})
+		d.layouts = l.layouts
+		d.snapshot(tree.Root, CellStyle{}, DirLTR, 0, f64.Aff3{})
+		for _, o := range layers {
+			d.snapshot(o, CellStyle{}, DirLTR, 0, f64.Aff3{})
+		}
+		if n, ok := d.tooltipSnapshot(tree, bounds); ok {
+			d.nodes[tooltipKey] = n
+		}
+	}
+
+	var damage []image.Rectangle
+	full := r.img == nil || r.img.Bounds() != bounds || fontsChanged
+	if full {
+		if r.img == nil || r.img.Bounds() != bounds {
+			r.img = image.NewRGBA(bounds)
+		}
+		damage = []image.Rectangle{bounds}
+	} else {
+		damage = mergeDamage(diffSnapshots(r.nodes, d.nodes), bounds)
+	}
+	r.nodes = d.nodes
+
+	d.img = r.img
+	for _, clip := range damage {
+		draw.Draw(r.img, clip, image.NewUniform(rasterBackground), image.Point{}, draw.Src)
+		if tree.Root != nil {
+			d.draw(tree.Root, clip)
+		}
+		d.drawOverlays(layers, clip)
+		if n, ok := d.nodes[tooltipKey]; ok {
+			d.drawTooltip(n, clip)
+		}
+	}
+	return RenderResult{Image: r.img, Damage: damage, Full: full}
+}
+
+// diffSnapshots returns the areas of nodes that differ between two
+// renders: the old and new area of changed nodes, the old area of removed
+// ones, and the new area of added ones.
+func diffSnapshots(prev, next map[int]rasterNode) []image.Rectangle {
+	var damage []image.Rectangle
+	for id, n := range next {
+		p, ok := prev[id]
+		switch {
+		case !ok:
+			damage = append(damage, n.area())
+		case p != n:
+			damage = append(damage, p.area(), n.area())
+		}
+	}
+	for id, p := range prev {
+		if _, ok := next[id]; !ok {
+			damage = append(damage, p.area())
+		}
+	}
+	return damage
+}
+
+// mergeDamage clips rects to bounds and merges overlapping or touching
+// ones, collapsing to a single bounding box past maxDamageRects.
+func mergeDamage(rects []image.Rectangle, bounds image.Rectangle) []image.Rectangle {
+	var out []image.Rectangle
+	for _, rc := range rects {
+		rc = rc.Intersect(bounds)
+		if rc.Empty() {
+			continue
+		}
+		// Absorb every rectangle rc touches, repeating since the union
+		// may reach others.
+		for merged := true; merged; {
+			merged = false
+			for i := 0; i < len(out); i++ {
+				if touches(rc, out[i]) {
+					rc = rc.Union(out[i])
+					out = append(out[:i], out[i+1:]...)
+					merged = true
+					i--
+				}
+			}
+		}
+		out = append(out, rc)
+	}
+	if len(out) > maxDamageRects {
+		union := image.Rectangle{}
+		for _, rc := range out {
+			union = union.Union(rc)
+		}
+		out = []image.Rectangle{union}
+	}
+	return out
+}
+
+// touches reports whether two rectangles overlap or share an edge.
+func touches(a, b image.Rectangle) bool {
+	return a.Min.X <= b.Max.X && b.Min.X <= a.Max.X && a.Min.Y <= b.Max.Y && b.Min.Y <= a.Max.Y
+}
+
+// rasterDrawer paints laid-out nodes into an image, reusing the grid
+// drawer's style resolution.
+type rasterDrawer struct {
+	gridDrawer
+	img   *image.RGBA
+	nodes map[int]rasterNode
+	fonts *fontCache
+}
+
+// snapshot records node and its descendants. dir is the inherited text
+// direction, dy shifts content scrolled by enclosing scroll containers,
+// and space is the transform of enclosing transformed nodes, if any.
+func (d *rasterDrawer) snapshot(node *RenderNode, inherited CellStyle, dir string, dy int, space f64.Aff3) {
+	layout, ok := d.layouts[node.ID]
+	if !ok {
+		return
+	}
+	style := d.nodeStyle(node, inherited)
+	n := rasterNode{
+		rect:    image.Rect(int(layout.X), int(layout.Y)-dy, int(layout.X+layout.Width), int(layout.Y+layout.Height)-dy),
+		style:   style,
+		fill:    style.BG != "" && style.BG != inherited.BG || style.Inverse && !inherited.Inverse,
+		opacity: 1,
+	}
+	if style.Inverse {
+		// Pixels have concrete colors, so inverting is swapping them.
+		n.style.FG = hexColor(rgba(style.BG, rasterBackground))
+		n.style.BG = hexColor(rgba(style.FG, rasterForeground))
+		n.style.Inverse = false
+	}
+
+	p := node.Props
+	if p.Opacity != nil {
+		n.opacity = min(max(*p.Opacity, 0), 1)
+	}
+	if p.BorderRadius != nil {
+		n.radius = min(max(*p.BorderRadius, 0), n.rect.Dx()/2, n.rect.Dy()/2)
+	}
+	dir = inheritDir(p.TextDirection, dir)
+	n.align, n.overflow = p.TextAlign, p.TextOverflow
+	n.metrics = rasterMetrics(nil)
+	switch node.Type {
+	case NodeText:
+		n.metrics = rasterMetrics(p.Size)
+		if p.Content != nil {
+			n.text = *p.Content
+		}
+	case NodeInput:
+		n.text = d.inputShown(node)
+		if p.Placeholder != nil {
+			n.text += "\x00" + *p.Placeholder
+		}
+		n.cursor, n.caret = d.cursorRect(node, n.rect, n.metrics)
+		n.scroll = d.inputScroll(node)
+		n.selected = d.selectionRect(node, n.rect, n.metrics)
+	case NodeCheckbox, NodeRadio, NodeSelect, NodeButton:
+		n.text = controlText(node)
+	case NodeProgress:
+		n.bar = d.progressRect(node, n.rect)
+	case NodeTable:
+		header, lines := tableLines(d.tree, node)
+		n.scroll = tableTop(node)
+		n.text = strings.Join(append([]string{header}, lines[min(n.scroll, len(lines)):]...), "\n")
+		n.selected = rowRect(node, selectedRow(node), n.rect, n.metrics)
+		n.striped = p.Striped != nil && *p.Striped
+	case NodeSpinner:
+		n.text = d.spinnerText(node)
+	case NodeImage, NodeCanvas:
+		if node.Type == NodeImage && len(p.Data) > 0 {
+			n.img, _ = d.images.lookup(node, d.now)
+		}
+		n.text = MediaPlaceholder(node)
+		if p.AltText != nil {
+			n.text = *p.AltText
+		}
+	}
+	if isScroller(node) {
+		if track, thumb, ok := scrollbar(node, d.layouts, PixelLayoutOptions()); ok {
+			n.track, n.thumb = barRect(track, dy), barRect(thumb, dy)
+		}
+		if p.ScrollTop != nil {
+			dy += *p.ScrollTop
+		}
+	}
+	if p.Shadow != nil {
+		n.shadow, n.shadowed = *p.Shadow, true
+		n.shadow.Color = d.color(p.Shadow.Color)
+	}
+	if (node.Type == NodeBox || node.Type == NodeScroll) && hasBorder(p.Border) {
+		n.border = p.Border.Width
+		n.stroke = d.color(p.Border.Color)
+	}
+	n.rtl = resolveRTL(dir, n.text)
+	if t := transformOf(node); t != nil {
+		if space == (f64.Aff3{}) {
+			space = identity
+		}
+		space = compose(space, rectMatrix(t, n.rect))
+	}
+	n.space = space
+	d.nodes[node.ID] = n
+
+	for _, child := range paintOrder(node) {
+		d.snapshot(child, style, dir, dy, space)
+	}
+}
+
+// draw paints a node and its children from their snapshots, clipped.
+func (d *rasterDrawer) draw(node *RenderNode, clip image.Rectangle) {
+	n, ok := d.nodes[node.ID]
+	if !ok {
+		return
+	}
+	if t := transformOf(node); t != nil {
+		d.transformed(node, n, t, clip)
+		return
+	}
+	d.drawPlain(node, n, clip)
+}
+
+// drawPlain paints a node and its children, ignoring its transform.
+func (d *rasterDrawer) drawPlain(node *RenderNode, n rasterNode, clip image.Rectangle) {
+	if n.opacity < 1 {
+		d.layer(node, n, clip)
+		return
+	}
+	d.paint(node, n, clip)
+}
+
+// layer draws a translucent node and its subtree into a transparent
+// layer, then composites the layer at the node's opacity, so overlapping
+// descendants do not show through each other.
+func (d *rasterDrawer) layer(node *RenderNode, n rasterNode, clip image.Rectangle) {
+	area := n.bounds().Intersect(clip)
+	if area.Empty() || n.opacity <= 0 {
+		return
+	}
+	dst := d.img
+	d.img = image.NewRGBA(area)
+	d.paint(node, n, clip)
+	layer := d.img
+	d.img = dst
+	alpha := image.NewUniform(color.Alpha{uint8(n.opacity*255 + 0.5)})
+	draw.DrawMask(d.img, area, layer, area.Min, alpha, image.Point{}, draw.Over)
+}
+
+// paint draws a node's shadow, then the node and its children.
+func (d *rasterDrawer) paint(node *RenderNode, n rasterNode, clip image.Rectangle) {
+	if n.shadowed {
+		d.shadow(n, clip)
+	}
+	if n.radius > 0 {
+		d.rounded(node, n, clip)
+		return
+	}
+	d.content(node, n, clip)
+}
+
+// rounded draws a node with rounded corners into a layer, then
+// composites the layer through an anti-aliased mask of the rounded
+// rectangle, which also clips its children.
+func (d *rasterDrawer) rounded(node *RenderNode, n rasterNode, clip image.Rectangle) {
+	area := n.rect.Intersect(clip)
+	if area.Empty() {
+		return
+	}
+	dst := d.img
+	d.img = image.NewRGBA(area)
+	d.content(node, n, clip)
+	layer := d.img
+	d.img = dst
+	draw.DrawMask(d.img, area, layer, area.Min, roundedMask(n.rect, n.radius, 0, area), area.Min, draw.Over)
+}
+
+// content draws a node and its children onto the current image.
+func (d *rasterDrawer) content(node *RenderNode, n rasterNode, clip image.Rectangle) {
+	visible := n.rect.Intersect(clip)
+	if visible.Empty() && clipsChildren(node) {
+		return
+	}
+
+	if n.fill {
+		draw.Draw(d.img, visible, image.NewUniform(rgba(n.style.BG, rasterBackground)), image.Point{}, draw.Src)
+	}
+
+	p := node.Props
+	switch node.Type {
+	case NodeText:
+		m := n.metrics
+		cols := n.rect.Dx() / m.cellW
+		for i, line := range fitLines(n.text, n.overflow, cols) {
+			line = BidiReorder(line, n.rtl)
+			x := n.rect.Min.X + alignOffset(n.align, n.rtl, cols, StringWidth(line))*m.cellW
+			d.text(x, n.rect.Min.Y+i*m.lineH, line, n.style, m, visible)
+		}
+
+	case NodeSeparator:
+		y := n.rect.Min.Y + n.rect.Dy()/2
+		line := image.Rect(n.rect.Min.X, y, n.rect.Max.X, y+1).Intersect(visible)
+		draw.Draw(d.img, line, image.NewUniform(rgba(n.style.FG, rasterForeground)), image.Point{}, draw.Over)
+
+	case NodeInput:
+		faint := n.style
+		faint.Faint = true
+		d.text(n.rect.Min.X, n.rect.Min.Y, "> ", faint, n.metrics, visible)
+		x := n.rect.Min.X + 2*n.metrics.cellW
+		shown := d.inputShown(node)
+		switch {
+		case shown != "" && isMultiline(node):
+			visible = visible.Intersect(n.rect)
+			lines := strings.Split(shown, "\n")
+			for i, line := range lines[min(n.scroll, len(lines)):] {
+				d.text(x, n.rect.Min.Y+i*n.metrics.lineH, BidiReorder(line, n.rtl), n.style, n.metrics, visible)
+			}
+		case shown != "":
+			d.text(x, n.rect.Min.Y, BidiReorder(shown, n.rtl), n.style, n.metrics, visible)
+		case p.Placeholder != nil:
+			d.text(x, n.rect.Min.Y, BidiReorder(*p.Placeholder, n.rtl), faint, n.metrics, visible)
+		}
+		d.drawComposition(node, n, visible)
+		if !n.cursor.Empty() {
+			d.drawCursor(node, n, visible)
+		}
+		if !n.selected.Empty() {
+			d.drawSelection(node, n, visible)
+		}
+
+	case NodeCheckbox, NodeRadio, NodeSelect, NodeButton, NodeSpinner:
+		d.text(n.rect.Min.X, n.rect.Min.Y, n.text, n.style, n.metrics, visible)
+
+	case NodeProgress:
+		d.drawBar(n, visible)
+
+	case NodeTable:
+		d.drawTable(node, n, visible)
+
+	case NodeImage, NodeCanvas:
+		if n.img != nil {
+			// Scaling into a sub-image keeps the image's placement but only
+			// touches the visible part.
+			if dst, ok := d.img.SubImage(visible).(*image.RGBA); ok {
+				draw.ApproxBiLinear.Scale(dst, n.rect, n.img, n.img.Bounds(), draw.Over, nil)
+			}
+			break
+		}
+		faint := n.style
+		faint.Faint = true
+		d.text(n.rect.Min.X, n.rect.Min.Y, BidiReorder(n.text, n.rtl), faint, n.metrics, visible)
+
+	case NodeBox, NodeScroll:
+		inner := visible
+		if n.border > 0 {
+			inner = visible.Intersect(n.rect.Inset(n.border))
+		}
+		if !clipsChildren(node) {
+			inner = clip
+		}
+		for _, child := range paintOrder(node) {
+			d.draw(child, inner)
+		}
+		if !n.track.Empty() {
+			d.scrollbar(n, inner)
+		}
+		// Over the children, which a rounded border's corners overlap.
+		if n.border > 0 {
+			d.stroke(n, visible)
+		}
+	}
+}
+
+// stroke draws a box's border along the edge of its rectangle: a ring
+// following rounded corners, or four straight edges.
+func (d *rasterDrawer) stroke(n rasterNode, clip image.Rectangle) {
+	src := image.NewUniform(rgba(n.stroke, rgba(n.style.FG, rasterForeground)))
+	if n.radius > 0 {
+		draw.DrawMask(d.img, clip, src, image.Point{}, roundedMask(n.rect, n.radius, n.border, clip), clip.Min, draw.Over)
+		return
+	}
+	inner := n.rect.Inset(n.border)
+	for _, edge := range []image.Rectangle{
+		{n.rect.Min, image.Pt(n.rect.Max.X, inner.Min.Y)},
+		{image.Pt(n.rect.Min.X, inner.Max.Y), n.rect.Max},
+		{image.Pt(n.rect.Min.X, inner.Min.Y), image.Pt(inner.Min.X, inner.Max.Y)},
+		{image.Pt(inner.Max.X, inner.Min.Y), image.Pt(n.rect.Max.X, inner.Max.Y)},
+	} {
+		draw.Draw(d.img, edge.Intersect(clip), src, image.Point{}, draw.Over)
+	}
+}
+
+// text draws one line of text with its top-left corner at (x, y), on the
+// text grid m.
+func (d *rasterDrawer) text(x, y int, s string, style CellStyle, m textMetrics, clip image.Rectangle) {
+	fg := rgba(style.FG, rasterForeground)
+	if style.Faint {
+		bg := rgba(style.BG, rasterBackground)
+		mid := func(a, b uint8) uint8 { return uint8((int(a) + int(b)) / 2) }
+		fg = color.RGBA{mid(fg.R, bg.R), mid(fg.G, bg.G), mid(fg.B, bg.B), 255}
+	}
+	dst, ok := d.img.SubImage(clip).(*image.RGBA)
+	if !ok {
+		return
+	}
+	src := image.NewUniform(fg)
+	face := d.fonts.face(style, m)
+	cx := x
+	for len(s) > 0 {
+		n, width := nextGrapheme(s)
+		if width > 0 {
+			face.glyph(dst, src, s[:n], cx, y, width, m)
+		}
+		cx += width * m.cellW
+		s = s[n:]
+	}
+	width := cx - x
+	base := y + m.baseline
+	thick := max(1, m.lineH/20)
+	if style.Underline {
+		draw.Draw(dst, image.Rect(x, base+2, x+width, base+2+thick).Intersect(clip), src, image.Point{}, draw.Over)
+	}
+	if style.Strike {
+		strike := base - m.baseline*2/7
+		draw.Draw(dst, image.Rect(x, strike, x+width, strike+thick).Intersect(clip), src, image.Point{}, draw.Over)
+	}
+}
+
+// rgba converts a normalized color string, falling back to def.
+func rgba(c string, def color.RGBA) color.RGBA {
+	r, g, b, ok := parseColor(c)
+	if !ok {
+		return def
+	}
+	return color.RGBA{uint8(r), uint8(g), uint8(b), 255}
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package viewer
+
+import "errors"
+
+// ErrRawUnsupported is returned where raw terminal control is not
+// implemented for the platform.
+var ErrRawUnsupported = errors.New("raw terminal mode not supported on this platform")
+
+// MakeRaw is not implemented on this platform.
+func MakeRaw(fd int) (restore func() error, err error) {
+	return nil, ErrRawUnsupported
+}
+
+// TerminalSize is not implemented on this platform.
+func TerminalSize(fd int) (width, height int, err error) {
+	return 0, 0, ErrRawUnsupported
+}
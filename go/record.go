@@ -0,0 +1,141 @@
+package viewer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// Session recordings: a timestamped log of the raw bytes that crossed a
+// connection, in either direction. Recordings are what cmd/vpdump inspects
+// and cmd/vpplay replays.
+//
+// File layout:
+//
+//	[0:8]  magic "VPREC\x00\x01\x00"
+//	then repeated records:
+//	[0:8]  offset since recording start, nanoseconds (little-endian uint64)
+//	[8:12] length (little-endian uint32)
+//	[12]   direction (0 = to viewer, 1 = from viewer)
+//	[13:]  data
+
+// RecordingMagic identifies a recording file.
+var RecordingMagic = []byte("VPREC\x00\x01\x00")
+
+const recordHeaderSize = 13
+
+// ErrNotRecording is returned when data does not start with RecordingMagic.
+var ErrNotRecording = errors.New("not a viewport recording")
+
+// Direction is which way recorded bytes travelled.
+type Direction uint8
+
+const (
+	DirInbound  Direction = 0 // source → viewer
+	DirOutbound Direction = 1 // viewer → source
+)
+
+func (d Direction) String() string {
+	if d == DirOutbound {
+		return "out"
+	}
+	return "in"
+}
+
+// RecordedChunk is one write captured in a recording.
+type RecordedChunk struct {
+	At   time.Duration // since the recording started
+	Dir  Direction
+	Data []byte
+}
+
+// IsRecording reports whether data starts with RecordingMagic.
+func IsRecording(data []byte) bool {
+	return bytes.HasPrefix(data, RecordingMagic)
+}
+
+// Recorder writes a recording. It is safe for concurrent use, so the
+// inbound and outbound sides of a connection can share one.
+type Recorder struct {
+	mu    sync.Mutex
+	w     io.Writer
+	clock Clock
+	start time.Time
+}
+
+// NewRecorder writes the recording magic to w and starts the recording
+// clock. A nil clock uses SystemClock.
+func NewRecorder(w io.Writer, clock Clock) (*Recorder, error) {
+	if clock == nil {
+		clock = SystemClock{}
+	}
+	if _, err := w.Write(RecordingMagic); err != nil {
+		return nil, err
+	}
+	return &Recorder{w: w, clock: clock, start: clock.Now()}, nil
+}
+
+// Record appends a chunk travelling in dir.
+func (r *Recorder) Record(dir Direction, data []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	buf := make([]byte, recordHeaderSize+len(data))
+	binary.LittleEndian.PutUint64(buf[0:8], uint64(r.clock.Now().Sub(r.start)))
+	binary.LittleEndian.PutUint32(buf[8:12], uint32(len(data)))
+	buf[12] = byte(dir)
+	copy(buf[recordHeaderSize:], data)
+	_, err := r.w.Write(buf)
+	return err
+}
+
+// Write records p as inbound bytes, so a Recorder can tee a connection:
+// io.TeeReader(conn, recorder).
+func (r *Recorder) Write(p []byte) (int, error) {
+	if err := r.Record(DirInbound, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// RecordingReader reads chunks back from a recording.
+type RecordingReader struct {
+	r io.Reader
+}
+
+// NewRecordingReader checks the recording magic and returns a reader
+// positioned at the first chunk.
+func NewRecordingReader(r io.Reader) (*RecordingReader, error) {
+	magic := make([]byte, len(RecordingMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return nil, ErrNotRecording
+	}
+	if !IsRecording(magic) {
+		return nil, ErrNotRecording
+	}
+	return &RecordingReader{r: r}, nil
+}
+
+// Next returns the next chunk, or io.EOF at the end of the recording. A
+// recording cut off mid-chunk returns io.ErrUnexpectedEOF.
+func (rr *RecordingReader) Next() (RecordedChunk, error) {
+	var head [recordHeaderSize]byte
+	if _, err := io.ReadFull(rr.r, head[:]); err != nil {
+		return RecordedChunk{}, err
+	}
+	chunk := RecordedChunk{
+		At:   time.Duration(binary.LittleEndian.Uint64(head[0:8])),
+		Dir:  Direction(head[12]),
+		Data: make([]byte, binary.LittleEndian.Uint32(head[8:12])),
+	}
+	if _, err := io.ReadFull(rr.r, chunk.Data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return RecordedChunk{}, err
+	}
+	return chunk, nil
+}
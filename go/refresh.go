@@ -0,0 +1,74 @@
+package viewer
+
+import "time"
+
+// Full state refresh. MsgResync asks only for the tree, which is enough
+// after lost frames the source can still account for. When the viewer
+// cannot tell what it is missing — a frame failed to decode, its tree
+// hash disagrees with the source's, or it is serving a new connection
+// with state from an old one — it sends MsgRefresh instead, and the
+// source resends every slot and schema along with the full tree. Until
+// the tree arrives, patches and data rows are dropped as for a resync.
+
+// RequestRefresh asks the source to resend its full state: slots,
+// schemas and tree. It does nothing if a refresh is already pending.
+func (v *Viewer) RequestRefresh() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.requestRefresh()
+}
+
+// requestRefresh sends MsgRefresh upstream, unless one is already
+// pending, and drops incremental messages until the full tree arrives.
+// Must be called with the mutex held.
+func (v *Viewer) requestRefresh() {
+	if v.refreshPending {
+		return
+	}
+	v.refreshPending = true
+	v.resyncPending = true
+	v.resyncRequests++
+	last := v.lastSeq
+	v.emit(ProtocolMessage{Type: MsgRefresh, Ack: &last})
+}
+
+// reconnected prepares for a new connection: sequence tracking, the
+// heartbeat, metrics reports and clock sync start over, since a new
+// source numbers its frames from the beginning, and if the viewer holds
+// state from the last connection it asks for a refresh.
+func (v *Viewer) reconnected() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.resetSeq()
+	if hb := v.heartbeat; hb != nil {
+		hb.sent, hb.next = time.Time{}, time.Time{}
+	}
+	if r := v.metricsReport; r != nil {
+		r.next = time.Time{}
+	}
+	// The new source may keep different time.
+	v.pings = nil
+	v.clockSamples = nil
+	v.tree.ClockOffset = 0
+	if v.tree.Root != nil {
+		v.requestRefresh()
+	}
+}
+
+// ReportDecodeError handles a frame of type t whose payload failed to
+// decode with err as Serve does, for hosts that read frames themselves:
+// it is counted in ViewerMetrics.DecodeErrors, reported to the source,
+// and followed by a refresh request.
+func (v *Viewer) ReportDecodeError(t MessageType, err error) {
+	v.decodeFailed(t, err)
+}
+
+// decodeFailed counts a frame that failed to decode, reports it to the
+// source, and asks for a refresh, since whatever it carried is lost.
+func (v *Viewer) decodeFailed(t MessageType, err error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.decodeErrors++
+	v.reportUpstream(ErrorReport{Code: ErrorCodeDecode, Message: err.Error(), MsgType: t})
+	v.requestRefresh()
+}
@@ -0,0 +1,134 @@
+package viewer
+
+import "reflect"
+
+// Matches reports whether env satisfies every bound set in c.
+func (c EnvCondition) Matches(env EnvInfo) bool {
+	if c.MinColorDepth > 0 && env.ColorDepth < c.MinColorDepth {
+		return false
+	}
+	if c.MaxColorDepth > 0 && env.ColorDepth > c.MaxColorDepth {
+		return false
+	}
+	if c.MinDisplayWidth > 0 && env.DisplayWidth < c.MinDisplayWidth {
+		return false
+	}
+	if c.MaxDisplayWidth > 0 && env.DisplayWidth > c.MaxDisplayWidth {
+		return false
+	}
+	if c.MinDisplayHeight > 0 && env.DisplayHeight < c.MinDisplayHeight {
+		return false
+	}
+	if c.MaxDisplayHeight > 0 && env.DisplayHeight > c.MaxDisplayHeight {
+		return false
+	}
+	if c.GPU != nil && env.GPU != *c.GPU {
+		return false
+	}
+	if c.Remote != nil && env.Remote != *c.Remote {
+		return false
+	}
+	return true
+}
+
+// ResolveSlot applies env-conditional variants to a slot value, returning
+// the effective value for env. Slot kinds without variants are returned
+// unchanged. The result never carries variants.
+func ResolveSlot(value SlotValue, env EnvInfo) SlotValue {
+	switch sv := value.(type) {
+	case StyleSlot:
+		if len(sv.Variants) == 0 {
+			return sv
+		}
+		props := make(map[string]interface{}, len(sv.Props))
+		for k, p := range sv.Props {
+			props[k] = p
+		}
+		for _, variant := range sv.Variants {
+			if variant.When.Matches(env) {
+				for k, p := range variant.Props {
+					props[k] = p
+				}
+			}
+		}
+		return StyleSlot{Kind: sv.Kind, Props: props, Active: sv.Active, Invalid: sv.Invalid}
+
+	case ColorSlot:
+		if len(sv.Variants) == 0 {
+			return sv
+		}
+		resolved := ColorSlot{Kind: sv.Kind, Role: sv.Role, Value: sv.Value}
+		for _, variant := range sv.Variants {
+			if variant.When.Matches(env) {
+				resolved.Value = variant.Value
+				break
+			}
+		}
+		return resolved
+	}
+	return value
+}
+
+// ResolvedSlot returns the slot's value with env-conditional variants
+// applied for the current environment, or nil if the slot is undefined.
+func (v *Viewer) ResolvedSlot(slot int) SlotValue {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	return v.slotValue(slot)
+}
+
+// slotValue returns the resolved value of a slot.
+// Must be called with the mutex held.
+func (v *Viewer) slotValue(slot int) SlotValue {
+	if resolved, ok := v.resolved[slot]; ok {
+		return resolved
+	}
+	return v.tree.Slots[slot]
+}
+
+// resolveSlot recomputes the resolved value for one slot.
+// Must be called with the mutex held.
+func (v *Viewer) resolveSlot(slot int) {
+	value, ok := v.tree.Slots[slot]
+	if !ok {
+		delete(v.resolved, slot)
+		return
+	}
+	if v.resolved == nil {
+		v.resolved = make(map[int]SlotValue)
+	}
+	env := EnvInfo{}
+	if v.env != nil {
+		env = *v.env
+	}
+	v.resolved[slot] = ResolveSlot(value, env)
+}
+
+// resolveAllSlots re-evaluates every slot against the current environment,
+// marking the viewer dirty if any resolved value changed.
+// Must be called with the mutex held.
+func (v *Viewer) resolveAllSlots() {
+	changed := false
+	for slot, value := range v.tree.Slots {
+		before := v.resolved[slot]
+		v.resolveSlot(slot)
+		if hasVariants(value) && !reflect.DeepEqual(before, v.resolved[slot]) {
+			changed = true
+		}
+	}
+	if changed {
+		v.markDirty()
+	}
+}
+
+// hasVariants reports whether a slot value carries env-conditional variants.
+func hasVariants(value SlotValue) bool {
+	switch sv := value.(type) {
+	case StyleSlot:
+		return len(sv.Variants) > 0
+	case ColorSlot:
+		return len(sv.Variants) > 0
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+package viewer
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultFrameInterval is the Scheduler's cadence when none is set: 60Hz.
+const DefaultFrameInterval = time.Second / 60
+
+// Scheduler flushes a Root on the app's behalf. Mutations made through
+// the Root (Update, Invalidate, State) coalesce until the next frame,
+// which is sent at most once per Interval, only when the viewer's flow
+// control allows it, and within Budget. Anything held back is sent on
+// following frames.
+//
+//	root := Mount(NewSourceState(), app)
+//	sched := NewScheduler(root, func(msgs []ProtocolMessage) error { ... })
+//	go sched.Run(ctx)
+//	root.Update(func() { app.count++ })
+type Scheduler struct {
+	// Interval is the minimum time between flushes. Zero means
+	// DefaultFrameInterval.
+	Interval time.Duration
+
+	// Idle, if set, delays a flush until no mutation has arrived for this
+	// long, so a burst of updates goes out as one frame once it settles.
+	Idle time.Duration
+
+	// Budget limits each flush.
+	Budget FlushBudget
+
+	root *Root
+	send func([]ProtocolMessage) error
+	wake chan struct{}
+
+	mu         sync.Mutex // guards hooks and lastChange
+	hooks      []func([]ProtocolMessage)
+	lastChange time.Time
+
+	stepMu sync.Mutex // serializes Step so frames go out in order
+}
+
+// NewScheduler creates a scheduler that flushes root and hands each
+// frame's messages to send. It takes over change notification for root;
+// a Root can be driven by only one Scheduler.
+func NewScheduler(root *Root, send func([]ProtocolMessage) error) *Scheduler {
+	s := &Scheduler{root: root, send: send, wake: make(chan struct{}, 1)}
+	root.mu.Lock()
+	root.notify = s.notify
+	root.mu.Unlock()
+	return s
+}
+
+// OnFlush registers a hook called with the messages of every frame after
+// they have been sent.
+func (s *Scheduler) OnFlush(fn func(msgs []ProtocolMessage)) {
+	s.mu.Lock()
+	s.hooks = append(s.hooks, fn)
+	s.mu.Unlock()
+}
+
+// notify records a mutation and wakes Run. Called with the Root locked.
+func (s *Scheduler) notify() {
+	s.mu.Lock()
+	s.lastChange = time.Now()
+	s.mu.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Step renders and flushes one frame immediately, ignoring Interval and
+// Idle, and returns the number of messages sent. It sends nothing if
+// flow control has no credit.
+func (s *Scheduler) Step() (int, error) {
+	s.stepMu.Lock()
+	defer s.stepMu.Unlock()
+	if _, canSend := s.root.pending(); !canSend {
+		return 0, nil
+	}
+	msgs := s.root.FlushWithin(s.Budget)
+	if len(msgs) == 0 {
+		return 0, nil
+	}
+	if err := s.send(msgs); err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	hooks := s.hooks
+	s.mu.Unlock()
+	for _, fn := range hooks {
+		fn(msgs)
+	}
+	return len(msgs), nil
+}
+
+// Run flushes frames until ctx is done or send fails. It sleeps while
+// there is nothing to send, or while the viewer has granted no credit.
+func (s *Scheduler) Run(ctx context.Context) error {
+	interval := s.Interval
+	if interval <= 0 {
+		interval = DefaultFrameInterval
+	}
+	var lastFlush time.Time
+	for {
+		pending, canSend := s.root.pending()
+		if !pending || !canSend {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-s.wake:
+			}
+			continue
+		}
+
+		wait := interval - time.Since(lastFlush)
+		if s.Idle > 0 {
+			s.mu.Lock()
+			quiet := s.Idle - time.Since(s.lastChange)
+			s.mu.Unlock()
+			wait = max(wait, quiet)
+		}
+		if wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			case <-s.wake:
+				// A new mutation may push the idle deadline back.
+				timer.Stop()
+			}
+			continue
+		}
+
+		if _, err := s.Step(); err != nil {
+			return err
+		}
+		lastFlush = time.Now()
+	}
+}
@@ -0,0 +1,460 @@
+package viewer
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Headless scripting: a line-based script language that drives a Viewer
+// against a live source, for end-to-end UI tests.
+//
+//	# comments and blank lines are ignored
+//	connect tcp 127.0.0.1:7000      # or: connect unix /tmp/app.sock
+//	connect exec ./app --flag       # source on the child's stdin/stdout
+//	resize 80 24
+//	wait-text "Count: 0" 2s         # timeout defaults to ScriptRunner.Timeout
+//	click "+"                       # target: "text" or #id
+//	type #4 "hello"
+//	press Enter #4                  # target optional
+//	expect-text "Count: 1"
+//	expect-no-text "Error"
+//	expect-projection golden.txt
+//	screenshot out.txt
+//	sleep 100ms
+//	close
+//
+// Arguments are separated by spaces; double-quoted arguments use Go
+// string syntax.
+
+// ScriptStep is one command of a script.
+type ScriptStep struct {
+	Line int
+	Cmd  string
+	Args []string
+}
+
+// Script is a parsed script.
+type Script struct {
+	Steps []ScriptStep
+}
+
+// ScriptError reports the step at which a script failed.
+type ScriptError struct {
+	Line int
+	Cmd  string
+	Err  error
+}
+
+func (e *ScriptError) Error() string {
+	return fmt.Sprintf("line %d: %s: %v", e.Line, e.Cmd, e.Err)
+}
+
+func (e *ScriptError) Unwrap() error { return e.Err }
+
+// ErrAssertion is wrapped by errors from failed expect-* steps.
+var ErrAssertion = errors.New("assertion failed")
+
+// scriptArity is the allowed argument count range per command.
+var scriptArity = map[string][2]int{
+	"connect":           {2, -1},
+	"resize":            {2, 2},
+	"wait-text":         {1, 2},
+	"click":             {1, 1},
+	"type":              {2, 2},
+	"press":             {1, 2},
+	"expect-text":       {1, 1},
+	"expect-no-text":    {1, 1},
+	"expect-projection": {1, 1},
+	"screenshot":        {1, 1},
+	"sleep":             {1, 1},
+	"close":             {0, 0},
+}
+
+// ParseScript reads a script, checking commands and argument counts.
+func ParseScript(r io.Reader) (*Script, error) {
+	s := &Script{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		args, err := splitScriptLine(scanner.Text())
+		if err != nil {
+			return nil, &ScriptError{Line: line, Err: err}
+		}
+		if len(args) == 0 {
+			continue
+		}
+		step := ScriptStep{Line: line, Cmd: args[0], Args: args[1:]}
+		arity, ok := scriptArity[step.Cmd]
+		if !ok {
+			return nil, &ScriptError{Line: line, Cmd: step.Cmd, Err: errors.New("unknown command")}
+		}
+		if len(step.Args) < arity[0] || (arity[1] >= 0 && len(step.Args) > arity[1]) {
+			return nil, &ScriptError{Line: line, Cmd: step.Cmd, Err: fmt.Errorf("wrong number of arguments (%d)", len(step.Args))}
+		}
+		s.Steps = append(s.Steps, step)
+	}
+	return s, scanner.Err()
+}
+
+// splitScriptLine splits a line into arguments, honoring quotes and
+// stripping comments. A "#" followed by a digit is a node target, not a
+// comment.
+func splitScriptLine(line string) ([]string, error) {
+	var args []string
+	for {
+		line = strings.TrimLeft(line, " \t")
+		if line == "" || (line[0] == '#' && (len(line) == 1 || line[1] < '0' || line[1] > '9')) {
+			return args, nil
+		}
+		if line[0] == '"' {
+			prefix, err := strconv.QuotedPrefix(line)
+			if err != nil {
+				return nil, fmt.Errorf("bad quoted argument: %s", line)
+			}
+			arg, _ := strconv.Unquote(prefix)
+			args = append(args, arg)
+			line = line[len(prefix):]
+			continue
+		}
+		end := strings.IndexAny(line, " \t")
+		if end < 0 {
+			end = len(line)
+		}
+		args = append(args, line[:end])
+		line = line[end:]
+	}
+}
+
+// ScriptRunner executes scripts against a Viewer.
+type ScriptRunner struct {
+	Viewer *Viewer
+
+	// Timeout bounds wait-text steps without an explicit timeout.
+	// Defaults to 5s.
+	Timeout time.Duration
+
+	// Dir resolves relative file paths (expect-projection, screenshot,
+	// connect exec). Defaults to the working directory.
+	Dir string
+
+	// Dial opens connections for connect steps. Defaults to net.Dial for
+	// tcp and unix, and a child process for exec.
+	Dial func(ctx context.Context, network string, args []string) (io.ReadWriteCloser, error)
+
+	// Log, if set, receives one line per completed step.
+	Log io.Writer
+
+	mu        sync.Mutex
+	conn      io.ReadWriteCloser
+	serveDone chan error
+	hooked    bool
+}
+
+// Run executes the script's steps in order, stopping at the first
+// failure, and closes any open connection before returning.
+func (r *ScriptRunner) Run(ctx context.Context, s *Script) error {
+	if !r.hooked {
+		r.Viewer.OnMessage(r.forward)
+		r.hooked = true
+	}
+	defer r.closeConn()
+
+	for _, step := range s.Steps {
+		if err := r.runStep(ctx, step); err != nil {
+			return &ScriptError{Line: step.Line, Cmd: step.Cmd, Err: err}
+		}
+		if r.Log != nil {
+			fmt.Fprintf(r.Log, "ok   %d: %s %s\n", step.Line, step.Cmd, strings.Join(step.Args, " "))
+		}
+	}
+	return nil
+}
+
+// runStep executes one step.
+func (r *ScriptRunner) runStep(ctx context.Context, step ScriptStep) error {
+	v := r.Viewer
+	args := step.Args
+
+	switch step.Cmd {
+	case "connect":
+		return r.connect(ctx, args[0], args[1:])
+
+	case "resize":
+		w, errW := strconv.Atoi(args[0])
+		h, errH := strconv.Atoi(args[1])
+		if errW != nil || errH != nil {
+			return fmt.Errorf("bad size %q × %q", args[0], args[1])
+		}
+		v.Resize(w, h)
+
+	case "wait-text":
+		timeout := r.Timeout
+		if timeout == 0 {
+			timeout = 5 * time.Second
+		}
+		if len(args) > 1 {
+			d, err := time.ParseDuration(args[1])
+			if err != nil {
+				return err
+			}
+			timeout = d
+		}
+		waitCtx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		err := v.WaitForCtx(waitCtx, func(tree *RenderTree) bool {
+			return strings.Contains(TextProjection(tree), args[0])
+		})
+		if errors.Is(err, context.DeadlineExceeded) {
+			return fmt.Errorf("%w: text %q did not appear within %s", ErrWaitTimeout, args[0], timeout)
+		}
+		return err
+
+	case "click":
+		id, err := r.locate(args[0], true)
+		if err != nil {
+			return err
+		}
+		v.SendInput(InputEvent{Target: &id, Kind: "click"})
+
+	case "type":
+		id, err := r.locate(args[0], false)
+		if err != nil {
+			return err
+		}
+		v.SendInput(InputEvent{Target: &id, Kind: "value_change", Value: args[1]})
+
+	case "press":
+		event := InputEvent{Kind: "key", Key: args[0]}
+		if len(args) > 1 {
+			id, err := r.locate(args[1], false)
+			if err != nil {
+				return err
+			}
+			event.Target = &id
+		}
+		v.SendInput(event)
+
+	case "expect-text", "expect-no-text":
+		found := strings.Contains(v.GetTextProjection(), args[0])
+		if want := step.Cmd == "expect-text"; found != want {
+			return fmt.Errorf("%w: text %q present = %v\n%s", ErrAssertion, args[0], found, v.GetTextProjection())
+		}
+
+	case "expect-projection":
+		want, err := os.ReadFile(r.path(args[0]))
+		if err != nil {
+			return err
+		}
+		got := v.GetTextProjection()
+		if strings.TrimRight(got, "\n") != strings.TrimRight(string(want), "\n") {
+			return fmt.Errorf("%w: projection differs from %s\ngot:\n%s", ErrAssertion, args[0], got)
+		}
+
+	case "screenshot":
+		return os.WriteFile(r.path(args[0]), []byte(v.Screenshot().Data), 0o644)
+
+	case "sleep":
+		d, err := time.ParseDuration(args[0])
+		if err != nil {
+			return err
+		}
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+	case "close":
+		return r.closeConn()
+	}
+	return nil
+}
+
+// connect opens the transport and starts serving it.
+func (r *ScriptRunner) connect(ctx context.Context, network string, args []string) error {
+	if err := r.closeConn(); err != nil {
+		return err
+	}
+
+	dial := r.Dial
+	if dial == nil {
+		dial = r.dial
+	}
+	conn, err := dial(ctx, network, args)
+	if err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	r.mu.Lock()
+	r.conn = conn
+	r.serveDone = done
+	r.mu.Unlock()
+
+	go func() { done <- r.Viewer.ServeCtx(ctx, conn) }()
+	return nil
+}
+
+// dial is the default transport: tcp, unix, or exec.
+func (r *ScriptRunner) dial(ctx context.Context, network string, args []string) (io.ReadWriteCloser, error) {
+	switch network {
+	case "tcp", "unix":
+		var d net.Dialer
+		return d.DialContext(ctx, network, args[0])
+	case "exec":
+		name := args[0]
+		if strings.ContainsRune(name, '/') {
+			name = r.path(name)
+		}
+		return startProcess(name, args[1:], r.Dir)
+	}
+	return nil, fmt.Errorf("unknown transport %q", network)
+}
+
+// forward writes the viewer's outbound messages to the connection.
+func (r *ScriptRunner) forward(msg ProtocolMessage) {
+	r.mu.Lock()
+	conn := r.conn
+	r.mu.Unlock()
+	if conn == nil {
+		return
+	}
+	if frame, err := EncodeFrame(&msg); err == nil {
+		conn.Write(frame)
+	}
+}
+
+// closeConn closes the connection, if any, and waits for serving to stop.
+func (r *ScriptRunner) closeConn() error {
+	r.mu.Lock()
+	conn, done := r.conn, r.serveDone
+	r.conn, r.serveDone = nil, nil
+	r.mu.Unlock()
+
+	if conn == nil {
+		return nil
+	}
+	conn.Close()
+	err := <-done
+	if errors.Is(err, net.ErrClosed) || errors.Is(err, os.ErrClosed) ||
+		errors.Is(err, io.ErrClosedPipe) || errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
+
+// locate resolves a target: "#id", or text matched against text node
+// content, input values and placeholders, and control labels. With
+// clickable set, a text match resolves to its nearest clickable ancestor
+// or control, if any.
+func (r *ScriptRunner) locate(spec string, clickable bool) (id int, err error) {
+	r.Viewer.withTree(func(tree *RenderTree) {
+		id, err = locateNode(tree, spec, clickable)
+	})
+	return id, err
+}
+
+// locateNode implements locate against a tree.
+func locateNode(tree *RenderTree, spec string, clickable bool) (int, error) {
+	if strings.HasPrefix(spec, "#") {
+		id, err := strconv.Atoi(spec[1:])
+		if err != nil {
+			return 0, fmt.Errorf("bad target %q", spec)
+		}
+		if _, ok := tree.NodeIndex[id]; !ok {
+			return 0, fmt.Errorf("no node %s", spec)
+		}
+		return id, nil
+	}
+
+	var path []*RenderNode
+	var search func(n *RenderNode) bool
+	search = func(n *RenderNode) bool {
+		path = append(path, n)
+		p := n.Props
+		if (p.Content != nil && strings.Contains(*p.Content, spec)) ||
+			(p.Value != nil && strings.Contains(*p.Value, spec)) ||
+			(p.Placeholder != nil && strings.Contains(*p.Placeholder, spec)) ||
+			(p.Label != nil && strings.Contains(*p.Label, spec)) {
+			return true
+		}
+		for _, child := range n.Children {
+			if search(child) {
+				return true
+			}
+		}
+		path = path[:len(path)-1]
+		return false
+	}
+	if tree.Root == nil || !search(tree.Root) {
+		return 0, fmt.Errorf("no node matches %q", spec)
+	}
+
+	if clickable {
+		for i := len(path) - 1; i >= 0; i-- {
+			if pressable(path[i]) {
+				return path[i].ID, nil
+			}
+		}
+	}
+	return path[len(path)-1].ID, nil
+}
+
+// withTree calls fn with the viewer locked.
+func (v *Viewer) withTree(fn func(tree *RenderTree)) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	fn(v.tree)
+}
+
+// path resolves a script-relative file path.
+func (r *ScriptRunner) path(p string) string {
+	if r.Dir == "" || filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(r.Dir, p)
+}
+
+// processConn is a child process speaking the protocol on stdin/stdout.
+type processConn struct {
+	cmd *exec.Cmd
+	io.Reader
+	io.WriteCloser
+}
+
+// startProcess runs name with args in dir.
+func startProcess(name string, args []string, dir string) (*processConn, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Dir = dir
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &processConn{cmd: cmd, Reader: stdout, WriteCloser: stdin}, nil
+}
+
+// Close closes the child's stdin, stops it, and reaps it.
+func (p *processConn) Close() error {
+	p.WriteCloser.Close()
+	p.cmd.Process.Kill()
+	p.cmd.Wait()
+	return nil
+}
@@ -0,0 +1,482 @@
+package viewer
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// Kinetic scrolling. Wheel and touch deltas given to Scroll set a scroll
+// node's velocity rather than jumping, and Tick — the viewer's animation
+// step, called once per display frame — moves the node and decays the
+// velocity exponentially. The velocity a delta adds is chosen so the
+// scroll coasts exactly that far, so a burst of wheel steps ends where
+// immediate scrolling would have. Every intermediate scrollTop is written
+// to the node, re-rendering it, and reported upstream as a scroll input
+// event.
+//
+// Positions are in input units (see inputLayout) — character cells when
+// the render target draws to a terminal, pixels otherwise — and clamped
+// to the node's content.
+// Headless viewers, and viewers with momentum turned off, apply deltas
+// immediately so tests and replays see the same scrollTop sequence
+// regardless of timing. ScrollIntoView always jumps. A focused scroll
+// node also scrolls with the arrow, page, home and end keys.
+//
+// Scroll nodes whose content overflows show a vertical scrollbar over the
+// right edge of their viewport: a line of cells with a block thumb on the
+// cell grid, a translucent 6-pixel bar in the rasterizer. Pointer input
+// drags it (see Pointer).
+//
+// Sticky children of a scroll node are pinned to the top of its viewport
+// by layout once scrolling would carry them above it, and drawn and hit
+// tested over the other children.
+
+// ScrollConfig configures kinetic scrolling.
+type ScrollConfig struct {
+	// Momentum animates deltas. When false, Scroll jumps immediately.
+	Momentum bool
+
+	// Friction is the velocity decay rate per second; higher values stop
+	// sooner. Defaults to 8, which settles a scroll in about half a
+	// second.
+	Friction float64
+}
+
+// DefaultScrollConfig returns the configuration new viewers use.
+func DefaultScrollConfig() ScrollConfig {
+	return ScrollConfig{Momentum: true, Friction: 8}
+}
+
+// scrollSettle is the remaining coast distance, in input units, below
+// which an animation snaps to its end.
+const scrollSettle = 0.5
+
+// scrollState is one scroll node's animation.
+type scrollState struct {
+	pos      float64 // unrounded scrollTop
+	velocity float64 // input units per second
+	last     int64   // clock time of the last step, in nanoseconds
+}
+
+// SetScrollConfig replaces the kinetic scrolling configuration. Turning
+// momentum off finishes running animations at once.
+func (v *Viewer) SetScrollConfig(cfg ScrollConfig) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if cfg.Friction <= 0 {
+		cfg.Friction = DefaultScrollConfig().Friction
+	}
+	v.scrollCfg = &cfg
+	if !cfg.Momentum {
+		for id, s := range v.scrolls {
+			v.scrollTo(id, s.pos+s.velocity/cfg.Friction)
+		}
+		v.scrolls = nil
+	}
+}
+
+// Scroll scrolls a scroll node by dy input units, as from a mouse wheel
+// or a touch drag; positive values scroll down. Returns false if the node
+// does not scroll. To route a wheel to the containers under the
+// pointer, nested ones first, use Pointer with PointerWheel.
+func (v *Viewer) Scroll(nodeID int, dy float64) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	node := v.tree.NodeIndex[nodeID]
+	if node == nil || !isScroller(node) {
+		return false
+	}
+	v.scrollBy(node, dy)
+	return true
+}
+
+// scrollBy is the body of Scroll.
+// Must be called with the mutex held.
+func (v *Viewer) scrollBy(node *RenderNode, dy float64) {
+	cfg := v.scrollConfig()
+	if !cfg.Momentum || v.renderTarget.TargetType() == "headless" {
+		v.scrollTo(node.ID, v.scrollTop(node)+dy)
+		return
+	}
+
+	s := v.scrolls[node.ID]
+	if s == nil {
+		s = &scrollState{pos: v.scrollTop(node), last: v.now().UnixNano()}
+		if v.scrolls == nil {
+			v.scrolls = make(map[int]*scrollState)
+		}
+		v.scrolls[node.ID] = s
+	}
+	s.velocity += dy * cfg.Friction
+}
+
+// scrollKey applies a key to the focused scroll node: the arrows scroll
+// it a line, page up and down its viewport less a line, and home and end
+// to its ends. Returns false if the key does not scroll it.
+// Must be called with the mutex held.
+func (v *Viewer) scrollKey(key string) bool {
+	if v.tree.Focused == nil {
+		return false
+	}
+	node := v.tree.NodeIndex[*v.tree.Focused]
+	if node == nil || !isScroller(node) {
+		return false
+	}
+	in := v.inputLayout()
+	view, maxTop, _ := scrollExtent(node, in.layouts, in.opts)
+	line := in.opts.LineHeight
+	page := math.Max(view.Height-line, line)
+	pos := v.scrollTarget(node)
+	to := pos
+	switch strings.ToLower(key) {
+	case "arrowup", "up":
+		to -= line
+	case "arrowdown", "down":
+		to += line
+	case "pageup":
+		to -= page
+	case "pagedown":
+		to += page
+	case "home":
+		to = 0
+	case "end":
+		to = maxTop
+	default:
+		return false
+	}
+	if to = math.Min(math.Max(to, 0), maxTop); to != pos {
+		v.scrollBy(node, to-pos)
+	}
+	return true
+}
+
+// scrollTarget returns where a scroll node will come to rest: its
+// scrollTop, or the end of its running animation.
+// Must be called with the mutex held.
+func (v *Viewer) scrollTarget(node *RenderNode) float64 {
+	if s := v.scrolls[node.ID]; s != nil {
+		return s.pos + s.velocity/v.scrollConfig().Friction
+	}
+	return v.scrollTop(node)
+}
+
+// Tick advances scroll animations, the cursor blink, progress indicators,
+// touch long presses and key repeat to the clock's current time,
+// updating the tree.
+// Returns whether any animation is still running; hosts call it every
+// frame until it returns false.
+func (v *Viewer) Tick() bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	k := v.scrollConfig().Friction
+	now := v.now().UnixNano()
+	for id, s := range v.scrolls {
+		if v.tree.NodeIndex[id] == nil {
+			delete(v.scrolls, id)
+			continue
+		}
+		dt := float64(now-s.last) / 1e9
+		s.last = now
+		if dt <= 0 {
+			continue
+		}
+		// Integrate v·e^(-kt) over the step.
+		decay := math.Exp(-k * dt)
+		s.pos += s.velocity / k * (1 - decay)
+		s.velocity *= decay
+		if math.Abs(s.velocity/k) < scrollSettle {
+			s.pos += s.velocity / k
+			s.velocity = 0
+		}
+		if pos := v.scrollTo(id, s.pos); pos != s.pos {
+			// Hit an end of the content.
+			s.velocity = 0
+		}
+		if s.velocity == 0 {
+			delete(v.scrolls, id)
+		}
+	}
+	blinking := v.blinkCursor()
+	progressing := v.trackProgress()
+	if progressing {
+		v.markDirty()
+		v.signalChanged()
+	}
+	waiting := v.showTooltip()
+	holding := v.checkLongPress()
+	repeating := v.checkKeyRepeat()
+	return len(v.scrolls) > 0 || blinking || progressing || waiting || holding || repeating
+}
+
+// scrollConfig returns the configuration in effect.
+// Must be called with the mutex held.
+func (v *Viewer) scrollConfig() ScrollConfig {
+	if v.scrollCfg == nil {
+		return DefaultScrollConfig()
+	}
+	return *v.scrollCfg
+}
+
+// scrollTop returns a node's current scrollTop.
+func (v *Viewer) scrollTop(node *RenderNode) float64 {
+	if node.Props.ScrollTop == nil {
+		return 0
+	}
+	return float64(*node.Props.ScrollTop)
+}
+
+// ScrollIntoView scrolls the scroll containers around a node by as
+// little as possible to bring the node into view, aligning the node's
+// top and left edges where it does not fit, and cancels any scroll
+// animations running there. The nearest container reveals the node; each
+// enclosing one then reveals the part of the node visible in the
+// container inside it. Returns false if the node does not exist or has
+// no scroll ancestor.
+func (v *Viewer) ScrollIntoView(nodeID int) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	in := v.inputLayout()
+	target := in.layouts[nodeID]
+	if v.tree.NodeIndex[nodeID] == nil || target == nil {
+		return false
+	}
+	// Layout positions ignore scrolling, so the node's offset within each
+	// container's content is fixed and only the scroll offsets move it.
+	r := *target
+	found := false
+	chain := ancestors(v.tree.Root, nodeID)
+	for i := len(chain) - 1; i >= 0; i-- {
+		scroller := chain[i]
+		if !isScroller(scroller) || in.layouts[scroller.ID] == nil {
+			continue
+		}
+		found = true
+		delete(v.scrolls, scroller.ID)
+
+		view, maxTop, maxLeft := scrollExtent(scroller, in.layouts, in.opts)
+		top := reveal(v.scrollTop(scroller), r.Y-view.Y, r.Height, view.Height, maxTop)
+		left := 0.0
+		if scroller.Props.ScrollLeft != nil {
+			left = float64(*scroller.Props.ScrollLeft)
+		}
+		left = reveal(left, r.X-view.X, r.Width, view.Width, maxLeft)
+		v.setScroll(scroller, top, &left)
+
+		// What shows of the node in this viewport, as laid out in the
+		// enclosing content.
+		r.Y -= math.Round(top)
+		r = intersectLayout(r, view)
+	}
+	return found
+}
+
+// intersectLayout returns the overlap of two rectangles, empty at a's
+// corner if they do not overlap.
+func intersectLayout(a, b ComputedLayout) ComputedLayout {
+	x0, y0 := math.Max(a.X, b.X), math.Max(a.Y, b.Y)
+	x1, y1 := math.Min(a.X+a.Width, b.X+b.Width), math.Min(a.Y+a.Height, b.Y+b.Height)
+	if x1 <= x0 || y1 <= y0 {
+		return ComputedLayout{X: a.X, Y: a.Y}
+	}
+	return ComputedLayout{X: x0, Y: y0, Width: x1 - x0, Height: y1 - y0}
+}
+
+// reveal returns the scroll offset nearest cur that shows the span
+// [start, start+size) of the content in a viewport of length view,
+// clamped to [0, limit].
+func reveal(cur, start, size, view, limit float64) float64 {
+	switch {
+	case start < cur || size > view:
+		cur = start
+	case start+size > cur+view:
+		cur = start + size - view
+	}
+	return math.Min(math.Max(cur, 0), limit)
+}
+
+// scrollTo clamps pos to a scroll node's content and scrolls it there
+// vertically. Returns the clamped position.
+// Must be called with the mutex held.
+func (v *Viewer) scrollTo(nodeID int, pos float64) float64 {
+	node := v.tree.NodeIndex[nodeID]
+	if node == nil {
+		return pos
+	}
+	in := v.inputLayout()
+	_, maxTop, _ := scrollExtent(node, in.layouts, in.opts)
+	pos = math.Min(math.Max(pos, 0), maxTop)
+	v.setScroll(node, pos, nil)
+	return pos
+}
+
+// setScroll rounds and sets a scroll node's scrollTop, and scrollLeft if
+// left is non-nil. If either changed it marks the viewer dirty and emits
+// a scroll event carrying the offsets set.
+// Must be called with the mutex held.
+func (v *Viewer) setScroll(node *RenderNode, top float64, left *float64) {
+	changed := false
+	set := func(p **int, pos float64) *int {
+		n := int(math.Round(pos))
+		if *p == nil && n != 0 || *p != nil && **p != n {
+			*p = &n
+			changed = true
+		}
+		return &n
+	}
+	id := node.ID
+	event := InputEvent{Target: &id, Kind: "scroll"}
+	event.ScrollTop = set(&node.Props.ScrollTop, top)
+	if left != nil {
+		event.ScrollLeft = set(&node.Props.ScrollLeft, *left)
+	}
+	if !changed {
+		return
+	}
+	v.markDirty()
+	v.signalChanged()
+	v.emit(ProtocolMessage{Type: MsgInput, Event: &event})
+}
+
+// scrollExtent returns a scroll node's viewport — its content box — and
+// how far its content overflows the viewport's bottom and right edges.
+// The content is the node's children, or the virtual size the source
+// declares if larger.
+func scrollExtent(node *RenderNode, layouts map[int]*ComputedLayout, opts LayoutOptions) (view ComputedLayout, maxTop, maxLeft float64) {
+	box := layouts[node.ID]
+	if box == nil {
+		return ComputedLayout{}, 0, 0
+	}
+	l := &layoutEngine{opts: opts}
+	p := node.Props
+	pad := l.resolveSpacing(p.Padding)
+	bw := l.borderWidth(node)
+	view = ComputedLayout{
+		X:      box.X + pad.left + bw,
+		Y:      box.Y + pad.top + bw,
+		Width:  math.Max(0, box.Width-pad.left-pad.right-2*bw),
+		Height: math.Max(0, box.Height-pad.top-pad.bottom-2*bw),
+	}
+	right, bottom := view.X+view.Width, view.Y+view.Height
+	for _, child := range paintOrder(node) {
+		if c := layouts[child.ID]; c != nil {
+			right = math.Max(right, c.X+c.Width)
+			bottom = math.Max(bottom, c.Y+c.Height)
+		}
+	}
+	if p.VirtualWidth != nil {
+		right = math.Max(right, view.X+float64(*p.VirtualWidth))
+	}
+	if p.VirtualHeight != nil {
+		bottom = math.Max(bottom, view.Y+float64(*p.VirtualHeight))
+	}
+	return view, bottom - (view.Y + view.Height), right - (view.X + view.Width)
+}
+
+// scrollbarWidth is the thickness of a scrollbar: one cell, or 6 pixels.
+func scrollbarWidth(opts LayoutOptions) float64 {
+	if opts.Round {
+		return 1
+	}
+	return 6
+}
+
+// scrollbar returns the track and thumb of a scroll node's vertical
+// scrollbar, which overlays the right edge of its viewport, or ok false
+// if the content fits. The thumb's length is the visible fraction of the
+// content, but no shorter than the bar is wide.
+func scrollbar(node *RenderNode, layouts map[int]*ComputedLayout, opts LayoutOptions) (track, thumb ComputedLayout, ok bool) {
+	view, maxTop, _ := scrollExtent(node, layouts, opts)
+	if maxTop <= 0 || view.Width <= 0 || view.Height <= 0 {
+		return ComputedLayout{}, ComputedLayout{}, false
+	}
+	w := math.Min(scrollbarWidth(opts), view.Width)
+	track = ComputedLayout{X: view.X + view.Width - w, Y: view.Y, Width: w, Height: view.Height}
+
+	size := math.Max(view.Height*view.Height/(view.Height+maxTop), math.Min(w, view.Height))
+	frac := 0.0
+	if node.Props.ScrollTop != nil {
+		frac = math.Min(math.Max(float64(*node.Props.ScrollTop)/maxTop, 0), 1)
+	}
+	if opts.Round {
+		size = math.Max(1, math.Round(size))
+	}
+	pos := (view.Height - size) * frac
+	if opts.Round {
+		pos = math.Round(pos)
+	}
+	thumb = ComputedLayout{X: track.X, Y: view.Y + pos, Width: w, Height: size}
+	return track, thumb, true
+}
+
+// scrollbar draws a scroll node's scrollbar over the right column of its
+// viewport, dy rows up: a faint line for the track and a block for the
+// thumb.
+func (d *gridDrawer) scrollbar(track, thumb ComputedLayout, dy int, style CellStyle, clip rect) {
+	x := int(track.X)
+	for y := int(track.Y); y < int(track.Y+track.Height); y++ {
+		ch, st := '│', CellStyle{FG: style.FG, BG: style.BG, Faint: true}
+		if float64(y) >= thumb.Y && float64(y) < thumb.Y+thumb.Height {
+			ch, st = '█', CellStyle{FG: style.FG, BG: style.BG}
+		}
+		d.set(x, y-dy, ch, st, clip)
+	}
+}
+
+// scrollbar draws a scroll node's scrollbar in its text color, the track
+// faint and the thumb stronger.
+func (d *rasterDrawer) scrollbar(n rasterNode, clip image.Rectangle) {
+	src := image.NewUniform(rgba(n.style.FG, rasterForeground))
+	for _, bar := range []struct {
+		r     image.Rectangle
+		alpha uint8
+	}{{n.track, 0x20}, {n.thumb, 0x80}} {
+		draw.DrawMask(d.img, bar.r.Intersect(clip), src, image.Point{}, image.NewUniform(color.Alpha{bar.alpha}), image.Point{}, draw.Over)
+	}
+}
+
+// barRect converts a scrollbar rectangle to pixels drawn dy pixels up.
+func barRect(l ComputedLayout, dy int) image.Rectangle {
+	return image.Rect(int(l.X), int(l.Y)-dy, int(l.X+l.Width), int(l.Y+l.Height)-dy)
+}
+
+// isSticky reports whether a node has the sticky prop.
+func isSticky(node *RenderNode) bool {
+	return node.Props.Sticky != nil && *node.Props.Sticky
+}
+
+// paintOrder returns a container's children in drawing order, leaving
+// out hidden children and overlays: a scroll node's sticky children come after the content
+// scrolling under them, and absolutely positioned children come last.
+func paintOrder(node *RenderNode) []*RenderNode {
+	children := flowChildren(node)
+	var last []*RenderNode
+	if isScroller(node) {
+		for _, child := range children {
+			if isSticky(child) {
+				last = append(last, child)
+			}
+		}
+	}
+	for _, child := range node.Children {
+		if isAbsolute(child) && !isHidden(child) {
+			last = append(last, child)
+		}
+	}
+	if len(last) == 0 {
+		return children
+	}
+	order := make([]*RenderNode, 0, len(children)+len(last))
+	for _, child := range children {
+		if !isScroller(node) || !isSticky(child) {
+			order = append(order, child)
+		}
+	}
+	return append(order, last...)
+}
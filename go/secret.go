@@ -0,0 +1,157 @@
+package viewer
+
+import "strings"
+
+// Secret inputs. An input with the secret prop holds a password or the
+// like: renderers draw its value as one secretBullet per grapheme cluster,
+// keeping line breaks, and text projections and screenshots show the same
+// bullets. The real value goes only to the source, in the input's events.
+// Copying or cutting from a secret input does nothing.
+//
+// SetRedactSecrets goes further for hosts that log or share projections:
+// screenshots and text projections then show secret inputs as
+// redactedText, giving away not even the value's length. What is drawn
+// on screen still shows the bullets, so the user can see their typing.
+
+// secretBullet stands for each character of a secret value.
+const secretBullet = "•"
+
+// redactedText replaces secret values in redacted projections.
+const redactedText = "[redacted]"
+
+// isSecret reports whether an input hides its value.
+func isSecret(node *RenderNode) bool {
+	return node.Props.Secret != nil && *node.Props.Secret
+}
+
+// maskValue returns value with each grapheme cluster but line breaks
+// replaced by a bullet.
+func maskValue(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); {
+		if value[i] == '\n' {
+			b.WriteByte('\n')
+			i++
+			continue
+		}
+		n, _ := nextGrapheme(value[i:])
+		b.WriteString(secretBullet)
+		i += max(n, 1)
+	}
+	return b.String()
+}
+
+// shownValue returns an input's value as renderers draw it.
+func shownValue(node *RenderNode) string {
+	if isSecret(node) {
+		return maskValue(inputValue(node))
+	}
+	return inputValue(node)
+}
+
+// projectedValue returns an input's value as projections show it, or
+// redactedText if it is secret and redact is set.
+func projectedValue(node *RenderNode, redact bool) string {
+	if isSecret(node) && redact {
+		return redactedText
+	}
+	return shownValue(node)
+}
+
+// shownOffset converts an offset into an input's value to the same place
+// in its shown value.
+func shownOffset(node *RenderNode, at int) int {
+	if !isSecret(node) {
+		return at
+	}
+	value := inputValue(node)
+	return len(maskValue(value[:min(at, len(value))]))
+}
+
+// valueOffset converts an offset into an input's shown value back to the
+// same place in its value.
+func valueOffset(node *RenderNode, at int) int {
+	if !isSecret(node) {
+		return at
+	}
+	value := inputValue(node)
+	shown := 0
+	for i := 0; i < len(value); {
+		if shown >= at {
+			return i
+		}
+		next := nextCluster(value, i)
+		if value[i] == '\n' {
+			next = i + 1
+			shown++
+		} else {
+			shown += len(secretBullet)
+		}
+		i = next
+	}
+	return len(value)
+}
+
+// shown returns an input's shown value with the cursor's offset
+// and selection converted to it. A composition is shown at the cursor,
+// with the cursor where the IME has it.
+func (c *TextCursor) shown(node *RenderNode) (value string, at, start, end int) {
+	raw := inputValue(node)
+	start, end = c.selection(len(raw))
+	at = min(c.Offset, len(raw))
+	value, at, start, end = shownValue(node), shownOffset(node, at), shownOffset(node, start), shownOffset(node, end)
+	if c.composing() {
+		comp, cur := c.Composition, c.CompositionCursor
+		if isSecret(node) {
+			comp, cur = maskValue(comp), len(maskValue(comp[:cur]))
+		}
+		value = value[:at] + comp + value[at:]
+		at += cur
+		start, end = at, at
+	}
+	return value, at, start, end
+}
+
+// composition returns where the composition is in the value shown by
+// shown, or an empty range if there is none.
+func (c *TextCursor) composition(node *RenderNode) (start, end int) {
+	if !c.composing() {
+		return 0, 0
+	}
+	raw := inputValue(node)
+	start = shownOffset(node, min(c.Offset, len(raw)))
+	if isSecret(node) {
+		return start, start + len(maskValue(c.Composition))
+	}
+	return start, start + len(c.Composition)
+}
+
+// inputShown returns an input's value as drawn, with any composition in
+// it.
+func (d *gridDrawer) inputShown(node *RenderNode) string {
+	if c := d.cursor; c != nil && c.Node == node.ID && c.composing() {
+		value, _, _, _ := c.shown(node)
+		return value
+	}
+	return shownValue(node)
+}
+
+// SetRedactSecrets sets whether screenshots and text projections leave
+// out the values of secret inputs entirely instead of showing bullets.
+func (v *Viewer) SetRedactSecrets(redact bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.redactSecrets = redact
+	v.markDirty()
+	v.signalChanged()
+}
+
+// projectionOptions returns the default text projection options with
+// the viewer's redaction applied.
+// Must be called with the mutex held.
+func (v *Viewer) projectionOptions() TextProjectionOptions {
+	opts := DefaultTextProjectionOptions()
+	opts.RedactSecrets = v.redactSecrets
+	return opts
+}
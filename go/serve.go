@@ -0,0 +1,235 @@
+package viewer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrWaitTimeout is returned by WaitFor when the condition is not met in time.
+var ErrWaitTimeout = errors.New("wait condition not met before timeout")
+
+// ProcessMessageCtx is ProcessMessage with cancellation: it returns
+// ctx.Err() without touching state if ctx is already done. It fails fast
+// with an *IncompatibleError once the source has declared requirements
+// the viewer cannot meet.
+func (v *Viewer) ProcessMessageCtx(ctx context.Context, msg ProtocolMessage) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	// The context may have been cancelled while we waited for the lock.
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	v.processMessage(msg)
+	return v.incompatible
+}
+
+// serveMessage is ProcessMessageCtx for a served message, which goes
+// through the inbound queue instead when flow control is enabled.
+func (v *Viewer) serveMessage(ctx context.Context, msg ProtocolMessage) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	v.enqueue(msg)
+	return v.incompatible
+}
+
+// Serve reads framed messages from r and processes them until r returns
+// an error. io.EOF is reported as a nil error.
+func (v *Viewer) Serve(r io.Reader) error {
+	return v.ServeCtx(context.Background(), r)
+}
+
+// ServeCtx is Serve with cancellation. When ctx is done, r is closed if it
+// implements io.Closer (unblocking any pending read) and ctx.Err() is
+// returned. Frames whose payload fails to decode are skipped, counted
+// in ViewerMetrics.DecodeErrors, and followed by a refresh request (see
+// RequestRefresh). With flow control enabled (see EnableFlowControl),
+// messages are queued rather than processed, for the host to Pump.
+//
+// Sequence tracking starts over with each call. If the environment is
+// known, the handshake (MsgEnv) is sent before the first read, followed
+// by a refresh request if the viewer holds state from an earlier
+// connection. Serving stops with an *IncompatibleError if the source
+// declares requirements the viewer cannot meet, with
+// ErrHeartbeatTimeout if a heartbeat is enabled (see EnableHeartbeat) and
+// the source stops answering pings, and with ErrFrameTooLarge or
+// ErrByteRateExceeded when the source passes a connection quota (see
+// Quotas).
+func (v *Viewer) ServeCtx(ctx context.Context, r io.Reader) error {
+	if err := v.Handshake(); err != nil && !errors.Is(err, ErrNoEnv) {
+		return err
+	}
+	v.reconnected()
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	go v.runTimers(ctx, cancel)
+
+	fr := NewFrameReader()
+	fr.MaxFrameBytes = v.maxFrameBytes()
+	err := serveFrames(ctx, r, fr, v.trackRead, func(f Frame) error {
+		msg, err := DecodeMessage(f.Header, f.Payload)
+		if err != nil {
+			v.decodeFailed(f.Header.Type, err)
+			return nil
+		}
+		return v.serveMessage(ctx, msg)
+	})
+	if cause := context.Cause(ctx); errors.Is(cause, ErrHeartbeatTimeout) {
+		return cause
+	}
+	return err
+}
+
+// runTimers sends heartbeat pings and metrics reports while a connection
+// is served, until ctx is done, cancelling it with ErrHeartbeatTimeout if
+// the source stops answering pings.
+func (v *Viewer) runTimers(ctx context.Context, cancel context.CancelCauseFunc) {
+	// Check often enough that nothing runs late by more than a fraction
+	// of its period.
+	v.mu.Lock()
+	var period time.Duration
+	if hb := v.heartbeat; hb != nil {
+		period = min(hb.cfg.Interval, hb.cfg.Timeout) / 4
+	}
+	if r := v.metricsReport; r != nil && (period == 0 || r.interval/4 < period) {
+		period = r.interval / 4
+	}
+	v.mu.Unlock()
+	if period <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		if err := v.checkHeartbeat(); err != nil {
+			cancel(err)
+			return
+		}
+		v.checkMetricsReport()
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// serveFrames reads from r into fr until EOF, an error, or ctx is done,
+// passing the byte count of each read and the bytes already buffered to
+// onBytes (if non-nil), which may fail the read, and each complete
+// frame to onFrame. If r implements io.Closer it is closed when ctx is
+// done to unblock a pending read. io.EOF is reported as a nil error.
+func serveFrames(ctx context.Context, r io.Reader, fr *FrameReader, onBytes func(n, buffered int) error, onFrame func(Frame) error) error {
+	if closer, ok := r.(io.Closer); ok {
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			select {
+			case <-ctx.Done():
+				closer.Close()
+			case <-stop:
+			}
+		}()
+	}
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, readErr := r.Read(buf)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if n > 0 {
+			if onBytes != nil {
+				if err := onBytes(n, fr.PendingBytes()); err != nil {
+					return err
+				}
+			}
+			frames, err := fr.Feed(buf[:n])
+			if err != nil {
+				return err
+			}
+			for _, f := range frames {
+				if err := onFrame(f); err != nil {
+					return err
+				}
+			}
+		}
+
+		if readErr != nil {
+			if errors.Is(readErr, io.EOF) {
+				return nil
+			}
+			return readErr
+		}
+	}
+}
+
+// WaitFor blocks until cond returns true for the current render tree, or
+// until timeout elapses. cond is evaluated with the viewer locked and
+// must not call other Viewer methods.
+func (v *Viewer) WaitFor(cond func(tree *RenderTree) bool, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := v.WaitForCtx(ctx, cond)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrWaitTimeout
+	}
+	return err
+}
+
+// WaitForCtx blocks until cond returns true for the current render tree,
+// re-evaluating after every processed message, or until ctx is done.
+func (v *Viewer) WaitForCtx(ctx context.Context, cond func(tree *RenderTree) bool) error {
+	for {
+		v.mu.Lock()
+		ok := cond(v.tree)
+		changed := v.changedChan()
+		v.mu.Unlock()
+
+		if ok {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// changedChan returns a channel closed at the next state change.
+// Must be called with the mutex held.
+func (v *Viewer) changedChan() <-chan struct{} {
+	if v.changed == nil {
+		v.changed = make(chan struct{})
+	}
+	return v.changed
+}
+
+// signalChanged wakes all WaitFor callers.
+// Must be called with the mutex held.
+func (v *Viewer) signalChanged() {
+	if v.changed != nil {
+		close(v.changed)
+		v.changed = nil
+	}
+}
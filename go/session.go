@@ -0,0 +1,209 @@
+package viewer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// Mux multiplexes several logical viewports over one transport connection.
+// Each session ID in a version 2 frame header addresses its own Viewer,
+// with its own tree, slots, and metrics. Frames without session context
+// (version 1, or SessionNone) go to session 0.
+//
+// It is safe for concurrent use.
+type Mux struct {
+	mu sync.Mutex
+
+	sessions     map[uint64]*Viewer
+	newViewer    func(session uint64) *Viewer
+	handlers     []func(session uint64, msg ProtocolMessage)
+	decodeErrors int
+	maxSessions  int
+	rejected     int
+}
+
+// NewMux creates a session multiplexer. newViewer is called to create the
+// Viewer for each session the first time it is seen; if nil, headless
+// viewers are created.
+func NewMux(newViewer func(session uint64) *Viewer) *Mux {
+	if newViewer == nil {
+		newViewer = func(uint64) *Viewer { return NewViewer(HeadlessTarget{}) }
+	}
+	return &Mux{
+		sessions:  make(map[uint64]*Viewer),
+		newViewer: newViewer,
+	}
+}
+
+// Session returns the Viewer for a session, creating it if needed. A new
+// session's outbound messages are forwarded to the Mux handlers, and its
+// handshake is sent if the viewer already knows its environment.
+func (m *Mux) Session(id uint64) *Viewer {
+	v, _ := m.session(id, false)
+	return v
+}
+
+// session is Session, but if limited it returns false instead of
+// opening a session past the limit set by SetMaxSessions.
+func (m *Mux) session(id uint64, limited bool) (*Viewer, bool) {
+	m.mu.Lock()
+	v, ok := m.sessions[id]
+	if !ok {
+		if limited && m.maxSessions > 0 && len(m.sessions) >= m.maxSessions {
+			m.rejected++
+			m.mu.Unlock()
+			return nil, false
+		}
+		v = m.newViewer(id)
+		m.sessions[id] = v
+	}
+	m.mu.Unlock()
+
+	if !ok {
+		v.OnMessage(func(msg ProtocolMessage) { m.emit(id, msg) })
+		_ = v.Handshake() // ErrNoEnv just means there is nothing to announce yet
+	}
+	return v, true
+}
+
+// SetMaxSessions limits the sessions frames may open. A frame for a new
+// session past the limit is dropped, counted, and reported to the source
+// on that session with a quota MsgError. Zero means unlimited. Sessions
+// opened by calling Session are not limited.
+func (m *Mux) SetMaxSessions(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.maxSessions = n
+}
+
+// Lookup returns the Viewer for an existing session.
+func (m *Mux) Lookup(id uint64) (*Viewer, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v, ok := m.sessions[id]
+	return v, ok
+}
+
+// Sessions returns the IDs of all open sessions in ascending order.
+func (m *Mux) Sessions() []uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ids := make([]uint64, 0, len(m.sessions))
+	for id := range m.sessions {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// CloseSession destroys a session's Viewer and forgets it. Returns false
+// if the session does not exist.
+func (m *Mux) CloseSession(id uint64) bool {
+	m.mu.Lock()
+	v, ok := m.sessions[id]
+	delete(m.sessions, id)
+	m.mu.Unlock()
+
+	if ok {
+		v.Destroy()
+	}
+	return ok
+}
+
+// OnMessage registers a callback for outbound messages from any session.
+// Use EncodeSessionFrame to put them on the wire.
+func (m *Mux) OnMessage(handler func(session uint64, msg ProtocolMessage)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.handlers = append(m.handlers, handler)
+}
+
+// DecodeErrors returns the number of frames dropped because their payload
+// failed to decode.
+func (m *Mux) DecodeErrors() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.decodeErrors
+}
+
+// RejectedFrames returns the number of frames dropped because they would
+// have opened a session past the limit set by SetMaxSessions.
+func (m *Mux) RejectedFrames() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.rejected
+}
+
+// ProcessFrame decodes a frame and dispatches it to its session. A frame
+// that fails to decode is dropped, counted by both the Mux and the
+// session, and reported to the source, and the session asks for a
+// refresh (see Viewer.RequestRefresh). A session whose source
+// requirements cannot be met is reported through that Viewer's
+// Compatible method rather than failing the whole connection, and so is
+// a frame past the session limit (see SetMaxSessions).
+func (m *Mux) ProcessFrame(ctx context.Context, f Frame) error {
+	v, ok := m.session(f.Header.Session, true)
+	if !ok {
+		m.mu.Lock()
+		limit := m.maxSessions
+		m.mu.Unlock()
+		m.emit(f.Header.Session, ProtocolMessage{Type: MsgError, Error: &ErrorReport{
+			Code:    ErrorCodeQuota,
+			Message: fmt.Sprintf("%s: %d exceeds limit %d", QuotaSessions, limit+1, limit),
+			MsgType: f.Header.Type,
+		}})
+		return nil
+	}
+	v.TrackBytes(f.Header.Size() + len(f.Payload))
+	msg, err := DecodeMessage(f.Header, f.Payload)
+	if err != nil {
+		m.mu.Lock()
+		m.decodeErrors++
+		m.mu.Unlock()
+		v.decodeFailed(f.Header.Type, err)
+		return nil
+	}
+
+	err = v.ProcessMessageCtx(ctx, msg)
+	var incompatible *IncompatibleError
+	if errors.As(err, &incompatible) {
+		return nil
+	}
+	return err
+}
+
+// Serve reads session frames from r until EOF.
+func (m *Mux) Serve(r io.Reader) error {
+	return m.ServeCtx(context.Background(), r)
+}
+
+// ServeCtx reads session frames from r and dispatches them until EOF, an
+// error, or ctx is done (see Viewer.ServeCtx). Sessions left from an
+// earlier connection start sequence tracking over and ask for a refresh.
+func (m *Mux) ServeCtx(ctx context.Context, r io.Reader) error {
+	for _, id := range m.Sessions() {
+		if v, ok := m.Lookup(id); ok {
+			v.reconnected()
+		}
+	}
+	return serveFrames(ctx, r, NewFrameReader(), nil, func(f Frame) error {
+		return m.ProcessFrame(ctx, f)
+	})
+}
+
+// emit forwards an outbound message from a session to all handlers.
+func (m *Mux) emit(session uint64, msg ProtocolMessage) {
+	m.mu.Lock()
+	handlers := make([]func(uint64, ProtocolMessage), len(m.handlers))
+	copy(handlers, m.handlers)
+	m.mu.Unlock()
+
+	for _, h := range handlers {
+		h(session, msg)
+	}
+}
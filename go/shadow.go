@@ -0,0 +1,120 @@
+package viewer
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+// Drop shadows. A node's shadow is its rectangle offset by (x, y) and
+// blurred by blur pixels, painted before the node itself.
+//
+// The rasterizer composites the shadow color with the coverage of a box
+// blur of the rectangle, computed exactly per pixel since a box-blurred
+// rectangle is the product of two blurred intervals. Unblurred shadows
+// of nodes with rounded corners are rounded too. The cell grid shades
+// the part of the offset rectangle outside the node: blank cells get a
+// light shade character in the shadow color and text there is made faint.
+// Offsets round away from zero to whole cells, and a blurred shadow with
+// no offset shades one cell right and below.
+
+// shadowAlpha is the opacity of a shadow with no color.
+const shadowAlpha = 0x60
+
+// shadowRect returns the area a shadow paints for a node at r.
+func shadowRect(s ShadowStyle, r image.Rectangle) image.Rectangle {
+	return r.Add(image.Pt(s.X, s.Y)).Inset(-max(s.Blur, 0))
+}
+
+// shadow composites a node's shadow onto the image, clipped.
+func (d *rasterDrawer) shadow(n rasterNode, clip image.Rectangle) {
+	area := shadowRect(n.shadow, n.rect).Intersect(clip)
+	if area.Empty() {
+		return
+	}
+	src := color.RGBA{0, 0, 0, shadowAlpha}
+	if n.shadow.Color != "" {
+		src = rgba(n.shadow.Color, src)
+	}
+
+	sr := n.rect.Add(image.Pt(n.shadow.X, n.shadow.Y))
+	blur := max(n.shadow.Blur, 0)
+	if blur == 0 && n.radius > 0 {
+		draw.DrawMask(d.img, area, image.NewUniform(src), image.Point{}, roundedMask(sr, n.radius, 0, area), area.Min, draw.Over)
+		return
+	}
+	ax := make([]float64, area.Dx())
+	for i := range ax {
+		ax[i] = coverage(area.Min.X+i, sr.Min.X, sr.Max.X, blur)
+	}
+	mask := image.NewAlpha(area)
+	for y := area.Min.Y; y < area.Max.Y; y++ {
+		ay := coverage(y, sr.Min.Y, sr.Max.Y, blur)
+		for i, a := range ax {
+			mask.SetAlpha(area.Min.X+i, y, color.Alpha{uint8(a*ay*255 + 0.5)})
+		}
+	}
+	draw.DrawMask(d.img, area, image.NewUniform(src), image.Point{}, mask, area.Min, draw.Over)
+}
+
+// coverage returns how much of pixel p lies in [lo, hi) after a box blur
+// of the given radius.
+func coverage(p, lo, hi, blur int) float64 {
+	if blur == 0 {
+		if p >= lo && p < hi {
+			return 1
+		}
+		return 0
+	}
+	c := float64(p) + 0.5
+	b := float64(blur)
+	overlap := min(float64(hi), c+b) - max(float64(lo), c-b)
+	return max(0, overlap) / (2 * b)
+}
+
+// shadow shades the cells of a node's shadow that the node at r does not
+// cover.
+func (d *gridDrawer) shadow(node *RenderNode, r rect, clip rect) {
+	s := node.Props.Shadow
+	if s == nil || r.w <= 0 || r.h <= 0 {
+		return
+	}
+	opts := CellLayoutOptions()
+	dx, dy := shadowCells(s.X, opts.PixelWidth), shadowCells(s.Y, opts.PixelHeight)
+	if dx == 0 && dy == 0 {
+		if s.Blur <= 0 {
+			return
+		}
+		dx, dy = 1, 1
+	}
+	fg := d.color(s.Color)
+	area := rect{r.x + dx, r.y + dy, r.w, r.h}.intersect(clip).intersect(rect{0, 0, d.grid.Width, d.grid.Height})
+	for y := area.y; y < area.y+area.h; y++ {
+		for x := area.x; x < area.x+area.w; x++ {
+			if r.contains(x, y) {
+				continue
+			}
+			c := &d.grid.Cells[y][x]
+			if c.Ch == ' ' && c.Cluster == "" {
+				c.Ch = '░'
+				if fg != "" {
+					c.Style.FG = fg
+				}
+			} else {
+				c.Style.Faint = true
+			}
+		}
+	}
+}
+
+// shadowCells converts a shadow offset in pixels to cells of the given
+// size, rounding away from zero.
+func shadowCells(px int, cell float64) int {
+	n := int(math.Ceil(math.Abs(float64(px)) / cell))
+	if px < 0 {
+		return -n
+	}
+	return n
+}
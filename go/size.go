@@ -0,0 +1,288 @@
+package viewer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Sizes. A width or height prop, and a positioned node's offsets, are a
+// number in layout units or a string: a number, a percentage of the
+// parent's content box along the same axis ("50%"), a percentage of the
+// viewport's width or height ("100vw", "50vh"), a count of character
+// widths or line heights ("2ch", "3lh"), or arithmetic on these with +,
+// -, *, /, and parentheses ("100% - 20", "calc(50% + 2ch)"). The root
+// and overlays take percentages of the screen, grid children of their
+// cells, and positioned nodes of the box they are placed in.
+//
+// A size in any unit is as fixed as a number, so it takes precedence over
+// flex: a flex container shares out only the space its sized children
+// leave, whatever they are sized in.
+//
+// Size strings are parsed into expression trees when the tree or a patch
+// setting them is decoded, and cached by their text, so layout evaluates
+// them against the current parent and viewport without parsing. Props
+// keep the text, so trees encode and diff as they were sent.
+
+// Length units.
+const (
+	unitPercent = "%"
+	unitVW      = "vw"
+	unitVH      = "vh"
+	unitCH      = "ch"
+	unitLH      = "lh"
+)
+
+// sizeUnits are the units a number in a size string may carry.
+var sizeUnits = []string{unitPercent, unitVW, unitVH, unitCH, unitLH}
+
+// maxSizeExprs is how many parsed size strings are cached before the
+// cache starts over.
+const maxSizeExprs = 4096
+
+// sizeExprs caches parsed size strings by their text.
+var sizeExprs = struct {
+	sync.Mutex
+	m map[string]*sizeExpr
+}{m: make(map[string]*sizeExpr)}
+
+// sizeExpr is a parsed size: a length, or an operation on two sizes.
+type sizeExpr struct {
+	n    float64
+	unit string // a length's unit; "" for layout units
+	op   byte   // '+', '-', '*', or '/'; 0 for a length
+	a, b *sizeExpr
+}
+
+// parseSize reads a size prop.
+func parseSize(v interface{}) (*sizeExpr, bool) {
+	if n, ok := toFloat(v); ok {
+		return &sizeExpr{n: n}, true
+	}
+	s, ok := v.(string)
+	if !ok {
+		return nil, false
+	}
+	sizeExprs.Lock()
+	defer sizeExprs.Unlock()
+	if e, ok := sizeExprs.m[s]; ok {
+		return e, e != nil
+	}
+	e := parseSizeString(s)
+	if len(sizeExprs.m) >= maxSizeExprs {
+		sizeExprs.m = make(map[string]*sizeExpr)
+	}
+	sizeExprs.m[s] = e
+	return e, e != nil
+}
+
+// decodeSizes parses a node's size props into the cache.
+func decodeSizes(p *NodeProps) {
+	for _, v := range []interface{}{p.Width, p.Height, p.Top, p.Right, p.Bottom, p.Left} {
+		if v != nil {
+			parseSize(v)
+		}
+	}
+}
+
+// parseSizeString parses a size string, or returns nil if it is not one.
+func parseSizeString(s string) *sizeExpr {
+	s = strings.TrimSpace(s)
+	if inner, ok := strings.CutPrefix(s, "calc("); ok {
+		if s, ok = strings.CutSuffix(inner, ")"); !ok {
+			return nil
+		}
+	}
+	p := &sizeParser{s: s}
+	e := p.expr()
+	if e == nil || p.peek() != 0 {
+		return nil
+	}
+	return e
+}
+
+// sizeParser is a recursive descent parser for size strings.
+type sizeParser struct {
+	s string
+	i int
+}
+
+// peek returns the next character past spaces, or 0 at the end.
+func (p *sizeParser) peek() byte {
+	for p.i < len(p.s) && p.s[p.i] == ' ' {
+		p.i++
+	}
+	if p.i == len(p.s) {
+		return 0
+	}
+	return p.s[p.i]
+}
+
+// expr parses terms added or subtracted.
+func (p *sizeParser) expr() *sizeExpr {
+	e := p.term()
+	for c := p.peek(); e != nil && (c == '+' || c == '-'); c = p.peek() {
+		p.i++
+		e = sizeOp(c, e, p.term())
+	}
+	return e
+}
+
+// term parses factors multiplied or divided.
+func (p *sizeParser) term() *sizeExpr {
+	e := p.factor()
+	for c := p.peek(); e != nil && (c == '*' || c == '/'); c = p.peek() {
+		p.i++
+		e = sizeOp(c, e, p.factor())
+	}
+	return e
+}
+
+// factor parses a length, a negated factor, or a parenthesized
+// expression.
+func (p *sizeParser) factor() *sizeExpr {
+	switch p.peek() {
+	case '(':
+		p.i++
+		e := p.expr()
+		if e == nil || p.peek() != ')' {
+			return nil
+		}
+		p.i++
+		return e
+	case '-':
+		p.i++
+		return sizeOp('-', &sizeExpr{}, p.factor())
+	}
+	start := p.i
+	for p.i < len(p.s) && (p.s[p.i] >= '0' && p.s[p.i] <= '9' || p.s[p.i] == '.') {
+		p.i++
+	}
+	n, err := strconv.ParseFloat(p.s[start:p.i], 64)
+	if err != nil {
+		return nil
+	}
+	for _, u := range sizeUnits {
+		if strings.HasPrefix(p.s[p.i:], u) {
+			p.i += len(u)
+			return &sizeExpr{n: n, unit: u}
+		}
+	}
+	return &sizeExpr{n: n}
+}
+
+// sizeOp returns the operation op on a and b, or nil if either is
+// missing.
+func sizeOp(op byte, a, b *sizeExpr) *sizeExpr {
+	if a == nil || b == nil {
+		return nil
+	}
+	return &sizeExpr{op: op, a: a, b: b}
+}
+
+// relative reports whether a size depends on its parent's size.
+func (e *sizeExpr) relative() bool {
+	if e.op == 0 {
+		return e.unit == unitPercent
+	}
+	return e.a.relative() || e.b.relative()
+}
+
+// eval returns a size in layout units, taking percentages of basis.
+// Dividing by zero gives zero.
+func (l *layoutEngine) eval(e *sizeExpr, basis float64) float64 {
+	switch e.op {
+	case '+':
+		return l.eval(e.a, basis) + l.eval(e.b, basis)
+	case '-':
+		return l.eval(e.a, basis) - l.eval(e.b, basis)
+	case '*':
+		return l.eval(e.a, basis) * l.eval(e.b, basis)
+	case '/':
+		if d := l.eval(e.b, basis); d != 0 {
+			return l.eval(e.a, basis) / d
+		}
+		return 0
+	}
+	switch e.unit {
+	case unitPercent:
+		return e.n / 100 * basis
+	case unitVW:
+		return e.n / 100 * l.viewport.Width
+	case unitVH:
+		return e.n / 100 * l.viewport.Height
+	case unitCH:
+		return e.n * l.opts.CharWidth
+	case unitLH:
+		return e.n * l.opts.LineHeight
+	}
+	return e.n
+}
+
+// size resolves a size prop, taking percentages of basis, the parent's
+// content size along the same axis.
+func (l *layoutEngine) size(v interface{}, basis float64) (float64, bool) {
+	e, ok := parseSize(v)
+	if !ok {
+		return 0, false
+	}
+	return l.eval(e, basis), true
+}
+
+// ownSize resolves a size prop that does not depend on the node's
+// parent. Percentages are resolved by the parent when it allocates the
+// node's space, so they report false.
+func (l *layoutEngine) ownSize(v interface{}) (float64, bool) {
+	e, ok := parseSize(v)
+	if !ok || e.relative() {
+		return 0, false
+	}
+	return l.eval(e, 0), true
+}
+
+// hasSize reports whether a size prop is set to something readable.
+func hasSize(v interface{}) bool {
+	_, ok := parseSize(v)
+	return ok
+}
+
+// within returns box with a node's percentage sizes taken of it, for
+// nodes whose parent does not resolve them: the root, overlays, and grid
+// children.
+func (l *layoutEngine) within(node *RenderNode, box ComputedLayout) ComputedLayout {
+	if e, ok := parseSize(node.Props.Width); ok && e.relative() {
+		box.Width = l.eval(e, box.Width)
+	}
+	if e, ok := parseSize(node.Props.Height); ok && e.relative() {
+		box.Height = l.eval(e, box.Height)
+	}
+	return box
+}
+
+// htmlLength converts a size prop to CSS.
+func htmlLength(v interface{}) (string, bool) {
+	e, ok := parseSize(v)
+	if !ok {
+		return "", false
+	}
+	if e.op != 0 {
+		return "calc" + e.css(false), true
+	}
+	return e.css(false), true
+}
+
+// css writes a size as CSS. Lengths in layout units are pixels, except
+// plain numbers multiplying or dividing.
+func (e *sizeExpr) css(number bool) string {
+	switch e.op {
+	case 0:
+		if e.unit == "" && !number {
+			return fmt.Sprintf("%gpx", e.n)
+		}
+		return fmt.Sprintf("%g%s", e.n, e.unit)
+	case '*', '/':
+		return fmt.Sprintf("(%s %c %s)", e.a.css(e.b.unit != "" || e.b.op != 0), e.op, e.b.css(true))
+	}
+	return fmt.Sprintf("(%s %c %s)", e.a.css(false), e.op, e.b.css(false))
+}
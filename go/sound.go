@@ -0,0 +1,66 @@
+package viewer
+
+import "fmt"
+
+// Sound effects. A source declares its sounds once, as SoundSlots, and
+// plays one by sending a MsgAudio naming its slot, so a notification
+// sound costs a few bytes each time it is heard. The viewer hands the
+// sound to the host's AudioSink; without one, or while muted, triggers
+// are ignored. A trigger naming a slot that is not a sound is reported
+// upstream, and a sink's failure to play goes to the error handlers.
+
+// AudioSink plays sound effects for a viewer.
+type AudioSink interface {
+	// Play starts playing sound and returns without waiting for it to
+	// finish. It is called with the viewer's lock held.
+	Play(sound SoundSlot) error
+}
+
+// AudioSinkFunc adapts a function to an AudioSink.
+type AudioSinkFunc func(sound SoundSlot) error
+
+func (f AudioSinkFunc) Play(sound SoundSlot) error { return f(sound) }
+
+// SetAudioSink sets where the viewer plays sound effects. Passing nil
+// makes it play none.
+func (v *Viewer) SetAudioSink(sink AudioSink) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.audio = sink
+}
+
+// SetMuted mutes or unmutes sound effects. Sounds triggered while muted
+// are dropped, not deferred.
+func (v *Viewer) SetMuted(muted bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.muted = muted
+}
+
+// Muted reports whether sound effects are muted.
+func (v *Viewer) Muted() bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.muted
+}
+
+// playSound plays the sound defined in slot.
+// Must be called with the mutex held.
+func (v *Viewer) playSound(slot int) {
+	sound, ok := v.tree.Slots[slot].(SoundSlot)
+	if !ok {
+		v.reportUpstream(ErrorReport{
+			Code:    ErrorCodeSlot,
+			Message: fmt.Sprintf("slot %d is not a sound", slot),
+			MsgType: MsgAudio,
+			Slot:    &slot,
+		})
+		return
+	}
+	if v.audio == nil || v.muted {
+		return
+	}
+	if err := v.audio.Play(sound); err != nil {
+		v.reportError(fmt.Errorf("sound slot %d: %w", slot, err))
+	}
+}
@@ -1,5 +1,12 @@
 package viewer
 
+import (
+	"reflect"
+	"sort"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
 // SourceState manages pending and published state for the app (source) side.
 //
 // This mirrors the TypeScript SourceState (src/source/state.ts):
@@ -7,56 +14,710 @@ package viewer
 //   - Flush() bundles pending ops into protocol messages
 //   - Published state tracks what has been sent to the viewer
 //
-// Status: Stub — interface defined, implementation TODO.
+// Unlike the TypeScript version, the published tree is kept materialized
+// (patches are applied to it as they are flushed), so a resync request
+// can be answered with a full tree without asking the app to rebuild it.
 
 // SourceState holds pending and published state for the source side.
+// It is not safe for concurrent use.
 type SourceState struct {
 	// Seq is the sequence number of the last flush.
 	Seq uint64
 
+	// AckedSeq is the highest sequence number acknowledged by the viewer.
+	AckedSeq uint64
+
+	// ResyncRequested is set when the viewer reports lost frames; the next
+	// flush should resend the full tree.
+	ResyncRequested bool
+
+	// RefreshRequested is set when the viewer asks for its full state; the
+	// next flush resends every slot and schema as well as the full tree.
+	RefreshRequested bool
+
+	// Clipboard is the viewer's clipboard text as last read with
+	// ReadClipboard, and ClipboardNonce the read it answers. Clipboard is
+	// nil until an answer arrives.
+	Clipboard      *string
+	ClipboardNonce uint64
+
+	// ViewerMetrics is the latest report from a viewer sending its metrics
+	// (see Viewer.ReportMetrics), or nil.
+	ViewerMetrics *ViewerMetrics
+
+	// Credit is the number of messages the viewer has allowed us to send;
+	// each message of a flush takes one, as each takes a slot in the
+	// viewer's queue. It is only meaningful once the viewer has enabled
+	// flow control.
+	Credit int
+
+	// DeltaTrees makes Flush send a full tree set with SetTree as patches
+	// against the published tree when that is smaller, so re-sending a
+	// mostly unchanged UI costs only its changes. A resync still sends the
+	// whole tree, since the viewer drops patches until it gets one.
+	DeltaTrees bool
+
+	// Timestamps makes each flush stamp its last message with the time it
+	// was sent, by Clock, so the viewer can measure transport delay.
+	Timestamps bool
+
+	// Clock is the time source for Timestamps and pongs. Nil means the
+	// system clock.
+	Clock Clock
+
+	// HashEvery makes every nth flush end with a MsgHash of the published
+	// tree, which the viewer checks its own tree against, asking for a
+	// resync if they differ. Zero disables hashing.
+	HashEvery int
+
+	flowControlled bool
+
+	// pongs are the nonces of pings to answer in the next flush.
+	pongs []uint64
+
+	// echo is the input event token the next flush responds to; 0 if none.
+	echo uint64
+
+	// clipboardNext is the nonce of the last clipboard read.
+	clipboardNext uint64
+
 	hasPending bool
+
+	// held are flushed messages waiting for credit, in order.
+	held []ProtocolMessage
+
+	pending   pendingOps
+	published *RenderTree
+	ids       *IDAllocator
+	interner  *SlotInterner
+}
+
+// pendingOps holds mutations accumulated since the last flush.
+type pendingOps struct {
+	// tree, if set, replaces the whole tree and supersedes patches.
+	tree *VNode
+	// patches are kept in order; Set-only ops for the same target are
+	// merged into one (see SourceState.Patch).
+	patches []PatchOp
+	// setIndex maps a target to its mergeable Set-only op in patches.
+	setIndex    map[int]int
+	slots       map[int]SlotValue
+	slotOrder   []int
+	schemas     map[int][]SchemaColumn
+	schemaOrder []int
+	dataRows    []pendingRow
+	// sounds are the sound slots to play, in order.
+	sounds []int
+	// notices are the notifications to send, in order.
+	notices []Notification
+	// clipboard holds the clipboard writes and reads to send, in order.
+	clipboard []pendingClipboard
+}
+
+type pendingClipboard struct {
+	nonce    uint64 // reads only
+	transfer ClipboardTransfer
+}
+
+type pendingRow struct {
+	schema int
+	row    []interface{}
 }
 
 // NewSourceState creates a new SourceState.
 func NewSourceState() *SourceState {
-	return &SourceState{}
+	return &SourceState{published: NewRenderTree()}
 }
 
 // SetTree sets a full tree (replaces any pending patches).
 func (s *SourceState) SetTree(root *VNode) {
-	// TODO: store pending tree, clear pending patches
-	_ = root
+	s.pending.tree = root
+	s.pending.patches = nil
+	s.pending.setIndex = nil
 	s.hasPending = true
 }
 
 // Patch applies patch operations (coalesce with existing pending patches).
+// Consecutive Set-only ops for a target merge into one, last write wins
+// per key; structural ops are kept in order. If a full tree is pending,
+// the ops are applied to it directly.
 func (s *SourceState) Patch(ops []PatchOp) {
-	// TODO: coalesce patches per target
-	_ = ops
+	if len(ops) == 0 {
+		return
+	}
 	s.hasPending = true
+	if s.pending.tree != nil {
+		tree := NewRenderTree()
+		SetTreeRoot(tree, s.pending.tree)
+		ApplyPatches(tree, ops)
+		s.pending.tree = renderNodeToVNode(tree.Root)
+		return
+	}
+	if s.pending.setIndex == nil {
+		s.pending.setIndex = make(map[int]int)
+	}
+	for _, op := range ops {
+		// A Set cannot merge into one made before its node was
+		// inserted again.
+		for _, id := range insertedIDs(op) {
+			delete(s.pending.setIndex, id)
+		}
+		if isSetOnly(op) {
+			if i, ok := s.pending.setIndex[op.Target]; ok {
+				merged := s.pending.patches[i].Set
+				for k, v := range op.Set {
+					merged[k] = v
+				}
+				continue
+			}
+			op.Set = copySet(op.Set)
+			s.pending.setIndex[op.Target] = len(s.pending.patches)
+		} else {
+			// Later Sets must not be hoisted above a structural change.
+			delete(s.pending.setIndex, op.Target)
+		}
+		s.pending.patches = append(s.pending.patches, op)
+	}
 }
 
 // DefineSlot defines a slot (last-write-wins).
 func (s *SourceState) DefineSlot(slot uint32, value SlotValue) {
-	// TODO: store in pending slots
-	_ = slot
-	_ = value
+	if s.pending.slots == nil {
+		s.pending.slots = make(map[int]SlotValue)
+	}
+	id := int(slot)
+	if _, ok := s.pending.slots[id]; !ok {
+		s.pending.slotOrder = append(s.pending.slotOrder, id)
+	}
+	s.pending.slots[id] = value
+	s.hasPending = true
+}
+
+// DefineSchema defines a data schema (last-write-wins).
+func (s *SourceState) DefineSchema(slot uint32, columns []SchemaColumn) {
+	if s.pending.schemas == nil {
+		s.pending.schemas = make(map[int][]SchemaColumn)
+	}
+	id := int(slot)
+	if _, ok := s.pending.schemas[id]; !ok {
+		s.pending.schemaOrder = append(s.pending.schemaOrder, id)
+	}
+	s.pending.schemas[id] = columns
+	s.hasPending = true
+}
+
+// EmitData queues a data row. Rows are never coalesced.
+func (s *SourceState) EmitData(schema uint32, row []interface{}) {
+	s.pending.dataRows = append(s.pending.dataRows, pendingRow{int(schema), row})
+	s.hasPending = true
+}
+
+// PlaySound queues a MsgAudio playing the sound defined in slot (see
+// SoundSlot). Sounds are never coalesced, and follow the definitions of
+// the same flush, so a sound can be defined and played at once.
+func (s *SourceState) PlaySound(slot uint32) {
+	s.pending.sounds = append(s.pending.sounds, int(slot))
+	s.hasPending = true
+}
+
+// Notify queues a MsgNotify asking the viewer to alert the user with n.
+// Notifications are never coalesced.
+func (s *SourceState) Notify(n Notification) {
+	s.pending.notices = append(s.pending.notices, n)
+	s.hasPending = true
+}
+
+// WriteClipboard queues a MsgClipboard putting text on the viewer's
+// clipboard, if its ClipboardPolicy allows.
+func (s *SourceState) WriteClipboard(text string) {
+	s.pending.clipboard = append(s.pending.clipboard, pendingClipboard{
+		transfer: ClipboardTransfer{Op: ClipboardWrite, Text: text},
+	})
+	s.hasPending = true
+}
+
+// ReadClipboard queues a MsgClipboard asking for the text on the viewer's
+// clipboard, and returns the nonce its answer will carry. The answer, if
+// the viewer's ClipboardPolicy allows one, sets Clipboard through
+// HandleControl.
+func (s *SourceState) ReadClipboard() uint64 {
+	s.clipboardNext++
+	s.pending.clipboard = append(s.pending.clipboard, pendingClipboard{
+		nonce:    s.clipboardNext,
+		transfer: ClipboardTransfer{Op: ClipboardRead},
+	})
 	s.hasPending = true
+	return s.clipboardNext
+}
+
+// Echo marks the pending changes as the response to an input event, by
+// the event's Echo token, so the viewer can measure the event's latency.
+// The next flush that sends anything carries the token; if several
+// events were echoed since the last flush, the latest one is.
+func (s *SourceState) Echo(token uint64) {
+	if token > s.echo {
+		s.echo = token
+	}
+}
+
+// FlushBudget limits how much a single flush sends. Zero fields are
+// unlimited. Patch ops and data rows beyond the budget stay pending for
+// the next flush; slot and schema definitions, full trees, sounds,
+// notifications and clipboard transfers are always sent whole, and at
+// least one op or row is sent so a flush always makes progress.
+type FlushBudget struct {
+	// MaxOps caps the number of patch ops plus data rows.
+	MaxOps int
+	// MaxBytes caps the approximate CBOR payload size of the flush.
+	MaxBytes int
+}
+
+// Flush bundles pending ops into protocol messages and updates published
+// state. Messages are ordered DEFINE, SCHEMA, TREE or PATCH, DATA, AUDIO,
+// NOTIFY, CLIPBOARD, then HASH if HashEvery calls for one; the last one
+// carries the new Seq so the viewer acknowledges the flush as a whole. If
+// a resync was requested, the published tree is resent in full instead of
+// a patch, and after a refresh request so are all slots and schemas.
+// Returns nil if nothing is pending.
+func (s *SourceState) Flush() []ProtocolMessage {
+	return s.FlushWithin(FlushBudget{})
 }
 
-// Flush bundles pending ops into protocol messages and updates published state.
-// Returns the number of messages generated.
-func (s *SourceState) Flush() int {
+// FlushWithin is Flush limited by a budget. HasPending reports whether
+// anything was held back. Pongs answering pings come first, outside the
+// budget and without a Seq, and are sent even if nothing else is pending;
+// each carries the time by Clock, for the viewer's clock sync.
+//
+// Under flow control each message sent takes one credit. Messages beyond
+// the credit left are held back, in order, and sent by later flushes as
+// the viewer grants more; nothing new is flushed until they have been.
+func (s *SourceState) FlushWithin(b FlushBudget) []ProtocolMessage {
+	var msgs []ProtocolMessage
+	for _, nonce := range s.pongs {
+		nonce, sent := nonce, s.stamp()
+		msgs = append(msgs, ProtocolMessage{Type: MsgPong, Nonce: &nonce, Sent: &sent})
+	}
+	s.pongs = nil
+
+	if len(s.held) == 0 {
+		s.held = s.flushPending(b)
+	}
+	n := len(s.held)
+	if s.flowControlled {
+		n = min(n, max(s.Credit, 0))
+		s.Credit -= n
+	}
+	msgs = append(msgs, s.held[:n]...)
+	s.held = s.held[n:]
+	if len(s.held) == 0 {
+		s.held = nil
+	}
+	return msgs
+}
+
+// flushPending bundles the pending ops into the messages of one flush.
+func (s *SourceState) flushPending(b FlushBudget) []ProtocolMessage {
 	if !s.hasPending {
-		return 0
+		return nil
+	}
+	if s.RefreshRequested {
+		s.RefreshRequested = false
+		s.ResyncRequested = true
+		s.redefinePublished()
 	}
+	p := s.pending
+	s.pending = pendingOps{}
 	s.hasPending = false
+	if b != (FlushBudget{}) {
+		s.deferOverBudget(&p, b)
+	}
+
+	var msgs []ProtocolMessage
+	for _, id := range p.slotOrder {
+		slot, value := id, p.slots[id]
+		msgs = append(msgs, ProtocolMessage{Type: MsgDefine, Slot: &slot, SlotValue: value})
+		s.published.Slots[id] = value
+	}
+	for _, id := range p.schemaOrder {
+		slot, columns := id, p.schemas[id]
+		msgs = append(msgs, ProtocolMessage{Type: MsgSchema, Slot: &slot, Columns: columns})
+		s.published.Schemas[id] = columns
+	}
+
+	switch {
+	case p.tree != nil:
+		if ops, ok := s.treeDelta(p.tree); ok {
+			if len(ops) > 0 {
+				msgs = append(msgs, ProtocolMessage{Type: MsgPatch, Ops: ops})
+			}
+		} else {
+			msgs = append(msgs, ProtocolMessage{Type: MsgTree, Root: p.tree})
+			s.ResyncRequested = false
+		}
+		SetTreeRoot(s.published, p.tree)
+	case s.ResyncRequested && s.published.Root != nil:
+		ApplyPatches(s.published, p.patches)
+		msgs = append(msgs, ProtocolMessage{Type: MsgTree, Root: renderNodeToVNode(s.published.Root)})
+		s.ResyncRequested = false
+	case len(p.patches) > 0:
+		if ops := s.publishDelta(p.patches); len(ops) > 0 {
+			msgs = append(msgs, ProtocolMessage{Type: MsgPatch, Ops: ops})
+		}
+	}
+
+	for _, r := range p.dataRows {
+		schema := r.schema
+		msgs = append(msgs, ProtocolMessage{Type: MsgData, Schema: &schema, Row: r.row})
+	}
+	for _, slot := range p.sounds {
+		slot := slot
+		msgs = append(msgs, ProtocolMessage{Type: MsgAudio, Slot: &slot})
+	}
+	for i := range p.notices {
+		msgs = append(msgs, ProtocolMessage{Type: MsgNotify, Notify: &p.notices[i]})
+	}
+	for i := range p.clipboard {
+		c := &p.clipboard[i]
+		msg := ProtocolMessage{Type: MsgClipboard, Clipboard: &c.transfer}
+		if c.transfer.Op == ClipboardRead {
+			msg.Nonce = &c.nonce
+		}
+		msgs = append(msgs, msg)
+	}
+	if s.ids != nil && !s.hasPending {
+		// Removals released since the last flush are in these messages;
+		// if some were held back by the budget, wait for them.
+		s.ids.Recycle()
+	}
+	echo := s.echo
+	s.echo = 0
+	if len(msgs) == 0 {
+		return nil
+	}
+	if echo != 0 {
+		msgs[len(msgs)-1].Echo = &echo
+	}
+	if s.Timestamps {
+		sent := s.stamp()
+		msgs[len(msgs)-1].Sent = &sent
+	}
+
 	s.Seq++
-	// TODO: build and return messages
-	return 0
+	if s.HashEvery > 0 && s.Seq%uint64(s.HashEvery) == 0 && s.published.Root != nil {
+		hash := TreeHash(s.published)
+		msgs = append(msgs, ProtocolMessage{Type: MsgHash, Hash: &hash})
+	}
+	seq := s.Seq
+	msgs[len(msgs)-1].Seq = &seq
+	return msgs
+}
+
+// redefinePublished queues the published slots and schemas to be sent
+// again, ahead of any pending definitions, which replace them.
+func (s *SourceState) redefinePublished() {
+	p := &s.pending
+	if p.slots == nil {
+		p.slots = make(map[int]SlotValue)
+	}
+	var slots []int
+	for id, value := range s.published.Slots {
+		if _, ok := p.slots[id]; !ok {
+			p.slots[id] = value
+			slots = append(slots, id)
+		}
+	}
+	sort.Ints(slots)
+	p.slotOrder = append(slots, p.slotOrder...)
+
+	if p.schemas == nil {
+		p.schemas = make(map[int][]SchemaColumn)
+	}
+	var schemas []int
+	for id, columns := range s.published.Schemas {
+		if _, ok := p.schemas[id]; !ok {
+			p.schemas[id] = columns
+			schemas = append(schemas, id)
+		}
+	}
+	sort.Ints(schemas)
+	p.schemaOrder = append(schemas, p.schemaOrder...)
+}
+
+// treeDelta returns the patches that turn the published tree into tree,
+// if DeltaTrees is set, no resync is pending, and they encode smaller
+// than the tree itself.
+func (s *SourceState) treeDelta(tree *VNode) ([]PatchOp, bool) {
+	if !s.DeltaTrees || s.ResyncRequested || s.published.Root == nil {
+		return nil, false
+	}
+	ops, ok := DiffTree(renderNodeToVNode(s.published.Root), tree)
+	if !ok || encodedSize(ops) >= encodedSize(encodeVNode(tree)) {
+		return nil, false
+	}
+	return ops, true
+}
+
+// publishDelta applies ops to the published tree one at a time and
+// returns them with every Set trimmed to the keys whose values actually
+// change, so a prop set and later set back, or set to what the viewer
+// already shows, is not sent. Ops left with nothing to do are dropped.
+func (s *SourceState) publishDelta(ops []PatchOp) []PatchOp {
+	out := make([]PatchOp, 0, len(ops))
+	for _, op := range ops {
+		if op.Set != nil || op.Unset != nil {
+			if node := s.published.NodeIndex[op.Target]; node != nil {
+				propsOnly := isPropsOnly(op)
+				op.Unset = clearedProps(node.Props, op.Unset, op.Set)
+				op.Set = changedProps(node.Props, op.Set)
+				if op.Set == nil && op.Unset == nil && propsOnly {
+					continue
+				}
+			}
+		}
+		ApplyPatch(s.published, op)
+		out = append(out, op)
+	}
+	return out
+}
+
+// changedProps returns the entries of set that differ from props, or nil
+// if none do. A cleared value ("" or nil, or a pointerDefaults value)
+// matches an unset prop, and numbers compare by value whatever their Go
+// type.
+func changedProps(props NodeProps, set map[string]interface{}) map[string]interface{} {
+	current := propValues(props)
+	var changed map[string]interface{}
+	for k, v := range set {
+		if propEqual(current[k], v) {
+			continue
+		}
+		if d, ok := pointerDefaults[k]; ok && v == d && current[k] == nil {
+			continue
+		}
+		if changed == nil {
+			changed = make(map[string]interface{})
+		}
+		changed[k] = v
+	}
+	return changed
+}
+
+// clearedProps returns the keys of unset that props has set and set does
+// not assign again, or nil if there are none.
+func clearedProps(props NodeProps, unset []string, set map[string]interface{}) []string {
+	current := propValues(props)
+	var cleared []string
+	for _, k := range unset {
+		if _, ok := set[k]; ok || propEqual(current[k], nil) {
+			continue
+		}
+		cleared = append(cleared, k)
+	}
+	return cleared
+}
+
+func propEqual(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return (a == nil || a == "") && (b == nil || b == "")
+	}
+	if x, ok := toFloat(a); ok {
+		y, ok := toFloat(b)
+		return ok && x == y
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// deferOverBudget moves the patches and data rows of p that exceed b back
+// into s.pending. Resyncs are exempt, since their patches are folded into
+// the full tree.
+func (s *SourceState) deferOverBudget(p *pendingOps, b FlushBudget) {
+	if s.ResyncRequested && p.tree == nil && s.published.Root != nil {
+		return
+	}
+	used, count := 0, 0
+	for _, id := range p.slotOrder {
+		used += encodedSize(p.slots[id])
+	}
+	for _, id := range p.schemaOrder {
+		used += encodedSize(p.schemas[id])
+	}
+	if p.tree != nil {
+		used += encodedSize(encodeVNode(p.tree))
+	}
+	fits := func(v interface{}) bool {
+		size := encodedSize(v)
+		if count > 0 && ((b.MaxOps > 0 && count >= b.MaxOps) || (b.MaxBytes > 0 && used+size > b.MaxBytes)) {
+			return false
+		}
+		used += size
+		count++
+		return true
+	}
+
+	n := 0
+	for n < len(p.patches) && fits(p.patches[n]) {
+		n++
+	}
+	rows := 0
+	if n == len(p.patches) {
+		for rows < len(p.dataRows) && fits(p.dataRows[rows].row) {
+			rows++
+		}
+	}
+	if n == len(p.patches) && rows == len(p.dataRows) {
+		return
+	}
+
+	s.pending.dataRows = p.dataRows[rows:]
+	p.dataRows = p.dataRows[:rows]
+	s.hasPending = true
+	if rest := p.patches[n:]; len(rest) > 0 {
+		p.patches = p.patches[:n]
+		s.Patch(rest)
+	}
+}
+
+// encodedSize returns the CBOR size of v, or 0 if it cannot be encoded.
+func encodedSize(v interface{}) int {
+	data, err := cbor.Marshal(v)
+	if err != nil {
+		return 0
+	}
+	return len(data)
+}
+
+// Published returns the state the viewer has been sent: the tree with
+// all flushed patches applied, plus slot and schema definitions. The
+// returned tree must not be modified.
+func (s *SourceState) Published() *RenderTree {
+	return s.published
+}
+
+// IDs returns the state's ID allocator, creating it on first use. IDs it
+// releases are recycled when the removal is flushed.
+func (s *SourceState) IDs() *IDAllocator {
+	if s.ids == nil {
+		s.ids = NewIDAllocator()
+	}
+	return s.ids
+}
+
+// InternSlots enables slot interning for trees rendered through a
+// mounted Root, numbering interned slots from firstSlot. See SlotInterner.
+func (s *SourceState) InternSlots(firstSlot uint32) *SlotInterner {
+	s.interner = NewSlotInterner(s, firstSlot)
+	return s.interner
 }
 
-// HasPending returns true if there are pending changes to flush.
+// Reset discards pending and published state, as for a new connection.
+// The ID allocator is kept, since the app's nodes still hold its IDs;
+// interned slots are forgotten and redefined as trees are sent again.
+func (s *SourceState) Reset() {
+	*s = SourceState{published: NewRenderTree(), ids: s.ids, interner: s.interner}
+	if s.interner != nil {
+		s.interner.reset()
+	}
+}
+
+// HandleControl processes a viewer → source control message (MsgAck,
+// MsgResync, MsgRefresh, MsgCredit, MsgPing, MsgMetrics, MsgError or
+// MsgClipboard). Pings are answered by the next flush, metrics are kept
+// in ViewerMetrics, clipboard text in Clipboard, and a failed patch is
+// repaired by resending the tree.
+// Returns false if the message is not a control message.
+func (s *SourceState) HandleControl(msg ProtocolMessage) bool {
+	switch msg.Type {
+	case MsgAck:
+		if msg.Ack != nil && *msg.Ack > s.AckedSeq {
+			s.AckedSeq = *msg.Ack
+		}
+	case MsgResync:
+		s.ResyncRequested = true
+		s.hasPending = true
+	case MsgRefresh:
+		s.RefreshRequested = true
+		s.hasPending = true
+	case MsgPing:
+		if msg.Nonce != nil {
+			s.pongs = append(s.pongs, *msg.Nonce)
+		}
+	case MsgMetrics:
+		if msg.Metrics != nil {
+			s.ViewerMetrics = msg.Metrics
+		}
+	case MsgClipboard:
+		if c := msg.Clipboard; c != nil && c.Op == ClipboardText && msg.Nonce != nil {
+			text := c.Text
+			s.Clipboard = &text
+			s.ClipboardNonce = *msg.Nonce
+		}
+	case MsgError:
+		// The published tree no longer matches the viewer's once one of
+		// its patches has failed.
+		if msg.Error != nil && msg.Error.Code == ErrorCodePatch {
+			s.ResyncRequested = true
+			s.hasPending = true
+		}
+	case MsgCredit:
+		if msg.Credit != nil {
+			s.flowControlled = true
+			s.Credit += *msg.Credit
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// CanSend reports whether the viewer's flow-control window allows another
+// message. Always true if the viewer never granted credit.
+func (s *SourceState) CanSend() bool {
+	return !s.flowControlled || s.Credit > 0
+}
+
+// Unacked returns the number of flushed frames not yet acknowledged.
+func (s *SourceState) Unacked() uint64 {
+	if s.Seq < s.AckedSeq {
+		return 0
+	}
+	return s.Seq - s.AckedSeq
+}
+
+// HasPending returns true if there are pending changes or pongs to
+// flush, or flushed messages still waiting for credit.
 func (s *SourceState) HasPending() bool {
-	return s.hasPending
+	return s.hasPending || len(s.pongs) > 0 || len(s.held) > 0
+}
+
+// isSetOnly reports whether op only sets properties and so may be merged
+// with another Set for the same target.
+func isSetOnly(op PatchOp) bool {
+	return op.Set != nil && op.Unset == nil && isPropsOnly(op)
+}
+
+// isPropsOnly reports whether op only sets or unsets properties.
+func isPropsOnly(op PatchOp) bool {
+	return op.ChildrenInsert == nil && op.ChildrenRemove == nil && op.ChildrenMove == nil &&
+		op.ChildrenRemoveRange == nil && op.ChildrenMoveRange == nil && op.ChildrenSet == nil &&
+		!op.Remove && op.Replace == nil && op.Transition == nil
+}
+
+func copySet(set map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(set))
+	for k, v := range set {
+		out[k] = v
+	}
+	return out
+}
+
+// renderNodeToVNode converts a materialized node back into a VNode.
+func renderNodeToVNode(node *RenderNode) *VNode {
+	if node == nil {
+		return nil
+	}
+	v := &VNode{ID: node.ID, Type: node.Type, Props: node.Props}
+	for _, c := range node.Children {
+		v.Children = append(v.Children, renderNodeToVNode(c))
+	}
+	return v
 }
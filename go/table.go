@@ -0,0 +1,279 @@
+package viewer
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// Tables. A table node shows the data rows of the schema slot named by
+// its schema prop under a header row of the schema's column names, with
+// values formatted as text projections format them. Each column is as
+// wide as the widest of its header and cells, up to tableMaxAuto, unless
+// columnWidths sets it (in characters; 0 leaves it automatic); columns
+// are tableGap apart, and cells too wide for their column are cut short
+// with an ellipsis. Without a height, a table is as tall as its rows.
+// The header stays put while the rows scroll under it by scrollTop rows,
+// and a striped table shades every other row.
+//
+// A table takes focus. Up and down move the selected row, page up and
+// page down move it a page, and home and end go to the first and last;
+// pressing a row selects it. The viewer keeps the selection in the
+// selectedRow prop, scrolls to keep it in view, and sends the source a
+// select event with the row's index.
+
+// tableGap is the space between columns, in characters.
+const tableGap = 2
+
+// tableMaxAuto is the widest an automatic column grows, in characters.
+const tableMaxAuto = 40
+
+// stripeAlpha is how strongly a striped table's odd rows are shaded with
+// its text color.
+const stripeAlpha = 0x18
+
+// stripeColor is the background of a striped table's odd rows on the
+// cell grid.
+const stripeColor = "#262626"
+
+// tableData returns a table's schema and rows.
+func tableData(tree *RenderTree, node *RenderNode) ([]SchemaColumn, [][]interface{}) {
+	if tree == nil || node.Props.Schema == nil {
+		return nil, nil
+	}
+	return tree.Schemas[*node.Props.Schema], tree.DataRows[*node.Props.Schema]
+}
+
+// tableCells returns a table's header and body as text.
+func tableCells(tree *RenderTree, node *RenderNode) (header []string, body [][]string) {
+	schema, rows := tableData(tree, node)
+	f := treeFormat(tree)
+	header = make([]string, len(schema))
+	for i, col := range schema {
+		header[i] = col.Name
+	}
+	body = make([][]string, len(rows))
+	for r, row := range rows {
+		body[r] = make([]string, len(schema))
+		for i, col := range schema {
+			if i < len(row) {
+				body[r][i] = formatValue(row[i], col, f)
+			}
+		}
+	}
+	return header, body
+}
+
+// columnWidths returns the width of each of a table's columns.
+func columnWidths(node *RenderNode, header []string, body [][]string) []int {
+	widths := make([]int, len(header))
+	for i, h := range header {
+		if i < len(node.Props.ColumnWidths) && node.Props.ColumnWidths[i] > 0 {
+			widths[i] = node.Props.ColumnWidths[i]
+			continue
+		}
+		widths[i] = StringWidth(h)
+		for _, cells := range body {
+			widths[i] = max(widths[i], StringWidth(cells[i]))
+		}
+		widths[i] = min(widths[i], tableMaxAuto)
+	}
+	return widths
+}
+
+// tableLine lays out one row of cells in columns.
+func tableLine(cells []string, widths []int) string {
+	var b strings.Builder
+	for i, c := range cells {
+		if i > 0 {
+			b.WriteString(strings.Repeat(" ", tableGap))
+		}
+		b.WriteString(PadWidth(TruncateWidth(c, widths[i], "…"), widths[i]))
+	}
+	return b.String()
+}
+
+// tableLines returns a table's header line and row lines.
+func tableLines(tree *RenderTree, node *RenderNode) (string, []string) {
+	header, body := tableCells(tree, node)
+	widths := columnWidths(node, header, body)
+	lines := make([]string, len(body))
+	for i, cells := range body {
+		lines[i] = tableLine(cells, widths)
+	}
+	return tableLine(header, widths), lines
+}
+
+// tableWidth returns how wide a table's columns are together, in
+// characters.
+func tableWidth(tree *RenderTree, node *RenderNode) int {
+	header, body := tableCells(tree, node)
+	total := 0
+	for i, w := range columnWidths(node, header, body) {
+		if i > 0 {
+			total += tableGap
+		}
+		total += w
+	}
+	return total
+}
+
+// tableTop returns the first row a table shows.
+func tableTop(node *RenderNode) int {
+	if node.Props.ScrollTop == nil {
+		return 0
+	}
+	return max(*node.Props.ScrollTop, 0)
+}
+
+// selectedRow returns a table's selected row, or -1 if none is.
+func selectedRow(node *RenderNode) int {
+	if node.Props.SelectedRow == nil {
+		return -1
+	}
+	return *node.Props.SelectedRow
+}
+
+// tableKey applies a key to the focused table. Returns false if the key
+// does not move its selection.
+// Must be called with the mutex held.
+func (v *Viewer) tableKey(key string) bool {
+	if v.tree.Focused == nil {
+		return false
+	}
+	node := v.tree.NodeIndex[*v.tree.Focused]
+	if node == nil || node.Type != NodeTable {
+		return false
+	}
+	_, rows := tableData(v.tree, node)
+	if len(rows) == 0 {
+		return false
+	}
+	row, page := selectedRow(node), v.tablePage(node)
+	switch strings.ToLower(key) {
+	case "arrowup", "up":
+		row--
+	case "arrowdown", "down":
+		row++
+	case "pageup":
+		row -= page
+	case "pagedown":
+		row += page
+	case "home":
+		row = 0
+	case "end":
+		row = len(rows) - 1
+	default:
+		return false
+	}
+	v.selectRow(node, min(max(row, 0), len(rows)-1))
+	return true
+}
+
+// tablePage returns how many rows a table shows at once.
+// Must be called with the mutex held.
+func (v *Viewer) tablePage(node *RenderNode) int {
+	in := v.inputLayout()
+	if l := in.layouts[node.ID]; l != nil {
+		return max(int(l.Height/in.opts.LineHeight)-1, 1)
+	}
+	return 1
+}
+
+// pressTable focuses a table and selects the row at y, in its layout
+// coordinates before scrolling.
+// Must be called with the mutex held.
+func (v *Viewer) pressTable(in *inputLayout, node *RenderNode, y float64) {
+	v.setFocus(&node.ID)
+	l := in.layouts[node.ID]
+	if l == nil {
+		return
+	}
+	line := int(math.Floor((y - l.Y) / in.opts.LineHeight))
+	_, rows := tableData(v.tree, node)
+	if row := tableTop(node) + line - 1; line > 0 && row < len(rows) {
+		v.selectRow(node, row)
+	}
+}
+
+// selectRow selects a table row, scrolls it into view, and tells the
+// source.
+// Must be called with the mutex held.
+func (v *Viewer) selectRow(node *RenderNode, row int) {
+	page := v.tablePage(node)
+	top := min(max(tableTop(node), row-page+1), row)
+	node.Props.ScrollTop = &top
+	if selectedRow(node) != row {
+		node.Props.SelectedRow = &row
+		id := node.ID
+		event := InputEvent{Target: &id, Kind: "select", Row: &row}
+		v.emit(ProtocolMessage{Type: MsgInput, Event: &event})
+	}
+	v.markDirty()
+	v.signalChanged()
+}
+
+// rowStyle returns the style of a table row drawn with style.
+func rowStyle(node *RenderNode, row int, style CellStyle) CellStyle {
+	if node.Props.Striped != nil && *node.Props.Striped && row%2 == 1 {
+		style.BG = stripeColor
+	}
+	if row == selectedRow(node) {
+		style.Inverse = !style.Inverse
+	}
+	return style
+}
+
+// drawTable draws a table's header and the rows scrolled into view.
+func (d *gridDrawer) drawTable(node *RenderNode, r rect, paint CellStyle, clip rect) {
+	clip = clip.intersect(r)
+	header, lines := tableLines(d.tree, node)
+	bold := paint
+	bold.Bold = true
+	// Pad lines only as far as they can be seen; the laid-out width may
+	// be far wider than the screen.
+	w := clip.x + clip.w - r.x
+	d.text(r.x, r.y, PadWidth(header, w), bold, clip)
+	top := tableTop(node)
+	for i := top; i < len(lines) && r.y+1+i-top < r.y+r.h; i++ {
+		d.text(r.x, r.y+1+i-top, PadWidth(lines[i], w), rowStyle(node, i, paint), clip)
+	}
+}
+
+// rowRect returns where the rasterizer draws row of a table at r, or an
+// empty rectangle if it is scrolled out of view.
+func rowRect(node *RenderNode, row int, r image.Rectangle, m textMetrics) image.Rectangle {
+	line := row - tableTop(node) + 1
+	if row < 0 || line < 1 {
+		return image.Rectangle{}
+	}
+	return image.Rect(r.Min.X, r.Min.Y+line*m.lineH, r.Max.X, r.Min.Y+(line+1)*m.lineH).Intersect(r)
+}
+
+// drawTable paints a table's header and the rows scrolled into view,
+// shading striped rows and highlighting the selected one with the text
+// color.
+func (d *rasterDrawer) drawTable(node *RenderNode, n rasterNode, clip image.Rectangle) {
+	clip = clip.Intersect(n.rect)
+	m := n.metrics
+	header, lines := tableLines(d.tree, node)
+	bold := n.style
+	bold.Bold = true
+	d.text(n.rect.Min.X, n.rect.Min.Y, header, bold, m, clip)
+	src := image.NewUniform(rgba(n.style.FG, rasterForeground))
+	striped := node.Props.Striped != nil && *node.Props.Striped
+	for i := tableTop(node); i < len(lines); i++ {
+		row := rowRect(node, i, n.rect, m)
+		if row.Empty() {
+			break
+		}
+		if striped && i%2 == 1 {
+			draw.DrawMask(d.img, row.Intersect(clip), src, image.Point{}, image.NewUniform(color.Alpha{stripeAlpha}), image.Point{}, draw.Over)
+		}
+		d.text(n.rect.Min.X, row.Min.Y, lines[i], n.style, m, clip)
+	}
+	draw.DrawMask(d.img, n.selected.Intersect(clip), src, image.Point{}, image.NewUniform(color.Alpha{0x50}), image.Point{}, draw.Over)
+}
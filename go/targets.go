@@ -0,0 +1,246 @@
+package viewer
+
+import (
+	"errors"
+	"image"
+	"reflect"
+)
+
+// Multi-output fan-out: a single Viewer can drive several render targets
+// at once (e.g. an ANSI terminal plus a headless projection for tests).
+// Each target remembers the state generation it last rendered, so it only
+// re-renders when something changed since.
+
+// ErrTargetNotComparable is reported to the error handlers by AddTarget
+// for a target that cannot be compared with ==, such as a TextureTarget
+// whose Backend is a struct holding a slice or map. Pass a pointer
+// instead.
+var ErrTargetNotComparable = errors.New("render target is not comparable")
+
+// targetState tracks one render target's output.
+type targetState struct {
+	target      RenderTarget
+	renderedGen uint64
+	renderCount int
+	lastOutput  string
+	hasRendered bool
+	term        *Terminal
+
+	// Framebuffer and texture targets: the rasterizer holding the frame,
+	// and damage accumulated since the last TakeRenderResult.
+	raster *Rasterizer
+	damage []image.Rectangle
+	full   bool
+
+	texture *gpuTexture
+}
+
+// AddTarget attaches an additional render target. The target starts out
+// dirty so the next Render draws the current state to it. Adding a target
+// that is already attached does nothing, and one that is not comparable
+// is refused with ErrTargetNotComparable.
+func (v *Viewer) AddTarget(target RenderTarget) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if !targetComparable(target) {
+		v.reportError(ErrTargetNotComparable)
+		return
+	}
+	if v.findTarget(target) != nil {
+		return
+	}
+	v.targets = append(v.targets, &targetState{target: target})
+}
+
+// RemoveTarget detaches a render target added with AddTarget. The primary
+// target passed to NewViewer cannot be removed. Returns false if target
+// was not attached.
+func (v *Viewer) RemoveTarget(target RenderTarget) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if !targetComparable(target) {
+		return false
+	}
+	for i, ts := range v.targets {
+		if i > 0 && ts.target == target {
+			v.releaseTexture(ts)
+			v.targets = append(v.targets[:i], v.targets[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Targets returns all attached render targets, primary first.
+func (v *Viewer) Targets() []RenderTarget {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	targets := make([]RenderTarget, len(v.targets))
+	for i, ts := range v.targets {
+		targets[i] = ts.target
+	}
+	return targets
+}
+
+// RenderTo renders only the given target, if it is out of date. Returns
+// whether it was re-rendered.
+func (v *Viewer) RenderTo(target RenderTarget) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	ts := v.findTarget(target)
+	if ts == nil {
+		return false
+	}
+	return v.renderTo(ts)
+}
+
+// IsDirty reports whether target has state changes it has not rendered.
+func (v *Viewer) IsDirty(target RenderTarget) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	ts := v.findTarget(target)
+	return ts != nil && v.targetDirty(ts)
+}
+
+// TargetOutput returns the last output rendered to target (the ANSI text
+// for "ansi", the text projection for "headless", the markup for "html",
+// nothing for "framebuffer" and "texture"; see TakeRenderResult), and its
+// render count.
+func (v *Viewer) TargetOutput(target RenderTarget) (output string, renders int) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	ts := v.findTarget(target)
+	if ts == nil {
+		return "", 0
+	}
+	return ts.lastOutput, ts.renderCount
+}
+
+// TakeRenderResult returns a framebuffer or texture target's current
+// frame and the regions redrawn since the previous call, then clears
+// them, so an embedder compositing the frame copies only what changed.
+// With a Buffer or texture, those regions have already been copied into
+// it. Returns false
+// if target is not an attached framebuffer target or has not rendered.
+func (v *Viewer) TakeRenderResult(target RenderTarget) (RenderResult, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	ts := v.findTarget(target)
+	if ts == nil || ts.raster == nil || ts.raster.img == nil {
+		return RenderResult{}, false
+	}
+	res := RenderResult{Image: ts.raster.img, Damage: ts.damage, Full: ts.full}
+	ts.damage, ts.full = nil, false
+	return res, true
+}
+
+// markDirty records a state change that every target must render.
+// Must be called with the mutex held.
+func (v *Viewer) markDirty() {
+	v.generation++
+}
+
+// targetDirty reports whether ts is behind the current state.
+// Must be called with the mutex held.
+func (v *Viewer) targetDirty(ts *targetState) bool {
+	return !ts.hasRendered || ts.renderedGen != v.generation
+}
+
+// renderTo renders one target if it is out of date. Returns whether it
+// was rendered. Must be called with the mutex held.
+func (v *Viewer) renderTo(ts *targetState) bool {
+	if !v.targetDirty(ts) {
+		return false
+	}
+	v.trackProgress()
+
+	switch ts.target.TargetType() {
+	case "ansi":
+		if ts.term != nil {
+			width, height := ts.term.Size()
+			ts.term.detected = ImageProtocolFor(v.env)
+			v.images.prune(v.tree)
+			grid := renderGrid(v.tree, width, height, v.slotValue, &v.images, v.now())
+			v.reportErrors()
+			ts.term.Draw(grid)
+			ts.lastOutput = grid.String()
+		} else {
+			ts.lastOutput = v.renderToAnsi()
+		}
+	case "framebuffer":
+		width, height := v.displaySize()
+		var fb *Framebuffer
+		t, _ := ts.target.(FramebufferTarget)
+		if t.Buffer != nil {
+			if err := t.Buffer.Validate(); err != nil {
+				v.reportError(err)
+			} else {
+				fb = t.Buffer
+				width, height = fb.Width, fb.Height
+			}
+		}
+		res := v.rasterize(ts, t.Fonts, width, height)
+		if fb != nil {
+			fb.Copy(res.Image, res.Damage)
+		}
+	case "texture":
+		t, _ := ts.target.(TextureTarget)
+		width, height := v.displaySize()
+		v.uploadTexture(ts, textureBackend(t), v.rasterize(ts, t.Fonts, width, height))
+	case "headless":
+		ts.lastOutput = TextProjectionWithOptions(v.tree, v.projectionOptions())
+	case "html":
+		ts.lastOutput = RenderHTML(v.tree)
+		if t, ok := ts.target.(HtmlTarget); ok {
+			v.renderDOM(t, ts.lastOutput)
+		}
+	}
+
+	ts.renderedGen = v.generation
+	ts.hasRendered = true
+	ts.renderCount++
+	return true
+}
+
+// rasterize renders a pixel target's frame and accumulates its damage
+// for TakeRenderResult. Must be called with the mutex held.
+func (v *Viewer) rasterize(ts *targetState, fonts *FontFamily, width, height int) RenderResult {
+	if ts.raster == nil {
+		ts.raster = NewRasterizer()
+	}
+	ts.raster.Fonts = fonts
+	v.images.prune(v.tree)
+	res := ts.raster.render(v.tree, width, height, v.slotValue, &v.images, v.now())
+	v.reportErrors()
+	ts.full = ts.full || res.Full
+	ts.damage = mergeDamage(append(ts.damage, res.Damage...), res.Image.Bounds())
+	return res
+}
+
+// findTarget returns the state for an attached target, or nil.
+// Must be called with the mutex held.
+func (v *Viewer) findTarget(target RenderTarget) *targetState {
+	if !targetComparable(target) {
+		return nil
+	}
+	for _, ts := range v.targets {
+		if ts.target == target {
+			return ts
+		}
+	}
+	return nil
+}
+
+// targetComparable reports whether target can be compared with == without
+// panicking. A target holding an interface is only as comparable as the
+// value in it, so the value is checked, not its static type.
+func targetComparable(target RenderTarget) bool {
+	return target != nil && reflect.ValueOf(target).Comparable()
+}
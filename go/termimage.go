@@ -0,0 +1,249 @@
+package viewer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+)
+
+// Inline terminal images. Image nodes are drawn into the cell grid as
+// half-block characters (two pixels per cell) so every terminal shows
+// something; terminals that support a graphics protocol additionally get
+// the full image emitted over those cells.
+
+// Image protocols, in order of preference.
+const (
+	ImageKitty  = "kitty"  // kitty graphics protocol
+	ImageITerm2 = "iterm2" // iTerm2 inline images (OSC 1337)
+	ImageSixel  = "sixel"  // DEC sixel graphics
+	ImageBlocks = "blocks" // half-block character fallback
+)
+
+// Assumed cell size in pixels when rasterizing for sixel.
+const (
+	sixelCellWidth  = 8
+	sixelCellHeight = 16
+)
+
+// ImageProtocolFor returns the best image protocol the environment
+// advertises in EnvInfo.ImageProtocols, or ImageBlocks.
+func ImageProtocolFor(env *EnvInfo) string {
+	if env == nil {
+		return ImageBlocks
+	}
+	for _, want := range []string{ImageKitty, ImageITerm2, ImageSixel} {
+		if containsString(env.ImageProtocols, want) {
+			return want
+		}
+	}
+	return ImageBlocks
+}
+
+// GridImage is an image placed on a grid, covering a rectangle of cells.
+type GridImage struct {
+	X, Y          int
+	Width, Height int
+	Format        string
+	Data          []byte
+
+	img image.Image
+}
+
+// equal reports whether two placements draw the same image in the same
+// place.
+func (gi GridImage) equal(o GridImage) bool {
+	return gi.X == o.X && gi.Y == o.Y && gi.Width == o.Width && gi.Height == o.Height &&
+		bytes.Equal(gi.Data, o.Data)
+}
+
+// drawImage draws an image node's current frame into r as half blocks
+// and records its placement. Only the part inside clip is drawn; images
+// cut by the clip are not offered to graphics protocols. Returns false if
+// the data could not be decoded.
+func (d *gridDrawer) drawImage(node *RenderNode, r, clip rect) bool {
+	img, ok := d.images.lookup(node, d.now)
+	if !ok || r.w <= 0 || r.h <= 0 {
+		return false
+	}
+
+	pixels := downsample(img, r.w, r.h*2)
+	for y := 0; y < r.h; y++ {
+		for x := 0; x < r.w; x++ {
+			top, bottom := pixels[y*2][x], pixels[y*2+1][x]
+			d.set(r.x+x, r.y+y, '▀', CellStyle{FG: hexColor(top), BG: hexColor(bottom)}, clip)
+		}
+	}
+
+	if clip.intersect(r) == r {
+		d.grid.Images = append(d.grid.Images, GridImage{
+			X: r.x, Y: r.y, Width: r.w, Height: r.h,
+			Format: node.Props.Format,
+			Data:   node.Props.Data,
+			img:    img,
+		})
+	}
+	return true
+}
+
+// downsample scales img to w × h by averaging the source pixels that fall
+// in each destination pixel.
+func downsample(img image.Image, w, h int) [][]color.RGBA {
+	b := img.Bounds()
+	out := make([][]color.RGBA, h)
+	for y := 0; y < h; y++ {
+		out[y] = make([]color.RGBA, w)
+		y0 := b.Min.Y + y*b.Dy()/h
+		y1 := max(y0+1, b.Min.Y+(y+1)*b.Dy()/h)
+		for x := 0; x < w; x++ {
+			x0 := b.Min.X + x*b.Dx()/w
+			x1 := max(x0+1, b.Min.X+(x+1)*b.Dx()/w)
+			var r, g, bl, n uint32
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, _ := img.At(sx, sy).RGBA()
+					r, g, bl, n = r+pr>>8, g+pg>>8, bl+pb>>8, n+1
+				}
+			}
+			out[y][x] = color.RGBA{uint8(r / n), uint8(g / n), uint8(bl / n), 0xff}
+		}
+	}
+	return out
+}
+
+// hexColor formats a color as "#rrggbb".
+func hexColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// encodeImage returns the escape sequence drawing gi with protocol, to be
+// written with the cursor at the image's top-left cell. Returns "" for
+// ImageBlocks, whose cells are already on the grid.
+func encodeImage(gi GridImage, protocol string) string {
+	switch protocol {
+	case ImageKitty:
+		return encodeKitty(gi)
+	case ImageITerm2:
+		return encodeITerm2(gi)
+	case ImageSixel:
+		return encodeSixel(gi)
+	}
+	return ""
+}
+
+// encodeKitty transmits and displays a PNG in chunks of at most 4096
+// base64 bytes, scaled to the placement's cells.
+func encodeKitty(gi GridImage) string {
+	data := gi.Data
+	if gi.Format != "png" {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, gi.img); err != nil {
+			return ""
+		}
+		data = buf.Bytes()
+	}
+	payload := base64.StdEncoding.EncodeToString(data)
+
+	var sb strings.Builder
+	for first := true; first || payload != ""; first = false {
+		chunk := payload
+		if len(chunk) > 4096 {
+			chunk = chunk[:4096]
+		}
+		payload = payload[len(chunk):]
+		more := 0
+		if payload != "" {
+			more = 1
+		}
+		if first {
+			fmt.Fprintf(&sb, "\x1b_Ga=T,f=100,q=2,c=%d,r=%d,m=%d;%s\x1b\\", gi.Width, gi.Height, more, chunk)
+		} else {
+			fmt.Fprintf(&sb, "\x1b_Gm=%d;%s\x1b\\", more, chunk)
+		}
+	}
+	return sb.String()
+}
+
+// encodeITerm2 sends the original file bytes inline, sized in cells.
+func encodeITerm2(gi GridImage) string {
+	return fmt.Sprintf("\x1b]1337;File=inline=1;size=%d;width=%d;height=%d;preserveAspectRatio=0:%s\x07",
+		len(gi.Data), gi.Width, gi.Height, base64.StdEncoding.EncodeToString(gi.Data))
+}
+
+// encodeSixel rasterizes the image at the assumed cell size and encodes
+// it with a 6×6×6 color cube palette.
+func encodeSixel(gi GridImage) string {
+	w, h := gi.Width*sixelCellWidth, gi.Height*sixelCellHeight
+	pixels := downsample(gi.img, w, h)
+
+	index := make([][]int, h)
+	used := make(map[int]bool)
+	for y := range pixels {
+		index[y] = make([]int, w)
+		for x, c := range pixels[y] {
+			i := int(c.R)*6/256*36 + int(c.G)*6/256*6 + int(c.B)*6/256
+			index[y][x] = i
+			used[i] = true
+		}
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "\x1bPq\"1;1;%d;%d", w, h)
+	for i := 0; i < 216; i++ {
+		if used[i] {
+			fmt.Fprintf(&sb, "#%d;2;%d;%d;%d", i, i/36*20, i/6%6*20, i%6*20)
+		}
+	}
+
+	for band := 0; band < h; band += 6 {
+		first := true
+		for i := 0; i < 216; i++ {
+			if !used[i] {
+				continue
+			}
+			row := make([]byte, w)
+			set := false
+			for x := 0; x < w; x++ {
+				var bits byte
+				for dy := 0; dy < 6 && band+dy < h; dy++ {
+					if index[band+dy][x] == i {
+						bits |= 1 << dy
+					}
+				}
+				row[x] = '?' + bits
+				set = set || bits != 0
+			}
+			if !set {
+				continue
+			}
+			if !first {
+				sb.WriteByte('$')
+			}
+			first = false
+			fmt.Fprintf(&sb, "#%d", i)
+			writeSixelRun(&sb, row)
+		}
+		sb.WriteByte('-')
+	}
+	sb.WriteString("\x1b\\")
+	return sb.String()
+}
+
+// writeSixelRun writes sixel characters with run-length compression.
+func writeSixelRun(sb *strings.Builder, row []byte) {
+	for i := 0; i < len(row); {
+		j := i
+		for j < len(row) && row[j] == row[i] {
+			j++
+		}
+		if n := j - i; n > 3 {
+			fmt.Fprintf(sb, "!%d%c", n, row[i])
+		} else {
+			sb.Write(row[i:j])
+		}
+		i = j
+	}
+}
@@ -0,0 +1,282 @@
+package viewer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Terminal drives a character-cell display over an ANSI-compatible byte
+// stream. It owns the screen state: Start switches to the alternate screen,
+// hides the cursor, and enables mouse reporting; Draw repaints only the
+// cells that changed since the previous frame; Stop restores the terminal.
+//
+// Frames are double-buffered: the grid passed to Draw is the back buffer,
+// and the Terminal keeps the last grid it fully wrote as the front buffer
+// that the screen shows. Each frame is composed off-screen, diffed against
+// the front buffer, and written in one Write, wrapped in a synchronized
+// update (DEC mode 2026) so the terminal displays it all at once rather
+// than as it arrives. Terminals without mode 2026 ignore it. A failed
+// write leaves the screen unknown, so the next Draw repaints everything.
+//
+// A Terminal is not safe for concurrent use. When attached to a Viewer it
+// is only touched with the viewer's mutex held.
+type Terminal struct {
+	// Mouse enables mouse reporting (button events, drag, SGR encoding)
+	// when the terminal is started.
+	Mouse bool
+
+	// Graphics selects the inline image protocol (ImageKitty, ImageITerm2,
+	// ImageSixel, or ImageBlocks). When empty, an attached Viewer picks
+	// one from EnvInfo.ImageProtocols.
+	Graphics string
+
+	// Synchronized wraps each frame in a synchronized update. NewTerminal
+	// enables it.
+	Synchronized bool
+
+	detected string
+	out      io.Writer
+	width    int
+	height   int
+	started  bool
+	prev     *Grid // front buffer: what the screen shows
+	err      error
+}
+
+// Synchronized update brackets (DEC private mode 2026).
+const (
+	syncBegin = "\x1b[?2026h"
+	syncEnd   = "\x1b[?2026l"
+)
+
+// NewTerminal returns a terminal of the given size in cells writing to out.
+func NewTerminal(out io.Writer, width, height int) *Terminal {
+	return &Terminal{out: out, width: width, height: height, Synchronized: true}
+}
+
+// Size returns the terminal size in cells.
+func (t *Terminal) Size() (width, height int) {
+	return t.width, t.height
+}
+
+// Resize sets the terminal size in cells. The next Draw repaints the
+// whole screen.
+func (t *Terminal) Resize(width, height int) {
+	t.width = width
+	t.height = height
+	t.prev = nil
+}
+
+// Invalidate forces the next Draw to repaint the whole screen, e.g. after
+// something else has written to the terminal.
+func (t *Terminal) Invalidate() {
+	t.prev = nil
+}
+
+// Err returns the error from the most recent write, if any.
+func (t *Terminal) Err() error {
+	return t.err
+}
+
+// Start enters the alternate screen, hides the cursor, clears the display,
+// and enables mouse reporting if Mouse is set.
+func (t *Terminal) Start() error {
+	var buf bytes.Buffer
+	buf.WriteString("\x1b[?1049h") // alternate screen
+	buf.WriteString("\x1b[?25l")   // hide cursor
+	if t.Mouse {
+		buf.WriteString("\x1b[?1000h\x1b[?1002h\x1b[?1006h")
+	}
+	buf.WriteString("\x1b[0m\x1b[2J\x1b[H")
+	t.started = true
+	t.prev = nil
+	return t.write(buf.Bytes())
+}
+
+// Stop disables mouse reporting, shows the cursor, and leaves the
+// alternate screen. Does nothing if the terminal was not started.
+func (t *Terminal) Stop() error {
+	if !t.started {
+		return nil
+	}
+	var buf bytes.Buffer
+	if t.Synchronized {
+		buf.WriteString(syncEnd) // in case a frame was cut short
+	}
+	buf.WriteString("\x1b[0m")
+	if t.Mouse {
+		buf.WriteString("\x1b[?1006l\x1b[?1002l\x1b[?1000l")
+	}
+	buf.WriteString("\x1b[?25h")   // show cursor
+	buf.WriteString("\x1b[?1049l") // main screen
+	t.started = false
+	t.prev = nil
+	return t.write(buf.Bytes())
+}
+
+// Draw paints g. Rows identical to the previous frame are skipped; a
+// size change or Invalidate repaints everything. Images on the grid are
+// re-sent with the graphics protocol when they or the rows beneath them
+// changed. The frame is written with a single Write call, and g becomes
+// the front buffer once it succeeds.
+func (t *Terminal) Draw(g *Grid) error {
+	full := t.prev == nil || t.prev.Width != g.Width || t.prev.Height != g.Height
+
+	var buf strings.Builder
+	if t.Synchronized {
+		buf.WriteString(syncBegin)
+	}
+	empty := buf.Len()
+	if full {
+		buf.WriteString("\x1b[0m\x1b[2J")
+	}
+	redrawn := make([]bool, g.Height)
+	for y := 0; y < g.Height; y++ {
+		if full {
+			redrawn[y] = true
+			fmt.Fprintf(&buf, "\x1b[%d;1H", y+1)
+			g.writeRow(&buf, y, 0, g.Width)
+			continue
+		}
+		for _, run := range changedRuns(t.prev.Cells[y], g.Cells[y]) {
+			redrawn[y] = true
+			fmt.Fprintf(&buf, "\x1b[%d;%dH", y+1, run[0]+1)
+			g.writeRow(&buf, y, run[0], run[1])
+		}
+	}
+	t.drawImages(&buf, g, redrawn)
+	if buf.Len() == empty {
+		t.prev = g
+		return nil
+	}
+	if t.Synchronized {
+		buf.WriteString(syncEnd)
+	}
+	if err := t.write([]byte(buf.String())); err != nil {
+		t.prev = nil
+		return err
+	}
+	t.prev = g
+	return nil
+}
+
+// drawImages emits graphics-protocol images that need repainting: new or
+// moved placements, and those whose rows were just redrawn as cells.
+func (t *Terminal) drawImages(buf *strings.Builder, g *Grid, redrawn []bool) {
+	protocol := t.Graphics
+	if protocol == "" {
+		protocol = t.detected
+	}
+	if protocol == "" || protocol == ImageBlocks {
+		return
+	}
+
+	var stale []GridImage
+	for _, gi := range g.Images {
+		if t.imageStale(gi, redrawn) {
+			stale = append(stale, gi)
+		}
+	}
+
+	if protocol == ImageKitty {
+		// Kitty placements persist over text, so replace them all.
+		if len(stale) == 0 && (t.prev == nil || !imagesRemoved(t.prev.Images, g.Images)) {
+			return
+		}
+		buf.WriteString("\x1b_Ga=d,q=2\x1b\\")
+		stale = g.Images
+	}
+
+	for _, gi := range stale {
+		fmt.Fprintf(buf, "\x1b[%d;%dH", gi.Y+1, gi.X+1)
+		buf.WriteString(encodeImage(gi, protocol))
+	}
+}
+
+// imageStale reports whether gi must be re-sent this frame.
+func (t *Terminal) imageStale(gi GridImage, redrawn []bool) bool {
+	for y := gi.Y; y < gi.Y+gi.Height && y < len(redrawn); y++ {
+		if redrawn[y] {
+			return true
+		}
+	}
+	return t.prev == nil || !containsImage(t.prev.Images, gi)
+}
+
+// containsImage reports whether images includes an equal placement.
+func containsImage(images []GridImage, gi GridImage) bool {
+	for _, other := range images {
+		if other.equal(gi) {
+			return true
+		}
+	}
+	return false
+}
+
+// imagesRemoved reports whether any placement in before is gone in after.
+func imagesRemoved(before, after []GridImage) bool {
+	for _, gi := range before {
+		if !containsImage(after, gi) {
+			return true
+		}
+	}
+	return false
+}
+
+// write sends bytes to the output, remembering any error.
+func (t *Terminal) write(p []byte) error {
+	_, t.err = t.out.Write(p)
+	return t.err
+}
+
+// runGap is the longest stretch of unchanged cells rewritten to join two
+// changed runs, as that is no longer than the cursor move it saves.
+const runGap = 6
+
+// changedRuns returns the [from, to) column ranges where row b differs
+// from row a, merging runs separated by at most runGap unchanged cells.
+// Runs are widened so they never split a double-width character.
+func changedRuns(a, b []Cell) [][2]int {
+	var runs [][2]int
+	for x := 0; x < len(b); x++ {
+		if x < len(a) && a[x] == b[x] {
+			continue
+		}
+		if n := len(runs); n > 0 && x-runs[n-1][1] <= runGap {
+			runs[n-1][1] = x + 1
+		} else {
+			runs = append(runs, [2]int{x, x + 1})
+		}
+	}
+	for i := range runs {
+		if from := runs[i][0]; from > 0 && b[from].Ch == 0 {
+			runs[i][0]--
+		}
+		if to := runs[i][1]; to < len(b) && b[to].Ch == 0 {
+			runs[i][1]++
+		}
+	}
+	return runs
+}
+
+// AttachTerminal routes an ANSI target's output through term: each render
+// lays the tree out in term's cell grid and draws the changes. The target
+// is added if not already attached. Passing a nil term detaches the
+// terminal and restores the debug text output.
+func (v *Viewer) AttachTerminal(target AnsiTarget, term *Terminal) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	ts := v.findTarget(target)
+	if ts == nil {
+		ts = &targetState{target: target}
+		v.targets = append(v.targets, ts)
+	}
+	ts.term = term
+	ts.hasRendered = false
+	if term != nil {
+		term.Invalidate()
+	}
+}
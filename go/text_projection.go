@@ -15,13 +15,35 @@ type TextProjectionOptions struct {
 	BoxSeparatorColumn string
 
 	// FullScrollContent includes scroll content beyond the visible range.
+	// When false, a scroll node's children outside its scrolled viewport
+	// are left out, judged by the tree's computed layout; sticky
+	// children pinned to the viewport always show.
 	FullScrollContent bool
 
-	// MaxWidth for wrapping (0 = no wrap).
+	// MaxWidth wraps text content to this many cells, including the
+	// indent, measured with StringWidth (0 = no wrap).
 	MaxWidth int
 
+	// AlignTables pads data-row columns to a common display width,
+	// separated by two spaces, instead of joining them with tabs.
+	AlignTables bool
+
 	// IndentSize is the number of spaces per nesting level.
 	IndentSize int
+
+	// MediaPlaceholders describes image and canvas nodes that lack
+	// AltText by format, dimensions, and size (see MediaPlaceholder)
+	// instead of a bare "[image]".
+	MediaPlaceholders bool
+
+	// RedactSecrets shows secret inputs as "[redacted]" rather than a
+	// bullet for each character of their value.
+	RedactSecrets bool
+
+	// Clock, if set, is read instead of the tree's clock for the time
+	// relative_time columns are measured from, so projections of them
+	// are reproducible.
+	Clock Clock
 }
 
 // DefaultTextProjectionOptions returns the default options.
@@ -32,6 +54,7 @@ func DefaultTextProjectionOptions() TextProjectionOptions {
 		FullScrollContent:  true,
 		MaxWidth:           0,
 		IndentSize:         0,
+		MediaPlaceholders:  true,
 	}
 }
 
@@ -49,8 +72,21 @@ func TextProjectionWithOptions(tree *RenderTree, opts TextProjectionOptions) str
 	return projectNode(tree.Root, tree, opts, 0)
 }
 
-// projectNode computes the text projection for a single node.
+// projectNode computes the text projection for a single node, followed
+// by its tooltip if it is showing, or nothing if it is hidden.
 func projectNode(node *RenderNode, tree *RenderTree, opts TextProjectionOptions, depth int) string {
+	if node != nil && isHidden(node) {
+		return ""
+	}
+	text := projectContent(node, tree, opts, depth)
+	if tip, ok := tooltipText(tree, node); ok {
+		text += "\n" + strings.Repeat(" ", depth*opts.IndentSize) + "tooltip: " + tip
+	}
+	return text
+}
+
+// projectContent computes the text projection of a node's own content.
+func projectContent(node *RenderNode, tree *RenderTree, opts TextProjectionOptions, depth int) string {
 	if node == nil {
 		return ""
 	}
@@ -71,9 +107,15 @@ func projectNode(node *RenderNode, tree *RenderTree, opts TextProjectionOptions,
 		if node.Props.Content != nil {
 			content = *node.Props.Content
 		}
+		if opts.MaxWidth > 0 || node.Props.TextOverflow == OverflowEllipsis {
+			return fitProjected(content, node.Props.TextOverflow, indent, opts.MaxWidth)
+		}
 		return indent + content
 
 	case NodeBox:
+		if node.Props.Direction == DirectionGrid {
+			return projectGrid(node, tree, opts, depth)
+		}
 		dir := node.Props.Direction
 		if dir == "" {
 			dir = "column"
@@ -85,6 +127,9 @@ func projectNode(node *RenderNode, tree *RenderTree, opts TextProjectionOptions,
 
 		childTexts := make([]string, 0, len(node.Children))
 		for _, child := range node.Children {
+			if !projectedChild(node, child, opts) {
+				continue
+			}
 			t := projectNode(child, tree, opts, depth+1)
 			if len(t) > 0 {
 				childTexts = append(childTexts, t)
@@ -95,6 +140,9 @@ func projectNode(node *RenderNode, tree *RenderTree, opts TextProjectionOptions,
 	case NodeScroll:
 		childTexts := make([]string, 0, len(node.Children))
 		for _, child := range node.Children {
+			if !projectedChild(node, child, opts) {
+				continue
+			}
 			t := projectNode(child, tree, opts, depth+1)
 			if len(t) > 0 {
 				childTexts = append(childTexts, t)
@@ -110,7 +158,7 @@ func projectNode(node *RenderNode, tree *RenderTree, opts TextProjectionOptions,
 					rows := tree.DataRows[schemaSlotID]
 					schema := tree.Schemas[schemaSlotID]
 					if rows != nil && schema != nil {
-						dataText := projectDataRows(rows, schema)
+						dataText := projectDataRows(rows, schema, opts.AlignTables, projectionFormat(tree, opts))
 						if dataText != "" {
 							childTexts = append(childTexts, dataText)
 						}
@@ -123,17 +171,33 @@ func projectNode(node *RenderNode, tree *RenderTree, opts TextProjectionOptions,
 
 	case NodeInput:
 		if node.Props.Value != nil {
-			return indent + *node.Props.Value
+			return indent + projectedValue(node, opts.RedactSecrets)
 		}
 		if node.Props.Placeholder != nil {
 			return indent + *node.Props.Placeholder
 		}
 		return indent
 
+	case NodeCheckbox, NodeRadio, NodeSelect, NodeButton:
+		return indent + controlText(node)
+
+	case NodeTable:
+		schema, rows := tableData(tree, node)
+		if len(schema) == 0 {
+			return indent
+		}
+		return indent + projectDataRows(rows, schema, opts.AlignTables, projectionFormat(tree, opts))
+
+	case NodeProgress, NodeSpinner:
+		return indent + progressText(node)
+
 	case NodeImage, NodeCanvas:
 		if node.Props.AltText != nil {
 			return indent + *node.Props.AltText
 		}
+		if opts.MediaPlaceholders {
+			return indent + MediaPlaceholder(node)
+		}
 		return indent + "[image]"
 
 	case NodeSeparator:
@@ -144,43 +208,205 @@ func projectNode(node *RenderNode, tree *RenderTree, opts TextProjectionOptions,
 	}
 }
 
-// projectDataRows formats data rows as a TSV-like table.
-func projectDataRows(rows [][]interface{}, schema []SchemaColumn) string {
+// scrolledIntoView reports whether a scroll node's child overlaps its
+// scrolled viewport, or true if either has not been laid out.
+func scrolledIntoView(scroll, child *RenderNode) bool {
+	view, c := scroll.ComputedLayout, child.ComputedLayout
+	if view == nil || c == nil {
+		return true
+	}
+	top := view.Y
+	if scroll.Props.ScrollTop != nil {
+		top += float64(*scroll.Props.ScrollTop)
+	}
+	return c.Y < top+view.Height && c.Y+c.Height > top
+}
+
+// MediaPlaceholder describes an image or canvas node for display in place
+// of its content, e.g. "[png 320×200 14KB]" or "[canvas vector2d 300×150]".
+// Parts that are unknown are left out; with nothing known it is
+// "[image]" or "[canvas]".
+func MediaPlaceholder(node *RenderNode) string {
+	p := node.Props
+	var parts []string
+	var dims string
+
+	switch node.Type {
+	case NodeImage:
+		format := normalizeImageFormat(p.Format)
+		if info, err := ProbeImage(p.Data, p.Format); err == nil {
+			format = info.Format
+			if info.Width > 0 && info.Height > 0 {
+				dims = fmt.Sprintf("%d×%d", info.Width, info.Height)
+			}
+		}
+		if format != "" {
+			parts = append(parts, format)
+		}
+	case NodeCanvas:
+		parts = append(parts, "canvas")
+		if p.Mode != "" {
+			parts = append(parts, p.Mode)
+		}
+	}
+
+	if dims == "" {
+		w, wok := toFloat(p.Width)
+		h, hok := toFloat(p.Height)
+		if wok && hok {
+			dims = fmt.Sprintf("%d×%d", int(w), int(h))
+		}
+	}
+	if dims != "" {
+		parts = append(parts, dims)
+	}
+	if len(p.Data) > 0 {
+		parts = append(parts, formatByteSize(len(p.Data)))
+	}
+
+	if len(parts) == 0 {
+		return "[image]"
+	}
+	return "[" + strings.Join(parts, " ") + "]"
+}
+
+// formatByteSize formats a byte count as B, KB, or MB.
+func formatByteSize(n int) string {
+	switch {
+	case n < 1024:
+		return fmt.Sprintf("%dB", n)
+	case n < 1024*1024:
+		return fmt.Sprintf("%dKB", (n+512)/1024)
+	default:
+		return fmt.Sprintf("%.1fMB", float64(n)/(1024*1024))
+	}
+}
+
+// fitProjected fits content to maxWidth cells after the indent, which
+// starts every resulting line, following the node's textOverflow: lines
+// are wrapped unless the node clips them or shows a single line with an
+// ellipsis. maxWidth 0 leaves the width unlimited.
+func fitProjected(content, overflow, indent string, maxWidth int) string {
+	cols := 0
+	if maxWidth > 0 {
+		cols = max(1, maxWidth-StringWidth(indent))
+	}
+	if overflow == "" {
+		overflow = OverflowWrap
+	}
+	lines := fitLines(content, overflow, cols)
+	if overflow == OverflowClip && cols > 0 {
+		for i, line := range lines {
+			lines[i] = TruncateWidth(line, cols, "")
+		}
+	}
+	for i, line := range lines {
+		lines[i] = indent + line
+	}
+	return strings.Join(lines, "\n")
+}
+
+// projectionFormat returns how a projection of tree formats values, with
+// relative times measured by opts.Clock if it is set, or the tree's
+// clock, shifted to the source's.
+func projectionFormat(tree *RenderTree, opts TextProjectionOptions) valueFormat {
+	f := treeFormat(tree)
+	if opts.Clock != nil {
+		f.now = opts.Clock.Now().Add(tree.ClockOffset)
+	}
+	return f
+}
+
+// projectDataRows formats data rows as a TSV-like table, or with columns
+// padded to their display width if align is set, formatting values by f.
+func projectDataRows(rows [][]interface{}, schema []SchemaColumn, align bool, f valueFormat) string {
 	if len(rows) == 0 {
 		return ""
 	}
 
-	var lines []string
-
 	// Header
 	headers := make([]string, len(schema))
 	for i, col := range schema {
 		headers[i] = col.Name
 	}
-	lines = append(lines, strings.Join(headers, "\t"))
+	table := [][]string{headers}
 
 	// Data rows
 	for _, row := range rows {
 		cells := make([]string, len(schema))
 		for i, col := range schema {
 			if i < len(row) {
-				cells[i] = formatValue(row[i], col)
+				cells[i] = formatValue(row[i], col, f)
 			} else {
 				cells[i] = ""
 			}
 		}
-		lines = append(lines, strings.Join(cells, "\t"))
+		table = append(table, cells)
 	}
 
+	lines := make([]string, len(table))
+	if !align {
+		for i, cells := range table {
+			lines[i] = strings.Join(cells, "\t")
+		}
+		return strings.Join(lines, "\n")
+	}
+
+	widths := make([]int, len(schema))
+	for _, cells := range table {
+		for i, c := range cells {
+			widths[i] = max(widths[i], StringWidth(c))
+		}
+	}
+	for r, cells := range table {
+		padded := make([]string, len(cells))
+		for i, c := range cells {
+			if i < len(cells)-1 {
+				c = PadWidth(c, widths[i])
+			}
+			padded[i] = c
+		}
+		lines[r] = strings.TrimRight(strings.Join(padded, "  "), " ")
+	}
 	return strings.Join(lines, "\n")
 }
 
+// valueFormat is what formatting data values depends on besides the
+// values: the time relative times are measured from, the zone absolute
+// times are shown in (nil for local), and the locale numbers are
+// written in.
+type valueFormat struct {
+	now    time.Time
+	zone   *time.Location
+	locale string
+}
+
+// treeFormat returns how tree's values are formatted.
+func treeFormat(tree *RenderTree) valueFormat {
+	if tree == nil {
+		return valueFormat{now: time.Now()}
+	}
+	return valueFormat{now: sourceNow(tree), zone: tree.TimeZone, locale: tree.Locale}
+}
+
 // formatValue formats a single data value for text projection.
-func formatValue(value interface{}, column SchemaColumn) string {
+func formatValue(value interface{}, column SchemaColumn, f valueFormat) string {
 	if value == nil {
 		return ""
 	}
 
+	if column.Format == "number" {
+		if s, ok := formatNumber(value, column.Precision, localeFor(column, f.locale)); ok {
+			return s
+		}
+	}
+
+	if column.Format == "compact_number" {
+		if s, ok := compactNumber(value, column.Precision, localeFor(column, f.locale)); ok {
+			return s
+		}
+	}
+
 	if column.Format == "human_bytes" {
 		if n, ok := toFloat(value); ok {
 			return humanBytes(n)
@@ -189,7 +415,13 @@ func formatValue(value interface{}, column SchemaColumn) string {
 
 	if column.Format == "relative_time" {
 		if n, ok := toFloat(value); ok {
-			return relativeTime(n)
+			return relativeTime(n, f.now)
+		}
+	}
+
+	if column.Format == "absolute_time" {
+		if n, ok := toFloat(value); ok {
+			return absoluteTime(n, column, f.zone)
 		}
 	}
 
@@ -211,10 +443,10 @@ func humanBytes(bytes float64) string {
 	return fmt.Sprintf("%.1f %s", b, units[i])
 }
 
-// relativeTime formats a Unix timestamp as a relative time string.
-func relativeTime(timestamp float64) string {
-	now := float64(time.Now().Unix())
-	diff := now - timestamp
+// relativeTime formats a Unix timestamp as a relative time string, as
+// seen at now.
+func relativeTime(timestamp float64, now time.Time) string {
+	diff := float64(now.Unix()) - timestamp
 	if diff < 0 {
 		diff = math.Abs(diff)
 	}
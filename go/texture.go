@@ -0,0 +1,105 @@
+package viewer
+
+import (
+	"errors"
+	"image"
+)
+
+// GPU texture output. A TextureTarget renders each frame with the
+// Rasterizer and uploads the damaged regions into a texture through a
+// GPUBackend, so hosts compositing their own scenes can draw the UI as a
+// textured quad. The texture is RGBA, non-premultiplied, with row 0 at
+// the top; it is recreated when the display size changes.
+//
+// Backends make GPU calls from Render, so the viewer must be rendered on
+// the thread that owns the GPU context. The OpenGL backend is built with
+// the viewport_gl tag (and cgo); other hosts supply their own, for wgpu,
+// Vulkan, or a game engine's texture API.
+
+// ErrNoGPUBackend is reported when a TextureTarget has no backend and
+// none was built in.
+var ErrNoGPUBackend = errors.New("texture target: no GPU backend (build with -tags viewport_gl or set TextureTarget.Backend)")
+
+// TextureHandle identifies a backend texture: a GL texture name, or an
+// index into the host's own table.
+type TextureHandle uint64
+
+// GPUBackend creates and updates RGBA textures. Implementations are
+// compared by the viewer to find targets, so they should be pointers.
+type GPUBackend interface {
+	// CreateTexture allocates a width × height RGBA texture.
+	CreateTexture(width, height int) (TextureHandle, error)
+
+	// UpdateTexture uploads the given regions of frame, which is the
+	// size of the texture.
+	UpdateTexture(tex TextureHandle, frame *image.RGBA, regions []image.Rectangle) error
+
+	// DeleteTexture releases a texture.
+	DeleteTexture(tex TextureHandle)
+}
+
+// Texture returns the texture a TextureTarget last rendered into and its
+// size. Returns false if target is not an attached texture target or has
+// no texture yet.
+func (v *Viewer) Texture(target RenderTarget) (tex TextureHandle, width, height int, ok bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	ts := v.findTarget(target)
+	if ts == nil || ts.texture == nil {
+		return 0, 0, 0, false
+	}
+	return ts.texture.handle, ts.texture.size.X, ts.texture.size.Y, true
+}
+
+// gpuTexture is a texture target's current texture.
+type gpuTexture struct {
+	backend GPUBackend
+	handle  TextureHandle
+	size    image.Point
+}
+
+// textureBackend returns the backend a texture target uses.
+func textureBackend(t TextureTarget) GPUBackend {
+	if t.Backend != nil {
+		return t.Backend
+	}
+	return defaultGPUBackend()
+}
+
+// uploadTexture copies a raster render into the target's texture,
+// creating it on first use or after a resize. Must be called with the
+// mutex held.
+func (v *Viewer) uploadTexture(ts *targetState, backend GPUBackend, res RenderResult) {
+	if backend == nil {
+		v.reportError(ErrNoGPUBackend)
+		return
+	}
+	size := res.Image.Bounds().Size()
+	regions := res.Damage
+	if ts.texture == nil || ts.texture.size != size || ts.texture.backend != backend {
+		v.releaseTexture(ts)
+		handle, err := backend.CreateTexture(size.X, size.Y)
+		if err != nil {
+			v.reportError(err)
+			return
+		}
+		ts.texture = &gpuTexture{backend: backend, handle: handle, size: size}
+		regions = []image.Rectangle{res.Image.Bounds()}
+	}
+	if len(regions) == 0 {
+		return
+	}
+	if err := backend.UpdateTexture(ts.texture.handle, res.Image, regions); err != nil {
+		v.reportError(err)
+	}
+}
+
+// releaseTexture deletes a target's texture, if it has one.
+// Must be called with the mutex held.
+func (v *Viewer) releaseTexture(ts *targetState) {
+	if ts.texture != nil {
+		ts.texture.backend.DeleteTexture(ts.texture.handle)
+		ts.texture = nil
+	}
+}
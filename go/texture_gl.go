@@ -0,0 +1,86 @@
+//go:build viewport_gl && cgo
+
+package viewer
+
+/*
+#cgo linux pkg-config: gl
+#cgo darwin LDFLAGS: -framework OpenGL
+#cgo windows LDFLAGS: -lopengl32
+#ifdef __APPLE__
+#include <OpenGL/gl.h>
+#else
+#include <GL/gl.h>
+#endif
+*/
+import "C"
+
+import (
+	"fmt"
+	"image"
+	"unsafe"
+)
+
+// GLBackend uploads frames to OpenGL 2D textures in the current context.
+// It needs only OpenGL 1.1, so it also works in compatibility profiles of
+// later versions; a host on a core profile or GLES should supply its own
+// backend.
+type GLBackend struct{}
+
+var glBackend = &GLBackend{}
+
+// defaultGPUBackend returns the OpenGL backend.
+func defaultGPUBackend() GPUBackend { return glBackend }
+
+// CreateTexture allocates an RGBA8 texture with linear filtering and
+// clamped edges.
+func (b *GLBackend) CreateTexture(width, height int) (TextureHandle, error) {
+	var tex C.GLuint
+	C.glGenTextures(1, &tex)
+	if tex == 0 {
+		return 0, fmt.Errorf("texture target: glGenTextures failed (no current GL context?)")
+	}
+	C.glBindTexture(C.GL_TEXTURE_2D, tex)
+	C.glTexParameteri(C.GL_TEXTURE_2D, C.GL_TEXTURE_MIN_FILTER, C.GL_LINEAR)
+	C.glTexParameteri(C.GL_TEXTURE_2D, C.GL_TEXTURE_MAG_FILTER, C.GL_LINEAR)
+	C.glTexParameteri(C.GL_TEXTURE_2D, C.GL_TEXTURE_WRAP_S, C.GL_CLAMP_TO_EDGE)
+	C.glTexParameteri(C.GL_TEXTURE_2D, C.GL_TEXTURE_WRAP_T, C.GL_CLAMP_TO_EDGE)
+	C.glTexImage2D(C.GL_TEXTURE_2D, 0, C.GL_RGBA8, C.GLsizei(width), C.GLsizei(height), 0, C.GL_RGBA, C.GL_UNSIGNED_BYTE, nil)
+	if err := glError("glTexImage2D"); err != nil {
+		C.glDeleteTextures(1, &tex)
+		return 0, err
+	}
+	return TextureHandle(tex), nil
+}
+
+// UpdateTexture uploads each region straight from the frame's pixels,
+// using the unpack row length to step over the rest of each row.
+func (b *GLBackend) UpdateTexture(tex TextureHandle, frame *image.RGBA, regions []image.Rectangle) error {
+	C.glBindTexture(C.GL_TEXTURE_2D, C.GLuint(tex))
+	C.glPixelStorei(C.GL_UNPACK_ALIGNMENT, 4)
+	C.glPixelStorei(C.GL_UNPACK_ROW_LENGTH, C.GLint(frame.Stride/4))
+	defer C.glPixelStorei(C.GL_UNPACK_ROW_LENGTH, 0)
+	for _, r := range regions {
+		r = r.Intersect(frame.Bounds())
+		if r.Empty() {
+			continue
+		}
+		pix := unsafe.Pointer(&frame.Pix[frame.PixOffset(r.Min.X, r.Min.Y)])
+		C.glTexSubImage2D(C.GL_TEXTURE_2D, 0, C.GLint(r.Min.X), C.GLint(r.Min.Y), C.GLsizei(r.Dx()), C.GLsizei(r.Dy()),
+			C.GL_RGBA, C.GL_UNSIGNED_BYTE, pix)
+	}
+	return glError("glTexSubImage2D")
+}
+
+// DeleteTexture deletes the texture.
+func (b *GLBackend) DeleteTexture(tex TextureHandle) {
+	t := C.GLuint(tex)
+	C.glDeleteTextures(1, &t)
+}
+
+// glError returns the pending GL error, if any.
+func glError(call string) error {
+	if code := C.glGetError(); code != C.GL_NO_ERROR {
+		return fmt.Errorf("texture target: %s: GL error 0x%x", call, int(code))
+	}
+	return nil
+}
@@ -0,0 +1,6 @@
+//go:build !viewport_gl || !cgo
+
+package viewer
+
+// defaultGPUBackend is nil without the OpenGL backend.
+func defaultGPUBackend() GPUBackend { return nil }
@@ -0,0 +1,134 @@
+package viewer
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Absolute times. A timestamp column with the absolute_time format shows
+// each value as a date and time in a time zone, rather than how long ago
+// it was. The zone is the column's own (SchemaColumn.TimeZone, an IANA
+// name such as "Europe/Berlin") if it names one the viewer knows, and
+// otherwise the viewer's (SetTimeZone), which is the system's local zone
+// unless set. The column's Layout writes the time with strftime-style
+// directives, so sources in any language can give one; it defaults to
+// defaultTimeLayout.
+
+// defaultTimeLayout is how absolute times are written when a column sets
+// no layout.
+const defaultTimeLayout = "%Y-%m-%d %H:%M:%S"
+
+// SetTimeZone sets the time zone absolute_time columns are shown in,
+// unless they name their own. Nil means the system's local zone.
+func (v *Viewer) SetTimeZone(loc *time.Location) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.timeZone = loc
+	v.tree.TimeZone = loc
+	v.markDirty()
+	v.signalChanged()
+}
+
+// absoluteTime formats a Unix timestamp, in seconds, for a column in
+// zone, or the column's own zone if it names one.
+func absoluteTime(timestamp float64, column SchemaColumn, zone *time.Location) string {
+	if loc := loadZone(column.TimeZone); loc != nil {
+		zone = loc
+	}
+	if zone == nil {
+		zone = time.Local
+	}
+	sec, frac := math.Modf(timestamp)
+	t := time.Unix(int64(sec), int64(frac*1e9)).In(zone)
+	layout := column.Layout
+	if layout == "" {
+		layout = defaultTimeLayout
+	}
+	return strftime(t, layout)
+}
+
+// zones caches time zones by name; a nil entry is a name that failed to
+// load.
+var zones sync.Map
+
+// loadZone returns the time zone with an IANA name, or nil if name is
+// empty or unknown.
+func loadZone(name string) *time.Location {
+	if name == "" {
+		return nil
+	}
+	if loc, ok := zones.Load(name); ok {
+		return loc.(*time.Location)
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		loc = nil
+	}
+	zones.Store(name, loc)
+	return loc
+}
+
+// strftime writes t by a strftime-style layout. It knows %Y %y %m %d %e
+// %H %I %M %S %p %b %B %a %A %j %Z %z %F %T and %%; other directives are
+// written as they are.
+func strftime(t time.Time, layout string) string {
+	var sb strings.Builder
+	for i := 0; i < len(layout); i++ {
+		c := layout[i]
+		if c != '%' || i+1 == len(layout) {
+			sb.WriteByte(c)
+			continue
+		}
+		i++
+		switch layout[i] {
+		case 'Y':
+			fmt.Fprintf(&sb, "%04d", t.Year())
+		case 'y':
+			fmt.Fprintf(&sb, "%02d", t.Year()%100)
+		case 'm':
+			fmt.Fprintf(&sb, "%02d", int(t.Month()))
+		case 'd':
+			fmt.Fprintf(&sb, "%02d", t.Day())
+		case 'e':
+			fmt.Fprintf(&sb, "%2d", t.Day())
+		case 'H':
+			fmt.Fprintf(&sb, "%02d", t.Hour())
+		case 'I':
+			fmt.Fprintf(&sb, "%02d", (t.Hour()+11)%12+1)
+		case 'M':
+			fmt.Fprintf(&sb, "%02d", t.Minute())
+		case 'S':
+			fmt.Fprintf(&sb, "%02d", t.Second())
+		case 'p':
+			sb.WriteString(t.Format("PM"))
+		case 'b':
+			sb.WriteString(t.Format("Jan"))
+		case 'B':
+			sb.WriteString(t.Format("January"))
+		case 'a':
+			sb.WriteString(t.Format("Mon"))
+		case 'A':
+			sb.WriteString(t.Format("Monday"))
+		case 'j':
+			fmt.Fprintf(&sb, "%03d", t.YearDay())
+		case 'Z':
+			sb.WriteString(t.Format("MST"))
+		case 'z':
+			sb.WriteString(t.Format("-0700"))
+		case 'F':
+			sb.WriteString(t.Format("2006-01-02"))
+		case 'T':
+			sb.WriteString(t.Format("15:04:05"))
+		case '%':
+			sb.WriteByte('%')
+		default:
+			sb.WriteByte('%')
+			sb.WriteByte(layout[i])
+		}
+	}
+	return sb.String()
+}
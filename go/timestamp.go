@@ -0,0 +1,47 @@
+package viewer
+
+import "time"
+
+// Message timestamps. A source that sets SourceState.Timestamps stamps
+// the last message of each flush with its clock (ProtocolMessage.Sent),
+// and the viewer measures how long the message took to reach it, from
+// the stamp to when it is processed. The delays are smoothed into
+// ViewerMetrics.TransportDelayMs and, being a more direct measure than
+// the heartbeat's round trip, take over EnvInfo.LatencyMs once the first
+// one arrives. The stamp is read by the source's clock as estimated by
+// clock sync (see SyncClock); a stamp from the future, which an estimate
+// off by more than the delay gives, is counted as no delay.
+
+// receiveTimestamp records the transport delay of a message the source
+// sent at sent, in Unix microseconds by its clock.
+// Must be called with the mutex held.
+func (v *Viewer) receiveTimestamp(sent int64) {
+	ms := float64(v.now().Add(v.tree.ClockOffset).UnixMicro()-sent) / 1000
+	ms = max(ms, 0)
+	if v.delaySamples == 0 {
+		v.transportDelayMs = ms
+	} else {
+		v.transportDelayMs += latencySmoothing * (ms - v.transportDelayMs)
+	}
+	v.delaySamples++
+	v.setLatency(v.transportDelayMs)
+}
+
+// setLatency updates EnvInfo.LatencyMs, if the environment is known.
+// Must be called with the mutex held.
+func (v *Viewer) setLatency(ms float64) {
+	if v.env == nil {
+		return
+	}
+	env := *v.env
+	env.LatencyMs = ms
+	v.env = &env
+}
+
+// stamp returns the time to put in ProtocolMessage.Sent.
+func (s *SourceState) stamp() int64 {
+	if s.Clock == nil {
+		return time.Now().UnixMicro()
+	}
+	return s.Clock.Now().UnixMicro()
+}
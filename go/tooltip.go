@@ -0,0 +1,165 @@
+package viewer
+
+import (
+	"image"
+	"math"
+	"strings"
+	"time"
+
+	"golang.org/x/image/draw"
+)
+
+// Tooltips. A node's tooltip prop is text that shows in a box near it
+// once the pointer has rested over it, or focus has stayed on it, for
+// tooltipDelay. The box goes below the node, or above it if there is no
+// room below, and is drawn over everything else, inverted on the cell
+// grid and in the text color in the rasterizer. Moving off the node,
+// pressing, moving focus away, or escape hides it. While a tooltip shows,
+// text projections give it on a line after its node's text, and the HTML
+// renderer always gives tooltips as title attributes.
+//
+// Tick shows a tooltip when its delay is up, so it reports an animation
+// running while one is waiting.
+
+// tooltipDelay is how long the pointer or focus rests on a node before
+// its tooltip shows.
+const tooltipDelay = 500 * time.Millisecond
+
+// tooltipMaxWidth is the widest a tooltip's text runs before wrapping,
+// in characters.
+const tooltipMaxWidth = 40
+
+// tooltipKey is the rasterizer's snapshot entry for the tooltip, which is
+// not a node.
+const tooltipKey = -1
+
+// tooltipNode returns the innermost node along path with a tooltip, or
+// nil if none has one.
+func tooltipNode(path []hitNode) *RenderNode {
+	for i := len(path) - 1; i >= 0; i-- {
+		if path[i].node.Props.Tooltip != nil {
+			return path[i].node
+		}
+	}
+	return nil
+}
+
+// hoverTooltip starts the delay for a node's tooltip, hiding any other,
+// or hides tooltips if node is nil.
+// Must be called with the mutex held.
+func (v *Viewer) hoverTooltip(node *RenderNode) {
+	if node != nil && node.Props.Tooltip == nil {
+		node = nil
+	}
+	if node != nil && v.tooltip != nil && *v.tooltip == node.ID {
+		return
+	}
+	v.tooltip = nil
+	if node != nil {
+		id := node.ID
+		v.tooltip, v.tooltipSince = &id, v.now()
+	}
+	if v.tree.Tooltip != nil {
+		v.tree.Tooltip = nil
+		v.markDirty()
+		v.signalChanged()
+	}
+}
+
+// showTooltip shows the waiting tooltip once its delay is up. Returns
+// whether one is still waiting.
+// Must be called with the mutex held.
+func (v *Viewer) showTooltip() bool {
+	if v.tooltip == nil || v.tree.Tooltip != nil {
+		return false
+	}
+	if v.tree.NodeIndex[*v.tooltip] == nil {
+		v.tooltip = nil
+		return false
+	}
+	if v.now().Sub(v.tooltipSince) < tooltipDelay {
+		return true
+	}
+	id := *v.tooltip
+	v.tree.Tooltip = &id
+	v.markDirty()
+	v.signalChanged()
+	return false
+}
+
+// tooltipText returns the tooltip showing for a node, if any.
+func tooltipText(tree *RenderTree, node *RenderNode) (string, bool) {
+	if tree == nil || tree.Tooltip == nil || *tree.Tooltip != node.ID || node.Props.Tooltip == nil {
+		return "", false
+	}
+	return *node.Props.Tooltip, true
+}
+
+// tooltipBox returns where the showing tooltip goes on a screen width by
+// height, in layout units, and its lines.
+func tooltipBox(tree *RenderTree, layouts map[int]*ComputedLayout, opts LayoutOptions, width, height float64) (box ComputedLayout, lines []string, ok bool) {
+	if tree.Tooltip == nil {
+		return ComputedLayout{}, nil, false
+	}
+	node := tree.NodeIndex[*tree.Tooltip]
+	a := layouts[*tree.Tooltip]
+	if node == nil || a == nil {
+		return ComputedLayout{}, nil, false
+	}
+	text, _ := tooltipText(tree, node)
+	cols := 0
+	for _, para := range strings.Split(text, "\n") {
+		for _, line := range WrapWidth(para, tooltipMaxWidth) {
+			lines = append(lines, line)
+			cols = max(cols, StringWidth(line))
+		}
+	}
+	box.Width = float64(cols+2) * opts.CharWidth
+	box.Height = float64(len(lines)) * opts.LineHeight
+	top := a.Y - scrollOffset(tree.Root, node.ID)
+	box.Y = top + a.Height
+	if box.Y+box.Height > height {
+		box.Y = math.Max(top-box.Height, 0)
+	}
+	box.X = math.Max(math.Min(a.X, width-box.Width), 0)
+	return box, lines, true
+}
+
+// drawTooltip draws the showing tooltip over the grid.
+func (d *gridDrawer) drawTooltip(tree *RenderTree) {
+	box, lines, ok := tooltipBox(tree, d.layouts, CellLayoutOptions(), float64(d.grid.Width), float64(d.grid.Height))
+	if !ok {
+		return
+	}
+	clip := rect{0, 0, d.grid.Width, d.grid.Height}
+	style := CellStyle{Inverse: true}
+	x, y, w := int(box.X), int(box.Y), int(box.Width)
+	for i, line := range lines {
+		d.text(x, y+i, " "+PadWidth(line, w-1), style, clip)
+	}
+}
+
+// tooltipSnapshot returns the rasterizer's snapshot of the showing
+// tooltip.
+func (d *rasterDrawer) tooltipSnapshot(tree *RenderTree, bounds image.Rectangle) (rasterNode, bool) {
+	box, lines, ok := tooltipBox(tree, d.layouts, PixelLayoutOptions(), float64(bounds.Dx()), float64(bounds.Dy()))
+	if !ok {
+		return rasterNode{}, false
+	}
+	return rasterNode{
+		rect:    image.Rect(int(box.X), int(box.Y), int(box.X+box.Width), int(box.Y+box.Height)),
+		text:    strings.Join(lines, "\n"),
+		metrics: rasterMetrics(nil),
+		opacity: 1,
+	}, true
+}
+
+// drawTooltip paints the tooltip in the text color with its text in the
+// background color.
+func (d *rasterDrawer) drawTooltip(n rasterNode, clip image.Rectangle) {
+	draw.Draw(d.img, n.rect.Intersect(clip), image.NewUniform(rasterForeground), image.Point{}, draw.Src)
+	style := CellStyle{FG: hexColor(rasterBackground)}
+	for i, line := range strings.Split(n.text, "\n") {
+		d.text(n.rect.Min.X+n.metrics.cellW, n.rect.Min.Y+i*n.metrics.lineH, line, style, n.metrics, clip)
+	}
+}
@@ -0,0 +1,207 @@
+package viewer
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strings"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/math/f64"
+)
+
+// Transforms. A node's transform prop moves, scales, and rotates it and
+// everything in it as drawn, about the center of its box, without
+// changing its layout or its siblings': it is translated by translateX
+// and translateY pixels, rotated by rotate degrees clockwise, and scaled
+// by scaleX and scaleY (1 when unset), like the CSS transform
+// "translate() rotate() scale()". Transforms nest, a child's applying
+// within its transformed parent.
+//
+// The rasterizer draws a transformed node and its subtree into a layer,
+// then composites the layer through the transform with bilinear
+// filtering. Pointer input on pixel targets is mapped back through the
+// inverse transforms, so a rotated button is pressed where it is drawn.
+// Cells cannot be rotated or scaled, so the cell grid draws nodes
+// untransformed and hit tests them there. The HTML renderer gives the
+// CSS transform.
+
+// transformOf returns a node's transform, or nil if it has none or it
+// does nothing.
+func transformOf(node *RenderNode) *Transform {
+	t := node.Props.Transform
+	if t == nil {
+		return nil
+	}
+	if sx, sy := t.scales(); t.TranslateX == 0 && t.TranslateY == 0 && t.Rotate == 0 && sx == 1 && sy == 1 {
+		return nil
+	}
+	return t
+}
+
+// scales returns a transform's scale factors.
+func (t Transform) scales() (sx, sy float64) {
+	sx, sy = t.ScaleX, t.ScaleY
+	if sx == 0 {
+		sx = 1
+	}
+	if sy == 0 {
+		sy = 1
+	}
+	return sx, sy
+}
+
+// matrix returns the transform of a box at x, y sized w × h, about the
+// box's center.
+func (t Transform) matrix(x, y, w, h float64) f64.Aff3 {
+	sx, sy := t.scales()
+	sin, cos := math.Sincos(t.Rotate * math.Pi / 180)
+	cx, cy := x+w/2, y+h/2
+	a, b := cos*sx, -sin*sy
+	d, e := sin*sx, cos*sy
+	return f64.Aff3{
+		a, b, cx + t.TranslateX - a*cx - b*cy,
+		d, e, cy + t.TranslateY - d*cx - e*cy,
+	}
+}
+
+// identity is the transform that leaves points where they are.
+var identity = f64.Aff3{1, 0, 0, 0, 1, 0}
+
+// compose returns the transform applying n, then m.
+func compose(m, n f64.Aff3) f64.Aff3 {
+	return f64.Aff3{
+		m[0]*n[0] + m[1]*n[3], m[0]*n[1] + m[1]*n[4], m[0]*n[2] + m[1]*n[5] + m[2],
+		m[3]*n[0] + m[4]*n[3], m[3]*n[1] + m[4]*n[4], m[3]*n[2] + m[4]*n[5] + m[5],
+	}
+}
+
+// invert returns the inverse of m, or false if it collapses the plane.
+func invert(m f64.Aff3) (f64.Aff3, bool) {
+	det := m[0]*m[4] - m[1]*m[3]
+	if det == 0 {
+		return f64.Aff3{}, false
+	}
+	a, b, d, e := m[4]/det, -m[1]/det, -m[3]/det, m[0]/det
+	return f64.Aff3{a, b, -a*m[2] - b*m[5], d, e, -d*m[2] - e*m[5]}, true
+}
+
+// apply maps a point through m.
+func apply(m f64.Aff3, x, y float64) (float64, float64) {
+	return m[0]*x + m[1]*y + m[2], m[3]*x + m[4]*y + m[5]
+}
+
+// transformRect returns the bounding box of r mapped through m.
+func transformRect(m f64.Aff3, r image.Rectangle) image.Rectangle {
+	if r.Empty() {
+		return r
+	}
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, p := range []image.Point{r.Min, {r.Max.X, r.Min.Y}, {r.Min.X, r.Max.Y}, r.Max} {
+		x, y := apply(m, float64(p.X), float64(p.Y))
+		minX, minY = math.Min(minX, x), math.Min(minY, y)
+		maxX, maxY = math.Max(maxX, x), math.Max(maxY, y)
+	}
+	return image.Rect(int(math.Floor(minX)), int(math.Floor(minY)), int(math.Ceil(maxX)), int(math.Ceil(maxY)))
+}
+
+// rectMatrix returns a node's transform about its rectangle r in the
+// rasterizer.
+func rectMatrix(t *Transform, r image.Rectangle) f64.Aff3 {
+	return t.matrix(float64(r.Min.X), float64(r.Min.Y), float64(r.Dx()), float64(r.Dy()))
+}
+
+// local maps a point in a node's parent's coordinates into the node's
+// own, undoing its transform if the input target draws transforms. dy is
+// the scroll offset applied to the node's layout.
+func (in *inputLayout) local(node *RenderNode, x, y, dy float64) (float64, float64) {
+	t, l := transformOf(node), in.layouts[node.ID]
+	if !in.transforms || t == nil || l == nil {
+		return x, y
+	}
+	inv, ok := invert(t.matrix(l.X, l.Y-dy, l.Width, l.Height))
+	if !ok {
+		return math.Inf(-1), math.Inf(-1)
+	}
+	return apply(inv, x, y)
+}
+
+// transformed draws a transformed node's subtree into a layer and
+// composites it onto the image through the transform.
+func (d *rasterDrawer) transformed(node *RenderNode, n rasterNode, t *Transform, clip image.Rectangle) {
+	m := rectMatrix(t, n.rect)
+	src := n.bounds()
+	if transformRect(m, src).Intersect(clip).Empty() {
+		return
+	}
+	dst := d.img
+	d.img = image.NewRGBA(src)
+	d.drawPlain(node, n, src)
+	layer := d.img
+	d.img = dst
+	if sub, ok := d.img.SubImage(clip).(*image.RGBA); ok {
+		draw.BiLinear.Transform(sub, m, layer, src, draw.Over, nil)
+	}
+}
+
+// htmlTransform converts a transform to CSS.
+func htmlTransform(t *Transform) string {
+	var css []string
+	if t.TranslateX != 0 || t.TranslateY != 0 {
+		css = append(css, fmt.Sprintf("translate(%gpx,%gpx)", t.TranslateX, t.TranslateY))
+	}
+	if t.Rotate != 0 {
+		css = append(css, fmt.Sprintf("rotate(%gdeg)", t.Rotate))
+	}
+	if sx, sy := t.scales(); sx != 1 || sy != 1 {
+		css = append(css, fmt.Sprintf("scale(%g,%g)", sx, sy))
+	}
+	return strings.Join(css, " ")
+}
+
+// parseTransform reads a transform prop set by a patch: a Transform, or
+// a map of its fields, where "scale" is shorthand for both factors.
+func parseTransform(v interface{}) (Transform, bool) {
+	switch t := v.(type) {
+	case Transform:
+		return t, true
+	case *Transform:
+		if t != nil {
+			return *t, true
+		}
+		return Transform{}, true
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, x := range t {
+			if s, ok := k.(string); ok {
+				m[s] = x
+			}
+		}
+		return parseTransform(m)
+	case map[string]interface{}:
+		var out Transform
+		for k, x := range t {
+			f, ok := toFloat(x)
+			if !ok {
+				continue
+			}
+			switch k {
+			case "translateX":
+				out.TranslateX = f
+			case "translateY":
+				out.TranslateY = f
+			case "scaleX":
+				out.ScaleX = f
+			case "scaleY":
+				out.ScaleY = f
+			case "scale":
+				out.ScaleX, out.ScaleY = f, f
+			case "rotate":
+				out.Rotate = f
+			}
+		}
+		return out, true
+	}
+	return Transform{}, false
+}
@@ -1,6 +1,14 @@
 package viewer
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// ErrPatchFailed is returned when an operation in an atomic patch batch
+// cannot be applied.
+var ErrPatchFailed = errors.New("patch operation failed")
 
 // NewRenderTree creates an empty render tree with initialized maps.
 func NewRenderTree() *RenderTree {
@@ -37,6 +45,7 @@ func VNodeToRenderNode(vnode *VNode, index map[int]*RenderNode) *RenderNode {
 		node.Props.TextAlt = vnode.TextAlt
 	}
 
+	decodeSizes(&node.Props)
 	index[node.ID] = node
 	return node
 }
@@ -67,18 +76,49 @@ func ApplyPatch(tree *RenderTree, op PatchOp) bool {
 		return false
 	}
 
-	// Set properties
+	// Resolve the insert first, among the children it will see, so an
+	// insert next to a missing sibling fails before anything changes.
+	idx := 0
+	if op.ChildrenInsert != nil {
+		siblings := node
+		if op.ChildrenSet != nil {
+			siblings = &RenderNode{}
+			for _, c := range op.ChildrenSet.Nodes {
+				if c != nil {
+					siblings.Children = append(siblings.Children, &RenderNode{ID: c.ID})
+				}
+			}
+		}
+		if idx, ok = insertIndex(siblings, op.ChildrenInsert); !ok {
+			return false
+		}
+	}
+
+	// Unset, then set properties
+	if op.Unset != nil {
+		applyPropsUnset(node, op.Unset)
+	}
 	if op.Set != nil {
 		applyPropsSet(node, op.Set)
 	}
 
+	// Replace all children
+	if op.ChildrenSet != nil {
+		for _, c := range node.Children {
+			removeSubtreeFromIndex(tree.NodeIndex, c)
+		}
+		children := make([]*RenderNode, 0, len(op.ChildrenSet.Nodes))
+		for _, c := range op.ChildrenSet.Nodes {
+			if c != nil {
+				children = append(children, VNodeToRenderNode(c, tree.NodeIndex))
+			}
+		}
+		node.Children = children
+	}
+
 	// Insert child
 	if op.ChildrenInsert != nil {
 		child := VNodeToRenderNode(op.ChildrenInsert.Node, tree.NodeIndex)
-		idx := op.ChildrenInsert.Index
-		if idx > len(node.Children) {
-			idx = len(node.Children)
-		}
 		// Insert at index
 		node.Children = append(node.Children, nil)
 		copy(node.Children[idx+1:], node.Children[idx:])
@@ -109,9 +149,49 @@ func ApplyPatch(tree *RenderTree, op PatchOp) bool {
 		}
 	}
 
+	// Remove a range of children
+	if r := op.ChildrenRemoveRange; r != nil {
+		end := min(r.Index+r.Count, len(node.Children))
+		if r.Index >= 0 && r.Index < end {
+			for _, c := range node.Children[r.Index:end] {
+				removeSubtreeFromIndex(tree.NodeIndex, c)
+			}
+			node.Children = append(node.Children[:r.Index], node.Children[end:]...)
+		}
+	}
+
+	// Move a range of children
+	if r := op.ChildrenMoveRange; r != nil {
+		n := len(node.Children)
+		if r.Count > 0 && r.From >= 0 && r.From+r.Count <= n && r.To >= 0 && r.To+r.Count <= n {
+			run := append([]*RenderNode{}, node.Children[r.From:r.From+r.Count]...)
+			rest := append(node.Children[:r.From], node.Children[r.From+r.Count:]...)
+			node.Children = append(rest[:r.To], append(run, rest[r.To:]...)...)
+		}
+	}
+
 	return true
 }
 
+// insertIndex resolves where a ChildrenInsert goes among node's current
+// children: next to its sibling, if it names one, or at its index.
+// Returns false if the sibling is not a child of node.
+func insertIndex(node *RenderNode, ins *ChildrenInsert) (int, bool) {
+	sibling, offset := ins.Before, 0
+	if sibling == nil {
+		sibling, offset = ins.After, 1
+	}
+	if sibling == nil {
+		return min(max(ins.Index, 0), len(node.Children)), true
+	}
+	for i, c := range node.Children {
+		if c.ID == *sibling {
+			return i + offset, true
+		}
+	}
+	return 0, false
+}
+
 // ApplyPatches applies a batch of patch operations.
 // Returns the count of successfully applied and failed patches.
 func ApplyPatches(tree *RenderTree, ops []PatchOp) (applied, failed int) {
@@ -125,6 +205,91 @@ func ApplyPatches(tree *RenderTree, ops []PatchOp) (applied, failed int) {
 	return applied, failed
 }
 
+// ApplyPatchesAtomic applies a batch of patch operations as a single
+// transaction. The batch is first applied to a shadow copy of the tree;
+// only if every op succeeds is the result committed. On failure the tree
+// is left untouched and the returned error identifies the first failing op.
+func ApplyPatchesAtomic(tree *RenderTree, ops []PatchOp) error {
+	if i := applyPatchesAtomic(tree, ops); i >= 0 {
+		return fmt.Errorf("op %d (target %d): %w", i, ops[i].Target, ErrPatchFailed)
+	}
+	return nil
+}
+
+// applyPatchesAtomic is ApplyPatchesAtomic returning the index of the
+// failing op, or -1 if the batch was committed.
+func applyPatchesAtomic(tree *RenderTree, ops []PatchOp) int {
+	shadow := shadowTree(tree)
+
+	for i, op := range ops {
+		if !ApplyPatch(shadow, op) {
+			return i
+		}
+	}
+
+	tree.Root = shadow.Root
+	tree.NodeIndex = shadow.NodeIndex
+	return -1
+}
+
+// shadowTree returns a copy of tree's nodes to patch without touching
+// tree. Slots, schemas, and data rows are shared.
+func shadowTree(tree *RenderTree) *RenderTree {
+	shadow := &RenderTree{
+		Slots:     tree.Slots,
+		Schemas:   tree.Schemas,
+		DataRows:  tree.DataRows,
+		NodeIndex: make(map[int]*RenderNode, len(tree.NodeIndex)),
+	}
+	shadow.Root = CloneRenderNode(tree.Root, shadow.NodeIndex)
+	return shadow
+}
+
+// CloneRenderNode deep-copies a render subtree, indexing every copied node
+// into the provided map. Prop pointers are shared since patches replace
+// them rather than writing through them; the Extra map is copied.
+func CloneRenderNode(node *RenderNode, index map[int]*RenderNode) *RenderNode {
+	if node == nil {
+		return nil
+	}
+
+	clone := &RenderNode{
+		ID:       node.ID,
+		Type:     node.Type,
+		Props:    node.Props,
+		Children: make([]*RenderNode, 0, len(node.Children)),
+	}
+	if node.Props.Extra != nil {
+		clone.Props.Extra = make(map[string]interface{}, len(node.Props.Extra))
+		for k, v := range node.Props.Extra {
+			clone.Props.Extra[k] = v
+		}
+	}
+	if node.ComputedLayout != nil {
+		layout := *node.ComputedLayout
+		clone.ComputedLayout = &layout
+	}
+	for _, c := range node.Children {
+		clone.Children = append(clone.Children, CloneRenderNode(c, index))
+	}
+
+	index[clone.ID] = clone
+	return clone
+}
+
+// applyPropsUnset clears properties of a RenderNode back to unset. Keys
+// are JSON field names, or the names of Extra properties.
+func applyPropsUnset(node *RenderNode, keys []string) {
+	props := reflect.ValueOf(&node.Props).Elem()
+	for _, k := range keys {
+		if f, ok := propFields[k]; ok {
+			props.FieldByIndex(f.Index).SetZero()
+		} else {
+			delete(node.Props.Extra, k)
+		}
+	}
+}
+
 // applyPropsSet merges a set of property changes into a RenderNode.
 // The set map uses string keys matching JSON field names.
 func applyPropsSet(node *RenderNode, set map[string]interface{}) {
@@ -158,6 +323,131 @@ func applyPropsSet(node *RenderNode, set map[string]interface{}) {
 			if b, ok := v.(bool); ok {
 				node.Props.Disabled = &b
 			}
+		case "italic":
+			if b, ok := v.(bool); ok {
+				node.Props.Italic = &b
+			}
+		case "sticky":
+			if b, ok := v.(bool); ok {
+				node.Props.Sticky = &b
+			}
+		case "zIndex":
+			if n, ok := toInt(v); ok {
+				node.Props.ZIndex = &n
+			}
+		case "modal":
+			if b, ok := v.(bool); ok {
+				node.Props.Modal = &b
+			}
+		case "transform":
+			if t, ok := parseTransform(v); ok {
+				node.Props.Transform = &t
+			}
+		case "hidden":
+			if b, ok := v.(bool); ok {
+				node.Props.Hidden = &b
+			}
+		case "display":
+			if s, ok := v.(string); ok {
+				node.Props.Display = s
+			}
+		case "multiline":
+			if b, ok := v.(bool); ok {
+				node.Props.Multiline = &b
+			}
+		case "maxLength":
+			if n, ok := toInt(v); ok {
+				node.Props.MaxLength = &n
+			}
+		case "inputType":
+			if s, ok := v.(string); ok {
+				node.Props.InputType = s
+			}
+		case "pattern":
+			if s, ok := v.(string); ok {
+				node.Props.Pattern = s
+			}
+		case "secret":
+			if b, ok := v.(bool); ok {
+				node.Props.Secret = &b
+			}
+		case "tooltip":
+			if s, ok := v.(string); ok {
+				node.Props.Tooltip = &s
+			}
+		case "draggable":
+			if b, ok := v.(bool); ok {
+				node.Props.Draggable = &b
+			}
+		case "droppable":
+			if b, ok := v.(bool); ok {
+				node.Props.Droppable = &b
+			}
+		case "capturePointer":
+			if b, ok := v.(bool); ok {
+				node.Props.CapturePointer = &b
+			}
+		case "variant":
+			if s, ok := v.(string); ok {
+				node.Props.Variant = s
+			}
+		case "schema":
+			if n, ok := toInt(v); ok {
+				node.Props.Schema = &n
+			}
+		case "columnWidths":
+			// Decoded patches carry []interface{}; those built in
+			// process (DiffTree, InvertPatch) carry []int. Empty clears.
+			switch widths := v.(type) {
+			case []int:
+				node.Props.ColumnWidths = append([]int(nil), widths...)
+			case []interface{}:
+				node.Props.ColumnWidths = nil
+				for _, w := range widths {
+					n, _ := toInt(w)
+					node.Props.ColumnWidths = append(node.Props.ColumnWidths, n)
+				}
+			}
+		case "striped":
+			if b, ok := v.(bool); ok {
+				node.Props.Striped = &b
+			}
+		case "selectedRow":
+			if n, ok := toInt(v); ok {
+				node.Props.SelectedRow = &n
+			}
+		case "progress":
+			if f, ok := toFloat(v); ok {
+				node.Props.Progress = &f
+			}
+		case "max":
+			if f, ok := toFloat(v); ok {
+				node.Props.Max = &f
+			}
+		case "label":
+			if s, ok := v.(string); ok {
+				node.Props.Label = &s
+			}
+		case "checked":
+			if b, ok := v.(bool); ok {
+				node.Props.Checked = &b
+			}
+		case "options":
+			switch opts := v.(type) {
+			case []string:
+				node.Props.Options = opts
+			case []interface{}:
+				node.Props.Options = nil
+				for _, o := range opts {
+					if s, ok := o.(string); ok {
+						node.Props.Options = append(node.Props.Options, s)
+					}
+				}
+			}
+		case "wrap":
+			if b, ok := v.(bool); ok {
+				node.Props.Wrap = &b
+			}
 		case "scrollTop":
 			if n, ok := toInt(v); ok {
 				node.Props.ScrollTop = &n
@@ -186,6 +476,14 @@ func applyPropsSet(node *RenderNode, set map[string]interface{}) {
 			if s, ok := v.(string); ok {
 				node.Props.TextAlign = s
 			}
+		case "textDirection":
+			if s, ok := v.(string); ok {
+				node.Props.TextDirection = s
+			}
+		case "textOverflow":
+			if s, ok := v.(string); ok {
+				node.Props.TextOverflow = s
+			}
 		case "fontFamily":
 			if s, ok := v.(string); ok {
 				node.Props.FontFamily = s
@@ -218,6 +516,22 @@ func applyPropsSet(node *RenderNode, set map[string]interface{}) {
 			if n, ok := toInt(v); ok {
 				node.Props.Gap = &n
 			}
+		case "overflow":
+			if s, ok := v.(string); ok {
+				node.Props.Overflow = s
+			}
+		case "columns":
+			node.Props.Columns = v
+		case "rows":
+			node.Props.Rows = v
+		case "colSpan":
+			if n, ok := toInt(v); ok {
+				node.Props.ColSpan = &n
+			}
+		case "rowSpan":
+			if n, ok := toInt(v); ok {
+				node.Props.RowSpan = &n
+			}
 		case "size":
 			if n, ok := toInt(v); ok {
 				node.Props.Size = &n
@@ -238,10 +552,58 @@ func applyPropsSet(node *RenderNode, set map[string]interface{}) {
 			if n, ok := toInt(v); ok {
 				node.Props.TabIndex = &n
 			}
+		case "borderRadius":
+			if n, ok := toInt(v); ok {
+				node.Props.BorderRadius = &n
+			}
+		case "aspectRatio":
+			if f, ok := parseAspectRatio(v); ok {
+				node.Props.AspectRatio = &f
+			}
+		case "minWidth":
+			if n, ok := toInt(v); ok {
+				node.Props.MinWidth = &n
+			}
+		case "minHeight":
+			if n, ok := toInt(v); ok {
+				node.Props.MinHeight = &n
+			}
+		case "maxWidth":
+			if n, ok := toInt(v); ok {
+				node.Props.MaxWidth = &n
+			}
+		case "maxHeight":
+			if n, ok := toInt(v); ok {
+				node.Props.MaxHeight = &n
+			}
+		case "virtualWidth":
+			if n, ok := toInt(v); ok {
+				node.Props.VirtualWidth = &n
+			}
+		case "virtualHeight":
+			if n, ok := toInt(v); ok {
+				node.Props.VirtualHeight = &n
+			}
+		case "data":
+			if b, ok := v.([]byte); ok {
+				node.Props.Data = b
+			}
 		case "width":
 			node.Props.Width = v
 		case "height":
 			node.Props.Height = v
+		case "position":
+			if s, ok := v.(string); ok {
+				node.Props.Position = s
+			}
+		case "top":
+			node.Props.Top = v
+		case "right":
+			node.Props.Right = v
+		case "bottom":
+			node.Props.Bottom = v
+		case "left":
+			node.Props.Left = v
 		case "padding":
 			node.Props.Padding = v
 		case "margin":
@@ -254,6 +616,7 @@ func applyPropsSet(node *RenderNode, set map[string]interface{}) {
 			node.Props.Extra[k] = v
 		}
 	}
+	decodeSizes(&node.Props)
 }
 
 // toInt attempts to convert an interface{} to int.
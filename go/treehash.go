@@ -0,0 +1,75 @@
+package viewer
+
+import (
+	"encoding/binary"
+	"hash"
+	"hash/fnv"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// Tree hashes. A source that sets SourceState.HashEvery follows every
+// nth flush with a MsgHash carrying the hash of its published tree; the
+// viewer hashes its own tree when the message arrives and, if they
+// differ, asks for a full refresh (see RequestRefresh). This catches
+// divergence that sequencing cannot: patches lost by a source that does
+// not number its frames, or ones that failed to apply.
+//
+// The hash covers each node's ID, type, props and children in order.
+// Props the viewer changes itself as the user interacts with a control
+// are left out, since the source only learns of them through input
+// events. Prop values are hashed by their canonical CBOR encoding, so a
+// number hashes the same whatever integer type it was decoded as.
+
+// hashEncoding encodes prop values deterministically for hashing.
+var hashEncoding, _ = cbor.CoreDetEncOptions().EncMode()
+
+// viewerOwnedProps are the props the viewer sets on its own copy of the
+// tree in response to input.
+var viewerOwnedProps = map[string]bool{
+	"value": true, "checked": true, "selectedRow": true,
+	"scrollTop": true, "scrollLeft": true,
+}
+
+// TreeHash returns a structural hash of tree's nodes. Slots, schemas and
+// data rows are not included.
+func TreeHash(tree *RenderTree) uint64 {
+	h := fnv.New64a()
+	if tree.Root != nil {
+		hashNode(h, tree.Root)
+	}
+	return h.Sum64()
+}
+
+// hashNode writes node and its subtree to h.
+func hashNode(h hash.Hash64, node *RenderNode) {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], uint64(node.ID))
+	h.Write(buf[:])
+	h.Write([]byte(node.Type))
+	h.Write([]byte{0})
+	props := propValues(node.Props)
+	for k := range viewerOwnedProps {
+		delete(props, k)
+	}
+	if data, err := hashEncoding.Marshal(props); err == nil {
+		h.Write(data)
+	}
+	binary.LittleEndian.PutUint64(buf[:], uint64(len(node.Children)))
+	h.Write(buf[:])
+	for _, c := range node.Children {
+		hashNode(h, c)
+	}
+}
+
+// checkHash compares the tree with the hash in a MsgHash and requests a
+// refresh if they differ. Nothing is checked while a resync is pending,
+// since the tree is already known to be stale.
+// Must be called with the mutex held.
+func (v *Viewer) checkHash(msg ProtocolMessage) {
+	if msg.Hash == nil || v.resyncPending || TreeHash(v.tree) == *msg.Hash {
+		return
+	}
+	v.hashMismatches++
+	v.requestRefresh()
+}
@@ -6,6 +6,11 @@
 // output, and targets headless mode for testing.
 package viewer
 
+import (
+	"fmt"
+	"time"
+)
+
 // ── Node types ───────────────────────────────────────────────────────
 
 // NodeType identifies the kind of a UI node.
@@ -19,6 +24,13 @@ const (
 	NodeImage     NodeType = "image"
 	NodeCanvas    NodeType = "canvas"
 	NodeSeparator NodeType = "separator"
+	NodeCheckbox  NodeType = "checkbox"
+	NodeRadio     NodeType = "radio"
+	NodeSelect    NodeType = "select"
+	NodeButton    NodeType = "button"
+	NodeProgress  NodeType = "progress"
+	NodeSpinner   NodeType = "spinner"
+	NodeTable     NodeType = "table"
 )
 
 // ── Message types (wire protocol) ────────────────────────────────────
@@ -34,18 +46,93 @@ const (
 	MsgInput  MessageType = 0x05
 	MsgEnv    MessageType = 0x06
 	MsgRegion MessageType = 0x07
-	MsgAudio  MessageType = 0x08
+	MsgAudio  MessageType = 0x08 // plays a sound slot (see SoundSlot)
 	MsgCanvas MessageType = 0x09
 	MsgSchema MessageType = 0x0a
+
+	// Control messages (viewer → source).
+	MsgAck    MessageType = 0x0b // acknowledges a sequenced frame
+	MsgResync MessageType = 0x0c // requests a full state resend after lost frames
+	MsgCredit MessageType = 0x0d // grants the source a window of frames to send
+
+	// Handshake (source → viewer).
+	MsgRequire MessageType = 0x0e // declares the source's capability requirements
+
+	// Container (source → viewer).
+	MsgBatch MessageType = 0x0f // several messages applied as one frame
+
+	// Verification (source → viewer).
+	MsgHash MessageType = 0x10 // carries a hash of the source's tree (see TreeHash)
+
+	// Control (viewer → source).
+	MsgRefresh MessageType = 0x11 // requests slots, schemas and tree resent in full
+
+	// Keepalive (either direction).
+	MsgPing MessageType = 0x12 // asks the other side to answer with a MsgPong
+	MsgPong MessageType = 0x13 // answers a MsgPing, echoing its nonce
+
+	// Control (viewer → source).
+	MsgError   MessageType = 0x14 // reports a rejected message or failed patch (see ErrorReport)
+	MsgMetrics MessageType = 0x15 // reports the viewer's metrics (see ReportMetrics)
+
+	// Alerts (source → viewer).
+	MsgNotify MessageType = 0x16 // asks the viewer to notify the user (see Notifier)
+
+	// Clipboard (either direction).
+	MsgClipboard MessageType = 0x17 // moves text to or from the viewer's clipboard (see ClipboardTransfer)
+
+	// Uploads (viewer → source).
+	MsgFile MessageType = 0x18 // carries a chunk of a dropped file (see Viewer.DropFile)
 )
 
+var messageTypeNames = map[MessageType]string{
+	MsgDefine:    "DEFINE",
+	MsgTree:      "TREE",
+	MsgPatch:     "PATCH",
+	MsgData:      "DATA",
+	MsgInput:     "INPUT",
+	MsgEnv:       "ENV",
+	MsgRegion:    "REGION",
+	MsgAudio:     "AUDIO",
+	MsgCanvas:    "CANVAS",
+	MsgSchema:    "SCHEMA",
+	MsgAck:       "ACK",
+	MsgResync:    "RESYNC",
+	MsgCredit:    "CREDIT",
+	MsgRequire:   "REQUIRE",
+	MsgBatch:     "BATCH",
+	MsgHash:      "HASH",
+	MsgRefresh:   "REFRESH",
+	MsgPing:      "PING",
+	MsgPong:      "PONG",
+	MsgError:     "ERROR",
+	MsgMetrics:   "METRICS",
+	MsgNotify:    "NOTIFY",
+	MsgClipboard: "CLIPBOARD",
+	MsgFile:      "FILE",
+}
+
+// Known reports whether t is a defined message type.
+func (t MessageType) Known() bool {
+	_, ok := messageTypeNames[t]
+	return ok
+}
+
+// String returns the protocol name of the message type, e.g. "TREE".
+func (t MessageType) String() string {
+	if name, ok := messageTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("UNKNOWN(0x%02x)", uint8(t))
+}
+
 // ── Node properties ──────────────────────────────────────────────────
 
 // BorderStyle describes border appearance.
 type BorderStyle struct {
 	Width int    `json:"width,omitempty" cbor:"width,omitempty"`
 	Color string `json:"color,omitempty" cbor:"color,omitempty"`
-	Style string `json:"style,omitempty" cbor:"style,omitempty"` // solid, dashed, dotted, none
+	Style string `json:"style,omitempty" cbor:"style,omitempty"` // solid, dashed, dotted, double, none
 }
 
 // ShadowStyle describes a drop shadow.
@@ -56,15 +143,33 @@ type ShadowStyle struct {
 	Color string `json:"color" cbor:"color"`
 }
 
+// Transform moves, scales, and rotates a node as drawn, about the center
+// of its box. Unset scales are 1.
+type Transform struct {
+	TranslateX float64 `json:"translateX,omitempty" cbor:"translateX,omitempty"` // pixels
+	TranslateY float64 `json:"translateY,omitempty" cbor:"translateY,omitempty"`
+	ScaleX     float64 `json:"scaleX,omitempty" cbor:"scaleX,omitempty"`
+	ScaleY     float64 `json:"scaleY,omitempty" cbor:"scaleY,omitempty"`
+	Rotate     float64 `json:"rotate,omitempty" cbor:"rotate,omitempty"` // degrees clockwise
+}
+
 // NodeProps holds all possible node properties. Which fields are relevant
 // depends on the node type.
 type NodeProps struct {
 	// Box layout
-	Direction string `json:"direction,omitempty" cbor:"direction,omitempty"` // "row" or "column"
+	Direction string `json:"direction,omitempty" cbor:"direction,omitempty"` // "row", "column", or "grid"
 	Wrap      *bool  `json:"wrap,omitempty" cbor:"wrap,omitempty"`
 	Justify   string `json:"justify,omitempty" cbor:"justify,omitempty"`
 	Align     string `json:"align,omitempty" cbor:"align,omitempty"`
 	Gap       *int   `json:"gap,omitempty" cbor:"gap,omitempty"`
+	Overflow  string `json:"overflow,omitempty" cbor:"overflow,omitempty"` // "visible", "hidden", or "auto"
+
+	// Grid layout: track templates (a string like "20 1fr auto", a list,
+	// or a count) and how many tracks a child spans.
+	Columns interface{} `json:"columns,omitempty" cbor:"columns,omitempty"`
+	Rows    interface{} `json:"rows,omitempty" cbor:"rows,omitempty"`
+	ColSpan *int        `json:"colSpan,omitempty" cbor:"colSpan,omitempty"`
+	RowSpan *int        `json:"rowSpan,omitempty" cbor:"rowSpan,omitempty"`
 
 	// Spacing (simplified to single int for now; arrays handled via interface{})
 	Padding interface{} `json:"padding,omitempty" cbor:"padding,omitempty"`
@@ -76,6 +181,7 @@ type NodeProps struct {
 	Background   interface{}  `json:"background,omitempty" cbor:"background,omitempty"` // string or int (slot ref)
 	Opacity      *float64     `json:"opacity,omitempty" cbor:"opacity,omitempty"`
 	Shadow       *ShadowStyle `json:"shadow,omitempty" cbor:"shadow,omitempty"`
+	Transform    *Transform   `json:"transform,omitempty" cbor:"transform,omitempty"`
 
 	// Sizing
 	Width    interface{} `json:"width,omitempty" cbor:"width,omitempty"`  // number or string
@@ -86,6 +192,17 @@ type NodeProps struct {
 	MaxWidth *int        `json:"maxWidth,omitempty" cbor:"maxWidth,omitempty"`
 	MaxHeight *int       `json:"maxHeight,omitempty" cbor:"maxHeight,omitempty"`
 
+	// AspectRatio is width over height, kept when one side is unset.
+	AspectRatio *float64 `json:"aspectRatio,omitempty" cbor:"aspectRatio,omitempty"`
+
+	// Positioning: absolute or fixed takes a node out of the flow and
+	// offsets its edges by Top, Right, Bottom, and Left (numbers).
+	Position string      `json:"position,omitempty" cbor:"position,omitempty"`
+	Top      interface{} `json:"top,omitempty" cbor:"top,omitempty"`
+	Right    interface{} `json:"right,omitempty" cbor:"right,omitempty"`
+	Bottom   interface{} `json:"bottom,omitempty" cbor:"bottom,omitempty"`
+	Left     interface{} `json:"left,omitempty" cbor:"left,omitempty"`
+
 	// Text
 	Content    *string `json:"content,omitempty" cbor:"content,omitempty"`
 	FontFamily string  `json:"fontFamily,omitempty" cbor:"fontFamily,omitempty"`
@@ -96,6 +213,14 @@ type NodeProps struct {
 	TextAlign  string  `json:"textAlign,omitempty" cbor:"textAlign,omitempty"`
 	Italic     *bool   `json:"italic,omitempty" cbor:"italic,omitempty"`
 
+	// TextDirection is "ltr", "rtl", or "auto" (from the first strong
+	// character of the content). Inherited; "ltr" at the root.
+	TextDirection string `json:"textDirection,omitempty" cbor:"textDirection,omitempty"`
+
+	// TextOverflow is how text wider than its node is shown: "clip" (the
+	// default), "wrap" at spaces, or "ellipsis" on a single line.
+	TextOverflow string `json:"textOverflow,omitempty" cbor:"textOverflow,omitempty"`
+
 	// Scroll
 	VirtualHeight *int `json:"virtualHeight,omitempty" cbor:"virtualHeight,omitempty"`
 	VirtualWidth  *int `json:"virtualWidth,omitempty" cbor:"virtualWidth,omitempty"`
@@ -103,12 +228,66 @@ type NodeProps struct {
 	ScrollLeft    *int `json:"scrollLeft,omitempty" cbor:"scrollLeft,omitempty"`
 	Template      *int `json:"template,omitempty" cbor:"template,omitempty"` // slot ref
 
+	// Sticky pins a child of a scroll node to the top of its viewport
+	// once scrolling would carry the child above it.
+	Sticky *bool `json:"sticky,omitempty" cbor:"sticky,omitempty"`
+
+	// ZIndex makes a node an overlay drawn over the screen at that
+	// level; Modal makes an overlay take all input while it is open.
+	ZIndex *int  `json:"zIndex,omitempty" cbor:"zIndex,omitempty"`
+	Modal  *bool `json:"modal,omitempty" cbor:"modal,omitempty"`
+
+	// Hidden, or a Display of "none", takes a node and its subtree off
+	// the screen while keeping their state.
+	Hidden  *bool  `json:"hidden,omitempty" cbor:"hidden,omitempty"`
+	Display string `json:"display,omitempty" cbor:"display,omitempty"`
+
 	// Input
 	Value       *string `json:"value,omitempty" cbor:"value,omitempty"`
 	Placeholder *string `json:"placeholder,omitempty" cbor:"placeholder,omitempty"`
 	Multiline   *bool   `json:"multiline,omitempty" cbor:"multiline,omitempty"`
 	Disabled    *bool   `json:"disabled,omitempty" cbor:"disabled,omitempty"`
 
+	// Constraints on an input's value: at most MaxLength grapheme
+	// clusters, of InputType (text, number, email), matching Pattern.
+	MaxLength *int   `json:"maxLength,omitempty" cbor:"maxLength,omitempty"`
+	InputType string `json:"inputType,omitempty" cbor:"inputType,omitempty"`
+	Pattern   string `json:"pattern,omitempty" cbor:"pattern,omitempty"`
+
+	// Secret hides an input's value from everything but the source.
+	Secret *bool `json:"secret,omitempty" cbor:"secret,omitempty"`
+
+	// Tooltip is text shown near the node while it is hovered or focused.
+	Tooltip *string `json:"tooltip,omitempty" cbor:"tooltip,omitempty"`
+
+	// Draggable nodes can be dragged with the pointer and dropped on
+	// Droppable ones.
+	Draggable *bool `json:"draggable,omitempty" cbor:"draggable,omitempty"`
+	Droppable *bool `json:"droppable,omitempty" cbor:"droppable,omitempty"`
+
+	// CapturePointer nodes keep the pointer from a press until its
+	// release, getting pointer events wherever it goes (see capture.go).
+	CapturePointer *bool `json:"capturePointer,omitempty" cbor:"capturePointer,omitempty"`
+
+	// Checkbox, radio, select, and button. A radio's Value is sent with
+	// its change events; a select's is one of its Options.
+	Label   *string  `json:"label,omitempty" cbor:"label,omitempty"`
+	Variant string   `json:"variant,omitempty" cbor:"variant,omitempty"` // button: primary, danger
+	Checked *bool    `json:"checked,omitempty" cbor:"checked,omitempty"`
+	Options []string `json:"options,omitempty" cbor:"options,omitempty"`
+
+	// Progress out of Max (default 1); nil for an indeterminate bar.
+	Progress *float64 `json:"progress,omitempty" cbor:"progress,omitempty"`
+	Max      *float64 `json:"max,omitempty" cbor:"max,omitempty"`
+
+	// Table: the schema slot whose data rows it shows, column widths in
+	// characters (0 for automatic), and the selected row. ScrollTop
+	// counts rows.
+	Schema       *int  `json:"schema,omitempty" cbor:"schema,omitempty"`
+	ColumnWidths []int `json:"columnWidths,omitempty" cbor:"columnWidths,omitempty"`
+	Striped      *bool `json:"striped,omitempty" cbor:"striped,omitempty"`
+	SelectedRow  *int  `json:"selectedRow,omitempty" cbor:"selectedRow,omitempty"`
+
 	// Image
 	Data    []byte `json:"data,omitempty" cbor:"data,omitempty"`
 	Format  string `json:"format,omitempty" cbor:"format,omitempty"` // png, jpeg, svg
@@ -169,6 +348,75 @@ type RenderTree struct {
 	Schemas   map[int][]SchemaColumn       `json:"schemas"`
 	DataRows  map[int][][]interface{}       `json:"dataRows"` // schema slot -> rows
 	NodeIndex map[int]*RenderNode          `json:"-"`
+
+	// Active is the clickable node being pressed: the pointer went down
+	// on it and has not been released. Renderers draw it with its style's
+	// active props, or inverted if it has none.
+	Active *int `json:"-"`
+
+	// Focused is the node with keyboard focus.
+	Focused *int `json:"-"`
+
+	// Cursor is the text cursor in the focused input, if it is one.
+	Cursor *TextCursor `json:"-"`
+
+	// Invalid holds the inputs whose last commit failed validation.
+	// Renderers draw them with their style's invalid props.
+	Invalid map[int]bool `json:"-"`
+
+	// ClockOffset is how far the source's clock is estimated to run ahead
+	// of the viewer's (see Viewer.SyncClock). Times from the source, such
+	// as relative_time columns, are read against the viewer's clock
+	// shifted by it.
+	ClockOffset time.Duration `json:"-"`
+
+	// Clock is read for the current time relative_time columns are
+	// measured from; nil means the system clock. A viewer sets its own
+	// (see Viewer.SetClock).
+	Clock Clock `json:"-"`
+
+	// TimeZone is the zone absolute_time columns are shown in, unless
+	// they name their own (see Viewer.SetTimeZone); nil means local.
+	TimeZone *time.Location `json:"-"`
+
+	// Locale is the BCP 47 tag number columns are written in, unless
+	// they name their own (see Viewer.SetLocale); empty means English.
+	Locale string `json:"-"`
+
+	// Progress holds each determinate progress bar's last value and any
+	// transition to it, kept up to date by the viewer.
+	Progress map[int]*ProgressTransition `json:"-"`
+
+	// Tooltip is the node whose tooltip is showing.
+	Tooltip *int `json:"-"`
+
+	// Dragging is the node being dragged, and DropTarget the node it
+	// would drop on. Renderers draw them faint and inverted.
+	Dragging   *int `json:"-"`
+	DropTarget *int `json:"-"`
+}
+
+// ProgressTransition is a progress bar moving between fractions.
+type ProgressTransition struct {
+	From, To float64
+	Start    time.Time
+	Duration time.Duration // 0 to jump
+	Easing   string
+}
+
+// TextCursor is the editing position in an input.
+type TextCursor struct {
+	Node   int
+	Offset int    // byte offset into the value, on a cluster boundary
+	Anchor int    // other end of the selection; Offset if none
+	Scroll int    // lines a multiline input is scrolled down
+	Shape  string // CursorBlock, CursorBar, or CursorUnderline
+	Hidden bool   // blinked off
+
+	// Composition is IME text being composed at Offset, not yet part of
+	// the value, and CompositionCursor the IME's cursor in it, in bytes.
+	Composition       string
+	CompositionCursor int
 }
 
 // ── Schema ───────────────────────────────────────────────────────────
@@ -179,7 +427,19 @@ type SchemaColumn struct {
 	Name   string `json:"name" cbor:"name"`
 	Type   string `json:"type" cbor:"type"` // string, uint64, int64, float64, bool, timestamp
 	Unit   string `json:"unit,omitempty" cbor:"unit,omitempty"`
-	Format string `json:"format,omitempty" cbor:"format,omitempty"` // human_bytes, relative_time
+	Format string `json:"format,omitempty" cbor:"format,omitempty"` // human_bytes, relative_time, absolute_time, number, compact_number
+
+	// absolute_time: how to write the time, in strftime-style directives
+	// ("%Y-%m-%d %H:%M"), and the IANA time zone to show it in instead
+	// of the viewer's.
+	Layout   string `json:"layout,omitempty" cbor:"layout,omitempty"`
+	TimeZone string `json:"timeZone,omitempty" cbor:"timeZone,omitempty"`
+
+	// number, compact_number: digits after the decimal point, and the
+	// locale (a BCP 47 tag) to write separators in instead of the
+	// viewer's.
+	Precision *int   `json:"precision,omitempty" cbor:"precision,omitempty"`
+	Locale    string `json:"locale,omitempty" cbor:"locale,omitempty"`
 }
 
 // ── Slot values ──────────────────────────────────────────────────────
@@ -193,6 +453,22 @@ type SlotValue interface {
 type StyleSlot struct {
 	Kind  string                 `json:"kind" cbor:"kind"`
 	Props map[string]interface{} `json:"props,omitempty" cbor:"props,omitempty"`
+
+	// Variants override Props when their condition matches the current
+	// environment. Matching variants are merged in order.
+	Variants []StyleVariant `json:"variants,omitempty" cbor:"variants,omitempty"`
+
+	// Active overrides Props while the node is pressed.
+	Active map[string]interface{} `json:"active,omitempty" cbor:"active,omitempty"`
+
+	// Invalid overrides Props while an input fails validation.
+	Invalid map[string]interface{} `json:"invalid,omitempty" cbor:"invalid,omitempty"`
+}
+
+// StyleVariant is an env-conditional override for a StyleSlot.
+type StyleVariant struct {
+	When  EnvCondition           `json:"when" cbor:"when"`
+	Props map[string]interface{} `json:"props" cbor:"props"`
 }
 
 func (s StyleSlot) SlotKind() string { return "style" }
@@ -202,6 +478,29 @@ type ColorSlot struct {
 	Kind  string `json:"kind" cbor:"kind"`
 	Role  string `json:"role" cbor:"role"`
 	Value string `json:"value" cbor:"value"`
+
+	// Variants replace Value when their condition matches the current
+	// environment. The first matching variant wins.
+	Variants []ColorVariant `json:"variants,omitempty" cbor:"variants,omitempty"`
+}
+
+// ColorVariant is an env-conditional override for a ColorSlot.
+type ColorVariant struct {
+	When  EnvCondition `json:"when" cbor:"when"`
+	Value string       `json:"value" cbor:"value"`
+}
+
+// EnvCondition matches an EnvInfo. Bounds are inclusive and zero bounds
+// are ignored, so "colorDepth < 24" is MaxColorDepth: 23.
+type EnvCondition struct {
+	MinColorDepth    int   `json:"minColorDepth,omitempty" cbor:"minColorDepth,omitempty"`
+	MaxColorDepth    int   `json:"maxColorDepth,omitempty" cbor:"maxColorDepth,omitempty"`
+	MinDisplayWidth  int   `json:"minDisplayWidth,omitempty" cbor:"minDisplayWidth,omitempty"`
+	MaxDisplayWidth  int   `json:"maxDisplayWidth,omitempty" cbor:"maxDisplayWidth,omitempty"`
+	MinDisplayHeight int   `json:"minDisplayHeight,omitempty" cbor:"minDisplayHeight,omitempty"`
+	MaxDisplayHeight int   `json:"maxDisplayHeight,omitempty" cbor:"maxDisplayHeight,omitempty"`
+	GPU              *bool `json:"gpu,omitempty" cbor:"gpu,omitempty"`
+	Remote           *bool `json:"remote,omitempty" cbor:"remote,omitempty"`
 }
 
 func (s ColorSlot) SlotKind() string { return "color" }
@@ -251,6 +550,19 @@ type RowTemplateSlot struct {
 
 func (s RowTemplateSlot) SlotKind() string { return "row_template" }
 
+// SoundSlot defines a sound effect, played when the source sends a
+// MsgAudio naming the slot (see AudioSink). The audio is either inline in
+// Data or fetched by the sink from Ref.
+type SoundSlot struct {
+	Kind   string `json:"kind" cbor:"kind"`
+	Role   string `json:"role" cbor:"role"`                         // notify, alert, error, success, ...
+	Format string `json:"format,omitempty" cbor:"format,omitempty"` // MIME type, such as audio/wav
+	Data   []byte `json:"data,omitempty" cbor:"data,omitempty"`
+	Ref    string `json:"ref,omitempty" cbor:"ref,omitempty"` // URL or path, when Data is empty
+}
+
+func (s SoundSlot) SlotKind() string { return "sound" }
+
 // GenericSlot is a catch-all for slot types not explicitly modeled.
 type GenericSlot struct {
 	Kind  string                 `json:"kind" cbor:"kind"`
@@ -265,18 +577,27 @@ func (s GenericSlot) SlotKind() string { return s.Kind }
 type PatchOp struct {
 	Target         int               `json:"target" cbor:"target"`
 	Set            map[string]interface{} `json:"set,omitempty" cbor:"set,omitempty"`
+	Unset          []string          `json:"unset,omitempty" cbor:"unset,omitempty"`
 	ChildrenInsert *ChildrenInsert   `json:"childrenInsert,omitempty" cbor:"childrenInsert,omitempty"`
 	ChildrenRemove *ChildrenRemove   `json:"childrenRemove,omitempty" cbor:"childrenRemove,omitempty"`
 	ChildrenMove   *ChildrenMove     `json:"childrenMove,omitempty" cbor:"childrenMove,omitempty"`
+	ChildrenRemoveRange *ChildrenRemoveRange `json:"childrenRemoveRange,omitempty" cbor:"childrenRemoveRange,omitempty"`
+	ChildrenMoveRange   *ChildrenMoveRange   `json:"childrenMoveRange,omitempty" cbor:"childrenMoveRange,omitempty"`
+	ChildrenSet    *ChildrenSet      `json:"childrenSet,omitempty" cbor:"childrenSet,omitempty"`
 	Remove         bool              `json:"remove,omitempty" cbor:"remove,omitempty"`
 	Replace        *VNode            `json:"replace,omitempty" cbor:"replace,omitempty"`
 	Transition     *int              `json:"transition,omitempty" cbor:"transition,omitempty"`
 }
 
-// ChildrenInsert describes inserting a child at an index.
+// ChildrenInsert describes inserting a child at an index, or next to a
+// sibling by ID. Before or After, if set, names the child to insert
+// before or after, and the index is ignored; the insert fails if no such
+// child exists.
 type ChildrenInsert struct {
-	Index int    `json:"index" cbor:"index"`
-	Node  *VNode `json:"node" cbor:"node"`
+	Index  int    `json:"index" cbor:"index"`
+	Node   *VNode `json:"node" cbor:"node"`
+	Before *int   `json:"before,omitempty" cbor:"before,omitempty"`
+	After  *int   `json:"after,omitempty" cbor:"after,omitempty"`
 }
 
 // ChildrenRemove describes removing a child at an index.
@@ -290,12 +611,32 @@ type ChildrenMove struct {
 	To   int `json:"to" cbor:"to"`
 }
 
+// ChildrenRemoveRange describes removing count adjacent children from an
+// index.
+type ChildrenRemoveRange struct {
+	Index int `json:"index" cbor:"index"`
+	Count int `json:"count" cbor:"count"`
+}
+
+// ChildrenMoveRange describes moving count adjacent children starting at
+// From so that the first of them ends up at index To.
+type ChildrenMoveRange struct {
+	From  int `json:"from" cbor:"from"`
+	Count int `json:"count" cbor:"count"`
+	To    int `json:"to" cbor:"to"`
+}
+
+// ChildrenSet describes replacing all of a node's children at once.
+type ChildrenSet struct {
+	Nodes []*VNode `json:"nodes" cbor:"nodes"`
+}
+
 // ── Input events ─────────────────────────────────────────────────────
 
 // InputEvent describes user input directed at a node.
 type InputEvent struct {
 	Target    *int    `json:"target,omitempty" cbor:"target,omitempty"`
-	Kind      string  `json:"kind" cbor:"kind"` // click, hover, focus, blur, key, value_change, etc.
+	Kind      string  `json:"kind" cbor:"kind"` // click, hover, focus, blur, key, value_change, change, select, validation, etc.
 	Key       string  `json:"key,omitempty" cbor:"key,omitempty"`
 	Value     string  `json:"value,omitempty" cbor:"value,omitempty"`
 	X         *int    `json:"x,omitempty" cbor:"x,omitempty"`
@@ -304,6 +645,43 @@ type InputEvent struct {
 	Action    string  `json:"action,omitempty" cbor:"action,omitempty"`
 	ScrollTop *int    `json:"scrollTop,omitempty" cbor:"scrollTop,omitempty"`
 	ScrollLeft *int   `json:"scrollLeft,omitempty" cbor:"scrollLeft,omitempty"`
+	SelectionStart *int `json:"selectionStart,omitempty" cbor:"selectionStart,omitempty"` // byte offsets into the value
+	SelectionEnd   *int `json:"selectionEnd,omitempty" cbor:"selectionEnd,omitempty"`
+	Valid  *bool  `json:"valid,omitempty" cbor:"valid,omitempty"`
+	Reason string `json:"reason,omitempty" cbor:"reason,omitempty"` // validation: the prop the value breaks
+	Checked *bool `json:"checked,omitempty" cbor:"checked,omitempty"` // change: a checkbox or radio's new state
+	Row     *int  `json:"row,omitempty" cbor:"row,omitempty"`         // select: a table's selected row
+	Source  *int  `json:"source,omitempty" cbor:"source,omitempty"`   // drag_over, drop: the dragged node
+
+	// pointer_down, pointer_move, pointer_up: the pointer relative to the
+	// captured node's top-left corner, in input units.
+	OffsetX *int `json:"offsetX,omitempty" cbor:"offsetX,omitempty"`
+	OffsetY *int `json:"offsetY,omitempty" cbor:"offsetY,omitempty"`
+
+	// Touch gestures (see Viewer.Pointer): the touches of a pinch or
+	// two_finger_scroll, a pinch's scale, and the distance of a
+	// two_finger_scroll that no scroll node took.
+	Pointers []TouchPoint `json:"pointers,omitempty" cbor:"pointers,omitempty"`
+	Scale    *float64     `json:"scale,omitempty" cbor:"scale,omitempty"`
+	DeltaY   *float64     `json:"deltaY,omitempty" cbor:"deltaY,omitempty"`
+
+	// key events from KeyInput: the physical key, the text typed, and the
+	// modifiers held (see KeyModifiers). Action is down, repeat or up.
+	Code      string       `json:"code,omitempty" cbor:"code,omitempty"`
+	Text      string       `json:"text,omitempty" cbor:"text,omitempty"`
+	Modifiers KeyModifiers `json:"modifiers,omitempty" cbor:"modifiers,omitempty"`
+
+	// Gamepads (see Viewer.Gamepad): the controller's index, and a
+	// gamepad_axis event's position.
+	Pad  int      `json:"pad,omitempty" cbor:"pad,omitempty"`
+	Axis *float64 `json:"axis,omitempty" cbor:"axis,omitempty"`
+
+	// drop: the dropped file, whose content follows in MsgFile chunks.
+	File *FileInfo `json:"file,omitempty" cbor:"file,omitempty"`
+
+	// Set by the viewer on every event it sends, to measure input latency.
+	Time int64  `json:"time,omitempty" cbor:"time,omitempty"` // viewer clock when sent, Unix milliseconds
+	Echo uint64 `json:"echo,omitempty" cbor:"echo,omitempty"` // token to pass to SourceState.Echo
 }
 
 // ── Protocol messages ────────────────────────────────────────────────
@@ -312,7 +690,51 @@ type InputEvent struct {
 type ProtocolMessage struct {
 	Type MessageType `json:"type" cbor:"type"`
 
-	// DEFINE
+	// Seq is an optional per-frame sequence number assigned by the source.
+	// When present, the viewer detects gaps and acknowledges each frame.
+	Seq *uint64 `json:"seq,omitempty" cbor:"seq,omitempty"`
+
+	// Sent is the source's clock when the message was sent, in Unix
+	// microseconds (see SourceState.Timestamps). Pongs carry it for clock
+	// sync.
+	Sent *int64 `json:"sent,omitempty" cbor:"sent,omitempty"`
+
+	// Echo is the token of the input event this frame responds to (see
+	// SourceState.Echo), which the viewer uses to measure input latency.
+	Echo *uint64 `json:"echo,omitempty" cbor:"echo,omitempty"`
+
+	// ACK / RESYNC / REFRESH: the last sequence number received in order.
+	Ack *uint64 `json:"ack,omitempty" cbor:"ack,omitempty"`
+
+	// CREDIT: number of additional frames the source may send.
+	Credit *int `json:"credit,omitempty" cbor:"credit,omitempty"`
+
+	// HASH: the TreeHash of the source's tree after the preceding frames.
+	Hash *uint64 `json:"hash,omitempty" cbor:"hash,omitempty"`
+
+	// PING / PONG / CLIPBOARD: identifies the ping a pong answers, or the
+	// clipboard read an answer is to.
+	Nonce *uint64 `json:"nonce,omitempty" cbor:"nonce,omitempty"`
+
+	// ERROR
+	Error *ErrorReport `json:"error,omitempty" cbor:"error,omitempty"`
+
+	// METRICS
+	Metrics *ViewerMetrics `json:"metrics,omitempty" cbor:"metrics,omitempty"`
+
+	// NOTIFY
+	Notify *Notification `json:"notify,omitempty" cbor:"notify,omitempty"`
+
+	// CLIPBOARD
+	Clipboard *ClipboardTransfer `json:"clipboard,omitempty" cbor:"clipboard,omitempty"`
+
+	// FILE
+	Chunk *FileChunk `json:"chunk,omitempty" cbor:"chunk,omitempty"`
+
+	// REQUIRE
+	Requires *Requirements `json:"requires,omitempty" cbor:"requires,omitempty"`
+
+	// DEFINE, SCHEMA, AUDIO
 	Slot      *int      `json:"slot,omitempty" cbor:"slot,omitempty"`
 	SlotValue SlotValue `json:"value,omitempty" cbor:"value,omitempty"`
 
@@ -334,6 +756,10 @@ type ProtocolMessage struct {
 
 	// SCHEMA
 	Columns []SchemaColumn `json:"columns,omitempty" cbor:"columns,omitempty"`
+
+	// BATCH: the contained messages, in order. They carry no seq of their
+	// own; the batch's seq covers them all.
+	Messages []ProtocolMessage `json:"messages,omitempty" cbor:"messages,omitempty"`
 }
 
 // ── Environment info ─────────────────────────────────────────────────
@@ -350,33 +776,79 @@ type EnvInfo struct {
 	VideoDecode     []string `json:"videoDecode,omitempty" cbor:"videoDecode,omitempty"`
 	Remote          bool     `json:"remote" cbor:"remote"`
 	LatencyMs       float64  `json:"latencyMs" cbor:"latencyMs"`
+
+	// ImageProtocols lists the inline image protocols the terminal
+	// supports ("kitty", "iterm2", "sixel").
+	ImageProtocols []string `json:"imageProtocols,omitempty" cbor:"imageProtocols,omitempty"`
+}
+
+// Requirements are the display capabilities a source declares it needs.
+// Zero values impose no requirement.
+type Requirements struct {
+	MinViewportVersion int      `json:"minViewportVersion,omitempty" cbor:"minViewportVersion,omitempty"`
+	MinDisplayWidth    int      `json:"minDisplayWidth,omitempty" cbor:"minDisplayWidth,omitempty"`
+	MinDisplayHeight   int      `json:"minDisplayHeight,omitempty" cbor:"minDisplayHeight,omitempty"`
+	MinColorDepth      int      `json:"minColorDepth,omitempty" cbor:"minColorDepth,omitempty"`
+	GPU                bool     `json:"gpu,omitempty" cbor:"gpu,omitempty"`
+	VideoDecode        []string `json:"videoDecode,omitempty" cbor:"videoDecode,omitempty"`
+	AllowRemote        *bool    `json:"allowRemote,omitempty" cbor:"allowRemote,omitempty"`
+	MaxLatencyMs       float64  `json:"maxLatencyMs,omitempty" cbor:"maxLatencyMs,omitempty"`
 }
 
 // ── Wire format ──────────────────────────────────────────────────────
 
-// FrameHeader is the 8-byte binary frame header.
+// FrameHeader is the 8-byte binary frame header (24 bytes for version 2
+// session frames).
 type FrameHeader struct {
 	Magic   uint16      `json:"magic"`
 	Version uint8       `json:"version"`
 	Type    MessageType `json:"type"`
 	Length  uint32      `json:"length"` // payload size in bytes (LE u32)
+
+	// Version 2 only.
+	Session uint64 `json:"session,omitempty"` // LE u64
+	Seq     uint64 `json:"seq,omitempty"`     // LE u64
+}
+
+// Size returns the encoded size of the header in bytes.
+func (h *FrameHeader) Size() int {
+	if h.Version >= SessionProtocolVersion {
+		return SessionHeaderSize
+	}
+	return HeaderSize
 }
 
 // ── Viewer metrics ───────────────────────────────────────────────────
 
 // ViewerMetrics contains performance and state counters.
+
 type ViewerMetrics struct {
-	MessagesProcessed int       `json:"messagesProcessed"`
-	BytesReceived     int       `json:"bytesReceived"`
-	LastFrameTimeMs   float64   `json:"lastFrameTimeMs"`
-	PeakFrameTimeMs   float64   `json:"peakFrameTimeMs"`
-	AvgFrameTimeMs    float64   `json:"avgFrameTimeMs"`
-	MemoryUsageBytes  int       `json:"memoryUsageBytes"`
-	TreeNodeCount     int       `json:"treeNodeCount"`
-	TreeDepth         int       `json:"treeDepth"`
-	SlotCount         int       `json:"slotCount"`
-	DataRowCount      int       `json:"dataRowCount"`
-	FrameTimesMs      []float64 `json:"frameTimesMs"`
+	MessagesProcessed  int       `json:"messagesProcessed"`
+	BytesReceived      int       `json:"bytesReceived"`
+	LastFrameTimeMs    float64   `json:"lastFrameTimeMs"`
+	PeakFrameTimeMs    float64   `json:"peakFrameTimeMs"`
+	AvgFrameTimeMs     float64   `json:"avgFrameTimeMs"`
+	MemoryUsageBytes   int       `json:"memoryUsageBytes"`
+	TreeNodeCount      int       `json:"treeNodeCount"`
+	TreeDepth          int       `json:"treeDepth"`
+	SlotCount          int       `json:"slotCount"`
+	DataRowCount       int       `json:"dataRowCount"`
+	FrameTimesMs       []float64 `json:"frameTimesMs"`
+	SeqGaps            int       `json:"seqGaps"`
+	SeqDuplicates      int       `json:"seqDuplicates"`
+	ResyncRequests     int       `json:"resyncRequests"`
+	HashMismatches     int       `json:"hashMismatches"`
+	LatencyMs          float64   `json:"latencyMs"`
+	TransportDelayMs   float64   `json:"transportDelayMs"`
+	HeartbeatTimeouts  int       `json:"heartbeatTimeouts"`
+	InputLatencyMs     float64   `json:"inputLatencyMs"`
+	AvgInputLatencyMs  float64   `json:"avgInputLatencyMs"`
+	PeakInputLatencyMs float64   `json:"peakInputLatencyMs"`
+	FramesQueued       int       `json:"framesQueued"`   // queue depth now
+	FramesDropped      int       `json:"framesDropped"`  // refused or evicted by a full queue
+	FramesDeferred     int       `json:"framesDeferred"` // queued for Pump, counted once each
+	QuotaViolations    int       `json:"quotaViolations"`
+	DecodeErrors       int       `json:"decodeErrors"`
 }
 
 // ── Screenshot result ────────────────────────────────────────────────
@@ -403,15 +875,30 @@ type AnsiTarget struct {
 
 func (t AnsiTarget) TargetType() string { return "ansi" }
 
-// FramebufferTarget sends output to a raw framebuffer pointer.
+// FramebufferTarget renders pixels with the Rasterizer. With a Buffer,
+// frames are sized to it and copied into it; otherwise they are sized to
+// the display and read with Viewer.TakeRenderResult. Ptr identifies
+// host-owned memory behind the Buffer for C hosts (see
+// cmd/libviewport); the viewer does not dereference it.
 type FramebufferTarget struct {
-	Ptr uintptr `json:"ptr"`
+	Ptr    uintptr      `json:"ptr"`
+	Buffer *Framebuffer `json:"-"`
+
+	// Fonts draws text with OpenType fonts instead of the embedded bitmap
+	// font; see Rasterizer.Fonts.
+	Fonts *FontFamily `json:"-"`
 }
 
 func (t FramebufferTarget) TargetType() string { return "framebuffer" }
 
-// TextureTarget sends output to a GPU texture (wgpu surface).
-type TextureTarget struct{}
+// TextureTarget renders pixels into a GPU texture through Backend, or the
+// built-in OpenGL backend if Backend is nil; see Viewer.Texture.
+type TextureTarget struct {
+	// Backend must be comparable, as targets are found with ==: use a
+	// pointer if the backend type holds slices, maps or funcs.
+	Backend GPUBackend  `json:"-"`
+	Fonts   *FontFamily `json:"-"`
+}
 
 func (t TextureTarget) TargetType() string { return "texture" }
 
@@ -0,0 +1,105 @@
+package viewer
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Input constraints. An input's maxLength, inputType, and pattern props
+// constrain its value. Editing enforces what it can as the user types:
+// text that would pass maxLength grapheme clusters is cut short, and a
+// number input takes only what could begin a number. The rest is checked
+// when the input is committed — on enter in a single-line input, or on
+// losing focus — as HTML does: the value must be a number or an email
+// address for those types and match the whole of pattern (Go regexp
+// syntax; a pattern that does not compile is ignored). An empty value is
+// always valid.
+//
+// Each commit sends the source a validation event saying whether the
+// value is valid and, if not, which prop it breaks. Renderers draw an
+// input whose last commit failed with its style's invalid props, or in
+// invalidColor if it has none, until a commit passes.
+
+// Input types.
+const (
+	InputText   = "text"
+	InputNumber = "number"
+	InputEmail  = "email"
+)
+
+// invalidColor is the text color of invalid inputs without invalid props.
+const invalidColor = "#cc3333"
+
+var (
+	partialNumber = regexp.MustCompile(`^[-+]?[0-9]*\.?[0-9]*$`)
+	emailAddress  = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+)
+
+// allowedText returns the part of text that may be inserted at offset at
+// into an input whose value, less any selection being replaced, is rest.
+func allowedText(node *RenderNode, rest string, at int, text string) string {
+	p := node.Props
+	if p.MaxLength != nil {
+		room := max(*p.MaxLength-len(Graphemes(rest)), 0)
+		if clusters := Graphemes(text); len(clusters) > room {
+			text = strings.Join(clusters[:room], "")
+		}
+	}
+	if p.InputType == InputNumber && !partialNumber.MatchString(rest[:at]+text+rest[at:]) {
+		return ""
+	}
+	return text
+}
+
+// invalidReason returns the prop an input's value breaks, or "" if it is
+// valid.
+func invalidReason(node *RenderNode) string {
+	p := node.Props
+	value := inputValue(node)
+	if value == "" {
+		return ""
+	}
+	if p.MaxLength != nil && len(Graphemes(value)) > *p.MaxLength {
+		return "maxLength"
+	}
+	switch p.InputType {
+	case InputNumber:
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return "inputType"
+		}
+	case InputEmail:
+		if !emailAddress.MatchString(value) {
+			return "inputType"
+		}
+	}
+	if p.Pattern != "" {
+		if re, err := regexp.Compile(`^(?:` + p.Pattern + `)$`); err == nil && !re.MatchString(value) {
+			return "pattern"
+		}
+	}
+	return ""
+}
+
+// commitInput validates an input, records whether it is invalid for
+// renderers, and sends the source a validation event.
+// Must be called with the mutex held.
+func (v *Viewer) commitInput(node *RenderNode) {
+	reason := invalidReason(node)
+	valid := reason == ""
+	if valid != !v.tree.Invalid[node.ID] {
+		if valid {
+			delete(v.tree.Invalid, node.ID)
+		} else {
+			if v.tree.Invalid == nil {
+				v.tree.Invalid = make(map[int]bool)
+			}
+			v.tree.Invalid[node.ID] = true
+		}
+		v.markDirty()
+		v.signalChanged()
+	}
+	id := node.ID
+	event := InputEvent{Target: &id, Kind: "validation", Value: inputValue(node), Valid: &valid, Reason: reason}
+	v.emit(ProtocolMessage{Type: MsgInput, Event: &event})
+}
@@ -17,12 +17,111 @@ type Viewer struct {
 
 	// Configuration
 	renderTarget RenderTarget
+	targets      []*targetState // renderTarget first, then AddTarget order
 
 	// State
 	tree             *RenderTree
 	env              *EnvInfo
 	messageHandlers  []func(ProtocolMessage)
-	dirty            bool
+	generation       uint64 // bumped on every state change; see markDirty
+	layoutGen        uint64 // generation the computed layout reflects
+	atomicPatches    bool
+
+	// Sequencing
+	lastSeq       uint64
+	seqStarted    bool
+	resyncPending  bool
+	refreshPending bool // the pending resync is a MsgRefresh
+
+	// Keepalive pings and metrics reports (nil when disabled)
+	heartbeat     *heartbeatState
+	metricsReport *metricsReportState
+
+	// Pings awaiting a pong, by nonce, and clock offset samples
+	pingNext     uint64
+	pings        map[uint64]time.Time
+	clockSamples []clockSample
+
+	// Flow control (nil when disabled)
+	flow *flowState
+
+	// Quotas for untrusted sources (nil when disabled)
+	quota *quotaState
+	clock Clock
+
+	// Closed and replaced on each state change (see WaitForCtx)
+	changed chan struct{}
+
+	// Handshake
+	requires     *Requirements
+	incompatible error
+
+	// Slot values with env-conditional variants applied
+	resolved map[int]SlotValue
+
+	// DOM listeners per HtmlTarget container (js/wasm builds only)
+	dom map[string]*domState
+
+	// Decoded image frames for grid rendering
+	images imageCache
+
+	// Kinetic scrolling (nil config means DefaultScrollConfig)
+	scrollCfg *ScrollConfig
+	scrolls   map[int]*scrollState
+
+	// Pointer input: the layout in input units, a scrollbar drag, a
+	// press on a draggable node, and the node capturing the pointer
+	input    *inputLayout
+	drag     *scrollDrag
+	dragDrop *dragDrop
+	capture  *int
+
+	// Touches of the gesture in progress, if any, and controllers by index
+	gesture  *gestureState
+	gamepads map[int]*gamepadState
+
+	// Key repeat (nil config means DefaultKeyRepeatConfig), the key being
+	// repeated, and whether the host sends repeats itself
+	keyRepeatCfg *KeyRepeatConfig
+	heldKey      *keyRepeat
+	nativeRepeat bool
+
+	// Text cursor (nil config means DefaultCursorConfig) and when it
+	// last moved, which restarts its blink
+	cursorCfg   *CursorConfig
+	cursorMoved time.Time
+	selecting   bool // a pointer drag is selecting text
+
+	// Clipboard (nil means the default for the render target), and what
+	// the source may do with it
+	clip       Clipboard
+	clipPolicy ClipboardPolicy
+
+	// File drops: limits, and the ID of the last file sent
+	fileLimits FileLimits
+	fileNext   uint64
+
+	// Sound effects (nil sink plays nothing)
+	audio AudioSink
+	muted bool
+
+	// Notifications (nil means the attached terminal, if any)
+	notifier Notifier
+
+	// Screenshots and projections leave out secret values entirely
+	redactSecrets bool
+
+	// Time zone of absolute_time columns (nil means local) and locale of
+	// number columns, kept on the tree
+	timeZone *time.Location
+	locale   string
+
+	// Tooltip: the node hovered or focused and since when, until its
+	// tooltip shows and after
+	tooltip      *int
+	tooltipSince time.Time
+
+	errorHandlers []func(error)
 
 	// Metrics
 	messagesProcessed int
@@ -34,6 +133,26 @@ type Viewer struct {
 	patchesApplied    int
 	patchesFailed     int
 	frameTimes        []float64
+	seqGaps           int
+	seqDuplicates     int
+	resyncRequests    int
+	hashMismatches    int
+	latencyMs         float64 // smoothed heartbeat round trip
+	transportDelayMs  float64 // smoothed delay of timestamped messages
+	delaySamples      int
+	heartbeatTimeouts int
+
+	// Input latency: when each stamped event was sent, by echo token
+	echoNext           uint64
+	echoSent           map[uint64]time.Time
+	inputLatencyMs     float64
+	inputLatencySum    float64
+	inputLatencyCount  int
+	peakInputLatencyMs float64
+	framesDropped     int
+	framesDeferred    int
+	quotaViolations   int
+	decodeErrors      int
 }
 
 // NewViewer creates a new Viewer with the specified render target.
@@ -41,6 +160,7 @@ type Viewer struct {
 func NewViewer(target RenderTarget) *Viewer {
 	return &Viewer{
 		renderTarget:    target,
+		targets:         []*targetState{{target: target, hasRendered: true}},
 		tree:            NewRenderTree(),
 		messageHandlers: nil,
 		frameTimes:      make([]float64, 0, 128),
@@ -53,7 +173,9 @@ func (v *Viewer) Init(env EnvInfo) {
 	defer v.mu.Unlock()
 
 	v.env = &env
-	v.tree = NewRenderTree()
+	v.tree = v.newTree()
+	v.resolved = nil
+	v.resetSeq()
 	v.resetMetrics()
 }
 
@@ -67,7 +189,7 @@ func (v *Viewer) SetTree(root *VNode) {
 	v.messagesProcessed++
 
 	SetTreeRoot(v.tree, root)
-	v.dirty = true
+	v.markDirty()
 
 	v.trackFrameTime(start)
 }
@@ -80,14 +202,22 @@ func (v *Viewer) ApplyPatches(ops []PatchOp) {
 	start := time.Now()
 	v.messagesProcessed++
 
-	applied, failed := ApplyPatches(v.tree, ops)
-	v.patchesApplied += applied
-	v.patchesFailed += failed
-	v.dirty = true
+	v.applyPatchBatch(ops)
+	v.markDirty()
 
 	v.trackFrameTime(start)
 }
 
+// SetAtomicPatches enables or disables transactional patch application.
+// When enabled, each patch batch is validated against a shadow copy of the
+// tree and committed only if every op succeeds, so a failure mid-batch
+// never leaves the tree half-updated.
+func (v *Viewer) SetAtomicPatches(enabled bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.atomicPatches = enabled
+}
+
 // DefineSlot defines a slot directly (no serialization).
 func (v *Viewer) DefineSlot(slot int, value SlotValue) {
 	v.mu.Lock()
@@ -97,25 +227,59 @@ func (v *Viewer) DefineSlot(slot int, value SlotValue) {
 	v.messagesProcessed++
 
 	v.tree.Slots[slot] = value
+	v.resolveSlot(slot)
 	v.slotCount = len(v.tree.Slots)
-	v.dirty = true
+	v.markDirty()
 
 	v.trackFrameTime(start)
 }
 
 // ProcessMessage processes a decoded protocol message, updating internal
-// state. This is the wire-protocol path.
+// state. This is the wire-protocol path. Messages exceeding configured
+// quotas (see SetQuotas) are rejected.
+//
+// If the message carries a sequence number, the viewer acknowledges it
+// upstream with MsgAck, drops duplicates, and on a gap sends MsgResync and
+// ignores incremental messages until the next full tree arrives.
 func (v *Viewer) ProcessMessage(msg ProtocolMessage) {
 	v.mu.Lock()
 	defer v.mu.Unlock()
+	v.processMessage(msg)
+}
+
+// processMessage is the body of ProcessMessage.
+// Must be called with the mutex held.
+func (v *Viewer) processMessage(msg ProtocolMessage) {
+	if msg.Type == MsgBatch {
+		// Only the contained messages count as processed.
+		v.processBatch(msg)
+		return
+	}
 
 	start := time.Now()
 	v.messagesProcessed++
 
+	if !v.checkQuota(msg) {
+		v.trackFrameTime(start)
+		return
+	}
+
+	if msg.Seq != nil && !v.checkSeq(msg) {
+		v.trackFrameTime(start)
+		return
+	}
+	// Unnumbered frames skip sequence tracking, but a refresh asked for
+	// after a hash mismatch still has to wait for its tree.
+	if msg.Seq == nil && v.dropForResync(msg.Type) {
+		v.trackFrameTime(start)
+		return
+	}
+
 	switch msg.Type {
 	case MsgDefine:
 		if msg.Slot != nil && msg.SlotValue != nil {
 			v.tree.Slots[*msg.Slot] = msg.SlotValue
+			v.resolveSlot(*msg.Slot)
 			v.slotCount = len(v.tree.Slots)
 		}
 
@@ -125,15 +289,37 @@ func (v *Viewer) ProcessMessage(msg ProtocolMessage) {
 		}
 
 	case MsgPatch:
-		applied, failed := ApplyPatches(v.tree, msg.Ops)
-		v.patchesApplied += applied
-		v.patchesFailed += failed
+		v.applyPatchBatch(msg.Ops)
 
 	case MsgSchema:
 		if msg.Slot != nil {
 			v.tree.Schemas[*msg.Slot] = msg.Columns
 		}
 
+	case MsgHash:
+		v.checkHash(msg)
+
+	case MsgAudio:
+		if msg.Slot != nil {
+			v.playSound(*msg.Slot)
+		}
+
+	case MsgNotify:
+		if msg.Notify != nil {
+			v.notify(*msg.Notify)
+		}
+
+	case MsgClipboard:
+		if msg.Clipboard != nil {
+			v.handleClipboard(msg)
+		}
+
+	case MsgPing:
+		v.emit(ProtocolMessage{Type: MsgPong, Nonce: msg.Nonce})
+
+	case MsgPong:
+		v.receivePong(msg)
+
 	case MsgData:
 		schemaSlot := 0
 		if msg.Schema != nil {
@@ -150,19 +336,28 @@ func (v *Viewer) ProcessMessage(msg ProtocolMessage) {
 	case MsgInput:
 		if msg.Event != nil {
 			// Forward input to registered handlers
-			inputMsg := ProtocolMessage{Type: MsgInput, Event: msg.Event}
-			for _, handler := range v.messageHandlers {
-				handler(inputMsg)
-			}
+			v.emit(ProtocolMessage{Type: MsgInput, Event: msg.Event})
 		}
 
 	case MsgEnv:
 		if msg.Env != nil {
 			v.env = msg.Env
+			v.resolveAllSlots()
+		}
+
+	case MsgRequire:
+		if msg.Requires != nil {
+			v.handleRequire(*msg.Requires)
 		}
 	}
 
-	v.dirty = true
+	if msg.Echo != nil {
+		v.receiveEcho(*msg.Echo)
+	}
+	if msg.Sent != nil && msg.Type != MsgPong {
+		v.receiveTimestamp(*msg.Sent)
+	}
+	v.markDirty()
 	v.trackFrameTime(start)
 }
 
@@ -177,7 +372,18 @@ func (v *Viewer) GetTree() *RenderTree {
 func (v *Viewer) GetTextProjection() string {
 	v.mu.Lock()
 	defer v.mu.Unlock()
-	return TextProjection(v.tree)
+	return TextProjectionWithOptions(v.tree, v.projectionOptions())
+}
+
+// GetTextProjectionWithOptions returns the text projection of the
+// current tree with custom options, laid out against the display first
+// so scroll content can be clipped to what is scrolled into view.
+func (v *Viewer) GetTextProjectionWithOptions(opts TextProjectionOptions) string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.ensureLayout()
+	opts.RedactSecrets = opts.RedactSecrets || v.redactSecrets
+	return TextProjectionWithOptions(v.tree, opts)
 }
 
 // GetLayout returns the computed layout for a node, or nil if not found.
@@ -189,35 +395,70 @@ func (v *Viewer) GetLayout(nodeID int) *ComputedLayout {
 	if !ok {
 		return nil
 	}
+	v.ensureLayout()
 	return node.ComputedLayout
 }
 
-// Render renders to the target output. Returns whether anything changed.
+// Render renders to every target output that is out of date. Returns
+// whether anything changed.
 func (v *Viewer) Render() bool {
 	v.mu.Lock()
 	defer v.mu.Unlock()
 
-	if !v.dirty {
-		return false
-	}
+	return v.render()
+}
 
-	switch v.renderTarget.TargetType() {
-	case "ansi":
-		// Would write ANSI to fd; for now produce the text
-		_ = v.renderToAnsi()
-	case "headless":
-		// No output needed
+// Resize updates the display dimensions in EnvInfo, invalidates computed
+// layout, re-renders, and sends the new environment upstream as MsgEnv so
+// the source can adapt. Does nothing if the size is unchanged.
+func (v *Viewer) Resize(width, height int) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	env := EnvInfo{ViewportVersion: ProtocolVersion}
+	if v.env != nil {
+		env = *v.env
 	}
+	if v.env != nil && env.DisplayWidth == width && env.DisplayHeight == height {
+		return
+	}
+	env.DisplayWidth = width
+	env.DisplayHeight = height
+	v.env = &env
 
-	v.dirty = false
-	return true
+	v.resolveAllSlots()
+	v.invalidateLayout()
+	v.markDirty()
+	v.render()
+
+	upstream := env
+	v.emit(ProtocolMessage{Type: MsgEnv, Env: &upstream})
+	v.signalChanged()
+}
+
+// GetEnv returns a copy of the current environment info, or nil if the
+// viewer has not been initialized.
+func (v *Viewer) GetEnv() *EnvInfo {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.env == nil {
+		return nil
+	}
+	env := *v.env
+	return &env
 }
 
 // GetMetrics returns current performance/state metrics.
 func (v *Viewer) GetMetrics() ViewerMetrics {
 	v.mu.Lock()
 	defer v.mu.Unlock()
+	return v.metrics()
+}
 
+// metrics is the body of GetMetrics.
+// Must be called with the mutex held.
+func (v *Viewer) metrics() ViewerMetrics {
 	avg := 0.0
 	if len(v.frameTimes) > 0 {
 		sum := 0.0
@@ -227,6 +468,11 @@ func (v *Viewer) GetMetrics() ViewerMetrics {
 		avg = sum / float64(len(v.frameTimes))
 	}
 
+	avgInput := 0.0
+	if v.inputLatencyCount > 0 {
+		avgInput = v.inputLatencySum / float64(v.inputLatencyCount)
+	}
+
 	frameTimesCopy := make([]float64, len(v.frameTimes))
 	copy(frameTimesCopy, v.frameTimes)
 
@@ -242,6 +488,21 @@ func (v *Viewer) GetMetrics() ViewerMetrics {
 		SlotCount:         v.slotCount,
 		DataRowCount:      v.dataRowCount,
 		FrameTimesMs:      frameTimesCopy,
+		SeqGaps:           v.seqGaps,
+		SeqDuplicates:     v.seqDuplicates,
+		ResyncRequests:    v.resyncRequests,
+		HashMismatches:    v.hashMismatches,
+		LatencyMs:         v.latencyMs,
+		TransportDelayMs:  v.transportDelayMs,
+		HeartbeatTimeouts: v.heartbeatTimeouts,
+		InputLatencyMs:     v.inputLatencyMs,
+		AvgInputLatencyMs:  avgInput,
+		PeakInputLatencyMs: v.peakInputLatencyMs,
+		FramesQueued:      v.queuedFrames(),
+		FramesDropped:     v.framesDropped,
+		FramesDeferred:    v.framesDeferred,
+		QuotaViolations:   v.quotaViolations,
+		DecodeErrors:      v.decodeErrors,
 	}
 }
 
@@ -266,15 +527,16 @@ func (v *Viewer) Screenshot() ScreenshotResult {
 	}
 }
 
-// SendInput injects an input event (for automation).
+// SendInput injects an input event (for automation). Click, key, and
+// value_change events aimed at a disabled node are dropped.
 func (v *Viewer) SendInput(event InputEvent) {
 	v.mu.Lock()
 	defer v.mu.Unlock()
 
-	msg := ProtocolMessage{Type: MsgInput, Event: &event}
-	for _, handler := range v.messageHandlers {
-		handler(msg)
+	if event.Target != nil && disabledKinds[event.Kind] && v.disabled(*event.Target) {
+		return
 	}
+	v.emit(ProtocolMessage{Type: MsgInput, Event: &event})
 }
 
 // OnMessage registers a callback for outbound messages (e.g. input events).
@@ -284,11 +546,26 @@ func (v *Viewer) OnMessage(handler func(ProtocolMessage)) {
 	v.messageHandlers = append(v.messageHandlers, handler)
 }
 
+// OnError registers a handler for non-fatal errors found while rendering,
+// such as image data that fails to decode (*ImageError), and for
+// clipboard, audio and notification failures.
+func (v *Viewer) OnError(handler func(error)) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.errorHandlers = append(v.errorHandlers, handler)
+}
+
 // TrackBytes records received byte count for metrics (called by harness).
 func (v *Viewer) TrackBytes(n int) {
 	v.mu.Lock()
 	defer v.mu.Unlock()
 	v.bytesReceived += n
+	if v.quota != nil {
+		// Roll the window first, or these bytes would be dropped with
+		// the old window's when the next message is checked.
+		v.quota.roll(v.now())
+		v.quota.windowBytes += n
+	}
 }
 
 // Destroy tears down the viewer and releases resources.
@@ -297,7 +574,28 @@ func (v *Viewer) Destroy() {
 	defer v.mu.Unlock()
 
 	v.messageHandlers = nil
-	v.tree = NewRenderTree()
+	v.errorHandlers = nil
+	v.images = imageCache{}
+	v.releaseDOM()
+	for _, ts := range v.targets {
+		v.releaseTexture(ts)
+	}
+	v.flow = nil
+	v.scrolls = nil
+	v.input = nil
+	v.drag = nil
+	v.dragDrop = nil
+	v.capture = nil
+	v.gesture = nil
+	v.heldKey = nil
+	v.nativeRepeat = false
+	v.selecting = false
+	v.tooltip = nil
+	v.resolved = nil
+	v.requires = nil
+	v.incompatible = nil
+	v.tree = v.newTree()
+	v.resetSeq()
 	v.resetMetrics()
 }
 
@@ -308,9 +606,21 @@ func (v *Viewer) RenderTargetValue() RenderTarget {
 
 // ── Internal helpers ─────────────────────────────────────────────────
 
-// trackFrameTime records the elapsed time for a frame processing operation.
-// Must be called with the mutex held.
+// newTree returns an empty tree that reads the viewer's clock and shows
+// times and numbers in its zone and locale.
+func (v *Viewer) newTree() *RenderTree {
+	tree := NewRenderTree()
+	tree.Clock = v.clock
+	tree.TimeZone = v.timeZone
+	tree.Locale = v.locale
+	return tree
+}
+
+// trackFrameTime records the elapsed time for a frame processing operation
+// and wakes any WaitFor callers. Must be called with the mutex held.
 func (v *Viewer) trackFrameTime(start time.Time) {
+	v.signalChanged()
+
 	elapsed := float64(time.Since(start).Microseconds()) / 1000.0 // ms
 	v.frameTimes = append(v.frameTimes, elapsed)
 	if len(v.frameTimes) > 1000 {
@@ -323,6 +633,137 @@ func (v *Viewer) trackFrameTime(start time.Time) {
 	}
 }
 
+// emit sends an outbound message to all registered handlers, stamping
+// input events for latency measurement (see stampInput).
+// Must be called with the mutex held.
+func (v *Viewer) emit(msg ProtocolMessage) {
+	if msg.Type == MsgInput && msg.Event != nil {
+		event := *msg.Event
+		v.stampInput(&event)
+		msg.Event = &event
+	}
+	for _, handler := range v.messageHandlers {
+		handler(msg)
+	}
+}
+
+// reportErrors delivers errors collected during rendering to the error
+// handlers.
+// Must be called with the mutex held.
+func (v *Viewer) reportErrors() {
+	errs := v.images.errors
+	v.images.errors = nil
+	for _, err := range errs {
+		v.reportError(err)
+	}
+}
+
+// reportError delivers one error to the error handlers.
+// Must be called with the mutex held.
+func (v *Viewer) reportError(err error) {
+	for _, handler := range v.errorHandlers {
+		handler(err)
+	}
+}
+
+// checkSeq tracks the sequence number of an inbound frame, acknowledging
+// it and detecting duplicates and gaps. Returns false if the frame should
+// be dropped. Must be called with the mutex held.
+func (v *Viewer) checkSeq(msg ProtocolMessage) bool {
+	seq := *msg.Seq
+	if v.seqStarted && seq <= v.lastSeq {
+		v.seqDuplicates++
+		return false
+	}
+
+	if v.seqStarted && seq > v.lastSeq+1 {
+		v.seqGaps++
+		// Frames were lost: everything incremental from here on is
+		// relative to state we never saw, so ask for a full resend.
+		v.requestResync()
+	}
+
+	v.seqStarted = true
+	v.lastSeq = seq
+	v.emit(ProtocolMessage{Type: MsgAck, Ack: &seq})
+	return !v.dropForResync(msg.Type)
+}
+
+// requestResync sends MsgResync upstream, unless one is already pending,
+// and drops incremental messages until the full tree arrives.
+// Must be called with the mutex held.
+func (v *Viewer) requestResync() {
+	if v.resyncPending {
+		return
+	}
+	v.resyncPending = true
+	v.resyncRequests++
+	last := v.lastSeq
+	v.emit(ProtocolMessage{Type: MsgResync, Ack: &last})
+}
+
+// dropForResync reports whether a message of type t must be dropped while
+// waiting for a full tree after a gap, and ends the wait if t is that
+// tree. Must be called with the mutex held.
+func (v *Viewer) dropForResync(t MessageType) bool {
+	if v.resyncPending {
+		switch t {
+		case MsgTree:
+			v.resyncPending = false
+			v.refreshPending = false
+		case MsgPatch, MsgData, MsgHash:
+			return true
+		}
+	}
+	return false
+}
+
+// processBatch applies the messages of a MsgBatch in order, as one frame:
+// the batch's seq is acknowledged once, and quotas and resync dropping
+// apply to each contained message. Nested batches are ignored.
+// Must be called with the mutex held.
+func (v *Viewer) processBatch(msg ProtocolMessage) {
+	if msg.Seq != nil && !v.checkSeq(msg) {
+		return
+	}
+	for _, m := range msg.Messages {
+		if m.Type == MsgBatch || v.dropForResync(m.Type) {
+			continue
+		}
+		m.Seq = nil
+		v.processMessage(m)
+	}
+	if msg.Echo != nil {
+		v.receiveEcho(*msg.Echo)
+	}
+	if msg.Sent != nil {
+		v.receiveTimestamp(*msg.Sent)
+	}
+}
+
+// applyPatchBatch applies ops to the tree, honouring atomic mode, and
+// updates patch counters. Must be called with the mutex held.
+func (v *Viewer) applyPatchBatch(ops []PatchOp) {
+	if v.atomicPatches {
+		if i := applyPatchesAtomic(v.tree, ops); i >= 0 {
+			v.patchesFailed += len(ops)
+			v.reportPatchFailure(i, ops[i])
+			return
+		}
+		v.patchesApplied += len(ops)
+		return
+	}
+
+	for i, op := range ops {
+		if ApplyPatch(v.tree, op) {
+			v.patchesApplied++
+		} else {
+			v.patchesFailed++
+			v.reportPatchFailure(i, op)
+		}
+	}
+}
+
 // estimateMemory returns a rough estimate of memory usage in bytes.
 // Must be called with the mutex held.
 func (v *Viewer) estimateMemory() int {
@@ -368,11 +809,18 @@ func (v *Viewer) renderToAnsi() string {
 		case NodeInput:
 			val := ""
 			if node.Props.Value != nil {
-				val = *node.Props.Value
+				val = projectedValue(node, v.redactSecrets)
 			} else if node.Props.Placeholder != nil {
 				val = *node.Props.Placeholder
 			}
 			lines = append(lines, fmt.Sprintf("%s[input%s: %s]", indent, idStr, val))
+		case NodeCheckbox, NodeRadio, NodeSelect, NodeButton:
+			lines = append(lines, fmt.Sprintf("%s[%s%s: %s]", indent, node.Type, idStr, controlText(node)))
+		case NodeTable:
+			_, rows := tableData(v.tree, node)
+			lines = append(lines, fmt.Sprintf("%s[table%s: %d rows]", indent, idStr, len(rows)))
+		case NodeProgress, NodeSpinner:
+			lines = append(lines, fmt.Sprintf("%s[%s%s: %s]", indent, node.Type, idStr, progressText(node)))
 		case NodeSeparator:
 			lines = append(lines, fmt.Sprintf("%s\u2500\u2500\u2500\u2500\u2500\u2500\u2500\u2500\u2500\u2500\u2500\u2500\u2500\u2500\u2500\u2500", indent))
 		case NodeCanvas:
@@ -393,6 +841,60 @@ func (v *Viewer) renderToAnsi() string {
 	return strings.Join(lines, "\n")
 }
 
+// render renders every target whose output is stale. Returns whether
+// anything changed. Must be called with the mutex held.
+func (v *Viewer) render() bool {
+	changed := false
+	for _, ts := range v.targets {
+		if v.renderTo(ts) {
+			changed = true
+		}
+	}
+	return changed
+}
+
+// invalidateLayout clears computed layout on every node so it is
+// recomputed against the current environment.
+// Must be called with the mutex held.
+func (v *Viewer) invalidateLayout() {
+	for _, node := range v.tree.NodeIndex {
+		node.ComputedLayout = nil
+	}
+}
+
+// ensureLayout computes pixel layout for the tree against the display
+// size if the tree changed since it was last computed.
+// Must be called with the mutex held.
+func (v *Viewer) ensureLayout() {
+	if v.tree.Root == nil {
+		return
+	}
+	if v.tree.Root.ComputedLayout != nil && v.layoutGen == v.generation {
+		return
+	}
+	width, height := v.displaySize()
+	ComputeLayout(v.tree, float64(width), float64(height), PixelLayoutOptions())
+	v.layoutGen = v.generation
+}
+
+// displaySize returns the display size in pixels, 800 × 600 if unknown.
+// Must be called with the mutex held.
+func (v *Viewer) displaySize() (width, height int) {
+	if v.env != nil && v.env.DisplayWidth > 0 && v.env.DisplayHeight > 0 {
+		return v.env.DisplayWidth, v.env.DisplayHeight
+	}
+	return 800, 600
+}
+
+// resetSeq clears sequence tracking state.
+// Must be called with the mutex held.
+func (v *Viewer) resetSeq() {
+	v.lastSeq = 0
+	v.seqStarted = false
+	v.resyncPending = false
+	v.refreshPending = false
+}
+
 // resetMetrics clears all metrics to initial values.
 // Must be called with the mutex held.
 func (v *Viewer) resetMetrics() {
@@ -404,5 +906,21 @@ func (v *Viewer) resetMetrics() {
 	v.dataRowCount = 0
 	v.patchesApplied = 0
 	v.patchesFailed = 0
+	v.seqGaps = 0
+	v.seqDuplicates = 0
+	v.resyncRequests = 0
+	v.hashMismatches = 0
+	v.latencyMs = 0
+	v.transportDelayMs = 0
+	v.delaySamples = 0
+	v.heartbeatTimeouts = 0
+	v.inputLatencyMs = 0
+	v.inputLatencySum = 0
+	v.inputLatencyCount = 0
+	v.peakInputLatencyMs = 0
+	v.framesDropped = 0
+	v.framesDeferred = 0
+	v.quotaViolations = 0
+	v.decodeErrors = 0
 	v.frameTimes = make([]float64, 0, 128)
 }
@@ -1,8 +1,26 @@
 package viewer
 
 import (
+	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"reflect"
+	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
 )
 
 // ── Wire format tests ────────────────────────────────────────────────
@@ -39,6 +57,16 @@ func TestDecodeHeaderBadMagic(t *testing.T) {
 	}
 }
 
+func TestDecodeHeaderVersion(t *testing.T) {
+	for _, version := range []byte{0, 3, 0xff} {
+		buf := EncodeSessionHeader(MsgTree, 0, 1, 1)
+		buf[2] = version
+		if _, err := DecodeHeader(buf); !errors.Is(err, ErrUnsupportedVersion) {
+			t.Errorf("version %d: expected ErrUnsupportedVersion, got %v", version, err)
+		}
+	}
+}
+
 func TestDecodeHeaderTooShort(t *testing.T) {
 	_, err := DecodeHeader([]byte{0x56, 0x50})
 	if err != ErrBufferTooShort {
@@ -112,10 +140,116 @@ func TestFrameReaderMultipleFrames(t *testing.T) {
 	}
 }
 
+func TestDecodeMessageRoundTrip(t *testing.T) {
+	slot := 7
+	seq := uint64(3)
+	frame, err := EncodeFrame(&ProtocolMessage{
+		Type:      MsgDefine,
+		Seq:       &seq,
+		Slot:      &slot,
+		SlotValue: ColorSlot{Kind: "color", Role: "accent", Value: "#00ff00"},
+	})
+	if err != nil {
+		t.Fatalf("EncodeFrame: %v", err)
+	}
+
+	header, payload, err := DecodeFrame(frame)
+	if err != nil {
+		t.Fatalf("DecodeFrame: %v", err)
+	}
+	msg, err := DecodeMessage(header, payload)
+	if err != nil {
+		t.Fatalf("DecodeMessage: %v", err)
+	}
+
+	if msg.Type != MsgDefine || msg.Slot == nil || *msg.Slot != 7 || msg.Seq == nil || *msg.Seq != 3 {
+		t.Errorf("decoded message = %+v", msg)
+	}
+	color, ok := msg.SlotValue.(ColorSlot)
+	if !ok || color.Value != "#00ff00" {
+		t.Errorf("slot value = %#v, want ColorSlot #00ff00", msg.SlotValue)
+	}
+}
+
+func TestSessionHeaderRoundTrip(t *testing.T) {
+	seq := uint64(9)
+	frame, err := EncodeSessionFrame(&ProtocolMessage{Type: MsgTree, Seq: &seq, Root: makeSimpleTree()}, 42)
+	if err != nil {
+		t.Fatalf("EncodeSessionFrame: %v", err)
+	}
+
+	fr := NewFrameReader()
+	frames, err := fr.Feed(frame[:HeaderSize+4]) // past v1 header, inside v2 header
+	if err != nil || len(frames) != 0 {
+		t.Fatalf("partial feed = %d frames, %v", len(frames), err)
+	}
+	frames, err = fr.Feed(frame[HeaderSize+4:])
+	if err != nil || len(frames) != 1 {
+		t.Fatalf("full feed = %d frames, %v", len(frames), err)
+	}
+
+	h := frames[0].Header
+	if h.Version != SessionProtocolVersion || h.Session != 42 || h.Seq != 9 || h.Size() != SessionHeaderSize {
+		t.Errorf("header = %+v", h)
+	}
+	msg, err := DecodeMessage(h, frames[0].Payload)
+	if err != nil || msg.Root == nil || msg.Root.ID != 1 {
+		t.Errorf("DecodeMessage = %+v, %v", msg, err)
+	}
+}
+
+func TestRecorderRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	clock := &ManualClock{T: time.Unix(100, 0)}
+	rec, err := NewRecorder(&buf, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec.Write([]byte("abc"))
+	clock.Advance(250 * time.Millisecond)
+	rec.Record(DirOutbound, []byte("de"))
+
+	if !IsRecording(buf.Bytes()) {
+		t.Fatal("recording should start with magic")
+	}
+	rr, err := NewRecordingReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _ := rr.Next()
+	second, _ := rr.Next()
+	if string(first.Data) != "abc" || first.Dir != DirInbound || first.At != 0 {
+		t.Errorf("first chunk = %+v", first)
+	}
+	if string(second.Data) != "de" || second.Dir != DirOutbound || second.At != 250*time.Millisecond {
+		t.Errorf("second chunk = %+v", second)
+	}
+	if _, err := rr.Next(); err != io.EOF {
+		t.Errorf("end err = %v, want io.EOF", err)
+	}
+
+	if _, err := NewRecordingReader(bytes.NewReader([]byte("VP\x01\x02...."))); err != ErrNotRecording {
+		t.Errorf("raw frames err = %v, want ErrNotRecording", err)
+	}
+}
+
 // ── Tree operation tests ─────────────────────────────────────────────
 
 func strPtr(s string) *string { return &s }
 
+func floatPtr(f float64) *float64 { return &f }
+
+func intPtr(n int) *int { return &n }
+
+func encodeTestPNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, w, h))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
 func makeSimpleTree() *VNode {
 	return &VNode{
 		ID:   1,
@@ -262,6 +396,53 @@ func TestApplyPatches(t *testing.T) {
 	}
 }
 
+func TestApplyPatchesAtomicRollback(t *testing.T) {
+	tree := NewRenderTree()
+	SetTreeRoot(tree, makeSimpleTree())
+
+	err := ApplyPatchesAtomic(tree, []PatchOp{
+		{Target: 2, Set: map[string]interface{}{"content": "A"}},
+		{Target: 3, Remove: true},
+		{Target: 999, Set: map[string]interface{}{"content": "C"}}, // non-existent
+	})
+
+	if !errors.Is(err, ErrPatchFailed) {
+		t.Fatalf("expected ErrPatchFailed, got %v", err)
+	}
+	if got := *tree.NodeIndex[2].Props.Content; got != "Hello" {
+		t.Errorf("content = %q, want unchanged 'Hello'", got)
+	}
+	if len(tree.Root.Children) != 2 {
+		t.Errorf("children count = %d, want 2", len(tree.Root.Children))
+	}
+	if _, ok := tree.NodeIndex[3]; !ok {
+		t.Error("node 3 missing from index after rollback")
+	}
+}
+
+func TestApplyPatchesAtomicCommit(t *testing.T) {
+	tree := NewRenderTree()
+	SetTreeRoot(tree, makeSimpleTree())
+
+	err := ApplyPatchesAtomic(tree, []PatchOp{
+		{Target: 2, Set: map[string]interface{}{"content": "A"}},
+		{Target: 3, Remove: true},
+	})
+	if err != nil {
+		t.Fatalf("ApplyPatchesAtomic: %v", err)
+	}
+
+	if tree.NodeIndex[2] != tree.Root.Children[0] {
+		t.Error("index not rebuilt against committed tree")
+	}
+	if got := *tree.NodeIndex[2].Props.Content; got != "A" {
+		t.Errorf("content = %q, want 'A'", got)
+	}
+	if len(tree.Root.Children) != 1 {
+		t.Errorf("children count = %d, want 1", len(tree.Root.Children))
+	}
+}
+
 func TestCountNodes(t *testing.T) {
 	tree := NewRenderTree()
 	SetTreeRoot(tree, makeSimpleTree())
@@ -332,6 +513,86 @@ func TestFindByText(t *testing.T) {
 	}
 }
 
+func TestBuilder(t *testing.T) {
+	root, err := Box(Dir("row"), Gap(2),
+		Text("Hello").Weight("bold"),
+		Input().Placeholder("name").ID(1),
+		Column(Padding(4, 8), Text("nested")),
+	).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if root.Type != NodeBox || root.Props.Direction != "row" || *root.Props.Gap != 2 {
+		t.Errorf("root = %+v", root)
+	}
+	// Explicit ID 1 is reserved; automatic IDs skip it.
+	ids := []int{root.ID, root.Children[0].ID, root.Children[1].ID, root.Children[2].ID, root.Children[2].Children[0].ID}
+	want := []int{2, 3, 1, 4, 5}
+	for i := range want {
+		if ids[i] != want[i] {
+			t.Errorf("ids = %v, want %v", ids, want)
+			break
+		}
+	}
+	if root.Children[0].Props.Weight != "bold" || *root.Children[1].Props.Placeholder != "name" {
+		t.Error("chained props not set")
+	}
+
+	tree := NewRenderTree()
+	SetTreeRoot(tree, root)
+	if got := TextProjection(tree); got != "Hello\tname\tnested" {
+		t.Errorf("projection = %q", got)
+	}
+}
+
+func TestBuilderValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		el   *Element
+	}{
+		{"bad direction", Box(Dir("diagonal"))},
+		{"bad weight", Text("x").Weight("heavy")},
+		{"duplicate id", Box(Text("a").ID(3), Text("b").ID(3))},
+		{"leaf children", Text("x").Children(Text("y"))},
+		{"bad padding", Box(Padding(1, 2, 3))},
+		{"duplicate key", Box(Text("a").Key("k"), Text("b").Key("k"))},
+	}
+	for _, tt := range tests {
+		if _, err := tt.el.Build(); !errors.Is(err, ErrInvalidTree) {
+			t.Errorf("%s: err = %v, want ErrInvalidTree", tt.name, err)
+		}
+	}
+}
+
+func TestBuilderBuildWith(t *testing.T) {
+	ids := NewIDAllocator()
+	build := func(items ...string) *VNode {
+		list := Column()
+		for _, item := range items {
+			list.Children(Text(item).Key(item))
+		}
+		root, err := Column(Text("title"), list).BuildWith(ids)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return root
+	}
+
+	first := build("a", "b")
+	second := build("b", "a", "c")
+	if first.ID != second.ID || first.Children[0].ID != second.Children[0].ID {
+		t.Error("unkeyed nodes should keep their positional IDs")
+	}
+	list1, list2 := first.Children[1].Children, second.Children[1].Children
+	if list1[0].ID != list2[1].ID || list1[1].ID != list2[0].ID {
+		t.Error("keyed nodes should keep their IDs when reordered")
+	}
+	if ids.Len() != 6 {
+		t.Errorf("live ids = %d, want 6", ids.Len())
+	}
+}
+
 // ── Text projection tests ────────────────────────────────────────────
 
 func TestTextProjectionSimple(t *testing.T) {
@@ -453,6 +714,90 @@ func TestTextProjectionImageNoAlt(t *testing.T) {
 	}
 }
 
+func TestTextProjectionMediaPlaceholder(t *testing.T) {
+	data := encodeTestPNG(t, 320, 200)
+	tree := NewRenderTree()
+	SetTreeRoot(tree, &VNode{
+		ID:    1,
+		Type:  NodeBox,
+		Props: NodeProps{Direction: "column"},
+		Children: []*VNode{
+			{ID: 2, Type: NodeImage, Props: NodeProps{Data: data, Format: "png"}},
+			{ID: 3, Type: NodeCanvas, Props: NodeProps{Mode: "vector2d", Width: 300, Height: 150}},
+		},
+	})
+
+	want := fmt.Sprintf("[png 320×200 %s]\n[canvas vector2d 300×150]", formatByteSize(len(data)))
+	if got := TextProjection(tree); got != want {
+		t.Errorf("projection = %q, want %q", got, want)
+	}
+
+	opts := DefaultTextProjectionOptions()
+	opts.MediaPlaceholders = false
+	if got := TextProjectionWithOptions(tree, opts); got != "[image]\n[image]" {
+		t.Errorf("projection without placeholders = %q", got)
+	}
+
+	if got := formatByteSize(14 * 1024); got != "14KB" {
+		t.Errorf("formatByteSize = %q, want 14KB", got)
+	}
+}
+
+func TestDiffProjections(t *testing.T) {
+	changes := DiffProjections("a\nb\nc", "a\nx\nc\nd")
+	var got []string
+	for _, c := range changes {
+		got = append(got, c.String())
+	}
+	if want := "[~2 b → x +4 d]"; fmt.Sprint(got) != want {
+		t.Errorf("changes = %v, want %s", got, want)
+	}
+	if len(DiffProjections("same", "same")) != 0 {
+		t.Error("identical projections should have no changes")
+	}
+
+	build := func(last, cell string) *RenderTree {
+		tree := NewRenderTree()
+		SetTreeRoot(tree, Column(
+			Text("Hello").ID(2),
+			Row(Text(cell).ID(5), Text("b").ID(6)).ID(4),
+			Text(last).ID(3),
+		).ID(1).MustBuild())
+		return tree
+	}
+	before := build("World", "a")
+	lines := ProjectLines(before)
+	if strings.Join(lines.Lines, "\n") != TextProjection(before) || fmt.Sprint(lines.Owners) != "[2 4 3]" {
+		t.Errorf("ProjectLines = %q owners %v", lines.Lines, lines.Owners)
+	}
+
+	changes = DiffTreeProjections(before, build("Earth", "z"))
+	if len(changes) != 2 || changes[0].NodeID != 4 || changes[1].NodeID != 3 || changes[1].After != "Earth" {
+		t.Errorf("tree changes = %+v", changes)
+	}
+}
+
+func TestTextProjectionWidth(t *testing.T) {
+	tree := NewRenderTree()
+	SetTreeRoot(tree, &VNode{ID: 1, Type: NodeBox, Children: []*VNode{
+		{ID: 2, Type: NodeText, Props: NodeProps{Content: strPtr("日本語のテキスト")}},
+	}})
+	opts := DefaultTextProjectionOptions()
+	opts.MaxWidth = 6
+	if got := TextProjectionWithOptions(tree, opts); got != "日本語\nのテキ\nスト" {
+		t.Errorf("wrapped projection = %q", got)
+	}
+
+	schema := []SchemaColumn{{Name: "name"}, {Name: "city"}}
+	rows := [][]interface{}{{"山田", "東京"}, {"Bob", "Paris"}}
+	if got := projectDataRows(rows, schema, true, valueFormat{now: time.Now()}); got != "name  city\n山田  東京\nBob   Paris" {
+		t.Errorf("aligned table = %q", got)
+	}
+	if got := projectDataRows(rows, schema, false, valueFormat{now: time.Now()}); got != "name\tcity\n山田\t東京\nBob\tParis" {
+		t.Errorf("tsv table = %q", got)
+	}
+}
+
 func TestTextProjectionTextAlt(t *testing.T) {
 	tree := NewRenderTree()
 	alt := "override"
@@ -469,139 +814,5423 @@ func TestTextProjectionTextAlt(t *testing.T) {
 	}
 }
 
-// ── Viewer tests ─────────────────────────────────────────────────────
+func TestRenderHTML(t *testing.T) {
+	tree := NewRenderTree()
+	SetTreeRoot(tree, &VNode{
+		ID:    1,
+		Type:  NodeBox,
+		Props: NodeProps{Direction: "row"},
+		Children: []*VNode{
+			{ID: 2, Type: NodeText, Props: NodeProps{Content: strPtr("a < b")}},
+			{ID: 3, Type: NodeInput, Props: NodeProps{Placeholder: strPtr("name")}},
+		},
+	})
 
-func TestNewViewer(t *testing.T) {
-	v := NewViewer(HeadlessTarget{})
-	if v == nil {
-		t.Fatal("expected non-nil viewer")
+	got := RenderHTML(tree)
+	want := `<div data-vp-id="1" style="display:flex;flex-direction:row">` +
+		`<span data-vp-id="2">a &lt; b</span>` +
+		`<input data-vp-id="3" placeholder="name"></div>`
+	if got != want {
+		t.Errorf("RenderHTML =\n%s\nwant\n%s", got, want)
 	}
+}
 
-	tree := v.GetTree()
-	if tree.Root != nil {
-		t.Error("expected nil root on new viewer")
+func TestComputeLayout(t *testing.T) {
+	tree := NewRenderTree()
+	SetTreeRoot(tree, &VNode{
+		ID:    1,
+		Type:  NodeBox,
+		Props: NodeProps{Direction: "row", Padding: 8},
+		Children: []*VNode{
+			{ID: 2, Type: NodeText, Props: NodeProps{Content: strPtr("abc")}},
+			{ID: 3, Type: NodeBox, Props: NodeProps{Flex: floatPtr(1)}},
+			{ID: 4, Type: NodeText, Props: NodeProps{Content: strPtr("z"), Width: 4}},
+		},
+	})
+
+	layouts := ComputeLayout(tree, 100, 40, PixelLayoutOptions())
+	want := map[int]ComputedLayout{
+		1: {X: 0, Y: 0, Width: 100, Height: 40},
+		2: {X: 8, Y: 8, Width: 24, Height: 24},
+		3: {X: 32, Y: 8, Width: 56, Height: 24},
+		4: {X: 88, Y: 8, Width: 4, Height: 24},
+	}
+	for id, w := range want {
+		got := layouts[id]
+		if got == nil || *got != w {
+			t.Errorf("layout #%d = %+v, want %+v", id, got, w)
+		}
+		if tree.NodeIndex[id].ComputedLayout != got {
+			t.Errorf("node #%d ComputedLayout not set", id)
+		}
 	}
 }
 
-func TestViewerSetTree(t *testing.T) {
-	v := NewViewer(HeadlessTarget{})
-	v.SetTree(makeSimpleTree())
+func TestRenderGrid(t *testing.T) {
+	tree := NewRenderTree()
+	tree.Slots[5] = ColorSlot{Kind: "color", Value: "#ff0000"}
+	SetTreeRoot(tree, &VNode{
+		ID:    1,
+		Type:  NodeBox,
+		Props: NodeProps{Direction: "column"},
+		Children: []*VNode{
+			{ID: 2, Type: NodeText, Props: NodeProps{Content: strPtr("Hello"), Weight: "bold", Color: 5}},
+			{ID: 3, Type: NodeSeparator},
+			{ID: 4, Type: NodeInput, Props: NodeProps{Placeholder: strPtr("name")}},
+		},
+	})
 
-	tree := v.GetTree()
-	if tree.Root == nil {
-		t.Fatal("expected non-nil root after SetTree")
+	g := RenderGrid(tree, 8, 4, func(id int) SlotValue { return tree.Slots[id] })
+	want := "Hello\n────────\n> name"
+	if got := g.String(); got != want {
+		t.Errorf("grid =\n%s\nwant\n%s", got, want)
 	}
-	if tree.Root.ID != 1 {
-		t.Errorf("root ID = %d, want 1", tree.Root.ID)
+
+	cell := g.Cells[0][0]
+	if !cell.Style.Bold || cell.Style.FG != "#ff0000" {
+		t.Errorf("cell style = %+v, want bold red", cell.Style)
+	}
+	if !g.Cells[2][2].Style.Faint {
+		t.Error("placeholder should be faint")
+	}
+
+	// A separator far wider than the grid is drawn only where it shows.
+	wide := int(1e9)
+	tree.NodeIndex[3].Props.MinWidth = &wide
+	g = RenderGrid(tree, 8, 4, nil)
+	if got := strings.Split(g.String(), "\n")[1]; got != "────────" {
+		t.Errorf("wide separator %q", got)
 	}
 }
 
-func TestViewerGetTextProjection(t *testing.T) {
-	v := NewViewer(HeadlessTarget{})
-	v.SetTree(makeSimpleTree())
+func TestTerminalDraw(t *testing.T) {
+	var out bytes.Buffer
+	term := NewTerminal(&out, 6, 3)
+	term.Mouse = true
+	if err := term.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if !containsStr(out.String(), "\x1b[?25l") || !containsStr(out.String(), "\x1b[?1006h") {
+		t.Errorf("start should hide cursor and enable mouse: %q", out.String())
+	}
 
-	text := v.GetTextProjection()
-	if !containsStr(text, "Hello") {
-		t.Errorf("text projection missing 'Hello': %s", text)
+	g := NewGrid(6, 3)
+	g.Set(0, 0, 'a', CellStyle{})
+	g.Set(0, 2, 'c', CellStyle{})
+	out.Reset()
+	term.Draw(g)
+	if !containsStr(out.String(), "\x1b[2J") {
+		t.Error("first draw should clear the screen")
 	}
-}
 
-func TestViewerApplyPatches(t *testing.T) {
-	v := NewViewer(HeadlessTarget{})
-	v.SetTree(makeSimpleTree())
+	next := NewGrid(6, 3)
+	next.Set(0, 0, 'a', CellStyle{})
+	next.Set(0, 2, 'd', CellStyle{Bold: true})
+	out.Reset()
+	term.Draw(next)
+	got := out.String()
+	if containsStr(got, "\x1b[1;1H") || containsStr(got, "\x1b[2J") {
+		t.Errorf("unchanged rows redrawn: %q", got)
+	}
+	if !containsStr(got, "\x1b[3;1H\x1b[0;1md") {
+		t.Errorf("changed row not drawn: %q", got)
+	}
 
-	v.ApplyPatches([]PatchOp{
-		{Target: 2, Set: map[string]interface{}{"content": "Modified"}},
-	})
+	out.Reset()
+	term.Draw(next)
+	if out.Len() != 0 {
+		t.Errorf("identical frame wrote %q", out.String())
+	}
 
-	text := v.GetTextProjection()
-	if !containsStr(text, "Modified") {
-		t.Errorf("text projection missing 'Modified': %s", text)
+	term.Stop()
+	if !containsStr(out.String(), "\x1b[?25h\x1b[?1049l") {
+		t.Errorf("stop should restore the terminal: %q", out.String())
 	}
 }
 
-func TestViewerDefineSlot(t *testing.T) {
-	v := NewViewer(HeadlessTarget{})
-	v.DefineSlot(5, ColorSlot{Kind: "color", Role: "primary", Value: "#ff0000"})
+func TestTerminalDrawCells(t *testing.T) {
+	var out bytes.Buffer
+	term := NewTerminal(&out, 40, 2)
+	term.Synchronized = false // compare bare cell updates
+	g := NewGrid(40, 2)
+	for x := 0; x < 40; x++ {
+		g.Set(x, 0, '.', CellStyle{})
+	}
+	term.Draw(g)
 
-	tree := v.GetTree()
-	if len(tree.Slots) != 1 {
-		t.Errorf("slots count = %d, want 1", len(tree.Slots))
+	next := NewGrid(40, 2)
+	copy(next.Cells[0], g.Cells[0])
+	next.Set(30, 0, 'z', CellStyle{})
+	out.Reset()
+	term.Draw(next)
+	if got := out.String(); got != "\x1b[1;31Hz" {
+		t.Errorf("single cell change wrote %q", got)
+	}
+
+	last := NewGrid(40, 2)
+	copy(last.Cells[0], next.Cells[0])
+	last.Set(2, 0, 'a', CellStyle{})
+	last.Set(5, 0, 'b', CellStyle{})
+	last.Set(20, 0, 'c', CellStyle{})
+	out.Reset()
+	term.Draw(last)
+	if got := out.String(); got != "\x1b[1;3Ha..b\x1b[1;21Hc" {
+		t.Errorf("nearby changes should share a cursor move: %q", got)
 	}
 }
 
-func TestViewerMetrics(t *testing.T) {
-	v := NewViewer(HeadlessTarget{})
-	v.SetTree(makeSimpleTree())
-	v.ApplyPatches([]PatchOp{
-		{Target: 2, Set: map[string]interface{}{"content": "Changed"}},
-	})
+func TestTerminalSynchronized(t *testing.T) {
+	var out bytes.Buffer
+	term := NewTerminal(&out, 4, 2)
+	g := NewGrid(4, 2)
+	g.Set(0, 0, 'a', CellStyle{})
+	term.Draw(g)
+	got := out.String()
+	if !strings.HasPrefix(got, "\x1b[?2026h") || !strings.HasSuffix(got, "\x1b[?2026l") {
+		t.Errorf("frame not wrapped in a synchronized update: %q", got)
+	}
 
-	metrics := v.GetMetrics()
-	if metrics.MessagesProcessed != 2 {
-		t.Errorf("messagesProcessed = %d, want 2", metrics.MessagesProcessed)
+	out.Reset()
+	term.Draw(g)
+	if out.Len() != 0 {
+		t.Errorf("unchanged frame wrote %q", out.String())
 	}
-	if metrics.TreeNodeCount != 3 {
-		t.Errorf("treeNodeCount = %d, want 3", metrics.TreeNodeCount)
+
+	// A failed write leaves the screen unknown: the next frame repaints.
+	fw := &failWriter{}
+	term = NewTerminal(fw, 4, 2)
+	term.Draw(g)
+	next := NewGrid(4, 2)
+	next.Set(0, 0, 'b', CellStyle{})
+	fw.fail = true
+	if err := term.Draw(next); err == nil {
+		t.Fatal("Draw should report the write error")
 	}
-	if metrics.TreeDepth != 2 {
-		t.Errorf("treeDepth = %d, want 2", metrics.TreeDepth)
+	fw.fail = false
+	fw.buf.Reset()
+	term.Draw(next)
+	if !containsStr(fw.buf.String(), "\x1b[2J") {
+		t.Errorf("draw after a failed write should repaint: %q", fw.buf.String())
 	}
 }
 
-func TestViewerRender(t *testing.T) {
-	v := NewViewer(HeadlessTarget{})
-	v.SetTree(makeSimpleTree())
-
-	changed := v.Render()
-	if !changed {
-		t.Error("expected Render() to return true on dirty tree")
+func TestStringWidth(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		want int
+	}{
+		{"ascii", "abc", 3},
+		{"empty", "", 0},
+		{"cjk", "世界", 4},
+		{"hangul", "한국어", 6},
+		{"mixed", "ab世c", 5},
+		{"fullwidth", "Ａ", 2},
+		{"halfwidth katakana", "ｱ", 1},
+		{"combining acute", "é", 1},
+		{"stacked marks", "à́̂", 1},
+		{"zero width space", "\u200b", 0},
+		{"emoji", "👍", 2},
+		{"skin tone", "👍🏽", 2},
+		{"zwj family", "👨\u200d👩\u200d👧", 2},
+		{"flag", "🇯🇵", 2},
+		{"two flags", "🇯🇵🇫🇷", 4},
+		{"text heart", "❤", 1},
+		{"emoji heart", "❤\ufe0f", 2},
+		{"keycap", "1\ufe0f\u20e3", 2},
+		{"control", "a\tb", 2},
+	}
+	for _, tt := range tests {
+		if got := StringWidth(tt.s); got != tt.want {
+			t.Errorf("%s: StringWidth(%q) = %d, want %d", tt.name, tt.s, got, tt.want)
+		}
 	}
 
-	changed = v.Render()
-	if changed {
-		t.Error("expected Render() to return false on clean tree")
+	if got := Graphemes("é👨‍👩‍👧🇯🇵x"); len(got) != 4 {
+		t.Errorf("Graphemes = %q, want 4 clusters", got)
 	}
 }
 
-func TestViewerScreenshot(t *testing.T) {
-	v := NewViewer(HeadlessTarget{})
-	v.SetTree(makeSimpleTree())
+func TestWrapTruncateWidth(t *testing.T) {
+	wraps := []struct {
+		s     string
+		width int
+		want  []string
+	}{
+		{"hello world foo", 11, []string{"hello world", "foo"}},
+		{"hello   world", 5, []string{"hello", "world"}},
+		{"日本語のテキスト", 6, []string{"日本語", "のテキ", "スト"}},
+		{"ab 世界です", 5, []string{"ab", "世界", "です"}},
+		{"short", 10, []string{"short"}},
+	}
+	for _, tt := range wraps {
+		if got := WrapWidth(tt.s, tt.width); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("WrapWidth(%q, %d) = %q, want %q", tt.s, tt.width, got, tt.want)
+		}
+	}
 
-	ss := v.Screenshot()
-	if ss.Format != "ansi" {
-		t.Errorf("format = %s, want ansi", ss.Format)
+	truncs := []struct {
+		s, tail string
+		width   int
+		want    string
+	}{
+		{"hello world", "…", 6, "hello…"},
+		{"世界abc", "", 3, "世"},
+		{"世界abc", "…", 4, "世…"},
+		{"ééé", "", 2, "éé"},
+		{"fits", "…", 4, "fits"},
 	}
-	if ss.Data == "" {
-		t.Error("expected non-empty screenshot data")
+	for _, tt := range truncs {
+		if got := TruncateWidth(tt.s, tt.width, tt.tail); got != tt.want {
+			t.Errorf("TruncateWidth(%q, %d, %q) = %q, want %q", tt.s, tt.width, tt.tail, got, tt.want)
+		}
+	}
+	if got := PadWidth("世", 4) + "|"; got != "世  |" {
+		t.Errorf("PadWidth = %q", got)
 	}
 }
 
-func TestViewerDestroy(t *testing.T) {
-	v := NewViewer(HeadlessTarget{})
-	v.SetTree(makeSimpleTree())
-	v.Destroy()
+func TestRenderGridWide(t *testing.T) {
+	tree := NewRenderTree()
+	SetTreeRoot(tree, &VNode{ID: 1, Type: NodeBox, Children: []*VNode{
+		{ID: 2, Type: NodeText, Props: NodeProps{Content: strPtr("a世b")}},
+		{ID: 3, Type: NodeText, Props: NodeProps{Content: strPtr("é👍x")}},
+		{ID: 4, Type: NodeText, Props: NodeProps{Content: strPtr("abcd世")}},
+	}})
+	g := RenderGrid(tree, 5, 3, nil)
 
-	tree := v.GetTree()
-	if tree.Root != nil {
-		t.Error("expected nil root after Destroy")
+	if got := g.String(); got != "a世b\né👍x\nabcd" {
+		t.Errorf("grid = %q", got)
+	}
+	row := g.Cells[0]
+	if row[1].Ch != '世' || row[2].Ch != 0 || row[3].Ch != 'b' {
+		t.Errorf("wide cell layout = %+v", row[:4])
+	}
+	if c := g.Cells[1][0]; c.Ch != 'e' || c.Cluster != "é" {
+		t.Errorf("combining cluster cell = %+v", c)
 	}
-}
 
-func TestViewerProcessMessage(t *testing.T) {
+	// Overwriting half of a wide character blanks the other half.
+	g.Set(2, 0, 'z', CellStyle{})
+	if got := strings.SplitN(g.String(), "\n", 2)[0]; got != "a zb" {
+		t.Errorf("after split = %q, want %q", got, "a zb")
+	}
+
+	// A changed run never starts on a continuation cell.
+	next := NewGrid(5, 1)
+	next.SetCluster(1, 0, "世", CellStyle{})
+	prev := NewGrid(5, 1)
+	prev.SetCluster(1, 0, "界", CellStyle{})
+	prev.Cells[0][1].Ch = '世'
+	prev.Cells[0][2].Style.Bold = true
+	if runs := changedRuns(prev.Cells[0], next.Cells[0]); len(runs) != 1 || runs[0] != [2]int{1, 3} {
+		t.Errorf("runs = %v, want [[1 3]]", runs)
+	}
+}
+
+func TestBidiReorder(t *testing.T) {
+	tests := []struct {
+		line string
+		rtl  bool
+		want string
+	}{
+		{"abc", false, "abc"},
+		{"שלום", false, "םולש"},
+		{"hello שלום world", false, "hello םולש world"},
+		{"שלום world", true, "world םולש"},
+		{"עמוד 12", false, "12 דומע"},
+		{"עמוד 1,000.5", true, "1,000.5 דומע"},
+		{"(שלום)", true, "(םולש)"},
+		{"a (b) ג", true, "ג a (b)"},
+		{"שָׁל", false, "לשָׁ"},
+		{"שלום  ", false, "םולש  "},
+	}
+	for _, tt := range tests {
+		if got := BidiReorder(tt.line, tt.rtl); got != tt.want {
+			t.Errorf("BidiReorder(%q, %v) = %q, want %q", tt.line, tt.rtl, got, tt.want)
+		}
+	}
+
+	if BaseDirection("123 שלום abc") != DirRTL || BaseDirection("abc שלום") != DirLTR || BaseDirection("123") != "" {
+		t.Error("BaseDirection picks the first strong character")
+	}
+}
+
+func TestRenderGridRTL(t *testing.T) {
+	tree := NewRenderTree()
+	SetTreeRoot(tree, &VNode{ID: 1, Type: NodeBox, Children: []*VNode{
+		{ID: 2, Type: NodeText, Props: NodeProps{Content: strPtr("שלום"), TextDirection: DirRTL}},
+		{ID: 3, Type: NodeText, Props: NodeProps{Content: strPtr("שלום"), TextDirection: DirAuto}},
+		{ID: 4, Type: NodeText, Props: NodeProps{Content: strPtr("שלום")}},
+		{ID: 5, Type: NodeText, Props: NodeProps{Content: strPtr("abc"), TextDirection: DirRTL, TextAlign: "end"}},
+		{ID: 6, Type: NodeBox, Props: NodeProps{TextDirection: DirRTL}, Children: []*VNode{
+			{ID: 7, Type: NodeText, Props: NodeProps{Content: strPtr("hi"), TextAlign: "center"}},
+		}},
+	}})
+	g := RenderGrid(tree, 10, 5, nil)
+	want := "      םולש\n      םולש\nםולש\nabc\n    hi"
+	if got := g.String(); got != want {
+		t.Errorf("grid =\n%s\nwant\n%s", got, want)
+	}
+
+	// The projection keeps logical order; HTML leaves reordering to the
+	// browser.
+	if got := TextProjection(tree); !containsStr(got, "שלום") {
+		t.Errorf("projection reordered: %q", got)
+	}
+	if got := RenderHTML(tree); !containsStr(got, `dir="rtl"`) || !containsStr(got, "text-align:end") {
+		t.Errorf("html missing direction: %s", got)
+	}
+}
+
+func TestRenderGridBorder(t *testing.T) {
+	radius := 4
+	tree := NewRenderTree()
+	SetTreeRoot(tree, &VNode{ID: 1, Type: NodeBox, Children: []*VNode{
+		{ID: 2, Type: NodeBox, Props: NodeProps{Height: 3, BorderRadius: &radius,
+			Border: &BorderStyle{Width: 1, Color: "#ff0000", Style: BorderSolid}}, Children: []*VNode{
+			{ID: 3, Type: NodeText, Props: NodeProps{Content: strPtr("hi")}},
+		}},
+		{ID: 4, Type: NodeBox, Props: NodeProps{Height: 3, Border: &BorderStyle{Width: 2, Style: BorderDashed}}, Children: []*VNode{
+			{ID: 5, Type: NodeText, Props: NodeProps{Content: strPtr("abcdefgh")}},
+		}},
+		{ID: 6, Type: NodeBox, Props: NodeProps{Border: &BorderStyle{Width: 1, Style: BorderDouble}}, Children: []*VNode{
+			{ID: 7, Type: NodeText, Props: NodeProps{Content: strPtr("x")}},
+		}},
+		{ID: 8, Type: NodeBox, Props: NodeProps{Border: &BorderStyle{Width: 1, Style: BorderNone}}, Children: []*VNode{
+			{ID: 9, Type: NodeText, Props: NodeProps{Content: strPtr("plain")}},
+		}},
+	}})
+
+	g := RenderGrid(tree, 8, 10, nil)
+	want := "╭──────╮\n│hi    │\n╰──────╯\n┏┅┅┅┅┅┅┓\n┇abcdef┇\n┗┅┅┅┅┅┅┛\n╔══════╗\n║x     ║\n╚══════╝\nplain"
+	if got := g.String(); got != want {
+		t.Errorf("grid =\n%s\nwant\n%s", got, want)
+	}
+	if fg := g.Cells[0][0].Style.FG; fg != "#ff0000" {
+		t.Errorf("border color = %q, want #ff0000", fg)
+	}
+	if fg := g.Cells[1][1].Style.FG; fg != "" {
+		t.Errorf("content took the border color %q", fg)
+	}
+
+	html := RenderHTML(tree)
+	for _, css := range []string{"border:1px solid #ff0000", "border-radius:4px", "border:2px dashed"} {
+		if !containsStr(html, css) {
+			t.Errorf("html missing %q: %s", css, html)
+		}
+	}
+
+	if _, err := Column(Border(1, "wavy", "")).Build(); err == nil {
+		t.Error("invalid border style accepted")
+	}
+}
+
+func TestShadow(t *testing.T) {
+	tree := NewRenderTree()
+	SetTreeRoot(tree, &VNode{ID: 1, Type: NodeBox, Props: NodeProps{Align: "start"}, Children: []*VNode{
+		{ID: 2, Type: NodeBox, Props: NodeProps{Width: 16, Height: 16, Background: "#ffffff",
+			Shadow: &ShadowStyle{X: 8, Y: 16, Color: "#0000ff"}}},
+	}})
+
+	img := RenderImage(tree, 40, 40, nil)
+	for _, c := range []struct {
+		x, y int
+		want color.RGBA
+	}{
+		{4, 4, color.RGBA{255, 255, 255, 255}},   // the node covers its shadow
+		{20, 20, color.RGBA{0, 0, 255, 255}},     // offset shadow
+		{30, 20, color.RGBA{255, 255, 255, 255}}, // beyond it
+	} {
+		if got := img.RGBAAt(c.x, c.y); got != c.want {
+			t.Errorf("pixel (%d, %d) = %v, want %v", c.x, c.y, got, c.want)
+		}
+	}
+
+	// A blurred shadow fades out across its edge.
+	blur := 4
+	node := tree.NodeIndex[2]
+	node.Props.Shadow = &ShadowStyle{X: 8, Y: 16, Blur: blur}
+	img = RenderImage(tree, 40, 40, nil)
+	inner, edge := img.RGBAAt(12, 24), img.RGBAAt(24, 24)
+	if !(inner.R < edge.R && edge.R < 255) {
+		t.Errorf("blurred shadow: inner %v, edge %v", inner, edge)
+	}
+
+	// The grid shades the cells right of and below the node.
+	node.Props.Width, node.Props.Height = 2, 2
+	g := RenderGrid(tree, 4, 4, nil)
+	want := "\n  ░\n ░░"
+	if got := g.String(); got != want {
+		t.Errorf("grid =\n%s\nwant\n%s", got, want)
+	}
+	if got := RenderHTML(tree); !containsStr(got, "box-shadow:8px 16px 4px") {
+		t.Errorf("html missing shadow: %s", got)
+	}
+}
+
+func TestOpacity(t *testing.T) {
+	half := 0.5
+	tree := NewRenderTree()
+	SetTreeRoot(tree, &VNode{ID: 1, Type: NodeBox, Props: NodeProps{Background: "#ffffff", Align: "start"}, Children: []*VNode{
+		{ID: 2, Type: NodeBox, Props: NodeProps{Width: 20, Height: 20, Background: "#000000", Opacity: &half}, Children: []*VNode{
+			{ID: 3, Type: NodeBox, Props: NodeProps{Width: 10, Height: 10, Background: "#000000"}},
+		}},
+	}})
+
+	// The subtree is composited as a group: the inner box does not
+	// darken further where it overlaps its parent.
+	img := RenderImage(tree, 40, 40, nil)
+	for _, p := range []image.Point{{5, 5}, {15, 15}} {
+		if got := img.RGBAAt(p.X, p.Y); got.R < 126 || got.R > 129 {
+			t.Errorf("pixel %v = %v, want half gray", p, got)
+		}
+	}
+	if got := img.RGBAAt(30, 30); got.R != 255 {
+		t.Errorf("pixel outside = %v, want white", got)
+	}
+
+	faint := 0.4
+	tree = NewRenderTree()
+	SetTreeRoot(tree, &VNode{ID: 1, Type: NodeBox, Props: NodeProps{Background: "#000000"}, Children: []*VNode{
+		{ID: 2, Type: NodeText, Props: NodeProps{Content: strPtr("a"), Color: "#ffffff", Opacity: &half}},
+		{ID: 3, Type: NodeText, Props: NodeProps{Content: strPtr("b"), Opacity: &faint}},
+		{ID: 4, Type: NodeText, Props: NodeProps{Content: strPtr("c"), Color: "#ffffff"}},
+	}})
+	g := RenderGrid(tree, 4, 3, nil)
+	if got := g.Cells[0][0].Style; got.FG != "#808080" || got.BG != "#000000" {
+		t.Errorf("blended style = %+v", got)
+	}
+	if got := g.Cells[1][0].Style; !got.Faint {
+		t.Errorf("unknown colors not faint: %+v", got)
+	}
+	if got := g.Cells[2][0].Style; got.FG != "#ffffff" {
+		t.Errorf("opaque sibling faded: %+v", got)
+	}
+}
+
+func TestTextOverflow(t *testing.T) {
+	tree := NewRenderTree()
+	SetTreeRoot(tree, &VNode{ID: 1, Type: NodeBox, Children: []*VNode{
+		{ID: 2, Type: NodeText, Props: NodeProps{Content: strPtr("hello wide world"), TextOverflow: OverflowWrap}},
+		{ID: 3, Type: NodeText, Props: NodeProps{Content: strPtr("abcdefghijklmno"), TextOverflow: OverflowEllipsis}},
+		{ID: 4, Type: NodeText, Props: NodeProps{Content: strPtr("abcdefghijklmno"), TextOverflow: OverflowClip}},
+		{ID: 5, Type: NodeText, Props: NodeProps{Content: strPtr("one\ntwo"), TextOverflow: OverflowEllipsis}},
+	}})
+
+	g := RenderGrid(tree, 10, 6, nil)
+	want := "hello wide\nworld\nabcdefghi…\nabcdefghij\none…"
+	if got := g.String(); got != want {
+		t.Errorf("grid =\n%s\nwant\n%s", got, want)
+	}
+
+	opts := DefaultTextProjectionOptions()
+	opts.MaxWidth = 10
+	if got := TextProjectionWithOptions(tree, opts); got != want {
+		t.Errorf("projection =\n%s\nwant\n%s", got, want)
+	}
+	// Without a width only the ellipsis mode's single line shows.
+	if got := TextProjection(tree); got != "hello wide world\nabcdefghijklmno\nabcdefghijklmno\none…" {
+		t.Errorf("unbounded projection = %q", got)
+	}
+
+	if got := RenderHTML(tree); !containsStr(got, "text-overflow:ellipsis") || !containsStr(got, "white-space:pre-wrap") {
+		t.Errorf("html missing overflow styles: %s", got)
+	}
+}
+
+func TestRenderGridImage(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	img.Set(0, 0, color.RGBA{255, 0, 0, 255})
+	img.Set(1, 0, color.RGBA{255, 0, 0, 255})
+	img.Set(0, 1, color.RGBA{0, 0, 255, 255})
+	img.Set(1, 1, color.RGBA{0, 0, 255, 255})
+	var data bytes.Buffer
+	if err := png.Encode(&data, img); err != nil {
+		t.Fatal(err)
+	}
+
+	tree := NewRenderTree()
+	SetTreeRoot(tree, &VNode{
+		ID:    1,
+		Type:  NodeImage,
+		Props: NodeProps{Data: data.Bytes(), Format: "png", Width: 2, Height: 1},
+	})
+	g := RenderGrid(tree, 4, 2, nil)
+
+	cell := g.Cells[0][0]
+	if cell.Ch != '▀' || cell.Style.FG != "#ff0000" || cell.Style.BG != "#0000ff" {
+		t.Errorf("image cell = %+v, want red-over-blue half block", cell)
+	}
+	if len(g.Images) != 1 || g.Images[0].Width != 2 || g.Images[0].Height != 1 {
+		t.Fatalf("images = %+v, want one 2x1 placement", g.Images)
+	}
+
+	tests := []struct {
+		protocol string
+		prefix   string
+	}{
+		{ImageKitty, "\x1b_Ga=T,f=100"},
+		{ImageITerm2, "\x1b]1337;File=inline=1"},
+		{ImageSixel, "\x1bPq"},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		term := NewTerminal(&out, 4, 2)
+		term.Graphics = tt.protocol
+		term.Draw(g)
+		if !containsStr(out.String(), tt.prefix) {
+			t.Errorf("%s: output missing %q", tt.protocol, tt.prefix)
+		}
+
+		out.Reset()
+		term.Draw(g)
+		if out.Len() != 0 {
+			t.Errorf("%s: unchanged image re-sent", tt.protocol)
+		}
+	}
+}
+
+func TestRasterizerDamage(t *testing.T) {
+	tree := NewRenderTree()
+	SetTreeRoot(tree, makeSimpleTree())
+	r := NewRasterizer()
+
+	res := r.Render(tree, 200, 100, nil)
+	bounds := image.Rect(0, 0, 200, 100)
+	if !res.Full || len(res.Damage) != 1 || res.Damage[0] != bounds {
+		t.Fatalf("first render = full %v damage %v, want the whole frame", res.Full, res.Damage)
+	}
+	if res = r.Render(tree, 200, 100, nil); res.Full || len(res.Damage) != 0 {
+		t.Fatalf("unchanged render = full %v damage %v, want none", res.Full, res.Damage)
+	}
+
+	ApplyPatches(tree, []PatchOp{{Target: 3, Set: map[string]interface{}{"content": "Earth", "color": "red"}}})
+	res = r.Render(tree, 200, 100, nil)
+	if res.Full || len(res.Damage) != 1 {
+		t.Fatalf("patched render = full %v damage %v, want one region", res.Full, res.Damage)
+	}
+	if d := res.Damage[0]; d.Min.Y < 20 || d.Max.Y > 40 {
+		t.Errorf("damage = %v, want only the second line (y 20-40)", d)
+	}
+
+	// The incremental frame matches a fresh full render.
+	if want := RenderImage(tree, 200, 100, nil); !bytes.Equal(res.Image.Pix, want.Pix) {
+		t.Error("incremental frame differs from a full render")
+	}
+	red := false
+	for y := 20; y < 40 && !red; y++ {
+		for x := 0; x < 40; x++ {
+			if c := res.Image.RGBAAt(x, y); c.R > 200 && c.G < 50 {
+				red = true
+				break
+			}
+		}
+	}
+	if !red {
+		t.Error("patched text not drawn in red")
+	}
+
+	// A resize redraws everything.
+	if res = r.Render(tree, 120, 100, nil); !res.Full || res.Damage[0] != image.Rect(0, 0, 120, 100) {
+		t.Errorf("resized render = full %v damage %v, want the whole frame", res.Full, res.Damage)
+	}
+}
+
+func TestRasterBorderRadius(t *testing.T) {
+	radius := 10
+	tree := NewRenderTree()
+	SetTreeRoot(tree, &VNode{ID: 1, Type: NodeBox, Props: NodeProps{Direction: "row"}, Children: []*VNode{
+		{ID: 2, Type: NodeBox, Props: NodeProps{Width: 40, Height: 40, Background: "#000000", BorderRadius: &radius}, Children: []*VNode{
+			{ID: 3, Type: NodeBox, Props: NodeProps{Height: 20, Background: "#ff0000"}},
+		}},
+	}})
+	img := RenderImage(tree, 60, 60, nil)
+
+	white, black, red := color.RGBA{255, 255, 255, 255}, color.RGBA{0, 0, 0, 255}, color.RGBA{255, 0, 0, 255}
+	for _, c := range []struct {
+		x, y int
+		want color.RGBA
+	}{
+		{0, 0, white},   // outside the corner, child clipped
+		{39, 39, white}, // outside the bottom-right corner
+		{20, 10, red},   // the child inside
+		{0, 25, black},  // straight left edge
+		{20, 39, black}, // straight bottom edge
+	} {
+		if got := img.RGBAAt(c.x, c.y); got != c.want {
+			t.Errorf("pixel (%d, %d) = %v, want %v", c.x, c.y, got, c.want)
+		}
+	}
+	// The curve is anti-aliased.
+	partial := false
+	for y := 30; y < 40; y++ {
+		for x := 30; x < 40; x++ {
+			if g := img.RGBAAt(x, y).G; g > 0 && g < 255 {
+				partial = true
+			}
+		}
+	}
+	if !partial {
+		t.Error("corner has no anti-aliased pixels")
+	}
+}
+
+func TestRasterFonts(t *testing.T) {
+	size := 28
+	tree := NewRenderTree()
+	SetTreeRoot(tree, &VNode{ID: 1, Type: NodeBox, Props: NodeProps{Direction: "row"}, Children: []*VNode{
+		{ID: 2, Type: NodeText, Props: NodeProps{Content: strPtr("H"), Size: &size}},
+	}})
+	l := &layoutEngine{opts: PixelLayoutOptions()}
+	if w, h := l.measure(tree.NodeIndex[2], true, 100, 100), l.measure(tree.NodeIndex[2], false, 100, 100); w != 16 || h != 40 {
+		t.Errorf("28px text = %gx%g, want 16x40", w, h)
+	}
+
+	ink := func(img *image.RGBA, area image.Rectangle) int {
+		n := 0
+		for y := area.Min.Y; y < area.Max.Y; y++ {
+			for x := area.Min.X; x < area.Max.X; x++ {
+				if img.RGBAAt(x, y).R < 128 {
+					n++
+				}
+			}
+		}
+		return n
+	}
+	// The bitmap font is magnified to the larger size.
+	if n := ink(RenderImage(tree, 100, 100, nil), image.Rect(0, 20, 16, 40)); n == 0 {
+		t.Error("28px bitmap text not magnified")
+	}
+
+	// Italic and bold are synthesized for the bitmap font.
+	text := tree.NodeIndex[2]
+	text.Props.Size = nil
+	plain := RenderImage(tree, 40, 20, nil)
+	text.Props.Weight = "bold"
+	bold := RenderImage(tree, 40, 20, nil)
+	if ink(bold, bold.Bounds()) <= ink(plain, plain.Bounds()) {
+		t.Error("bold not heavier than regular")
+	}
+	text.Props.Weight = ""
+	italic := true
+	text.Props.Italic = &italic
+	if img := RenderImage(tree, 40, 20, nil); reflect.DeepEqual(img.Pix, plain.Pix) {
+		t.Error("italic drawn upright")
+	}
+
+	// An OpenType family replaces the bitmap font.
+	r := NewRasterizer()
+	r.Fonts = GoMonoFonts()
+	res := r.Render(tree, 40, 20, nil)
+	if n := ink(res.Image, image.Rect(0, 0, 8, 20)); n == 0 || reflect.DeepEqual(res.Image.Pix, plain.Pix) {
+		t.Errorf("Go Mono text: %d dark pixels", n)
+	}
+
+	if _, err := ParseFontFamily(nil, nil, nil, nil); err == nil {
+		t.Error("family without a regular font accepted")
+	}
+	if _, err := ParseFontFamily([]byte("not a font"), nil, nil, nil); err == nil {
+		t.Error("garbage font accepted")
+	}
+}
+
+func TestViewerFramebufferTarget(t *testing.T) {
+	target := FramebufferTarget{}
+	v := NewViewer(target)
+	v.Init(EnvInfo{DisplayWidth: 200, DisplayHeight: 100})
+	if _, ok := v.TakeRenderResult(target); ok {
+		t.Fatal("result before first render")
+	}
+
+	v.SetTree(makeSimpleTree())
+	v.Render()
+	res, ok := v.TakeRenderResult(target)
+	if !ok || !res.Full || res.Image.Bounds() != image.Rect(0, 0, 200, 100) {
+		t.Fatalf("first result = %v full %v, want a full 200x100 frame", ok, res.Full)
+	}
+	if res, _ = v.TakeRenderResult(target); res.Full || len(res.Damage) != 0 {
+		t.Fatalf("damage not cleared: %v", res.Damage)
+	}
+
+	// Damage accumulates across renders until taken.
+	v.ApplyPatches([]PatchOp{{Target: 2, Set: map[string]interface{}{"content": "Hi"}}})
+	v.Render()
+	v.ApplyPatches([]PatchOp{{Target: 3, Set: map[string]interface{}{"content": "There"}}})
+	v.Render()
+	res, _ = v.TakeRenderResult(target)
+	if res.Full || len(res.Damage) != 1 || res.Damage[0].Min.Y != 0 || res.Damage[0].Max.Y < 40 {
+		t.Errorf("accumulated damage = %v, want both lines", res.Damage)
+	}
+}
+
+func TestFramebufferBuffer(t *testing.T) {
+	// BGRA rows padded to 100 bytes.
+	fb := &Framebuffer{Pix: make([]byte, 100*10), Width: 20, Height: 10, Stride: 100, Format: PixelBGRA}
+	target := FramebufferTarget{Buffer: fb}
+	v := NewViewer(target)
+	tree := makeSimpleTree()
+	tree.Props.Background = "#336699"
+	v.SetTree(tree)
+	v.Render()
+
+	if got := fb.Pix[2*100+10*4 : 2*100+11*4]; !reflect.DeepEqual(got, []byte{0x99, 0x66, 0x33, 0xff}) {
+		t.Errorf("pixel (10, 2) = % x, want BGRA of #336699", got)
+	}
+	if fb.Pix[2*100+80] != 0 {
+		t.Error("row padding was written")
+	}
+	if res, ok := v.TakeRenderResult(target); !ok || res.Image.Bounds() != image.Rect(0, 0, 20, 10) {
+		t.Errorf("frame not sized to the buffer: %v", res.Image.Bounds())
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	img.SetRGBA(1, 0, color.RGBA{255, 0, 0, 255})
+	small := NewFramebuffer(2, 1, PixelRGB565)
+	small.Copy(img, []image.Rectangle{img.Bounds()})
+	if want := []byte{0, 0, 0x00, 0xf8}; !reflect.DeepEqual(small.Pix, want) {
+		t.Errorf("rgb565 = % x, want % x", small.Pix, want)
+	}
+
+	bad := &Framebuffer{Pix: make([]byte, 10), Width: 4, Height: 2, Stride: 8, Format: PixelRGBA}
+	if err := bad.Validate(); err == nil {
+		t.Error("short stride accepted")
+	}
+	var reported error
+	v = NewViewer(FramebufferTarget{Buffer: bad})
+	v.OnError(func(err error) { reported = err })
+	v.SetTree(makeSimpleTree())
+	v.Render()
+	if reported == nil {
+		t.Error("invalid buffer not reported")
+	}
+}
+
+func TestTextureTarget(t *testing.T) {
+	gpu := &fakeGPU{textures: make(map[TextureHandle]*image.RGBA)}
+	target := TextureTarget{Backend: gpu}
+	v := NewViewer(target)
+	v.Init(EnvInfo{DisplayWidth: 100, DisplayHeight: 50})
+	v.SetTree(makeSimpleTree())
+	v.Render()
+
+	tex, w, h, ok := v.Texture(target)
+	if !ok || w != 100 || h != 50 {
+		t.Fatalf("Texture = %v %dx%d %v, want a 100x50 texture", tex, w, h, ok)
+	}
+	res, _ := v.TakeRenderResult(target)
+	if !reflect.DeepEqual(gpu.textures[tex].Pix, res.Image.Pix) {
+		t.Error("texture differs from the frame")
+	}
+
+	// Patches upload only damaged regions.
+	gpu.uploaded = nil
+	v.ApplyPatches([]PatchOp{{Target: 3, Set: map[string]interface{}{"content": "There"}}})
+	v.Render()
+	if len(gpu.uploaded) != 1 || gpu.uploaded[0].Min.Y < 20 {
+		t.Errorf("uploaded %v, want the second line only", gpu.uploaded)
+	}
+
+	// A resize replaces the texture.
+	v.Resize(120, 50)
+	if next, w, _, _ := v.Texture(target); next == tex || w != 120 || len(gpu.textures) != 1 {
+		t.Errorf("after resize: texture %v width %d, %d live", next, w, len(gpu.textures))
+	}
+	v.Destroy()
+	if len(gpu.textures) != 0 {
+		t.Errorf("%d textures leaked", len(gpu.textures))
+	}
+
+	// A backend that cannot be compared is refused, not a panic.
+	var reported error
+	v = NewViewer(HeadlessTarget{})
+	v.OnError(func(err error) { reported = err })
+	bad := TextureTarget{Backend: sliceGPU{fakeGPU: gpu}}
+	v.AddTarget(bad)
+	if !errors.Is(reported, ErrTargetNotComparable) || len(v.Targets()) != 1 {
+		t.Errorf("AddTarget reported %v with %d targets", reported, len(v.Targets()))
+	}
+	if v.IsDirty(bad) || v.RemoveTarget(bad) {
+		t.Error("non-comparable target found")
+	}
+}
+
+// sliceGPU is a GPUBackend value that cannot be compared with ==.
+type sliceGPU struct {
+	*fakeGPU
+	tags []string
+}
+
+func TestKineticScroll(t *testing.T) {
+	scrollTree := func() *VNode {
+		root := &VNode{ID: 1, Type: NodeScroll, Props: NodeProps{Height: 100}}
+		for i := 0; i < 4; i++ {
+			root.Children = append(root.Children, &VNode{ID: 2 + i, Type: NodeBox, Props: NodeProps{Height: 60}})
+		}
+		return root
+	}
+	var tops []int
+	record := func(msg ProtocolMessage) {
+		if msg.Type == MsgInput && msg.Event.Kind == "scroll" {
+			tops = append(tops, *msg.Event.ScrollTop)
+		}
+	}
+
+	// Headless viewers jump at once.
+	v := NewViewer(HeadlessTarget{})
+	v.SetTree(scrollTree())
+	v.OnMessage(record)
+	v.Scroll(1, 30)
+	if !reflect.DeepEqual(tops, []int{30}) || v.Tick() {
+		t.Fatalf("headless scroll events %v, want [30] and no animation", tops)
+	}
+
+	// With momentum, ticks coast to the same place through intermediate
+	// positions.
+	clock := &ManualClock{T: time.Unix(0, 0)}
+	v = NewViewer(AnsiTarget{})
+	v.SetClock(clock)
+	v.SetTree(scrollTree())
+	tops = nil
+	v.OnMessage(record)
+	v.Scroll(1, 30)
+	v.Scroll(1, 20)
+	frames := 0
+	for running := true; running; frames++ {
+		clock.Advance(16 * time.Millisecond)
+		running = v.Tick()
+	}
+	if len(tops) < 3 || tops[len(tops)-1] != 50 || frames > 60 {
+		t.Fatalf("momentum scroll events %v over %d frames, want several ending at 50", tops, frames)
+	}
+	for i := 1; i < len(tops); i++ {
+		if tops[i] <= tops[i-1] {
+			t.Fatalf("scroll went backwards: %v", tops)
+		}
+	}
+	if got := *v.GetTree().NodeIndex[1].Props.ScrollTop; got != 50 {
+		t.Errorf("scrollTop = %d, want 50", got)
+	}
+
+	// Flings stop at the end of the content, and turning momentum off
+	// finishes the animation.
+	v.Scroll(1, 1000)
+	clock.Advance(time.Second)
+	if v.Tick() || tops[len(tops)-1] != 140 {
+		t.Errorf("fling ended at %d, want 140", tops[len(tops)-1])
+	}
+	v.Scroll(1, -40)
+	v.SetScrollConfig(ScrollConfig{Momentum: false})
+	if v.Tick() || tops[len(tops)-1] != 100 {
+		t.Errorf("momentum off ended at %d, want 100", tops[len(tops)-1])
+	}
+	if v.Scroll(2, 10) {
+		t.Error("Scroll accepted a box")
+	}
+}
+
+func TestScrollIntoView(t *testing.T) {
+	v := NewViewer(HeadlessTarget{})
+	root := &VNode{ID: 1, Type: NodeBox, Children: []*VNode{
+		{ID: 2, Type: NodeScroll, Props: NodeProps{Height: 100, Padding: 10}},
+		{ID: 9, Type: NodeText, Props: NodeProps{Content: strPtr("outside")}},
+	}}
+	for i := 0; i < 5; i++ {
+		root.Children[0].Children = append(root.Children[0].Children,
+			&VNode{ID: 3 + i, Type: NodeBox, Props: NodeProps{Height: 40}, Children: []*VNode{
+				{ID: 10 + i, Type: NodeText, Props: NodeProps{Content: strPtr("row")}},
+			}})
+	}
+	v.SetTree(root)
+	var events []InputEvent
+	v.OnMessage(func(msg ProtocolMessage) {
+		if msg.Type == MsgInput {
+			events = append(events, *msg.Event)
+		}
+	})
+	scrollTop := func() int { return *v.GetTree().NodeIndex[2].Props.ScrollTop }
+
+	// The viewport is 80px tall inside the padding; the fourth row spans
+	// 120–160 of the content, so it is revealed at the bottom edge.
+	if !v.ScrollIntoView(6) || scrollTop() != 80 {
+		t.Fatalf("scrollTop = %d, want 80", scrollTop())
+	}
+	if len(events) != 1 || *events[0].Target != 2 || events[0].Kind != "scroll" || *events[0].ScrollTop != 80 || *events[0].ScrollLeft != 0 {
+		t.Fatalf("events %+v, want one scroll to 80", events)
+	}
+	// Visible nodes need no scrolling; nodes above align to the top, and
+	// descendants of items count.
+	v.ScrollIntoView(5)
+	if scrollTop() != 80 || len(events) != 1 {
+		t.Errorf("visible node scrolled to %d", scrollTop())
+	}
+	v.ScrollIntoView(10)
+	if scrollTop() != 0 {
+		t.Errorf("scrollTop = %d, want 0", scrollTop())
+	}
+	// The last row stops at the end of the content.
+	v.ScrollIntoView(7)
+	if scrollTop() != 120 {
+		t.Errorf("scrollTop = %d, want 120", scrollTop())
+	}
+	if v.ScrollIntoView(9) || v.ScrollIntoView(99) {
+		t.Error("ScrollIntoView succeeded without a scroll ancestor")
+	}
+}
+
+func TestScrollbar(t *testing.T) {
+	rows := func() []*VNode {
+		var rows []*VNode
+		for i := 0; i < 8; i++ {
+			rows = append(rows, &VNode{ID: 2 + i, Type: NodeText, Props: NodeProps{Content: strPtr(fmt.Sprintf("row%d", i))}})
+		}
+		return rows
+	}
+	tree := NewRenderTree()
+	top := 4
+	SetTreeRoot(tree, &VNode{ID: 1, Type: NodeScroll, Props: NodeProps{Height: 4, ScrollTop: &top}, Children: rows()})
+	g := RenderGrid(tree, 6, 4, nil)
+	if want := "row4 │\nrow5 │\nrow6 █\nrow7 █"; g.String() != want {
+		t.Errorf("grid =\n%s\nwant\n%s", g.String(), want)
+	}
+
+	// Pressing the track jumps the thumb there and drags it.
+	v := NewViewer(HeadlessTarget{})
+	v.Init(EnvInfo{DisplayWidth: 100, DisplayHeight: 100})
+	root := &VNode{ID: 1, Type: NodeScroll, Props: NodeProps{Height: 100}}
+	for i := 0; i < 4; i++ {
+		root.Children = append(root.Children, &VNode{ID: 2 + i, Type: NodeBox, Props: NodeProps{Height: 60}})
+	}
+	v.SetTree(root)
+	scrollTop := func() int { return *v.GetTree().NodeIndex[1].Props.ScrollTop }
+	if v.Pointer(PointerEvent{X: 10, Y: 90, Action: PointerDown}) {
+		t.Error("press on content consumed")
+	}
+	if !v.Pointer(PointerEvent{X: 97, Y: 90, Action: PointerDown}) || scrollTop() != 140 {
+		t.Fatalf("track press scrolled to %d, want 140", scrollTop())
+	}
+	v.Pointer(PointerEvent{X: 97, Y: 50, Action: PointerMove})
+	if !v.Pointer(PointerEvent{X: 0, Y: 50, Action: PointerUp}) || scrollTop() != 70 {
+		t.Errorf("drag scrolled to %d, want 70", scrollTop())
+	}
+	if v.Pointer(PointerEvent{X: 97, Y: 0, Action: PointerMove}) || scrollTop() != 70 {
+		t.Error("moved after release")
+	}
+	// Hit testing sees the scrolled content.
+	if id, ok := v.HitTest(10, 10); !ok || id != 3 {
+		t.Errorf("HitTest = %d, %v; want 3", id, ok)
+	}
+
+	img := RenderImage(v.GetTree(), 100, 100, nil)
+	track, thumb := img.RGBAAt(97, 10), img.RGBAAt(97, 50)
+	if track == rasterBackground || thumb == track {
+		t.Errorf("scrollbar track %v, thumb %v", track, thumb)
+	}
+}
+
+func TestStickyHeader(t *testing.T) {
+	table := func(height int) *VNode {
+		sticky := true
+		root := &VNode{ID: 1, Type: NodeScroll, Props: NodeProps{Height: height}, Children: []*VNode{
+			{ID: 2, Type: NodeText, Props: NodeProps{Content: strPtr("Name"), Sticky: &sticky}},
+		}}
+		for i := 0; i < 6; i++ {
+			root.Children = append(root.Children, &VNode{ID: 3 + i, Type: NodeText, Props: NodeProps{Content: strPtr(fmt.Sprintf("r%d", i))}})
+		}
+		return root
+	}
+	tree := NewRenderTree()
+	SetTreeRoot(tree, table(3))
+	top := 2
+	tree.NodeIndex[1].Props.ScrollTop = &top
+	g := RenderGrid(tree, 6, 3, nil)
+	if want := "Name │\nr2   █\nr3   │"; g.String() != want {
+		t.Errorf("grid =\n%s\nwant\n%s", g.String(), want)
+	}
+
+	v := NewViewer(HeadlessTarget{})
+	v.Init(EnvInfo{DisplayWidth: 100, DisplayHeight: 100})
+	v.SetTree(table(60))
+	v.ApplyPatches([]PatchOp{{Target: 1, Set: map[string]interface{}{"scrollTop": 40}}})
+	opts := DefaultTextProjectionOptions()
+	opts.FullScrollContent = false
+	if got := v.GetTextProjectionWithOptions(opts); got != "Name\nr1\nr2\nr3" {
+		t.Errorf("clipped projection = %q", got)
+	}
+	if got := v.GetTextProjection(); !strings.Contains(got, "r5") {
+		t.Errorf("full projection = %q", got)
+	}
+	if id, ok := v.HitTest(5, 5); !ok || id != 2 {
+		t.Errorf("HitTest = %d, %v; want the header", id, ok)
+	}
+	if html := RenderHTML(v.GetTree()); !strings.Contains(html, "position:sticky") {
+		t.Errorf("html missing sticky: %s", html)
+	}
+}
+
+func TestNestedScroll(t *testing.T) {
+	nested := func(outer, inner, row, other int) *VNode {
+		in := &VNode{ID: 3, Type: NodeScroll, Props: NodeProps{Height: inner}}
+		for i := 0; i < 4; i++ {
+			in.Children = append(in.Children, &VNode{ID: 4 + i, Type: NodeText, Props: NodeProps{Height: row, Content: strPtr(fmt.Sprintf("r%d", i))}})
+		}
+		return &VNode{ID: 1, Type: NodeScroll, Props: NodeProps{Height: outer}, Children: []*VNode{
+			{ID: 2, Type: NodeText, Props: NodeProps{Height: other, Content: strPtr("a")}},
+			in,
+			{ID: 8, Type: NodeText, Props: NodeProps{Height: other, Content: strPtr("b")}},
+		}}
+	}
+
+	// Each container clips its content; the outer scrollbar is drawn
+	// over the inner one.
+	tree := NewRenderTree()
+	SetTreeRoot(tree, nested(3, 2, 1, 1))
+	one := 1
+	tree.NodeIndex[1].Props.ScrollTop = &one
+	tree.NodeIndex[3].Props.ScrollTop = &one
+	if got, want := RenderGrid(tree, 4, 3, nil).String(), "r1 │\nr2 █\nb  █"; got != want {
+		t.Errorf("grid =\n%s\nwant\n%s", got, want)
+	}
+
+	v := NewViewer(HeadlessTarget{})
+	v.Init(EnvInfo{DisplayWidth: 100, DisplayHeight: 100})
+	v.SetTree(nested(100, 80, 40, 60))
+	tops := func() [2]int {
+		var out [2]int
+		for i, id := range []int{1, 3} {
+			if p := v.GetTree().NodeIndex[id].Props.ScrollTop; p != nil {
+				out[i] = *p
+			}
+		}
+		return out
+	}
+
+	// Revealing the last inner row scrolls the inner container to it,
+	// then the outer one to the inner viewport's bottom.
+	if !v.ScrollIntoView(7) || tops() != [2]int{40, 80} {
+		t.Fatalf("ScrollIntoView: scrollTops %v, want [40 80]", tops())
+	}
+	// The inner container is at its end, so the wheel moves the outer
+	// one; scrolling back up, the inner one takes it all.
+	if !v.Pointer(PointerEvent{X: 10, Y: 50, Action: PointerWheel, DeltaY: 30}) || tops() != [2]int{70, 80} {
+		t.Errorf("wheel down: scrollTops %v, want [70 80]", tops())
+	}
+	v.Pointer(PointerEvent{X: 10, Y: 50, Action: PointerWheel, DeltaY: -30})
+	if tops() != [2]int{70, 50} {
+		t.Errorf("wheel up: scrollTops %v, want [70 50]", tops())
+	}
+	// A wheel over the outer content only moves it.
+	v.Pointer(PointerEvent{X: 10, Y: 95, Action: PointerWheel, DeltaY: 500})
+	if tops() != [2]int{100, 50} {
+		t.Errorf("wheel past the end: scrollTops %v, want [100 50]", tops())
+	}
+	// Hit testing applies both offsets: y=5 is 105 in the outer content,
+	// 45 into the inner viewport, and 95 in the inner content.
+	if id, _ := v.HitTest(10, 5); id != 6 {
+		t.Errorf("HitTest = %d, want 6", id)
+	}
+}
+
+func TestActiveState(t *testing.T) {
+	button := func(height int, style *int) *VNode {
+		return &VNode{ID: 1, Type: NodeBox, Children: []*VNode{
+			{ID: 2, Type: NodeBox, Props: NodeProps{Height: height, Interactive: "clickable", Style: style}, Children: []*VNode{
+				{ID: 3, Type: NodeText, Props: NodeProps{Content: strPtr("OK")}},
+			}},
+			{ID: 4, Type: NodeText, Props: NodeProps{Content: strPtr("other")}},
+		}}
+	}
+
+	// Pressed nodes without an active style are inverted, background
+	// and text alike.
+	tree := NewRenderTree()
+	SetTreeRoot(tree, button(1, nil))
+	active := 2
+	tree.Active = &active
+	g := RenderGrid(tree, 4, 2, nil)
+	if !g.Cells[0][0].Style.Inverse || !g.Cells[0][3].Style.Inverse || g.Cells[1][0].Style.Inverse {
+		t.Errorf("active row styles %+v, %+v; next row %+v", g.Cells[0][0].Style, g.Cells[0][3].Style, g.Cells[1][0].Style)
+	}
+	if !strings.Contains(g.ANSI(), "\x1b[0;7m") {
+		t.Errorf("no reverse video in %q", g.ANSI())
+	}
+	SetTreeRoot(tree, button(20, nil))
+	img := RenderImage(tree, 40, 40, nil)
+	if img.RGBAAt(30, 5) != rasterForeground || img.RGBAAt(30, 30) != rasterBackground {
+		t.Errorf("raster active fill %v, below %v", img.RGBAAt(30, 5), img.RGBAAt(30, 30))
+	}
+
+	// A style's active props replace the inversion.
+	slot := 9
+	tree.Slots[slot] = StyleSlot{Kind: "style", Active: map[string]interface{}{"background": "#ff0000"}}
+	SetTreeRoot(tree, button(1, &slot))
+	g = RenderGrid(tree, 4, 2, func(id int) SlotValue { return tree.Slots[id] })
+	if st := g.Cells[0][3].Style; st.BG != "#ff0000" || st.Inverse {
+		t.Errorf("active style = %+v", st)
+	}
+
+	// Pointer presses set the active node and releasing over it clicks.
+	v := NewViewer(HeadlessTarget{})
+	v.Init(EnvInfo{DisplayWidth: 100, DisplayHeight: 100})
+	v.SetTree(button(20, nil))
+	var clicks []int
+	v.OnMessage(func(msg ProtocolMessage) {
+		if msg.Type == MsgInput && msg.Event.Kind == "click" {
+			clicks = append(clicks, *msg.Event.Target)
+		}
+	})
+	if !v.Pointer(PointerEvent{X: 5, Y: 5, Action: PointerDown}) || v.GetTree().Active == nil || *v.GetTree().Active != 2 {
+		t.Fatal("press on the text did not activate the button")
+	}
+	v.Pointer(PointerEvent{X: 50, Y: 10, Action: PointerUp})
+	if v.GetTree().Active != nil || !reflect.DeepEqual(clicks, []int{2}) {
+		t.Errorf("after release: active %v, clicks %v", v.GetTree().Active, clicks)
+	}
+	v.Pointer(PointerEvent{X: 5, Y: 5, Action: PointerDown})
+	v.Pointer(PointerEvent{X: 5, Y: 50, Action: PointerUp})
+	if len(clicks) != 1 {
+		t.Errorf("release elsewhere clicked: %v", clicks)
+	}
+	if v.Pointer(PointerEvent{X: 5, Y: 30, Action: PointerDown}) {
+		t.Error("press on plain text consumed")
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	tabIndex := 1
+	tree := func(disabled bool) *VNode {
+		return &VNode{ID: 1, Type: NodeBox, Children: []*VNode{
+			{ID: 2, Type: NodeBox, Props: NodeProps{Height: 20, Interactive: "clickable"}, Children: []*VNode{
+				{ID: 3, Type: NodeText, Props: NodeProps{Content: strPtr("OK")}},
+			}},
+			{ID: 4, Type: NodeBox, Props: NodeProps{Disabled: &disabled}, Children: []*VNode{
+				{ID: 5, Type: NodeInput, Props: NodeProps{Height: 20, Value: strPtr("x")}},
+				{ID: 6, Type: NodeBox, Props: NodeProps{Height: 20, Interactive: "clickable"}},
+			}},
+			{ID: 7, Type: NodeBox, Props: NodeProps{Height: 20, Interactive: "focusable", TabIndex: &tabIndex}},
+		}}
+	}
+
+	v := NewViewer(HeadlessTarget{})
+	v.Init(EnvInfo{DisplayWidth: 100, DisplayHeight: 100})
+	v.SetTree(tree(true))
+	var events []string
+	v.OnMessage(func(msg ProtocolMessage) {
+		if msg.Type == MsgInput && msg.Event.Target != nil {
+			events = append(events, fmt.Sprintf("%s %d", msg.Event.Kind, *msg.Event.Target))
+		}
+	})
+
+	// Tab order puts the positive tabIndex first and skips the disabled
+	// subtree.
+	var order []int
+	for i := 0; i < 3; i++ {
+		v.Key("Tab")
+		id, _ := v.Focused()
+		order = append(order, id)
+	}
+	if !reflect.DeepEqual(order, []int{7, 2, 7}) {
+		t.Errorf("tab order %v", order)
+	}
+	v.Key("shift+tab")
+	if id, _ := v.Focused(); id != 2 {
+		t.Errorf("shift+tab focused %d", id)
+	}
+	if v.Focus(5) || v.Focus(3) {
+		t.Error("focused a disabled or unfocusable node")
+	}
+
+	// Input aimed into the disabled subtree is dropped.
+	events = nil
+	id := 6
+	v.SendInput(InputEvent{Target: &id, Kind: "click"})
+	id = 5
+	v.SendInput(InputEvent{Target: &id, Kind: "value_change", Value: "y"})
+	if v.Pointer(PointerEvent{X: 5, Y: 45, Action: PointerDown}) {
+		t.Error("press on a disabled clickable consumed")
+	}
+	v.Key("enter")
+	if !reflect.DeepEqual(events, []string{"key 2"}) {
+		t.Errorf("events %v", events)
+	}
+
+	// Enabling the subtree brings it back.
+	v.SetTree(tree(false))
+	if !v.Focus(5) {
+		t.Error("could not focus the enabled input")
+	}
+
+	// Disabled subtrees render faint.
+	off := true
+	rt := NewRenderTree()
+	SetTreeRoot(rt, &VNode{ID: 1, Type: NodeBox, Props: NodeProps{Disabled: &off}, Children: []*VNode{
+		{ID: 2, Type: NodeText, Props: NodeProps{Content: strPtr("off")}},
+	}})
+	if g := RenderGrid(rt, 4, 1, nil); !g.Cells[0][0].Style.Faint {
+		t.Errorf("disabled text style %+v", g.Cells[0][0].Style)
+	}
+}
+
+func TestTextCursor(t *testing.T) {
+	clock := &ManualClock{T: time.Unix(0, 0)}
+	v := NewViewer(HeadlessTarget{})
+	v.SetClock(clock)
+	v.Init(EnvInfo{DisplayWidth: 100, DisplayHeight: 100})
+	v.SetTree(&VNode{ID: 1, Type: NodeBox, Children: []*VNode{
+		{ID: 2, Type: NodeInput, Props: NodeProps{Value: strPtr("héllo")}},
+	}})
+	var values []string
+	v.OnMessage(func(msg ProtocolMessage) {
+		if msg.Type == MsgInput && msg.Event.Kind == "value_change" {
+			values = append(values, msg.Event.Value)
+		}
+	})
+
+	// Editing happens at the cursor, which starts at the end.
+	v.Focus(2)
+	for _, key := range []string{"Backspace", "ArrowLeft", "ArrowLeft", "ArrowLeft", "X", "Home", "Delete", "End", "!"} {
+		if !v.Key(key) {
+			t.Errorf("key %q not consumed", key)
+		}
+	}
+	if v.Key("Enter") {
+		t.Error("enter consumed")
+	}
+	want := []string{"héll", "hXéll", "Xéll", "Xéll!"}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("values %q, want %q", values, want)
+	}
+	tree := v.GetTree()
+	if c := tree.Cursor; c == nil || c.Node != 2 || c.Offset != len("Xéll!") {
+		t.Fatalf("cursor %+v", c)
+	}
+
+	// The grid inverts the cell under a block cursor and underlines it
+	// for the other shapes.
+	tree.Cursor.Offset, tree.Cursor.Anchor = 1, 1
+	g := RenderGrid(tree, 10, 1, nil)
+	if !g.Cells[0][3].Style.Inverse || g.Cells[0][2].Style.Inverse || g.Cells[0][3].Ch != 'é' {
+		t.Errorf("block cursor cells %+v %+v", g.Cells[0][2], g.Cells[0][3])
+	}
+	tree.Cursor.Shape = CursorBar
+	if g := RenderGrid(tree, 10, 1, nil); !g.Cells[0][3].Style.Underline || g.Cells[0][3].Style.Inverse {
+		t.Errorf("bar cursor cell %+v", g.Cells[0][3])
+	}
+
+	// The rasterizer paints the cursor in the text color.
+	tree.Cursor.Shape = CursorBlock
+	tree.Cursor.Offset, tree.Cursor.Anchor = len("Xéll!"), len("Xéll!")
+	img := RenderImage(tree, 100, 40, nil)
+	m := rasterMetrics(nil)
+	x := (2 + 5) * m.cellW
+	if img.RGBAAt(x+1, 2) != rasterForeground || img.RGBAAt(x+m.cellW+1, 2) != rasterBackground {
+		t.Errorf("raster cursor %v, after %v", img.RGBAAt(x+1, 2), img.RGBAAt(x+m.cellW+1, 2))
+	}
+
+	// A blinking cursor turns off and on as Tick advances and shows
+	// again when it moves.
+	v.SetCursorConfig(CursorConfig{Shape: CursorBlock, Blink: true})
+	clock.Advance(blinkPeriod)
+	if !v.Tick() || !v.GetTree().Cursor.Hidden {
+		t.Error("cursor did not blink off")
+	}
+	clock.Advance(blinkPeriod)
+	if v.Tick(); v.GetTree().Cursor.Hidden {
+		t.Error("cursor did not blink on")
+	}
+	clock.Advance(blinkPeriod)
+	v.Tick()
+	v.Key("Home")
+	if v.GetTree().Cursor.Hidden {
+		t.Error("moved cursor hidden")
+	}
+
+	// The cursor goes with focus.
+	v.SetTree(&VNode{ID: 1, Type: NodeBox, Children: []*VNode{
+		{ID: 2, Type: NodeInput},
+		{ID: 3, Type: NodeBox, Props: NodeProps{Interactive: "clickable"}},
+	}})
+	v.Focus(3)
+	if v.GetTree().Cursor != nil {
+		t.Error("cursor left on a blurred input")
+	}
+}
+
+func TestInputSelection(t *testing.T) {
+	v := NewViewer(HeadlessTarget{})
+	v.Init(EnvInfo{DisplayWidth: 200, DisplayHeight: 100})
+	v.SetTree(&VNode{ID: 1, Type: NodeBox, Children: []*VNode{
+		{ID: 2, Type: NodeInput, Props: NodeProps{Value: strPtr("hello world")}},
+	}})
+	var selects []string
+	v.OnMessage(func(msg ProtocolMessage) {
+		if msg.Type == MsgInput && msg.Event.Kind == "select" {
+			e := msg.Event
+			selects = append(selects, fmt.Sprintf("%q %d-%d", e.Value, *e.SelectionStart, *e.SelectionEnd))
+		}
+	})
+
+	// Shift extends the selection from the cursor; a plain arrow
+	// collapses it to that side.
+	v.Focus(2)
+	v.Key("shift+ArrowLeft")
+	v.Key("Shift+ArrowLeft")
+	v.Key("ArrowLeft")
+	v.Key("shift+Home")
+	want := []string{`"d" 10-11`, `"ld" 9-11`, `"" 9-9`, `"hello wor" 0-9`}
+	if !reflect.DeepEqual(selects, want) {
+		t.Errorf("selects %v, want %v", selects, want)
+	}
+
+	// The grid inverts the selection and shows no cursor.
+	g := RenderGrid(v.GetTree(), 20, 1, nil)
+	for x := 0; x < 14; x++ {
+		if inverse := x >= 2 && x < 11; g.Cells[0][x].Style.Inverse != inverse {
+			t.Errorf("cell %d inverse = %v", x, !inverse)
+		}
+	}
+
+	// Typing replaces the selection.
+	v.Key("J")
+	if got := *v.GetTree().NodeIndex[2].Props.Value; got != "Jld" {
+		t.Errorf("value %q", got)
+	}
+	v.Key("ctrl+a")
+	v.Key("Backspace")
+	if got := *v.GetTree().NodeIndex[2].Props.Value; got != "" {
+		t.Errorf("value after select all and backspace %q", got)
+	}
+
+	// Dragging across an input selects between the press and release.
+	v.SetTree(&VNode{ID: 1, Type: NodeBox, Children: []*VNode{
+		{ID: 3, Type: NodeInput, Props: NodeProps{Value: strPtr("abcdef")}},
+	}})
+	selects = nil
+	cw := PixelLayoutOptions().CharWidth
+	if !v.Pointer(PointerEvent{X: int(3 * cw), Y: 5, Action: PointerDown}) {
+		t.Fatal("press on input not consumed")
+	}
+	if id, _ := v.Focused(); id != 3 {
+		t.Errorf("focused %d", id)
+	}
+	v.Pointer(PointerEvent{X: int(5*cw) + 1, Y: 5, Action: PointerMove})
+	v.Pointer(PointerEvent{X: int(6*cw) - 1, Y: 5, Action: PointerUp})
+	if want := []string{`"bc" 1-3`, `"bcd" 1-4`}; !reflect.DeepEqual(selects, want) {
+		t.Errorf("drag selects %v, want %v", selects, want)
+	}
+	img := RenderImage(v.GetTree(), 200, 40, nil)
+	m := rasterMetrics(nil)
+	if img.RGBAAt(3*m.cellW+1, 1) == rasterBackground || img.RGBAAt(7*m.cellW+1, 1) != rasterBackground {
+		t.Error("raster selection not highlighted")
+	}
+}
+
+func TestClipboard(t *testing.T) {
+	v := NewViewer(HeadlessTarget{})
+	v.Init(EnvInfo{DisplayWidth: 200, DisplayHeight: 100})
+	v.SetTree(&VNode{ID: 1, Type: NodeBox, Children: []*VNode{
+		{ID: 2, Type: NodeInput, Props: NodeProps{Value: strPtr("copy me")}},
+		{ID: 3, Type: NodeBox, Props: NodeProps{Interactive: "focusable"}},
+	}})
+	var events []string
+	v.OnMessage(func(msg ProtocolMessage) {
+		if e := msg.Event; msg.Type == MsgInput && (e.Kind == "paste" || e.Kind == "key") {
+			events = append(events, fmt.Sprintf("%s %d %q", e.Kind, *e.Target, e.Value+e.Key))
+		}
+	})
+	value := func() string { return *v.GetTree().NodeIndex[2].Props.Value }
+
+	// With nothing selected, ctrl+c is the source's.
+	v.Focus(2)
+	if v.Key("ctrl+c") {
+		t.Error("ctrl+c without a selection consumed")
+	}
+
+	// Cut the last word and paste it at the start.
+	v.Key("shift+ArrowLeft")
+	v.Key("shift+ArrowLeft")
+	if !v.Key("ctrl+x") || value() != "copy " {
+		t.Errorf("after cut: %q", value())
+	}
+	v.Key("Home")
+	v.Key("ctrl+v")
+	if value() != "mecopy " {
+		t.Errorf("after paste: %q", value())
+	}
+
+	// Pastes outside inputs only go to the source.
+	v.Focus(3)
+	v.Key("ctrl+v")
+	want := []string{`key 2 "ctrl+c"`, `paste 2 "me"`, `paste 3 "me"`}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("events %v, want %v", events, want)
+	}
+
+	// A host clipboard replaces the in-memory one; line breaks paste as
+	// spaces into single-line inputs.
+	clip := &MemoryClipboard{}
+	clip.WriteText("a\nb")
+	v.SetClipboard(clip)
+	v.Focus(2)
+	v.Key("ctrl+a")
+	v.Key("ctrl+v")
+	if value() != "a b" {
+		t.Errorf("after host paste: %q", value())
+	}
+	v.Key("ctrl+a")
+	v.Key("ctrl+c")
+	if text, _ := clip.ReadText(); text != "a b" {
+		t.Errorf("host clipboard %q", text)
+	}
+}
+
+func TestMultilineInput(t *testing.T) {
+	multiline, rows := true, 4
+	v := NewViewer(HeadlessTarget{})
+	v.Init(EnvInfo{DisplayWidth: 200, DisplayHeight: 400})
+	v.SetTree(&VNode{ID: 1, Type: NodeBox, Children: []*VNode{
+		{ID: 2, Type: NodeInput, Props: NodeProps{Multiline: &multiline, Value: strPtr("one\ntwo")}},
+		{ID: 3, Type: NodeInput, Props: NodeProps{Multiline: &multiline, Height: rows * 20}},
+	}})
+	tree := v.GetTree()
+	value := func() string { return *tree.NodeIndex[2].Props.Value }
+	offset := func() int { return tree.Cursor.Offset }
+
+	// Enter breaks the line; up and down keep the column.
+	v.Focus(2)
+	v.Key("Enter")
+	v.Key("x")
+	if value() != "one\ntwo\nx" {
+		t.Errorf("value %q", value())
+	}
+	v.Key("ArrowUp")
+	v.Key("End")
+	v.Key("ArrowUp")
+	if offset() != 3 {
+		t.Errorf("up from the end of a line: offset %d", offset())
+	}
+	v.Key("ArrowUp")
+	if offset() != 0 {
+		t.Errorf("up from the first line: offset %d", offset())
+	}
+	v.Key("shift+ArrowDown")
+	v.Key("shift+ArrowDown")
+	if start, end := tree.Cursor.selection(len(value())); start != 0 || end != len("one\ntwo\n") {
+		t.Errorf("selection %d-%d", start, end)
+	}
+
+	// Inputs grow with their lines up to a limit, unless sized.
+	height := func(id int) float64 { return tree.NodeIndex[id].ComputedLayout.Height }
+	ComputeLayout(tree, 200, 400, PixelLayoutOptions())
+	if height(2) != 3*20 {
+		t.Errorf("three-line input height %v", height(2))
+	}
+	v.Key("ctrl+a")
+	for i := 0; i < 12; i++ {
+		v.Key("Enter")
+	}
+	ComputeLayout(tree, 200, 400, PixelLayoutOptions())
+	if height(2) != multilineMaxRows*20 {
+		t.Errorf("long input height %v", height(2))
+	}
+	if tree.Cursor.Scroll != 3 {
+		t.Errorf("scrolled %d lines, want 3", tree.Cursor.Scroll)
+	}
+
+	// Rendering follows the scroll, and clicks pick the line.
+	v.Focus(3)
+	for _, key := range []string{"a", "Enter", "b", "Enter", "c", "Enter", "d", "Enter", "e"} {
+		v.Key(key)
+	}
+	if tree.Cursor.Scroll != 1 {
+		t.Fatalf("scroll %d, want 1", tree.Cursor.Scroll)
+	}
+	g := RenderGrid(tree, 10, 40, nil)
+	y := int(tree.NodeIndex[3].ComputedLayout.Y)
+	if g.Cells[y][2].Ch != 'b' || g.Cells[y+3][2].Ch != 'e' || !g.Cells[y+3][3].Style.Inverse {
+		t.Errorf("rows %q %q, cursor %+v", g.Cells[y][2].Ch, g.Cells[y+3][2].Ch, g.Cells[y+3][3])
+	}
+	in := v.inputLayout()
+	l := in.layouts[3]
+	v.Pointer(PointerEvent{X: int(l.X) + 2*8 + 1, Y: int(l.Y) + 20 + 5, Action: PointerDown})
+	v.Pointer(PointerEvent{X: int(l.X) + 2*8 + 1, Y: int(l.Y) + 20 + 5, Action: PointerUp})
+	if want := len("a\nb\n"); offset() != want {
+		t.Errorf("click on the second row: offset %d, want %d", offset(), want)
+	}
+}
+
+func TestInputConstraints(t *testing.T) {
+	maxLength := 4
+	v := NewViewer(HeadlessTarget{})
+	v.Init(EnvInfo{DisplayWidth: 200, DisplayHeight: 100})
+	v.SetTree(&VNode{ID: 1, Type: NodeBox, Children: []*VNode{
+		{ID: 2, Type: NodeInput, Props: NodeProps{MaxLength: &maxLength}},
+		{ID: 3, Type: NodeInput, Props: NodeProps{InputType: InputNumber}},
+		{ID: 4, Type: NodeInput, Props: NodeProps{InputType: InputEmail}},
+		{ID: 5, Type: NodeInput, Props: NodeProps{Pattern: `[a-z]+\d`}},
+	}})
+	var validations []string
+	v.OnMessage(func(msg ProtocolMessage) {
+		if e := msg.Event; msg.Type == MsgInput && e.Kind == "validation" {
+			validations = append(validations, fmt.Sprintf("%d %v %s", *e.Target, *e.Valid, e.Reason))
+		}
+	})
+	tree := v.GetTree()
+	value := func(id int) string { return inputValue(tree.NodeIndex[id]) }
+	typeInto := func(id int, keys ...string) {
+		v.Focus(id)
+		for _, key := range keys {
+			v.Key(key)
+		}
+	}
+
+	// maxLength stops typing and cuts pastes short.
+	typeInto(2, "a", "b", "c", "d", "e")
+	if value(2) != "abcd" {
+		t.Errorf("maxLength value %q", value(2))
+	}
+	v.SetClipboard(&MemoryClipboard{})
+	v.Key("ctrl+a")
+	v.Key("ctrl+c")
+	v.Key("End")
+	v.Key("Backspace")
+	v.Key("ctrl+v")
+	if value(2) != "abca" {
+		t.Errorf("after paste %q", value(2))
+	}
+
+	// Number inputs only take what could start a number.
+	typeInto(3, "-", "1", "x", ".", "5", ".", "-")
+	if value(3) != "-1.5" {
+		t.Errorf("number value %q", value(3))
+	}
+
+	// Types and patterns are checked on commit.
+	typeInto(4, "a", "@", "b")
+	typeInto(5, "a", "b", "1")
+	v.Key("Enter")
+	want := []string{"2 true ", "3 true ", "4 false inputType", "5 true "}
+	if !reflect.DeepEqual(validations, want) {
+		t.Errorf("validations %q, want %q", validations, want)
+	}
+	if !tree.Invalid[4] || tree.Invalid[5] {
+		t.Errorf("invalid %v", tree.Invalid)
+	}
+
+	// Invalid inputs are drawn in the invalid color until fixed.
+	g := RenderGrid(tree, 10, 4, nil)
+	if g.Cells[2][2].Style.FG != invalidColor || g.Cells[3][2].Style.FG != "" {
+		t.Errorf("invalid style %+v, valid %+v", g.Cells[2][2].Style, g.Cells[3][2].Style)
+	}
+	typeInto(4, ".", "c")
+	v.Focus(2)
+	if tree.Invalid[4] {
+		t.Error("fixed input still invalid")
+	}
+}
+
+func TestSecretInput(t *testing.T) {
+	secret := true
+	v := NewViewer(HeadlessTarget{})
+	v.Init(EnvInfo{DisplayWidth: 200, DisplayHeight: 100})
+	v.SetTree(&VNode{ID: 1, Type: NodeInput, Props: NodeProps{Secret: &secret}})
+	var values []string
+	v.OnMessage(func(msg ProtocolMessage) {
+		if e := msg.Event; msg.Type == MsgInput && e.Kind == "value_change" {
+			values = append(values, e.Value)
+		}
+	})
+	v.Focus(1)
+	for _, key := range []string{"p", "w", "é", "Left"} {
+		v.Key(key)
+	}
+
+	// The source gets the real value; everything else gets bullets.
+	if got := values[len(values)-1]; got != "pwé" {
+		t.Errorf("value_change %q", got)
+	}
+	if got := v.GetTextProjection(); got != "•••" {
+		t.Errorf("projection %q", got)
+	}
+	if got := v.Screenshot().Data; !strings.Contains(got, "•••") || strings.Contains(got, "pw") {
+		t.Errorf("screenshot %q", got)
+	}
+	if got := RenderHTML(v.GetTree()); !strings.Contains(got, `type="password"`) || strings.Contains(got, "pw") {
+		t.Errorf("html %q", got)
+	}
+
+	// The cursor sits on the bullet of the cluster it is before.
+	g := RenderGrid(v.GetTree(), 10, 1, nil)
+	if got := g.Cells[0][2].Ch; got != '•' {
+		t.Errorf("cell %q", got)
+	}
+	if !g.Cells[0][4].Style.Inverse || g.Cells[0][3].Style.Inverse {
+		t.Error("cursor not on the last bullet")
+	}
+
+	// Selections cannot be copied out.
+	clip := &MemoryClipboard{}
+	v.SetClipboard(clip)
+	v.Key("ctrl+a")
+	if !v.Key("ctrl+c") {
+		t.Error("copy not consumed")
+	}
+	if text, _ := clip.ReadText(); text != "" {
+		t.Errorf("copied %q", text)
+	}
+
+	// Redaction hides even the length.
+	v.SetRedactSecrets(true)
+	if got := v.GetTextProjection(); got != "[redacted]" {
+		t.Errorf("redacted projection %q", got)
+	}
+	if got := v.Screenshot().Data; strings.Contains(got, "•") {
+		t.Errorf("redacted screenshot %q", got)
+	}
+}
+
+func TestFormControls(t *testing.T) {
+	label := func(s string) *string { return &s }
+	v := NewViewer(HeadlessTarget{})
+	v.Init(EnvInfo{DisplayWidth: 200, DisplayHeight: 100})
+	v.SetTree(&VNode{ID: 1, Type: NodeBox, Children: []*VNode{
+		{ID: 2, Type: NodeCheckbox, Props: NodeProps{Label: label("Accept terms")}},
+		{ID: 3, Type: NodeBox, Children: []*VNode{
+			{ID: 4, Type: NodeRadio, Props: NodeProps{Label: label("Small"), Value: strPtr("s")}},
+			{ID: 5, Type: NodeRadio, Props: NodeProps{Label: label("Large"), Value: strPtr("l")}},
+		}},
+		{ID: 6, Type: NodeSelect, Props: NodeProps{Options: []string{"red", "green", "blue"}}},
+	}})
+	var changes []string
+	v.OnMessage(func(msg ProtocolMessage) {
+		if e := msg.Event; msg.Type == MsgInput && e.Kind == "change" {
+			s := fmt.Sprintf("%d %s", *e.Target, e.Value)
+			if e.Checked != nil {
+				s += fmt.Sprintf(" %v", *e.Checked)
+			}
+			changes = append(changes, s)
+		}
+	})
+
+	// Space toggles a checkbox; arrows move the check through radios
+	// and the value through a select's options.
+	v.Focus(2)
+	if !v.Key(" ") {
+		t.Error("space not consumed")
+	}
+	v.Focus(4)
+	v.Key(" ")
+	v.Key("ArrowDown")
+	if id, _ := v.Focused(); id != 5 {
+		t.Errorf("focused %d after arrow", id)
+	}
+	v.Focus(6)
+	v.Key("ArrowDown")
+	v.Key("End")
+	v.Key("ArrowDown")
+	want := []string{"2  true", "4 s true", "5 l true", "6 green", "6 blue"}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("changes %q, want %q", changes, want)
+	}
+	if got, want := v.GetTextProjection(), "[x] Accept terms\n( ) Small\n(*) Large\n[blue ▾]"; got != want {
+		t.Errorf("projection %q, want %q", got, want)
+	}
+
+	// Clicking activates, and the focused control is underlined.
+	changes = nil
+	tree := v.GetTree()
+	v.Pointer(PointerEvent{X: 5, Y: 0, Action: PointerDown})
+	v.Pointer(PointerEvent{X: 5, Y: 0, Action: PointerUp})
+	if id, _ := v.Focused(); id != 2 || isChecked(tree.NodeIndex[2]) {
+		t.Errorf("after click focused %d, checked %v", id, isChecked(tree.NodeIndex[2]))
+	}
+	g := RenderGrid(tree, 20, 4, nil)
+	if !g.Cells[0][0].Style.Underline || g.Cells[1][0].Style.Underline {
+		t.Error("focused control not underlined")
+	}
+	if !reflect.DeepEqual(changes, []string{"2  false"}) {
+		t.Errorf("click changes %q", changes)
+	}
+}
+
+func TestButton(t *testing.T) {
+	v := NewViewer(HeadlessTarget{})
+	v.Init(EnvInfo{DisplayWidth: 200, DisplayHeight: 100})
+	v.SetTree(&VNode{ID: 1, Type: NodeBox, Children: []*VNode{
+		{ID: 2, Type: NodeButton, Props: NodeProps{Label: strPtr("Save"), Variant: ButtonPrimary}},
+		{ID: 3, Type: NodeButton, Props: NodeProps{Label: strPtr("Delete"), Variant: ButtonDanger}},
+	}})
+	var clicks []int
+	v.OnMessage(func(msg ProtocolMessage) {
+		if e := msg.Event; msg.Type == MsgInput && e.Kind == "click" {
+			clicks = append(clicks, *e.Target)
+		}
+	})
+	if got := v.GetTextProjection(); got != "[ Save ]\n[ Delete ]" {
+		t.Errorf("projection %q", got)
+	}
+
+	// Buttons take focus and click on enter or space.
+	v.Key("Tab")
+	v.Key("Enter")
+	v.Key("Tab")
+	v.Key(" ")
+	if v.Key("x") {
+		t.Error("button consumed a letter")
+	}
+
+	// The pointer presses them like clickable nodes.
+	tree := v.GetTree()
+	v.Pointer(PointerEvent{X: 5, Y: 5, Action: PointerDown})
+	if tree.Active == nil || *tree.Active != 2 {
+		t.Errorf("active %v", tree.Active)
+	}
+	g := RenderGrid(tree, 12, 2, nil)
+	if !g.Cells[0][0].Style.Inverse || !g.Cells[0][0].Style.Bold || g.Cells[1][0].Style.FG != dangerColor {
+		t.Errorf("styles %+v, %+v", g.Cells[0][0].Style, g.Cells[1][0].Style)
+	}
+	v.Pointer(PointerEvent{X: 5, Y: 5, Action: PointerUp})
+	if !reflect.DeepEqual(clicks, []int{2, 3, 2}) {
+		t.Errorf("clicks %v", clicks)
+	}
+}
+
+func TestProgress(t *testing.T) {
+	value, limit := 21.0, 50.0
+	clock := &ManualClock{T: time.Unix(0, 0)}
+	v := NewViewer(HeadlessTarget{})
+	v.SetClock(clock)
+	v.Init(EnvInfo{DisplayWidth: 200, DisplayHeight: 100})
+	v.DefineSlot(64, TransitionSlot{Kind: "transition", Role: "default", DurationMs: 100, Easing: "linear"})
+	transition := 64
+	v.SetTree(&VNode{ID: 1, Type: NodeBox, Children: []*VNode{
+		{ID: 2, Type: NodeProgress, Props: NodeProps{Progress: &value, Max: &limit, Transition: &transition}},
+		{ID: 3, Type: NodeProgress},
+		{ID: 4, Type: NodeSpinner, Props: NodeProps{Label: strPtr("Loading")}},
+	}})
+	if got, want := v.GetTextProjection(), "42%\nworking…\nLoading"; got != want {
+		t.Errorf("projection %q, want %q", got, want)
+	}
+	tree := v.GetTree()
+	bar := func() string {
+		g := renderGrid(tree, 10, 3, nil, &imageCache{}, clock.T)
+		return string([]rune{g.Cells[0][0].Ch, g.Cells[0][4].Ch, g.Cells[0][5].Ch})
+	}
+	if !v.Tick() {
+		t.Error("Tick idle with a spinner in the tree")
+	}
+	if got := bar(); got != "█▎░" {
+		t.Errorf("bar %q", got)
+	}
+
+	// A new value is reached over the transition.
+	next := 50.0
+	v.ApplyPatches([]PatchOp{{Target: 2, Set: map[string]interface{}{"progress": next}}})
+	v.Tick()
+	clock.Advance(50 * time.Millisecond)
+	if got := tree.Progress[2].at(clock.T); math.Abs(got-0.71) > 1e-9 {
+		t.Errorf("halfway %v", got)
+	}
+	clock.Advance(50 * time.Millisecond)
+	if got := bar(); got != "███" {
+		t.Errorf("full bar %q", got)
+	}
+
+	// Spinners cycle through their frames.
+	g := renderGrid(tree, 10, 3, nil, &imageCache{}, clock.T)
+	clock.Advance(spinnerInterval)
+	if h := renderGrid(tree, 10, 3, nil, &imageCache{}, clock.T); g.Cells[2][0].Ch == h.Cells[2][0].Ch {
+		t.Error("spinner did not advance")
+	}
+
+	// Bars far wider than the grid are drawn only where they show.
+	wide := int(1e9)
+	tree.NodeIndex[2].Props.MinWidth = &wide
+	tree.NodeIndex[3].Props.MinWidth = &wide
+	g = renderGrid(tree, 10, 3, nil, &imageCache{}, clock.T)
+	if got := strings.Join(strings.Split(g.String(), "\n")[:2], "\n"); got != "██████████\n░░░░░░░░░░" {
+		t.Errorf("wide bars\n%s", got)
+	}
+}
+
+func TestTable(t *testing.T) {
+	schema, height, striped := 10, 60.0, true
+	v := NewViewer(HeadlessTarget{})
+	v.Init(EnvInfo{DisplayWidth: 200, DisplayHeight: 100})
+	v.ProcessMessage(ProtocolMessage{Type: MsgSchema, Slot: &schema, Columns: []SchemaColumn{
+		{ID: 0, Name: "name", Type: "string"},
+		{ID: 1, Name: "size", Type: "uint64", Format: "human_bytes"},
+	}})
+	for _, row := range [][]interface{}{{"a.txt", 512}, {"b.bin", 2048}, {"c.iso", 1 << 20}, {"a-very-long-file-name.tar.gz", 1}} {
+		v.ProcessMessage(ProtocolMessage{Type: MsgData, Schema: &schema, Row: row})
+	}
+	v.SetTree(&VNode{ID: 1, Type: NodeBox, Children: []*VNode{
+		{ID: 2, Type: NodeTable, Props: NodeProps{Schema: &schema, Height: height, Striped: &striped, ColumnWidths: []int{10}}},
+	}})
+	var selects []int
+	v.OnMessage(func(msg ProtocolMessage) {
+		if e := msg.Event; msg.Type == MsgInput && e.Kind == "select" {
+			selects = append(selects, *e.Row)
+		}
+	})
+	tree := v.GetTree()
+
+	g := RenderGrid(tree, 20, 4, nil)
+	for i, want := range []string{"name        size", "a.txt       512 B", "b.bin       2.0 KB", "c.iso       1.0 MB"} {
+		if got := strings.TrimRight(strings.Split(g.String(), "\n")[i], " "); got != want {
+			t.Errorf("row %d %q, want %q", i, got, want)
+		}
+	}
+	if !g.Cells[0][0].Style.Bold || g.Cells[2][0].Style.BG != stripeColor || g.Cells[1][0].Style.BG != "" {
+		t.Error("header or stripes not styled")
+	}
+
+	// Keys move the selection and scroll it into view; the table is two
+	// rows tall under its header.
+	v.Focus(2)
+	for _, key := range []string{"ArrowDown", "ArrowDown", "End", "Home", "PageDown"} {
+		if !v.Key(key) {
+			t.Errorf("%s not consumed", key)
+		}
+	}
+	if !reflect.DeepEqual(selects, []int{0, 1, 3, 0, 2}) {
+		t.Errorf("selects %v", selects)
+	}
+	if got := *tree.NodeIndex[2].Props.ScrollTop; got != 1 {
+		t.Errorf("scrollTop %d", got)
+	}
+	g = RenderGrid(tree, 20, 3, nil)
+	if got := strings.TrimRight(strings.Split(g.String(), "\n")[2], " "); got != "c.iso       1.0 MB" || !g.Cells[2][0].Style.Inverse {
+		t.Errorf("selected row %q", got)
+	}
+
+	// Pressing a row selects it.
+	v.Pointer(PointerEvent{X: 5, Y: 25, Action: PointerDown})
+	if got := selectedRow(tree.NodeIndex[2]); got != 1 {
+		t.Errorf("pressed row %d", got)
+	}
+	if got := strings.Split(v.GetTextProjection(), "\n")[4]; got != "a-very-long-file-name.tar.gz\t1 B" {
+		t.Errorf("projection line %q", got)
+	}
+
+	// A table laid out far wider than the screen is padded only as far
+	// as it shows.
+	wide := int(1e8)
+	tree.NodeIndex[2].Props.MinWidth = &wide
+	g = RenderGrid(tree, 20, 3, nil)
+	if got := strings.Split(g.String(), "\n")[0]; got != "name        size" || !g.Cells[0][19].Style.Bold {
+		t.Errorf("wide header %q", got)
+	}
+}
+
+func TestTooltip(t *testing.T) {
+	clock := &ManualClock{T: time.Unix(0, 0)}
+	v := NewViewer(HeadlessTarget{})
+	v.SetClock(clock)
+	v.Init(EnvInfo{DisplayWidth: 200, DisplayHeight: 100})
+	v.SetTree(&VNode{ID: 1, Type: NodeBox, Children: []*VNode{
+		{ID: 2, Type: NodeButton, Props: NodeProps{Label: strPtr("Save"), Tooltip: strPtr("Write to disk")}},
+		{ID: 3, Type: NodeText, Props: NodeProps{Content: strPtr("Quit")}},
+	}})
+	tree := v.GetTree()
+
+	// Hovering shows the tooltip only after the delay.
+	v.Pointer(PointerEvent{X: 5, Y: 5, Action: PointerMove})
+	if !v.Tick() || tree.Tooltip != nil {
+		t.Fatal("tooltip shown before its delay")
+	}
+	clock.T = clock.T.Add(tooltipDelay)
+	if v.Tick() || tree.Tooltip == nil || *tree.Tooltip != 2 {
+		t.Fatalf("tooltip %v after its delay", tree.Tooltip)
+	}
+	if got := v.GetTextProjection(); got != "[ Save ]\ntooltip: Write to disk\nQuit" {
+		t.Errorf("projection %q", got)
+	}
+
+	// It is drawn below its node, over the rest.
+	g := RenderGrid(tree, 20, 4, nil)
+	if got := strings.Split(g.String(), "\n")[1]; strings.TrimRight(got, " ") != " Write to disk" || !g.Cells[1][0].Style.Inverse {
+		t.Errorf("grid row %q", got)
+	}
+	r := NewRasterizer()
+	r.Render(tree, 200, 100, nil)
+
+	// Escape hides it, and the rasterizer repaints where it was.
+	if !v.Key("Escape") || tree.Tooltip != nil {
+		t.Error("escape did not hide the tooltip")
+	}
+	if v.Key("Escape") {
+		t.Error("escape consumed with no tooltip")
+	}
+	if res := r.Render(tree, 200, 100, nil); len(res.Damage) == 0 || res.Damage[0].Min.Y > 20 {
+		t.Errorf("damage %v", res.Damage)
+	}
+
+	// Focus shows it too; moving off and pressing hide it.
+	v.Pointer(PointerEvent{X: 5, Y: 90, Action: PointerMove})
+	v.Key("Tab")
+	clock.T = clock.T.Add(tooltipDelay)
+	v.Tick()
+	if tree.Tooltip == nil {
+		t.Fatal("focus did not show the tooltip")
+	}
+	v.Pointer(PointerEvent{X: 5, Y: 90, Action: PointerDown})
+	if tree.Tooltip != nil {
+		t.Error("press did not hide the tooltip")
+	}
+	if html := RenderHTML(tree); !strings.Contains(html, `title="Write to disk"`) {
+		t.Errorf("html %s", html)
+	}
+}
+
+func TestOverlay(t *testing.T) {
+	z := 1
+	modal := true
+	under := []*VNode{
+		{ID: 2, Type: NodeText, Props: NodeProps{Content: strPtr("under"), Interactive: "clickable"}},
+		{ID: 3, Type: NodeButton, Props: NodeProps{Label: strPtr("Open")}},
+	}
+	v := NewViewer(HeadlessTarget{})
+	v.Init(EnvInfo{DisplayWidth: 200, DisplayHeight: 100})
+	v.SetTree(&VNode{ID: 1, Type: NodeBox, Children: under})
+	v.Focus(3)
+	v.SetTree(&VNode{ID: 1, Type: NodeBox, Children: append(under, &VNode{
+		ID: 4, Type: NodeBox, Props: NodeProps{ZIndex: &z, Modal: &modal, Justify: "center"},
+		Children: []*VNode{{ID: 5, Type: NodeButton, Props: NodeProps{Label: strPtr("OK")}}},
+	})})
+	var keys []int
+	v.OnMessage(func(msg ProtocolMessage) {
+		if e := msg.Event; msg.Type == MsgInput && e.Kind == "key" {
+			keys = append(keys, *e.Target)
+		}
+	})
+	tree := v.GetTree()
+
+	// The modal is laid out over the screen, out of the flow, and dims
+	// what is under it.
+	g := RenderGrid(tree, 20, 5, nil)
+	if got := strings.TrimRight(strings.Split(g.String(), "\n")[2], " "); got != "[ OK ]" {
+		t.Errorf("modal row %q", got)
+	}
+	if !g.Cells[0][0].Style.Faint || g.Cells[2][0].Style.Faint {
+		t.Error("screen under the modal not dimmed")
+	}
+	if img := NewRasterizer().Render(tree, 200, 100, nil).Image; img.RGBAAt(199, 99) == rasterBackground {
+		t.Error("image under the modal not dimmed")
+	}
+
+	// It takes the pointer, keys, and focus.
+	if v.Pointer(PointerEvent{X: 5, Y: 5, Action: PointerDown}) || tree.Active != nil {
+		t.Error("pressed a node under the modal")
+	}
+	v.Pointer(PointerEvent{X: 5, Y: 5, Action: PointerUp})
+	v.Key("x")
+	if !reflect.DeepEqual(keys, []int{4}) {
+		t.Errorf("key targets %v", keys)
+	}
+	if v.Focus(3) {
+		t.Error("focused a node under the modal")
+	}
+	v.Key("Tab")
+	v.Key("Tab")
+	if id, _ := v.Focused(); id != 5 {
+		t.Errorf("focus %d", id)
+	}
+	if id, ok := v.HitTest(5, 45); !ok || id != 5 {
+		t.Errorf("hit %d", id)
+	}
+
+	// Without modal, an overlay only takes the pointer over its content.
+	modal = false
+	v.SetTree(&VNode{ID: 1, Type: NodeBox, Children: append(under, &VNode{
+		ID: 4, Type: NodeBox, Props: NodeProps{ZIndex: &z, Modal: &modal, Justify: "end"},
+		Children: []*VNode{{ID: 6, Type: NodeText, Props: NodeProps{Content: strPtr("Saved")}}},
+	})})
+	if id, _ := v.HitTest(5, 5); id != 2 {
+		t.Errorf("hit %d under a toast", id)
+	}
+	if id, _ := v.HitTest(5, 90); id != 6 {
+		t.Errorf("hit %d on a toast", id)
+	}
+	if html := RenderHTML(v.GetTree()); !strings.Contains(html, "position:fixed;inset:0;z-index:1") {
+		t.Errorf("html %s", html)
+	}
+}
+
+func TestPositioning(t *testing.T) {
+	top := 1
+	v := NewViewer(HeadlessTarget{})
+	v.Init(EnvInfo{DisplayWidth: 200, DisplayHeight: 100})
+	v.SetTree(&VNode{ID: 1, Type: NodeBox, Children: []*VNode{
+		{ID: 2, Type: NodeText, Props: NodeProps{Content: strPtr("flow")}},
+		{ID: 3, Type: NodeText, Props: NodeProps{Content: strPtr("badge"), Position: PositionAbsolute, Top: 0, Right: 0}},
+		{ID: 4, Type: NodeScroll, Props: NodeProps{Height: 3, ScrollTop: &top}, Children: []*VNode{
+			{ID: 5, Type: NodeText, Props: NodeProps{Content: strPtr("a")}},
+			{ID: 6, Type: NodeText, Props: NodeProps{Content: strPtr("b")}},
+			{ID: 7, Type: NodeText, Props: NodeProps{Content: strPtr("c")}},
+			{ID: 8, Type: NodeText, Props: NodeProps{Content: strPtr("status"), Position: PositionFixed, Bottom: 0, Left: 0}},
+		}},
+		{ID: 9, Type: NodeBox, Props: NodeProps{Position: PositionAbsolute, Left: 2, Right: 2, Top: 2, Height: 1}},
+	}})
+	tree := v.GetTree()
+
+	// Positioned nodes take no space in the flow: the scroll node follows
+	// "flow" directly.
+	layouts := ComputeLayout(tree, 20, 5, CellLayoutOptions())
+	for id, want := range map[int]ComputedLayout{
+		3: {X: 15, Y: 0, Width: 5, Height: 1},
+		4: {X: 0, Y: 1, Width: 20, Height: 3},
+		8: {X: 0, Y: 4, Width: 6, Height: 1},
+		9: {X: 2, Y: 2, Width: 16, Height: 1},
+	} {
+		if got := *layouts[id]; got != want {
+			t.Errorf("node %d at %+v, want %+v", id, got, want)
+		}
+	}
+
+	// The fixed node stays on the screen however its parent scrolls.
+	lines := strings.Split(RenderGrid(tree, 20, 5, nil).String(), "\n")
+	for i, want := range []string{"flow           badge", "b", "c", "", "status"} {
+		if got := strings.TrimRight(lines[i], " "); got != want {
+			t.Errorf("row %d %q, want %q", i, got, want)
+		}
+	}
+	if id, _ := v.HitTest(5, 90); id != 8 {
+		t.Errorf("hit %d", id)
+	}
+	if html := RenderHTML(tree); !strings.Contains(html, "position:fixed;bottom:0px;left:0px") {
+		t.Errorf("html %s", html)
+	}
+}
+
+func TestGridLayout(t *testing.T) {
+	gap, one, two := 1, 1, 2
+	text := func(id int, s string, props NodeProps) *VNode {
+		props.Content = strPtr(s)
+		return &VNode{ID: id, Type: NodeText, Props: props}
+	}
+	v := NewViewer(HeadlessTarget{})
+	v.Init(EnvInfo{DisplayWidth: 200, DisplayHeight: 100})
+	v.SetTree(&VNode{ID: 1, Type: NodeBox, Props: NodeProps{Direction: DirectionGrid, Columns: "4 1fr 1fr", Gap: &gap}, Children: []*VNode{
+		text(2, "a", NodeProps{}),
+		text(3, "b", NodeProps{ColSpan: &two}),
+		text(4, "c", NodeProps{RowSpan: &two}),
+		text(5, "d", NodeProps{}),
+		text(6, "e", NodeProps{ColSpan: &one}),
+	}})
+	tree := v.GetTree()
+
+	// Children fill the cells row by row, spanning tracks and gaps.
+	layouts := ComputeLayout(tree, 20, 6, CellLayoutOptions())
+	for id, want := range map[int]ComputedLayout{
+		2: {X: 0, Y: 0, Width: 4, Height: 1},
+		3: {X: 5, Y: 0, Width: 15, Height: 1},
+		4: {X: 0, Y: 2, Width: 4, Height: 2},
+		5: {X: 5, Y: 2, Width: 7, Height: 1},
+		6: {X: 13, Y: 2, Width: 7, Height: 1},
+	} {
+		if got := *layouts[id]; got != want {
+			t.Errorf("node %d at %+v, want %+v", id, got, want)
+		}
+	}
+	if got := v.GetTextProjection(); got != "a\tb\nc\td\te" {
+		t.Errorf("projection %q", got)
+	}
+	if html := RenderHTML(tree); !strings.Contains(html, "display:grid;grid-template-columns:4px 1fr 1fr") || !strings.Contains(html, "grid-column:span 2") {
+		t.Errorf("html %s", html)
+	}
+
+	// Unsized, a grid is as wide as its tracks' content.
+	v.SetTree(&VNode{ID: 1, Type: NodeBox, Props: NodeProps{Direction: "row"}, Children: []*VNode{
+		{ID: 7, Type: NodeBox, Props: NodeProps{Direction: DirectionGrid, Columns: "auto 1fr", Gap: &gap}, Children: []*VNode{
+			text(8, "abc", NodeProps{}),
+			text(9, "de", NodeProps{}),
+		}},
+		text(10, "z", NodeProps{}),
+	}})
+	if l := ComputeLayout(v.GetTree(), 20, 6, CellLayoutOptions())[10]; l.X != 6 {
+		t.Errorf("after the grid at %g", l.X)
+	}
+}
+
+func TestAspectRatio(t *testing.T) {
+	wide, square, three := 2.0, 1.0, 3
+	tree := NewRenderTree()
+	tree.Root = &RenderNode{ID: 1, Type: NodeBox, Children: []*RenderNode{
+		{ID: 2, Type: NodeCanvas, Props: NodeProps{AspectRatio: &wide}},
+		{ID: 3, Type: NodeCanvas, Props: NodeProps{AspectRatio: &wide, Width: 10}},
+		{ID: 4, Type: NodeCanvas, Props: NodeProps{AspectRatio: &square, MaxHeight: &three}},
+	}}
+
+	// Unsized, a node is as big as its ratio allows; sized on one side,
+	// the other follows; a max on the derived side shrinks both.
+	layouts := ComputeLayout(tree, 40, 40, CellLayoutOptions())
+	for id, want := range map[int]ComputedLayout{
+		2: {X: 0, Y: 0, Width: 40, Height: 20},
+		3: {X: 0, Y: 20, Width: 10, Height: 5},
+		4: {X: 0, Y: 25, Width: 3, Height: 3},
+	} {
+		if got := *layouts[id]; got != want {
+			t.Errorf("node %d at %+v, want %+v", id, got, want)
+		}
+	}
+
+	for in, want := range map[interface{}]float64{"16/9": 16.0 / 9, "4:3": 4.0 / 3, 1.5: 1.5, "2": 2} {
+		if got, ok := parseAspectRatio(in); !ok || got != want {
+			t.Errorf("aspect ratio %v read as %g", in, got)
+		}
+	}
+}
+
+func TestOverflow(t *testing.T) {
+	text := func(id int, s string) *RenderNode {
+		return &RenderNode{ID: id, Type: NodeText, Props: NodeProps{Content: strPtr(s)}}
+	}
+	top := 1
+	tree := NewRenderTree()
+	tree.Root = &RenderNode{ID: 1, Type: NodeBox, Children: []*RenderNode{
+		{ID: 2, Type: NodeBox, Props: NodeProps{Height: 2, Overflow: OverflowHidden}, Children: []*RenderNode{text(5, "a"), text(6, "b"), text(7, "c")}},
+		{ID: 3, Type: NodeBox, Props: NodeProps{Height: 2, Overflow: OverflowAuto, ScrollTop: &top}, Children: []*RenderNode{text(8, "f"), text(9, "g"), text(10, "h")}},
+		{ID: 4, Type: NodeBox, Props: NodeProps{Height: 1, Overflow: OverflowVisible}, Children: []*RenderNode{text(11, "d"), text(12, "e")}},
+	}}
+
+	// Hidden clips, auto scrolls with a scrollbar, and visible shows the
+	// overflow below the box.
+	g := RenderGrid(tree, 20, 6, nil)
+	for y, want := range "abghde" {
+		if got := g.Cells[y][0].Ch; got != want {
+			t.Errorf("row %d %q, want %q", y, got, want)
+		}
+	}
+	if g.Cells[2][19].Ch == ' ' {
+		t.Error("no scrollbar on the auto box")
+	}
+	in := &inputLayout{opts: CellLayoutOptions(), layouts: ComputeLayout(tree, 20, 6, CellLayoutOptions())}
+	if path := hitLayers(tree.Root, in, 0, 5); len(path) == 0 || path[len(path)-1].node.ID != 12 {
+		t.Errorf("hit %v", path)
+	}
+	if path := hitLayers(tree.Root, in, 0, 3); path[len(path)-1].node.ID != 10 {
+		t.Errorf("hit %d in the scrolled box", path[len(path)-1].node.ID)
+	}
+
+	// Projections leave out what is clipped, and what is scrolled away
+	// unless asked for full scroll content.
+	opts := DefaultTextProjectionOptions()
+	if got := TextProjectionWithOptions(tree, opts); got != "a\nb\nf\ng\nh\nd\ne" {
+		t.Errorf("projection %q", got)
+	}
+	opts.FullScrollContent = false
+	if got := TextProjectionWithOptions(tree, opts); got != "a\nb\ng\nh\nd\ne" {
+		t.Errorf("projection %q", got)
+	}
+}
+
+func TestHidden(t *testing.T) {
+	v := NewViewer(HeadlessTarget{})
+	v.Init(EnvInfo{DisplayWidth: 200, DisplayHeight: 100})
+	v.SetTree(&VNode{ID: 1, Type: NodeBox, Children: []*VNode{
+		{ID: 2, Type: NodeText, Props: NodeProps{Content: strPtr("a")}},
+		{ID: 3, Type: NodeBox, Children: []*VNode{
+			{ID: 4, Type: NodeInput, Props: NodeProps{Value: strPtr("typed")}},
+		}},
+		{ID: 5, Type: NodeText, Props: NodeProps{Content: strPtr("c")}},
+		{ID: 6, Type: NodeButton, Props: NodeProps{Label: strPtr("OK")}},
+	}})
+	v.ApplyPatches([]PatchOp{{Target: 3, Set: map[string]interface{}{"hidden": true}}})
+	tree := v.GetTree()
+
+	// A hidden subtree takes no space and is neither drawn nor hit.
+	g := RenderGrid(tree, 20, 5, nil)
+	for i, want := range []string{"a", "c"} {
+		if got := strings.TrimRight(strings.Split(g.String(), "\n")[i], " "); got != want {
+			t.Errorf("row %d %q, want %q", i, got, want)
+		}
+	}
+	if id, ok := v.HitTest(5, 25); !ok || id != 5 {
+		t.Errorf("hit %d", id)
+	}
+	if got := TextProjection(tree); got != "a\nc\n[ OK ]" {
+		t.Errorf("projection %q", got)
+	}
+	if html := RenderHTML(tree); !strings.Contains(html, `data-vp-id="3" style="display:none"`) {
+		t.Errorf("html %s", html)
+	}
+
+	// Its nodes cannot take focus.
+	if v.Focus(4) {
+		t.Error("focused a hidden input")
+	}
+	v.Key("Tab")
+	if id, _ := v.Focused(); id != 6 {
+		t.Errorf("focus %d", id)
+	}
+
+	// Showing it again brings back its state.
+	v.ApplyPatches([]PatchOp{
+		{Target: 3, Set: map[string]interface{}{"hidden": false}},
+		{Target: 5, Set: map[string]interface{}{"display": "none"}},
+	})
+	if got := TextProjection(v.GetTree()); got != "a\ntyped\n[ OK ]" {
+		t.Errorf("projection %q", got)
+	}
+	if !v.Focus(4) {
+		t.Error("shown input refused focus")
+	}
+}
+
+func TestShow(t *testing.T) {
+	state := NewSourceState()
+	open := true
+	root := Mount(state, ComponentFunc(func() *VNode {
+		node, err := Column(
+			Text("title"),
+			Show(open, Column(Input().Value("draft"), Text("details"))),
+		).BuildWith(state.IDs())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return node
+	}))
+	v := NewViewer(HeadlessTarget{})
+	send := func() []ProtocolMessage {
+		msgs := root.Flush()
+		for _, msg := range msgs {
+			v.ProcessMessage(msg)
+		}
+		return msgs
+	}
+	send()
+	box := state.Published().Root.Children[1].ID
+
+	// Hiding and showing are each a single visibility patch.
+	for _, shown := range []bool{false, true} {
+		root.Update(func() { open = shown })
+		msgs := send()
+		want := []PatchOp{{Target: box, Set: map[string]interface{}{"hidden": !shown}}}
+		if len(msgs) != 1 || !reflect.DeepEqual(msgs[0].Ops, want) {
+			t.Fatalf("shown=%v: sent %+v", shown, msgs)
+		}
+	}
+	if got := v.GetTextProjection(); got != "title\ndraft\ndetails" {
+		t.Errorf("projection %q", got)
+	}
+
+	// Flipping back and forth between flushes sends nothing.
+	root.Update(func() { open = false })
+	root.Render()
+	root.Update(func() { open = true })
+	if msgs := send(); msgs != nil {
+		t.Errorf("sent %+v", msgs)
+	}
+}
+
+func TestTransform(t *testing.T) {
+	red := "#ff0000"
+	v := NewViewer(HeadlessTarget{})
+	v.Init(EnvInfo{DisplayWidth: 200, DisplayHeight: 100})
+	v.SetTree(&VNode{ID: 1, Type: NodeBox, Children: []*VNode{
+		{ID: 2, Type: NodeBox, Props: NodeProps{Width: 80, Height: 20, Background: red, Transform: &Transform{Rotate: 90}}},
+		{ID: 3, Type: NodeBox, Props: NodeProps{Width: 20, Height: 20, Background: red, Transform: &Transform{TranslateX: 150}}},
+	}})
+	tree := v.GetTree()
+
+	// Nodes are drawn transformed about their centers, leaving the layout
+	// alone.
+	if l := v.GetLayout(3); l.X != 0 || l.Y != 20 {
+		t.Errorf("layout %+v", l)
+	}
+	img := NewRasterizer().Render(tree, 200, 100, nil).Image
+	for _, c := range []struct {
+		x, y int
+		red  bool
+	}{{70, 10, false}, {40, 40, true}, {10, 30, false}, {160, 30, true}} {
+		if got := img.RGBAAt(c.x, c.y) == (color.RGBA{255, 0, 0, 255}); got != c.red {
+			t.Errorf("pixel (%d, %d) red %v", c.x, c.y, got)
+		}
+	}
+
+	// The pointer reaches them where they are drawn.
+	for _, c := range []struct{ x, y, id int }{{70, 10, 1}, {40, 40, 2}, {10, 30, 1}, {160, 30, 3}} {
+		if id, _ := v.HitTest(c.x, c.y); id != c.id {
+			t.Errorf("hit (%d, %d) = %d, want %d", c.x, c.y, id, c.id)
+		}
+	}
+	if html := RenderHTML(tree); !strings.Contains(html, "transform:rotate(90deg)") || !strings.Contains(html, "transform:translate(150px,0px)") {
+		t.Errorf("html %s", html)
+	}
+
+	// Transforms change with patches, redrawing where the node was and
+	// is, and removing one is a Set.
+	r := NewRasterizer()
+	r.Render(tree, 200, 100, nil)
+	v.ApplyPatches([]PatchOp{{Target: 3, Set: map[string]interface{}{"transform": map[interface{}]interface{}{"scale": 2}}}})
+	if tf := v.GetTree().NodeIndex[3].Props.Transform; *tf != (Transform{ScaleX: 2, ScaleY: 2}) {
+		t.Errorf("transform %+v", tf)
+	}
+	got, want := r.Render(v.GetTree(), 200, 100, nil), NewRasterizer().Render(v.GetTree(), 200, 100, nil)
+	if !bytes.Equal(got.Image.Pix, want.Image.Pix) {
+		t.Error("incremental render differs from a full one")
+	}
+	prev := &VNode{ID: 1, Type: NodeBox, Props: NodeProps{Transform: &Transform{Rotate: 45}}}
+	ops, _ := DiffTree(prev, &VNode{ID: 1, Type: NodeBox})
+	if want := []PatchOp{{Target: 1, Set: map[string]interface{}{"transform": Transform{}}}}; !reflect.DeepEqual(ops, want) {
+		t.Errorf("ops %+v", ops)
+	}
+}
+
+func TestRelativeSizes(t *testing.T) {
+	tree := NewRenderTree()
+	tree.Root = &RenderNode{ID: 1, Type: NodeBox, Props: NodeProps{Width: "50%"}, Children: []*RenderNode{
+		{ID: 2, Type: NodeBox, Props: NodeProps{Direction: "row", Height: "50%"}, Children: []*RenderNode{
+			{ID: 3, Type: NodeText, Props: NodeProps{Content: strPtr("a"), Width: "25%"}},
+			{ID: 4, Type: NodeText, Props: NodeProps{Content: strPtr("b"), Flex: floatPtr(1)}},
+			{ID: 5, Type: NodeText, Props: NodeProps{Content: strPtr("c"), Width: "10vw", Flex: floatPtr(1)}},
+		}},
+		{ID: 6, Type: NodeBox, Props: NodeProps{Height: "10vh", Position: PositionAbsolute, Left: "50%", Width: "25%"}},
+	}}
+	layouts := ComputeLayout(tree, 80, 20, CellLayoutOptions())
+
+	// Percentages are of the parent, or of the screen for the root;
+	// sized children keep their size whatever their flex.
+	for id, want := range map[int]ComputedLayout{
+		1: {Width: 40, Height: 20},
+		2: {Width: 40, Height: 10},
+		3: {Width: 10, Height: 10},
+		4: {X: 10, Width: 22, Height: 10},
+		5: {X: 32, Width: 8, Height: 10},
+		6: {X: 20, Width: 10, Height: 2},
+	} {
+		if got := *layouts[id]; got != want {
+			t.Errorf("node %d at %+v, want %+v", id, got, want)
+		}
+	}
+	if html := RenderHTML(tree); !strings.Contains(html, "left:50%") {
+		t.Errorf("html %s", html)
+	}
+	if _, err := Box().WidthOf("half").Build(); err == nil {
+		t.Error("built an unreadable width")
+	}
+}
+
+func TestSizeExpressions(t *testing.T) {
+	tree := NewRenderTree()
+	tree.Root = &RenderNode{ID: 1, Type: NodeBox, Props: NodeProps{Width: "100% - 20"}, Children: []*RenderNode{
+		{ID: 2, Type: NodeBox, Props: NodeProps{Width: "50% + 2ch", Height: "2lh"}},
+		{ID: 3, Type: NodeBox, Props: NodeProps{Width: "-(10 - 100%) * 2", Height: "calc((100vh - 4) / 2)"}},
+		{ID: 4, Type: NodeBox, Props: NodeProps{Position: PositionAbsolute, Left: "50% - 2ch", Top: "(100vh - 4) / 2", Width: 1, Height: 1}},
+	}}
+	layouts := ComputeLayout(tree, 80, 20, CellLayoutOptions())
+	for id, want := range map[int]ComputedLayout{
+		1: {Width: 60, Height: 20},
+		2: {Width: 32, Height: 2},
+		3: {Y: 2, Width: 100, Height: 8},
+		4: {X: 28, Y: 8, Width: 1, Height: 1},
+	} {
+		if got := *layouts[id]; got != want {
+			t.Errorf("node %d at %+v, want %+v", id, got, want)
+		}
+	}
+	if html := RenderHTML(tree); !strings.Contains(html, "left:calc(50% - 2ch)") || !strings.Contains(html, "top:calc((100vh - 4px) / 2)") {
+		t.Errorf("html %s", html)
+	}
+	for _, s := range []string{"50% +", "(2 * 3", "1px", "calc(1", "10 / 0%x"} {
+		if _, ok := parseSize(s); ok {
+			t.Errorf("parsed %q", s)
+		}
+	}
+	if _, err := Box().WidthOf("100% - 2ch").Build(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMinMaxConstraints(t *testing.T) {
+	tree := NewRenderTree()
+	tree.Root = &RenderNode{ID: 1, Type: NodeBox, Props: NodeProps{MaxWidth: intPtr(60)}, Children: []*RenderNode{
+		{ID: 2, Type: NodeBox, Props: NodeProps{Direction: "row", Height: 4}, Children: []*RenderNode{
+			{ID: 3, Type: NodeBox, Props: NodeProps{Flex: floatPtr(1), MaxWidth: intPtr(5)}},
+			{ID: 4, Type: NodeBox, Props: NodeProps{Flex: floatPtr(1)}},
+			{ID: 5, Type: NodeBox, Props: NodeProps{Flex: floatPtr(2), MinWidth: intPtr(40), MaxHeight: intPtr(2)}},
+			{ID: 6, Type: NodeText, Props: NodeProps{Content: strPtr("abc"), MinWidth: intPtr(6)}},
+		}},
+		{ID: 7, Type: NodeBox, Props: NodeProps{Width: 100, Height: 1, MinHeight: intPtr(3)}},
+	}}
+	layouts := ComputeLayout(tree, 80, 20, CellLayoutOptions())
+
+	// The root is held to its max; the row's 54 columns after the text's
+	// min go first as 13.5, 13.5, and 27, then with node 3 held at its
+	// max and node 5 at its min, node 4 takes what is left.
+	for id, want := range map[int]ComputedLayout{
+		1: {Width: 60, Height: 20},
+		3: {Width: 5, Height: 4},
+		4: {X: 5, Width: 9, Height: 4},
+		5: {X: 14, Width: 40, Height: 2},
+		6: {X: 54, Width: 6, Height: 4},
+		7: {Y: 4, Width: 100, Height: 3},
+	} {
+		if got := *layouts[id]; got != want {
+			t.Errorf("node %d at %+v, want %+v", id, got, want)
+		}
+	}
+}
+
+func TestBaselineAlign(t *testing.T) {
+	big := 28
+	tree := NewRenderTree()
+	tree.Root = &RenderNode{ID: 1, Type: NodeBox, Props: NodeProps{Direction: "row", Align: AlignBaseline}, Children: []*RenderNode{
+		{ID: 2, Type: NodeText, Props: NodeProps{Content: strPtr("Big"), Size: &big}},
+		{ID: 3, Type: NodeText, Props: NodeProps{Content: strPtr("small")}},
+		{ID: 4, Type: NodeBox, Props: NodeProps{Width: 10, Height: 10}},
+		{ID: 5, Type: NodeBox, Props: NodeProps{Padding: 8}, Children: []*RenderNode{
+			{ID: 6, Type: NodeText, Props: NodeProps{Content: strPtr("x")}},
+		}},
+	}}
+
+	// Baselines at 28 and 14 px down the big and small lines, and 8 more
+	// inside the padded box; the empty box sits on the baseline.
+	layouts := ComputeLayout(tree, 200, 100, PixelLayoutOptions())
+	for id, want := range map[int]float64{2: 0, 3: 14, 4: 18, 5: 6, 6: 14} {
+		if got := layouts[id].Y; got != want {
+			t.Errorf("node %d at y %g, want %g", id, got, want)
+		}
+	}
+	if h := layouts[3].Height; h != 20 {
+		t.Errorf("small text stretched to %g", h)
+	}
+
+	// On cells text is one size; the padded box's text is a row lower, and
+	// its siblings drop to meet it.
+	tree.Root.Children = append(tree.Root.Children[:2], tree.Root.Children[3])
+	if got, want := RenderGrid(tree, 20, 4, nil).String(), "\nBigsmall x"; got != want {
+		t.Errorf("grid %q, want prefix %q", got, want)
+	}
+}
+
+func TestChildrenSet(t *testing.T) {
+	text := func(id int, s string) *VNode {
+		return &VNode{ID: id, Type: NodeText, Props: NodeProps{Content: strPtr(s)}}
+	}
+	prev := &VNode{ID: 1, Type: NodeBox, Children: []*VNode{
+		{ID: 2, Type: NodeBox, Children: []*VNode{text(3, "a"), text(4, "b"), text(5, "c")}},
+	}}
+	next := &VNode{ID: 1, Type: NodeBox, Children: []*VNode{
+		{ID: 2, Type: NodeBox, Children: []*VNode{text(6, "x"), text(7, "y")}},
+	}}
+
+	// Children that are all new replace the old ones in one op.
+	ops, ok := DiffTree(prev, next)
+	if !ok || len(ops) != 1 || ops[0].ChildrenSet == nil || len(ops[0].ChildrenSet.Nodes) != 2 {
+		t.Fatalf("ops = %+v", ops)
+	}
+	tree := NewRenderTree()
+	SetTreeRoot(tree, prev)
+	if err := ApplyPatchesAtomic(tree, ops); err != nil {
+		t.Fatal(err)
+	}
+	if got := TextProjection(tree); got != "x\ny" {
+		t.Errorf("projection %q", got)
+	}
+	for id, want := range map[int]bool{3: false, 5: false, 6: true, 7: true} {
+		if _, ok := tree.NodeIndex[id]; ok != want {
+			t.Errorf("node %d indexed = %v, want %v", id, ok, want)
+		}
+	}
+
+	// An empty set clears the children, and survives encoding.
+	frame, err := EncodeFrame(&ProtocolMessage{Type: MsgPatch, Ops: []PatchOp{{Target: 2, ChildrenSet: &ChildrenSet{}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, payload, _ := DecodeFrame(frame)
+	msg, err := DecodeMessage(header, payload)
+	if err != nil || len(msg.Ops) != 1 || msg.Ops[0].ChildrenSet == nil {
+		t.Fatalf("decoded %+v, %v", msg, err)
+	}
+	if op := msg.Ops[0]; !ApplyPatch(tree, op) || len(tree.NodeIndex[2].Children) != 0 || tree.NodeIndex[6] != nil {
+		t.Errorf("children left: %+v", tree.NodeIndex[2].Children)
+	}
+
+	// A child moving under another parent keeps the diff to removals and
+	// inserts, so its ID is never indexed twice.
+	next.Children = append(next.Children, &VNode{ID: 8, Type: NodeBox, Children: []*VNode{text(3, "a")}})
+	ops, _ = DiffTree(prev, next)
+	for _, op := range ops {
+		if op.ChildrenSet != nil {
+			t.Errorf("set children of %d with a moved child", op.Target)
+		}
+	}
+}
+
+func TestUnsetProps(t *testing.T) {
+	tree := NewRenderTree()
+	SetTreeRoot(tree, &VNode{ID: 1, Type: NodeText, Props: NodeProps{
+		Content: strPtr("a"), Flex: floatPtr(1), Width: 10, MinWidth: intPtr(4),
+		Extra: map[string]interface{}{"custom": 1},
+	}})
+	if !ApplyPatch(tree, PatchOp{Target: 1, Unset: []string{"content", "flex", "width", "minWidth", "custom"}}) {
+		t.Fatal("unset failed")
+	}
+	if p := tree.Root.Props; p.Content != nil || p.Flex != nil || p.Width != nil || p.MinWidth != nil || len(p.Extra) != 0 {
+		t.Errorf("props left %+v", p)
+	}
+	// A Set of a key also unset wins.
+	ApplyPatch(tree, PatchOp{Target: 1, Unset: []string{"content"}, Set: map[string]interface{}{"content": "b"}})
+	if c := tree.Root.Props.Content; c == nil || *c != "b" {
+		t.Errorf("content %v", c)
+	}
+
+	// The differ unsets pointer props instead of replacing the node.
+	prev := &VNode{ID: 1, Type: NodeBox, Props: NodeProps{Flex: floatPtr(1), Padding: 8}}
+	next := &VNode{ID: 1, Type: NodeBox}
+	ops, _ := DiffTree(prev, next)
+	if len(ops) != 1 || ops[0].Replace != nil || len(ops[0].Unset) != 1 || ops[0].Unset[0] != "flex" {
+		t.Errorf("ops = %+v", ops)
+	} else if v, ok := ops[0].Set["padding"]; !ok || v != nil {
+		t.Errorf("padding cleared with %v", ops[0].Set)
+	}
+
+	// Sources drop unsets of props that are not set.
+	s := NewSourceState()
+	s.SetTree(prev)
+	s.Flush()
+	s.Patch([]PatchOp{{Target: 1, Unset: []string{"flex", "width"}}})
+	msgs := s.Flush()
+	if len(msgs) != 1 || len(msgs[0].Ops) != 1 || len(msgs[0].Ops[0].Unset) != 1 {
+		t.Fatalf("flush = %+v", msgs)
+	}
+	s.Patch([]PatchOp{{Target: 1, Unset: []string{"flex"}}})
+	if msgs := s.Flush(); msgs != nil {
+		t.Errorf("flush = %+v", msgs)
+	}
+}
+
+func TestChildrenRanges(t *testing.T) {
+	list := func(ids ...int) *VNode {
+		root := &VNode{ID: 1, Type: NodeBox}
+		for _, id := range ids {
+			root.Children = append(root.Children, &VNode{ID: id, Type: NodeText, Props: NodeProps{Content: strPtr(fmt.Sprint(id))}})
+		}
+		return root
+	}
+	childIDs := func(tree *RenderTree) []int {
+		var ids []int
+		for _, c := range tree.Root.Children {
+			ids = append(ids, c.ID)
+		}
+		return ids
+	}
+	apply := func(prev, next *VNode) ([]PatchOp, []int) {
+		ops, _ := DiffTree(prev, next)
+		tree := NewRenderTree()
+		SetTreeRoot(tree, prev)
+		if err := ApplyPatchesAtomic(tree, ops); err != nil {
+			t.Fatal(err)
+		}
+		return ops, childIDs(tree)
+	}
+
+	// A scrolled window drops a run from the top and moves a run to the
+	// front, one op each.
+	ops, got := apply(list(2, 3, 4, 5, 6, 7, 8), list(7, 8, 5, 6))
+	if want := []int{7, 8, 5, 6}; !reflect.DeepEqual(got, want) {
+		t.Errorf("children %v, want %v", got, want)
+	}
+	if len(ops) != 2 || ops[0].ChildrenRemoveRange == nil || *ops[0].ChildrenRemoveRange != (ChildrenRemoveRange{Index: 0, Count: 3}) ||
+		ops[1].ChildrenMoveRange == nil || *ops[1].ChildrenMoveRange != (ChildrenMoveRange{From: 2, Count: 2, To: 0}) {
+		t.Errorf("ops = %+v", ops)
+	}
+
+	// Any reordering with removals and insertions comes out right.
+	r := rand.New(rand.NewSource(1))
+	for n := 0; n < 200; n++ {
+		prev := r.Perm(12)[:r.Intn(12)]
+		next := append(r.Perm(12)[:r.Intn(12)], 12+r.Intn(3))
+		for i := range prev {
+			prev[i] += 2
+		}
+		for i := range next {
+			next[i] += 2
+		}
+		if _, got := apply(list(prev...), list(next...)); !reflect.DeepEqual(got, next) {
+			t.Fatalf("%v to %v gave %v", prev, next, got)
+		}
+	}
+
+	// Ranges out of bounds do nothing.
+	tree := NewRenderTree()
+	SetTreeRoot(tree, list(2, 3, 4))
+	ApplyPatch(tree, PatchOp{Target: 1, ChildrenMoveRange: &ChildrenMoveRange{From: 2, Count: 2, To: 0}})
+	ApplyPatch(tree, PatchOp{Target: 1, ChildrenRemoveRange: &ChildrenRemoveRange{Index: 3, Count: 1}})
+	if got := childIDs(tree); !reflect.DeepEqual(got, []int{2, 3, 4}) {
+		t.Errorf("children %v", got)
+	}
+}
+
+func TestInsertBySibling(t *testing.T) {
+	text := func(id int) *VNode {
+		return &VNode{ID: id, Type: NodeText, Props: NodeProps{Content: strPtr(fmt.Sprint(id))}}
+	}
+	tree := NewRenderTree()
+	SetTreeRoot(tree, &VNode{ID: 1, Type: NodeBox, Children: []*VNode{text(2), text(3), text(4)}})
+
+	// A removal racing ahead of the inserts shifts indexes but not
+	// siblings.
+	three, four := 3, 4
+	ApplyPatches(tree, []PatchOp{
+		{Target: 1, ChildrenRemove: &ChildrenRemove{Index: 0}},
+		{Target: 1, ChildrenInsert: &ChildrenInsert{Index: 2, Node: text(5), Before: &three}},
+		{Target: 1, ChildrenInsert: &ChildrenInsert{Index: 2, Node: text(6), After: &four}},
+	})
+	if got := TextProjection(tree); got != "5\n3\n4\n6" {
+		t.Errorf("projection %q", got)
+	}
+
+	// A sibling that is gone fails the insert.
+	gone := 2
+	if ApplyPatch(tree, PatchOp{Target: 1, ChildrenInsert: &ChildrenInsert{Node: text(7), After: &gone}}) || tree.NodeIndex[7] != nil {
+		t.Error("inserted after a missing sibling")
+	}
+	// And leaves the rest of the op unapplied.
+	gap := 1
+	tree.Root.Props.Gap = &gap
+	if ApplyPatch(tree, PatchOp{Target: 1, Set: map[string]interface{}{"padding": 2}, Unset: []string{"gap"},
+		ChildrenInsert: &ChildrenInsert{Node: text(7), Before: &gone}}) {
+		t.Error("inserted before a missing sibling")
+	}
+	if p := tree.Root.Props; p.Gap == nil || *p.Gap != 1 || p.Padding != nil {
+		t.Errorf("failed insert changed props: gap %v, padding %v", p.Gap, p.Padding)
+	}
+}
+
+func TestValidatePatches(t *testing.T) {
+	text := func(id int) *VNode {
+		return &VNode{ID: id, Type: NodeText, Props: NodeProps{Content: strPtr(fmt.Sprint(id))}}
+	}
+	tree := NewRenderTree()
+	SetTreeRoot(tree, &VNode{ID: 1, Type: NodeBox, Children: []*VNode{text(2), text(3)}})
+	before := TreeString(tree.Root)
+
+	// Later ops see what earlier ones did.
+	valid := []PatchOp{
+		{Target: 1, ChildrenInsert: &ChildrenInsert{Index: 2, Node: text(4)}},
+		{Target: 4, Set: map[string]interface{}{"content": "x", "width": "50%", "flex": 1, "custom": []int{1}}},
+		{Target: 1, ChildrenMoveRange: &ChildrenMoveRange{From: 1, Count: 2, To: 0}},
+		{Target: 2, Replace: text(2)},
+		{Target: 1, ChildrenSet: &ChildrenSet{Nodes: []*VNode{text(3), text(5)}}},
+	}
+	if err := ValidatePatches(tree, valid); err != nil {
+		t.Errorf("valid batch: %v", err)
+	}
+
+	two := 2
+	for _, tt := range []struct {
+		name string
+		ops  []PatchOp
+	}{
+		{"missing target", []PatchOp{{Target: 9, Set: map[string]interface{}{"content": "x"}}}},
+		{"removed target", []PatchOp{{Target: 1, ChildrenRemove: &ChildrenRemove{Index: 0}}, {Target: 2, Remove: true}}},
+		{"remove index", []PatchOp{{Target: 1, ChildrenRemove: &ChildrenRemove{Index: 2}}}},
+		{"insert index", []PatchOp{{Target: 1, ChildrenInsert: &ChildrenInsert{Index: 3, Node: text(4)}}}},
+		{"duplicate id", []PatchOp{{Target: 1, ChildrenInsert: &ChildrenInsert{Node: text(3)}}}},
+		{"missing sibling", []PatchOp{{Target: 1, ChildrenRemove: &ChildrenRemove{Index: 0}}, {Target: 1, ChildrenInsert: &ChildrenInsert{Node: text(4), After: &two}}}},
+		{"move range", []PatchOp{{Target: 1, ChildrenMoveRange: &ChildrenMoveRange{From: 1, Count: 2}}}},
+		{"value type", []PatchOp{{Target: 2, Set: map[string]interface{}{"content": 5}}}},
+		{"size", []PatchOp{{Target: 2, Set: map[string]interface{}{"width": "wide"}}}},
+	} {
+		if err := ValidatePatches(tree, tt.ops); !errors.Is(err, ErrInvalidPatch) {
+			t.Errorf("%s: err = %v", tt.name, err)
+		}
+	}
+	if after := TreeString(tree.Root); after != before {
+		t.Errorf("tree changed:\n%s\nwant\n%s", after, before)
+	}
+}
+
+func TestInvertPatch(t *testing.T) {
+	text := func(id int) *VNode {
+		return &VNode{ID: id, Type: NodeText, Props: NodeProps{Content: strPtr(fmt.Sprint(id))}}
+	}
+	build := func() *RenderTree {
+		tree := NewRenderTree()
+		SetTreeRoot(tree, &VNode{ID: 1, Type: NodeBox, Props: NodeProps{Padding: 8, Flex: floatPtr(2)}, Children: []*VNode{
+			text(2), text(3), {ID: 4, Type: NodeBox, Props: NodeProps{Extra: map[string]interface{}{"custom": "a"}}, Children: []*VNode{text(5)}},
+		}})
+		return tree
+	}
+	dump := func(tree *RenderTree) string {
+		var sb strings.Builder
+		WalkTree(tree.Root, func(n *RenderNode, depth int) {
+			fmt.Fprintf(&sb, "%*s%d %v\n", depth*2, "", n.ID, propValues(n.Props))
+		}, 0)
+		return sb.String()
+	}
+	three := 3
+	for _, op := range []PatchOp{
+		{Target: 1, Set: map[string]interface{}{"padding": 16, "flex": 1, "direction": "row"}},
+		{Target: 1, Unset: []string{"flex", "padding"}},
+		{Target: 4, Set: map[string]interface{}{"custom": "b", "other": 1}, Unset: []string{"custom"}},
+		{Target: 2, Set: map[string]interface{}{"transform": map[string]interface{}{"rotate": 90}, "width": "50%"}},
+		{Target: 1, ChildrenInsert: &ChildrenInsert{Node: text(6), After: &three}},
+		{Target: 1, ChildrenRemove: &ChildrenRemove{Index: 2}},
+		{Target: 1, ChildrenMove: &ChildrenMove{From: 0, To: 2}},
+		{Target: 1, ChildrenMoveRange: &ChildrenMoveRange{From: 1, Count: 2, To: 0}},
+		{Target: 1, ChildrenRemoveRange: &ChildrenRemoveRange{Index: 0, Count: 2}},
+		{Target: 1, ChildrenSet: &ChildrenSet{Nodes: []*VNode{text(7)}}, Set: map[string]interface{}{"gap": 2}},
+		{Target: 1, ChildrenInsert: &ChildrenInsert{Index: 0, Node: text(8)}, ChildrenRemove: &ChildrenRemove{Index: 3}},
+		{Target: 4, Remove: true},
+		{Target: 3, Replace: text(9)},
+	} {
+		tree := build()
+		want := dump(tree)
+		inv, err := InvertPatch(tree, op)
+		if err != nil {
+			t.Fatalf("%+v: %v", op, err)
+		}
+		if !ApplyPatch(tree, op) || !ApplyPatch(tree, inv) {
+			t.Fatalf("%+v then %+v did not apply", op, inv)
+		}
+		if got := dump(tree); got != want {
+			t.Errorf("%+v undone by %+v gave\n%s\nwant\n%s", op, inv, got, want)
+		}
+		if len(tree.NodeIndex) != 5 {
+			t.Errorf("%+v: %d nodes indexed after undo", op, len(tree.NodeIndex))
+		}
+	}
+
+	if _, err := InvertPatch(build(), PatchOp{Target: 1, Remove: true}); !errors.Is(err, ErrInvalidPatch) {
+		t.Errorf("inverted removing the root: %v", err)
+	}
+}
+
+func TestOptimizePatches(t *testing.T) {
+	text := func(id int) *VNode {
+		return &VNode{ID: id, Type: NodeText, Props: NodeProps{Content: strPtr(fmt.Sprint(id))}}
+	}
+	build := func() *RenderTree {
+		tree := NewRenderTree()
+		SetTreeRoot(tree, &VNode{ID: 1, Type: NodeBox, Children: []*VNode{
+			{ID: 2, Type: NodeBox, Children: []*VNode{text(3), text(4), text(5)}},
+			{ID: 6, Type: NodeBox, Children: []*VNode{text(7)}},
+		}})
+		return tree
+	}
+	dump := func(tree *RenderTree) string {
+		var sb strings.Builder
+		WalkTree(tree.Root, func(n *RenderNode, depth int) {
+			fmt.Fprintf(&sb, "%*s%d %v\n", depth*2, "", n.ID, propValues(n.Props))
+		}, 0)
+		return sb.String()
+	}
+	check := func(ops []PatchOp, want int) {
+		t.Helper()
+		opt := OptimizePatches(ops)
+		a, b := build(), build()
+		_, failedBefore := ApplyPatches(a, ops)
+		if _, failed := ApplyPatches(b, opt); failed > failedBefore {
+			t.Errorf("%d optimized ops failed, from %d", failed, failedBefore)
+		}
+		if dump(a) != dump(b) {
+			t.Errorf("optimized %+v to %+v: got\n%s\nwant\n%s", ops, opt, dump(b), dump(a))
+		}
+		if want >= 0 && len(opt) != want {
+			t.Errorf("optimized %d ops to %+v, want %d ops", len(ops), opt, want)
+		}
+	}
+
+	// Sets and unsets on a node merge, the last word on each key winning.
+	check([]PatchOp{
+		{Target: 3, Set: map[string]interface{}{"content": "a", "flex": 1}},
+		{Target: 7, Set: map[string]interface{}{"content": "b"}},
+		{Target: 3, Unset: []string{"flex"}, Set: map[string]interface{}{"width": 4}},
+		{Target: 3, Set: map[string]interface{}{"flex": 2}, Unset: []string{"width"}},
+	}, 2)
+	// A node inserted and removed, with ops on it between, vanishes.
+	check([]PatchOp{
+		{Target: 6, ChildrenInsert: &ChildrenInsert{Index: 0, Node: &VNode{ID: 8, Type: NodeBox, Children: []*VNode{text(9)}}}},
+		{Target: 9, Set: map[string]interface{}{"content": "x"}},
+		{Target: 8, ChildrenInsert: &ChildrenInsert{Node: text(10)}},
+		{Target: 10, Set: map[string]interface{}{"content": "y"}},
+		{Target: 3, Set: map[string]interface{}{"content": "z"}},
+		{Target: 8, Remove: true},
+	}, 1)
+	// Not if its parent's children change in between.
+	check([]PatchOp{
+		{Target: 6, ChildrenInsert: &ChildrenInsert{Index: 0, Node: text(8)}},
+		{Target: 6, ChildrenMove: &ChildrenMove{From: 1, To: 0}},
+		{Target: 8, Remove: true},
+	}, 3)
+	// Moves chain, and vanish if they come back.
+	check([]PatchOp{
+		{Target: 2, ChildrenMove: &ChildrenMove{From: 0, To: 2}},
+		{Target: 2, ChildrenMove: &ChildrenMove{From: 2, To: 1}},
+		{Target: 6, ChildrenMove: &ChildrenMove{From: 0, To: 0}},
+	}, 1)
+	check([]PatchOp{
+		{Target: 2, ChildrenMove: &ChildrenMove{From: 0, To: 2}},
+		{Target: 2, ChildrenMove: &ChildrenMove{From: 2, To: 0}},
+	}, 0)
+	// Nor across a removal, which may have shifted the indexes.
+	check([]PatchOp{
+		{Target: 2, ChildrenInsert: &ChildrenInsert{Index: 3, Node: text(8)}},
+		{Target: 2, ChildrenMove: &ChildrenMove{From: 0, To: 2}},
+		{Target: 5, Remove: true},
+		{Target: 2, ChildrenMove: &ChildrenMove{From: 2, To: 0}},
+	}, 4)
+	// Sets are not merged over a structural change or a reinserted ID.
+	check([]PatchOp{
+		{Target: 3, Set: map[string]interface{}{"content": "a"}},
+		{Target: 2, ChildrenRemove: &ChildrenRemove{Index: 0}},
+		{Target: 6, ChildrenInsert: &ChildrenInsert{Node: text(3)}},
+		{Target: 3, Set: map[string]interface{}{"flex": 1}},
+		{Target: 2, Set: map[string]interface{}{"gap": 1}},
+		{Target: 2, ChildrenMove: &ChildrenMove{From: 0, To: 1}},
+		{Target: 2, Set: map[string]interface{}{"gap": 2}},
+	}, 7)
+
+	// Random batches leave the same tree.
+	r := rand.New(rand.NewSource(1))
+	for n := 0; n < 300; n++ {
+		var ops []PatchOp
+		next := 20
+		for k := 0; k < 8; k++ {
+			target := 2 + 4*r.Intn(2)
+			switch r.Intn(5) {
+			case 0:
+				ops = append(ops, PatchOp{Target: target, ChildrenMove: &ChildrenMove{From: r.Intn(2), To: r.Intn(2)}})
+			case 1:
+				ops = append(ops, PatchOp{Target: target, ChildrenInsert: &ChildrenInsert{Index: r.Intn(2), Node: text(next)}})
+				next++
+			case 2:
+				ops = append(ops, PatchOp{Target: 3 + r.Intn(next-3), Remove: true})
+			case 3:
+				ops = append(ops, PatchOp{Target: 3 + r.Intn(next-3), Set: map[string]interface{}{"content": fmt.Sprint(k)}})
+			default:
+				ops = append(ops, PatchOp{Target: 3 + r.Intn(next-3), Unset: []string{"content"}})
+			}
+		}
+		check(ops, -1)
+	}
+}
+
+func TestTreeHash(t *testing.T) {
+	s := NewSourceState()
+	s.HashEvery = 1
+	v := NewViewer(HeadlessTarget{})
+	var resyncs []ProtocolMessage
+	v.OnMessage(func(msg ProtocolMessage) {
+		if msg.Type == MsgRefresh {
+			resyncs = append(resyncs, msg)
+		}
+	})
+	// deliver sends a flush over the wire without sequence numbers, so
+	// only the hash can reveal a lost frame.
+	deliver := func(msgs []ProtocolMessage) {
+		t.Helper()
+		for _, msg := range msgs {
+			msg.Seq = nil
+			frame, err := EncodeFrame(&msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			header, payload, err := DecodeFrame(frame)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := DecodeMessage(header, payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			v.ProcessMessage(decoded)
+		}
+	}
+
+	s.SetTree(makeSimpleTree())
+	msgs := s.Flush()
+	if last := msgs[len(msgs)-1]; last.Type != MsgHash || last.Hash == nil || *last.Hash != TreeHash(s.Published()) {
+		t.Fatalf("flush should end with the published tree's hash, got %+v", last)
+	}
+	deliver(msgs)
+	s.Patch([]PatchOp{{Target: 2, Set: map[string]interface{}{"content": "One", "width": 10, "opacity": 0.5}}})
+	deliver(s.Flush())
+	if len(resyncs) != 0 {
+		t.Fatalf("matching trees should not resync, got %d requests", len(resyncs))
+	}
+	if TreeHash(v.tree) != TreeHash(s.Published()) {
+		t.Fatal("decoded tree should hash the same as the published one")
+	}
+
+	// Props the viewer owns do not count.
+	value := "typed"
+	v.tree.NodeIndex[2].Props.Value = &value
+	s.Patch([]PatchOp{{Target: 2, Set: map[string]interface{}{"content": "Two"}}})
+	deliver(s.Flush())
+	if len(resyncs) != 0 {
+		t.Fatal("viewer-owned props should not cause a mismatch")
+	}
+
+	// Nor do the table selection and scroll offsets input moves.
+	schema := 7
+	s.DefineSchema(uint32(schema), []SchemaColumn{{Name: "n", Type: "int"}})
+	for i := 0; i < 3; i++ {
+		s.EmitData(uint32(schema), []interface{}{i})
+	}
+	tree := makeSimpleTree()
+	tree.Children = append(tree.Children,
+		&VNode{ID: 20, Type: NodeTable, Props: NodeProps{Schema: &schema, Height: 40}},
+		&VNode{ID: 21, Type: NodeScroll, Props: NodeProps{Direction: "row", Width: 50, Height: 20}, Children: []*VNode{
+			{ID: 22, Type: NodeBox, Props: NodeProps{Width: 100, Height: 10}},
+			{ID: 23, Type: NodeBox, Props: NodeProps{Width: 100, Height: 10}},
+		}})
+	s.SetTree(tree)
+	deliver(s.Flush())
+	if !v.Focus(20) || !v.Key("ArrowDown") || v.GetTree().NodeIndex[20].Props.SelectedRow == nil {
+		t.Fatal("table did not take the selection")
+	}
+	if !v.ScrollIntoView(23) || v.GetTree().NodeIndex[21].Props.ScrollLeft == nil {
+		t.Fatal("container did not scroll sideways")
+	}
+	s.Patch([]PatchOp{{Target: 2, Set: map[string]interface{}{"content": "Still"}}})
+	deliver(s.Flush())
+	if len(resyncs) != 0 {
+		t.Fatal("selection or horizontal scroll caused a mismatch")
+	}
+
+	// A lost patch is caught by the next hash.
+	s.Patch([]PatchOp{{Target: 2, Set: map[string]interface{}{"content": "Lost"}}})
+	s.Flush()
+	s.Patch([]PatchOp{{Target: 1, Set: map[string]interface{}{"gap": 2}}})
+	deliver(s.Flush())
+	if len(resyncs) != 1 {
+		t.Fatalf("resync requests = %d, want 1", len(resyncs))
+	}
+	if m := v.GetMetrics(); m.HashMismatches != 1 || m.ResyncRequests != 1 {
+		t.Errorf("hashMismatches = %d, resyncRequests = %d, want 1, 1", m.HashMismatches, m.ResyncRequests)
+	}
+
+	s.HandleControl(resyncs[0])
+	deliver(s.Flush())
+	if !containsStr(v.GetTextProjection(), "Lost") {
+		t.Error("resync should bring the viewer up to date")
+	}
+	s.Patch([]PatchOp{{Target: 2, Set: map[string]interface{}{"content": "Three"}}})
+	deliver(s.Flush())
+	if len(resyncs) != 1 || !containsStr(v.GetTextProjection(), "Three") {
+		t.Errorf("patches should apply again after the resync, %d requests", len(resyncs))
+	}
+	s.Patch([]PatchOp{{Target: 2, Set: map[string]interface{}{"content": "Lost again"}}})
+	s.Flush()
+	s.Patch([]PatchOp{{Target: 1, Set: map[string]interface{}{"gap": 3}}})
+	deliver(s.Flush())
+	if len(resyncs) != 2 {
+		t.Errorf("hashes should verify again after the resync, %d requests", len(resyncs))
+	}
+
+}
+
+func TestRefresh(t *testing.T) {
+	s := NewSourceState()
+	s.DefineSlot(1, ColorSlot{Kind: "color", Role: "accent", Value: "#ff0000"})
+	s.DefineSchema(2, []SchemaColumn{{Name: "n", Type: "int"}})
+	s.SetTree(makeSimpleTree())
+	v := NewViewer(HeadlessTarget{})
+	var control []ProtocolMessage
+	v.OnMessage(func(msg ProtocolMessage) {
+		if msg.Type == MsgRefresh || msg.Type == MsgResync {
+			control = append(control, msg)
+		}
+	})
+	encode := func(msgs []ProtocolMessage) []byte {
+		t.Helper()
+		var out []byte
+		for i := range msgs {
+			frame, err := EncodeFrame(&msgs[i])
+			if err != nil {
+				t.Fatal(err)
+			}
+			out = append(out, frame...)
+		}
+		return out
+	}
+	if err := v.Serve(bytes.NewReader(encode(s.Flush()))); err != nil {
+		t.Fatal(err)
+	}
+	if len(control) != 0 {
+		t.Fatalf("first connection should not refresh, got %+v", control)
+	}
+
+	// A frame that fails to decode asks for a refresh; patches are
+	// dropped until it arrives.
+	bad := []byte{0x56, 0x50, 1, byte(MsgPatch), 1, 0, 0, 0, 0xff}
+	s.Patch([]PatchOp{{Target: 2, Set: map[string]interface{}{"content": "Dropped"}}})
+	if err := v.Serve(bytes.NewReader(append(bad, encode(s.Flush())...))); err != nil {
+		t.Fatal(err)
+	}
+	if len(control) != 1 || control[0].Type != MsgRefresh {
+		t.Fatalf("decode error should request a refresh, got %+v", control)
+	}
+	if m := v.GetMetrics(); m.DecodeErrors != 1 {
+		t.Errorf("decodeErrors = %d, want 1", m.DecodeErrors)
+	}
+	if containsStr(v.GetTextProjection(), "Dropped") {
+		t.Error("patches should be dropped while a refresh is pending")
+	}
+
+	// The source resends slots and schemas along with the tree.
+	v.ProcessMessage(ProtocolMessage{Type: MsgDefine, Slot: intPtr(1), SlotValue: ColorSlot{Kind: "color", Role: "accent", Value: "#000000"}})
+	s.HandleControl(control[0])
+	msgs := s.Flush()
+	var types []MessageType
+	for _, msg := range msgs {
+		types = append(types, msg.Type)
+	}
+	if !reflect.DeepEqual(types, []MessageType{MsgDefine, MsgSchema, MsgTree}) {
+		t.Fatalf("refresh flush = %v, want DEFINE SCHEMA TREE", types)
+	}
+	for _, msg := range msgs {
+		v.ProcessMessage(msg)
+	}
+	if c, ok := v.tree.Slots[1].(ColorSlot); !ok || c.Value != "#ff0000" {
+		t.Errorf("slot 1 = %+v, want resent value", v.tree.Slots[1])
+	}
+	if !containsStr(v.GetTextProjection(), "Dropped") {
+		t.Error("refresh should bring the tree up to date")
+	}
+
+	// Reconnecting with state asks for a refresh and accepts the new
+	// connection's sequence numbers from the start.
+	control = nil
+	s2 := NewSourceState()
+	s2.SetTree(makeSimpleTree())
+	if err := v.Serve(bytes.NewReader(encode(s2.Flush()))); err != nil {
+		t.Fatal(err)
+	}
+	if len(control) != 1 || control[0].Type != MsgRefresh {
+		t.Fatalf("reconnect should request a refresh, got %+v", control)
+	}
+	s2.Patch([]PatchOp{{Target: 2, Set: map[string]interface{}{"content": "Reconnected"}}})
+	v.ProcessMessage(s2.Flush()[0])
+	if !containsStr(v.GetTextProjection(), "Reconnected") {
+		t.Error("patches should apply after the new connection's tree")
+	}
+
+	// Embedders can ask directly; a pending refresh is not repeated.
+	v.RequestRefresh()
+	v.RequestRefresh()
+	if len(control) != 2 {
+		t.Errorf("RequestRefresh sent %d messages, want 1", len(control)-1)
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	clock := &ManualClock{T: time.Unix(1000, 0)}
+	v := NewViewer(HeadlessTarget{})
+	v.SetClock(clock)
+	v.Resize(80, 24)
+	v.EnableHeartbeat(HeartbeatConfig{Interval: time.Second})
+	var pings []ProtocolMessage
+	v.OnMessage(func(msg ProtocolMessage) {
+		if msg.Type == MsgPing || msg.Type == MsgPong {
+			pings = append(pings, msg)
+		}
+	})
+	s := NewSourceState()
+	deliver := func(msg ProtocolMessage) ProtocolMessage {
+		t.Helper()
+		frame, err := EncodeFrame(&msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		header, payload, err := DecodeFrame(frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := DecodeMessage(header, payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return decoded
+	}
+
+	if err := v.checkHeartbeat(); err != nil || len(pings) != 1 || pings[0].Type != MsgPing {
+		t.Fatalf("first check should ping, got %v, %+v", err, pings)
+	}
+	s.HandleControl(deliver(pings[0]))
+	pongs := s.Flush()
+	if len(pongs) != 1 || pongs[0].Type != MsgPong || *pongs[0].Nonce != *pings[0].Nonce || pongs[0].Seq != nil {
+		t.Fatalf("source should answer with an unsequenced pong, got %+v", pongs)
+	}
+	clock.Advance(40 * time.Millisecond)
+	v.ProcessMessage(deliver(pongs[0]))
+	if env := v.GetEnv(); env.LatencyMs != 40 {
+		t.Errorf("env latency = %v, want 40", env.LatencyMs)
+	}
+
+	// The next ping waits for the interval; later answers are smoothed.
+	clock.Advance(500 * time.Millisecond)
+	v.checkHeartbeat()
+	if len(pings) != 1 {
+		t.Fatal("ping sent before the interval")
+	}
+	clock.Advance(500 * time.Millisecond)
+	v.checkHeartbeat()
+	if len(pings) != 2 {
+		t.Fatal("ping not sent after the interval")
+	}
+	clock.Advance(120 * time.Millisecond)
+	v.ProcessMessage(ProtocolMessage{Type: MsgPong, Nonce: pings[1].Nonce})
+	if m := v.GetMetrics(); m.LatencyMs != 50 {
+		t.Errorf("smoothed latency = %v, want 50", m.LatencyMs)
+	}
+
+	// An unanswered ping times out after three intervals.
+	clock.Advance(time.Second)
+	v.checkHeartbeat()
+	clock.Advance(2 * time.Second)
+	if err := v.checkHeartbeat(); err != nil {
+		t.Fatalf("timed out early: %v", err)
+	}
+	clock.Advance(time.Second)
+	if err := v.checkHeartbeat(); !errors.Is(err, ErrHeartbeatTimeout) {
+		t.Fatalf("err = %v, want ErrHeartbeatTimeout", err)
+	}
+	if m := v.GetMetrics(); m.HeartbeatTimeouts != 1 {
+		t.Errorf("heartbeatTimeouts = %d, want 1", m.HeartbeatTimeouts)
+	}
+
+	// The viewer answers the source's pings.
+	nonce := uint64(7)
+	pings = nil
+	v.ProcessMessage(ProtocolMessage{Type: MsgPing, Nonce: &nonce})
+	if len(pings) != 1 || pings[0].Type != MsgPong || *pings[0].Nonce != 7 {
+		t.Errorf("viewer should pong, got %+v", pings)
+	}
+
+	// ServeCtx gives up on a connection that goes quiet.
+	quiet := NewViewer(HeadlessTarget{})
+	quiet.EnableHeartbeat(HeartbeatConfig{Interval: 5 * time.Millisecond})
+	r, w := io.Pipe()
+	defer w.Close()
+	done := make(chan error, 1)
+	go func() { done <- quiet.Serve(r) }()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrHeartbeatTimeout) {
+			t.Errorf("Serve = %v, want ErrHeartbeatTimeout", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve did not detect the dead connection")
+	}
+}
+
+func TestErrorReports(t *testing.T) {
+	v := NewViewer(HeadlessTarget{})
+	var reports []ErrorReport
+	v.OnMessage(func(msg ProtocolMessage) {
+		if msg.Type != MsgError {
+			return
+		}
+		// Reports survive the wire.
+		frame, err := EncodeFrame(&msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		header, payload, err := DecodeFrame(frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := DecodeMessage(header, payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reports = append(reports, *decoded.Error)
+	})
+
+	v.ProcessMessage(ProtocolMessage{Type: MsgTree, Root: makeSimpleTree()})
+	v.ProcessMessage(ProtocolMessage{Type: MsgPatch, Ops: []PatchOp{
+		{Target: 2, Set: map[string]interface{}{"content": "ok"}},
+		{Target: 99, Set: map[string]interface{}{"content": "missing"}},
+	}})
+	if len(reports) != 1 {
+		t.Fatalf("reports = %+v, want one patch failure", reports)
+	}
+	if r := reports[0]; r.Code != ErrorCodePatch || r.MsgType != MsgPatch || r.Target == nil || *r.Target != 99 || r.Op == nil || *r.Op != 1 {
+		t.Errorf("patch report = %+v", r)
+	}
+
+	v.SetAtomicPatches(true)
+	v.ProcessMessage(ProtocolMessage{Type: MsgPatch, Ops: []PatchOp{
+		{Target: 2, Set: map[string]interface{}{"content": "rolled back"}},
+		{Target: 98, Remove: true},
+	}})
+	if r := reports[len(reports)-1]; len(reports) != 2 || *r.Target != 98 || *r.Op != 1 {
+		t.Errorf("atomic patch report = %+v", r)
+	}
+
+	v.SetQuotas(Quotas{MaxSlots: 1}, nil)
+	v.ProcessMessage(ProtocolMessage{Type: MsgDefine, Slot: intPtr(1), SlotValue: ColorSlot{Kind: "color", Value: "#fff"}})
+	v.ProcessMessage(ProtocolMessage{Type: MsgDefine, Slot: intPtr(2), SlotValue: ColorSlot{Kind: "color", Value: "#000"}})
+	if r := reports[len(reports)-1]; len(reports) != 3 || r.Code != ErrorCodeQuota || r.MsgType != MsgDefine || r.Slot == nil || *r.Slot != 2 {
+		t.Errorf("quota report = %+v", r)
+	}
+
+	v.Resize(80, 24)
+	v.ProcessMessage(ProtocolMessage{Type: MsgRequire, Requires: &Requirements{MinDisplayWidth: 200}})
+	if r := reports[len(reports)-1]; len(reports) != 4 || r.Code != ErrorCodeIncompatible {
+		t.Errorf("requirements report = %+v", r)
+	}
+
+	bad := []byte{0x56, 0x50, 1, byte(MsgData), 1, 0, 0, 0, 0xff}
+	v.Serve(bytes.NewReader(bad))
+	if r := reports[len(reports)-1]; len(reports) != 5 || r.Code != ErrorCodeDecode || r.MsgType != MsgData {
+		t.Errorf("decode report = %+v", r)
+	}
+
+	// A source repairs a failed patch by resending its tree.
+	s := NewSourceState()
+	s.SetTree(makeSimpleTree())
+	s.Flush()
+	if !s.HandleControl(ProtocolMessage{Type: MsgError, Error: &reports[0]}) {
+		t.Fatal("MsgError should be a control message")
+	}
+	if msgs := s.Flush(); len(msgs) != 1 || msgs[0].Type != MsgTree {
+		t.Errorf("flush after a patch failure = %+v, want the tree", msgs)
+	}
+}
+
+func TestMetricsReport(t *testing.T) {
+	clock := &ManualClock{T: time.Unix(1000, 0)}
+	v := NewViewer(HeadlessTarget{})
+	v.SetClock(clock)
+	v.ReportMetrics(time.Second)
+	var reports []ProtocolMessage
+	v.OnMessage(func(msg ProtocolMessage) {
+		if msg.Type == MsgMetrics {
+			reports = append(reports, msg)
+		}
+	})
+
+	v.ProcessMessage(ProtocolMessage{Type: MsgTree, Root: makeSimpleTree()})
+	v.checkMetricsReport()
+	clock.Advance(500 * time.Millisecond)
+	v.checkMetricsReport()
+	if len(reports) != 1 {
+		t.Fatalf("reports = %d, want 1 within the interval", len(reports))
+	}
+	clock.Advance(500 * time.Millisecond)
+	v.checkMetricsReport()
+	if len(reports) != 2 {
+		t.Fatalf("reports = %d, want 2 after the interval", len(reports))
+	}
+
+	frame, err := EncodeFrame(&reports[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, payload, err := DecodeFrame(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := DecodeMessage(header, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewSourceState()
+	if !s.HandleControl(msg) || s.ViewerMetrics == nil {
+		t.Fatal("source should keep the reported metrics")
+	}
+	if got := s.ViewerMetrics; got.TreeNodeCount != v.GetMetrics().TreeNodeCount || got.MessagesProcessed != 1 {
+		t.Errorf("reported metrics = %+v", got)
+	}
+
+	// ServeCtx sends reports while serving.
+	live := NewViewer(HeadlessTarget{})
+	live.ReportMetrics(5 * time.Millisecond)
+	got := make(chan struct{}, 16)
+	live.OnMessage(func(msg ProtocolMessage) {
+		if msg.Type == MsgMetrics {
+			select {
+			case got <- struct{}{}:
+			default:
+			}
+		}
+	})
+	r, w := io.Pipe()
+	go live.Serve(r)
+	defer w.Close()
+	for i := 0; i < 2; i++ {
+		select {
+		case <-got:
+		case <-time.After(5 * time.Second):
+			t.Fatal("no metrics report while serving")
+		}
+	}
+}
+
+func TestInputLatency(t *testing.T) {
+	clock := &ManualClock{T: time.Unix(1000, 0)}
+	v := NewViewer(HeadlessTarget{})
+	v.SetClock(clock)
+	var events []InputEvent
+	v.OnMessage(func(msg ProtocolMessage) {
+		if msg.Type == MsgInput {
+			events = append(events, *msg.Event)
+		}
+	})
+	s := NewSourceState()
+	s.SetTree(makeSimpleTree())
+	for _, msg := range s.Flush() {
+		v.ProcessMessage(msg)
+	}
+	// respond echoes the last event in a patch sent over the wire as one
+	// batch, after the given delay.
+	respond := func(content string, delay time.Duration) {
+		t.Helper()
+		s.Echo(events[len(events)-1].Echo)
+		s.Patch([]PatchOp{{Target: 2, Set: map[string]interface{}{"content": content}}})
+		s.EmitData(0, []interface{}{content})
+		batch := NewBatch(s.Flush())
+		frame, err := EncodeFrame(&batch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		header, payload, err := DecodeFrame(frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, err := DecodeMessage(header, payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		clock.Advance(delay)
+		v.ProcessMessage(msg)
+	}
+
+	v.SendInput(InputEvent{Kind: "click", Target: intPtr(1)})
+	if len(events) != 1 || events[0].Echo == 0 || events[0].Time != clock.T.UnixMilli() {
+		t.Fatalf("events = %+v, want one stamped event", events)
+	}
+	respond("one", 30*time.Millisecond)
+	if m := v.GetMetrics(); m.InputLatencyMs != 30 {
+		t.Errorf("input latency = %v, want 30", m.InputLatencyMs)
+	}
+
+	v.SendInput(InputEvent{Kind: "click", Target: intPtr(1)})
+	if events[1].Echo == events[0].Echo {
+		t.Error("each event should get its own token")
+	}
+	respond("two", 50*time.Millisecond)
+	m := v.GetMetrics()
+	if m.InputLatencyMs != 50 || m.AvgInputLatencyMs != 40 || m.PeakInputLatencyMs != 50 {
+		t.Errorf("latency last/avg/peak = %v/%v/%v, want 50/40/50", m.InputLatencyMs, m.AvgInputLatencyMs, m.PeakInputLatencyMs)
+	}
+
+	// An answered token, and frames without one, measure nothing.
+	respond("three", time.Second)
+	s.Patch([]PatchOp{{Target: 2, Set: map[string]interface{}{"content": "four"}}})
+	for _, msg := range s.Flush() {
+		if msg.Echo != nil {
+			t.Error("echo should only ride on the next flush")
+		}
+		v.ProcessMessage(msg)
+	}
+	if m := v.GetMetrics(); m.PeakInputLatencyMs != 50 {
+		t.Errorf("peak = %v, want 50", m.PeakInputLatencyMs)
+	}
+}
+
+func TestMessageTimestamps(t *testing.T) {
+	clock := &ManualClock{T: time.Unix(1000, 0)}
+	v := NewViewer(HeadlessTarget{})
+	v.SetClock(clock)
+	v.Resize(80, 24)
+	s := NewSourceState()
+	s.Timestamps = true
+	s.Clock = clock
+	send := func(delay time.Duration) {
+		t.Helper()
+		msgs := s.Flush()
+		if last := msgs[len(msgs)-1]; last.Sent == nil || *last.Sent != clock.T.UnixMicro() {
+			t.Fatalf("last message should carry the send time, got %+v", last)
+		}
+		batch := NewBatch(msgs)
+		frame, err := EncodeFrame(&batch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		header, payload, err := DecodeFrame(frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, err := DecodeMessage(header, payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		clock.Advance(delay)
+		v.ProcessMessage(msg)
+	}
+
+	s.DefineSlot(1, ColorSlot{Kind: "color", Value: "#fff"})
+	s.SetTree(makeSimpleTree())
+	send(20 * time.Millisecond)
+	if m := v.GetMetrics(); m.TransportDelayMs != 20 {
+		t.Errorf("transport delay = %v, want 20", m.TransportDelayMs)
+	}
+	s.Patch([]PatchOp{{Target: 2, Set: map[string]interface{}{"content": "later"}}})
+	send(36 * time.Millisecond)
+	if m := v.GetMetrics(); m.TransportDelayMs != 22 {
+		t.Errorf("smoothed delay = %v, want 22", m.TransportDelayMs)
+	}
+	if env := v.GetEnv(); env.LatencyMs != 22 {
+		t.Errorf("env latency = %v, want 22", env.LatencyMs)
+	}
+
+	// Timestamps take precedence over heartbeat round trips.
+	v.EnableHeartbeat(HeartbeatConfig{Interval: time.Second})
+	v.checkHeartbeat()
+	clock.Advance(300 * time.Millisecond)
+	nonce := uint64(1)
+	v.ProcessMessage(ProtocolMessage{Type: MsgPong, Nonce: &nonce})
+	if m, env := v.GetMetrics(), v.GetEnv(); m.LatencyMs != 300 || env.LatencyMs != 22 {
+		t.Errorf("round trip %v should not replace env latency %v", m.LatencyMs, env.LatencyMs)
+	}
+
+	// A stamp from the future counts as no delay.
+	s.Clock = &ManualClock{T: clock.T.Add(time.Hour)}
+	s.Patch([]PatchOp{{Target: 2, Set: map[string]interface{}{"content": "skewed"}}})
+	msgs := s.Flush()
+	v.ProcessMessage(msgs[len(msgs)-1])
+	if m := v.GetMetrics(); m.TransportDelayMs >= 22 {
+		t.Errorf("future stamp should pull the delay down, got %v", m.TransportDelayMs)
+	}
+}
+
+func TestClockSync(t *testing.T) {
+	start := time.UnixMicro(time.Now().UnixMicro()) // pongs carry microseconds
+	viewerClock := &ManualClock{T: start}
+	sourceClock := &ManualClock{T: start.Add(time.Hour)}
+	advance := func(d time.Duration) {
+		viewerClock.Advance(d)
+		sourceClock.Advance(d)
+	}
+	v := NewViewer(HeadlessTarget{})
+	v.SetClock(viewerClock)
+	var pings []ProtocolMessage
+	v.OnMessage(func(msg ProtocolMessage) {
+		if msg.Type == MsgPing {
+			pings = append(pings, msg)
+		}
+	})
+	s := NewSourceState()
+	s.Clock = sourceClock
+	// exchange runs one ping and pong with the given delays each way.
+	exchange := func(out, back time.Duration) {
+		t.Helper()
+		v.SyncClock()
+		advance(out)
+		s.HandleControl(pings[len(pings)-1])
+		pongs := s.Flush()
+		if len(pongs) != 1 || pongs[0].Sent == nil {
+			t.Fatalf("pong should carry the source's clock, got %+v", pongs)
+		}
+		frame, err := EncodeFrame(&pongs[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		header, payload, err := DecodeFrame(frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, err := DecodeMessage(header, payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		advance(back)
+		v.ProcessMessage(msg)
+	}
+
+	exchange(20*time.Millisecond, 20*time.Millisecond)
+	if got := v.ClockOffset(); got != time.Hour {
+		t.Fatalf("offset = %v, want 1h", got)
+	}
+	// A slower, lopsided exchange is outweighed by the faster one.
+	exchange(10*time.Millisecond, 200*time.Millisecond)
+	if got := v.ClockOffset(); got != time.Hour {
+		t.Errorf("offset = %v, want the shortest round trip's 1h", got)
+	}
+	if m := v.GetMetrics(); m.TransportDelayMs != 0 {
+		t.Errorf("pongs should not count as timestamped messages, delay = %v", m.TransportDelayMs)
+	}
+
+	// Timestamps are read by the source's clock.
+	s.Timestamps = true
+	s.SetTree(makeSimpleTree())
+	msgs := s.Flush()
+	advance(15 * time.Millisecond)
+	for _, msg := range msgs {
+		v.ProcessMessage(msg)
+	}
+	if m := v.GetMetrics(); m.TransportDelayMs != 15 {
+		t.Errorf("transport delay = %v, want 15", m.TransportDelayMs)
+	}
+
+	// So are relative_time columns.
+	schema := 1
+	v.ProcessMessage(ProtocolMessage{Type: MsgSchema, Slot: &schema, Columns: []SchemaColumn{{Name: "when", Type: "number", Format: "relative_time"}}})
+	v.ProcessMessage(ProtocolMessage{Type: MsgData, Schema: &schema, Row: []interface{}{float64(time.Now().Add(time.Hour).Unix() - 7200)}})
+	v.ProcessMessage(ProtocolMessage{Type: MsgPatch, Ops: []PatchOp{{Target: 1, ChildrenInsert: &ChildrenInsert{Index: 0, Node: &VNode{ID: 9, Type: NodeTable, Props: NodeProps{Schema: &schema}}}}}})
+	if text := v.GetTextProjection(); !containsStr(text, "2h ago") {
+		t.Errorf("projection = %q, want the row two hours old by the source's clock", text)
+	}
+
+	// A new connection may be to a different source.
+	v.Serve(bytes.NewReader(nil))
+	if got := v.ClockOffset(); got != 0 {
+		t.Errorf("offset after reconnect = %v, want 0", got)
+	}
+}
+
+func TestSoundSlots(t *testing.T) {
+	v := NewViewer(HeadlessTarget{})
+	var played []SoundSlot
+	v.SetAudioSink(AudioSinkFunc(func(sound SoundSlot) error {
+		played = append(played, sound)
+		return nil
+	}))
+	var reports []ErrorReport
+	v.OnMessage(func(msg ProtocolMessage) {
+		if msg.Type == MsgError {
+			reports = append(reports, *msg.Error)
+		}
+	})
+
+	// A sound defined and played in one flush survives the wire.
+	s := NewSourceState()
+	ding := SoundSlot{Kind: "sound", Role: "notify", Format: "audio/wav", Data: []byte("RIFF")}
+	s.DefineSlot(5, ding)
+	s.SetTree(makeSimpleTree())
+	s.PlaySound(5)
+	s.PlaySound(5)
+	msgs := s.Flush()
+	if got := msgs[len(msgs)-1]; got.Type != MsgAudio || got.Seq == nil {
+		t.Fatalf("last message = %v, want the sequenced AUDIO", got.Type)
+	}
+	for _, msg := range msgs {
+		frame, err := EncodeFrame(&msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		header, payload, err := DecodeFrame(frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := DecodeMessage(header, payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v.ProcessMessage(decoded)
+	}
+	if len(played) != 2 || played[0].Role != "notify" || string(played[0].Data) != "RIFF" {
+		t.Fatalf("played = %+v, want the notify sound twice", played)
+	}
+
+	// Muted, triggers are dropped.
+	v.SetMuted(true)
+	if !v.Muted() {
+		t.Fatal("viewer should be muted")
+	}
+	slot := 5
+	v.ProcessMessage(ProtocolMessage{Type: MsgAudio, Slot: &slot})
+	v.SetMuted(false)
+	if len(played) != 2 {
+		t.Errorf("muted trigger played, got %d sounds", len(played))
+	}
+
+	// A slot that is not a sound is reported upstream.
+	v.DefineSlot(6, ColorSlot{Kind: "color", Role: "accent", Value: "#fff"})
+	for _, id := range []int{6, 7} {
+		id := id
+		v.ProcessMessage(ProtocolMessage{Type: MsgAudio, Slot: &id})
+	}
+	if len(reports) != 2 || reports[0].Code != ErrorCodeSlot || *reports[1].Slot != 7 {
+		t.Errorf("reports = %+v, want slot errors for 6 and 7", reports)
+	}
+
+	// Sink failures go to the error handlers.
+	var errs []error
+	v.OnError(func(err error) { errs = append(errs, err) })
+	v.SetAudioSink(AudioSinkFunc(func(SoundSlot) error { return errors.New("no device") }))
+	v.ProcessMessage(ProtocolMessage{Type: MsgAudio, Slot: &slot})
+	if len(errs) != 1 || !containsStr(errs[0].Error(), "no device") {
+		t.Errorf("errors = %v, want the sink's failure", errs)
+	}
+}
+
+func TestNotify(t *testing.T) {
+	v := NewViewer(HeadlessTarget{})
+	var got []Notification
+	v.SetNotifier(NotifierFunc(func(n Notification) error {
+		got = append(got, n)
+		return nil
+	}))
+
+	s := NewSourceState()
+	s.Notify(Notification{Title: "Build finished", Body: "3 warnings", Urgency: UrgencyLow})
+	for _, msg := range s.Flush() {
+		frame, err := EncodeFrame(&msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		header, payload, err := DecodeFrame(frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := DecodeMessage(header, payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v.ProcessMessage(decoded)
+	}
+	if len(got) != 1 || got[0].Title != "Build finished" || got[0].Body != "3 warnings" || got[0].Urgency != UrgencyLow {
+		t.Fatalf("notifications = %+v", got)
+	}
+
+	// Without a notifier, an attached terminal rings and raises OSC 777,
+	// with control characters stripped.
+	v.SetNotifier(nil)
+	var out bytes.Buffer
+	v.AttachTerminal(AnsiTarget{FD: 1}, NewTerminal(&out, 10, 2))
+	v.ProcessMessage(ProtocolMessage{Type: MsgNotify, Notify: &Notification{Title: "a;b\x1b[2J", Body: "done\x07"}})
+	if want := "\a\x1b]777;notify;a,b[2J;done\x1b\\"; out.String() != want {
+		t.Errorf("terminal wrote %q, want %q", out.String(), want)
+	}
+	out.Reset()
+	v.ProcessMessage(ProtocolMessage{Type: MsgNotify, Notify: &Notification{Title: "quiet", Urgency: UrgencyLow}})
+	if strings.Contains(out.String(), "\a") {
+		t.Errorf("low urgency rang the bell: %q", out.String())
+	}
+
+	// Notifier failures go to the error handlers.
+	var errs []error
+	v.OnError(func(err error) { errs = append(errs, err) })
+	v.SetNotifier(NotifierFunc(func(Notification) error { return ErrNoNotifier }))
+	v.ProcessMessage(ProtocolMessage{Type: MsgNotify, Notify: &Notification{Title: "x"}})
+	if len(errs) != 1 || !errors.Is(errs[0], ErrNoNotifier) {
+		t.Errorf("errors = %v, want ErrNoNotifier", errs)
+	}
+}
+
+func TestClipboardTransfer(t *testing.T) {
+	v := NewViewer(HeadlessTarget{})
+	clip := &MemoryClipboard{}
+	v.SetClipboard(clip)
+	var reports []ErrorReport
+	s := NewSourceState()
+	v.OnMessage(func(msg ProtocolMessage) {
+		switch msg.Type {
+		case MsgError:
+			reports = append(reports, *msg.Error)
+		case MsgClipboard:
+			s.HandleControl(msg)
+		}
+	})
+	send := func() {
+		t.Helper()
+		for _, msg := range s.Flush() {
+			frame, err := EncodeFrame(&msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			header, payload, err := DecodeFrame(frame)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := DecodeMessage(header, payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			v.ProcessMessage(decoded)
+		}
+	}
+
+	// Without a policy, both directions are refused.
+	s.WriteClipboard("secret")
+	s.ReadClipboard()
+	send()
+	if text, _ := clip.ReadText(); text != "" || s.Clipboard != nil {
+		t.Fatalf("clipboard = %q, source read %v; want both refused", text, s.Clipboard)
+	}
+	if len(reports) != 2 || reports[0].Code != ErrorCodeDenied || reports[1].MsgType != MsgClipboard {
+		t.Fatalf("reports = %+v, want two denials", reports)
+	}
+
+	reports = nil
+	v.SetClipboardPolicy(ClipboardPolicy{AllowWrite: true, AllowRead: true, MaxBytes: 8})
+	s.WriteClipboard("copied")
+	nonce := s.ReadClipboard()
+	send()
+	if text, _ := clip.ReadText(); text != "copied" {
+		t.Errorf("clipboard = %q, want the source's write", text)
+	}
+	if s.Clipboard == nil || *s.Clipboard != "copied" || s.ClipboardNonce != nonce {
+		t.Errorf("source read %v (nonce %d), want %q for nonce %d", s.Clipboard, s.ClipboardNonce, "copied", nonce)
+	}
+
+	// Text over the limit is refused either way.
+	s.WriteClipboard("far too long")
+	clip.WriteText("also too long")
+	s.ReadClipboard()
+	send()
+	if text, _ := clip.ReadText(); text != "also too long" {
+		t.Errorf("clipboard = %q, oversized write should be refused", text)
+	}
+	if *s.Clipboard != "copied" || len(reports) != 2 || reports[0].Code != ErrorCodeQuota || reports[1].Code != ErrorCodeQuota {
+		t.Errorf("source read %q, reports = %+v; want two quota errors", *s.Clipboard, reports)
+	}
+}
+
+func TestFileDrop(t *testing.T) {
+	v := NewViewer(HeadlessTarget{})
+	v.SetTree(makeSimpleTree())
+	v.SetFileLimits(FileLimits{MaxBytes: 100, ChunkBytes: 4})
+	var sent []ProtocolMessage
+	v.OnMessage(func(msg ProtocolMessage) {
+		frame, err := EncodeFrame(&msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		header, payload, err := DecodeFrame(frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := DecodeMessage(header, payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sent = append(sent, decoded)
+	})
+
+	target := 2
+	data := []byte("hello, world")
+	if err := v.DropFile(&target, "greeting.txt", "text/plain", data); err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 4 || sent[0].Event.Kind != "drop" || sent[0].Event.File.Size != len(data) {
+		t.Fatalf("sent %d messages, want a drop event and 3 chunks", len(sent))
+	}
+	if c := sent[3].Chunk; sent[3].Type != MsgFile || !c.Final || c.Offset != 8 {
+		t.Errorf("last chunk = %+v, want the final one at offset 8", c)
+	}
+
+	var a FileAssembler
+	var file *DroppedFile
+	for _, msg := range sent {
+		f, err := a.Add(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f != nil {
+			file = f
+		}
+	}
+	if file == nil || string(file.Data) != "hello, world" || file.Name != "greeting.txt" || *file.Target != 2 {
+		t.Fatalf("reassembled %+v", file)
+	}
+
+	// The viewer refuses files over its limit and drops on disabled nodes.
+	sent = nil
+	if err := v.DropFile(nil, "big.bin", "", make([]byte, 101)); !errors.Is(err, ErrFileTooLarge) {
+		t.Errorf("oversized drop: err = %v, want ErrFileTooLarge", err)
+	}
+	v.ApplyPatches([]PatchOp{{Target: 2, Set: map[string]interface{}{"disabled": true}}})
+	if err := v.DropFile(&target, "x", "", nil); !errors.Is(err, ErrDropDisabled) {
+		t.Errorf("drop on disabled node: err = %v, want ErrDropDisabled", err)
+	}
+	if len(sent) != 0 {
+		t.Errorf("refused drops sent %d messages", len(sent))
+	}
+
+	// The assembler enforces its own limit, and chunk order.
+	v.ApplyPatches([]PatchOp{{Target: 2, Set: map[string]interface{}{"disabled": false}}})
+	if err := v.DropFile(&target, "greeting.txt", "", data); err != nil {
+		t.Fatal(err)
+	}
+	small := FileAssembler{MaxBytes: 8}
+	if _, err := small.Add(sent[0]); !errors.Is(err, ErrFileTooLarge) {
+		t.Errorf("assembler limit: err = %v, want ErrFileTooLarge", err)
+	}
+	var b FileAssembler
+	b.Add(sent[0])
+	b.Add(sent[1])
+	if _, err := b.Add(sent[3]); !errors.Is(err, ErrFileChunk) {
+		t.Errorf("skipped chunk: err = %v, want ErrFileChunk", err)
+	}
+	if f, err := b.Add(sent[2]); f != nil || err != nil {
+		t.Errorf("chunks of an abandoned file should be ignored, got %v, %v", f, err)
+	}
+}
+
+func TestDragAndDrop(t *testing.T) {
+	yes := true
+	card := func(id int) *VNode {
+		return &VNode{ID: id, Type: NodeBox, Props: NodeProps{Height: 20, Draggable: &yes, Interactive: "clickable"}, Children: []*VNode{
+			{ID: id + 1, Type: NodeText, Props: NodeProps{Content: strPtr("card")}},
+		}}
+	}
+	column := func(id int, cards ...*VNode) *VNode {
+		return &VNode{ID: id, Type: NodeBox, Props: NodeProps{Width: 50, Height: 100, Droppable: &yes}, Children: cards}
+	}
+	v := NewViewer(HeadlessTarget{})
+	v.Init(EnvInfo{DisplayWidth: 100, DisplayHeight: 100})
+	v.SetTree(&VNode{ID: 1, Type: NodeBox, Props: NodeProps{Direction: "row"}, Children: []*VNode{
+		column(10, card(11), card(13)),
+		column(20),
+	}})
+	var events []InputEvent
+	v.OnMessage(func(msg ProtocolMessage) {
+		if msg.Type == MsgInput {
+			events = append(events, *msg.Event)
+		}
+	})
+	kinds := func() []string {
+		var k []string
+		for _, e := range events {
+			k = append(k, e.Kind)
+		}
+		return k
+	}
+
+	// A press and release without moving is still a click.
+	v.Pointer(PointerEvent{X: 5, Y: 5, Action: PointerDown})
+	v.Pointer(PointerEvent{X: 6, Y: 5, Action: PointerMove})
+	v.Pointer(PointerEvent{X: 6, Y: 5, Action: PointerUp})
+	if !reflect.DeepEqual(kinds(), []string{"click"}) {
+		t.Fatalf("events = %v, want a click", kinds())
+	}
+
+	// Dragging the first card onto the second column drops it there.
+	events = nil
+	v.Pointer(PointerEvent{X: 5, Y: 5, Action: PointerDown})
+	if !v.Pointer(PointerEvent{X: 5, Y: 30, Action: PointerMove}) {
+		t.Fatal("drag move not consumed")
+	}
+	tree := v.GetTree()
+	if tree.Dragging == nil || *tree.Dragging != 11 || tree.Active != nil {
+		t.Fatalf("dragging %v, active %v; want card 11 dragged, nothing pressed", tree.Dragging, tree.Active)
+	}
+	if tree.DropTarget == nil || *tree.DropTarget != 10 {
+		t.Errorf("drop target = %v, want its own column", tree.DropTarget)
+	}
+	if !strings.Contains(RenderHTML(tree), `data-vp-dragging="true"`) {
+		t.Error("HTML does not mark the dragged node")
+	}
+	v.Pointer(PointerEvent{X: 60, Y: 30, Action: PointerMove})
+	v.Pointer(PointerEvent{X: 70, Y: 30, Action: PointerMove})
+	v.Pointer(PointerEvent{X: 70, Y: 30, Action: PointerUp})
+	if want := []string{"drag_start", "drag_over", "drag_over", "drop"}; !reflect.DeepEqual(kinds(), want) {
+		t.Fatalf("events = %v, want %v", kinds(), want)
+	}
+	if drop := events[3]; *drop.Target != 20 || *drop.Source != 11 {
+		t.Errorf("drop on %d of %d, want card 11 on column 20", *drop.Target, *drop.Source)
+	}
+	if tree := v.GetTree(); tree.Dragging != nil || tree.DropTarget != nil {
+		t.Error("drag feedback left after the drop")
+	}
+
+	// Nothing drops onto the dragged card itself, and releasing outside
+	// every droppable node cancels.
+	events = nil
+	v.SetTree(&VNode{ID: 1, Type: NodeBox, Children: []*VNode{
+		{ID: 11, Type: NodeBox, Props: NodeProps{Height: 20, Draggable: &yes, Droppable: &yes}},
+	}})
+	v.Pointer(PointerEvent{X: 5, Y: 5, Action: PointerDown})
+	v.Pointer(PointerEvent{X: 5, Y: 12, Action: PointerMove})
+	v.Pointer(PointerEvent{X: 5, Y: 50, Action: PointerUp})
+	if want := []string{"drag_start", "drag_cancel"}; !reflect.DeepEqual(kinds(), want) {
+		t.Errorf("events = %v, want %v", kinds(), want)
+	}
+}
+
+func TestTouchGestures(t *testing.T) {
+	clock := &ManualClock{T: time.Unix(1000, 0)}
+	v := NewViewer(HeadlessTarget{})
+	v.SetClock(clock)
+	v.Init(EnvInfo{DisplayWidth: 100, DisplayHeight: 100})
+	root := &VNode{ID: 1, Type: NodeScroll, Props: NodeProps{Height: 100}}
+	for i := 0; i < 2; i++ {
+		root.Children = append(root.Children, &VNode{ID: 2 + i, Type: NodeBox, Props: NodeProps{Height: 60, Interactive: "clickable"}})
+	}
+	v.SetTree(root)
+	var events []InputEvent
+	v.OnMessage(func(msg ProtocolMessage) {
+		if msg.Type == MsgInput {
+			events = append(events, *msg.Event)
+		}
+	})
+	kinds := func() []string {
+		var k []string
+		for _, e := range events {
+			k = append(k, e.Kind)
+		}
+		return k
+	}
+	touch := func(id, x, y int, action PointerAction) {
+		v.Pointer(PointerEvent{X: x, Y: y, Action: action, Touch: true, ID: id})
+	}
+
+	// A quick touch taps and clicks the node under it.
+	touch(1, 10, 10, PointerDown)
+	touch(1, 11, 10, PointerUp)
+	if want := []string{"tap", "click"}; !reflect.DeepEqual(kinds(), want) || *events[0].Target != 2 {
+		t.Fatalf("events = %v, want %v on node 2", kinds(), want)
+	}
+
+	// Held still, it long-presses instead of clicking.
+	events = nil
+	touch(1, 10, 10, PointerDown)
+	if !v.Tick() {
+		t.Error("Tick should keep running while a long press is pending")
+	}
+	clock.Advance(longPressDelay)
+	v.Tick()
+	touch(1, 10, 10, PointerUp)
+	if want := []string{"long_press"}; !reflect.DeepEqual(kinds(), want) {
+		t.Fatalf("events = %v, want %v", kinds(), want)
+	}
+
+	// Two fingers spreading apart pinch.
+	events = nil
+	touch(1, 40, 40, PointerDown)
+	touch(2, 60, 40, PointerDown)
+	touch(2, 80, 40, PointerMove)
+	if len(events) != 1 || events[0].Kind != "pinch" || *events[0].Scale != 2 || len(events[0].Pointers) != 2 {
+		t.Fatalf("events = %+v, want a pinch at scale 2", events)
+	}
+	touch(1, 40, 40, PointerUp)
+	touch(2, 80, 40, PointerUp)
+
+	// Two fingers moving up scroll the content, and what is left over
+	// goes to the source.
+	events = nil
+	touch(1, 40, 80, PointerDown)
+	touch(2, 60, 80, PointerDown)
+	touch(1, 40, 50, PointerMove)
+	touch(2, 60, 50, PointerMove)
+	if top := *v.GetTree().NodeIndex[1].Props.ScrollTop; top != 20 {
+		t.Errorf("scrollTop = %d, want the 20 there is room for", top)
+	}
+	// Moving one finger at a time also pinches a little.
+	if last := events[len(events)-1]; last.Kind != "two_finger_scroll" || *last.DeltaY != 10 {
+		t.Errorf("events = %v, want the 10 left over in a two_finger_scroll", kinds())
+	}
+	touch(1, 40, 50, PointerUp)
+	touch(2, 60, 50, PointerUp)
+	if v.gesture != nil {
+		t.Error("gesture state left after the last touch lifted")
+	}
+}
+
+func TestGamepad(t *testing.T) {
+	clock := &ManualClock{T: time.Unix(1000, 0)}
+	v := NewViewer(HeadlessTarget{})
+	v.SetClock(clock)
+	v.Init(EnvInfo{DisplayWidth: 100, DisplayHeight: 100})
+	tile := func(id int) *VNode {
+		return &VNode{ID: id, Type: NodeBox, Props: NodeProps{Width: 30, Height: 50, Interactive: "clickable"}}
+	}
+	// 10 11 12
+	// 20 21 22
+	v.SetTree(&VNode{ID: 1, Type: NodeBox, Children: []*VNode{
+		{ID: 2, Type: NodeBox, Props: NodeProps{Direction: "row"}, Children: []*VNode{tile(10), tile(11), tile(12)}},
+		{ID: 3, Type: NodeBox, Props: NodeProps{Direction: "row"}, Children: []*VNode{tile(20), tile(21), tile(22)}},
+	}})
+	var events []InputEvent
+	v.OnMessage(func(msg ProtocolMessage) {
+		if msg.Type == MsgInput && msg.Event.Kind != "focus" && msg.Event.Kind != "blur" {
+			events = append(events, *msg.Event)
+		}
+	})
+	focused := func() int {
+		id, _ := v.Focused()
+		return id
+	}
+	press := func(b GamepadButtons) bool {
+		consumed := v.Gamepad(0, GamepadState{Buttons: b})
+		v.Gamepad(0, GamepadState{})
+		return consumed
+	}
+
+	// The first move focuses the first node; then focus goes the way
+	// the d-pad points, and stops at the edge.
+	if !press(ButtonRight) || focused() != 10 {
+		t.Fatalf("focused %d, want 10", focused())
+	}
+	press(ButtonRight)
+	press(ButtonRight)
+	press(ButtonDown)
+	if focused() != 22 {
+		t.Errorf("right twice then down focused %d, want 22", focused())
+	}
+	if press(ButtonDown) || focused() != 22 {
+		t.Errorf("down at the bottom edge moved focus to %d", focused())
+	}
+
+	// The left stick navigates too, repeating while held.
+	v.Gamepad(0, GamepadState{LeftX: -0.9})
+	v.Gamepad(0, GamepadState{LeftX: -0.9})
+	if focused() != 21 {
+		t.Errorf("holding stick left briefly focused %d, want 21", focused())
+	}
+	clock.Advance(gamepadRepeatDelay)
+	v.Gamepad(0, GamepadState{LeftX: -0.9})
+	if focused() != 20 {
+		t.Errorf("holding stick left focused %d, want 20 after a repeat", focused())
+	}
+	v.Gamepad(0, GamepadState{LeftY: -0.9})
+	if focused() != 10 {
+		t.Errorf("stick up focused %d, want 10", focused())
+	}
+	v.Gamepad(0, GamepadState{})
+
+	// A clicks the focused node; other buttons and axes go to the source.
+	press(ButtonA)
+	press(ButtonStart)
+	v.Gamepad(1, GamepadState{RightY: 0.1})
+	v.Gamepad(1, GamepadState{RightY: 0.8})
+	v.Gamepad(1, GamepadState{RightY: 0.82})
+	v.Gamepad(1, GamepadState{})
+	var got []string
+	for _, e := range events {
+		got = append(got, fmt.Sprintf("%s %s%s %d", e.Kind, e.Key, e.Action, e.Pad))
+	}
+	want := []string{"click  0", "gamepad startpress 0", "gamepad startrelease 0", "gamepad_axis right_y 1", "gamepad_axis right_y 1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("events = %q, want %q", got, want)
+	}
+	if *events[0].Target != 10 || *events[3].Axis != 0.8 || *events[4].Axis != 0 {
+		t.Errorf("click on %d, axis %v then %v", *events[0].Target, *events[3].Axis, *events[4].Axis)
+	}
+}
+
+func TestPointerCapture(t *testing.T) {
+	yes := true
+	v := NewViewer(HeadlessTarget{})
+	v.Init(EnvInfo{DisplayWidth: 100, DisplayHeight: 100})
+	v.SetTree(&VNode{ID: 1, Type: NodeBox, Children: []*VNode{
+		{ID: 10, Type: NodeBox, Props: NodeProps{Height: 20}},
+		{ID: 11, Type: NodeBox, Props: NodeProps{Width: 40, Height: 10, CapturePointer: &yes, Interactive: "clickable"}},
+		{ID: 12, Type: NodeBox, Props: NodeProps{Height: 20, Interactive: "clickable"}},
+	}})
+	var events []InputEvent
+	v.OnMessage(func(msg ProtocolMessage) {
+		if msg.Type == MsgInput {
+			events = append(events, *msg.Event)
+		}
+	})
+	kinds := func() []string {
+		var k []string
+		for _, e := range events {
+			k = append(k, e.Kind)
+		}
+		return k
+	}
+
+	// A drag that leaves the slider still reaches it, and releasing it
+	// elsewhere does not click.
+	v.Pointer(PointerEvent{X: 10, Y: 25, Action: PointerDown})
+	if id, ok := v.PointerCapture(); !ok || id != 11 {
+		t.Fatalf("capture = %d, %v; want node 11", id, ok)
+	}
+	if !v.Pointer(PointerEvent{X: 60, Y: 35, Action: PointerMove}) {
+		t.Error("captured move not consumed")
+	}
+	if !v.Pointer(PointerEvent{X: 90, Y: 5, Action: PointerUp}) {
+		t.Error("captured release not consumed")
+	}
+	if want := []string{"pointer_down", "pointer_move", "pointer_up"}; !reflect.DeepEqual(kinds(), want) {
+		t.Fatalf("events = %v, want %v", kinds(), want)
+	}
+	for _, e := range events {
+		if *e.Target != 11 {
+			t.Errorf("%s aimed at %d, want 11", e.Kind, *e.Target)
+		}
+	}
+	if move := events[1]; *move.OffsetX != 60 || *move.OffsetY != 15 {
+		t.Errorf("move offset = (%d, %d), want (60, 15) past the slider's corner", *move.OffsetX, *move.OffsetY)
+	}
+	if _, ok := v.PointerCapture(); ok {
+		t.Error("capture left after the release")
+	}
+
+	// Released over the node, the press still clicks.
+	events = nil
+	v.Pointer(PointerEvent{X: 10, Y: 25, Action: PointerDown})
+	v.Pointer(PointerEvent{X: 12, Y: 25, Action: PointerUp})
+	if want := []string{"pointer_down", "pointer_up", "click"}; !reflect.DeepEqual(kinds(), want) {
+		t.Errorf("events = %v, want %v", kinds(), want)
+	}
+
+	// A host can capture for a node that does not ask for it.
+	events = nil
+	v.Pointer(PointerEvent{X: 10, Y: 40, Action: PointerDown})
+	if !v.SetPointerCapture(12) || v.SetPointerCapture(99) {
+		t.Fatal("SetPointerCapture accepted a missing node or refused a present one")
+	}
+	v.Pointer(PointerEvent{X: 10, Y: 90, Action: PointerMove})
+	v.Pointer(PointerEvent{X: 10, Y: 90, Action: PointerUp})
+	if want := []string{"pointer_move", "pointer_up"}; !reflect.DeepEqual(kinds(), want) {
+		t.Errorf("events = %v, want %v", kinds(), want)
+	}
+
+	// A second finger turns the touch into a gesture and cancels.
+	events = nil
+	v.Pointer(PointerEvent{X: 10, Y: 25, Action: PointerDown, Touch: true, ID: 1})
+	v.Pointer(PointerEvent{X: 30, Y: 25, Action: PointerDown, Touch: true, ID: 2})
+	if want := []string{"pointer_down", "pointer_cancel"}; !reflect.DeepEqual(kinds(), want) {
+		t.Errorf("events = %v, want %v", kinds(), want)
+	}
+	if _, ok := v.PointerCapture(); ok {
+		t.Error("capture left after a second finger")
+	}
+}
+
+func TestKeyEvents(t *testing.T) {
+	for _, tc := range []struct {
+		bind, want string
+	}{
+		{"ctrl+s", "ctrl+s"},
+		{"Shift+Ctrl+Tab", "ctrl+shift+tab"},
+		{"shift+a", "A"},
+		{"esc", "escape"},
+		{"cmd+up", "meta+arrowup"},
+		{"ctrl++", "ctrl++"},
+		{"space", "space"},
+	} {
+		e, err := ParseKey(tc.bind)
+		if err != nil || e.String() != tc.want {
+			t.Errorf("ParseKey(%q) = %q, %v; want %q", tc.bind, e.String(), err, tc.want)
+		}
+	}
+	if _, err := ParseKey("hyper+x"); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("ParseKey(hyper+x) error = %v, want ErrUnknownKey", err)
+	}
+
+	input := "a\x1b[A\x1b[1;5C\x1b[3~\x01\x1bx\x1b[<0;3;4M\x1b[97;6u\x1b[97;1:3u\x1b[15;2~\x1b[1;"
+	keys, n := ParseTerminalKeys([]byte(input))
+	var got []string
+	for _, k := range keys {
+		got = append(got, k.String()+"/"+k.Action.String())
+	}
+	want := []string{"a/down", "arrowup/down", "ctrl+arrowright/down", "delete/down", "ctrl+a/down",
+		"alt+x/down", "ctrl+shift+a/down", "a/up", "shift+f5/down"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("keys = %v, want %v", got, want)
+	}
+	if rest := input[n:]; rest != "\x1b[1;" {
+		t.Errorf("left %q undecoded, want the cut-off sequence", rest)
+	}
+	if keys[0].Code != "KeyA" || keys[0].Text != "a" || keys[4].Text != "" {
+		t.Errorf("codes and text = %+v, %+v", keys[0], keys[4])
+	}
+	if !keys[2].Matches("Control+Right") || keys[2].Matches("right") || !keys[6].Matches("ctrl+shift+A") {
+		t.Error("Matches disagrees with the keybind notation")
+	}
+
+	// Typed keys edit the focused input; key events for the source carry
+	// the detail.
+	v := NewViewer(HeadlessTarget{})
+	v.Init(EnvInfo{DisplayWidth: 100, DisplayHeight: 100})
+	v.SetTree(&VNode{ID: 1, Type: NodeBox, Children: []*VNode{
+		{ID: 2, Type: NodeInput, Props: NodeProps{Value: strPtr("")}},
+	}})
+	var events []InputEvent
+	v.OnMessage(func(msg ProtocolMessage) {
+		if msg.Type == MsgInput && msg.Event.Kind == "key" {
+			events = append(events, *msg.Event)
+		}
+	})
+	v.Key("tab")
+	typed, _ := ParseTerminalKeys([]byte("Hi"))
+	for _, k := range typed {
+		if !v.KeyInput(k) {
+			t.Errorf("typing %q not consumed", k.Key)
+		}
+	}
+	if got := inputValue(v.GetTree().NodeIndex[2]); got != "Hi" {
+		t.Errorf("value = %q, want Hi", got)
+	}
+	v.KeyInput(KeyEvent{Key: "s", Code: "KeyS", Mods: ModCtrl})
+	v.KeyInput(KeyEvent{Key: "s", Code: "KeyS", Mods: ModCtrl, Action: KeyUp})
+	if len(events) != 2 {
+		t.Fatalf("sent %d key events, want 2", len(events))
+	}
+	if e := events[0]; e.Key != "ctrl+s" || e.Code != "KeyS" || e.Modifiers != ModCtrl || e.Action != "down" || *e.Target != 2 {
+		t.Errorf("key down = %+v", e)
+	}
+	if e := events[1]; e.Action != "up" {
+		t.Errorf("key up action = %q, want up", e.Action)
+	}
+}
+
+func TestKeyRepeat(t *testing.T) {
+	clock := &ManualClock{T: time.Unix(0, 0)}
+	v := NewViewer(AnsiTarget{})
+	v.SetClock(clock)
+	v.SetScrollConfig(ScrollConfig{Momentum: false})
+	v.SetTree(&VNode{ID: 1, Type: NodeBox, Children: []*VNode{
+		{ID: 2, Type: NodeInput, Props: NodeProps{Value: strPtr("abcdef")}},
+		{ID: 3, Type: NodeScroll, Props: NodeProps{Height: 50, Interactive: "focusable"}, Children: []*VNode{
+			{ID: 4, Type: NodeBox, Props: NodeProps{Height: 500}},
+		}},
+	}})
+	v.Key("tab")
+	offset := func() int { return v.GetTree().Cursor.Offset }
+	left := KeyEvent{Key: "arrowleft", Code: "ArrowLeft"}
+
+	// A held arrow repeats after the delay, at the interval, until it
+	// comes up.
+	v.KeyInput(left)
+	clock.Advance(400 * time.Millisecond)
+	if !v.Tick() || offset() != 5 {
+		t.Fatalf("offset = %d before the delay, want 5 and a key held", offset())
+	}
+	clock.Advance(100 * time.Millisecond)
+	v.Tick()
+	clock.Advance(time.Second / 30)
+	v.Tick()
+	if offset() != 3 {
+		t.Errorf("offset = %d after two repeats, want 3", offset())
+	}
+	up := left
+	up.Action = KeyUp
+	v.KeyInput(up)
+	clock.Advance(time.Second)
+	if v.Tick() || offset() != 3 {
+		t.Errorf("offset = %d after the release, want 3 and nothing held", offset())
+	}
+
+	// Keys that do not navigate, and keys given to Key, do not repeat.
+	v.KeyInput(KeyEvent{Key: "x", Text: "x"})
+	clock.Advance(time.Second)
+	if v.Tick() {
+		t.Error("typed character repeated")
+	}
+
+	// A focused scroll node scrolls with the keys, and repeats them.
+	v.Key("tab")
+	v.KeyInput(KeyEvent{Key: "arrowdown"})
+	top := func() int { return *v.GetTree().NodeIndex[3].Props.ScrollTop }
+	first := top()
+	clock.Advance(time.Second)
+	v.Tick()
+	if first <= 0 || top() <= first {
+		t.Errorf("scrollTop %d then %d, want a line then more", first, top())
+	}
+	v.KeyInput(KeyEvent{Key: "arrowdown", Action: KeyUp})
+	v.Key("home")
+	if top() != 0 {
+		t.Errorf("scrollTop = %d after home, want 0", top())
+	}
+
+	// Once the host repeats keys itself, the viewer leaves it to.
+	v.KeyInput(KeyEvent{Key: "arrowdown", Action: KeyRepeat})
+	v.KeyInput(KeyEvent{Key: "arrowdown"})
+	clock.Advance(time.Second)
+	if v.Tick() {
+		t.Error("viewer repeated a key the host repeats")
+	}
+
+	// Headless viewers never repeat.
+	v = NewViewer(HeadlessTarget{})
+	v.SetClock(clock)
+	v.SetTree(&VNode{ID: 2, Type: NodeInput, Props: NodeProps{Value: strPtr("ab")}})
+	v.Key("tab")
+	v.KeyInput(left)
+	clock.Advance(time.Second)
+	if v.Tick() {
+		t.Error("headless viewer repeated a key")
+	}
+}
+
+func TestComposition(t *testing.T) {
+	v := NewViewer(HeadlessTarget{})
+	v.SetTree(&VNode{ID: 1, Type: NodeBox, Children: []*VNode{
+		{ID: 2, Type: NodeInput, Props: NodeProps{Value: strPtr("ab")}},
+		{ID: 3, Type: NodeButton, Props: NodeProps{Label: strPtr("ok")}},
+	}})
+	var events []InputEvent
+	v.OnMessage(func(msg ProtocolMessage) {
+		if msg.Type == MsgInput {
+			events = append(events, *msg.Event)
+		}
+	})
+	kinds := func() []string {
+		var k []string
+		for _, e := range events {
+			k = append(k, e.Kind+":"+e.Value)
+		}
+		return k
+	}
+	value := func() string { return inputValue(v.GetTree().NodeIndex[2]) }
+	if v.Compose("に", 0) {
+		t.Error("Compose accepted with no input focused")
+	}
+	v.Key("tab")
+	events = nil
+
+	// The composition is drawn in the input, underlined, but is not
+	// part of the value, and keys go to the IME.
+	v.Compose("に", len("に"))
+	v.Compose("にほ", len("にほ"))
+	if value() != "ab" {
+		t.Errorf("value = %q while composing, want ab", value())
+	}
+	if v.Key("x") {
+		t.Error("key consumed while composing")
+	}
+	tree := v.GetTree()
+	g := RenderGrid(tree, 10, 3, func(id int) SlotValue { return tree.Slots[id] })
+	if got := strings.TrimRight(strings.Split(g.String(), "\n")[0], " "); got != "> abにほ" {
+		t.Errorf("input drawn as %q, want the composition after the value", got)
+	}
+	if !g.Cells[0][4].Style.Underline || g.Cells[0][3].Style.Underline {
+		t.Error("composition not underlined apart from the value")
+	}
+
+	v.CommitComposition("日本")
+	if value() != "ab日本" || v.GetTree().Cursor.Offset != len("ab日本") {
+		t.Errorf("value = %q after commit, want ab日本 with the cursor after it", value())
+	}
+	want := []string{"composition_start:", "composition_update:に", "composition_update:にほ", "value_change:ab日本", "composition_end:日本"}
+	if !reflect.DeepEqual(kinds(), want) {
+		t.Errorf("events = %v, want %v", kinds(), want)
+	}
+
+	// Cancelling inserts nothing; moving focus commits what is there.
+	events = nil
+	v.Compose("か", 0)
+	v.Compose("", 0)
+	if value() != "ab日本" {
+		t.Errorf("value = %q after cancel", value())
+	}
+	v.Compose("か", 0)
+	v.Focus(3)
+	if value() != "ab日本か" {
+		t.Errorf("value = %q after focus moved, want the composition committed", value())
+	}
+	if got := kinds(); !strings.Contains(strings.Join(got, " "), "composition_end:か") {
+		t.Errorf("events = %v, want a composition_end", got)
+	}
+}
+
+func TestAbsoluteTimeColumns(t *testing.T) {
+	schema := []SchemaColumn{
+		{ID: 0, Name: "at", Type: "timestamp", Format: "absolute_time"},
+		{ID: 1, Name: "utc", Type: "timestamp", Format: "absolute_time", Layout: "%a %e %b %I:%M %p %Z", TimeZone: "UTC"},
+		{ID: 2, Name: "bad", Type: "timestamp", Format: "absolute_time", Layout: "%F %T %z %%", TimeZone: "Nowhere/Special"},
+	}
+	ts := float64(time.Date(2024, 3, 5, 22, 7, 9, 0, time.UTC).Unix())
+	sid := 5
+	v := NewViewer(HeadlessTarget{})
+	v.SetTimeZone(time.FixedZone("X", 2*3600))
+	v.SetTree(&VNode{ID: 1, Type: NodeTable, Props: NodeProps{Schema: &sid}})
+	tree := v.GetTree()
+	tree.Schemas[sid] = schema
+	tree.DataRows[sid] = [][]interface{}{{ts, ts, ts}}
+
+	_, body := tableCells(tree, tree.Root)
+	want := []string{"2024-03-06 00:07:09", "Tue  5 Mar 10:07 PM UTC", "2024-03-06 00:07:09 +0200 %"}
+	if !reflect.DeepEqual(body[0], want) {
+		t.Errorf("cells = %q, want %q", body[0], want)
+	}
+	if got := TextProjection(tree); !strings.Contains(got, want[0]) {
+		t.Errorf("projection %q does not show the absolute time", got)
+	}
+	if got := formatValue(ts, schema[0], valueFormat{zone: time.UTC}); got != "2024-03-05 22:07:09" {
+		t.Errorf("formatValue in UTC = %q", got)
+	}
+}
+
+func TestRelativeTimeClock(t *testing.T) {
+	clock := &ManualClock{T: time.Unix(1_000_000, 0)}
+	sid := 5
+	v := NewViewer(HeadlessTarget{})
+	v.SetClock(clock)
+	v.SetTree(&VNode{ID: 1, Type: NodeTable, Props: NodeProps{Schema: &sid}})
+	tree := v.GetTree()
+	tree.Schemas[sid] = []SchemaColumn{{ID: 0, Name: "modified", Type: "timestamp", Format: "relative_time"}}
+	tree.DataRows[sid] = [][]interface{}{{float64(1_000_000 - 2*3600)}}
+
+	// Projections and table cells read the viewer's clock, not the wall
+	// clock, and move only when it does.
+	if got := v.GetTextProjection(); !strings.Contains(got, "2h ago") {
+		t.Errorf("projection = %q, want 2h ago", got)
+	}
+	clock.Advance(24 * time.Hour)
+	if _, body := tableCells(tree, tree.Root); body[0][0] != "1d ago" {
+		t.Errorf("cell = %q after a day, want 1d ago", body[0][0])
+	}
+
+	// The options' clock overrides the tree's, and the clock offset still
+	// applies.
+	opts := DefaultTextProjectionOptions()
+	opts.Clock = &ManualClock{T: time.Unix(1_000_000+5*60, 0)}
+	if got := TextProjectionWithOptions(tree, opts); !strings.Contains(got, "2h ago") {
+		t.Errorf("projection with a clock = %q, want 2h ago", got)
+	}
+	tree.ClockOffset = -2 * time.Hour
+	if got := TextProjectionWithOptions(tree, opts); !strings.Contains(got, "5m ago") {
+		t.Errorf("projection with an offset = %q, want 5m ago", got)
+	}
+}
+
+func TestNumberColumns(t *testing.T) {
+	two, zero := 2, 0
+	cases := []struct {
+		value  interface{}
+		column SchemaColumn
+		locale string
+		want   string
+	}{
+		{float64(1234567.5), SchemaColumn{Format: "number"}, "", "1,234,567.5"},
+		{int64(-1234), SchemaColumn{Format: "number"}, "", "-1,234"},
+		{float64(999), SchemaColumn{Format: "number", Precision: &two}, "", "999.00"},
+		{float64(1234.567), SchemaColumn{Format: "number", Precision: &two}, "de", "1.234,57"},
+		{float64(1234567), SchemaColumn{Format: "number"}, "fr-FR", "1 234 567"},
+		{float64(1234.5), SchemaColumn{Format: "number", Locale: "de-CH"}, "de", "1’234.5"},
+		{float64(1234), SchemaColumn{Format: "number"}, "xx", "1,234"},
+		{float64(1260), SchemaColumn{Format: "compact_number"}, "", "1.3K"},
+		{float64(2000000), SchemaColumn{Format: "compact_number"}, "", "2M"},
+		{float64(999950), SchemaColumn{Format: "compact_number"}, "", "1M"},
+		{float64(-4_500_000_000), SchemaColumn{Format: "compact_number", Precision: &two}, "pt-BR", "-4,50B"},
+		{float64(12345), SchemaColumn{Format: "compact_number", Precision: &zero}, "", "12K"},
+		{float64(950), SchemaColumn{Format: "compact_number"}, "", "950"},
+		{float64(999.5), SchemaColumn{Format: "compact_number"}, "", "999.5"},
+		{float64(1e18), SchemaColumn{Format: "compact_number"}, "", "1,000,000,000,000,000,000"},
+		{float64(999_990_000_000_000), SchemaColumn{Format: "compact_number"}, "", "999,990,000,000,000"},
+		{math.NaN(), SchemaColumn{Format: "compact_number"}, "", "NaN"},
+		{math.Inf(1), SchemaColumn{Format: "compact_number"}, "", "+Inf"},
+		{math.Inf(-1), SchemaColumn{Format: "compact_number"}, "", "-Inf"},
+		{math.Inf(1), SchemaColumn{Format: "number"}, "", "+Inf"},
+		{"n/a", SchemaColumn{Format: "number"}, "", "n/a"},
+	}
+	for _, c := range cases {
+		if got := formatValue(c.value, c.column, valueFormat{locale: c.locale}); got != c.want {
+			t.Errorf("formatValue(%v, %+v, %q) = %q, want %q", c.value, c.column, c.locale, got, c.want)
+		}
+	}
+
+	sid := 5
+	v := NewViewer(HeadlessTarget{})
+	v.SetLocale("de")
+	v.SetTree(&VNode{ID: 1, Type: NodeTable, Props: NodeProps{Schema: &sid}})
+	tree := v.GetTree()
+	tree.Schemas[sid] = []SchemaColumn{
+		{ID: 0, Name: "count", Type: "int", Format: "number"},
+		{ID: 1, Name: "views", Type: "int", Format: "compact_number", Locale: "en"},
+	}
+	tree.DataRows[sid] = [][]interface{}{{float64(1234567), float64(1234567)}}
+	_, body := tableCells(tree, tree.Root)
+	if want := []string{"1.234.567", "1.2M"}; !reflect.DeepEqual(body[0], want) {
+		t.Errorf("cells = %q, want %q", body[0], want)
+	}
+}
+
+func TestProbeImage(t *testing.T) {
+	webp, _ := base64.StdEncoding.DecodeString("UklGRhoAAABXRUJQVlA4TA0AAAAvAAAAEAcQERGIiP4HAA==")
+	tests := []struct {
+		name   string
+		data   []byte
+		format string
+		want   ImageInfo
+	}{
+		{"png", encodeTestPNG(t, 3, 2), "", ImageInfo{Format: "png", Width: 3, Height: 2}},
+		{"webp", webp, "webp", ImageInfo{Format: "webp", Width: 1, Height: 1}},
+		{"svg", []byte(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 32 16"></svg>`), "svg", ImageInfo{Format: "svg", Width: 32, Height: 16}},
+	}
+	for _, tt := range tests {
+		got, err := ProbeImage(tt.data, tt.format)
+		if err != nil || got != tt.want {
+			t.Errorf("%s: ProbeImage = %+v, %v; want %+v", tt.name, got, err, tt.want)
+		}
+	}
+
+	if _, err := DecodeImage([]byte("not an image"), "png"); !errors.Is(err, ErrUnsupportedImage) {
+		t.Errorf("DecodeImage(garbage) err = %v, want ErrUnsupportedImage", err)
+	}
+}
+
+func TestDecodeImageAnimatedGIF(t *testing.T) {
+	palette := color.Palette{color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 255, 255}}
+	anim := &gif.GIF{Delay: []int{5, 20}}
+	for i := range palette {
+		frame := image.NewPaletted(image.Rect(0, 0, 2, 2), palette)
+		for p := range frame.Pix {
+			frame.Pix[p] = uint8(i)
+		}
+		anim.Image = append(anim.Image, frame)
+	}
+	var data bytes.Buffer
+	if err := gif.EncodeAll(&data, anim); err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := DecodeImage(data.Bytes(), "gif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(img.Frames) != 2 || img.Delays[0] != 50*time.Millisecond {
+		t.Fatalf("frames = %d, delays = %v", len(img.Frames), img.Delays)
+	}
+	if r, _, _, _ := img.FrameAt(60*time.Millisecond).At(0, 0).RGBA(); r != 0 {
+		t.Error("frame at 60ms should be the second (blue) frame")
+	}
+	if r, _, _, _ := img.FrameAt(260*time.Millisecond).At(0, 0).RGBA(); r == 0 {
+		t.Error("animation should loop back to the first frame")
+	}
+}
+
+func TestImageProtocolFor(t *testing.T) {
+	if got := ImageProtocolFor(nil); got != ImageBlocks {
+		t.Errorf("nil env = %q, want blocks", got)
+	}
+	env := &EnvInfo{ImageProtocols: []string{"sixel", "kitty"}}
+	if got := ImageProtocolFor(env); got != ImageKitty {
+		t.Errorf("protocol = %q, want kitty", got)
+	}
+}
+
+// ── Viewer tests ─────────────────────────────────────────────────────
+
+func TestNewViewer(t *testing.T) {
+	v := NewViewer(HeadlessTarget{})
+	if v == nil {
+		t.Fatal("expected non-nil viewer")
+	}
+
+	tree := v.GetTree()
+	if tree.Root != nil {
+		t.Error("expected nil root on new viewer")
+	}
+}
+
+func TestViewerSetTree(t *testing.T) {
+	v := NewViewer(HeadlessTarget{})
+	v.SetTree(makeSimpleTree())
+
+	tree := v.GetTree()
+	if tree.Root == nil {
+		t.Fatal("expected non-nil root after SetTree")
+	}
+	if tree.Root.ID != 1 {
+		t.Errorf("root ID = %d, want 1", tree.Root.ID)
+	}
+}
+
+func TestViewerGetTextProjection(t *testing.T) {
+	v := NewViewer(HeadlessTarget{})
+	v.SetTree(makeSimpleTree())
+
+	text := v.GetTextProjection()
+	if !containsStr(text, "Hello") {
+		t.Errorf("text projection missing 'Hello': %s", text)
+	}
+}
+
+func TestViewerApplyPatches(t *testing.T) {
+	v := NewViewer(HeadlessTarget{})
+	v.SetTree(makeSimpleTree())
+
+	v.ApplyPatches([]PatchOp{
+		{Target: 2, Set: map[string]interface{}{"content": "Modified"}},
+	})
+
+	text := v.GetTextProjection()
+	if !containsStr(text, "Modified") {
+		t.Errorf("text projection missing 'Modified': %s", text)
+	}
+}
+
+func TestViewerAtomicPatches(t *testing.T) {
+	v := NewViewer(HeadlessTarget{})
+	v.SetTree(makeSimpleTree())
+	v.SetAtomicPatches(true)
+
+	v.ApplyPatches([]PatchOp{
+		{Target: 2, Set: map[string]interface{}{"content": "Modified"}},
+		{Target: 999, Remove: true},
+	})
+
+	text := v.GetTextProjection()
+	if containsStr(text, "Modified") {
+		t.Errorf("partial batch was committed: %s", text)
+	}
+}
+
+func TestViewerDefineSlot(t *testing.T) {
+	v := NewViewer(HeadlessTarget{})
+	v.DefineSlot(5, ColorSlot{Kind: "color", Role: "primary", Value: "#ff0000"})
+
+	tree := v.GetTree()
+	if len(tree.Slots) != 1 {
+		t.Errorf("slots count = %d, want 1", len(tree.Slots))
+	}
+}
+
+func TestViewerMetrics(t *testing.T) {
+	v := NewViewer(HeadlessTarget{})
+	v.SetTree(makeSimpleTree())
+	v.ApplyPatches([]PatchOp{
+		{Target: 2, Set: map[string]interface{}{"content": "Changed"}},
+	})
+
+	metrics := v.GetMetrics()
+	if metrics.MessagesProcessed != 2 {
+		t.Errorf("messagesProcessed = %d, want 2", metrics.MessagesProcessed)
+	}
+	if metrics.TreeNodeCount != 3 {
+		t.Errorf("treeNodeCount = %d, want 3", metrics.TreeNodeCount)
+	}
+	if metrics.TreeDepth != 2 {
+		t.Errorf("treeDepth = %d, want 2", metrics.TreeDepth)
+	}
+}
+
+func TestViewerRender(t *testing.T) {
+	v := NewViewer(HeadlessTarget{})
+	v.SetTree(makeSimpleTree())
+
+	changed := v.Render()
+	if !changed {
+		t.Error("expected Render() to return true on dirty tree")
+	}
+
+	changed = v.Render()
+	if changed {
+		t.Error("expected Render() to return false on clean tree")
+	}
+}
+
+func TestViewerMultiTarget(t *testing.T) {
+	v := NewViewer(HeadlessTarget{})
+	if v.Render() {
+		t.Error("expected nothing to render on a fresh viewer")
+	}
+
+	ansi := AnsiTarget{FD: 1}
+	v.AddTarget(ansi)
+	v.SetTree(makeSimpleTree())
+
+	if !v.RenderTo(ansi) {
+		t.Fatal("expected ansi target to render")
+	}
+	if v.IsDirty(ansi) {
+		t.Error("ansi target should be clean after RenderTo")
+	}
+	if !v.IsDirty(HeadlessTarget{}) {
+		t.Error("headless target should still be dirty")
+	}
+
+	if !v.Render() {
+		t.Error("expected Render to draw the headless target")
+	}
+	if _, renders := v.TargetOutput(ansi); renders != 1 {
+		t.Errorf("ansi renders = %d, want 1 (already up to date)", renders)
+	}
+	if out, _ := v.TargetOutput(HeadlessTarget{}); out != "Hello\nWorld" {
+		t.Errorf("headless output = %q", out)
+	}
+
+	if !v.RemoveTarget(ansi) || len(v.Targets()) != 1 {
+		t.Error("expected ansi target to be removed")
+	}
+	if v.RemoveTarget(HeadlessTarget{}) {
+		t.Error("primary target must not be removable")
+	}
+}
+
+func TestViewerScreenshot(t *testing.T) {
+	v := NewViewer(HeadlessTarget{})
+	v.SetTree(makeSimpleTree())
+
+	ss := v.Screenshot()
+	if ss.Format != "ansi" {
+		t.Errorf("format = %s, want ansi", ss.Format)
+	}
+	if ss.Data == "" {
+		t.Error("expected non-empty screenshot data")
+	}
+}
+
+func TestViewerDestroy(t *testing.T) {
+	v := NewViewer(HeadlessTarget{})
+	v.SetTree(makeSimpleTree())
+	v.Destroy()
+
+	tree := v.GetTree()
+	if tree.Root != nil {
+		t.Error("expected nil root after Destroy")
+	}
+}
+
+func TestViewerProcessMessage(t *testing.T) {
+	v := NewViewer(HeadlessTarget{})
+
+	v.ProcessMessage(ProtocolMessage{
+		Type: MsgTree,
+		Root: makeSimpleTree(),
+	})
+
+	tree := v.GetTree()
+	if tree.Root == nil {
+		t.Fatal("expected non-nil root after ProcessMessage")
+	}
+	if CountNodes(tree.Root) != 3 {
+		t.Errorf("node count = %d, want 3", CountNodes(tree.Root))
+	}
+}
+
+func TestViewerSeqAck(t *testing.T) {
+	v := NewViewer(HeadlessTarget{})
+	var acks []uint64
+	v.OnMessage(func(msg ProtocolMessage) {
+		if msg.Type == MsgAck {
+			acks = append(acks, *msg.Ack)
+		}
+	})
+
+	seq1, seq2 := uint64(1), uint64(2)
+	v.ProcessMessage(ProtocolMessage{Type: MsgTree, Seq: &seq1, Root: makeSimpleTree()})
+	v.ProcessMessage(ProtocolMessage{Type: MsgPatch, Seq: &seq2, Ops: []PatchOp{
+		{Target: 2, Set: map[string]interface{}{"content": "Patched"}},
+	}})
+	v.ProcessMessage(ProtocolMessage{Type: MsgPatch, Seq: &seq2}) // duplicate
+
+	if len(acks) != 2 || acks[0] != 1 || acks[1] != 2 {
+		t.Errorf("acks = %v, want [1 2]", acks)
+	}
+	if m := v.GetMetrics(); m.SeqDuplicates != 1 {
+		t.Errorf("seqDuplicates = %d, want 1", m.SeqDuplicates)
+	}
+}
+
+func TestViewerSeqGapResync(t *testing.T) {
+	v := NewViewer(HeadlessTarget{})
+	var resync *uint64
+	v.OnMessage(func(msg ProtocolMessage) {
+		if msg.Type == MsgResync {
+			resync = msg.Ack
+		}
+	})
+
+	seq := func(n uint64) *uint64 { return &n }
+	v.ProcessMessage(ProtocolMessage{Type: MsgTree, Seq: seq(1), Root: makeSimpleTree()})
+	v.ProcessMessage(ProtocolMessage{Type: MsgPatch, Seq: seq(3), Ops: []PatchOp{
+		{Target: 2, Set: map[string]interface{}{"content": "Lost"}},
+	}})
+
+	if resync == nil || *resync != 1 {
+		t.Fatalf("resync = %v, want last good seq 1", resync)
+	}
+	if containsStr(v.GetTextProjection(), "Lost") {
+		t.Error("patch after gap should be dropped until resync")
+	}
+
+	v.ProcessMessage(ProtocolMessage{Type: MsgTree, Seq: seq(4), Root: makeSimpleTree()})
+	v.ProcessMessage(ProtocolMessage{Type: MsgPatch, Seq: seq(5), Ops: []PatchOp{
+		{Target: 2, Set: map[string]interface{}{"content": "Recovered"}},
+	}})
+	if !containsStr(v.GetTextProjection(), "Recovered") {
+		t.Error("patches should apply again after full tree")
+	}
+	if m := v.GetMetrics(); m.SeqGaps != 1 || m.ResyncRequests != 1 {
+		t.Errorf("seqGaps = %d, resyncRequests = %d, want 1, 1", m.SeqGaps, m.ResyncRequests)
+	}
+}
+
+func TestViewerBatchFrame(t *testing.T) {
+	s := NewSourceState()
+	s.DefineSlot(1, ColorSlot{Kind: "color", Role: "accent", Value: "#ff0000"})
+	s.SetTree(makeSimpleTree())
+	s.EmitData(0, []interface{}{"row"})
+	msgs := s.Flush()
+
+	batch := NewBatch(msgs)
+	if batch.Type != MsgBatch || len(batch.Messages) != 3 || batch.Seq == nil || *batch.Seq != 1 {
+		t.Fatalf("batch = %+v", batch)
+	}
+	frame, err := EncodeFrame(&batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, payload, err := DecodeFrame(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodeMessage(header, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := NewViewer(HeadlessTarget{})
+	var acks []uint64
+	v.OnMessage(func(msg ProtocolMessage) {
+		if msg.Type == MsgAck {
+			acks = append(acks, *msg.Ack)
+		}
+	})
+	v.ProcessMessage(decoded)
+	if len(acks) != 1 || acks[0] != 1 {
+		t.Errorf("acks = %v, want one ack for the batch", acks)
+	}
+	if v.ResolvedSlot(1) == nil || CountNodes(v.GetTree().Root) != 3 || len(v.GetTree().DataRows[0]) != 1 {
+		t.Error("batch contents not applied")
+	}
+	if m := v.GetMetrics(); m.MessagesProcessed != 3 {
+		t.Errorf("messagesProcessed = %d, want 3", m.MessagesProcessed)
+	}
+
+	nested := NewBatch([]ProtocolMessage{batch, {Type: MsgTree, Root: makeSimpleTree()}})
+	frame, _ = EncodeFrame(&nested)
+	header, payload, _ = DecodeFrame(frame)
+	if _, err := DecodeMessage(header, payload); !errors.Is(err, ErrNestedBatch) {
+		t.Errorf("nested batch err = %v, want ErrNestedBatch", err)
+	}
+
+	buf, err := EncodeFrames(msgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames, err := NewFrameReader().Feed(buf)
+	if err != nil || len(frames) != 3 {
+		t.Errorf("EncodeFrames gave %d frames (err %v), want 3", len(frames), err)
+	}
+}
+
+func TestViewerFlowControl(t *testing.T) {
+	v := NewViewer(HeadlessTarget{})
+	credit := 0
+	v.OnMessage(func(msg ProtocolMessage) {
+		if msg.Type == MsgCredit {
+			credit += *msg.Credit
+		}
+	})
+	v.EnableFlowControl(FlowConfig{QueueSize: 2})
+	if credit != 2 {
+		t.Fatalf("initial credit = %d, want 2", credit)
+	}
+
+	v.Enqueue(ProtocolMessage{Type: MsgTree, Root: makeSimpleTree()})
+	v.Enqueue(ProtocolMessage{Type: MsgPatch, Ops: []PatchOp{
+		{Target: 2, Set: map[string]interface{}{"content": "Queued"}},
+	}})
+	if v.Enqueue(ProtocolMessage{Type: MsgPatch}) {
+		t.Error("expected Enqueue to report a drop on a full queue")
+	}
+	if v.GetTree().Root != nil {
+		t.Error("queued messages should not be applied before Pump")
+	}
+
+	if n := v.Pump(1); n != 1 {
+		t.Errorf("Pump(1) = %d, want 1", n)
+	}
+	m := v.GetMetrics()
+	if m.FramesQueued != 1 || m.FramesDropped != 1 || m.FramesDeferred != 2 {
+		t.Errorf("queued/dropped/deferred = %d/%d/%d, want 1/1/2",
+			m.FramesQueued, m.FramesDropped, m.FramesDeferred)
+	}
+
+	v.Pump(0)
+	if !containsStr(v.GetTextProjection(), "Queued") {
+		t.Error("expected queued patch to be applied after Pump")
+	}
+	if credit != 4 {
+		t.Errorf("total credit = %d, want 4", credit)
+	}
+
+	// A frame is deferred once however many pumps it waits through.
+	v.Enqueue(ProtocolMessage{Type: MsgPatch})
+	v.Enqueue(ProtocolMessage{Type: MsgPatch})
+	v.Pump(1)
+	v.Pump(1)
+	if m := v.GetMetrics(); m.FramesDeferred != 4 {
+		t.Errorf("deferred = %d, want 4", m.FramesDeferred)
+	}
+
+	// Served frames wait in the queue for the host to pump them.
+	s := NewSourceState()
+	s.SetTree(makeSimpleTree())
+	s.Patch([]PatchOp{{Target: 2, Set: map[string]interface{}{"content": "Served"}}})
+	var buf []byte
+	for _, msg := range s.Flush() {
+		frame, err := EncodeFrame(&msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf = append(buf, frame...)
+	}
+	if err := v.Serve(bytes.NewReader(buf)); err != nil {
+		t.Fatal(err)
+	}
+	if containsStr(v.GetTextProjection(), "Served") {
+		t.Error("served frame applied before Pump")
+	}
+	if n := v.Pump(0); n != 1 || !containsStr(v.GetTextProjection(), "Served") {
+		t.Errorf("Pump = %d, want the served frame applied", n)
+	}
+}
+
+func TestViewerQuotas(t *testing.T) {
+	v := NewViewer(HeadlessTarget{})
+	clock := &ManualClock{T: time.Unix(1000, 0)}
+	v.SetClock(clock)
+
+	var violations []QuotaViolation
+	v.SetQuotas(Quotas{MaxMessagesPerSec: 3, MaxSlots: 1, MaxImageBytes: 4},
+		func(qv QuotaViolation) { violations = append(violations, qv) })
+
+	slot1, slot2 := 1, 2
+	v.ProcessMessage(ProtocolMessage{Type: MsgDefine, Slot: &slot1, SlotValue: ColorSlot{Kind: "color"}})
+	v.ProcessMessage(ProtocolMessage{Type: MsgDefine, Slot: &slot2, SlotValue: ColorSlot{Kind: "color"}})
+	v.ProcessMessage(ProtocolMessage{Type: MsgTree, Root: &VNode{
+		ID: 1, Type: NodeImage, Props: NodeProps{Data: []byte("too big")},
+	}})
+	v.ProcessMessage(ProtocolMessage{Type: MsgTree, Root: makeSimpleTree()}) // 4th in window
+
+	kinds := make([]string, len(violations))
+	for i, qv := range violations {
+		kinds[i] = qv.Kind
+	}
+	want := []string{QuotaSlots, QuotaImageBytes, QuotaMessageRate}
+	if len(kinds) != len(want) {
+		t.Fatalf("violations = %v, want %v", kinds, want)
+	}
+	for i := range want {
+		if kinds[i] != want[i] {
+			t.Errorf("violation %d = %s, want %s", i, kinds[i], want[i])
+		}
+	}
+	if v.GetTree().Root != nil {
+		t.Error("rejected trees should not be applied")
+	}
+
+	clock.Advance(time.Second)
+	v.ProcessMessage(ProtocolMessage{Type: MsgTree, Root: makeSimpleTree()})
+	if v.GetTree().Root == nil {
+		t.Error("rate window should reset after one second")
+	}
+
+	// Bytes read at the start of a window count toward it, and so do
+	// frames that fail to decode.
+	violations = nil
+	m := NewMux(nil)
+	b := m.Session(0)
+	b.SetClock(clock)
+	b.SetQuotas(Quotas{MaxBytesPerSec: 10}, func(qv QuotaViolation) { violations = append(violations, qv) })
+	clock.Advance(time.Second)
+	b.TrackBytes(20)
+	b.ProcessMessage(ProtocolMessage{Type: MsgTree, Root: makeSimpleTree()})
+	if len(violations) != 1 || violations[0].Kind != QuotaByteRate {
+		t.Errorf("violations = %+v, want a byte rate violation", violations)
+	}
+	bad := []byte{0x56, 0x50, 1, byte(MsgPatch), 1, 0, 0, 0, 0xff}
+	if err := m.Serve(bytes.NewReader(bad)); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.GetMetrics().BytesReceived; got != 20+len(bad) {
+		t.Errorf("bytesReceived = %d, want %d", got, 20+len(bad))
+	}
+
+	// Serve fails the connection rather than buffer a frame past the
+	// frame size quota, or past the byte rate while it arrives.
+	huge := append(EncodeHeader(MsgPatch, 0xFFFFFFFF), make([]byte, 40)...)
+	s := NewViewer(HeadlessTarget{})
+	s.SetQuotas(Quotas{MaxFrameBytes: 1 << 20}, nil)
+	if err := s.Serve(bytes.NewReader(huge)); !errors.Is(err, ErrFrameTooLarge) {
+		t.Errorf("oversized frame: err %v, want ErrFrameTooLarge", err)
+	}
+	violations = nil
+	s.SetClock(clock)
+	s.SetQuotas(Quotas{MaxBytesPerSec: 16}, func(qv QuotaViolation) { violations = append(violations, qv) })
+	if err := s.Serve(iotest.OneByteReader(bytes.NewReader(huge))); !errors.Is(err, ErrByteRateExceeded) {
+		t.Errorf("slow frame: err %v, want ErrByteRateExceeded", err)
+	}
+	if got := s.GetMetrics().BytesReceived; len(violations) != 1 || got != len(huge)+17 {
+		t.Errorf("violations = %+v after %d bytes, want one at 17", violations, got-len(huge))
+	}
+}
+
+func TestViewerServe(t *testing.T) {
+	v := NewViewer(HeadlessTarget{})
+
+	var stream []byte
+	for _, msg := range []*ProtocolMessage{
+		{Type: MsgTree, Root: makeSimpleTree()},
+		{Type: MsgPatch, Ops: []PatchOp{{Target: 3, Set: map[string]interface{}{"content": "Served"}}}},
+	} {
+		frame, err := EncodeFrame(msg)
+		if err != nil {
+			t.Fatalf("EncodeFrame: %v", err)
+		}
+		stream = append(stream, frame...)
+	}
+
+	if err := v.Serve(bytes.NewReader(stream)); err != nil {
+		t.Fatalf("Serve: %v", err)
+	}
+	if text := v.GetTextProjection(); text != "Hello\nServed" {
+		t.Errorf("text = %q, want %q", text, "Hello\nServed")
+	}
+	if m := v.GetMetrics(); m.BytesReceived != len(stream) {
+		t.Errorf("bytesReceived = %d, want %d", m.BytesReceived, len(stream))
+	}
+}
+
+func TestViewerServeCtxCancel(t *testing.T) {
+	v := NewViewer(HeadlessTarget{})
+	r, w := io.Pipe()
+	defer w.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- v.ServeCtx(ctx, r) }()
+
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("ServeCtx error = %v, want context.Canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ServeCtx did not return after cancel")
+	}
+}
+
+func TestViewerWaitFor(t *testing.T) {
+	v := NewViewer(HeadlessTarget{})
+
+	go v.SetTree(makeSimpleTree())
+	err := v.WaitFor(func(tree *RenderTree) bool {
+		return FindByText(tree.Root, "World") != nil
+	}, time.Second)
+	if err != nil {
+		t.Fatalf("WaitFor: %v", err)
+	}
+
+	err = v.WaitFor(func(tree *RenderTree) bool { return false }, 10*time.Millisecond)
+	if !errors.Is(err, ErrWaitTimeout) {
+		t.Errorf("WaitFor error = %v, want ErrWaitTimeout", err)
+	}
+}
+
+func TestViewerResize(t *testing.T) {
+	v := NewViewer(HeadlessTarget{})
+	v.Init(EnvInfo{ViewportVersion: 1, DisplayWidth: 80, DisplayHeight: 24, ColorDepth: 24})
+	v.SetTree(makeSimpleTree())
+	v.Render()
+
+	var envs []EnvInfo
+	v.OnMessage(func(msg ProtocolMessage) {
+		if msg.Type == MsgEnv {
+			envs = append(envs, *msg.Env)
+		}
+	})
+
+	v.Resize(120, 40)
+	v.Resize(120, 40) // unchanged: no message
+
+	if len(envs) != 1 {
+		t.Fatalf("env messages = %d, want 1", len(envs))
+	}
+	if envs[0].DisplayWidth != 120 || envs[0].DisplayHeight != 40 || envs[0].ColorDepth != 24 {
+		t.Errorf("env = %+v, want 120x40 with colorDepth preserved", envs[0])
+	}
+	if env := v.GetEnv(); env.DisplayWidth != 120 {
+		t.Errorf("GetEnv width = %d, want 120", env.DisplayWidth)
+	}
+	if v.Render() {
+		t.Error("Resize should have re-rendered already")
+	}
+}
+
+func TestCheckRequirements(t *testing.T) {
+	env := EnvInfo{ViewportVersion: 1, DisplayWidth: 80, ColorDepth: 8, VideoDecode: []string{"h264"}}
+
+	if err := CheckRequirements(env, Requirements{MinColorDepth: 8, VideoDecode: []string{"h264"}}); err != nil {
+		t.Errorf("expected compatible, got %v", err)
+	}
+
+	err := CheckRequirements(env, Requirements{MinColorDepth: 24, GPU: true, VideoDecode: []string{"av1"}})
+	var incompatible *IncompatibleError
+	if !errors.As(err, &incompatible) {
+		t.Fatalf("expected *IncompatibleError, got %v", err)
+	}
+	if len(incompatible.Unmet) != 3 {
+		t.Errorf("unmet = %v, want 3 entries", incompatible.Unmet)
+	}
+}
+
+func TestViewerHandshake(t *testing.T) {
 	v := NewViewer(HeadlessTarget{})
+	if err := v.Handshake(); !errors.Is(err, ErrNoEnv) {
+		t.Errorf("Handshake before Init = %v, want ErrNoEnv", err)
+	}
 
-	v.ProcessMessage(ProtocolMessage{
-		Type: MsgTree,
-		Root: makeSimpleTree(),
+	v.Init(EnvInfo{ViewportVersion: 1, DisplayWidth: 80, DisplayHeight: 24, ColorDepth: 8})
+	var first *ProtocolMessage
+	v.OnMessage(func(msg ProtocolMessage) {
+		if first == nil {
+			first = &msg
+		}
 	})
 
-	tree := v.GetTree()
-	if tree.Root == nil {
-		t.Fatal("expected non-nil root after ProcessMessage")
+	var stream []byte
+	for _, msg := range []*ProtocolMessage{
+		{Type: MsgRequire, Requires: &Requirements{MinColorDepth: 24}},
+		{Type: MsgTree, Root: makeSimpleTree()},
+	} {
+		frame, _ := EncodeFrame(msg)
+		stream = append(stream, frame...)
 	}
-	if CountNodes(tree.Root) != 3 {
-		t.Errorf("node count = %d, want 3", CountNodes(tree.Root))
+
+	err := v.Serve(bytes.NewReader(stream))
+	var incompatible *IncompatibleError
+	if !errors.As(err, &incompatible) {
+		t.Fatalf("Serve error = %v, want *IncompatibleError", err)
+	}
+	if first == nil || first.Type != MsgEnv || first.Env.ColorDepth != 8 {
+		t.Errorf("first outbound message = %+v, want MsgEnv", first)
+	}
+	if req := v.SourceRequirements(); req == nil || req.MinColorDepth != 24 {
+		t.Errorf("SourceRequirements = %+v", req)
+	}
+	if v.GetTree().Root != nil {
+		t.Error("serving should stop before the tree is applied")
+	}
+}
+
+func TestViewerEnvConditionalSlots(t *testing.T) {
+	v := NewViewer(HeadlessTarget{})
+	v.Init(EnvInfo{ViewportVersion: 1, DisplayWidth: 200, DisplayHeight: 50, ColorDepth: 24})
+
+	v.DefineSlot(1, ColorSlot{Kind: "color", Role: "accent", Value: "#3a7bd5",
+		Variants: []ColorVariant{{When: EnvCondition{MaxColorDepth: 23}, Value: "blue"}}})
+	v.DefineSlot(2, StyleSlot{Kind: "style", Props: map[string]interface{}{"padding": 2, "weight": "bold"},
+		Variants: []StyleVariant{{When: EnvCondition{MaxDisplayWidth: 99}, Props: map[string]interface{}{"padding": 0}}}})
+
+	if c := v.ResolvedSlot(1).(ColorSlot); c.Value != "#3a7bd5" {
+		t.Errorf("rich color = %s, want #3a7bd5", c.Value)
+	}
+
+	v.ProcessMessage(ProtocolMessage{Type: MsgEnv, Env: &EnvInfo{DisplayWidth: 200, ColorDepth: 8}})
+	if c := v.ResolvedSlot(1).(ColorSlot); c.Value != "blue" {
+		t.Errorf("8-bit color = %s, want blue", c.Value)
+	}
+
+	v.Resize(80, 24)
+	style := v.ResolvedSlot(2).(StyleSlot)
+	if style.Props["padding"] != 0 || style.Props["weight"] != "bold" {
+		t.Errorf("compact style = %v, want padding 0 and weight preserved", style.Props)
+	}
+}
+
+func TestMuxSessions(t *testing.T) {
+	m := NewMux(nil)
+	outbound := map[uint64]int{}
+	m.OnMessage(func(session uint64, msg ProtocolMessage) { outbound[session]++ })
+
+	var stream []byte
+	for _, f := range []struct {
+		session uint64
+		msg     ProtocolMessage
+	}{
+		{1, ProtocolMessage{Type: MsgTree, Root: makeSimpleTree()}},
+		{2, ProtocolMessage{Type: MsgTree, Root: &VNode{ID: 1, Type: NodeText, Props: NodeProps{Content: strPtr("Pane 2")}}}},
+		{1, ProtocolMessage{Type: MsgPatch, Ops: []PatchOp{{Target: 2, Set: map[string]interface{}{"content": "Pane 1"}}}}},
+	} {
+		msg := f.msg
+		frame, err := EncodeSessionFrame(&msg, f.session)
+		if err != nil {
+			t.Fatalf("EncodeSessionFrame: %v", err)
+		}
+		stream = append(stream, frame...)
+	}
+
+	if err := m.Serve(bytes.NewReader(stream)); err != nil {
+		t.Fatalf("Serve: %v", err)
+	}
+	if ids := m.Sessions(); len(ids) != 2 || ids[0] != 1 || ids[1] != 2 {
+		t.Fatalf("sessions = %v, want [1 2]", ids)
+	}
+
+	v1, _ := m.Lookup(1)
+	v2, _ := m.Lookup(2)
+	if text := v1.GetTextProjection(); text != "Pane 1\nWorld" {
+		t.Errorf("session 1 text = %q", text)
+	}
+	if text := v2.GetTextProjection(); text != "Pane 2" {
+		t.Errorf("session 2 text = %q", text)
+	}
+	if v1.GetMetrics().MessagesProcessed != 2 || v2.GetMetrics().MessagesProcessed != 1 {
+		t.Error("expected per-session message counts")
+	}
+
+	v2.SendInput(InputEvent{Kind: "click"})
+	if outbound[2] != 1 || outbound[1] != 0 {
+		t.Errorf("outbound = %v, want only session 2", outbound)
+	}
+
+	if !m.CloseSession(2) || len(m.Sessions()) != 1 {
+		t.Error("expected session 2 to close")
+	}
+
+	// Frames cannot open sessions past the limit; the source is told.
+	var errs []uint64
+	m.OnMessage(func(session uint64, msg ProtocolMessage) {
+		if msg.Type == MsgError && msg.Error.Code == ErrorCodeQuota {
+			errs = append(errs, session)
+		}
+	})
+	m.SetMaxSessions(2)
+	for _, session := range []uint64{3, 4, 1} {
+		frame, err := EncodeSessionFrame(&ProtocolMessage{Type: MsgTree, Root: makeSimpleTree()}, session)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Serve(bytes.NewReader(frame)); err != nil {
+			t.Fatalf("Serve: %v", err)
+		}
+	}
+	if ids := m.Sessions(); len(ids) != 2 || ids[1] != 3 || m.RejectedFrames() != 1 || len(errs) != 1 || errs[0] != 4 {
+		t.Errorf("sessions = %v, %d rejected, errors on %v; want [1 3], 1, [4]", ids, m.RejectedFrames(), errs)
+	}
+}
+
+func TestViewerAttachTerminal(t *testing.T) {
+	var out bytes.Buffer
+	target := AnsiTarget{FD: 1}
+	v := NewViewer(target)
+	term := NewTerminal(&out, 10, 3)
+	v.AttachTerminal(target, term)
+
+	v.SetTree(makeSimpleTree())
+	v.Render()
+	if got, _ := v.TargetOutput(target); got != "Hello\nWorld" {
+		t.Errorf("output = %q, want grid text", got)
+	}
+	if !containsStr(out.String(), "Hello") {
+		t.Errorf("terminal not drawn: %q", out.String())
+	}
+
+	layout := v.GetLayout(3)
+	if layout == nil || layout.Y != 20 {
+		t.Errorf("GetLayout(3) = %+v, want Y=20", layout)
+	}
+}
+
+func TestViewerImageDecodeError(t *testing.T) {
+	target := AnsiTarget{FD: 1}
+	v := NewViewer(target)
+	v.AttachTerminal(target, NewTerminal(io.Discard, 20, 2))
+
+	var errs []error
+	v.OnError(func(err error) { errs = append(errs, err) })
+	v.SetTree(&VNode{
+		ID:    1,
+		Type:  NodeImage,
+		Props: NodeProps{Data: []byte("corrupt"), Format: "png", AltText: strPtr("logo")},
+	})
+	v.Render()
+	v.Render()
+
+	var imgErr *ImageError
+	if len(errs) != 1 || !errors.As(errs[0], &imgErr) || imgErr.NodeID != 1 {
+		t.Fatalf("errors = %v, want one ImageError for #1", errs)
+	}
+	if got, _ := v.TargetOutput(target); got != "logo" {
+		t.Errorf("output = %q, want alt text", got)
+	}
+}
+
+func TestParseScript(t *testing.T) {
+	s, err := ParseScript(strings.NewReader(`
+# counter test
+connect tcp localhost:7000
+wait-text "Count: 0" 2s   # inline comment
+type #4 "say \"hi\""
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Steps) != 3 {
+		t.Fatalf("steps = %d, want 3", len(s.Steps))
+	}
+	if got := s.Steps[2]; got.Line != 5 || got.Args[1] != `say "hi"` {
+		t.Errorf("type step = %+v", got)
+	}
+
+	_, err = ParseScript(strings.NewReader("click\n"))
+	var scriptErr *ScriptError
+	if !errors.As(err, &scriptErr) || scriptErr.Line != 1 {
+		t.Errorf("err = %v, want arity error on line 1", err)
+	}
+}
+
+func TestScriptRunner(t *testing.T) {
+	client, server := net.Pipe()
+	counter := func(n int) []byte {
+		frame, _ := EncodeFrame(&ProtocolMessage{Type: MsgTree, Root: &VNode{
+			ID:    1,
+			Type:  NodeBox,
+			Props: NodeProps{Interactive: "clickable"},
+			Children: []*VNode{
+				{ID: 2, Type: NodeText, Props: NodeProps{Content: strPtr(fmt.Sprintf("Count: %d", n))}},
+			},
+		}})
+		return frame
+	}
+	go func() {
+		server.Write(counter(0))
+		fr := NewFrameReader()
+		buf := make([]byte, 4096)
+		for {
+			n, err := server.Read(buf)
+			if err != nil {
+				return
+			}
+			frames, _ := fr.Feed(buf[:n])
+			for _, f := range frames {
+				msg, _ := DecodeMessage(f.Header, f.Payload)
+				if msg.Type == MsgInput && msg.Event.Kind == "click" && *msg.Event.Target == 1 {
+					server.Write(counter(1))
+				}
+			}
+		}
+	}()
+
+	script, err := ParseScript(strings.NewReader(`
+connect pipe test
+wait-text "Count: 0" 1s
+click "Count"
+wait-text "Count: 1" 1s
+expect-no-text "Count: 0"
+close
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	runner := &ScriptRunner{
+		Viewer: NewViewer(HeadlessTarget{}),
+		Dial: func(ctx context.Context, network string, args []string) (io.ReadWriteCloser, error) {
+			return client, nil
+		},
+	}
+	if err := runner.Run(context.Background(), script); err != nil {
+		t.Fatal(err)
+	}
+
+	failing, _ := ParseScript(strings.NewReader(`expect-text "missing"`))
+	err = runner.Run(context.Background(), failing)
+	if !errors.Is(err, ErrAssertion) {
+		t.Errorf("err = %v, want ErrAssertion", err)
 	}
 }
 
@@ -616,6 +6245,506 @@ func TestViewerTrackBytes(t *testing.T) {
 	}
 }
 
+// ── Source tests ─────────────────────────────────────────────────────
+
+func TestSourceStateFlush(t *testing.T) {
+	s := NewSourceState()
+	if msgs := s.Flush(); msgs != nil {
+		t.Fatalf("empty flush = %v, want nil", msgs)
+	}
+
+	s.DefineSlot(1, ColorSlot{Kind: "color", Role: "accent", Value: "#ff0000"})
+	s.SetTree(makeSimpleTree())
+	msgs := s.Flush()
+	if len(msgs) != 2 || msgs[0].Type != MsgDefine || msgs[1].Type != MsgTree {
+		t.Fatalf("first flush = %v, want DEFINE then TREE", msgs)
+	}
+	if msgs[0].Seq != nil || msgs[1].Seq == nil || *msgs[1].Seq != 1 {
+		t.Error("only the last message of a flush should carry the seq")
+	}
+
+	s.Patch([]PatchOp{{Target: 2, Set: map[string]interface{}{"content": "A", "weight": "bold"}}})
+	s.Patch([]PatchOp{{Target: 2, Set: map[string]interface{}{"content": "B"}}})
+	s.Patch([]PatchOp{{Target: 1, ChildrenRemove: &ChildrenRemove{Index: 1}}})
+	msgs = s.Flush()
+	if len(msgs) != 1 || msgs[0].Type != MsgPatch || len(msgs[0].Ops) != 2 {
+		t.Fatalf("patch flush = %+v, want one PATCH with 2 ops", msgs)
+	}
+	if set := msgs[0].Ops[0].Set; set["content"] != "B" || set["weight"] != "bold" {
+		t.Errorf("coalesced set = %v", set)
+	}
+	if pub := s.Published(); CountNodes(pub.Root) != 2 || *pub.NodeIndex[2].Props.Content != "B" {
+		t.Errorf("published tree not updated: %s", TreeString(pub.Root))
+	}
+
+	s.HandleControl(ProtocolMessage{Type: MsgResync, Ack: new(uint64)})
+	msgs = s.Flush()
+	if len(msgs) != 1 || msgs[0].Type != MsgTree || CountNodes(VNodeToRenderNode(msgs[0].Root, map[int]*RenderNode{})) != 2 {
+		t.Fatalf("resync flush = %+v, want the published tree", msgs)
+	}
+	if s.ResyncRequested || s.Seq != 3 {
+		t.Errorf("resync = %v, seq = %d after resend", s.ResyncRequested, s.Seq)
+	}
+}
+
+func TestSourceStatePatchReinserted(t *testing.T) {
+	s := NewSourceState()
+	s.SetTree(makeSimpleTree())
+	s.Flush()
+
+	// A Set after its node is reinserted must not merge into one made
+	// before, which the reinsert overwrites.
+	s.Patch([]PatchOp{{Target: 2, Set: map[string]interface{}{"content": "Old"}}})
+	s.Patch([]PatchOp{{Target: 1, ChildrenSet: &ChildrenSet{Nodes: []*VNode{
+		{ID: 2, Type: NodeText, Props: NodeProps{Content: strPtr("Fresh")}},
+	}}}})
+	s.Patch([]PatchOp{{Target: 2, Set: map[string]interface{}{"content": "New"}}})
+	msgs := s.Flush()
+	if len(msgs) != 1 || len(msgs[0].Ops) != 3 {
+		t.Fatalf("flush = %+v, want one PATCH with 3 ops", msgs)
+	}
+
+	v := NewViewer(HeadlessTarget{})
+	v.ProcessMessage(ProtocolMessage{Type: MsgTree, Root: makeSimpleTree()})
+	v.ProcessMessage(msgs[0])
+	if got := *v.GetTree().NodeIndex[2].Props.Content; got != "New" {
+		t.Errorf("viewer content = %q, want New", got)
+	}
+	if got := *s.Published().NodeIndex[2].Props.Content; got != "New" {
+		t.Errorf("published content = %q, want New", got)
+	}
+}
+
+type listComponent struct {
+	items []string
+	title string
+}
+
+func (c *listComponent) Render() *VNode {
+	title := Text(c.title).ID(2)
+	if c.title == "Done" {
+		title.Italic()
+	}
+	list := Column().ID(3)
+	for i, item := range c.items {
+		list.Children(Text(item).ID(100 + int(item[0])).Weight([]string{"normal", "bold"}[i%2]))
+	}
+	return Column(title, list).ID(1).MustBuild()
+}
+
+func TestComponentReconcile(t *testing.T) {
+	v := NewViewer(HeadlessTarget{})
+	c := &listComponent{title: "Todo", items: []string{"a", "b", "c"}}
+	root := Mount(NewSourceState(), c)
+	send := func() []ProtocolMessage {
+		msgs := root.Flush()
+		for _, msg := range msgs {
+			frame, err := EncodeFrame(&msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			header, payload, err := DecodeFrame(frame)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := DecodeMessage(header, payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			v.ProcessMessage(decoded)
+		}
+		return msgs
+	}
+	check := func() {
+		t.Helper()
+		want := NewViewer(HeadlessTarget{})
+		want.SetTree(c.Render())
+		if got, exp := v.GetTextProjection(), want.GetTextProjection(); got != exp {
+			t.Errorf("projection = %q, want %q", got, exp)
+		}
+		if got, exp := TreeString(v.GetTree().Root), TreeString(want.GetTree().Root); got != exp {
+			t.Errorf("tree =\n%s\nwant\n%s", got, exp)
+		}
+	}
+
+	if msgs := send(); len(msgs) != 1 || msgs[0].Type != MsgTree {
+		t.Fatalf("first flush = %v, want a full tree", msgs)
+	}
+	check()
+
+	if msgs := send(); msgs != nil {
+		t.Errorf("flush without update = %v, want nil", msgs)
+	}
+
+	root.Update(func() {
+		c.title = "Done"
+		c.items = []string{"c", "a", "d"}
+	})
+	msgs := send()
+	if len(msgs) != 1 || msgs[0].Type != MsgPatch {
+		t.Fatalf("update flush = %v, want one PATCH", msgs)
+	}
+	for _, op := range msgs[0].Ops {
+		if op.Replace != nil {
+			t.Errorf("unexpected replace of %d", op.Target)
+		}
+	}
+	check()
+
+	root.Update(func() { c.title = "Todo" }) // clearing italic needs a replace
+	send()
+	check()
+}
+
+func TestIDAllocator(t *testing.T) {
+	a := NewIDAllocator()
+	if !a.Reserve(2) || a.Reserve(2) {
+		t.Error("Reserve should succeed once")
+	}
+	if id := a.Alloc(); id != 1 {
+		t.Errorf("first Alloc = %d, want 1", id)
+	}
+	k := a.Key("row")
+	if k != 3 || a.Key("row") != k {
+		t.Errorf("Key = %d, want a stable 3", k)
+	}
+
+	a.Release(k)
+	if a.Live(k) {
+		t.Error("released id still live")
+	}
+	if id := a.Alloc(); id == k {
+		t.Error("released id reused before Recycle")
+	}
+	a.Recycle()
+	if id := a.Key("other"); id != k {
+		t.Errorf("after Recycle Key = %d, want recycled %d", id, k)
+	}
+	if a.Key("row") == k {
+		t.Error("released key should not keep its old binding")
+	}
+}
+
+func TestComponentRecyclesIDs(t *testing.T) {
+	state := NewSourceState()
+	items := []string{"a", "b"}
+	root := Mount(state, ComponentFunc(func() *VNode {
+		list := Column()
+		for _, item := range items {
+			list.Children(Text(item).Key(item))
+		}
+		node, err := list.BuildWith(state.IDs())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return node
+	}))
+	root.Flush()
+	bID := state.Published().Root.Children[1].ID
+
+	root.Update(func() { items = []string{"a"} })
+	root.Flush()
+	if state.IDs().Live(bID) {
+		t.Fatal("removed node's id should be released")
+	}
+
+	root.Update(func() { items = []string{"a", "c"} })
+	msgs := root.Flush()
+	if len(msgs) != 1 || msgs[0].Type != MsgPatch {
+		t.Fatalf("flush = %+v, want a PATCH", msgs)
+	}
+	if cID := state.Published().Root.Children[1].ID; cID != bID {
+		t.Errorf("new node id = %d, want recycled %d", cID, bID)
+	}
+}
+
+func TestSlotInterner(t *testing.T) {
+	build := func() *VNode {
+		row := func(s string) *Element {
+			return Text(s).Color("#ff0000").Weight("bold").Italic()
+		}
+		return Column(row("one"), row("two"), Text("three").Color("#00ff00")).MustBuild()
+	}
+
+	state := NewSourceState()
+	in := NewSlotInterner(state, 1000)
+	root := in.Intern(build())
+	for _, c := range root.Children[:2] {
+		if c.Props.Color != 1000 || c.Props.Style == nil || *c.Props.Style != 1001 || c.Props.Weight != "" {
+			t.Errorf("node %d not interned: %+v", c.ID, c.Props)
+		}
+	}
+	if root.Children[2].Props.Color != "#00ff00" {
+		t.Error("a color used once should stay inline")
+	}
+
+	state.SetTree(root)
+	msgs := state.Flush()
+	if len(msgs) != 3 || msgs[0].Type != MsgDefine || msgs[1].Type != MsgDefine {
+		t.Fatalf("flush = %v, want two DEFINEs then the tree", msgs)
+	}
+
+	slots := state.Published().Slots
+	got := RenderGrid(state.Published(), 10, 3, func(id int) SlotValue { return slots[id] })
+	plain := NewRenderTree()
+	SetTreeRoot(plain, build())
+	if want := RenderGrid(plain, 10, 3, nil); got.ANSI() != want.ANSI() {
+		t.Errorf("interned tree renders differently:\n%q\n%q", got.ANSI(), want.ANSI())
+	}
+
+	in.Intern(build())
+	if msgs := state.Flush(); msgs != nil {
+		t.Errorf("re-interning known values should define nothing, got %v", msgs)
+	}
+}
+
+func TestSourceStateDeltaSet(t *testing.T) {
+	s := NewSourceState()
+	s.SetTree(makeSimpleTree())
+	s.Flush()
+
+	s.Patch([]PatchOp{{Target: 2, Set: map[string]interface{}{"content": "Changed"}}})
+	s.Patch([]PatchOp{{Target: 2, Set: map[string]interface{}{"content": "Hello", "color": nil}}})
+	if msgs := s.Flush(); msgs != nil {
+		t.Errorf("set back to published values should send nothing, got %+v", msgs)
+	}
+
+	s.Patch([]PatchOp{{Target: 2, Set: map[string]interface{}{"content": "Hello", "weight": "bold", "gap": 2}}})
+	msgs := s.Flush()
+	if len(msgs) != 1 || len(msgs[0].Ops) != 1 {
+		t.Fatalf("flush = %+v, want one op", msgs)
+	}
+	if set := msgs[0].Ops[0].Set; len(set) != 2 || set["weight"] != "bold" {
+		t.Errorf("set = %v, want only weight and gap", set)
+	}
+
+	s.Patch([]PatchOp{{Target: 2, Set: map[string]interface{}{"gap": 2.0, "weight": "bold"}}})
+	if msgs := s.Flush(); msgs != nil {
+		t.Errorf("numerically equal values should be dropped, got %+v", msgs)
+	}
+}
+
+func TestSourceStateDeltaTrees(t *testing.T) {
+	s := NewSourceState()
+	s.DeltaTrees = true
+	v := NewViewer(HeadlessTarget{})
+	flush := func() []ProtocolMessage {
+		msgs := s.Flush()
+		for _, msg := range msgs {
+			v.ProcessMessage(msg)
+		}
+		return msgs
+	}
+
+	s.SetTree(makeSimpleTree())
+	if msgs := flush(); len(msgs) != 1 || msgs[0].Type != MsgTree {
+		t.Fatalf("first flush = %+v, want a tree", msgs)
+	}
+
+	s.SetTree(makeSimpleTree())
+	if msgs := flush(); msgs != nil {
+		t.Errorf("unchanged tree should send nothing, got %+v", msgs)
+	}
+
+	next := makeSimpleTree()
+	next.Children[1].Props.Content = strPtr("Delta")
+	s.SetTree(next)
+	if msgs := flush(); len(msgs) != 1 || msgs[0].Type != MsgPatch || len(msgs[0].Ops) != 1 {
+		t.Fatalf("changed tree flush = %+v, want a one-op patch", msgs)
+	}
+	if got := v.GetTextProjection(); !containsStr(got, "Delta") {
+		t.Errorf("viewer projection = %q after delta", got)
+	}
+
+	s.HandleControl(ProtocolMessage{Type: MsgResync, Ack: new(uint64)})
+	s.SetTree(next)
+	if msgs := flush(); len(msgs) != 1 || msgs[0].Type != MsgTree {
+		t.Errorf("resync flush = %+v, want a full tree", msgs)
+	}
+
+	s.SetTree(&VNode{ID: 9, Type: NodeText, Props: NodeProps{Content: strPtr("new root")}})
+	if msgs := flush(); len(msgs) != 1 || msgs[0].Type != MsgTree {
+		t.Errorf("new root flush = %+v, want a full tree", msgs)
+	}
+}
+
+func TestSourceStateFlushBudget(t *testing.T) {
+	s := NewSourceState()
+	s.SetTree(makeSimpleTree())
+	s.Flush()
+
+	for i := 0; i < 5; i++ {
+		s.Patch([]PatchOp{{Target: 1, ChildrenInsert: &ChildrenInsert{Index: 0, Node: &VNode{ID: 10 + i, Type: NodeSeparator}}}})
+	}
+	s.EmitData(1, []interface{}{"row"})
+
+	var sizes []int
+	for s.HasPending() {
+		msgs := s.FlushWithin(FlushBudget{MaxOps: 2})
+		n := 0
+		for _, msg := range msgs {
+			n += len(msg.Ops)
+			if msg.Type == MsgData {
+				n++
+			}
+		}
+		sizes = append(sizes, n)
+	}
+	if fmt.Sprint(sizes) != "[2 2 2]" {
+		t.Errorf("ops per flush = %v, want [2 2 2]", sizes)
+	}
+	if n := CountNodes(s.Published().Root); n != 8 {
+		t.Errorf("published nodes = %d, want 8", n)
+	}
+
+	s.Patch([]PatchOp{{Target: 2, Set: map[string]interface{}{"content": strings.Repeat("x", 100)}}})
+	if msgs := s.FlushWithin(FlushBudget{MaxBytes: 10}); len(msgs) != 1 || s.HasPending() {
+		t.Error("an op larger than the byte budget should still be sent alone")
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	count := 0
+	root := Mount(NewSourceState(), ComponentFunc(func() *VNode {
+		return Text(fmt.Sprint(count)).MustBuild()
+	}))
+
+	frames := make(chan []ProtocolMessage, 10)
+	sched := NewScheduler(root, func(msgs []ProtocolMessage) error { return nil })
+	sched.Interval = 50 * time.Millisecond
+	sched.OnFlush(func(msgs []ProtocolMessage) { frames <- msgs })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- sched.Run(ctx) }()
+
+	first := <-frames
+	if len(first) != 1 || first[0].Type != MsgTree {
+		t.Fatalf("first frame = %v, want the tree", first)
+	}
+	for i := 0; i < 5; i++ {
+		root.Update(func() { count++ })
+	}
+	next := <-frames
+	if len(next) != 1 || next[0].Type != MsgPatch || next[0].Ops[0].Set["content"] != "5" {
+		t.Errorf("burst frame = %+v, want one coalesced patch", next)
+	}
+
+	// Without credit nothing is sent until the viewer grants some.
+	root.State(func(s *SourceState) {
+		s.HandleControl(ProtocolMessage{Type: MsgCredit, Credit: new(int)})
+	})
+	root.Update(func() { count++ })
+	select {
+	case msgs := <-frames:
+		t.Fatalf("frame sent without credit: %v", msgs)
+	case <-time.After(60 * time.Millisecond):
+	}
+	one := 1
+	root.State(func(s *SourceState) { s.HandleControl(ProtocolMessage{Type: MsgCredit, Credit: &one}) })
+	select {
+	case <-frames:
+	case <-time.After(time.Second):
+		t.Fatal("no frame after credit was granted")
+	}
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Run = %v, want context.Canceled", err)
+	}
+}
+
+func TestSourceStateCredit(t *testing.T) {
+	s := NewSourceState()
+	two := 2
+	s.HandleControl(ProtocolMessage{Type: MsgCredit, Credit: &two})
+
+	// A flush takes a credit per message; what does not fit waits.
+	s.DefineSchema(10, []SchemaColumn{{ID: 0, Name: "n", Type: "uint"}})
+	s.SetTree(makeSimpleTree())
+	s.EmitData(10, []interface{}{1})
+	msgs := s.Flush()
+	if len(msgs) != 2 || msgs[0].Type != MsgSchema || msgs[1].Type != MsgTree {
+		t.Fatalf("first flush = %+v, want schema and tree", msgs)
+	}
+	if s.Credit != 0 || s.CanSend() || !s.HasPending() {
+		t.Errorf("credit = %d, canSend = %v, pending = %v; want 0, false, true",
+			s.Credit, s.CanSend(), s.HasPending())
+	}
+	if msgs := s.Flush(); msgs != nil {
+		t.Errorf("flush without credit sent %+v", msgs)
+	}
+
+	// Pongs go out at once, without credit.
+	nonce := uint64(5)
+	s.HandleControl(ProtocolMessage{Type: MsgPing, Nonce: &nonce})
+	if !s.HasPending() {
+		t.Error("unanswered ping not pending")
+	}
+	if msgs := s.Flush(); len(msgs) != 1 || msgs[0].Type != MsgPong || *msgs[0].Nonce != 5 || s.Credit != 0 {
+		t.Errorf("flush without credit = %+v (credit %d), want the pong", msgs, s.Credit)
+	}
+
+	// Held messages go before anything new.
+	s.Patch([]PatchOp{{Target: 2, Set: map[string]interface{}{"content": "Later"}}})
+	s.HandleControl(ProtocolMessage{Type: MsgCredit, Credit: &two})
+	msgs = s.Flush()
+	if len(msgs) != 1 || msgs[0].Type != MsgData || msgs[0].Seq == nil || *msgs[0].Seq != 1 {
+		t.Fatalf("second flush = %+v, want the held data row with seq 1", msgs)
+	}
+	msgs = s.Flush()
+	if len(msgs) != 1 || msgs[0].Type != MsgPatch || s.Credit != 0 || s.HasPending() {
+		t.Errorf("third flush = %+v (credit %d), want the patch", msgs, s.Credit)
+	}
+}
+
+func TestDiffTree(t *testing.T) {
+	prev := makeSimpleTree()
+	next := makeSimpleTree()
+	next.Children[0].Props.Content = strPtr("Hi")
+	next.Children[0].Props.Color = "#00ff00"
+	ops, ok := DiffTree(prev, next)
+	if !ok || len(ops) != 1 || ops[0].Target != 2 || len(ops[0].Set) != 2 {
+		t.Fatalf("ops = %+v, ok = %v", ops, ok)
+	}
+
+	next.Children[0].Props.Color = nil
+	ops, _ = DiffTree(prev, next)
+	if len(ops) != 1 || len(ops[0].Set) != 1 {
+		t.Errorf("unchanged color should not be sent: %+v", ops)
+	}
+
+	next.ID = 9
+	if _, ok := DiffTree(prev, next); ok {
+		t.Error("root ID change should require a full tree")
+	}
+
+	// In-process patches carry column widths as []int.
+	table := func(widths ...int) *VNode {
+		return &VNode{ID: 1, Type: NodeTable, Props: NodeProps{ColumnWidths: widths}}
+	}
+	v := NewViewer(HeadlessTarget{})
+	v.SetTree(table(5, 6))
+	for _, widths := range [][]int{{9, 9}, nil} {
+		ops, _ := DiffTree(renderNodeToVNode(v.GetTree().Root), table(widths...))
+		if err := ValidatePatches(v.GetTree(), ops); err != nil {
+			t.Errorf("ValidatePatches(%+v) = %v", ops, err)
+		}
+		v.ApplyPatches(ops)
+		if got := v.GetTree().Root.Props.ColumnWidths; !reflect.DeepEqual(got, widths) {
+			t.Errorf("columnWidths after %+v = %v, want %v", ops, got, widths)
+		}
+	}
+	v.SetTree(table(5, 6))
+	reset := []PatchOp{{Target: 1, Set: map[string]interface{}{"columnWidths": []interface{}{}}}}
+	if err := ValidatePatches(v.GetTree(), reset); err != nil {
+		t.Errorf("ValidatePatches(empty widths) = %v", err)
+	}
+	if v.ApplyPatches(reset); v.GetTree().Root.Props.ColumnWidths != nil {
+		t.Errorf("empty widths left %v", v.GetTree().Root.Props.ColumnWidths)
+	}
+}
+
 // ── Helpers ──────────────────────────────────────────────────────────
 
 func containsStr(s, substr string) bool {
@@ -630,3 +6759,39 @@ func findSubstr(s, substr string) bool {
 	}
 	return false
 }
+
+// fakeGPU is a GPUBackend keeping textures in memory.
+type fakeGPU struct {
+	textures map[TextureHandle]*image.RGBA
+	next     TextureHandle
+	uploaded []image.Rectangle
+}
+
+func (g *fakeGPU) CreateTexture(width, height int) (TextureHandle, error) {
+	g.next++
+	g.textures[g.next] = image.NewRGBA(image.Rect(0, 0, width, height))
+	return g.next, nil
+}
+
+func (g *fakeGPU) UpdateTexture(tex TextureHandle, frame *image.RGBA, regions []image.Rectangle) error {
+	for _, r := range regions {
+		draw.Draw(g.textures[tex], r, frame, r.Min, draw.Src)
+	}
+	g.uploaded = append(g.uploaded, regions...)
+	return nil
+}
+
+func (g *fakeGPU) DeleteTexture(tex TextureHandle) { delete(g.textures, tex) }
+
+// failWriter buffers writes, or fails them while fail is set.
+type failWriter struct {
+	buf  bytes.Buffer
+	fail bool
+}
+
+func (w *failWriter) Write(p []byte) (int, error) {
+	if w.fail {
+		return 0, errors.New("write failed")
+	}
+	return w.buf.Write(p)
+}
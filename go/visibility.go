@@ -0,0 +1,36 @@
+package viewer
+
+// Hiding. A node with the hidden prop, or a display of "none", stays in
+// the tree with its ID and state — an input's value and cursor, a
+// scroll offset, a table's selection — but is otherwise gone along with
+// its subtree: it takes no space in its parent's flow, is not drawn or
+// hit by the pointer, is skipped in tab order and refused focus, and is
+// left out of text projections. The HTML renderer keeps it in the
+// document with display:none. Clearing the prop brings the subtree back
+// as it was.
+
+// DisplayNone is the display of a hidden node.
+const DisplayNone = "none"
+
+// isHidden reports whether a node is hidden.
+func isHidden(node *RenderNode) bool {
+	return node.Props.Hidden != nil && *node.Props.Hidden || node.Props.Display == DisplayNone
+}
+
+// hidden reports whether a node or one of its ancestors is hidden.
+// Must be called with the mutex held.
+func (v *Viewer) hidden(nodeID int) bool {
+	node := v.tree.NodeIndex[nodeID]
+	if node == nil {
+		return false
+	}
+	if isHidden(node) {
+		return true
+	}
+	for _, a := range ancestors(v.tree.Root, nodeID) {
+		if isHidden(a) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,269 @@
+package viewer
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Display width of text in terminal cells.
+//
+// Text is measured by grapheme cluster: a base character with its
+// combining marks, variation selectors, and emoji modifiers, emoji joined
+// with ZWJ, and regional-indicator flag pairs each count as one unit. A
+// cluster is two cells wide if its base is East Asian Wide or Fullwidth,
+// an emoji with default emoji presentation, a flag, or is followed by the
+// emoji presentation selector (U+FE0F); zero wide if it is only marks or
+// format characters; and one cell otherwise. This follows what common
+// terminals draw, not the full UAX #29 and #11 rules.
+
+// RuneWidth returns the number of cells r occupies on its own: 0 for
+// control, format, and combining characters, 2 for wide characters, 1
+// otherwise.
+func RuneWidth(r rune) int {
+	switch {
+	case r < 0x20 || (r >= 0x7f && r < 0xa0):
+		return 0
+	case r < 0x300:
+		return 1
+	case zeroWidth(r):
+		return 0
+	case inTable(r, wideRanges):
+		return 2
+	}
+	return 1
+}
+
+// StringWidth returns the display width of a single line of text.
+func StringWidth(s string) int {
+	w := 0
+	for len(s) > 0 {
+		n, cw := nextGrapheme(s)
+		w += cw
+		s = s[n:]
+	}
+	return w
+}
+
+// Graphemes splits s into grapheme clusters.
+func Graphemes(s string) []string {
+	var out []string
+	for len(s) > 0 {
+		n, _ := nextGrapheme(s)
+		out = append(out, s[:n])
+		s = s[n:]
+	}
+	return out
+}
+
+// TruncateWidth shortens s to at most width cells, never splitting a
+// cluster. If s is cut, tail (e.g. "…") is appended within the width.
+func TruncateWidth(s string, width int, tail string) string {
+	if StringWidth(s) <= width {
+		return s
+	}
+	limit := width - StringWidth(tail)
+	if limit < 0 {
+		return TruncateWidth(tail, width, "")
+	}
+	end, w := 0, 0
+	for end < len(s) {
+		n, cw := nextGrapheme(s[end:])
+		if w+cw > limit {
+			break
+		}
+		w += cw
+		end += n
+	}
+	return s[:end] + tail
+}
+
+// PadWidth pads s with spaces on the right to width cells.
+func PadWidth(s string, width int) string {
+	if w := StringWidth(s); w < width {
+		return s + strings.Repeat(" ", width-w)
+	}
+	return s
+}
+
+// WrapWidth breaks a single line of text into lines of at most width
+// cells, at spaces where possible and between clusters within words too
+// long for a line. Spaces at a break are dropped.
+func WrapWidth(s string, width int) []string {
+	if width <= 0 || StringWidth(s) <= width {
+		return []string{s}
+	}
+	var lines []string
+	var line strings.Builder
+	lineW := 0
+	breakLine := func() {
+		lines = append(lines, strings.TrimRight(line.String(), " "))
+		line.Reset()
+		lineW = 0
+	}
+	for _, word := range splitWords(s) {
+		ww := StringWidth(word)
+		if word[0] == ' ' {
+			if lineW > 0 && lineW+ww <= width {
+				line.WriteString(word)
+				lineW += ww
+			} else if lineW > 0 {
+				breakLine()
+			}
+			continue
+		}
+		if lineW > 0 && lineW+ww > width {
+			breakLine()
+		}
+		for len(word) > 0 {
+			n, cw := nextGrapheme(word)
+			if lineW > 0 && lineW+cw > width {
+				breakLine()
+			}
+			line.WriteString(word[:n])
+			lineW += cw
+			word = word[n:]
+		}
+	}
+	if lineW > 0 || len(lines) == 0 {
+		breakLine()
+	}
+	return lines
+}
+
+// TextOverflow values for the textOverflow prop.
+const (
+	OverflowClip     = "clip"
+	OverflowWrap     = "wrap"
+	OverflowEllipsis = "ellipsis"
+)
+
+// fitLines splits text content into the lines shown in a node cols cells
+// wide under an overflow mode: wrapped, the first line truncated with an
+// ellipsis (which also marks dropped lines), or unchanged for clip, which
+// the drawing clip cuts. cols <= 0 means unlimited.
+func fitLines(content, mode string, cols int) []string {
+	lines := strings.Split(content, "\n")
+	switch mode {
+	case OverflowWrap:
+		if cols <= 0 {
+			return lines
+		}
+		var out []string
+		for _, line := range lines {
+			out = append(out, WrapWidth(line, cols)...)
+		}
+		return out
+	case OverflowEllipsis:
+		line := lines[0]
+		if len(lines) > 1 {
+			line += "…"
+		}
+		if cols > 0 {
+			line = TruncateWidth(line, cols, "…")
+		}
+		return []string{line}
+	}
+	return lines
+}
+
+// splitWords splits s into alternating runs of spaces and non-spaces.
+func splitWords(s string) []string {
+	var words []string
+	start := 0
+	for i := 1; i <= len(s); i++ {
+		if i == len(s) || (s[i] == ' ') != (s[start] == ' ') {
+			words = append(words, s[start:i])
+			start = i
+		}
+	}
+	return words
+}
+
+// nextGrapheme returns the byte length and display width of the cluster
+// at the start of s.
+func nextGrapheme(s string) (n, width int) {
+	base, n := utf8.DecodeRuneInString(s)
+	width = RuneWidth(base)
+	regional := isRegionalIndicator(base)
+	joined := false
+	for n < len(s) {
+		r, size := utf8.DecodeRuneInString(s[n:])
+		switch {
+		case joined:
+			// The character after a ZWJ joins the cluster.
+			joined = false
+		case r == 0x200d:
+			joined = true
+		case r == 0xfe0f:
+			if width == 1 {
+				width = 2
+			}
+		case regional && isRegionalIndicator(r):
+			regional = false
+		case zeroWidth(r) || isEmojiModifier(r):
+		default:
+			return n, width
+		}
+		n += size
+	}
+	return n, width
+}
+
+// zeroWidth reports whether r combines with or formats the preceding
+// character rather than taking a cell of its own.
+func zeroWidth(r rune) bool {
+	return unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf) ||
+		(r >= 0x1160 && r <= 0x11ff) || // Hangul medial vowels and final consonants
+		(r >= 0xe0100 && r <= 0xe01ef) // variation selectors supplement
+}
+
+func isRegionalIndicator(r rune) bool { return r >= 0x1f1e6 && r <= 0x1f1ff }
+
+func isEmojiModifier(r rune) bool { return r >= 0x1f3fb && r <= 0x1f3ff }
+
+// inTable reports whether r falls in one of the sorted, inclusive ranges.
+func inTable(r rune, table [][2]rune) bool {
+	lo, hi := 0, len(table)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		switch {
+		case r < table[mid][0]:
+			hi = mid
+		case r > table[mid][1]:
+			lo = mid + 1
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+// wideRanges are the East Asian Wide and Fullwidth characters, including
+// emoji with default emoji presentation (Unicode 15), and regional
+// indicators.
+var wideRanges = [][2]rune{
+	{0x1100, 0x115f}, {0x231a, 0x231b}, {0x2329, 0x232a}, {0x23e9, 0x23ec},
+	{0x23f0, 0x23f0}, {0x23f3, 0x23f3}, {0x25fd, 0x25fe}, {0x2614, 0x2615},
+	{0x2648, 0x2653}, {0x267f, 0x267f}, {0x2693, 0x2693}, {0x26a1, 0x26a1},
+	{0x26aa, 0x26ab}, {0x26bd, 0x26be}, {0x26c4, 0x26c5}, {0x26ce, 0x26ce},
+	{0x26d4, 0x26d4}, {0x26ea, 0x26ea}, {0x26f2, 0x26f3}, {0x26f5, 0x26f5},
+	{0x26fa, 0x26fa}, {0x26fd, 0x26fd}, {0x2705, 0x2705}, {0x270a, 0x270b},
+	{0x2728, 0x2728}, {0x274c, 0x274c}, {0x274e, 0x274e}, {0x2753, 0x2755},
+	{0x2757, 0x2757}, {0x2795, 0x2797}, {0x27b0, 0x27b0}, {0x27bf, 0x27bf},
+	{0x2b1b, 0x2b1c}, {0x2b50, 0x2b50}, {0x2b55, 0x2b55}, {0x2e80, 0x303e},
+	{0x3041, 0x33ff}, {0x3400, 0x4dbf}, {0x4e00, 0x9fff}, {0xa000, 0xa4cf},
+	{0xa960, 0xa97f}, {0xac00, 0xd7a3}, {0xf900, 0xfaff}, {0xfe10, 0xfe19},
+	{0xfe30, 0xfe6f}, {0xff00, 0xff60}, {0xffe0, 0xffe6}, {0x16fe0, 0x16fe4},
+	{0x17000, 0x18aff}, {0x1b000, 0x1b2ff}, {0x1f004, 0x1f004}, {0x1f0cf, 0x1f0cf},
+	{0x1f18e, 0x1f18e}, {0x1f191, 0x1f19a}, {0x1f1e6, 0x1f202}, {0x1f210, 0x1f23b},
+	{0x1f240, 0x1f248}, {0x1f250, 0x1f251}, {0x1f260, 0x1f265}, {0x1f300, 0x1f320},
+	{0x1f32d, 0x1f335}, {0x1f337, 0x1f37c}, {0x1f37e, 0x1f393}, {0x1f3a0, 0x1f3ca},
+	{0x1f3cf, 0x1f3d3}, {0x1f3e0, 0x1f3f0}, {0x1f3f4, 0x1f3f4}, {0x1f3f8, 0x1f43e},
+	{0x1f440, 0x1f440}, {0x1f442, 0x1f4fc}, {0x1f4ff, 0x1f53d}, {0x1f54b, 0x1f54e},
+	{0x1f550, 0x1f567}, {0x1f57a, 0x1f57a}, {0x1f595, 0x1f596}, {0x1f5a4, 0x1f5a4},
+	{0x1f5fb, 0x1f64f}, {0x1f680, 0x1f6c5}, {0x1f6cc, 0x1f6cc}, {0x1f6d0, 0x1f6d2},
+	{0x1f6d5, 0x1f6d7}, {0x1f6dc, 0x1f6df}, {0x1f6eb, 0x1f6ec}, {0x1f6f4, 0x1f6fc},
+	{0x1f7e0, 0x1f7eb}, {0x1f7f0, 0x1f7f0}, {0x1f90c, 0x1f93a}, {0x1f93c, 0x1f945},
+	{0x1f947, 0x1f9ff}, {0x1fa70, 0x1faff}, {0x20000, 0x2fffd}, {0x30000, 0x3fffd},
+}
@@ -13,6 +13,15 @@ const (
 	HeaderSize      = 8
 	Magic           = 0x5650 // ASCII 'VP'
 	ProtocolVersion = 1
+
+	// Version 2 extends the header with session ID and sequence number
+	// at bytes 8–23; bytes 0–7 are unchanged.
+	SessionHeaderSize      = 24
+	SessionProtocolVersion = 2
+
+	// SessionNone is the reserved session ID for frames without session
+	// context.
+	SessionNone uint64 = 0
 )
 
 // Errors returned by wire format functions.
@@ -20,6 +29,8 @@ var (
 	ErrBufferTooShort = errors.New("buffer too short for frame header")
 	ErrBadMagic       = errors.New("invalid magic bytes in frame header")
 	ErrPayloadTooShort = errors.New("buffer too short for complete frame")
+	ErrFrameTooLarge   = errors.New("frame exceeds size limit")
+	ErrUnsupportedVersion = errors.New("unsupported frame header version")
 )
 
 // EncodeHeader writes an 8-byte frame header for the given message type
@@ -44,8 +55,27 @@ func EncodeHeader(msgType MessageType, payloadLength uint32) []byte {
 	return buf
 }
 
-// DecodeHeader parses an 8-byte frame header from data.
-// Returns an error if the buffer is too short or the magic bytes don't match.
+// EncodeSessionHeader writes a 24-byte version 2 frame header carrying a
+// session ID and sequence number.
+//
+// Wire layout:
+//
+//	[0:8]   as EncodeHeader, with version 2
+//	[8:16]  session (little-endian uint64)
+//	[16:24] seq     (little-endian uint64)
+func EncodeSessionHeader(msgType MessageType, payloadLength uint32, session, seq uint64) []byte {
+	buf := make([]byte, SessionHeaderSize)
+	copy(buf, EncodeHeader(msgType, payloadLength))
+	buf[2] = SessionProtocolVersion
+	binary.LittleEndian.PutUint64(buf[8:16], session)
+	binary.LittleEndian.PutUint64(buf[16:24], seq)
+	return buf
+}
+
+// DecodeHeader parses a frame header from data: 8 bytes for version 1,
+// 24 bytes for version 2 session frames.
+// Returns an error if the buffer is too short, the magic bytes don't
+// match, or the version is neither of those.
 func DecodeHeader(data []byte) (*FrameHeader, error) {
 	if len(data) < HeaderSize {
 		return nil, ErrBufferTooShort
@@ -56,12 +86,24 @@ func DecodeHeader(data []byte) (*FrameHeader, error) {
 		return nil, ErrBadMagic
 	}
 
-	return &FrameHeader{
+	header := &FrameHeader{
 		Magic:   magic,
 		Version: data[2],
 		Type:    MessageType(data[3]),
 		Length:  binary.LittleEndian.Uint32(data[4:8]),
-	}, nil
+	}
+	switch header.Version {
+	case ProtocolVersion:
+	case SessionProtocolVersion:
+		if len(data) < SessionHeaderSize {
+			return nil, ErrBufferTooShort
+		}
+		header.Session = binary.LittleEndian.Uint64(data[8:16])
+		header.Seq = binary.LittleEndian.Uint64(data[16:24])
+	default:
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, header.Version)
+	}
+	return header, nil
 }
 
 // EncodeFrame encodes a protocol message into a complete frame
@@ -79,6 +121,56 @@ func EncodeFrame(msg *ProtocolMessage) ([]byte, error) {
 	return frame, nil
 }
 
+// NewBatch wraps msgs in a single MsgBatch so they can be sent as one
+// frame. The batch takes the highest seq among msgs (SourceState.Flush
+// puts it on the last message) and the contained copies carry none. A
+// single message is returned unwrapped.
+func NewBatch(msgs []ProtocolMessage) ProtocolMessage {
+	if len(msgs) == 1 {
+		return msgs[0]
+	}
+	batch := ProtocolMessage{Type: MsgBatch, Messages: make([]ProtocolMessage, len(msgs))}
+	for i, msg := range msgs {
+		if msg.Seq != nil && (batch.Seq == nil || *msg.Seq > *batch.Seq) {
+			batch.Seq = msg.Seq
+		}
+		msg.Seq = nil
+		batch.Messages[i] = msg
+	}
+	return batch
+}
+
+// EncodeFrames encodes msgs as consecutive frames in one buffer, so they
+// can be written with a single call. Unlike NewBatch this works with any
+// viewer, but every message keeps its own header.
+func EncodeFrames(msgs []ProtocolMessage) ([]byte, error) {
+	var buf []byte
+	for i := range msgs {
+		frame, err := EncodeFrame(&msgs[i])
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, frame...)
+	}
+	return buf, nil
+}
+
+// EncodeSessionFrame encodes a protocol message into a version 2 frame
+// addressed to session. The header seq is taken from msg.Seq (0 if unset).
+func EncodeSessionFrame(msg *ProtocolMessage, session uint64) ([]byte, error) {
+	payload, err := encodeCBORPayload(msg)
+	if err != nil {
+		return nil, fmt.Errorf("cbor encode: %w", err)
+	}
+
+	var seq uint64
+	if msg.Seq != nil {
+		seq = *msg.Seq
+	}
+	header := EncodeSessionHeader(msg.Type, uint32(len(payload)), session, seq)
+	return append(header, payload...), nil
+}
+
 // DecodeFrame splits a complete frame into header and decoded message.
 // The data must contain at least header + payload bytes.
 func DecodeFrame(data []byte) (*FrameHeader, []byte, error) {
@@ -87,12 +179,12 @@ func DecodeFrame(data []byte) (*FrameHeader, []byte, error) {
 		return nil, nil, err
 	}
 
-	totalSize := HeaderSize + int(header.Length)
+	totalSize := header.Size() + int(header.Length)
 	if len(data) < totalSize {
 		return nil, nil, ErrPayloadTooShort
 	}
 
-	payload := data[HeaderSize:totalSize]
+	payload := data[header.Size():totalSize]
 	return header, payload, nil
 }
 
@@ -105,11 +197,189 @@ func DecodeCBORPayload(payload []byte) (map[string]interface{}, error) {
 	return result, nil
 }
 
+// DecodeMessage decodes a frame's CBOR payload into a ProtocolMessage.
+// The message type is taken from the frame header, as is the sequence
+// number for version 2 frames that carry one.
+func DecodeMessage(header *FrameHeader, payload []byte) (ProtocolMessage, error) {
+	var w wireMessage
+	if err := cbor.Unmarshal(payload, &w); err != nil {
+		return ProtocolMessage{}, fmt.Errorf("cbor unmarshal: %w", err)
+	}
+
+	msg := ProtocolMessage{
+		Type:      header.Type,
+		Seq:       w.Seq,
+		Echo:      w.Echo,
+		Sent:      w.Sent,
+		Ack:       w.Ack,
+		Credit:    w.Credit,
+		Hash:      w.Hash,
+		Nonce:     w.Nonce,
+		Error:     w.Error,
+		Metrics:   w.Metrics,
+		Notify:    w.Notify,
+		Clipboard: w.Clipboard,
+		Chunk:     w.Chunk,
+		Requires:  w.Requires,
+		Slot:      w.Slot,
+		Root:      w.Root,
+		Ops:       w.Ops,
+		Schema:    w.Schema,
+		Row:       w.Row,
+		Event:     w.Event,
+		Env:       w.Env,
+		Columns:   w.Columns,
+	}
+	if msg.Seq == nil && header.Seq != 0 {
+		seq := header.Seq
+		msg.Seq = &seq
+	}
+	if len(w.Value) > 0 {
+		value, err := decodeSlotValue(w.Value)
+		if err != nil {
+			return ProtocolMessage{}, err
+		}
+		msg.SlotValue = value
+	}
+	if header.Type == MsgBatch {
+		messages, err := decodeBatch(w.Messages)
+		if err != nil {
+			return ProtocolMessage{}, err
+		}
+		msg.Messages = messages
+	}
+	return msg, nil
+}
+
+// ErrNestedBatch is returned when a MsgBatch contains another batch.
+var ErrNestedBatch = errors.New("nested batch")
+
+// decodeBatch decodes the messages of a MsgBatch, each a CBOR map
+// carrying its own "type".
+func decodeBatch(raw []cbor.RawMessage) ([]ProtocolMessage, error) {
+	messages := make([]ProtocolMessage, 0, len(raw))
+	for i, r := range raw {
+		var head struct {
+			Type MessageType `cbor:"type"`
+		}
+		if err := cbor.Unmarshal(r, &head); err != nil {
+			return nil, fmt.Errorf("batch message %d: cbor unmarshal: %w", i, err)
+		}
+		if head.Type == MsgBatch {
+			return nil, fmt.Errorf("batch message %d: %w", i, ErrNestedBatch)
+		}
+		msg, err := DecodeMessage(&FrameHeader{Type: head.Type}, r)
+		if err != nil {
+			return nil, fmt.Errorf("batch message %d: %w", i, err)
+		}
+		msg.Seq = nil
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
+
+// wireMessage mirrors ProtocolMessage for decoding, deferring the slot
+// value (an interface) until its kind is known.
+type wireMessage struct {
+	Seq       *uint64            `cbor:"seq"`
+	Echo      *uint64            `cbor:"echo"`
+	Sent      *int64             `cbor:"sent"`
+	Ack       *uint64            `cbor:"ack"`
+	Credit    *int               `cbor:"credit"`
+	Hash      *uint64            `cbor:"hash"`
+	Nonce     *uint64            `cbor:"nonce"`
+	Error     *ErrorReport       `cbor:"error"`
+	Metrics   *ViewerMetrics     `cbor:"metrics"`
+	Notify    *Notification      `cbor:"notify"`
+	Clipboard *ClipboardTransfer `cbor:"clipboard"`
+	Chunk     *FileChunk         `cbor:"chunk"`
+	Requires  *Requirements      `cbor:"requires"`
+	Slot      *int               `cbor:"slot"`
+	Value     cbor.RawMessage    `cbor:"value"`
+	Root      *VNode             `cbor:"root"`
+	Ops       []PatchOp          `cbor:"ops"`
+	Schema    *int               `cbor:"schema"`
+	Row       []interface{}      `cbor:"row"`
+	Event     *InputEvent        `cbor:"event"`
+	Env       *EnvInfo           `cbor:"env"`
+	Columns   []SchemaColumn     `cbor:"columns"`
+	Messages  []cbor.RawMessage  `cbor:"messages"`
+}
+
+// decodeSlotValue decodes a slot definition into the concrete SlotValue
+// type for its kind, falling back to GenericSlot.
+func decodeSlotValue(raw []byte) (SlotValue, error) {
+	var head struct {
+		Kind string `cbor:"kind"`
+	}
+	if err := cbor.Unmarshal(raw, &head); err != nil {
+		return nil, fmt.Errorf("slot value: %w", err)
+	}
+
+	var value SlotValue
+	var err error
+	switch head.Kind {
+	case "style":
+		var sv StyleSlot
+		err = cbor.Unmarshal(raw, &sv)
+		value = sv
+	case "color":
+		var sv ColorSlot
+		err = cbor.Unmarshal(raw, &sv)
+		value = sv
+	case "keybind":
+		var sv KeybindSlot
+		err = cbor.Unmarshal(raw, &sv)
+		value = sv
+	case "transition":
+		var sv TransitionSlot
+		err = cbor.Unmarshal(raw, &sv)
+		value = sv
+	case "text_size":
+		var sv TextSizeSlot
+		err = cbor.Unmarshal(raw, &sv)
+		value = sv
+	case "schema":
+		var sv SchemaSlot
+		err = cbor.Unmarshal(raw, &sv)
+		value = sv
+	case "row_template":
+		var sv RowTemplateSlot
+		err = cbor.Unmarshal(raw, &sv)
+		value = sv
+	case "sound":
+		var sv SoundSlot
+		err = cbor.Unmarshal(raw, &sv)
+		value = sv
+	default:
+		var sv GenericSlot
+		err = cbor.Unmarshal(raw, &sv)
+		value = sv
+	}
+	if err != nil {
+		return nil, fmt.Errorf("slot value %q: %w", head.Kind, err)
+	}
+	return value, nil
+}
+
 // encodeCBORPayload encodes a protocol message to CBOR bytes.
 func encodeCBORPayload(msg *ProtocolMessage) ([]byte, error) {
-	// Build a generic map for CBOR encoding
+	return cbor.Marshal(encodeMessageMap(msg))
+}
+
+// encodeMessageMap builds the generic map a message is CBOR-encoded as.
+func encodeMessageMap(msg *ProtocolMessage) map[string]interface{} {
 	m := make(map[string]interface{})
 	m["type"] = uint8(msg.Type)
+	if msg.Seq != nil {
+		m["seq"] = *msg.Seq
+	}
+	if msg.Echo != nil {
+		m["echo"] = *msg.Echo
+	}
+	if msg.Sent != nil {
+		m["sent"] = *msg.Sent
+	}
 
 	switch msg.Type {
 	case MsgDefine:
@@ -143,9 +413,62 @@ func encodeCBORPayload(msg *ProtocolMessage) ([]byte, error) {
 			m["slot"] = *msg.Slot
 		}
 		m["columns"] = msg.Columns
+	case MsgAudio:
+		if msg.Slot != nil {
+			m["slot"] = *msg.Slot
+		}
+	case MsgNotify:
+		if msg.Notify != nil {
+			m["notify"] = msg.Notify
+		}
+	case MsgFile:
+		if msg.Chunk != nil {
+			m["chunk"] = msg.Chunk
+		}
+	case MsgClipboard:
+		if msg.Nonce != nil {
+			m["nonce"] = *msg.Nonce
+		}
+		if msg.Clipboard != nil {
+			m["clipboard"] = msg.Clipboard
+		}
+	case MsgAck, MsgResync, MsgRefresh:
+		if msg.Ack != nil {
+			m["ack"] = *msg.Ack
+		}
+	case MsgCredit:
+		if msg.Credit != nil {
+			m["credit"] = *msg.Credit
+		}
+	case MsgHash:
+		if msg.Hash != nil {
+			m["hash"] = *msg.Hash
+		}
+	case MsgPing, MsgPong:
+		if msg.Nonce != nil {
+			m["nonce"] = *msg.Nonce
+		}
+	case MsgError:
+		if msg.Error != nil {
+			m["error"] = msg.Error
+		}
+	case MsgMetrics:
+		if msg.Metrics != nil {
+			m["metrics"] = msg.Metrics
+		}
+	case MsgRequire:
+		if msg.Requires != nil {
+			m["requires"] = msg.Requires
+		}
+	case MsgBatch:
+		messages := make([]interface{}, len(msg.Messages))
+		for i := range msg.Messages {
+			messages[i] = encodeMessageMap(&msg.Messages[i])
+		}
+		m["messages"] = messages
 	}
 
-	return cbor.Marshal(m)
+	return m
 }
 
 // encodeVNode converts a VNode to a map suitable for CBOR encoding.
@@ -179,6 +502,11 @@ type Frame struct {
 // FrameReader is a streaming parser that buffers incoming bytes and
 // extracts complete frames. It handles partial reads.
 type FrameReader struct {
+	// MaxFrameBytes, if positive, caps the size of a frame, header
+	// included. Feed fails with ErrFrameTooLarge as soon as the header of
+	// a larger frame arrives, instead of buffering the frame.
+	MaxFrameBytes int
+
 	buffer []byte
 }
 
@@ -204,16 +532,22 @@ func (fr *FrameReader) Feed(data []byte) ([]Frame, error) {
 				fr.buffer = fr.buffer[1:]
 				continue
 			}
+			if errors.Is(err, ErrBufferTooShort) {
+				break // extended header not fully buffered yet
+			}
 			return frames, err
 		}
 
-		totalSize := HeaderSize + int(header.Length)
+		totalSize := header.Size() + int(header.Length)
+		if fr.MaxFrameBytes > 0 && totalSize > fr.MaxFrameBytes {
+			return frames, fmt.Errorf("%w: %d bytes, limit %d", ErrFrameTooLarge, totalSize, fr.MaxFrameBytes)
+		}
 		if len(fr.buffer) < totalSize {
 			break // need more data
 		}
 
 		payload := make([]byte, header.Length)
-		copy(payload, fr.buffer[HeaderSize:totalSize])
+		copy(payload, fr.buffer[header.Size():totalSize])
 		frames = append(frames, Frame{Header: header, Payload: payload})
 		fr.buffer = fr.buffer[totalSize:]
 	}